@@ -0,0 +1,98 @@
+// Package main runs the whereabouts allocation API server, aggregated to the Kubernetes API server
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/allocationapi"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/daemon"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+const (
+	defaultListenAddress = ":8443"
+	shutdownTimeout      = 30 * time.Second
+)
+
+const (
+	_ int = iota
+	missingTLSFiles
+	couldNotCreateClient
+	couldNotLoadRequestHeader
+	serverFailure
+)
+
+func main() {
+	kubeConfigFile := flag.String("kubeconfig", "", "Specify the path to the Kubernetes configuration file; uses the in-cluster configuration when empty")
+	namespace := flag.String("namespace", whereaboutsNamespace(), "Specify the namespace of the whereabouts resources")
+	listenAddress := flag.String("listen-address", defaultListenAddress, "Specify the address serving the allocation API")
+	tlsCertFile := flag.String("tls-cert-file", "", "Specify the file holding the TLS certificate the aggregation layer calls the allocation API with")
+	tlsKeyFile := flag.String("tls-key-file", "", "Specify the file holding the private key of the TLS certificate")
+	flatConfigPath := flag.String("config", "", "Specify the path of the whereabouts flat configuration file merged with the network configurations of the requests; uses the default locations when empty")
+	logLevel := flag.String("log-level", "error", "Specify the logging level; network configurations setting log_level override it")
+	qps := flag.Float64("qps", 0, "Specify the maximum queries per second the server issues to the API server; uses the client-go default when 0")
+	burst := flag.Int("burst", 0, "Specify the maximum burst of queries the server issues to the API server; uses the client-go default when 0")
+	maxConcurrentAllocations := flag.Int("max-concurrent-allocations", 0, "Specify the maximum requests the server serves concurrently on each IP pool; unlimited when 0")
+	flag.Parse()
+
+	logging.SetLogLevel(*logLevel)
+	logging.SetLogStderr(true)
+
+	if *tlsCertFile == "" || *tlsKeyFile == "" {
+		_ = logging.Errorf("the allocation API is served over TLS: -tls-cert-file and -tls-key-file are required")
+		os.Exit(missingTLSFiles)
+	}
+
+	client, err := kubernetes.NewClientWithUserAgent(*kubeConfigFile, kubernetes.AllocationUserAgent, kubernetes.RateLimit{QPS: float32(*qps), Burst: *burst})
+	if err != nil {
+		_ = logging.Errorf("failed to create the Kubernetes client: %v", err)
+		os.Exit(couldNotCreateClient)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	requestHeader, err := allocationapi.LoadRequestHeaderConfig(ctx, client)
+	if err != nil {
+		_ = logging.Errorf("failed to load the authentication configuration of the aggregation layer: %v", err)
+		os.Exit(couldNotLoadRequestHeader)
+	}
+
+	daemonServer := daemon.NewServer(client, *namespace)
+	daemonServer.LimitConcurrentRequests(*maxConcurrentAllocations)
+	if *flatConfigPath != "" {
+		daemonServer.LookForFlatConfig(*flatConfigPath)
+	}
+	server := allocationapi.NewServer(daemonServer, client, *requestHeader).NewHTTPServer(*listenAddress)
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		// let the in-flight allocations complete
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			_ = logging.Errorf("failed to shut down the allocation API server: %v", err)
+		}
+	}()
+
+	logging.Verbosef("serving the %s API on %s", allocationapi.GroupName, *listenAddress)
+	if err := server.ListenAndServeTLS(*tlsCertFile, *tlsKeyFile); err != nil && err != http.ErrServerClosed {
+		_ = logging.Errorf("allocation API server failure: %v", err)
+		os.Exit(serverFailure)
+	}
+	<-shutdownDone
+}
+
+func whereaboutsNamespace() string {
+	if namespace, found := os.LookupEnv("WHEREABOUTS_NAMESPACE"); found {
+		return namespace
+	}
+	return "kube-system"
+}
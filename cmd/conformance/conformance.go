@@ -0,0 +1,108 @@
+// Package main runs the whereabouts conformance scenarios against a live cluster
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+
+	wbtestclient "github.com/k8snetworkplumbingwg/whereabouts/e2e/client"
+	"github.com/k8snetworkplumbingwg/whereabouts/e2e/conformance"
+)
+
+const suiteName = "whereabouts-conformance"
+
+const (
+	_ int = iota
+	invalidArguments
+	couldNotConnectToCluster
+	couldNotWriteReport
+	conformanceFailed
+)
+
+func main() {
+	kubeconfigPath := flag.String("kubeconfig", os.Getenv("KUBECONFIG"), "Specify the path to the kubeconfig of the cluster under test")
+	namespace := flag.String("namespace", "default", "Specify the namespace in which the test workloads are created")
+	ipPoolNamespace := flag.String("ip-pool-namespace", "kube-system", "Specify the namespace of the whereabouts custom resources")
+	ipRange := flag.String("range", "10.10.0.0/16", "Specify the IP range used by the test networks")
+	focus := flag.String("focus", "", "Only run the scenarios whose name matches this regular expression")
+	junitPath := flag.String("junit", "", "Write a JUnit XML report to this path")
+	timeout := flag.Duration("timeout", time.Hour, "Specify the maximum duration of the conformance run")
+	flag.Parse()
+
+	var focusRegexp *regexp.Regexp
+	if *focus != "" {
+		var err error
+		focusRegexp, err = regexp.Compile(*focus)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid focus expression: %v\n", err)
+			os.Exit(invalidArguments)
+		}
+	}
+
+	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfigPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load the cluster configuration: %v\n", err)
+		os.Exit(couldNotConnectToCluster)
+	}
+	clientInfo, err := wbtestclient.NewClientInfo(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create the cluster clients: %v\n", err)
+		os.Exit(couldNotConnectToCluster)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	results := conformance.Run(ctx, &conformance.Environment{
+		ClientInfo:      clientInfo,
+		Namespace:       *namespace,
+		IPPoolNamespace: *ipPoolNamespace,
+		IPRange:         *ipRange,
+	}, conformance.Scenarios(), focusRegexp)
+	cancel()
+
+	failed := printResults(results)
+
+	if *junitPath != "" {
+		if err := writeJUnitReport(*junitPath, results); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write the JUnit report: %v\n", err)
+			os.Exit(couldNotWriteReport)
+		}
+	}
+
+	if failed {
+		os.Exit(conformanceFailed)
+	}
+}
+
+func printResults(results []conformance.Result) bool {
+	var failed bool
+	for _, result := range results {
+		switch {
+		case result.Skipped:
+			fmt.Printf("SKIP %s\n", result.Name)
+		case result.Err != nil:
+			failed = true
+			fmt.Printf("FAIL %s (%s): %v\n", result.Name, result.Duration.Round(time.Millisecond), result.Err)
+		default:
+			fmt.Printf("PASS %s (%s)\n", result.Name, result.Duration.Round(time.Millisecond))
+		}
+	}
+	return failed
+}
+
+func writeJUnitReport(path string, results []conformance.Result) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := conformance.WriteJUnit(f, suiteName, results); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
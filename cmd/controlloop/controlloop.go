@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"time"
@@ -10,6 +12,8 @@ import (
 	"github.com/fsnotify/fsnotify"
 	"github.com/go-co-op/gocron/v2"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
@@ -21,15 +25,22 @@ import (
 
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/controlloop"
 	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	wbscheme "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/scheme"
 	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/profiling"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/reconciler"
+	reconcilerloop "github.com/k8snetworkplumbingwg/whereabouts/pkg/reconciler/controlloop"
+	wbstorage "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
 )
 
 const (
 	allNamespaces               = ""
 	controllerName              = "pod-ip-controlloop"
 	reconcilerCronConfiguration = "/cron-schedule/config"
+	capacityReadHeaderTimeout   = 5 * time.Second
+	healthReadHeaderTimeout     = 5 * time.Second
 )
 
 const (
@@ -38,6 +49,10 @@ const (
 	cronSchedulerCreationError
 	fileWatcherError
 	couldNotCreateConfigWatcherError
+	couldNotCreateProfilingServer
+	invalidMetricsTLS
+	invalidStartupReconcileMode
+	invalidScaleToZeroSelector
 )
 
 const (
@@ -46,26 +61,255 @@ const (
 
 func main() {
 	logLevel := flag.String("log-level", defaultLogLevel, "Specify the pod controller application logging level")
+	workers := flag.Int("workers", controlloop.DefaultWorkers, "Specify the number of workers garbage collecting the IPs of deleted pods")
+	cleanupDeadNodes := flag.Bool("cleanup-dead-nodes", false, "Elect one control loop instance to garbage collect the IPs of pods whose node no longer exists")
+	cleanupDeletedNamespaces := flag.Bool("cleanup-deleted-namespaces", false, "Elect one control loop instance to garbage collect the IPs of pods whose namespace was deleted")
+	reconcilerQPS := flag.Float64("reconciler-qps", 0, "Specify the maximum queries per second issued by the dedicated client of the IP reconciler; uses the client-go default when 0")
+	reconcilerBurst := flag.Int("reconciler-burst", 0, "Specify the maximum burst of queries issued by the dedicated client of the IP reconciler; uses the client-go default when 0")
+	whereaboutsConfig := flag.String("whereabouts-config", "", "Specify the name of the WhereaboutsConfig whose schedule, concurrency and dry-run settings the IP reconciler runs follow, overriding the cron schedule; disabled when empty")
+	sandboxGCGracePeriod := flag.Duration("sandbox-gc-grace-period", 0, "Specify how long the sandbox of an allocation must be missing from the container runtime before its IP is released; disabled when 0")
+	sandboxGCInterval := flag.Duration("sandbox-gc-interval", controlloop.DefaultSandboxGCInterval, "Specify the period between two collections of the IPs of vanished sandboxes")
+	crictlPath := flag.String("crictl-path", "crictl", "Specify the path of the crictl binary listing the pod sandboxes of the container runtime")
+	criEndpoint := flag.String("cri-endpoint", "", "Specify the endpoint of the container runtime; uses the crictl configuration when empty")
+	capacityListenAddress := flag.String("capacity-listen-address", "", "Specify the address serving the node slice capacity queries, e.g. :9091; disabled when empty")
+	startupReconcile := flag.String("startup-reconcile", controlloop.StartupReconcileOff, "Specify whether to crosswalk the IP pools and the network-status of the pods on start: \"off\", \"report\" the inconsistencies, or \"fix\" them")
+	pprofAddress := flag.String("pprof-address", "", "Specify the loopback address serving the pprof and runtime debug endpoints, e.g. 127.0.0.1:6060; disabled when empty")
+	healthAddress := flag.String("health-address", "", "Specify the address serving the liveness and readiness probes of the pod controller under /healthz and /readyz, e.g. :8081; disabled when empty")
+	stallTimeout := flag.Duration("stall-timeout", controlloop.DefaultStallTimeout, "Specify how long the pod deletion queue may hold items without progress before the liveness probe fails")
+	metricsAddress := flag.String("metrics-address", "", "Specify the address serving the Prometheus metrics of the IP garbage collection and of the IP pools utilization under /metrics, e.g. :9122; disabled when empty")
+	metricsTLSCert := flag.String("metrics-tls-cert", "", "Specify the file holding the TLS certificate the metrics are served with; served over plain HTTP when empty")
+	metricsTLSKey := flag.String("metrics-tls-key", "", "Specify the file holding the private key of the TLS certificate of the metrics")
+	metricsClientCA := flag.String("metrics-client-ca", "", "Specify the file holding the CA bundle the client certificates scraping the metrics must be signed by; client certificates are not required when empty")
+	staleAllocations := flag.Bool("cleanup-stale-allocations", false, "Elect one control loop instance to watch the IP pools and the pods cluster-wide, releasing the IPs allocated to pods which no longer exist as soon as either changes")
+	ipRegistry := flag.Bool("ip-registry", false, "Elect one control loop instance to render the IP addresses allocated on the annotated network-attachment-definitions into ConfigMaps")
+	reservationUsage := flag.Bool("reservation-usage", false, "Elect one control loop instance to keep the number of overlapping range reservations of each network up to date in a ConfigMap")
+	floatingIPs := flag.Bool("floating-ips", false, "Elect one control loop instance to assign the floating IPs of the FloatingIPClaims to the pods holding them")
+	preReserveIPs := flag.Bool("pre-reserve-ips", false, "Elect one control loop instance to pre-reserve the IPs of the annotated pods once bound to a node, before their interfaces are added")
+	scaleToZeroSelector := flag.String("scale-to-zero-selector", "", "Specify the label selector of the ReplicaSets and StatefulSets notified with an event once scaled to zero and the IP addresses of their pods released; disabled when empty")
+	flag.Parse()
 	if logLevel != nil && logging.GetLoggingLevel().String() != *logLevel {
 		logging.SetLogLevel(*logLevel)
 	}
 	logging.SetLogStderr(true)
 
+	if *startupReconcile != controlloop.StartupReconcileOff && *startupReconcile != controlloop.StartupReconcileReport && *startupReconcile != controlloop.StartupReconcileFix {
+		_ = logging.Errorf("invalid startup reconcile mode %q; valid values are %q, %q and %q", *startupReconcile,
+			controlloop.StartupReconcileOff, controlloop.StartupReconcileReport, controlloop.StartupReconcileFix)
+		os.Exit(invalidStartupReconcileMode)
+	}
+
+	var workloadSelector labels.Selector
+	if *scaleToZeroSelector != "" {
+		var err error
+		if workloadSelector, err = labels.Parse(*scaleToZeroSelector); err != nil {
+			_ = logging.Errorf("invalid scale to zero selector %q: %v", *scaleToZeroSelector, err)
+			os.Exit(invalidScaleToZeroSelector)
+		}
+	}
+
 	stopChan := make(chan struct{})
 	errorChan := make(chan error)
 	defer close(stopChan)
 	defer close(errorChan)
 	handleSignals(stopChan, os.Interrupt)
 
-	networkController, err := newPodController(stopChan)
+	if *pprofAddress != "" {
+		profilingServer, err := profiling.NewServer(*pprofAddress)
+		if err != nil {
+			_ = logging.Errorf("could not create the profiling server: %v", err)
+			os.Exit(couldNotCreateProfilingServer)
+		}
+		go func() {
+			if err := profilingServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				_ = logging.Errorf("profiling server failure: %v", err)
+			}
+		}()
+		defer profilingServer.Close()
+	}
+
+	clients, err := newClientSets()
+	if err != nil {
+		_ = logging.Errorf("could not create the kubernetes clients: %v", err)
+		os.Exit(couldNotCreateController)
+	}
+	eventBroadcaster := newEventBroadcaster(clients.k8s)
+
+	if *startupReconcile != controlloop.StartupReconcileOff {
+		fix := *startupReconcile == controlloop.StartupReconcileFix
+		runStartupCrosswalk(clients, fix, controlloop.CrictlContainerIDResolver(*crictlPath, *criEndpoint))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go controlloop.RunOrphanCrosswalk(
+			ctx,
+			os.Getenv("NODENAME"),
+			clients.k8s,
+			clients.wb,
+			clients.nad,
+			fix)
+	}
+
+	networkController, err := newPodController(stopChan, clients, eventBroadcaster)
 	if err != nil {
 		_ = logging.Errorf("could not create the pod networks controller: %v", err)
 		os.Exit(couldNotCreateController)
 	}
 
-	networkController.Start(stopChan)
+	networkController.SetStallTimeout(*stallTimeout)
+	if *healthAddress != "" {
+		healthServer := newHealthServer(*healthAddress, networkController)
+		go func() {
+			if err := healthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				_ = logging.Errorf("health server failure: %v", err)
+			}
+		}()
+		defer healthServer.Close()
+	}
+
+	networkController.Start(stopChan, *workers)
 	defer networkController.Shutdown()
 
+	if *cleanupDeadNodes {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go controlloop.RunDeadNodeCleanup(
+			ctx,
+			os.Getenv("NODENAME"),
+			clients.k8s,
+			clients.wb,
+			clients.nad,
+			newEventRecorder(eventBroadcaster),
+			*workers)
+	}
+
+	if *cleanupDeletedNamespaces {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go controlloop.RunDeletedNamespaceCleanup(
+			ctx,
+			os.Getenv("NODENAME"),
+			clients.k8s,
+			clients.wb,
+			clients.nad)
+	}
+
+	if *staleAllocations {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go reconcilerloop.RunStaleAllocationCleanup(
+			ctx,
+			os.Getenv("NODENAME"),
+			clients.k8s,
+			clients.wb,
+			clients.nad,
+			*workers)
+	}
+
+	if *ipRegistry {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go controlloop.RunIPRegistry(
+			ctx,
+			os.Getenv("NODENAME"),
+			clients.k8s,
+			clients.wb,
+			clients.nad)
+	}
+
+	if *reservationUsage {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go controlloop.RunReservationUsage(
+			ctx,
+			os.Getenv("NODENAME"),
+			clients.k8s,
+			clients.wb,
+			clients.nad)
+	}
+
+	if *floatingIPs {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go controlloop.RunFloatingIPClaims(
+			ctx,
+			os.Getenv("NODENAME"),
+			clients.k8s,
+			clients.wb)
+	}
+
+	if *preReserveIPs {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go controlloop.RunPreReservations(
+			ctx,
+			os.Getenv("NODENAME"),
+			clients.k8s,
+			clients.wb,
+			clients.nad)
+	}
+
+	if workloadSelector != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go controlloop.RunScaleToZeroNotifier(
+			ctx,
+			os.Getenv("NODENAME"),
+			clients.k8s,
+			clients.wb,
+			newEventRecorder(eventBroadcaster),
+			workloadSelector)
+	}
+
+	if *sandboxGCGracePeriod > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		sandboxGC := controlloop.NewSandboxGarbageCollector(
+			clients.k8s,
+			clients.wb,
+			clients.nad,
+			os.Getenv("NODENAME"),
+			controlloop.CrictlSandboxLister(*crictlPath, *criEndpoint),
+			*sandboxGCGracePeriod)
+		go sandboxGC.Run(ctx, *sandboxGCInterval)
+	}
+
+	if *metricsAddress != "" {
+		metricsServer, err := metrics.Default.NewServer(*metricsAddress, metrics.TLSFiles{
+			CertFile:     *metricsTLSCert,
+			KeyFile:      *metricsTLSKey,
+			ClientCAFile: *metricsClientCA,
+		})
+		if err != nil {
+			_ = logging.Errorf("could not serve the metrics: %v", err)
+			os.Exit(invalidMetricsTLS)
+		}
+		go func() {
+			if err := metrics.ListenAndServe(metricsServer); err != nil && err != http.ErrServerClosed {
+				_ = logging.Errorf("metrics server failure: %v", err)
+			}
+		}()
+		defer metricsServer.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go controlloop.RunPoolMetrics(
+			ctx,
+			os.Getenv("NODENAME"),
+			clients.k8s,
+			clients.wb,
+			clients.nad)
+	}
+
+	if *capacityListenAddress != "" {
+		capacityServer := newCapacityServer(*capacityListenAddress, clients.wb)
+		go func() {
+			if err := capacityServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				_ = logging.Errorf("capacity server failure: %v", err)
+			}
+		}()
+		defer capacityServer.Close()
+	}
+
 	s, err := gocron.NewScheduler(gocron.WithLocation(time.UTC))
 	if err != nil {
 		os.Exit(cronSchedulerCreationError)
@@ -78,17 +322,26 @@ func main() {
 	}
 	defer watcher.Close()
 
-	reconcilerConfigWatcher, err := reconciler.NewConfigWatcher(
+	reconcilerRecorder := newEventRecorder(eventBroadcaster)
+	podListGuard := &reconciler.PodListGuard{}
+	var reconcilerConfigWatcher *reconciler.ConfigWatcher
+	reconcilerConfigWatcher, err = reconciler.NewConfigWatcher(
 		reconcilerCronConfiguration,
 		s,
 		watcher,
 		func() {
-			reconciler.ReconcileIPs(errorChan)
+			reconciler.ReconcileIPs(errorChan, wbstorage.RateLimit{QPS: float32(*reconcilerQPS), Burst: *reconcilerBurst}, reconcilerRecorder, podListGuard,
+				reconcilerConfigWatcher.RunSettings())
 		},
 	)
 	if err != nil {
 		os.Exit(couldNotCreateConfigWatcherError)
 	}
+	if *whereaboutsConfig != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		reconcilerConfigWatcher.WatchWhereaboutsConfig(ctx, clients.wb, *whereaboutsConfig)
+	}
 	s.Start()
 
 	const reconcilerConfigMntFile = "/cron-schedule/..data"
@@ -124,11 +377,18 @@ func handleSignals(stopChannel chan struct{}, signals ...os.Signal) {
 	}()
 }
 
-func newPodController(stopChannel chan struct{}) (*controlloop.PodController, error) {
+type clientSets struct {
+	k8s kubernetes.Interface
+	wb  wbclient.Interface
+	nad nadclient.Interface
+}
+
+func newClientSets() (*clientSets, error) {
 	cfg, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, fmt.Errorf("failed to implicitly generate the kubeconfig: %w", err)
 	}
+	wbstorage.ConfigureUserAgent(cfg, wbstorage.ControlLoopUserAgent, wbstorage.RateLimit{})
 
 	k8sClientSet, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
@@ -140,24 +400,26 @@ func newPodController(stopChannel chan struct{}) (*controlloop.PodController, er
 		return nil, err
 	}
 
-	eventBroadcaster := newEventBroadcaster(k8sClientSet)
-
 	wbClientSet, err := wbclient.NewForConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	return &clientSets{k8s: k8sClientSet, wb: wbClientSet, nad: nadK8sClientSet}, nil
+}
+
+func newPodController(stopChannel chan struct{}, clients *clientSets, eventBroadcaster record.EventBroadcaster) (*controlloop.PodController, error) {
 	const noResyncPeriod = 0
-	ipPoolInformerFactory := wbinformers.NewSharedInformerFactory(wbClientSet, noResyncPeriod)
-	netAttachDefInformerFactory := nadinformers.NewSharedInformerFactory(nadK8sClientSet, noResyncPeriod)
-	podInformerFactory, err := controlloop.PodInformerFactory(k8sClientSet)
+	ipPoolInformerFactory := wbinformers.NewSharedInformerFactory(clients.wb, noResyncPeriod)
+	netAttachDefInformerFactory := nadinformers.NewSharedInformerFactory(clients.nad, noResyncPeriod)
+	podInformerFactory, err := controlloop.PodInformerFactory(clients.k8s)
 	if err != nil {
 		return nil, err
 	}
 
 	controller := controlloop.NewPodController(
-		k8sClientSet,
-		wbClientSet,
+		clients.k8s,
+		clients.wb,
 		podInformerFactory,
 		ipPoolInformerFactory,
 		netAttachDefInformerFactory,
@@ -174,7 +436,41 @@ func newPodController(stopChannel chan struct{}) (*controlloop.PodController, er
 	return controller, nil
 }
 
+func runStartupCrosswalk(clients *clientSets, fix bool, resolveContainerID controlloop.ContainerIDResolver) {
+	crosswalk := controlloop.NewStartupCrosswalk(clients.k8s, clients.wb, clients.nad, os.Getenv("NODENAME"), resolveContainerID)
+	report, err := crosswalk.Run(context.Background(), fix)
+	if report != nil {
+		logging.Verbosef("startup crosswalk: %d unrecorded and %d conflicting IPs", len(report.Unrecorded), len(report.Conflicting))
+	}
+	if err != nil {
+		_ = logging.Errorf("startup crosswalk failure: %v", err)
+	}
+}
+
+func newCapacityServer(address string, wbClient wbclient.Interface) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(controlloop.CapacityPath, controlloop.NewCapacityChecker(wbClient, os.Getenv("NODENAME")))
+	return &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: capacityReadHeaderTimeout,
+	}
+}
+
+func newHealthServer(address string, controller *controlloop.PodController) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(controlloop.HealthzPath, controlloop.HealthHandler(controller.Healthy))
+	mux.Handle(controlloop.ReadyzPath, controlloop.HealthHandler(controller.Ready))
+	return &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: healthReadHeaderTimeout,
+	}
+}
+
 func newEventBroadcaster(k8sClientset kubernetes.Interface) record.EventBroadcaster {
+	// the events may refer to whereabouts objects, e.g. the cluster wide reservations deleted by the reconciler
+	utilruntime.Must(wbscheme.AddToScheme(scheme.Scheme))
 	eventBroadcaster := record.NewBroadcaster()
 	eventBroadcaster.StartLogging(logging.Verbosef)
 	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k8sClientset.CoreV1().Events(allNamespaces)})
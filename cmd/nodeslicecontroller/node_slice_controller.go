@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
+	"net/http"
 	"os"
 	"time"
 
@@ -17,14 +19,24 @@ import (
 	informers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
 	node_controller "github.com/k8snetworkplumbingwg/whereabouts/pkg/node-controller"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/node-controller/signals"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/profiling"
+	wbstorage "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
 )
 
+const apiReadHeaderTimeout = 5 * time.Second
+
 var (
-	masterURL  string
-	kubeconfig string
+	masterURL    string
+	kubeconfig   string
+	pprofAddress string
+	apiAddress   string
+
+	leaderElect   bool
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
 )
 
-// TODO: leader election
 func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
@@ -33,11 +45,26 @@ func main() {
 	ctx := signals.SetupSignalHandler()
 	logger := klog.FromContext(ctx)
 
+	if pprofAddress != "" {
+		profilingServer, err := profiling.NewServer(pprofAddress)
+		if err != nil {
+			logger.Error(err, "Error creating the profiling server")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+		go func() {
+			if err := profilingServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error(err, "Error serving the profiling endpoints")
+			}
+		}()
+		defer profilingServer.Close()
+	}
+
 	cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
 	if err != nil {
 		logger.Error(err, "Error building kubeconfig")
 		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
+	wbstorage.ConfigureUserAgent(cfg, wbstorage.NodeSliceControllerUserAgent, wbstorage.RateLimit{})
 
 	kubeClient, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
@@ -85,7 +112,38 @@ func main() {
 	whereaboutsInformerFactory.Start(ctx.Done())
 	nadInformerFactory.Start(ctx.Done())
 
-	if err = controller.Run(ctx, 1); err != nil {
+	if apiAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle(node_controller.NodeSlicesPath, node_controller.NewNodeSliceAPI(whereaboutsClient, whereaboutsNamespace))
+		apiServer := &http.Server{Addr: apiAddress, Handler: mux, ReadHeaderTimeout: apiReadHeaderTimeout}
+		go func() {
+			if err := apiServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error(err, "Error serving the node slice API")
+			}
+		}()
+		defer apiServer.Close()
+	}
+
+	if !leaderElect {
+		err = controller.Run(ctx, 1)
+	} else {
+		identity, hostnameErr := os.Hostname()
+		if hostnameErr != nil {
+			logger.Error(hostnameErr, "Error getting the leader election identity")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+		err = node_controller.RunWithLeaderElection(ctx, kubeClient, node_controller.LeaderElectionConfig{
+			LeaseName:      node_controller.LeaseName,
+			LeaseNamespace: whereaboutsNamespace,
+			Identity:       identity,
+			LeaseDuration:  leaseDuration,
+			RenewDeadline:  renewDeadline,
+			RetryPeriod:    retryPeriod,
+		}, func(leaderCtx context.Context) error {
+			return controller.Run(leaderCtx, 1)
+		})
+	}
+	if err != nil {
 		logger.Error(err, "Error running controller")
 		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
@@ -93,5 +151,11 @@ func main() {
 
 func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+	flag.StringVar(&pprofAddress, "pprof-address", "", "The loopback address serving the pprof and runtime debug endpoints, e.g. 127.0.0.1:6060. Disabled when empty.")
+	flag.StringVar(&apiAddress, "api-address", "", "The address serving the read-only node slice assignments under /v1/nodeslices, e.g. :9092. Disabled when empty.")
+	flag.BoolVar(&leaderElect, "leader-elect", false, "Elect a single replica, through the "+node_controller.LeaseName+" lease, to update the NodeSlicePools. Required when running more than one replica.")
+	flag.DurationVar(&leaseDuration, "leader-elect-lease-duration", 15*time.Second, "How long the standby replicas wait before taking over a lease the leader stopped renewing.")
+	flag.DurationVar(&renewDeadline, "leader-elect-renew-deadline", 10*time.Second, "How long the leader retries renewing its lease before giving it up. Must be shorter than the lease duration.")
+	flag.DurationVar(&retryPeriod, "leader-elect-retry-period", 2*time.Second, "The period between two attempts to acquire, or renew, the lease.")
 	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/reconciler"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// reconcilerComponent is the source of the events the reconciler emits
+const reconcilerComponent = "whereabouts-ip-reconciler"
+
+const (
+	_ int = iota
+	couldNotStartOrchestrator
+	failedToReconcile
+	invalidOutputFormat
+)
+
+func main() {
+	kubeConfigFile := flag.String("kubeconfig", "", "the path to the Kubernetes configuration file. Uses the in-cluster configuration when empty.")
+	logLevel := flag.String("log-level", "error", "the logging level for the `ip-reconciler` app. Valid values are: \"debug\", \"verbose\", \"warning\", \"error\", and \"panic\".")
+	output := flag.String("output", outputText, "the format of the reconciliation report. Valid values are: \"text\" and \"json\".")
+	reconcilerTimeout := flag.Duration("timeout", reconciler.DefaultReconcilerTimeout, "the maximum duration of the reconciliation run.")
+	maxChurnPercent := flag.Int("max-churn-percent", reconciler.DefaultMaxChurnPercent, "the maximum share of a pool's allocations, in percent, a single run may delete.")
+	minLivePods := flag.Int("min-live-pods", 0, "the minimum number of pods expected in the cluster; fewer listed pods are deemed an incomplete list, and nothing is cleaned up.")
+	allowMassDeletion := flag.Bool("allow-mass-deletion", false, "delete the orphaned allocations of a pool even above the -max-churn-percent limit.")
+	incremental := flag.Bool("incremental", false, "skip the IP pools found clean by a previous incremental run, unless they - or the pods they serve - changed since.")
+	cursorNamespace := flag.String("cursor-namespace", "kube-system", "the namespace of the config map persisting the state of incremental runs.")
+	qps := flag.Float64("qps", 0, "the maximum queries per second the reconciler issues to the API server. Uses the client-go default when 0.")
+	burst := flag.Int("burst", 0, "the maximum burst of queries the reconciler issues to the API server. Uses the client-go default when 0.")
+	metricsTextfile := flag.String("metrics-textfile", "", "the file the Prometheus metrics of the reconciler deletions are accumulated in across runs, for the node exporter textfile collector. Disabled when empty.")
+	dryRun := flag.Bool("dry-run", false, "report what would be cleaned up - as events, logs and in the reconciliation report - without updating the IP pools nor deleting the cluster wide reservations.")
+	compact := flag.Bool("compact", false, "compact the IP pools once reconciled: rewrite their allocations, dropping the tombstones - the allocations of invalid or out of range offsets - and the status entries no allocation can match.")
+	verifyReservations := flag.Bool("verify-reservations", false, "repair the overlapping range reservations once reconciled: create those missing for the IP pool allocations, and rewrite those of other pods.")
+	flag.Parse()
+
+	logging.SetLogLevel(*logLevel)
+	logging.SetLogStderr(true)
+
+	if *output != outputText && *output != outputJSON {
+		_ = logging.Errorf("invalid output format %q; valid values are %q and %q", *output, outputText, outputJSON)
+		os.Exit(invalidOutputFormat)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *reconcilerTimeout)
+	defer cancel()
+
+	rateLimit := kubernetes.RateLimit{QPS: float32(*qps), Burst: *burst}
+	var ipReconcileLoop *reconciler.ReconcileLooper
+	var err error
+	if *incremental {
+		ipReconcileLoop, err = reconciler.NewIncrementalReconcileLooper(ctx, *kubeConfigFile, *cursorNamespace, rateLimit)
+	} else {
+		ipReconcileLoop, err = reconciler.NewReconcileLooperWithKubeconfig(ctx, *kubeConfigFile, rateLimit)
+	}
+	if err != nil {
+		_ = logging.Errorf("failed to create the reconcile looper: %v", err)
+		printReport(*output, &reconciler.ReconcileReport{Errors: []string{err.Error()}})
+		cancel()
+		os.Exit(couldNotStartOrchestrator)
+	}
+
+	ipReconcileLoop.SetMinLivePods(*minLivePods)
+	ipReconcileLoop.SetDryRun(*dryRun)
+	ipReconcileLoop.SetCompaction(*compact)
+	ipReconcileLoop.SetReservationVerification(*verifyReservations)
+	stopRecordingEvents := ipReconcileLoop.RecordEvents(reconcilerComponent)
+	defer stopRecordingEvents()
+	if *allowMassDeletion {
+		ipReconcileLoop.SetMaxChurnPercent(100)
+	} else {
+		ipReconcileLoop.SetMaxChurnPercent(*maxChurnPercent)
+	}
+
+	if *metricsTextfile != "" {
+		if err := metrics.Default.RestoreTextfile(*metricsTextfile); err != nil {
+			_ = logging.Errorf("failed to restore the metrics of the previous runs: %v", err)
+		}
+	}
+
+	report, err := reconciler.InvokeIPReconciler(ctx, ipReconcileLoop)
+	printReport(*output, report)
+	if *metricsTextfile != "" {
+		if err := metrics.Default.WriteTextfile(*metricsTextfile); err != nil {
+			_ = logging.Errorf("failed to write the metrics to %s: %v", *metricsTextfile, err)
+		}
+	}
+	if err != nil {
+		stopRecordingEvents()
+		cancel()
+		os.Exit(failedToReconcile)
+	}
+}
+
+func printReport(output string, report *reconciler.ReconcileReport) {
+	if output == outputJSON {
+		reportBytes, err := json.Marshal(report)
+		if err != nil {
+			_ = logging.Errorf("failed to marshal the reconciliation report: %v", err)
+			return
+		}
+		fmt.Println(string(reportBytes))
+		return
+	}
+
+	cleanedUp, compacted, repaired := "cleaned up", "compacted", "repaired"
+	if report.DryRun {
+		cleanedUp, compacted, repaired = "would clean up", "would compact", "would repair"
+	}
+	for _, ip := range report.CleanedUpIPs {
+		fmt.Printf("%s IP address: %s\n", cleanedUp, ip)
+	}
+	for _, reservation := range report.CleanedUpOverlappingIPs {
+		fmt.Printf("%s overlapping range IP reservation: %s\n", cleanedUp, reservation)
+	}
+	for _, pool := range report.CompactedPools {
+		fmt.Printf("%s IP pool: %s\n", compacted, pool)
+	}
+	for _, reservation := range report.RepairedOverlappingIPs {
+		fmt.Printf("%s overlapping range IP reservation: %s\n", repaired, reservation)
+	}
+	for _, pool := range report.TimedOutPools {
+		fmt.Printf("timed out reconciling IP pool: %s\n", pool)
+	}
+	for _, pool := range report.ChurnProtectedPools {
+		fmt.Printf("left IP pool untouched by the churn limit: %s\n", pool)
+	}
+	for _, reconcileError := range report.Errors {
+		fmt.Printf("error: %s\n", reconcileError)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+
+	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	wbstorage "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/webhook"
+)
+
+const (
+	defaultLogLevel      = "debug"
+	defaultListenAddress = ":8443"
+	shutdownTimeout      = 10 * time.Second
+)
+
+const (
+	_ int = iota
+	missingTLSFiles
+	serverFailure
+	couldNotCreateClient
+)
+
+func main() {
+	logLevel := flag.String("log-level", defaultLogLevel, "Specify the webhook logging level")
+	listenAddress := flag.String("listen-address", defaultListenAddress, "Specify the address serving the admission reviews")
+	tlsCertFile := flag.String("tls-cert-file", "", "Specify the file holding the TLS certificate the API server calls the webhook with")
+	tlsKeyFile := flag.String("tls-key-file", "", "Specify the file holding the private key of the TLS certificate")
+	kubeconfigPath := flag.String("kubeconfig", "", "Specify the path of the kubeconfig the network-attachment-definitions are listed with; uses the in-cluster configuration when empty")
+	flatConfigPath := flag.String("config", "", "Specify the path of the whereabouts flat configuration file merged with the network-attachment-definitions; uses the default locations when empty")
+	flag.Parse()
+	if logLevel != nil && logging.GetLoggingLevel().String() != *logLevel {
+		logging.SetLogLevel(*logLevel)
+	}
+	logging.SetLogStderr(true)
+
+	if *tlsCertFile == "" || *tlsKeyFile == "" {
+		_ = logging.Errorf("the webhook is served over TLS: -tls-cert-file and -tls-key-file are required")
+		os.Exit(missingTLSFiles)
+	}
+
+	var flatConfigPaths []string
+	if *flatConfigPath != "" {
+		flatConfigPaths = append(flatConfigPaths, *flatConfigPath)
+	}
+	// the node slices are checked against those of the other network-attachment-definitions
+	cfg, err := clientcmd.BuildConfigFromFlags("", *kubeconfigPath)
+	if err != nil {
+		_ = logging.Errorf("failed to build the kubeconfig: %v", err)
+		os.Exit(couldNotCreateClient)
+	}
+	wbstorage.ConfigureUserAgent(cfg, wbstorage.WebhookUserAgent, wbstorage.RateLimit{})
+	nadClient, err := nadclient.NewForConfig(cfg)
+	if err != nil {
+		_ = logging.Errorf("failed to create the network-attachment-definition client: %v", err)
+		os.Exit(couldNotCreateClient)
+	}
+	server := webhook.NewValidatorWithNetAttachDefs(nadClient, flatConfigPaths...).NewServer(*listenAddress)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			_ = logging.Errorf("failed to shut down the webhook server: %v", err)
+		}
+	}()
+
+	logging.Verbosef("validating the network-attachment-definitions on %s%s", *listenAddress, webhook.ValidatePath)
+	if err := server.ListenAndServeTLS(*tlsCertFile, *tlsKeyFile); err != nil && err != http.ErrServerClosed {
+		_ = logging.Errorf("webhook server failure: %v", err)
+		os.Exit(serverFailure)
+	}
+}
@@ -0,0 +1,138 @@
+// Package main runs the whereabouts node daemon, serving the address allocations of the CNI plugin
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/daemon"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/platform"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+const (
+	shutdownTimeout  = 30 * time.Second
+	textfileInterval = 15 * time.Second
+)
+
+const (
+	_ int = iota
+	couldNotCreateClient
+	couldNotListen
+	serverFailure
+	invalidMetricsTLS
+)
+
+func main() {
+	kubeConfigFile := flag.String("kubeconfig", "", "Specify the path to the Kubernetes configuration file; uses the in-cluster configuration when empty")
+	namespace := flag.String("namespace", whereaboutsNamespace(), "Specify the namespace of the whereabouts resources")
+	socketPath := flag.String("socket", daemon.DefaultSocketPath, "Specify the UNIX socket the daemon listens on")
+	logLevel := flag.String("log-level", "error", "Specify the daemon logging level; the log_level of the network configurations is ignored")
+	logFormat := flag.String("log-format", logging.TextFormat, "Specify the format of the daemon log lines, either text or json; the log_format of the network configurations is ignored")
+	logFile := flag.String("log-file", "", "Specify the file the daemon logs to, on top of stderr; the log_file of the network configurations is ignored")
+	qps := flag.Float64("qps", 0, "Specify the maximum queries per second the daemon issues to the API server; uses the client-go default when 0")
+	burst := flag.Int("burst", 0, "Specify the maximum burst of queries the daemon issues to the API server; uses the client-go default when 0")
+	maxConcurrentAllocations := flag.Int("max-concurrent-allocations", 0, "Specify the maximum requests the daemon serves concurrently on each IP pool; unlimited when 0")
+	metricsAddress := flag.String("metrics-address", "", "Specify the address serving the Prometheus metrics of the allocations under /metrics, e.g. :9122; disabled when empty")
+	metricsTLSCert := flag.String("metrics-tls-cert", "", "Specify the file holding the TLS certificate the metrics are served with; served over plain HTTP when empty")
+	metricsTLSKey := flag.String("metrics-tls-key", "", "Specify the file holding the private key of the TLS certificate of the metrics")
+	metricsClientCA := flag.String("metrics-client-ca", "", "Specify the file holding the CA bundle the client certificates scraping the metrics must be signed by; client certificates are not required when empty")
+	metricsTextfile := flag.String("metrics-textfile", "", "Specify the file the Prometheus metrics of the allocations are periodically written to, for the node exporter textfile collector; disabled when empty")
+	flag.Parse()
+
+	logging.SetLogLevel(*logLevel)
+	logging.SetLogFormat(*logFormat)
+	logging.SetLogStderr(true)
+	logging.SetLogFile(*logFile)
+
+	client, err := kubernetes.NewClientWithUserAgent(*kubeConfigFile, kubernetes.AllocationUserAgent, kubernetes.RateLimit{QPS: float32(*qps), Burst: *burst})
+	if err != nil {
+		_ = logging.Errorf("failed to create the Kubernetes client: %v", err)
+		os.Exit(couldNotCreateClient)
+	}
+
+	listener, err := daemon.Listen(*socketPath)
+	if err != nil {
+		_ = logging.Errorf("could not serve the daemon: %v", err)
+		os.Exit(couldNotListen)
+	}
+
+	if *metricsAddress != "" {
+		metricsServer, err := metrics.Default.NewServer(*metricsAddress, metrics.TLSFiles{
+			CertFile:     *metricsTLSCert,
+			KeyFile:      *metricsTLSKey,
+			ClientCAFile: *metricsClientCA,
+		})
+		if err != nil {
+			_ = logging.Errorf("could not serve the metrics: %v", err)
+			os.Exit(invalidMetricsTLS)
+		}
+		go func() {
+			if err := metrics.ListenAndServe(metricsServer); err != nil && err != http.ErrServerClosed {
+				_ = logging.Errorf("metrics server failure: %v", err)
+			}
+		}()
+		defer metricsServer.Close()
+	}
+	if *metricsTextfile != "" {
+		go writeMetricsTextfile(*metricsTextfile)
+	}
+
+	informersCtx, stopInformers := context.WithCancel(context.Background())
+	defer stopInformers()
+	daemonServer := daemon.NewServer(client, *namespace)
+	daemonServer.LimitConcurrentRequests(*maxConcurrentAllocations)
+	if nodeName, err := platform.NodeName(); err != nil {
+		_ = logging.Errorf("failed to get the node name, not sharing the informers of its pods: %v", err)
+	} else if err := daemonServer.ShareInformers(informersCtx, nodeName); err != nil {
+		_ = logging.Errorf("failed to share the informers, reading the pods from the API server: %v", err)
+	}
+	server := daemonServer.NewHTTPServer()
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		// let the in-flight allocations complete
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			_ = logging.Errorf("failed to shut the daemon down: %v", err)
+		}
+	}()
+
+	logging.Verbosef("serving the whereabouts daemon on %s", *socketPath)
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		_ = logging.Errorf("daemon failure: %v", err)
+		os.Exit(serverFailure)
+	}
+	<-shutdownDone
+}
+
+// writeMetricsTextfile periodically refreshes the metrics file read by the node exporter textfile collector
+func writeMetricsTextfile(path string) {
+	ticker := time.NewTicker(textfileInterval)
+	defer ticker.Stop()
+	for {
+		if err := metrics.Default.WriteTextfile(path); err != nil {
+			_ = logging.Errorf("failed to write the metrics to %s: %v", path, err)
+		}
+		<-ticker.C
+	}
+}
+
+func whereaboutsNamespace() string {
+	if namespace, found := os.LookupEnv("WHEREABOUTS_NAMESPACE"); found {
+		return namespace
+	}
+	return "kube-system"
+}
@@ -5,112 +5,346 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sort"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	cnitypes "github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	cniversion "github.com/containernetworking/cni/pkg/version"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/allocationapi"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/config"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/daemon"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	_ "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/etcd3"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/tracing"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/version"
 )
 
 func cmdAddFunc(args *skel.CmdArgs) error {
+	setLogFields(args, "")
 	ipamConf, confVersion, err := config.LoadIPAMConfig(args.StdinData, args.Args)
 	if err != nil {
 		logging.Errorf("IPAM configuration load failed: %s", err)
 		return err
 	}
-	logging.Debugf("ADD - IPAM configuration successfully read: %+v", *ipamConf)
-	ipam, err := kubernetes.NewKubernetesIPAM(args.ContainerID, args.IfName, *ipamConf)
+	config.ConfigureLogging(ipamConf)
+	setLogFields(args, ipamConf.GetPodRef())
+	logging.Debugf("ADD - IPAM configuration successfully read: %s", logging.SanitizeConfig(ipamConf))
+	if ipamConf.DaemonSocket != "" || ipamConf.AllocationAPI {
+		return cmdAddViaDaemon(args, *ipamConf, confVersion)
+	}
+	store, err := storage.NewStore(args.ContainerID, args.IfName, *ipamConf)
 	if err != nil {
-		return logging.Errorf("failed to create Kubernetes IPAM manager: %v", err)
+		return logging.Errorf("failed to create IPAM manager: %v", err)
 	}
-	defer func() { safeCloseKubernetesBackendConnection(ipam) }()
+	defer func() { safeCloseBackendConnection(store) }()
 
 	logging.Debugf("Beginning IPAM for ContainerID: %q - podRef: %q - ifName: %q", args.ContainerID, ipamConf.GetPodRef(), args.IfName)
-	return cmdAdd(ipam, confVersion)
+	if ipam, ok := store.(*kubernetes.KubernetesIPAM); ok {
+		return cmdAdd(ipam, confVersion)
+	}
+	return cmdAddWithStore(store, args, *ipamConf, confVersion)
 }
 
 func cmdDelFunc(args *skel.CmdArgs) error {
+	setLogFields(args, "")
 	ipamConf, _, err := config.LoadIPAMConfig(args.StdinData, args.Args)
 	if err != nil {
 		logging.Errorf("IPAM configuration load failed: %s", err)
 		return err
 	}
-	logging.Debugf("DEL - IPAM configuration successfully read: %+v", *ipamConf)
+	config.ConfigureLogging(ipamConf)
+	setLogFields(args, ipamConf.GetPodRef())
+	logging.Debugf("DEL - IPAM configuration successfully read: %s", logging.SanitizeConfig(ipamConf))
+	if ipamConf.DaemonSocket != "" || ipamConf.AllocationAPI {
+		return cmdDelViaDaemon(args, *ipamConf)
+	}
 
-	ipam, err := kubernetes.NewKubernetesIPAM(args.ContainerID, args.IfName, *ipamConf)
+	store, err := storage.NewStore(args.ContainerID, args.IfName, *ipamConf)
 	if err != nil {
 		return logging.Errorf("IPAM client initialization error: %v", err)
 	}
-	defer func() { safeCloseKubernetesBackendConnection(ipam) }()
+	defer func() { safeCloseBackendConnection(store) }()
 
 	logging.Debugf("Beginning delete for ContainerID: %q - podRef: %q - ifName: %q", args.ContainerID, ipamConf.GetPodRef(), args.IfName)
-	return cmdDel(ipam)
+	if ipam, ok := store.(*kubernetes.KubernetesIPAM); ok {
+		return cmdDel(ipam)
+	}
+	return cmdDelWithStore(store, args, *ipamConf)
+}
+
+// setLogFields correlates the log lines of the invocation, the plugin serving a single one per process. Its request ID
+// is generated once, and kept as the pod becomes known.
+func setLogFields(args *skel.CmdArgs, podRef string) {
+	requestID := logging.FieldsFromContext(context.Background()).RequestID
+	if requestID == "" {
+		requestID = logging.NewRequestID()
+	}
+	logging.SetFields(logging.Fields{
+		RequestID:   requestID,
+		ContainerID: args.ContainerID,
+		PodRef:      podRef,
+		IfName:      args.IfName,
+	})
 }
 
 func main() {
 	skel.PluginMainFuncs(skel.CNIFuncs{
 		Add:   cmdAddFunc,
-		Check: cmdCheck,
+		Check: cmdCheckFunc,
 		Del:   cmdDelFunc,
 	},
 		cniversion.All,
 		fmt.Sprintf("whereabouts %s", version.GetFullVersionWithRuntimeInfo()))
 }
 
-func safeCloseKubernetesBackendConnection(ipam *kubernetes.KubernetesIPAM) {
-	if err := ipam.Close(); err != nil {
-		_ = logging.Errorf("failed to close the connection to the K8s backend: %v", err)
+func safeCloseBackendConnection(store storage.Store) {
+	if err := store.Close(); err != nil {
+		_ = logging.Errorf("failed to close the connection to the datastore: %v", err)
 	}
 }
 
-func cmdCheck(args *skel.CmdArgs) error {
-	// TODO
-	return fmt.Errorf("CNI CHECK method is not implemented")
+func cmdCheckFunc(args *skel.CmdArgs) error {
+	setLogFields(args, "")
+	ipamConf, _, err := config.LoadIPAMConfig(args.StdinData, args.Args)
+	if err != nil {
+		logging.Errorf("IPAM configuration load failed: %s", err)
+		return err
+	}
+	config.ConfigureLogging(ipamConf)
+	setLogFields(args, ipamConf.GetPodRef())
+	logging.Debugf("CHECK - IPAM configuration successfully read: %s", logging.SanitizeConfig(ipamConf))
+	if ipamConf.DaemonSocket != "" || ipamConf.AllocationAPI {
+		return cmdCheckViaDaemon(args, *ipamConf)
+	}
+
+	store, err := storage.NewStore(args.ContainerID, args.IfName, *ipamConf)
+	if err != nil {
+		return logging.Errorf("IPAM client initialization error: %v", err)
+	}
+	defer func() { safeCloseBackendConnection(store) }()
+
+	logging.Debugf("Beginning check for ContainerID: %q - podRef: %q - ifName: %q", args.ContainerID, ipamConf.GetPodRef(), args.IfName)
+	if ipam, ok := store.(*kubernetes.KubernetesIPAM); ok {
+		return cmdCheck(ipam)
+	}
+	return cmdCheckWithStore(store, args, *ipamConf)
 }
 
-func cmdAdd(client *kubernetes.KubernetesIPAM, cniVersion string) error {
-	// Initialize our result, and assign DNS & routing.
-	result := &current.Result{}
-	result.DNS = client.Config.DNS
-	result.Routes = client.Config.Routes
+// cmdCheck verifies the addresses of the container are still allocated to its pod, in the IP pools and - when the
+// ranges may overlap - cluster wide
+func cmdCheck(client *kubernetes.KubernetesIPAM) error {
+	ctx, cancel := context.WithTimeout(context.Background(), types.CheckTimeLimit)
+	defer cancel()
+
+	if _, err := kubernetes.CheckAllocations(ctx, client, client.Config); err != nil {
+		return logging.Errorf("CHECK failed: %v", err)
+	}
+	return nil
+}
+
+// cmdCheckWithStore verifies the addresses of the container are still allocated in the IP pools of a datastore other
+// than kubernetes
+func cmdCheckWithStore(store storage.Store, args *skel.CmdArgs, ipamConf types.IPAMConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), types.CheckTimeLimit)
+	defer cancel()
 
+	if err := storage.CheckAllocations(ctx, ipamConf, store, args.ContainerID, args.IfName); err != nil {
+		return logging.Errorf("CHECK failed: %v", err)
+	}
+	return nil
+}
+
+// cmdCheckViaDaemon has the node's whereabouts daemon, or the allocation API, verify the addresses
+func cmdCheckViaDaemon(args *skel.CmdArgs, ipamConf types.IPAMConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), types.CheckTimeLimit)
+	defer cancel()
+
+	client, err := newDaemonClient(ipamConf)
+	if err != nil {
+		return logging.Errorf("failed to create the client of the %s: %v", daemonName(ipamConf), err)
+	}
+	if err := client.Check(ctx, daemonRequest(args)); err != nil {
+		return logging.Errorf("CHECK failed through the %s: %v", daemonName(ipamConf), err)
+	}
+	return nil
+}
+
+func cmdAdd(client *kubernetes.KubernetesIPAM, cniVersion string) error {
 	var newips []net.IPNet
 
 	ctx, cancel := context.WithTimeout(context.Background(), types.AddTimeLimit)
 	defer cancel()
 
+	ctx, finishTrace := startTrace(ctx, client.Config, client.IfName, "whereabouts.add")
 	newips, err := kubernetes.IPManagement(ctx, types.Allocate, client.Config, client)
+	finishTrace(err)
+	if err != nil {
+		logging.Errorf("Error at storage engine: %s", err)
+		return fmt.Errorf("error at storage engine: %w", err)
+	}
+
+	return printAddResult(client.Config, newips, cniVersion)
+}
+
+// cmdAddWithStore allocates the addresses in the IP pools of a datastore other than kubernetes
+func cmdAddWithStore(store storage.Store, args *skel.CmdArgs, ipamConf types.IPAMConfig, cniVersion string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), types.AddTimeLimit)
+	defer cancel()
+
+	ctx, finishTrace := startTrace(ctx, ipamConf, args.IfName, "whereabouts.add")
+	newips, err := storage.IPManagement(ctx, types.Allocate, ipamConf, store, args.ContainerID, args.IfName)
+	finishTrace(err)
 	if err != nil {
 		logging.Errorf("Error at storage engine: %s", err)
 		return fmt.Errorf("error at storage engine: %w", err)
 	}
 
+	return printAddResult(ipamConf, newips, cniVersion)
+}
+
+// cmdAddViaDaemon has the node's whereabouts daemon, or the allocation API, allocate the addresses, rather than talking
+// to the datastore
+func cmdAddViaDaemon(args *skel.CmdArgs, ipamConf types.IPAMConfig, cniVersion string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), types.AddTimeLimit)
+	defer cancel()
+
+	client, err := newDaemonClient(ipamConf)
+	if err != nil {
+		return logging.Errorf("failed to create the client of the %s: %v", daemonName(ipamConf), err)
+	}
+	newips, err := client.Allocate(ctx, daemonRequest(args))
+	if err != nil {
+		return logging.Errorf("failed to allocate through the %s: %v", daemonName(ipamConf), err)
+	}
+
+	return printAddResult(ipamConf, newips, cniVersion)
+}
+
+func printAddResult(ipamConf types.IPAMConfig, newips []net.IPNet, cniVersion string) error {
+	// Initialize our result, and assign DNS & routing.
+	result := &current.Result{}
+	result.DNS = ipamConf.DNS
+	result.Routes = ipamConf.Routes
+
 	for _, newip := range newips {
 		result.IPs = append(result.IPs, &current.IPConfig{
 			Address: newip,
-			Gateway: client.Config.Gateway})
+			Gateway: ipamConf.GatewayOf(newip.IP)})
 	}
 
 	// Assign all the static IP elements.
-	for _, v := range client.Config.Addresses {
+	for _, v := range ipamConf.Addresses {
 		result.IPs = append(result.IPs, &current.IPConfig{
 			Address: v.Address,
 			Gateway: v.Gateway})
 	}
+	sortIPsByFamily(result.IPs, ipamConf.ResultOrder)
 
 	return cnitypes.PrintResult(result, cniVersion)
 }
 
+// sortIPsByFamily puts the IPs of the preferred family first - some applications simply pick the first address.
+// The relative order of the IPs of a family is kept; with no preference, the result keeps the configuration order.
+func sortIPsByFamily(ips []*current.IPConfig, resultOrder string) {
+	if resultOrder == "" {
+		return
+	}
+	preferV6 := resultOrder == types.ResultOrderV6First
+	sort.SliceStable(ips, func(i, j int) bool {
+		iIsV6 := ips[i].Address.IP.To4() == nil
+		jIsV6 := ips[j].Address.IP.To4() == nil
+		return iIsV6 != jIsV6 && iIsV6 == preferV6
+	})
+}
+
 func cmdDel(client *kubernetes.KubernetesIPAM) error {
 	ctx, cancel := context.WithTimeout(context.Background(), types.DelTimeLimit)
 	defer cancel()
 
-	_, _ = kubernetes.IPManagement(ctx, types.Deallocate, client.Config, client)
+	ctx, finishTrace := startTrace(ctx, client.Config, client.IfName, "whereabouts.del")
+	_, err := kubernetes.IPManagement(ctx, types.Deallocate, client.Config, client)
+	finishTrace(err)
+
+	return nil
+}
+
+// cmdDelWithStore releases the addresses in the IP pools of a datastore other than kubernetes. Like cmdDel, it never
+// fails the DEL.
+func cmdDelWithStore(store storage.Store, args *skel.CmdArgs, ipamConf types.IPAMConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), types.DelTimeLimit)
+	defer cancel()
+
+	ctx, finishTrace := startTrace(ctx, ipamConf, args.IfName, "whereabouts.del")
+	_, err := storage.IPManagement(ctx, types.Deallocate, ipamConf, store, args.ContainerID, args.IfName)
+	finishTrace(err)
 
 	return nil
 }
+
+// startTrace roots the spans of the IP management in a span of the given name, when the configuration sets a tracing
+// endpoint. The returned function exports them; a failed export is only logged.
+func startTrace(ctx context.Context, ipamConf types.IPAMConfig, ifName, name string) (context.Context, func(err error)) {
+	ctx, finish := tracing.Trace(ctx, ipamConf.TracingEndpoint, name,
+		tracing.String("pod", ipamConf.GetPodRef()), tracing.String("interface", ifName))
+	return ctx, func(err error) {
+		if exportErr := finish(err); exportErr != nil {
+			logging.Debugf("failed to export the trace: %v", exportErr)
+		}
+	}
+}
+
+// cmdDelViaDaemon has the node's whereabouts daemon, or the allocation API, release the addresses. Like the in-process
+// deletion, it never fails the DEL: the leftovers are garbage collected.
+func cmdDelViaDaemon(args *skel.CmdArgs, ipamConf types.IPAMConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), types.DelTimeLimit)
+	defer cancel()
+
+	client, err := newDaemonClient(ipamConf)
+	if err != nil {
+		_ = logging.Errorf("failed to create the client of the %s: %v", daemonName(ipamConf), err)
+		return nil
+	}
+	if err := client.Deallocate(ctx, daemonRequest(args)); err != nil {
+		_ = logging.Errorf("failed to release through the %s: %v", daemonName(ipamConf), err)
+	}
+	return nil
+}
+
+// daemonClient forwards the requests of the plugin to the node's whereabouts daemon, or to the allocation API
+type daemonClient interface {
+	Allocate(ctx context.Context, request daemon.Request) ([]net.IPNet, error)
+	Deallocate(ctx context.Context, request daemon.Request) error
+	Check(ctx context.Context, request daemon.Request) error
+}
+
+func newDaemonClient(ipamConf types.IPAMConfig) (daemonClient, error) {
+	if ipamConf.AllocationAPI {
+		client, err := allocationapi.NewClient(ipamConf.Kubernetes)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+	return daemon.NewClient(ipamConf.DaemonSocket), nil
+}
+
+func daemonName(ipamConf types.IPAMConfig) string {
+	if ipamConf.AllocationAPI {
+		return "whereabouts allocation API"
+	}
+	return "whereabouts daemon"
+}
+
+func daemonRequest(args *skel.CmdArgs) daemon.Request {
+	return daemon.Request{
+		RequestID:   logging.FieldsFromContext(context.Background()).RequestID,
+		ContainerID: args.ContainerID,
+		IfName:      args.IfName,
+		Args:        args.Args,
+		Config:      args.StdinData,
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,9 +20,13 @@ import (
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/plugins/pkg/testutils"
 
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	k8sclient "k8s.io/client-go/kubernetes"
 	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/allocate"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
@@ -702,6 +707,69 @@ var _ = Describe("Whereabouts operations", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	It("puts the IPv6 address first in the result when asked to", func() {
+		backend := fmt.Sprintf(`"kubernetes": {"kubeconfig": "%s"}`, kubeConfigPath)
+		conf := fmt.Sprintf(`{
+			"cniVersion": "0.3.1",
+			"name": "mynet",
+			"type": "ipvlan",
+			"master": "foo0",
+			"ipam": {
+			  "type": "whereabouts",
+			  "log_file" : "/tmp/whereabouts.log",
+			  "log_level" : "debug",
+			  %s,
+			  "result_order": "v6-first",
+			  "ipRanges": [{
+			    "range": "192.168.10.1/24"
+			  }, {
+			    "range": "abcd::1/64"
+			  }]
+			}
+		}`, backend)
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       nspath,
+			IfName:      ifname,
+			StdinData:   []byte(conf),
+			Args:        cniArgs(podNamespace, podName),
+		}
+
+		confPath := filepath.Join(tmpDir, "whereabouts.conf")
+		Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+		ipamConf, cniVersion, err := config.LoadIPAMConfig([]byte(conf), cniArgs(podNamespace, podName), confPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipamConf.IPRanges).To(HaveLen(2))
+		k8sClient = newK8sIPAM(
+			args.ContainerID,
+			ifname,
+			ipamConf,
+			fakek8sclient.NewSimpleClientset(),
+			fake.NewSimpleClientset(
+				ipPool(ipamConf.IPRanges[0].Range, podNamespace, ipamConf.NetworkName),
+				ipPool(ipamConf.IPRanges[1].Range, podNamespace, ipamConf.NetworkName)))
+
+		// Allocate the IP
+		r, _, err := testutils.CmdAddWithArgs(args, func() error {
+			return cmdAdd(k8sClient, cniVersion)
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		result, err := current.GetResult(r)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(result.IPs).To(HaveLen(2))
+		Expect(result.IPs[0].Address).To(Equal(mustCIDR("abcd::1/64")))
+		Expect(result.IPs[1].Address).To(Equal(mustCIDR("192.168.10.1/24")))
+
+		// Release the IP
+		err = testutils.CmdDelWithArgs(args, func() error {
+			return cmdDel(k8sClient)
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
 	It("allocates addresses using both IPRanges and range notations", func() {
 		backend := fmt.Sprintf(`"kubernetes": {"kubeconfig": "%s"}`, kubeConfigPath)
 		conf := fmt.Sprintf(`{
@@ -896,6 +964,74 @@ var _ = Describe("Whereabouts operations", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	It("skips the addresses reserved for network services", func() {
+		backend := fmt.Sprintf(`"kubernetes": {"kubeconfig": "%s"}`, kubeConfigPath)
+		conf := fmt.Sprintf(`{
+			"cniVersion": "0.3.1",
+			"name": "mynet",
+			"type": "ipvlan",
+			"master": "foo0",
+			"ipam": {
+			  "type": "whereabouts",
+			  "log_file" : "/tmp/whereabouts.log",
+              "log_level" : "debug",
+			  %s,
+			  "range": "192.168.1.0/24",
+			  "service_reservations": ["gateway", "vrrp"],
+			  "gateway": "192.168.10.1"
+			}
+		  }`, backend)
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       nspath,
+			IfName:      ifname,
+			StdinData:   []byte(conf),
+			Args:        cniArgs(podNamespace, podName),
+		}
+
+		confPath := filepath.Join(tmpDir, "whereabouts.conf")
+		Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+		ipamConf, cniVersion, err := config.LoadIPAMConfig([]byte(conf), cniArgs(podNamespace, podName), confPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipamConf.IPRanges).NotTo(BeEmpty())
+		pool := ipPool(ipamConf.IPRanges[0].Range, podNamespace, ipamConf.NetworkName)
+		wbClientSet := fake.NewSimpleClientset(pool)
+		k8sClient = newK8sIPAM(
+			args.ContainerID,
+			ifname,
+			ipamConf,
+			fakek8sclient.NewSimpleClientset(),
+			wbClientSet)
+
+		// Allocate the IP
+		r, _, err := testutils.CmdAddWithArgs(args, func() error {
+			return cmdAdd(k8sClient, cniVersion)
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		result, err := current.GetResult(r)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*result.IPs[0]).To(Equal(
+			current.IPConfig{
+				Address: mustCIDR("192.168.1.3/24"),
+				Gateway: net.ParseIP("192.168.10.1"),
+			}))
+
+		updatedPool, err := wbClientSet.WhereaboutsV1alpha1().IPPools(pool.GetNamespace()).Get(context.TODO(), pool.GetName(), metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updatedPool.Status.Reservations).To(ConsistOf(
+			v1alpha1.ServiceReservation{Name: "gateway", IP: "192.168.1.1"},
+			v1alpha1.ServiceReservation{Name: "vrrp", IP: "192.168.1.2"}))
+		Expect(updatedPool.Spec.Allocations).To(HaveLen(1))
+
+		// Release the IP
+		err = testutils.CmdDelWithArgs(args, func() error {
+			return cmdDel(k8sClient)
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
 	It("allocates addresses using range_end as an upper limit", func() {
 		backend := fmt.Sprintf(`"kubernetes": {"kubeconfig": "%s"}`, kubeConfigPath)
 		conf := fmt.Sprintf(`{
@@ -1110,6 +1246,269 @@ var _ = Describe("Whereabouts operations", func() {
 
 	})
 
+	It("retries with the next IP when losing the race for an overlapping range reservation", func() {
+		const ipRange = "192.168.23.0/24"
+
+		wbClientSet := fake.NewSimpleClientset(ipPool(ipRange, podNamespace, ""))
+		// another pod reserves 192.168.23.1 right after our overlapping range check
+		wbClientSet.PrependReactor("create", "overlappingrangeipreservations", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			reservation := action.(k8stesting.CreateAction).GetObject().(*v1alpha1.OverlappingRangeIPReservation)
+			if reservation.GetName() == "192.168.23.1" {
+				return true, nil, k8serrors.NewAlreadyExists(v1alpha1.Resource("overlappingrangeipreservations"), reservation.GetName())
+			}
+			return false, nil, nil
+		})
+		wbClient := *kubernetes.NewKubernetesClient(wbClientSet, fakek8sclient.NewSimpleClientset())
+
+		conf := fmt.Sprintf(`{
+		"cniVersion": "0.3.1",
+		"name": "mynet",
+		"type": "ipvlan",
+		"master": "foo0",
+		"ipam": {
+		  "type": "whereabouts",
+		  "datastore": "kubernetes",
+		  "log_file" : "/tmp/whereabouts.log",
+			"log_level" : "debug",
+		  "kubernetes": {"kubeconfig": "%s"},
+		  "range": %q
+		}
+	  }`, kubeConfigPath, ipRange)
+
+		args := &skel.CmdArgs{
+			ContainerID: "dummy",
+			Netns:       nspath,
+			IfName:      ifname,
+			StdinData:   []byte(conf),
+			Args:        cniArgs(podNamespace, podName),
+		}
+
+		confPath := filepath.Join(tmpDir, "whereabouts.conf")
+		Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+		ipamConf, cniVersion, err := config.LoadIPAMConfig([]byte(conf), cniArgs(podNamespace, podName), confPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		r, _, err := testutils.CmdAddWithArgs(args, func() error {
+			return cmdAdd(mutateK8sIPAM(args.ContainerID, ifname, ipamConf, wbClient), cniVersion)
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		result, err := current.GetResult(r)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*result.IPs[0]).To(Equal(
+			current.IPConfig{
+				Address: mustCIDR("192.168.23.2/24"),
+			}))
+
+		pool, err := wbClientSet.WhereaboutsV1alpha1().IPPools(podNamespace).Get(
+			context.TODO(), kubernetes.IPPoolName(kubernetes.PoolIdentifier{IpRange: ipRange}), metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pool.Spec.Allocations).To(HaveLen(1))
+		Expect(pool.Spec.Allocations).To(HaveKey("2"))
+	})
+
+	Context("interrupted overlapping range allocations", func() {
+		const ipRange = "192.168.24.0/24"
+
+		var (
+			args        *skel.CmdArgs
+			ipamConf    *whereaboutstypes.IPAMConfig
+			cniVersion  string
+			wbClientSet *fake.Clientset
+		)
+
+		BeforeEach(func() {
+			conf := fmt.Sprintf(`{
+			"cniVersion": "0.3.1",
+			"name": "mynet",
+			"type": "ipvlan",
+			"master": "foo0",
+			"ipam": {
+			  "type": "whereabouts",
+			  "datastore": "kubernetes",
+			  "log_file" : "/tmp/whereabouts.log",
+			  "log_level" : "debug",
+			  "kubernetes": {"kubeconfig": "%s"},
+			  "range": %q
+			}
+		  }`, kubeConfigPath, ipRange)
+
+			args = &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       nspath,
+				IfName:      ifname,
+				StdinData:   []byte(conf),
+				Args:        cniArgs(podNamespace, podName),
+			}
+
+			confPath := filepath.Join(tmpDir, "whereabouts.conf")
+			Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+			var err error
+			ipamConf, cniVersion, err = config.LoadIPAMConfig([]byte(conf), cniArgs(podNamespace, podName), confPath)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		cmdAddWith := func(wbClientSet *fake.Clientset) (types.Result, error) {
+			wbClient := *kubernetes.NewKubernetesClient(wbClientSet, fakek8sclient.NewSimpleClientset())
+			r, _, err := testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(mutateK8sIPAM(args.ContainerID, ifname, ipamConf, wbClient), cniVersion)
+			})
+			return r, err
+		}
+
+		overlappingRangeReservations := func() []v1alpha1.OverlappingRangeIPReservation {
+			reservations, err := wbClientSet.WhereaboutsV1alpha1().OverlappingRangeIPReservations(podNamespace).List(context.TODO(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			return reservations.Items
+		}
+
+		It("reuses the reservation left behind by a crash before the pool update", func() {
+			wbClientSet = fake.NewSimpleClientset(
+				ipPool(ipRange, podNamespace, ""),
+				&v1alpha1.OverlappingRangeIPReservation{
+					ObjectMeta: metav1.ObjectMeta{Name: "192.168.24.1", Namespace: podNamespace},
+					Spec: v1alpha1.OverlappingRangeIPReservationSpec{
+						ContainerID: args.ContainerID,
+						PodRef:      ipamConf.GetPodRef(),
+						IfName:      ifname,
+					},
+				})
+
+			r, err := cmdAddWith(wbClientSet)
+			Expect(err).NotTo(HaveOccurred())
+			result, err := current.GetResult(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*result.IPs[0]).To(Equal(
+				current.IPConfig{
+					Address: mustCIDR("192.168.24.1/24"),
+				}))
+			Expect(overlappingRangeReservations()).To(HaveLen(1))
+		})
+
+		It("records the owner of the reservation before updating the pool", func() {
+			var reservationsOnPoolUpdate []runtime.Object
+			wbClientSet = fake.NewSimpleClientset(ipPool(ipRange, podNamespace, ""))
+			wbClientSet.PrependReactor("patch", "ippools", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				// crash point: whatever is reserved when the pool is written survives a crash right before it
+				reservations, err := wbClientSet.Tracker().List(
+					v1alpha1.SchemeGroupVersion.WithResource("overlappingrangeipreservations"),
+					v1alpha1.SchemeGroupVersion.WithKind("OverlappingRangeIPReservation"),
+					podNamespace)
+				if err != nil {
+					return true, nil, err
+				}
+				reservationsOnPoolUpdate, err = meta.ExtractList(reservations)
+				return false, nil, err
+			})
+
+			_, err := cmdAddWith(wbClientSet)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reservationsOnPoolUpdate).To(HaveLen(1))
+			Expect(reservationsOnPoolUpdate[0].(*v1alpha1.OverlappingRangeIPReservation).Spec).To(Equal(
+				v1alpha1.OverlappingRangeIPReservationSpec{
+					ContainerID: args.ContainerID,
+					PodRef:      ipamConf.GetPodRef(),
+					IfName:      ifname,
+				}))
+		})
+
+		It("rolls back the reservation when the pool update fails", func() {
+			wbClientSet = fake.NewSimpleClientset(ipPool(ipRange, podNamespace, ""))
+			wbClientSet.PrependReactor("patch", "ippools", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, k8serrors.NewForbidden(v1alpha1.Resource("ippools"), action.(k8stesting.PatchAction).GetName(), errors.New("denied"))
+			})
+
+			_, err := cmdAddWith(wbClientSet)
+			Expect(err).To(HaveOccurred())
+			Expect(overlappingRangeReservations()).To(BeEmpty())
+		})
+	})
+
+	Context("CNI CHECK", func() {
+		const ipRange = "192.168.25.0/24"
+
+		var (
+			args        *skel.CmdArgs
+			client      *kubernetes.KubernetesIPAM
+			wbClientSet *fake.Clientset
+		)
+
+		BeforeEach(func() {
+			conf := fmt.Sprintf(`{
+			"cniVersion": "0.4.0",
+			"name": "mynet",
+			"type": "ipvlan",
+			"master": "foo0",
+			"ipam": {
+			  "type": "whereabouts",
+			  "datastore": "kubernetes",
+			  "log_file" : "/tmp/whereabouts.log",
+			  "log_level" : "debug",
+			  "kubernetes": {"kubeconfig": "%s"},
+			  "range": %q
+			}
+		  }`, kubeConfigPath, ipRange)
+
+			args = &skel.CmdArgs{
+				ContainerID: "dummy",
+				Netns:       nspath,
+				IfName:      ifname,
+				StdinData:   []byte(conf),
+				Args:        cniArgs(podNamespace, podName),
+			}
+
+			confPath := filepath.Join(tmpDir, "whereabouts.conf")
+			Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+			ipamConf, cniVersion, err := config.LoadIPAMConfig([]byte(conf), cniArgs(podNamespace, podName), confPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			wbClientSet = fake.NewSimpleClientset(ipPool(ipRange, podNamespace, ""))
+			client = mutateK8sIPAM(args.ContainerID, ifname, ipamConf, *kubernetes.NewKubernetesClient(wbClientSet, fakek8sclient.NewSimpleClientset()))
+			_, _, err = testutils.CmdAddWithArgs(args, func() error {
+				return cmdAdd(client, cniVersion)
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		cmdCheckWith := func() error {
+			return testutils.CmdCheckWithArgs(args, func() error {
+				return cmdCheck(client)
+			})
+		}
+
+		It("succeeds while the container holds its addresses", func() {
+			Expect(cmdCheckWith()).To(Succeed())
+		})
+
+		It("fails once the addresses are released", func() {
+			Expect(testutils.CmdDelWithArgs(args, func() error {
+				return cmdDel(client)
+			})).To(Succeed())
+
+			Expect(cmdCheckWith()).To(MatchError(ContainSubstring("no address of range 192.168.25.0/24 is allocated to container dummy")))
+		})
+
+		It("fails when the IP pool allocation belongs to another pod", func() {
+			pool, err := wbClientSet.WhereaboutsV1alpha1().IPPools(podNamespace).Get(
+				context.TODO(), kubernetes.IPPoolName(kubernetes.PoolIdentifier{IpRange: ipRange}), metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			allocation := pool.Spec.Allocations["1"]
+			allocation.PodRef = "dummyNS/otherPOD"
+			pool.Spec.Allocations["1"] = allocation
+			_, err = wbClientSet.WhereaboutsV1alpha1().IPPools(podNamespace).Update(context.TODO(), pool, metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(cmdCheckWith()).To(MatchError(ContainSubstring("is allocated to pod dummyNS/otherPOD")))
+		})
+
+		It("fails when the cluster wide reservation is missing", func() {
+			Expect(wbClientSet.WhereaboutsV1alpha1().OverlappingRangeIPReservations(podNamespace).Delete(
+				context.TODO(), "192.168.25.1", metav1.DeleteOptions{})).To(Succeed())
+
+			Expect(cmdCheckWith()).To(MatchError(ContainSubstring("IP 192.168.25.1 has no cluster wide reservation")))
+		})
+	})
+
 	It("detects IPv6 addresses used in other ranges, to allow for overlapping IP address ranges", func() {
 		firstPodName := "dummyfirstrange"
 		secondPodName := "dummysecondrange"
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// excludeFlag collects the exclusions given as repeated flags, or comma separated
+type excludeFlag []string
+
+func (e *excludeFlag) String() string {
+	return strings.Join(*e, ",")
+}
+
+func (e *excludeFlag) Set(value string) error {
+	for _, exclude := range strings.Split(value, ",") {
+		if exclude = strings.TrimSpace(exclude); exclude != "" {
+			*e = append(*e, exclude)
+		}
+	}
+	return nil
+}
+
+// ipInterval is an inclusive interval of IPs, as integers
+type ipInterval struct {
+	first, last *big.Int
+}
+
+// calc prints the usable IPs of the range, its capacity once the exclusions are left out and, given a slice size, how
+// it divides into node slices. It works offline, validating the range as the IPAM configuration does.
+func (c *ctl) calc(ipRange string, rangeStart, rangeEnd net.IP, excludes []string, sliceSize string) error {
+	rangeConf := types.RangeConfiguration{Range: ipRange, RangeStart: rangeStart, RangeEnd: rangeEnd, OmitRanges: excludes}
+	if err := rangeConf.Normalize(); err != nil {
+		return err
+	}
+	firstIP, lastIP, err := rangeConf.UsableRange()
+	if err != nil {
+		return err
+	}
+	usable := new(big.Int).Sub(ipToInt(lastIP), ipToInt(firstIP))
+	usable.Add(usable, big.NewInt(1))
+	excluded, err := countExcluded(firstIP, lastIP, rangeConf.OmitRanges)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "range\t%s\n", rangeConf.Range)
+	fmt.Fprintf(w, "first usable IP\t%s\n", firstIP)
+	fmt.Fprintf(w, "last usable IP\t%s\n", lastIP)
+	fmt.Fprintf(w, "usable IPs\t%s\n", usable)
+	fmt.Fprintf(w, "excluded IPs\t%s\n", excluded)
+	fmt.Fprintf(w, "capacity\t%s\n", new(big.Int).Sub(usable, excluded))
+	if sliceSize != "" {
+		if err := writeNodeSlices(w, rangeConf, sliceSize); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// writeNodeSlices prints how the range divides into node slices of the given size, e.g. /22
+func writeNodeSlices(w *tabwriter.Writer, rangeConf types.RangeConfiguration, sliceSize string) error {
+	ipNet, err := rangeConf.Network()
+	if err != nil {
+		return err
+	}
+	if _, err := strconv.Atoi(strings.TrimPrefix(sliceSize, "/")); err != nil {
+		return fmt.Errorf("invalid slice size %q, expected a prefix length, e.g. /22", sliceSize)
+	}
+	slices, err := iphelpers.DivideRangeBySize(ipNet.String(), sliceSize)
+	if err != nil {
+		return fmt.Errorf("invalid slice size %s for range %s: %w", sliceSize, rangeConf.Range, err)
+	}
+
+	_, firstSlice, err := net.ParseCIDR(slices[0])
+	if err != nil {
+		return err
+	}
+	sliceUsable := "0"
+	if sliceFirstIP, sliceLastIP, err := iphelpers.GetIPRange(*firstSlice, nil, nil); err == nil {
+		sliceUsable = new(big.Int).Add(new(big.Int).Sub(ipToInt(sliceLastIP), ipToInt(sliceFirstIP)), big.NewInt(1)).String()
+	}
+	fmt.Fprintf(w, "node slices\t%d\n", len(slices))
+	fmt.Fprintf(w, "usable IPs per slice\t%s\n", sliceUsable)
+	fmt.Fprintf(w, "first slice\t%s\n", slices[0])
+	fmt.Fprintf(w, "last slice\t%s\n", slices[len(slices)-1])
+	return nil
+}
+
+// countExcluded counts the IPs between firstIP and lastIP covered by the exclusions, which may overlap
+func countExcluded(firstIP, lastIP net.IP, excludes []string) (*big.Int, error) {
+	first, last := ipToInt(firstIP), ipToInt(lastIP)
+	var intervals []ipInterval
+	for _, exclude := range excludes {
+		subnet, err := iphelpers.ParseExcludedRange(exclude)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude %q: %w", exclude, err)
+		}
+		interval := ipInterval{first: ipToInt(iphelpers.NetworkIP(*subnet)), last: ipToInt(iphelpers.SubnetBroadcastIP(*subnet))}
+		if interval.first.Cmp(first) < 0 {
+			interval.first = first
+		}
+		if interval.last.Cmp(last) > 0 {
+			interval.last = last
+		}
+		if interval.first.Cmp(interval.last) <= 0 {
+			intervals = append(intervals, interval)
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].first.Cmp(intervals[j].first) < 0
+	})
+
+	excluded := new(big.Int)
+	var current *ipInterval
+	for i := range intervals {
+		if current != nil && intervals[i].first.Cmp(new(big.Int).Add(current.last, big.NewInt(1))) <= 0 {
+			if intervals[i].last.Cmp(current.last) > 0 {
+				current.last = intervals[i].last
+			}
+			continue
+		}
+		if current != nil {
+			excluded.Add(excluded, current.size())
+		}
+		current = &ipInterval{first: intervals[i].first, last: intervals[i].last}
+	}
+	if current != nil {
+		excluded.Add(excluded, current.size())
+	}
+	return excluded, nil
+}
+
+func (i ipInterval) size() *big.Int {
+	return new(big.Int).Add(new(big.Int).Sub(i.last, i.first), big.NewInt(1))
+}
+
+func ipToInt(ip net.IP) *big.Int {
+	return new(big.Int).SetBytes(ip.To16())
+}
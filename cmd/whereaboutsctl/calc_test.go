@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func calcOutput(args ...string) (map[string]string, int, string) {
+	var out bytes.Buffer
+	exitCode := run(context.TODO(), &ctl{out: &out}, &out, append([]string{calcCommand}, args...))
+	values := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 {
+			values[strings.Join(fields[:len(fields)-1], " ")] = fields[len(fields)-1]
+		}
+	}
+	return values, exitCode, out.String()
+}
+
+func TestCalc(t *testing.T) {
+	values, exitCode, out := calcOutput("-range", "10.0.0.0/8", "-slice", "/20",
+		"-exclude", "10.0.0.0/24,10.0.0.128/25", "-exclude", "10.255.255.0/24")
+	if exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, out)
+	}
+
+	expected := map[string]string{
+		"first usable IP":      "10.0.0.1",
+		"last usable IP":       "10.255.255.254",
+		"usable IPs":           "16777214",
+		"excluded IPs":         "510",
+		"capacity":             "16776704",
+		"node slices":          "4096",
+		"usable IPs per slice": "4094",
+		"last slice":           "10.255.240.0/20",
+	}
+	for key, value := range expected {
+		if values[key] != value {
+			t.Errorf("Expected %s %s, got %q", key, value, values[key])
+		}
+	}
+}
+
+func TestCalcIPv6(t *testing.T) {
+	values, exitCode, out := calcOutput("-range", "abcd::/64", "-range-end", "abcd::ffff", "-exclude", "abcd::/120")
+	if exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, out)
+	}
+	if values["capacity"] != "65280" {
+		t.Errorf("Expected capacity 65280, got %q", values["capacity"])
+	}
+	if _, found := values["node slices"]; found {
+		t.Errorf("Expected no node slices without a slice size, got %s", out)
+	}
+}
+
+func TestCalcIPv6NodeSlices(t *testing.T) {
+	values, exitCode, out := calcOutput("-range", "abcd::/62", "-slice", "/64")
+	if exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, out)
+	}
+	expected := map[string]string{
+		"node slices": "4",
+		"first slice": "abcd::/64",
+		"last slice":  "abcd:0:0:3::/64",
+	}
+	for key, value := range expected {
+		if values[key] != value {
+			t.Errorf("Expected %s %s, got %q", key, value, values[key])
+		}
+	}
+}
+
+func TestCalcInvalidRanges(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"-range", "10.0.0.0/33"},
+		{"-range", "10.0.0.0/24", "-exclude", "abcd::1"},
+		{"-range", "10.0.0.0/24", "-slice", "/20"},
+		{"-range", "abcd::/48", "-slice", "/80"},
+	} {
+		if _, exitCode, _ := calcOutput(args...); exitCode == 0 {
+			t.Errorf("Expected %v to fail, got exit code 0", args)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// the pool updates are guarded by their resource version: on conflict, the pools are listed anew
+const updateRetries = 5
+
+// The problems the audit reports
+const (
+	problemPodNotFound      = "the pod does not exist"
+	problemOutsideRange     = "the IP is outside the range of the pool"
+	problemDuplicateIfName  = "the pod holds other IPs of the pool for the same interface"
+	problemNetworkAddress   = "the IP is the network address of the range"
+	problemBroadcastAddress = "the IP is the broadcast address of the range"
+)
+
+const (
+	poolAllocationsHeader    = "POOL\tIP\tPOD\tCONTAINER\tINTERFACE"
+	auditHeader              = "POOL\tIP\tPOD\tPROBLEM"
+	reservationDriftsHeader  = "POOL\tIP\tPOD\tRESERVED FOR\tDRIFT\tREPAIRED"
+	notApplicableColumnValue = "-"
+)
+
+// poolMutation returns the new allocations of the pool, and a description of the change
+type poolMutation func(pool *kubernetes.KubernetesIPPool) ([]types.IPReservation, string, error)
+
+type ctl struct {
+	client *kubernetes.Client
+	out    io.Writer
+}
+
+// poolAllocation is an allocation of an IP pool, its offset resolved into the allocated IP
+type poolAllocation struct {
+	pool *kubernetes.KubernetesIPPool
+	types.IPReservation
+}
+
+func (c *ctl) listPools(ctx context.Context, poolName string) ([]*kubernetes.KubernetesIPPool, error) {
+	pools, err := c.client.ListIPPools(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the IP pools: %w", err)
+	}
+
+	var k8sPools []*kubernetes.KubernetesIPPool
+	for _, pool := range pools {
+		k8sPool, ok := pool.(*kubernetes.KubernetesIPPool)
+		if !ok || (poolName != "" && k8sPool.Name() != poolName) {
+			continue
+		}
+		k8sPools = append(k8sPools, k8sPool)
+	}
+	if poolName != "" && len(k8sPools) == 0 {
+		return nil, fmt.Errorf("IP pool %s not found", poolName)
+	}
+	sort.Slice(k8sPools, func(i, j int) bool {
+		return k8sPools[i].Name() < k8sPools[j].Name()
+	})
+	return k8sPools, nil
+}
+
+// listAllocations prints the allocations of the IP pools - or of the given pool - optionally limited to those of a pod
+func (c *ctl) listAllocations(ctx context.Context, poolName, podRef string) error {
+	pools, err := c.listPools(ctx, poolName)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, poolAllocationsHeader)
+	for _, allocation := range sortedAllocations(pools) {
+		if podRef != "" && allocation.PodRef != podRef {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", allocation.pool.Name(), allocation.IP, allocation.PodRef,
+			orNotApplicable(allocation.ContainerID), orNotApplicable(allocation.IfName))
+	}
+	return w.Flush()
+}
+
+// free removes the allocation of the IP from the pool holding it, then the cluster wide reservation of the IP held by
+// the pod of the allocation, as the releases do
+func (c *ctl) free(ctx context.Context, poolName string, ip net.IP) error {
+	var freedPool *kubernetes.KubernetesIPPool
+	var freed types.IPReservation
+	err := c.updatePool(ctx, poolName, ip, func(pool *kubernetes.KubernetesIPPool) ([]types.IPReservation, string, error) {
+		var reservations []types.IPReservation
+		freedPool = nil
+		for _, reservation := range pool.Allocations() {
+			if reservation.IP.Equal(ip) {
+				freedPool, freed = pool, reservation
+				continue
+			}
+			reservations = append(reservations, reservation)
+		}
+		if freedPool == nil {
+			return nil, "", fmt.Errorf("IP %s is not allocated in IP pool %s", ip, pool.Name())
+		}
+		return reservations, fmt.Sprintf("freed IP %s of IP pool %s, allocated to pod %s", ip, pool.Name(), freed.PodRef), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	overlappingRangeStore, networkName, err := c.client.PoolOverlappingRangeStore(ctx, freedPool)
+	if err != nil || overlappingRangeStore == nil {
+		return err
+	}
+	clusterWideIP, err := overlappingRangeStore.GetOverlappingRangeIPReservation(ctx, ip, freed.PodRef, networkName)
+	if err != nil {
+		return err
+	}
+	if clusterWideIP == nil || clusterWideIP.Spec.PodRef != freed.PodRef {
+		return nil
+	}
+	err = overlappingRangeStore.UpdateOverlappingRangeAllocation(ctx, types.Deallocate, ip, freed.ContainerID, freed.PodRef,
+		freed.IfName, networkName, freedPool.Range())
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to release the cluster wide reservation of IP %s: %w", ip, err)
+	}
+	return nil
+}
+
+// reserve allocates the IP to the pod in the pool whose range holds it. As on allocation, the IP is reserved cluster
+// wide first, the reservation being rolled back should the pool update fail.
+func (c *ctl) reserve(ctx context.Context, poolName string, ip net.IP, podRef, containerID, ifName string) error {
+	pool, err := c.poolOf(ctx, poolName, ip)
+	if err != nil {
+		return err
+	}
+	overlappingRangeStore, networkName, err := c.client.PoolOverlappingRangeStore(ctx, pool)
+	if err != nil {
+		return err
+	}
+	reserved := false
+	if overlappingRangeStore != nil {
+		clusterWideIP, err := overlappingRangeStore.GetOverlappingRangeIPReservation(ctx, ip, podRef, networkName)
+		if err != nil {
+			return err
+		}
+		if clusterWideIP != nil && clusterWideIP.Spec.PodRef != podRef {
+			return fmt.Errorf("IP %s is reserved cluster wide for pod %s", ip, clusterWideIP.Spec.PodRef)
+		}
+		if clusterWideIP == nil {
+			if err := overlappingRangeStore.UpdateOverlappingRangeAllocation(ctx, types.Allocate, ip, containerID, podRef, ifName,
+				networkName, pool.Range()); err != nil {
+				return fmt.Errorf("failed to reserve IP %s cluster wide: %w", ip, err)
+			}
+			reserved = true
+		}
+	}
+
+	err = c.updatePool(ctx, pool.Name(), ip, func(pool *kubernetes.KubernetesIPPool) ([]types.IPReservation, string, error) {
+		reservations := pool.Allocations()
+		for _, reservation := range reservations {
+			if reservation.IP.Equal(ip) {
+				return nil, "", fmt.Errorf("IP %s of IP pool %s is already allocated to pod %s", ip, pool.Name(), reservation.PodRef)
+			}
+		}
+		reservations = append(reservations, types.IPReservation{IP: ip, ContainerID: containerID, PodRef: podRef, IfName: ifName})
+		return reservations, fmt.Sprintf("reserved IP %s of IP pool %s for pod %s", ip, pool.Name(), podRef), nil
+	})
+	if err != nil && reserved {
+		if rollbackErr := overlappingRangeStore.UpdateOverlappingRangeAllocation(ctx, types.Deallocate, ip, containerID, podRef,
+			ifName, networkName, pool.Range()); rollbackErr != nil {
+			return fmt.Errorf("%w; failed to roll back the cluster wide reservation of IP %s: %v", err, ip, rollbackErr)
+		}
+	}
+	return err
+}
+
+// move reassigns the allocation of the IP from a pod to another in a single update of its pool
+func (c *ctl) move(ctx context.Context, poolName string, move kubernetes.IPMove) error {
+	pool, err := c.client.MoveIP(ctx, poolName, move)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "moved IP %s of IP pool %s from pod %s to pod %s\n", move.IP, pool.Name(), move.FromPodRef, move.ToPodRef)
+	return nil
+}
+
+// compact compacts the IP pools - or the given pool - and reports those which changed
+func (c *ctl) compact(ctx context.Context, poolName string, dryRun bool) error {
+	compactions, err := c.client.CompactIPPools(ctx, poolName, dryRun)
+	compacted := "compacted"
+	if dryRun {
+		compacted = "would compact"
+	}
+	for _, compaction := range compactions {
+		fmt.Fprintf(c.out, "%s IP pool %s: %d allocations rekeyed, %d tombstones dropped, %d status entries pruned\n",
+			compacted, compaction.Pool, compaction.Rekeyed, len(compaction.Dropped), compaction.PrunedStatus)
+	}
+	if err == nil && len(compactions) == 0 {
+		fmt.Fprintln(c.out, "no IP pool to compact")
+	}
+	return err
+}
+
+// verifyReservations prints the overlapping range reservations missing for, or disagreeing with, the allocations of
+// the IP pools, and whether they were repaired, returning how many were left as they are
+func (c *ctl) verifyReservations(ctx context.Context, dryRun bool) (int, error) {
+	drifts, err := c.client.VerifyOverlappingReservations(ctx, dryRun)
+	if err != nil && len(drifts) == 0 {
+		return 0, err
+	}
+	if len(drifts) == 0 {
+		fmt.Fprintln(c.out, "no overlapping range reservation to repair")
+		return 0, nil
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, reservationDriftsHeader)
+	unrepaired := 0
+	for _, drift := range drifts {
+		if !drift.Repaired {
+			unrepaired++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\n", drift.Pool, drift.IP, drift.PodRef, orNotApplicable(drift.ReservedPodRef),
+			drift.Drift, drift.Repaired)
+	}
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	return unrepaired, err
+}
+
+// migrateHostLocal allocates the leases of the host-local data directory in the IP pool of the range and network, and
+// reports the leases migrated, or skipped along with the reason why
+func (c *ctl) migrateHostLocal(ctx context.Context, dir, namespace string, poolIdentifier kubernetes.PoolIdentifier, overlappingRanges, dryRun bool) error {
+	leases, err := kubernetes.ReadHostLocalLeases(dir)
+	if err != nil {
+		return err
+	}
+	if len(leases) == 0 {
+		fmt.Fprintf(c.out, "no host-local lease in %s\n", dir)
+		return nil
+	}
+
+	migration, err := c.client.MigrateHostLocalLeases(ctx, namespace, poolIdentifier, leases, overlappingRanges, dryRun)
+	if migration == nil {
+		return err
+	}
+	migrated := "migrated"
+	if dryRun {
+		migrated = "would migrate"
+	}
+	for _, lease := range migration.Leases {
+		if lease.Skipped != "" {
+			fmt.Fprintf(c.out, "skipped IP %s of container %s: %s\n", lease.IP, lease.ContainerID, lease.Skipped)
+			continue
+		}
+		fmt.Fprintf(c.out, "%s IP %s of pod %s to IP pool %s\n", migrated, lease.IP, lease.PodRef, migration.Pool)
+	}
+	return err
+}
+
+// migrateUnnamedNetwork moves the allocations of the IP pool of the unnamed network of the range to the pool of the
+// network named networkName, and reports the allocations the named pool holds afterwards
+func (c *ctl) migrateUnnamedNetwork(ctx context.Context, namespace, ipRange, networkName string) error {
+	poolIdentifier := kubernetes.PoolIdentifier{IpRange: ipRange}
+	namedPool, err := c.client.MigratePoolToNetwork(ctx, namespace, poolIdentifier, networkName)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "migrated IP pool %s to %s, which holds %d allocations\n",
+		kubernetes.IPPoolName(poolIdentifier), namedPool.Name, len(namedPool.Spec.Allocations))
+	return nil
+}
+
+// updatePool applies the mutation to the pool whose range holds the IP - or to the given pool - retrying on conflicts
+func (c *ctl) updatePool(ctx context.Context, poolName string, ip net.IP, mutate poolMutation) error {
+	var err error
+	for i := 0; i < updateRetries; i++ {
+		var pool *kubernetes.KubernetesIPPool
+		pool, err = c.poolOf(ctx, poolName, ip)
+		if err != nil {
+			return err
+		}
+		var reservations []types.IPReservation
+		var change string
+		reservations, change, err = mutate(pool)
+		if err != nil {
+			return err
+		}
+		if err = pool.Update(ctx, reservations); err == nil {
+			fmt.Fprintln(c.out, change)
+			return nil
+		}
+		if temporary, ok := err.(storage.Temporary); !ok || !temporary.Temporary() {
+			break
+		}
+	}
+	return fmt.Errorf("failed to update the IP pool: %w", err)
+}
+
+// poolOf returns the pool whose range holds the IP; the pool must be named when several ranges - of different
+// networks - hold it
+func (c *ctl) poolOf(ctx context.Context, poolName string, ip net.IP) (*kubernetes.KubernetesIPPool, error) {
+	pools, err := c.listPools(ctx, poolName)
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []*kubernetes.KubernetesIPPool
+	for _, pool := range pools {
+		if _, ipNet, err := net.ParseCIDR(pool.Range()); err == nil && ipNet.Contains(ip) {
+			matching = append(matching, pool)
+		}
+	}
+	switch len(matching) {
+	case 0:
+		return nil, fmt.Errorf("no IP pool holds IP %s", ip)
+	case 1:
+		return matching[0], nil
+	default:
+		var names []string
+		for _, pool := range matching {
+			names = append(names, pool.Name())
+		}
+		return nil, fmt.Errorf("IP %s belongs to several IP pools %v: pick one with -pool", ip, names)
+	}
+}
+
+// audit prints the suspicious allocations of the IP pools of the range, returning how many were found
+func (c *ctl) audit(ctx context.Context, ipRange string) (int, error) {
+	_, rangeNet, err := net.ParseCIDR(ipRange)
+	if err != nil {
+		return 0, fmt.Errorf("invalid range %q: %w", ipRange, err)
+	}
+	pools, err := c.listPools(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+
+	var rangePools []*kubernetes.KubernetesIPPool
+	for _, pool := range pools {
+		if _, poolNet, err := net.ParseCIDR(pool.Range()); err == nil && poolNet.String() == rangeNet.String() {
+			rangePools = append(rangePools, pool)
+		}
+	}
+	if len(rangePools) == 0 {
+		return 0, fmt.Errorf("no IP pool of range %s", rangeNet)
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, auditHeader)
+	problems := 0
+	podExists := map[string]bool{}
+	interfaceIPs := map[string]int{}
+	allocations := sortedAllocations(rangePools)
+	for _, allocation := range allocations {
+		if !types.IsFloatingIPClaim(allocation.ContainerID) {
+			interfaceIPs[allocation.pool.Name()+"/"+allocation.PodRef+"/"+allocation.IfName]++
+		}
+	}
+	for _, allocation := range allocations {
+		// the floating IPs stay with their claims, whether a pod holds them or not, and come on top of the IPs of their
+		// holders
+		claimed := types.IsFloatingIPClaim(allocation.ContainerID)
+		exists, found := podExists[allocation.PodRef]
+		if !found && !claimed {
+			exists, err = c.podExists(ctx, allocation.PodRef)
+			if err != nil {
+				return problems, err
+			}
+			podExists[allocation.PodRef] = exists
+		}
+
+		var allocationProblems []string
+		if !exists && !claimed {
+			allocationProblems = append(allocationProblems, problemPodNotFound)
+		}
+		switch {
+		case !rangeNet.Contains(allocation.IP):
+			allocationProblems = append(allocationProblems, problemOutsideRange)
+		case allocation.IP.Equal(rangeNet.IP):
+			allocationProblems = append(allocationProblems, problemNetworkAddress)
+		case allocation.IP.To4() != nil && allocation.IP.Equal(broadcastAddress(rangeNet)):
+			allocationProblems = append(allocationProblems, problemBroadcastAddress)
+		}
+		if !claimed && allocation.IfName != "" && interfaceIPs[allocation.pool.Name()+"/"+allocation.PodRef+"/"+allocation.IfName] > 1 {
+			allocationProblems = append(allocationProblems, problemDuplicateIfName)
+		}
+
+		for _, problem := range allocationProblems {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", allocation.pool.Name(), allocation.IP, allocation.PodRef, problem)
+		}
+		problems += len(allocationProblems)
+	}
+	return problems, w.Flush()
+}
+
+func (c *ctl) podExists(ctx context.Context, podRef string) (bool, error) {
+	namespace, name, err := splitPodRef(podRef)
+	if err != nil {
+		return false, nil
+	}
+	if _, err := c.client.GetPod(ctx, namespace, name); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get pod %s: %w", podRef, err)
+	}
+	return true, nil
+}
+
+func sortedAllocations(pools []*kubernetes.KubernetesIPPool) []poolAllocation {
+	var allocations []poolAllocation
+	for _, pool := range pools {
+		reservations := pool.Allocations()
+		sort.Slice(reservations, func(i, j int) bool {
+			return bytes.Compare(reservations[i].IP.To16(), reservations[j].IP.To16()) < 0
+		})
+		for _, reservation := range reservations {
+			allocations = append(allocations, poolAllocation{pool: pool, IPReservation: reservation})
+		}
+	}
+	return allocations
+}
+
+func broadcastAddress(ipNet *net.IPNet) net.IP {
+	ip := ipNet.IP.To4()
+	broadcast := make(net.IP, len(ip))
+	for i := range ip {
+		broadcast[i] = ip[i] | ^ipNet.Mask[i]
+	}
+	return broadcast
+}
+
+func orNotApplicable(value string) string {
+	if value == "" {
+		return notApplicableColumnValue
+	}
+	return value
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	fakenadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+func newTestCtl(out *bytes.Buffer) (*ctl, *fakewbclient.Clientset) {
+	pool := &v1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+		Spec: v1alpha1.IPPoolSpec{
+			Range: "10.0.0.0/24",
+			Allocations: map[string]v1alpha1.IPAllocation{
+				"1":   {ContainerID: "container-a", PodRef: "default/pod-a", IfName: "net1"},
+				"2":   {ContainerID: "container-b", PodRef: "default/pod-b", IfName: "net1"},
+				"255": {ContainerID: "container-a", PodRef: "default/pod-a", IfName: "net1"},
+			},
+		},
+	}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-a", Namespace: "default"}}
+	wbClient := fakewbclient.NewSimpleClientset(pool)
+	return &ctl{client: kubernetes.NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset(pod)), out: out}, wbClient
+}
+
+func allocatedPods(t *testing.T, wbClient *fakewbclient.Clientset) map[string]string {
+	pool, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(context.TODO(), "10.0.0.0-24", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	pods := map[string]string{}
+	for offset, allocation := range pool.Spec.Allocations {
+		pods[offset] = allocation.PodRef
+	}
+	return pods
+}
+
+func reservedPods(t *testing.T, wbClient *fakewbclient.Clientset) map[string]string {
+	reservations, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations("kube-system").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	pods := map[string]string{}
+	for _, reservation := range reservations.Items {
+		pods[reservation.GetName()] = reservation.Spec.PodRef
+	}
+	return pods
+}
+
+func createReservation(t *testing.T, wbClient *fakewbclient.Clientset, name, podRef string) {
+	reservation := &v1alpha1.OverlappingRangeIPReservation{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-system"},
+		Spec:       v1alpha1.OverlappingRangeIPReservationSpec{PodRef: podRef},
+	}
+	if _, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations("kube-system").Create(context.TODO(), reservation, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestListAllocations(t *testing.T) {
+	var out bytes.Buffer
+	c, _ := newTestCtl(&out)
+	if exitCode := run(context.TODO(), c, &out, []string{listAllocationsCommand, "-pod-ref", "default/pod-b"}); exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, out.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "POOL") {
+		t.Fatalf("Expected a header and an allocation, got %q", out.String())
+	}
+	if fields := strings.Fields(lines[1]); strings.Join(fields, " ") != "10.0.0.0-24 10.0.0.2 default/pod-b container-b net1" {
+		t.Errorf("Expected the allocation of 10.0.0.2, got %q", lines[1])
+	}
+}
+
+func TestFree(t *testing.T) {
+	var out bytes.Buffer
+	c, wbClient := newTestCtl(&out)
+	createReservation(t, wbClient, "10.0.0.1", "default/pod-a")
+	createReservation(t, wbClient, "10.0.0.2", "default/pod-b")
+	if exitCode := run(context.TODO(), c, &out, []string{freeCommand, "10.0.0.2"}); exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, out.String())
+	}
+	if podRef, found := allocatedPods(t, wbClient)["2"]; found {
+		t.Errorf("Expected 10.0.0.2 to be freed, got it allocated to %s", podRef)
+	}
+	if reserved := reservedPods(t, wbClient); len(reserved) != 1 || reserved["10.0.0.1"] != "default/pod-a" {
+		t.Errorf("Expected the cluster wide reservation of 10.0.0.2 only to be released, got %v", reserved)
+	}
+
+	if exitCode := run(context.TODO(), c, &out, []string{freeCommand, "10.0.0.3"}); exitCode != commandFailure {
+		t.Errorf("Expected freeing an unallocated IP to fail, got exit code %d", exitCode)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	var out bytes.Buffer
+	c, wbClient := newTestCtl(&out)
+	if exitCode := run(context.TODO(), c, &out, []string{reserveCommand, "-pod-ref", "default/pod-c", "10.0.0.3"}); exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, out.String())
+	}
+	if podRef := allocatedPods(t, wbClient)["3"]; podRef != "default/pod-c" {
+		t.Errorf("Expected 10.0.0.3 to be allocated to default/pod-c, got %q", podRef)
+	}
+	if podRef := reservedPods(t, wbClient)["10.0.0.3"]; podRef != "default/pod-c" {
+		t.Errorf("Expected 10.0.0.3 to be reserved cluster wide for default/pod-c, got %q", podRef)
+	}
+
+	createReservation(t, wbClient, "10.0.0.4", "other/pod")
+	if exitCode := run(context.TODO(), c, &out, []string{reserveCommand, "-pod-ref", "default/pod-c", "10.0.0.4"}); exitCode != commandFailure {
+		t.Errorf("Expected reserving an IP reserved cluster wide for another pod to fail, got exit code %d", exitCode)
+	}
+	if podRef, found := allocatedPods(t, wbClient)["4"]; found {
+		t.Errorf("Expected 10.0.0.4 to be left unallocated, got it allocated to %s", podRef)
+	}
+
+	cases := []struct {
+		name string
+		args []string
+	}{
+		{name: "Allocated IP", args: []string{reserveCommand, "-pod-ref", "default/pod-c", "10.0.0.1"}},
+		{name: "IP outside the pools", args: []string{reserveCommand, "-pod-ref", "default/pod-c", "10.1.0.1"}},
+		{name: "Invalid pod reference", args: []string{reserveCommand, "-pod-ref", "pod-c", "10.0.0.4"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if exitCode := run(context.TODO(), c, &out, tc.args); exitCode != commandFailure {
+				t.Errorf("Expected exit code %d, got %d", commandFailure, exitCode)
+			}
+		})
+	}
+	if _, found := reservedPods(t, wbClient)["10.0.0.1"]; found {
+		t.Errorf("Expected the cluster wide reservation of the already allocated 10.0.0.1 to be rolled back")
+	}
+}
+
+func TestMove(t *testing.T) {
+	var out bytes.Buffer
+	c, wbClient := newTestCtl(&out)
+	args := []string{moveCommand, "-pod-ref", "default/pod-b", "-to-pod-ref", "default/pod-c", "10.0.0.2"}
+	if exitCode := run(context.TODO(), c, &out, args); exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, out.String())
+	}
+	if podRef := allocatedPods(t, wbClient)["2"]; podRef != "default/pod-c" {
+		t.Errorf("Expected 10.0.0.2 to be allocated to default/pod-c, got %q", podRef)
+	}
+
+	cases := []struct {
+		name string
+		args []string
+	}{
+		{name: "IP of another pod", args: []string{moveCommand, "-pod-ref", "default/pod-b", "-to-pod-ref", "default/pod-c", "10.0.0.1"}},
+		{name: "Unallocated IP", args: []string{moveCommand, "-pod-ref", "default/pod-b", "-to-pod-ref", "default/pod-c", "10.0.0.3"}},
+		{name: "Invalid pod reference", args: []string{moveCommand, "-pod-ref", "default/pod-a", "-to-pod-ref", "pod-c", "10.0.0.1"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if exitCode := run(context.TODO(), c, &out, tc.args); exitCode != commandFailure {
+				t.Errorf("Expected exit code %d, got %d", commandFailure, exitCode)
+			}
+		})
+	}
+}
+
+func TestCompact(t *testing.T) {
+	var out bytes.Buffer
+	c, wbClient := newTestCtl(&out)
+	if exitCode := run(context.TODO(), c, &out, []string{compactCommand}); exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, out.String())
+	}
+	if !strings.Contains(out.String(), "no IP pool to compact") {
+		t.Errorf("Expected no IP pool to compact, got %q", out.String())
+	}
+
+	pool, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(context.TODO(), "10.0.0.0-24", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	pool.Spec.Allocations["007"] = v1alpha1.IPAllocation{ContainerID: "container-c", PodRef: "default/pod-c"}
+	pool.Spec.Allocations["300"] = v1alpha1.IPAllocation{ContainerID: "container-d", PodRef: "default/pod-d"}
+	if _, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Update(context.TODO(), pool, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	out.Reset()
+	if exitCode := run(context.TODO(), c, &out, []string{compactCommand, "-dry-run"}); exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, out.String())
+	}
+	if !strings.Contains(out.String(), "would compact IP pool 10.0.0.0-24: 1 allocations rekeyed, 1 tombstones dropped") {
+		t.Errorf("Expected the compaction to be reported, got %q", out.String())
+	}
+	if _, found := allocatedPods(t, wbClient)["007"]; !found {
+		t.Errorf("Expected the dry run to leave the pool as it is")
+	}
+
+	out.Reset()
+	if exitCode := run(context.TODO(), c, &out, []string{compactCommand, "-pool", "10.0.0.0-24"}); exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, out.String())
+	}
+	pods := allocatedPods(t, wbClient)
+	if len(pods) != 4 || pods["7"] != "default/pod-c" {
+		t.Errorf("Expected 10.0.0.7 to be rekeyed and the out of range allocation dropped, got %v", pods)
+	}
+
+	if exitCode := run(context.TODO(), c, &out, []string{compactCommand, "-pool", "missing"}); exitCode != commandFailure {
+		t.Errorf("Expected exit code %d compacting a missing pool, got %d", commandFailure, exitCode)
+	}
+}
+
+func TestMigrateHostLocal(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"10.0.0.3":           "container-c\r\nnet1",
+		"10.0.0.9":           "container-d\r\nnet1",
+		"10.1.0.1":           "container-e\r\nnet1",
+		"last_reserved_ip.0": "10.0.0.9",
+		"lock":               "",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	c, wbClient := newTestCtl(&out)
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-c", Namespace: "default", Annotations: map[string]string{
+		nadv1.NetworkStatusAnnot: `[{"name": "default/net", "interface": "net1", "ips": ["10.0.0.3"]}]`,
+	}}}
+	c.client = kubernetes.NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset(pod))
+
+	args := []string{migrateCommand, hostLocalSource, "-dir", dir, "-range", "10.0.0.0/24"}
+	if exitCode := run(context.TODO(), c, &out, append(args, "-dry-run")); exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, out.String())
+	}
+	for _, expected := range []string{
+		"would migrate IP 10.0.0.3 of pod default/pod-c to IP pool 10.0.0.0-24",
+		"skipped IP 10.0.0.9 of container container-d: " + kubernetes.LeaseWithoutPod,
+		"skipped IP 10.1.0.1 of container container-e: " + kubernetes.LeaseOutsideRange,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q to be reported, got %q", expected, out.String())
+		}
+	}
+	if _, found := allocatedPods(t, wbClient)["3"]; found {
+		t.Errorf("Expected the dry run to leave the pool as it is")
+	}
+
+	out.Reset()
+	if exitCode := run(context.TODO(), c, &out, args); exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, out.String())
+	}
+	if pods := allocatedPods(t, wbClient); pods["3"] != "default/pod-c" {
+		t.Errorf("Expected 10.0.0.3 to be allocated to pod default/pod-c, got %v", pods)
+	}
+	reservation, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations("kube-system").Get(context.TODO(), "10.0.0.3", metav1.GetOptions{})
+	if err != nil || reservation.Spec.PodRef != "default/pod-c" {
+		t.Errorf("Expected 10.0.0.3 to be reserved cluster wide for pod default/pod-c, got %v, %v", reservation, err)
+	}
+
+	out.Reset()
+	if exitCode := run(context.TODO(), c, &out, args); exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, out.String())
+	}
+	if !strings.Contains(out.String(), "skipped IP 10.0.0.3 of container container-c: "+kubernetes.LeaseAlreadyAllocated) {
+		t.Errorf("Expected migrating anew to skip the migrated lease, got %q", out.String())
+	}
+
+	if exitCode := run(context.TODO(), c, &out, []string{migrateCommand, "-dir", dir}); exitCode != invalidArguments {
+		t.Errorf("Expected exit code %d without the source, got %d", invalidArguments, exitCode)
+	}
+}
+
+func TestMigrateUnnamedNetwork(t *testing.T) {
+	var out bytes.Buffer
+	c, wbClient := newTestCtl(&out)
+
+	args := []string{migrateCommand, unnamedNetworkSource, "-range", "10.0.0.0/24", "-network-name", "net1"}
+	if exitCode := run(context.TODO(), c, &out, args); exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, out.String())
+	}
+	if expected := "migrated IP pool 10.0.0.0-24 to net1-10.0.0.0-24, which holds 3 allocations"; !strings.Contains(out.String(), expected) {
+		t.Errorf("Expected %q to be reported, got %q", expected, out.String())
+	}
+	if pods := allocatedPods(t, wbClient); len(pods) != 0 {
+		t.Errorf("Expected the unnamed pool to be emptied, got %v", pods)
+	}
+
+	if exitCode := run(context.TODO(), c, &out, args[:4]); exitCode != invalidArguments {
+		t.Errorf("Expected exit code %d without the network name, got %d", invalidArguments, exitCode)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	var out bytes.Buffer
+	c, _ := newTestCtl(&out)
+	if exitCode := run(context.TODO(), c, &out, []string{auditCommand, "10.0.0.0/24"}); exitCode != auditFoundProblems {
+		t.Fatalf("Expected exit code %d, got %d: %s", auditFoundProblems, exitCode, out.String())
+	}
+
+	for _, expected := range []string{problemPodNotFound, problemBroadcastAddress, problemDuplicateIfName} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected the audit to report %q, got %q", expected, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "found 4 problems") {
+		t.Errorf("Expected 4 problems, got %q", out.String())
+	}
+}
+
+func TestVerifyReservations(t *testing.T) {
+	var out bytes.Buffer
+	c, wbClient := newTestCtl(&out)
+	nadClient := fakenadclient.NewSimpleClientset()
+	netAttachDef := &nadv1.NetworkAttachmentDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "net", Namespace: "default"},
+		Spec:       nadv1.NetworkAttachmentDefinitionSpec{Config: `{"name": "net", "ipam": {"type": "whereabouts", "range": "10.0.0.0/24"}}`},
+	}
+	if _, err := nadClient.K8sCniCncfIoV1().NetworkAttachmentDefinitions("default").Create(context.TODO(), netAttachDef, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	c.client = kubernetes.NewKubernetesClientWithNetAttachDefs(wbClient, fakek8sclient.NewSimpleClientset(), nadClient)
+
+	if exitCode := run(context.TODO(), c, &out, []string{verifyCommand, "-dry-run"}); exitCode != auditFoundProblems {
+		t.Fatalf("Expected exit code %d, got %d: %s", auditFoundProblems, exitCode, out.String())
+	}
+	if !strings.Contains(out.String(), "found 3 unrepaired reservations") {
+		t.Errorf("Expected the 3 missing reservations to be reported, got %q", out.String())
+	}
+
+	out.Reset()
+	if exitCode := run(context.TODO(), c, &out, []string{verifyCommand}); exitCode != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", exitCode, out.String())
+	}
+	reservation, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations("kube-system").Get(context.TODO(), "10.0.0.2", metav1.GetOptions{})
+	if err != nil || reservation.Spec.PodRef != "default/pod-b" {
+		t.Errorf("Expected 10.0.0.2 to be reserved cluster wide for pod default/pod-b, got %v, %v", reservation, err)
+	}
+
+	out.Reset()
+	if exitCode := run(context.TODO(), c, &out, []string{verifyCommand}); exitCode != 0 || !strings.Contains(out.String(), "no overlapping range reservation to repair") {
+		t.Errorf("Expected nothing left to repair, got exit code %d: %s", exitCode, out.String())
+	}
+}
@@ -0,0 +1,302 @@
+// Package main is whereaboutsctl, a command line tool inspecting and editing the whereabouts IP pools, which renders
+// the allocated IPs rather than their offsets in the pools
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+const (
+	listAllocationsCommand = "list-allocations"
+	freeCommand            = "free"
+	reserveCommand         = "reserve"
+	moveCommand            = "move"
+	auditCommand           = "audit"
+	compactCommand         = "compact"
+	verifyCommand          = "verify-reservations"
+	migrateCommand         = "migrate"
+	calcCommand            = "calc"
+)
+
+// The sources migrate moves allocations from: the leases of the host-local IPAM plugin, or the IP pool of an unnamed
+// whereabouts network adopting a network_name
+const (
+	hostLocalSource      = "host-local"
+	unnamedNetworkSource = "unnamed-network"
+)
+
+const (
+	_ int = iota
+	invalidArguments
+	couldNotCreateClient
+	commandFailure
+	auditFoundProblems
+)
+
+const usage = `Usage: whereaboutsctl [flags] <command> [command flags] [arguments]
+
+Commands:
+  list-allocations [-pool name] [-pod-ref namespace/name]
+        list the IPs allocated in the IP pools
+  free [-pool name] <ip>
+        release the allocation of the IP
+  reserve [-pool name] -pod-ref namespace/name [-container-id id] [-ifname name] <ip>
+        allocate the IP to the pod
+  move [-pool name] -pod-ref namespace/name [-container-id id] -to-pod-ref namespace/name [-to-container-id id] [-to-ifname name] <ip>
+        reassign the allocation of the IP from a pod to another at once
+  audit <range>
+        report the suspicious allocations of the IP pools of the range
+  compact [-pool name] [-dry-run]
+        rewrite the allocations of the IP pools, dropping their tombstones
+  verify-reservations [-dry-run]
+        repair the overlapping range reservations missing for, or disagreeing with, the allocations of the IP pools
+  migrate host-local -dir path -range cidr [-network-name name] [-namespace name] [-enable-overlapping-ranges=false] [-dry-run]
+        allocate the leases of a host-local network to the pods listing their IPs in their network-status
+  migrate unnamed-network -range cidr -network-name name [-namespace name]
+        move the allocations of the IP pool of an unnamed network to the pool of the network once named
+  calc -range cidr [-range-start ip] [-range-end ip] [-exclude cidr]... [-slice /size]
+        print the usable IPs and the capacity of the range, and its division in node slices, without a cluster
+
+Flags:
+`
+
+// errInvalidArguments reports command line errors, the usage being printed already
+var errInvalidArguments = fmt.Errorf("invalid arguments")
+
+func main() {
+	flags := flag.NewFlagSet("whereaboutsctl", flag.ExitOnError)
+	kubeConfigFile := flags.String("kubeconfig", "", "the path to the Kubernetes configuration file. Uses the in-cluster configuration when empty.")
+	logLevel := flags.String("log-level", "error", "the logging level. Valid values are: \"debug\", \"verbose\", \"warning\", \"error\", and \"panic\".")
+	timeout := flags.Duration("timeout", time.Minute, "the maximum duration of the command.")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(os.Args[1:])
+
+	logging.SetLogLevel(*logLevel)
+	logging.SetLogStderr(true)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(invalidArguments)
+	}
+
+	// calc works offline
+	var client *kubernetes.Client
+	if flags.Arg(0) != calcCommand {
+		var err error
+		client, err = kubernetes.NewClientViaKubeconfig(*kubeConfigFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create the Kubernetes client: %v\n", err)
+			os.Exit(couldNotCreateClient)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	exitCode := run(ctx, &ctl{client: client, out: os.Stdout}, os.Stderr, flags.Args())
+	cancel()
+	os.Exit(exitCode)
+}
+
+// run runs the command of the arguments, returning the exit code
+func run(ctx context.Context, c *ctl, errOut io.Writer, args []string) int {
+	command, args := args[0], args[1:]
+	commandFlags := flag.NewFlagSet(command, flag.ContinueOnError)
+	commandFlags.SetOutput(errOut)
+
+	var err error
+	switch command {
+	case listAllocationsCommand:
+		poolName := commandFlags.String("pool", "", "list the allocations of this IP pool only.")
+		podRef := commandFlags.String("pod-ref", "", "list the allocations of this pod only, as namespace/name.")
+		if err = parseCommandFlags(commandFlags, args, 0); err == nil {
+			err = c.listAllocations(ctx, *poolName, *podRef)
+		}
+	case freeCommand:
+		poolName := commandFlags.String("pool", "", "the IP pool of the IP, when several pools hold it.")
+		var ip net.IP
+		if err = parseCommandFlags(commandFlags, args, 1); err == nil {
+			if ip, err = parseIP(commandFlags.Arg(0)); err == nil {
+				err = c.free(ctx, *poolName, ip)
+			}
+		}
+	case reserveCommand:
+		poolName := commandFlags.String("pool", "", "the IP pool of the IP, when several pools hold it.")
+		podRef := commandFlags.String("pod-ref", "", "the pod the IP is allocated to, as namespace/name.")
+		containerID := commandFlags.String("container-id", "", "the container the IP is allocated to.")
+		ifName := commandFlags.String("ifname", "", "the interface the IP is allocated to.")
+		var ip net.IP
+		if err = parseCommandFlags(commandFlags, args, 1); err == nil {
+			if _, _, err = splitPodRef(*podRef); err == nil {
+				if ip, err = parseIP(commandFlags.Arg(0)); err == nil {
+					err = c.reserve(ctx, *poolName, ip, *podRef, *containerID, *ifName)
+				}
+			}
+		}
+	case moveCommand:
+		poolName := commandFlags.String("pool", "", "the IP pool of the IP, when several pools allocate it to the pod.")
+		podRef := commandFlags.String("pod-ref", "", "the pod the IP is allocated to, as namespace/name.")
+		containerID := commandFlags.String("container-id", "", "the container the IP is allocated to, if it matters.")
+		toPodRef := commandFlags.String("to-pod-ref", "", "the pod the IP is moved to, as namespace/name.")
+		toContainerID := commandFlags.String("to-container-id", "", "the container the IP is moved to.")
+		toIfName := commandFlags.String("to-ifname", "", "the interface the IP is moved to; keeps the interface of the allocation when empty.")
+		var ip net.IP
+		if err = parseCommandFlags(commandFlags, args, 1); err == nil {
+			if _, _, err = splitPodRef(*podRef); err == nil {
+				if _, _, err = splitPodRef(*toPodRef); err == nil {
+					if ip, err = parseIP(commandFlags.Arg(0)); err == nil {
+						err = c.move(ctx, *poolName, kubernetes.IPMove{
+							IP:              ip,
+							FromPodRef:      *podRef,
+							FromContainerID: *containerID,
+							ToPodRef:        *toPodRef,
+							ToContainerID:   *toContainerID,
+							ToIfName:        *toIfName,
+						})
+					}
+				}
+			}
+		}
+	case auditCommand:
+		var problems int
+		if err = parseCommandFlags(commandFlags, args, 1); err == nil {
+			problems, err = c.audit(ctx, commandFlags.Arg(0))
+			if err == nil && problems > 0 {
+				fmt.Fprintf(errOut, "found %d problems\n", problems)
+				return auditFoundProblems
+			}
+		}
+	case compactCommand:
+		poolName := commandFlags.String("pool", "", "compact this IP pool only.")
+		dryRun := commandFlags.Bool("dry-run", false, "report what compacting would change, without updating the IP pools.")
+		if err = parseCommandFlags(commandFlags, args, 0); err == nil {
+			err = c.compact(ctx, *poolName, *dryRun)
+		}
+	case verifyCommand:
+		dryRun := commandFlags.Bool("dry-run", false, "report the reservations verifying would repair, without updating them.")
+		var drifts int
+		if err = parseCommandFlags(commandFlags, args, 0); err == nil {
+			drifts, err = c.verifyReservations(ctx, *dryRun)
+			if err == nil && drifts > 0 {
+				fmt.Fprintf(errOut, "found %d unrepaired reservations\n", drifts)
+				return auditFoundProblems
+			}
+		}
+	case migrateCommand:
+		dir := commandFlags.String("dir", "", "the data directory of the host-local network, e.g. /var/lib/cni/networks/NET.")
+		ipRange := commandFlags.String("range", "", "the range of the whereabouts network, in CIDR notation.")
+		networkName := commandFlags.String("network-name", "", "the network_name of the whereabouts network; required to migrate an unnamed network.")
+		namespace := commandFlags.String("namespace", "kube-system", "the namespace of the IP pools.")
+		overlappingRanges := commandFlags.Bool("enable-overlapping-ranges", true, "reserve the leases cluster wide, as the enable_overlapping_ranges network parameter does.")
+		dryRun := commandFlags.Bool("dry-run", false, "report the leases migrating would allocate, without updating the IP pools.")
+		var source string
+		if len(args) > 0 {
+			source = args[0]
+		}
+		switch source {
+		case hostLocalSource:
+			if err = parseCommandFlags(commandFlags, args[1:], 0); err == nil {
+				if err = requireFlag(commandFlags, "dir", *dir); err == nil {
+					if err = requireFlag(commandFlags, "range", *ipRange); err == nil {
+						err = c.migrateHostLocal(ctx, *dir, *namespace,
+							kubernetes.PoolIdentifier{IpRange: *ipRange, NetworkName: *networkName}, *overlappingRanges, *dryRun)
+					}
+				}
+			}
+		case unnamedNetworkSource:
+			if err = parseCommandFlags(commandFlags, args[1:], 0); err == nil {
+				if err = requireFlag(commandFlags, "range", *ipRange); err == nil {
+					if err = requireFlag(commandFlags, "network-name", *networkName); err == nil {
+						err = c.migrateUnnamedNetwork(ctx, *namespace, *ipRange, *networkName)
+					}
+				}
+			}
+		default:
+			fmt.Fprintf(errOut, "%s expects the %s or %s source\n", migrateCommand, hostLocalSource, unnamedNetworkSource)
+			commandFlags.Usage()
+			err = errInvalidArguments
+		}
+	case calcCommand:
+		ipRange := commandFlags.String("range", "", "the range, in CIDR notation.")
+		rangeStart := commandFlags.String("range-start", "", "the first IP of the range to allocate, if not the first usable one.")
+		rangeEnd := commandFlags.String("range-end", "", "the last IP of the range to allocate, if not the last usable one.")
+		sliceSize := commandFlags.String("slice", "", "the size of the node slices, e.g. /22.")
+		var excludes excludeFlag
+		commandFlags.Var(&excludes, "exclude", "a CIDR or IP excluded from the range; can be repeated or comma separated.")
+		if err = parseCommandFlags(commandFlags, args, 0); err == nil {
+			var start, end net.IP
+			err = requireFlag(commandFlags, "range", *ipRange)
+			if err == nil && *rangeStart != "" {
+				start, err = parseIP(*rangeStart)
+			}
+			if err == nil && *rangeEnd != "" {
+				end, err = parseIP(*rangeEnd)
+			}
+			if err == nil {
+				err = c.calc(*ipRange, start, end, excludes, *sliceSize)
+			}
+		}
+	default:
+		fmt.Fprintf(errOut, "unknown command %q\n", command)
+		fmt.Fprint(errOut, usage)
+		return invalidArguments
+	}
+
+	switch {
+	case err == errInvalidArguments:
+		return invalidArguments
+	case err != nil:
+		fmt.Fprintln(errOut, err)
+		return commandFailure
+	}
+	return 0
+}
+
+func parseCommandFlags(commandFlags *flag.FlagSet, args []string, expectedArgs int) error {
+	if err := commandFlags.Parse(args); err != nil {
+		return errInvalidArguments
+	}
+	if commandFlags.NArg() != expectedArgs {
+		fmt.Fprintf(commandFlags.Output(), "%s expects %d arguments, got %d\n", commandFlags.Name(), expectedArgs, commandFlags.NArg())
+		commandFlags.Usage()
+		return errInvalidArguments
+	}
+	return nil
+}
+
+func requireFlag(commandFlags *flag.FlagSet, name, value string) error {
+	if value == "" {
+		fmt.Fprintf(commandFlags.Output(), "%s requires -%s\n", commandFlags.Name(), name)
+		commandFlags.Usage()
+		return errInvalidArguments
+	}
+	return nil
+}
+
+func parseIP(arg string) (net.IP, error) {
+	ip := net.ParseIP(arg)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP %q", arg)
+	}
+	return ip, nil
+}
+
+func splitPodRef(podRef string) (string, string, error) {
+	namespacedName := strings.Split(podRef, "/")
+	if len(namespacedName) != 2 || namespacedName[0] == "" || namespacedName[1] == "" {
+		return "", "", fmt.Errorf("invalid pod reference %q, expected namespace/name", podRef)
+	}
+	return namespacedName[0], namespacedName[1], nil
+}
@@ -9,8 +9,11 @@ import (
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/controlloop"
 )
 
 // WaitForReplicaSetSteadyState only plays nice with the replicaSet it's being used with.
@@ -65,3 +68,47 @@ func isReplicaSetGone(ctx context.Context, cs *kubernetes.Clientset, rsName, nam
 		return false, nil
 	}
 }
+
+// AllocationsReleasedEvents returns the number of times the IP control loop notified that the IP addresses of the pods
+// of the replicaset scaled to zero are released, by event name: the repeated notifications are aggregated into the
+// count of their event.
+func AllocationsReleasedEvents(ctx context.Context, cs *kubernetes.Clientset, namespace, rsName string) (map[string]int32, error) {
+	events, err := cs.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.Set{
+			"involvedObject.kind": "ReplicaSet",
+			"involvedObject.name": rsName,
+			"reason":              controlloop.AllocationsReleasedReason,
+		}.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int32{}
+	for _, event := range events.Items {
+		counts[event.GetName()] = event.Count
+	}
+	return counts, nil
+}
+
+// WaitForAllocationsReleasedEvent waits up to timeout for the IP control loop to notify once more that the IP addresses
+// of the pods of the replicaset scaled to zero are released, beyond the notifications counted by
+// AllocationsReleasedEvents before scaling it. The replicaset must be selected by the -scale-to-zero-selector of the
+// control loop.
+func WaitForAllocationsReleasedEvent(ctx context.Context, cs *kubernetes.Clientset, namespace, rsName string, before map[string]int32, timeout time.Duration) error {
+	return wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, isAllocationsReleasedEventEmitted(ctx, cs, namespace, rsName, before))
+}
+
+func isAllocationsReleasedEventEmitted(ctx context.Context, cs *kubernetes.Clientset, namespace, rsName string, before map[string]int32) wait.ConditionWithContextFunc {
+	return func(context.Context) (bool, error) {
+		counts, err := AllocationsReleasedEvents(ctx, cs, namespace, rsName)
+		if err != nil {
+			return false, err
+		}
+		for name, count := range counts {
+			if count > before[name] {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
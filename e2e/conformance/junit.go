@@ -0,0 +1,71 @@
+package conformance
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Content string `xml:",chardata"`
+}
+
+// WriteJUnit renders the results as a JUnit XML report
+func WriteJUnit(w io.Writer, suiteName string, results []Result) error {
+	suite := junitTestSuite{Name: suiteName, Tests: len(results)}
+
+	var total time.Duration
+	for _, result := range results {
+		testCase := junitTestCase{
+			Name:      result.Name,
+			ClassName: suiteName,
+			Time:      seconds(result.Duration),
+		}
+		switch {
+		case result.Skipped:
+			suite.Skipped++
+			testCase.Skipped = &struct{}{}
+		case result.Err != nil:
+			suite.Failures++
+			testCase.Failure = &junitFailure{Message: result.Err.Error(), Content: result.Err.Error()}
+		}
+		total += result.Duration
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	suite.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
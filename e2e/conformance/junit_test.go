@@ -0,0 +1,50 @@
+package conformance
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	results := []Result{
+		{Name: "allocates an IP", Duration: 1500 * time.Millisecond},
+		{Name: "releases the IP", Duration: 250 * time.Millisecond, Err: errors.New("IP still allocated")},
+		{Name: "allocates from node slices", Skipped: true},
+	}
+
+	var report bytes.Buffer
+	if err := WriteJUnit(&report, "whereabouts-conformance", results); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.HasPrefix(report.String(), xml.Header) {
+		t.Errorf("Expected the report to start with the XML header, got %q", report.String())
+	}
+
+	var suite junitTestSuite
+	if err := xml.Unmarshal(report.Bytes(), &suite); err != nil {
+		t.Fatalf("Expected a valid XML report, got %v", err)
+	}
+	if suite.Name != "whereabouts-conformance" || suite.Tests != 3 || suite.Failures != 1 || suite.Skipped != 1 ||
+		suite.Time != "1.750" {
+		t.Errorf("Unexpected test suite attributes: %+v", suite)
+	}
+	if len(suite.Cases) != 3 {
+		t.Fatalf("Expected 3 test cases, got %d", len(suite.Cases))
+	}
+
+	passed, failed, skipped := suite.Cases[0], suite.Cases[1], suite.Cases[2]
+	if passed.Name != "allocates an IP" || passed.ClassName != "whereabouts-conformance" || passed.Time != "1.500" ||
+		passed.Failure != nil || passed.Skipped != nil {
+		t.Errorf("Unexpected passed test case: %+v", passed)
+	}
+	if failed.Failure == nil || failed.Failure.Message != "IP still allocated" || failed.Failure.Content != "IP still allocated" {
+		t.Errorf("Expected the failure of the test case to be reported, got %+v", failed)
+	}
+	if skipped.Skipped == nil || skipped.Failure != nil {
+		t.Errorf("Expected the test case to be reported as skipped, got %+v", skipped)
+	}
+}
@@ -0,0 +1,72 @@
+// Package conformance packages the key whereabouts end-to-end scenarios so they
+// can be run against any cluster, without the ginkgo / gomega test machinery.
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	wbtestclient "github.com/k8snetworkplumbingwg/whereabouts/e2e/client"
+)
+
+// Environment holds the cluster access and the settings shared by all scenarios
+type Environment struct {
+	ClientInfo *wbtestclient.ClientInfo
+	// Namespace is where the test pods and network-attachment-definitions are created
+	Namespace string
+	// IPPoolNamespace is the namespace of the whereabouts custom resources
+	IPPoolNamespace string
+	// IPRange is the range from which the scenarios allocate addresses
+	IPRange string
+}
+
+// Scenario is a single conformance check
+type Scenario struct {
+	Name string
+	Run  func(ctx context.Context, env *Environment) error
+}
+
+// Result is the outcome of a scenario run
+type Result struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+	Skipped  bool
+}
+
+// Passed reports whether the scenario ran successfully
+func (r Result) Passed() bool {
+	return !r.Skipped && r.Err == nil
+}
+
+// Run executes the scenarios whose names match the focus expression, one after
+// the other. Scenarios not matching it are reported as skipped.
+func Run(ctx context.Context, env *Environment, scenarios []Scenario, focus *regexp.Regexp) []Result {
+	results := make([]Result, 0, len(scenarios))
+	for _, scenario := range scenarios {
+		if focus != nil && !focus.MatchString(scenario.Name) {
+			results = append(results, Result{Name: scenario.Name, Skipped: true})
+			continue
+		}
+
+		start := time.Now()
+		err := runScenario(ctx, env, scenario)
+		results = append(results, Result{
+			Name:     scenario.Name,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+	}
+	return results
+}
+
+func runScenario(ctx context.Context, env *Environment, scenario Scenario) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("scenario panicked: %v", r)
+		}
+	}()
+	return scenario.Run(ctx, env)
+}
@@ -0,0 +1,218 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/e2e/entities"
+	"github.com/k8snetworkplumbingwg/whereabouts/e2e/retrievers"
+	"github.com/k8snetworkplumbingwg/whereabouts/e2e/util"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbstorage "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+const (
+	allocationPollInterval = 500 * time.Millisecond
+	allocationTimeout      = 30 * time.Second
+	replicaSetTimeout      = 5 * time.Minute
+)
+
+// Scenarios returns the conformance scenarios, in execution order
+func Scenarios() []Scenario {
+	return []Scenario{
+		{Name: "allocates and releases the address of a single pod", Run: singlePodAllocation},
+		{Name: "allocates one address per interface of a pod", Run: multipleInterfacesAllocation},
+		{Name: "releases the addresses of a scaled down replicaset", Run: replicaSetScaleDown},
+	}
+}
+
+func singlePodAllocation(ctx context.Context, env *Environment) error {
+	const (
+		networkName = "wa-conformance-single"
+		podName     = "wa-conformance-single"
+	)
+
+	return withNetwork(env, networkName, func() error {
+		pod, err := env.ClientInfo.ProvisionPod(podName, env.Namespace, util.PodTierLabel(podName), entities.PodNetworkSelectionElements(networkName))
+		if err != nil {
+			return fmt.Errorf("failed to provision pod: %w", err)
+		}
+
+		ips, err := retrievers.SecondaryIfaceIPValue(pod, "net1")
+		if err == nil && len(ips) == 0 {
+			err = fmt.Errorf("no IP found for interface net1")
+		}
+		if err != nil {
+			_ = env.ClientInfo.DeletePod(pod)
+			return err
+		}
+		if err := util.InRange(env.IPRange, ips[0]); err != nil {
+			_ = env.ClientInfo.DeletePod(pod)
+			return err
+		}
+		if err := verifyAllocation(ctx, env, networkName, ips[0], podRef(env.Namespace, podName), "net1"); err != nil {
+			_ = env.ClientInfo.DeletePod(pod)
+			return err
+		}
+
+		if err := env.ClientInfo.DeletePod(pod); err != nil {
+			return fmt.Errorf("failed to delete pod: %w", err)
+		}
+		return waitForNoAllocations(ctx, env, networkName)
+	})
+}
+
+func multipleInterfacesAllocation(ctx context.Context, env *Environment) error {
+	const (
+		networkName = "wa-conformance-multi"
+		podName     = "wa-conformance-multi"
+	)
+
+	return withNetwork(env, networkName, func() error {
+		pod, err := env.ClientInfo.ProvisionPod(podName, env.Namespace, util.PodTierLabel(podName), entities.PodNetworkSelectionElements(networkName, networkName, networkName))
+		if err != nil {
+			return fmt.Errorf("failed to provision pod: %w", err)
+		}
+
+		seen := map[string]struct{}{}
+		for _, ifName := range []string{"net1", "net2", "net3"} {
+			ips, err := retrievers.SecondaryIfaceIPValue(pod, ifName)
+			if err == nil && len(ips) == 0 {
+				err = fmt.Errorf("no IP found for interface %s", ifName)
+			}
+			if err == nil {
+				if _, duplicate := seen[ips[0]]; duplicate {
+					err = fmt.Errorf("IP %s assigned to more than one interface", ips[0])
+				}
+				seen[ips[0]] = struct{}{}
+			}
+			if err == nil {
+				err = verifyAllocation(ctx, env, networkName, ips[0], podRef(env.Namespace, podName), ifName)
+			}
+			if err != nil {
+				_ = env.ClientInfo.DeletePod(pod)
+				return err
+			}
+		}
+
+		if err := env.ClientInfo.DeletePod(pod); err != nil {
+			return fmt.Errorf("failed to delete pod: %w", err)
+		}
+		return waitForNoAllocations(ctx, env, networkName)
+	})
+}
+
+func replicaSetScaleDown(ctx context.Context, env *Environment) error {
+	const (
+		networkName = "wa-conformance-rs"
+		rsName      = "wa-conformance-rs"
+		replicas    = 5
+	)
+
+	return withNetwork(env, networkName, func() error {
+		replicaSet, err := env.ClientInfo.ProvisionReplicaSet(rsName, env.Namespace, replicas, util.PodTierLabel(rsName), entities.PodNetworkSelectionElements(networkName))
+		if err != nil {
+			return fmt.Errorf("failed to provision replicaset: %w", err)
+		}
+
+		if err := waitForAllocations(ctx, env, networkName, replicas); err != nil {
+			_ = env.ClientInfo.DeleteReplicaSet(replicaSet)
+			return err
+		}
+
+		if err := env.ClientInfo.DeleteReplicaSet(replicaSet); err != nil {
+			return fmt.Errorf("failed to delete replicaset: %w", err)
+		}
+		return waitForNoAllocations(ctx, env, networkName)
+	})
+}
+
+func withNetwork(env *Environment, networkName string, f func() error) error {
+	netAttachDef := util.MacvlanNetworkWithWhereaboutsIPAMNetwork(networkName, env.Namespace, env.IPRange, []string{}, networkName, true)
+	if _, err := env.ClientInfo.AddNetAttachDef(netAttachDef); err != nil {
+		return fmt.Errorf("failed to create network-attachment-definition: %w", err)
+	}
+
+	err := f()
+	if delErr := env.ClientInfo.DelNetAttachDef(netAttachDef); delErr != nil && err == nil {
+		err = fmt.Errorf("failed to delete network-attachment-definition: %w", delErr)
+	}
+	return err
+}
+
+func verifyAllocation(ctx context.Context, env *Environment, networkName, ip, podRef, ifName string) error {
+	pool, err := ipPool(ctx, env, networkName)
+	if err != nil {
+		return err
+	}
+
+	var found bool
+	for _, allocation := range pool.Spec.Allocations {
+		if allocation.PodRef == podRef && allocation.IfName == ifName {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("no allocation found in pool %s for pod %s iface %s", pool.GetName(), podRef, ifName)
+	}
+
+	overlapping, err := env.ClientInfo.WbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(env.IPPoolNamespace).Get(
+		ctx, wbstorage.NormalizeIP(net.ParseIP(ip), networkName), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get the overlapping range reservation for IP %s: %w", ip, err)
+	}
+	if overlapping.Spec.PodRef != podRef || overlapping.Spec.IfName != ifName {
+		return fmt.Errorf("overlapping range reservation for IP %s belongs to %s iface %s", ip, overlapping.Spec.PodRef, overlapping.Spec.IfName)
+	}
+	return nil
+}
+
+func waitForAllocations(ctx context.Context, env *Environment, networkName string, expected int) error {
+	var current int
+	err := wait.PollUntilContextTimeout(ctx, allocationPollInterval, replicaSetTimeout, true, func(ctx context.Context) (bool, error) {
+		pool, err := ipPool(ctx, env, networkName)
+		if err != nil {
+			return false, nil
+		}
+		current = len(pool.Spec.Allocations)
+		return current == expected, nil
+	})
+	if err != nil {
+		return fmt.Errorf("expected %d allocations, found %d: %w", expected, current, err)
+	}
+	return nil
+}
+
+func waitForNoAllocations(ctx context.Context, env *Environment, networkName string) error {
+	var current int
+	err := wait.PollUntilContextTimeout(ctx, allocationPollInterval, allocationTimeout, true, func(ctx context.Context) (bool, error) {
+		pool, err := ipPool(ctx, env, networkName)
+		if err != nil && errors.IsNotFound(err) {
+			return true, nil
+		} else if err != nil {
+			return false, nil
+		}
+		current = len(pool.Spec.Allocations)
+		return current == 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("found %d stale allocations: %w", current, err)
+	}
+	return nil
+}
+
+func ipPool(ctx context.Context, env *Environment, networkName string) (*v1alpha1.IPPool, error) {
+	poolName := wbstorage.IPPoolName(wbstorage.PoolIdentifier{IpRange: env.IPRange, NetworkName: networkName})
+	return env.ClientInfo.WbClient.WhereaboutsV1alpha1().IPPools(env.IPPoolNamespace).Get(ctx, poolName, metav1.GetOptions{})
+}
+
+func podRef(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
@@ -3,9 +3,12 @@ package e2e_node_slice
 
 import (
 	"context"
-	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	"fmt"
+	"net"
 	"time"
 
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+
 	. "github.com/onsi/ginkgo"
 	"github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
@@ -104,6 +107,20 @@ var _ = Describe("Whereabouts node slice functionality", func() {
 				Expect(secondaryIfaceIPs).NotTo(BeEmpty())
 				Expect(util.InNodeRange(clientInfo, pod.Spec.NodeName, testNetworkName, testNamespace, secondaryIfaceIPs[0])).To(Succeed())
 			})
+
+			It("reserves the pod IP cluster wide, under the network name", func() {
+				const ipPoolNamespace = "kube-system"
+
+				secondaryIfaceIPs, err := retrievers.SecondaryIfaceIPValue(pod, "net1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(secondaryIfaceIPs).NotTo(BeEmpty())
+
+				By("checking the overlapping range reservation belongs to the pod")
+				reservation, err := clientInfo.WbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(ipPoolNamespace).Get(
+					context.TODO(), wbstorage.NormalizeIP(net.ParseIP(secondaryIfaceIPs[0]), testNetworkName), metav1.GetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(reservation.Spec.PodRef).To(Equal(fmt.Sprintf("%s/%s", pod.GetNamespace(), pod.GetName())))
+			})
 		})
 
 		Context("Replicaset tests node slice", func() {
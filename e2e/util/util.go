@@ -100,6 +100,16 @@ func CheckZeroIPPoolAllocationsAndReplicas(ctx context.Context, clientInfo *wbte
 	)
 	var err error
 
+	// the control loop notifies the release of the IP addresses of the pods the replicaset had
+	hadReplicas := false
+	if previous, err := clientInfo.Client.AppsV1().ReplicaSets(namespace).Get(ctx, rsName, metav1.GetOptions{}); err == nil {
+		hadReplicas = previous.Status.Replicas > 0
+	}
+	releasedEvents, err := wbtestclient.AllocationsReleasedEvents(ctx, clientInfo.Client, namespace, rsName)
+	if err != nil {
+		return err
+	}
+
 	replicaSet, err := clientInfo.UpdateReplicaSet(
 		entities.ReplicaSetObject(
 			emptyReplicaSet,
@@ -117,6 +127,9 @@ func CheckZeroIPPoolAllocationsAndReplicas(ctx context.Context, clientInfo *wbte
 		return err
 	}
 
+	if hadReplicas {
+		return wbtestclient.WaitForAllocationsReleasedEvent(ctx, clientInfo.Client, namespace, rsName, releasedEvents, zeroIPPoolTimeout)
+	}
 	if k8sIPAM.Config.NodeSliceSize == "" {
 		if err = wbtestclient.WaitForZeroIPPoolAllocations(ctx, k8sIPAM, ipPoolCIDR, zeroIPPoolTimeout); err != nil {
 			return err
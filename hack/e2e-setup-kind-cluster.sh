@@ -98,10 +98,13 @@ trap "rm /tmp/whereabouts-img.tar || true" EXIT
 kind load image-archive --name "$KIND_CLUSTER_NAME" /tmp/whereabouts-img.tar
 
 echo "## install whereabouts"
-for file in "daemonset-install.yaml" "whereabouts.cni.cncf.io_ippools.yaml" "whereabouts.cni.cncf.io_overlappingrangeipreservations.yaml" "whereabouts.cni.cncf.io_nodeslicepools.yaml"; do
+for file in "daemonset-install.yaml" "whereabouts.cni.cncf.io_ippools.yaml" "whereabouts.cni.cncf.io_overlappingrangeipreservations.yaml" "whereabouts.cni.cncf.io_nodeslicepools.yaml" "whereabouts.cni.cncf.io_floatingipclaims.yaml" "whereabouts.cni.cncf.io_whereaboutsconfigs.yaml"; do
   # insert 'imagePullPolicy: Never' under the container 'image' so it is certain that the image used
-  # by the daemonset is the one loaded into KinD and not one pulled from a repo
-  sed '/        image:/a\        imagePullPolicy: Never' "$ROOT/doc/crds/$file" | retry kubectl apply -f -
+  # by the daemonset is the one loaded into KinD and not one pulled from a repo. The control loop notifies
+  # the release of the IP addresses of the test replicasets - labeled with their tier - once scaled to zero.
+  sed -e '/        image:/a\        imagePullPolicy: Never' \
+    -e 's|/ip-control-loop -log-level debug|/ip-control-loop -log-level debug -scale-to-zero-selector tier|' \
+    "$ROOT/doc/crds/$file" | retry kubectl apply -f -
 done
 # deployment has an extra tab for the sed so doing out of the loop
 sed '/          image:/a\          imagePullPolicy: Never' "$ROOT/doc/crds/node-slice-controller.yaml" | retry kubectl apply -f -
@@ -1,52 +1,392 @@
 package allocate
 
 import (
+	crand "crypto/rand"
+	"encoding/binary"
 	"fmt"
+	"math"
+	"math/big"
+	"math/rand/v2"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
 )
 
+// maxSkipTraceLength bounds the number of skipped spans recorded while iterating for an assignment
+const maxSkipTraceLength = 32
+
+const (
+	// randomIIDDraws bounds the random interface identifiers drawn before settling for the free IP following the last one
+	randomIIDDraws = 16
+	// firstAnycastIID and lastAnycastIID delimit the interface identifiers reserved for the subnet anycast addresses
+	// (RFC 5453)
+	firstAnycastIID = 0xfdffffffffffff80
+	lastAnycastIID  = 0xfdffffffffffffff
+)
+
 // AssignmentError defines an IP assignment error.
 type AssignmentError struct {
 	firstIP       net.IP
 	lastIP        net.IP
 	ipnet         net.IPNet
 	excludeRanges []string
+	skipped       *skipTrace
 }
 
 func (a AssignmentError) Error() string {
-	return fmt.Sprintf("Could not allocate IP in range: ip: %v / - %v / range: %s / excludeRanges: %v",
+	msg := fmt.Sprintf("Could not allocate IP in range: ip: %v / - %v / range: %s / excludeRanges: %v",
 		a.firstIP, a.lastIP, a.ipnet.String(), a.excludeRanges)
+	if a.skipped != nil && len(a.skipped.spans) > 0 {
+		msg += fmt.Sprintf(" / skipped: %s", a.skipped)
+	}
+	return msg
+}
+
+// Skipped returns why the candidate IPs of the range were passed over. It is only recorded when logging at the debug
+// level, and bounded: the spans beyond the bound are left out.
+func (a AssignmentError) Skipped() []SkippedSpan {
+	if a.skipped == nil {
+		return nil
+	}
+	return a.skipped.spans
+}
+
+// SkippedSpan is a span of consecutive candidate IPs passed over for the same reason.
+type SkippedSpan struct {
+	First  net.IP
+	Last   net.IP
+	Reason string
+}
+
+func (s SkippedSpan) String() string {
+	if s.First.Equal(s.Last) {
+		return fmt.Sprintf("%s (%s)", s.First, s.Reason)
+	}
+	return fmt.Sprintf("%s-%s (%s)", s.First, s.Last, s.Reason)
 }
 
-// AssignIP assigns an IP using a range and a reserve list.
-func AssignIP(ipamConf types.RangeConfiguration, reservelist []types.IPReservation, containerID, podRef, ifName string) (net.IPNet, []types.IPReservation, error) {
+const (
+	skipReasonReserved    = "reserved"
+	skipReasonBeforeStart = "before range_start"
+	skipReasonAfterEnd    = "after range_end"
+)
+
+// skipTrace records the bounded trace of the candidate IPs skipped while iterating for an assignment. A nil trace
+// records nothing.
+type skipTrace struct {
+	spans   []SkippedSpan
+	omitted int
+}
+
+// newSkipTrace returns a trace when logging at the debug level, nil otherwise
+func newSkipTrace() *skipTrace {
+	if logging.GetLoggingLevel() < logging.DebugLevel {
+		return nil
+	}
+	return &skipTrace{}
+}
+
+// add records the skipped span, merging it into the previous one when they are contiguous and share their reason
+func (t *skipTrace) add(first, last net.IP, reason string) {
+	if t == nil {
+		return
+	}
+	if n := len(t.spans); n > 0 && t.spans[n-1].Reason == reason && iphelpers.IncIP(t.spans[n-1].Last).Equal(first) {
+		t.spans[n-1].Last = last
+		return
+	}
+	if len(t.spans) == maxSkipTraceLength {
+		t.omitted++
+		return
+	}
+	t.spans = append(t.spans, SkippedSpan{First: first, Last: last, Reason: reason})
+}
 
-	// Setup the basics here.
-	_, ipnet, _ := net.ParseCIDR(ipamConf.Range)
+func (t *skipTrace) String() string {
+	spans := make([]string, 0, len(t.spans))
+	for _, span := range t.spans {
+		spans = append(spans, span.String())
+	}
+	trace := strings.Join(spans, ", ")
+	if t.omitted > 0 {
+		trace += fmt.Sprintf(" and %d more", t.omitted)
+	}
+	return trace
+}
+
+// ForeignRangeError is returned when an IP belongs to a range managed by another IPAM.
+type ForeignRangeError struct {
+	ip           net.IP
+	foreignRange string
+}
+
+func (f ForeignRangeError) Error() string {
+	return fmt.Sprintf("refusing to allocate IP %s: it belongs to the foreign range %s", f.ip, f.foreignRange)
+}
+
+// CheckForeignRanges returns a ForeignRangeError when the IP belongs to one of the foreign ranges.
+func CheckForeignRanges(ip net.IP, foreignRanges []string) error {
+	for _, foreignRange := range foreignRanges {
+		_, foreignNet, err := net.ParseCIDR(foreignRange)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR in foreign ranges %s: %w", foreignRange, err)
+		}
+		if foreignNet.Contains(ip) {
+			return ForeignRangeError{ip: ip, foreignRange: foreignRange}
+		}
+	}
+	return nil
+}
+
+// Reasons a requested IP is refused
+const (
+	RequestedIPOutsideRange   = "it is outside the range"
+	RequestedIPExcluded       = "it is excluded"
+	RequestedIPReserved       = "it is already reserved"
+	RequestedIPSameRangeTwice = "another requested IP belongs to the same range"
+)
+
+// RequestedIPError is returned when a requested IP cannot be assigned.
+type RequestedIPError struct {
+	ip     net.IP
+	reason string
+}
+
+func (r RequestedIPError) Error() string {
+	return fmt.Sprintf("refusing to assign the requested IP %s: %s", r.ip, r.reason)
+}
+
+// Reason returns why the requested IP is refused, one of the RequestedIP reasons.
+func (r RequestedIPError) Reason() string {
+	return r.reason
+}
+
+// CheckRequestedIPs returns a RequestedIPError unless each requested IP belongs to its own range.
+func CheckRequestedIPs(ipRanges []types.RangeConfiguration, requestedIPs []net.IP) error {
+	requestedRanges := map[string]bool{}
+	for _, ip := range requestedIPs {
+		ipRange, found := requestedIPRange(ipRanges, ip)
+		if !found {
+			return RequestedIPError{ip: ip, reason: RequestedIPOutsideRange}
+		}
+		if requestedRanges[ipRange] {
+			return RequestedIPError{ip: ip, reason: RequestedIPSameRangeTwice}
+		}
+		requestedRanges[ipRange] = true
+	}
+	return nil
+}
+
+// RequestedIP returns the requested IP belonging to the range, nil when none does.
+func RequestedIP(ipRange types.RangeConfiguration, requestedIPs []net.IP) net.IP {
+	for _, ip := range requestedIPs {
+		if _, found := requestedIPRange([]types.RangeConfiguration{ipRange}, ip); found {
+			return ip
+		}
+	}
+	return nil
+}
+
+func requestedIPRange(ipRanges []types.RangeConfiguration, ip net.IP) (string, bool) {
+	for _, ipRange := range ipRanges {
+		if ipRange.Contains(ip) {
+			return ipRange.Range, true
+		}
+	}
+	return "", false
+}
+
+// AssignIP assigns an IP using a range and a reserve list: the requested IP when set, or else a free IP of the range
+// picked by its allocation strategy. released holds the times the free IPs of the range were last released, keyed by
+// IP, which the lru strategy allocates the least recently released of. The allocation of the podRef and ifName is
+// reused - e.g. the one pre-reserved for the pod, see types.PreReservationContainerID, which its CNI ADD claims - save
+// the allocation of a floating IP claim held by the pod, which the claim keeps. When podUID is set, it is only reused
+// by the pod of that UID: a pod of the same name but another UID, e.g. one recreated on another node while its
+// predecessor still runs, gets a new IP. When mac is set, the allocation recording that MAC address is handed over to
+// the pod interface - e.g. that of a virtual machine whose pod was recreated, the caller verifying the previous pod is
+// gone - and the MAC address is recorded on the new allocations. The preferred IP, e.g. the one the pod held before being rescheduled,
+// is assigned when usable, in place of the one the allocation strategy picks. The CIDRs of a range set are allocated
+// from in order, the next one once the previous is exhausted.
+func AssignIP(ipamConf types.RangeConfiguration, reservelist []types.IPReservation, released map[string]time.Time, containerID, podRef, podUID, ifName, mac string, requestedIP, preferredIP net.IP) (net.IPNet, []types.IPReservation, error) {
 
 	// Verify if podRef and ifName have already an allocation.
 	for i, r := range reservelist {
 		if r.PodRef == podRef && r.IfName == ifName {
+			if types.IsFloatingIPClaim(r.ContainerID) {
+				logging.Debugf("IP %s allocated for podRef: %q - ifName: %q is a floating IP claim, not reused", r.IP.String(), podRef, ifName)
+				continue
+			}
+			if podUID != "" && r.PodUID != "" && r.PodUID != podUID {
+				logging.Debugf("IP %s allocated for podRef: %q - ifName: %q belongs to pod UID %q, not %q",
+					r.IP.String(), podRef, ifName, r.PodUID, podUID)
+				continue
+			}
 			logging.Debugf("IP already allocated for podRef: %q - ifName:%q - IP: %s", podRef, ifName, r.IP.String())
-			if r.ContainerID != containerID {
+			// a pre-reservation never takes over the allocation its pod already claimed
+			if r.ContainerID != containerID && !types.IsPreReservation(containerID) {
 				logging.Debugf("updating container ID: %q", containerID)
 				reservelist[i].ContainerID = containerID
 			}
+			if podUID != "" && r.PodUID == "" {
+				reservelist[i].PodUID = podUID
+			}
+
+			return rangeIPNet(ipamConf, r.IP), reservelist, nil
+		}
+	}
+
+	// Verify if the MAC address has an allocation, held by the previous pod of the interface.
+	if idx := macReservationIndex(reservelist, mac); idx >= 0 {
+		r := &reservelist[idx]
+		logging.Debugf("IP %s reserved for MAC %s: handing it over from podRef: %q - ifName: %q to podRef: %q - ifName: %q",
+			r.IP.String(), mac, r.PodRef, r.IfName, podRef, ifName)
+		r.ContainerID = containerID
+		r.PodRef = podRef
+		r.PodUID = podUID
+		r.IfName = ifName
+		r.AllocatedAt = time.Time{}
+		return rangeIPNet(ipamConf, r.IP), reservelist, nil
+	}
+
+	if requestedIP != nil {
+		updatedreservelist, err := assignRequestedIP(ipamConf, reservelist, requestedIP, containerID, podRef, podUID, ifName)
+		if err != nil {
+			return net.IPNet{}, nil, err
+		}
+		return rangeIPNet(ipamConf, requestedIP), recordMAC(updatedreservelist, requestedIP, mac), nil
+	}
+
+	if preferredIP != nil {
+		updatedreservelist, err := assignRequestedIP(ipamConf, reservelist, preferredIP, containerID, podRef, podUID, ifName)
+		if err == nil {
+			return rangeIPNet(ipamConf, preferredIP), recordMAC(updatedreservelist, preferredIP, mac), nil
+		}
+		logging.Debugf("Preferred IP %s of podRef: %q - ifName: %q is not usable: %v", preferredIP, podRef, ifName, err)
+	}
+
+	subranges := ipamConf.Subranges()
+	for idx, subrange := range subranges {
+		subnet, err := subrange.Network()
+		if err != nil {
+			return net.IPNet{}, nil, err
+		}
+		newip, updatedreservelist, err := iterateForAssignment(subrange.AllocationStrategy, released, *subnet, subrange.RangeStart, subrange.RangeEnd,
+			reservelist, subrange.OmitRanges, containerID, podRef, podUID, ifName)
+		if _, exhausted := err.(AssignmentError); exhausted && idx < len(subranges)-1 {
+			logging.Debugf("Range %s of the range set is exhausted, trying the next one", subrange.Range)
+			continue
+		}
+		if err != nil {
+			return net.IPNet{}, nil, err
+		}
+		return net.IPNet{IP: newip, Mask: subnet.Mask}, recordMAC(updatedreservelist, newip, mac), nil
+	}
+	return net.IPNet{}, nil, fmt.Errorf("range %s has no CIDR to allocate from", ipamConf.Range)
+}
+
+// macReservationIndex returns the index of the allocation recording the MAC address, or -1 when none does
+func macReservationIndex(reservelist []types.IPReservation, mac string) int {
+	if mac == "" {
+		return -1
+	}
+	for i, r := range reservelist {
+		if !r.IsAllocated && r.MAC == mac {
+			return i
+		}
+	}
+	return -1
+}
 
-			return net.IPNet{IP: r.IP, Mask: ipnet.Mask}, reservelist, nil
+// recordMAC records the MAC address, when set, on the allocation of the IP newly assigned to its interface
+func recordMAC(reservelist []types.IPReservation, ip net.IP, mac string) []types.IPReservation {
+	if mac == "" {
+		return reservelist
+	}
+	for i := len(reservelist) - 1; i >= 0; i-- {
+		if !reservelist[i].IsAllocated && reservelist[i].IP.Equal(ip) {
+			reservelist[i].MAC = mac
+			break
 		}
 	}
+	return reservelist
+}
+
+// rangeIPNet returns the IP along with the mask of the network of the range, or of the CIDR of the range set it
+// belongs to
+func rangeIPNet(ipamConf types.RangeConfiguration, ip net.IP) net.IPNet {
+	ipnet, _ := ipamConf.Network()
+	if subrange, found := ipamConf.Subrange(ip); found {
+		ipnet, _ = subrange.Network()
+	}
+	return net.IPNet{IP: ip, Mask: ipnet.Mask}
+}
 
-	newip, updatedreservelist, err := IterateForAssignment(*ipnet, ipamConf.RangeStart, ipamConf.RangeEnd, reservelist, ipamConf.OmitRanges, containerID, podRef, ifName)
+// assignRequestedIP reserves the requested IP, provided it is usable: within the range - one of the CIDRs of a range
+// set - its start and end, neither excluded nor reserved.
+func assignRequestedIP(ipamConf types.RangeConfiguration, reservelist []types.IPReservation, requestedIP net.IP, containerID, podRef, podUID, ifName string) ([]types.IPReservation, error) {
+	subrange, found := ipamConf.Subrange(requestedIP)
+	if !found {
+		return nil, RequestedIPError{ip: requestedIP, reason: RequestedIPOutsideRange}
+	}
+	firstIP, lastIP, err := subrange.UsableRange()
 	if err != nil {
-		return net.IPNet{}, nil, err
+		return nil, err
+	}
+	if iphelpers.CompareIPs(requestedIP, firstIP) < 0 || iphelpers.CompareIPs(requestedIP, lastIP) > 0 {
+		return nil, RequestedIPError{ip: requestedIP, reason: RequestedIPOutsideRange}
+	}
+	for _, v := range ipamConf.OmitRanges {
+		subnet, err := iphelpers.ParseExcludedRange(v)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse exclude range, err: %q", err)
+		}
+		if subnet.Contains(requestedIP) {
+			return nil, RequestedIPError{ip: requestedIP, reason: RequestedIPExcluded}
+		}
+	}
+	for _, r := range reservelist {
+		if r.IP.Equal(requestedIP) {
+			return nil, RequestedIPError{ip: requestedIP, reason: RequestedIPReserved}
+		}
 	}
 
-	return net.IPNet{IP: newip, Mask: ipnet.Mask}, updatedreservelist, nil
+	logging.Debugf("Reserving requested IP: %q - container ID %q - podRef: %q - ifName: %q", requestedIP.String(), containerID, podRef, ifName)
+	return append(reservelist, types.IPReservation{IP: requestedIP, ContainerID: containerID, PodRef: podRef, PodUID: podUID, IfName: ifName}), nil
+}
+
+// ServiceIPs returns the first count usable IPs of the range, honoring its start, end and exclude ranges - those of
+// the first CIDRs of a range set. These are set aside for network services (e.g. gateways, VRRP) and never assigned
+// to pods.
+func ServiceIPs(ipamConf types.RangeConfiguration, count int) ([]net.IP, error) {
+	if count == 0 {
+		return nil, nil
+	}
+
+	var reservelist []types.IPReservation
+	serviceIPs := make([]net.IP, 0, count)
+	subranges := ipamConf.Subranges()
+	for idx, subrange := range subranges {
+		ipnet, err := subrange.Network()
+		if err != nil {
+			return nil, err
+		}
+		for len(serviceIPs) < count {
+			var ip net.IP
+			ip, reservelist, err = IterateForAssignment(*ipnet, subrange.RangeStart, subrange.RangeEnd, reservelist, subrange.OmitRanges, "", "", "", "")
+			if _, exhausted := err.(AssignmentError); exhausted && idx < len(subranges)-1 {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("could not reserve %d service IPs: %w", count, err)
+			}
+			serviceIPs = append(serviceIPs, ip)
+		}
+	}
+	return serviceIPs, nil
 }
 
 // DeallocateIP removes allocation from reserve list. Returns the updated reserve list and the deallocated IP.
@@ -63,6 +403,18 @@ func DeallocateIP(reservelist []types.IPReservation, containerID, ifName string)
 	return removeIdxFromSlice(reservelist, index), ip
 }
 
+// DeallocatePodIP removes the allocation of the container interface, as DeallocateIP does, provided it belongs to the
+// pod of the reference: the allocation of a container of another pod is left alone. An empty pod reference matches the
+// allocations of any pod.
+func DeallocatePodIP(reservelist []types.IPReservation, containerID, ifName, podRef string) ([]types.IPReservation, net.IP) {
+	index := getMatchingIPReservationIndex(reservelist, containerID, ifName)
+	if index >= 0 && podRef != "" && reservelist[index].PodRef != "" && reservelist[index].PodRef != podRef {
+		logging.Debugf("not deallocating IP %v of container %s: it belongs to pod %s, not %s", reservelist[index].IP, containerID, reservelist[index].PodRef, podRef)
+		return reservelist, nil
+	}
+	return DeallocateIP(reservelist, containerID, ifName)
+}
+
 func getMatchingIPReservationIndex(reservelist []types.IPReservation, id, ifName string) int {
 	for idx, v := range reservelist {
 		if v.ContainerID == id && v.IfName == ifName {
@@ -83,7 +435,15 @@ func removeIdxFromSlice(s []types.IPReservation, i int) []types.IPReservation {
 // If rangeEnd is specified, it is respected if it lies within the ipnet and if it is >= rangeStart.
 // reserveList holds a list of reserved IPs.
 // excludeRanges holds a list of subnets to be excluded (meaning the full subnet, including the network and broadcast IP).
-func IterateForAssignment(ipnet net.IPNet, rangeStart net.IP, rangeEnd net.IP, reserveList []types.IPReservation, excludeRanges []string, containerID, podRef, ifName string) (net.IP, []types.IPReservation, error) {
+// The lowest free IP is assigned.
+func IterateForAssignment(ipnet net.IPNet, rangeStart net.IP, rangeEnd net.IP, reserveList []types.IPReservation, excludeRanges []string, containerID, podRef, podUID, ifName string) (net.IP, []types.IPReservation, error) {
+	return iterateForAssignment(types.SequentialAllocation, nil, ipnet, rangeStart, rangeEnd, reserveList, excludeRanges, containerID, podRef, podUID, ifName)
+}
+
+// iterateForAssignment assigns the free IP of the range picked by the allocation strategy: the lowest one when
+// sequential, the first one from a random IP when random, the least recently released one when lru, and one of a
+// cryptographically random interface identifier when random-iid.
+func iterateForAssignment(strategy string, released map[string]time.Time, ipnet net.IPNet, rangeStart net.IP, rangeEnd net.IP, reserveList []types.IPReservation, excludeRanges []string, containerID, podRef, podUID, ifName string) (net.IP, []types.IPReservation, error) {
 	// Get the valid range, delimited by the ipnet's first and last usable IP as well as the rangeStart and rangeEnd.
 	firstIP, lastIP, err := iphelpers.GetIPRange(ipnet, rangeStart, rangeEnd)
 	if err != nil {
@@ -93,79 +453,178 @@ func IterateForAssignment(ipnet net.IPNet, rangeStart net.IP, rangeEnd net.IP, r
 	logging.Debugf("IterateForAssignment input >> range_start: %v | range_end: %v | ipnet: %v | first IP: %v | last IP: %v",
 		rangeStart, rangeEnd, ipnet.String(), firstIP, lastIP)
 
+	// Record why candidates are skipped, starting with those left out by the requested range.
+	skipped := newSkipTrace()
+	if skipped != nil {
+		if networkFirstIP, err := iphelpers.FirstUsableIP(ipnet); err == nil && iphelpers.CompareIPs(networkFirstIP, firstIP) < 0 {
+			skipped.add(networkFirstIP, iphelpers.DecIP(firstIP), skipReasonBeforeStart)
+		}
+	}
+
 	// Build reserved map.
 	reserved := make(map[string]bool)
 	for _, r := range reserveList {
 		reserved[r.IP.String()] = true
 	}
 
-	// Build excluded list, "192.168.2.229/30", "192.168.1.229/30".
+	// Build excluded list, "192.168.2.229/30", "192.168.1.229/30". The exclusions of the other IP family, e.g. those of
+	// the other half of a dual-stack configuration, cannot apply to this range.
 	excluded := []*net.IPNet{}
 	for _, v := range excludeRanges {
-		subnet, err := parseExcludedRange(v)
+		subnet, err := iphelpers.ParseExcludedRange(v)
 		if err != nil {
 			return net.IP{}, reserveList, fmt.Errorf("could not parse exclude range, err: %q", err)
 		}
+		if iphelpers.IsIPv4(subnet.IP) != iphelpers.IsIPv4(ipnet.IP) {
+			continue
+		}
 		excluded = append(excluded, subnet)
 	}
 
-	// Iterate over every IP address in the range, accounting for reserved IPs and exclude ranges. Make sure that ip is
-	// within ipnet, and make sure that ip is smaller than lastIP.
-	for ip := firstIP; ipnet.Contains(ip) && iphelpers.CompareIPs(ip, lastIP) <= 0; ip = iphelpers.IncIP(ip) {
+	var ip net.IP
+	switch strategy {
+	case types.RandomAllocation:
+		ip = randomFreeIP(ipnet, firstIP, lastIP, reserved, excluded, skipped)
+	case types.LRUAllocation:
+		ip = leastRecentlyReleasedFreeIP(ipnet, firstIP, lastIP, reserved, excluded, skipped, released)
+	case types.RandomIIDAllocation:
+		ip = randomIIDFreeIP(ipnet, firstIP, lastIP, reserved, excluded, skipped)
+	default:
+		ip = nextFreeIP(ipnet, firstIP, lastIP, reserved, excluded, skipped, nil)
+	}
+	if ip != nil {
+		// Assign and reserve the IP and return.
+		logging.Debugf("Reserving IP: %q - container ID %q - podRef: %q - ifName: %q", ip.String(), containerID, podRef, ifName)
+		reserveList = append(reserveList, types.IPReservation{IP: ip, ContainerID: containerID, PodRef: podRef, PodUID: podUID, IfName: ifName})
+		return ip, reserveList, nil
+	}
+
+	// No IP address for assignment found, return an error.
+	if skipped != nil {
+		if networkLastIP, err := iphelpers.LastUsableIP(ipnet); err == nil && iphelpers.CompareIPs(lastIP, networkLastIP) < 0 {
+			skipped.add(iphelpers.IncIP(lastIP), networkLastIP, skipReasonAfterEnd)
+		}
+	}
+	return net.IP{}, reserveList, AssignmentError{firstIP, lastIP, ipnet, excludeRanges, skipped}
+}
+
+// nextFreeIP iterates over the IPs from the given one up to lastIP, accounting for reserved IPs and exclude ranges, and
+// returns the first free IP accepted - any of them when accept is nil - or nil when there is none.
+func nextFreeIP(ipnet net.IPNet, from, lastIP net.IP, reserved map[string]bool, excluded []*net.IPNet, skipped *skipTrace, accept func(net.IP) bool) net.IP {
+	// Make sure that ip is within ipnet, and make sure that ip is smaller than lastIP.
+	for ip := from; ipnet.Contains(ip) && iphelpers.CompareIPs(ip, lastIP) <= 0; ip = iphelpers.IncIP(ip) {
 		// If already reserved, skip it.
 		if reserved[ip.String()] {
+			skipped.add(ip, ip, skipReasonReserved)
 			continue
 		}
 		// If this IP is within the range of one of the excluded subnets, jump to the exluded subnet's broadcast address
 		// and skip.
-		if skipTo := skipExcludedSubnets(ip, excluded); skipTo != nil {
+		if skipTo, subnet := skipExcludedSubnets(ip, excluded); skipTo != nil {
+			if iphelpers.CompareIPs(skipTo, lastIP) > 0 {
+				skipped.add(ip, lastIP, fmt.Sprintf("excluded by %s", subnet))
+			} else {
+				skipped.add(ip, skipTo, fmt.Sprintf("excluded by %s", subnet))
+			}
 			ip = skipTo
 			continue
 		}
-		// Assign and reserve the IP and return.
-		logging.Debugf("Reserving IP: %q - container ID %q - podRef: %q - ifName: %q", ip.String(), containerID, podRef, ifName)
-		reserveList = append(reserveList, types.IPReservation{IP: ip, ContainerID: containerID, PodRef: podRef, IfName: ifName})
-		return ip, reserveList, nil
+		if accept == nil || accept(ip) {
+			return ip
+		}
 	}
+	return nil
+}
 
-	// No IP address for assignment found, return an error.
-	return net.IP{}, reserveList, AssignmentError{firstIP, lastIP, ipnet, excludeRanges}
+// randomFreeIP returns the first free IP from a random IP of the range, wrapping around to the first IP of the range.
+// Spreading the allocations over the range keeps a just released IP from being handed out right away, while the
+// neighbors of the caches of the network still map it to the MAC address of its former owner.
+func randomFreeIP(ipnet net.IPNet, firstIP, lastIP net.IP, reserved map[string]bool, excluded []*net.IPNet, skipped *skipTrace) net.IP {
+	if span, err := iphelpers.IPGetOffset(lastIP, firstIP); err == nil && span > 0 {
+		offset := rand.Uint64()
+		if span < math.MaxUint64 {
+			offset = rand.Uint64N(span + 1)
+		}
+		if ip := nextFreeIP(ipnet, iphelpers.IPAddOffset(firstIP, offset), lastIP, reserved, excluded, nil, nil); ip != nil {
+			return ip
+		}
+	}
+	return nextFreeIP(ipnet, firstIP, lastIP, reserved, excluded, skipped, nil)
 }
 
-// skipExcludedSubnets iterates through all subnets and checks if ip is part of them. If i is part of one of the subnets,
-// return the subnet's broadcast address.
-func skipExcludedSubnets(ip net.IP, excluded []*net.IPNet) net.IP {
-	for _, subnet := range excluded {
-		if subnet.Contains(ip) {
-			broadcastIP := iphelpers.SubnetBroadcastIP(*subnet)
-			logging.Debugf("excluding %v and moving to the end of the excluded range: %v", subnet, broadcastIP)
-			return broadcastIP
+// randomIIDFreeIP returns a free IP of the range whose interface identifier is drawn by a cryptographically secure
+// generator, for the IPs of the pods not to be found by scanning the low interface identifiers. The interface
+// identifiers reserved for anycast addresses are never assigned. Once the draws keep hitting allocated or excluded IPs,
+// the free IP following the last one drawn is assigned.
+func randomIIDFreeIP(ipnet net.IPNet, firstIP, lastIP net.IP, reserved map[string]bool, excluded []*net.IPNet, skipped *skipTrace) net.IP {
+	notAnycast := func(ip net.IP) bool { return !anycastIID(ip) }
+	span, err := iphelpers.IPGetOffset(lastIP, firstIP)
+	if err != nil {
+		return nextFreeIP(ipnet, firstIP, lastIP, reserved, excluded, skipped, notAnycast)
+	}
+
+	from := firstIP
+	for draw := 0; draw < randomIIDDraws; draw++ {
+		offset, err := crand.Int(crand.Reader, new(big.Int).Add(new(big.Int).SetUint64(span), big.NewInt(1)))
+		if err != nil {
+			logging.Errorf("failed to draw a random interface identifier: %v", err)
+			break
 		}
+		from = iphelpers.IPAddOffset(firstIP, offset.Uint64())
+		if reserved[from.String()] || anycastIID(from) {
+			continue
+		}
+		if skipTo, _ := skipExcludedSubnets(from, excluded); skipTo != nil {
+			continue
+		}
+		return from
 	}
-	return nil
+	if ip := nextFreeIP(ipnet, from, lastIP, reserved, excluded, nil, notAnycast); ip != nil {
+		return ip
+	}
+	return nextFreeIP(ipnet, firstIP, lastIP, reserved, excluded, skipped, notAnycast)
 }
 
-// parseExcludedRange parses a provided string to a net.IPNet.
-// If the provided string is a valid CIDR, return the net.IPNet for that CIDR.
-// If the provided string is a valid IP address, add the /32 or /128 prefix to form the CIDR and return the net.IPNet.
-// Otherwise, return the error.
-func parseExcludedRange(s string) (*net.IPNet, error) {
-	// Try parsing CIDRs.
-	_, subnet, err := net.ParseCIDR(s)
-	if err == nil {
-		return subnet, nil
+// anycastIID tells whether the interface identifier of the IPv6 address is one of those reserved for the subnet
+// anycast addresses
+func anycastIID(ip net.IP) bool {
+	if iphelpers.IsIPv4(ip) {
+		return false
 	}
-	// The user might have given a single IP address, try parsing that - if it does not parse, return the error that
-	// we got earlier.
-	ip := net.ParseIP(s)
+	iid := binary.BigEndian.Uint64(ip.To16()[8:])
+	return iid >= firstAnycastIID && iid <= lastAnycastIID
+}
+
+// leastRecentlyReleasedFreeIP returns the lowest free IP which was never released, or else the free IP released the
+// longest ago.
+func leastRecentlyReleasedFreeIP(ipnet net.IPNet, firstIP, lastIP net.IP, reserved map[string]bool, excluded []*net.IPNet, skipped *skipTrace, released map[string]time.Time) net.IP {
+	var oldestIP net.IP
+	var oldestRelease time.Time
+	ip := nextFreeIP(ipnet, firstIP, lastIP, reserved, excluded, skipped, func(ip net.IP) bool {
+		releaseTime, wasReleased := released[ip.String()]
+		if !wasReleased {
+			return true
+		}
+		if oldestIP == nil || releaseTime.Before(oldestRelease) {
+			oldestIP, oldestRelease = ip, releaseTime
+		}
+		return false
+	})
 	if ip == nil {
-		return nil, err
+		return oldestIP
 	}
-	// If the address parses, check if it's IPv4 or IPv6 and add the correct prefix.
-	if ip.To4() != nil {
-		_, subnet, err = net.ParseCIDR(fmt.Sprintf("%s/32", s))
-	} else {
-		_, subnet, err = net.ParseCIDR(fmt.Sprintf("%s/128", s))
+	return ip
+}
+
+// skipExcludedSubnets iterates through all subnets and checks if ip is part of them. If i is part of one of the subnets,
+// return the subnet's broadcast address, along with the subnet.
+func skipExcludedSubnets(ip net.IP, excluded []*net.IPNet) (net.IP, *net.IPNet) {
+	for _, subnet := range excluded {
+		if subnet.Contains(ip) {
+			broadcastIP := iphelpers.SubnetBroadcastIP(*subnet)
+			logging.Debugf("excluding %v and moving to the end of the excluded range: %v", subnet, broadcastIP)
+			return broadcastIP, subnet
+		}
 	}
-	return subnet, err
+	return nil, nil
 }
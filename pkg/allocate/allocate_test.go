@@ -1,13 +1,18 @@
 package allocate
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"testing"
+	"time"
 
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -17,6 +22,139 @@ func TestAllocate(t *testing.T) {
 }
 
 var _ = Describe("Allocation operations", func() {
+	It("refuses the IPs belonging to a foreign range", func() {
+		foreignRanges := []string{"10.96.0.0/12", "10.244.0.0/16"}
+
+		Expect(CheckForeignRanges(net.ParseIP("192.168.1.1"), foreignRanges)).To(Succeed())
+		Expect(CheckForeignRanges(net.ParseIP("10.244.3.7"), foreignRanges)).To(
+			MatchError("refusing to allocate IP 10.244.3.7: it belongs to the foreign range 10.244.0.0/16"))
+	})
+
+	Context("assigning a requested IP", func() {
+		ipRange := types.RangeConfiguration{
+			Range:      "192.168.1.0/24",
+			RangeStart: net.ParseIP("192.168.1.10"),
+			OmitRanges: []string{"192.168.1.20/30"},
+		}
+		reservelist := []types.IPReservation{{IP: net.ParseIP("192.168.1.30"), PodRef: "default/other"}}
+
+		It("assigns the requested IP", func() {
+			newip, updatedreservelist, err := AssignIP(ipRange, reservelist, nil, "0xdeadbeef", "default/pod", "", "net1", "", net.ParseIP("192.168.1.53"), nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newip.String()).To(Equal("192.168.1.53/24"))
+			Expect(updatedreservelist).To(HaveLen(2))
+			Expect(updatedreservelist[1].PodRef).To(Equal("default/pod"))
+		})
+
+		DescribeTable("refuses the IPs which cannot be assigned",
+			func(requestedIP string, reason string) {
+				_, _, err := AssignIP(ipRange, reservelist, nil, "0xdeadbeef", "default/pod", "", "net1", "", net.ParseIP(requestedIP), nil)
+
+				var requestedIPErr RequestedIPError
+				Expect(errors.As(err, &requestedIPErr)).To(BeTrue())
+				Expect(requestedIPErr.Reason()).To(Equal(reason))
+			},
+			Entry("before range_start", "192.168.1.5", RequestedIPOutsideRange),
+			Entry("the broadcast address", "192.168.1.255", RequestedIPOutsideRange),
+			Entry("excluded", "192.168.1.21", RequestedIPExcluded),
+			Entry("reserved", "192.168.1.30", RequestedIPReserved),
+		)
+
+		It("keeps the IP already allocated to the pod interface", func() {
+			allocated := append(reservelist, types.IPReservation{IP: net.ParseIP("192.168.1.40"), PodRef: "default/pod", IfName: "net1"})
+			newip, _, err := AssignIP(ipRange, allocated, nil, "0xdeadbeef", "default/pod", "", "net1", "", net.ParseIP("192.168.1.53"), nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newip.IP.String()).To(Equal("192.168.1.40"))
+		})
+
+		It("does not hand the IP allocated to a pod over to another pod of the same name", func() {
+			allocated := append(reservelist, types.IPReservation{IP: net.ParseIP("192.168.1.40"), PodRef: "default/pod", PodUID: "uid-1", IfName: "net1"})
+			newip, updatedreservelist, err := AssignIP(ipRange, allocated, nil, "0xdeadbeef", "default/pod", "uid-2", "net1", "", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newip.IP.String()).To(Equal("192.168.1.10"))
+			Expect(updatedreservelist).To(HaveLen(3))
+			Expect(updatedreservelist[2].PodUID).To(Equal("uid-2"))
+		})
+
+		It("keeps the IP allocated to the pod of the same UID", func() {
+			for _, storedUID := range []string{"uid-1", ""} {
+				allocated := append([]types.IPReservation{}, reservelist...)
+				allocated = append(allocated, types.IPReservation{IP: net.ParseIP("192.168.1.40"), PodRef: "default/pod", PodUID: storedUID, IfName: "net1"})
+				newip, updatedreservelist, err := AssignIP(ipRange, allocated, nil, "0xdeadbeef", "default/pod", "uid-1", "net1", "", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(newip.IP.String()).To(Equal("192.168.1.40"))
+				Expect(updatedreservelist[1].PodUID).To(Equal("uid-1"))
+			}
+		})
+
+		It("claims the IP pre-reserved for the pod interface", func() {
+			allocated := append([]types.IPReservation{}, reservelist...)
+			allocated = append(allocated, types.IPReservation{IP: net.ParseIP("192.168.1.40"), ContainerID: types.PreReservationContainerID("uid-1"), PodRef: "default/pod", PodUID: "uid-1", IfName: "net1"})
+			newip, updatedreservelist, err := AssignIP(ipRange, allocated, nil, "0xdeadbeef", "default/pod", "uid-1", "net1", "", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newip.IP.String()).To(Equal("192.168.1.40"))
+			Expect(updatedreservelist).To(HaveLen(2))
+			Expect(updatedreservelist[1].ContainerID).To(Equal("0xdeadbeef"))
+		})
+
+		It("does not pre-reserve over the IP the pod interface already claimed", func() {
+			allocated := append([]types.IPReservation{}, reservelist...)
+			allocated = append(allocated, types.IPReservation{IP: net.ParseIP("192.168.1.40"), ContainerID: "0xdeadbeef", PodRef: "default/pod", PodUID: "uid-1", IfName: "net1"})
+			newip, updatedreservelist, err := AssignIP(ipRange, allocated, nil, types.PreReservationContainerID("uid-1"), "default/pod", "uid-1", "net1", "", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newip.IP.String()).To(Equal("192.168.1.40"))
+			Expect(updatedreservelist[1].ContainerID).To(Equal("0xdeadbeef"))
+		})
+
+		It("does not reuse the floating IP claimed for the pod interface", func() {
+			allocated := append([]types.IPReservation{}, reservelist...)
+			allocated = append(allocated, types.IPReservation{IP: net.ParseIP("192.168.1.40"), ContainerID: types.FloatingIPClaimContainerID("default", "vip"), PodRef: "default/pod", IfName: "net1"})
+			newip, updatedreservelist, err := AssignIP(ipRange, allocated, nil, "0xdeadbeef", "default/pod", "uid-1", "net1", "", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newip.IP.String()).To(Equal("192.168.1.10"))
+			Expect(updatedreservelist).To(HaveLen(3))
+			Expect(updatedreservelist[1].ContainerID).To(Equal(types.FloatingIPClaimContainerID("default", "vip")))
+		})
+
+		It("hands the IP allocated to a MAC address over to the pod interface of that MAC address", func() {
+			const mac = "0a:58:c0:a8:01:28"
+			allocated := append([]types.IPReservation{}, reservelist...)
+			allocated = append(allocated, types.IPReservation{IP: net.ParseIP("192.168.1.40"), ContainerID: "0xfeedbeef", PodRef: "default/vm-1", PodUID: "uid-1", IfName: "net1", MAC: mac})
+			newip, updatedreservelist, err := AssignIP(ipRange, allocated, nil, "0xdeadbeef", "default/vm-2", "uid-2", "net1", mac, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newip.IP.String()).To(Equal("192.168.1.40"))
+			Expect(updatedreservelist).To(HaveLen(2))
+			Expect(updatedreservelist[1]).To(Equal(types.IPReservation{IP: net.ParseIP("192.168.1.40"), ContainerID: "0xdeadbeef", PodRef: "default/vm-2", PodUID: "uid-2", IfName: "net1", MAC: mac}))
+		})
+
+		It("records the MAC address on the IP newly allocated to the pod interface", func() {
+			newip, updatedreservelist, err := AssignIP(ipRange, reservelist, nil, "0xdeadbeef", "default/vm", "", "net1", "0a:58:c0:a8:01:0a", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newip.IP.String()).To(Equal("192.168.1.10"))
+			Expect(updatedreservelist).To(HaveLen(2))
+			Expect(updatedreservelist[1].MAC).To(Equal("0a:58:c0:a8:01:0a"))
+		})
+
+		It("assigns the preferred IP when usable", func() {
+			newip, _, err := AssignIP(ipRange, reservelist, nil, "0xdeadbeef", "default/pod", "", "net1", "", nil, net.ParseIP("192.168.1.53"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newip.IP.String()).To(Equal("192.168.1.53"))
+
+			newip, _, err = AssignIP(ipRange, reservelist, nil, "0xdeadbeef", "default/pod", "", "net1", "", nil, net.ParseIP("192.168.1.30"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newip.IP.String()).To(Equal("192.168.1.10"))
+		})
+
+		It("requires each requested IP to belong to its own range", func() {
+			ipRanges := []types.RangeConfiguration{ipRange, {Range: "fd00::/64"}}
+			Expect(CheckRequestedIPs(ipRanges, []net.IP{net.ParseIP("192.168.1.53"), net.ParseIP("fd00::53")})).To(Succeed())
+			Expect(CheckRequestedIPs(ipRanges, []net.IP{net.ParseIP("10.0.0.53")})).To(
+				MatchError("refusing to assign the requested IP 10.0.0.53: it is outside the range"))
+			Expect(CheckRequestedIPs(ipRanges, []net.IP{net.ParseIP("192.168.1.53"), net.ParseIP("192.168.1.54")})).To(
+				MatchError("refusing to assign the requested IP 192.168.1.54: another requested IP belongs to the same range"))
+		})
+	})
+
 	It("can IterateForAssignment on an IPv4 address", func() {
 
 		firstip, ipnet, err := net.ParseCIDR("192.168.1.1/24")
@@ -27,7 +165,7 @@ var _ = Describe("Allocation operations", func() {
 
 		var ipres []types.IPReservation
 		var exrange []string
-		newip, _, err := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "")
+		newip, _, err := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(err).NotTo(HaveOccurred())
 		Expect(fmt.Sprint(newip)).To(Equal("192.168.1.1"))
 
@@ -43,12 +181,30 @@ var _ = Describe("Allocation operations", func() {
 
 		var ipres []types.IPReservation
 		var exrange []string
-		newip, _, err := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "")
+		newip, _, err := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(err).NotTo(HaveOccurred())
 		Expect(fmt.Sprint(newip)).To(Equal("caa5::1"))
 
 	})
 
+	It("ignores the exclude ranges of the other IP family", func() {
+		_, ipnet, err := net.ParseCIDR("192.168.0.0/24")
+		Expect(err).NotTo(HaveOccurred())
+
+		exrange := []string{"::/0", "192.168.0.0/30"}
+		newip, _, err := IterateForAssignment(*ipnet, nil, nil, nil, exrange, "0xdeadbeef", "", "", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fmt.Sprint(newip)).To(Equal("192.168.0.4"))
+
+		_, ipnet, err = net.ParseCIDR("caa5::/112")
+		Expect(err).NotTo(HaveOccurred())
+
+		exrange = []string{"0.0.0.0/0", "caa5::/126"}
+		newip, _, err = IterateForAssignment(*ipnet, nil, nil, nil, exrange, "0xdeadbeef", "", "", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fmt.Sprint(newip)).To(Equal("caa5::4"))
+	})
+
 	It("can IterateForAssignment on an IPv6 address when the first hextet has ALL leading zeroes", func() {
 
 		firstip, ipnet, err := net.ParseCIDR("::1/126")
@@ -59,7 +215,7 @@ var _ = Describe("Allocation operations", func() {
 
 		var ipres []types.IPReservation
 		var exrange []string
-		newip, _, err := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "")
+		newip, _, err := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(err).NotTo(HaveOccurred())
 		Expect(fmt.Sprint(newip)).To(Equal("::1"))
 
@@ -77,7 +233,7 @@ var _ = Describe("Allocation operations", func() {
 
 		var ipres []types.IPReservation
 		var exrange []string
-		newip, _, err := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "")
+		newip, _, err := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(err).NotTo(HaveOccurred())
 		Expect(fmt.Sprint(newip)).To(Equal("fd::1"))
 
@@ -93,7 +249,7 @@ var _ = Describe("Allocation operations", func() {
 
 		var ipres []types.IPReservation
 		var exrange []string
-		newip, _, err := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "")
+		newip, _, err := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(err).NotTo(HaveOccurred())
 		Expect(fmt.Sprint(newip)).To(Equal("100::2:1"))
 	})
@@ -108,7 +264,7 @@ var _ = Describe("Allocation operations", func() {
 
 		var ipres []types.IPReservation
 		exrange := []string{"192.168.0.0/30"}
-		newip, _, _ := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "")
+		newip, _, _ := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(fmt.Sprint(newip)).To(Equal("192.168.0.4"))
 
 	})
@@ -122,7 +278,7 @@ var _ = Describe("Allocation operations", func() {
 
 		var ipres []types.IPReservation
 		exrange := []string{"192.168.0.1"}
-		newip, _, err := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "")
+		newip, _, err := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(err).NotTo(HaveOccurred())
 		Expect(fmt.Sprint(newip)).To(Equal("192.168.0.2"))
 	})
@@ -136,7 +292,7 @@ var _ = Describe("Allocation operations", func() {
 
 		var ipres []types.IPReservation
 		exrange := []string{"192.168.0.1/123"}
-		_, _, err = IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "")
+		_, _, err = IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(err).To(MatchError(HavePrefix("could not parse exclude range")))
 	})
 
@@ -150,7 +306,7 @@ var _ = Describe("Allocation operations", func() {
 
 		var ipres []types.IPReservation
 		exrange := []string{"100::2:1/126"}
-		newip, _, _ := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "")
+		newip, _, _ := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(fmt.Sprint(newip)).To(Equal("100::2:4"))
 
 	})
@@ -164,7 +320,7 @@ var _ = Describe("Allocation operations", func() {
 
 		var ipres []types.IPReservation
 		exrange := []string{"100::2:1"}
-		newip, _, _ := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "")
+		newip, _, _ := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(fmt.Sprint(newip)).To(Equal("100::2:2"))
 	})
 
@@ -177,7 +333,7 @@ var _ = Describe("Allocation operations", func() {
 
 		var ipres []types.IPReservation
 		exrange := []string{"100::2::1"}
-		_, _, err = IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "")
+		_, _, err = IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(err).To(MatchError(HavePrefix("could not parse exclude range")))
 	})
 
@@ -191,7 +347,7 @@ var _ = Describe("Allocation operations", func() {
 
 		var ipres []types.IPReservation
 		exrange := []string{"2001:db8::0/32"}
-		newip, _, _ := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "")
+		newip, _, _ := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(fmt.Sprint(newip)).To(Equal("2001:db9::"))
 
 	})
@@ -206,11 +362,11 @@ var _ = Describe("Allocation operations", func() {
 
 		var ipres []types.IPReservation
 		exrange := []string{"192.168.0.0/30", "192.168.0.6/31", "192.168.0.8/31", "192.168.0.4/30"}
-		newip, _, _ := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "")
+		newip, _, _ := IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(fmt.Sprint(newip)).To(Equal("192.168.0.10"))
 
 		exrange = []string{"192.168.0.0/30", "192.168.0.14/31", "192.168.0.4/30", "192.168.0.6/31", "192.168.0.8/31"}
-		newip, _, _ = IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "")
+		newip, _, _ = IterateForAssignment(*ipnet, calculatedrangestart, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(fmt.Sprint(newip)).To(Equal("192.168.0.10"))
 	})
 
@@ -234,7 +390,7 @@ var _ = Describe("Allocation operations", func() {
 			},
 		}
 		exrange := []string{"192.168.0.0/30"}
-		_, _, err = IterateForAssignment(*ipnet, firstip, nil, ipres, exrange, "0xdeadbeef", "", "")
+		_, _, err = IterateForAssignment(*ipnet, firstip, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(err).To(MatchError(HavePrefix("Could not allocate IP in range")))
 
 	})
@@ -258,7 +414,7 @@ var _ = Describe("Allocation operations", func() {
 			},
 		}
 		exrange := []string{"192.168.0.4/30"}
-		_, _, err = IterateForAssignment(*ipnet, firstip, nil, ipres, exrange, "0xdeadbeef", "", "")
+		_, _, err = IterateForAssignment(*ipnet, firstip, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(err).To(MatchError(HavePrefix("Could not allocate IP in range")))
 
 	})
@@ -284,7 +440,7 @@ var _ = Describe("Allocation operations", func() {
 		}
 
 		exrange := []string{"100::2:4/126"}
-		_, _, err = IterateForAssignment(*ipnet, firstip, nil, ipres, exrange, "0xdeadbeef", "", "")
+		_, _, err = IterateForAssignment(*ipnet, firstip, nil, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(err).To(MatchError(HavePrefix("Could not allocate IP in range")))
 
 	})
@@ -297,7 +453,7 @@ var _ = Describe("Allocation operations", func() {
 			_, ipnet, err := net.ParseCIDR("192.168.0.0/29")
 			Expect(err).NotTo(HaveOccurred())
 			rangeStart := net.ParseIP("192.168.0.0") // Network address, out of bounds.
-			newip, _, err := IterateForAssignment(*ipnet, rangeStart, nil, nil, nil, "0xdeadbeef", "", "")
+			newip, _, err := IterateForAssignment(*ipnet, rangeStart, nil, nil, nil, "0xdeadbeef", "", "", "")
 			Expect(err).NotTo(HaveOccurred())
 			Expect(fmt.Sprint(newip)).To(Equal("192.168.0.1"))
 		})
@@ -309,7 +465,7 @@ var _ = Describe("Allocation operations", func() {
 			Expect(err).NotTo(HaveOccurred())
 			rangeStart := net.ParseIP("192.168.0.0") // Network address, out of bounds.
 			rangeEnd := net.ParseIP("192.168.0.8")   // Broadcast address, out of bounds.
-			newip, _, err := IterateForAssignment(*ipnet, rangeStart, rangeEnd, nil, nil, "0xdeadbeef", "", "")
+			newip, _, err := IterateForAssignment(*ipnet, rangeStart, rangeEnd, nil, nil, "0xdeadbeef", "", "", "")
 			Expect(err).NotTo(HaveOccurred())
 			Expect(fmt.Sprint(newip)).To(Equal("192.168.0.1"))
 		})
@@ -337,7 +493,7 @@ var _ = Describe("Allocation operations", func() {
 			},
 		}
 		exrange := []string{"192.168.0.4/30"}
-		_, _, err = IterateForAssignment(*ipnet, startip, lastip, ipres, exrange, "0xdeadbeef", "", "")
+		_, _, err = IterateForAssignment(*ipnet, startip, lastip, ipres, exrange, "0xdeadbeef", "", "", "")
 		Expect(err).To(MatchError(HavePrefix("Could not allocate IP in range")))
 	})
 
@@ -350,7 +506,7 @@ var _ = Describe("Allocation operations", func() {
 				lastip := net.ParseIP("192.168.0.6")
 
 				ipres := []types.IPReservation{}
-				_, ipres, err = IterateForAssignment(*ipnet, startip, lastip, ipres, nil, "0xdeadbeef", "dummy-0", "")
+				_, ipres, err = IterateForAssignment(*ipnet, startip, lastip, ipres, nil, "0xdeadbeef", "dummy-0", "", "")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(len(ipres)).To(Equal(1))
 				Expect(fmt.Sprint(ipres[0].IP)).To(Equal("192.168.0.1"))
@@ -379,7 +535,7 @@ var _ = Describe("Allocation operations", func() {
 					},
 				}
 
-				_, ipres, err = IterateForAssignment(*ipnet, startip, lastip, ipres, nil, "0xdeadbeef", "dummy-0", "")
+				_, ipres, err = IterateForAssignment(*ipnet, startip, lastip, ipres, nil, "0xdeadbeef", "dummy-0", "", "")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(len(ipres)).To(Equal(4))
 				Expect(fmt.Sprint(ipres[3].IP)).To(Equal("192.168.0.4"))
@@ -408,11 +564,265 @@ var _ = Describe("Allocation operations", func() {
 					},
 				}
 
-				_, ipres, err = IterateForAssignment(*ipnet, startip, lastip, ipres, nil, "0xdeadbeef", "dummy-0", "")
+				_, ipres, err = IterateForAssignment(*ipnet, startip, lastip, ipres, nil, "0xdeadbeef", "dummy-0", "", "")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(len(ipres)).To(Equal(4))
 				Expect(fmt.Sprint(ipres[3].IP)).To(Equal("192.168.0.3"))
 			})
 		})
 	})
+
+	Context("network service reservations", func() {
+		It("reserves the first usable IPs of the range", func() {
+			serviceIPs, err := ServiceIPs(types.RangeConfiguration{Range: "192.168.1.0/24"}, 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fmt.Sprint(serviceIPs)).To(Equal("[192.168.1.1 192.168.1.2]"))
+		})
+
+		It("honors the range start and the excluded ranges", func() {
+			serviceIPs, err := ServiceIPs(types.RangeConfiguration{
+				Range:      "192.168.1.0/24",
+				RangeStart: net.ParseIP("192.168.1.10"),
+				OmitRanges: []string{"192.168.1.11/32"},
+			}, 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fmt.Sprint(serviceIPs)).To(Equal("[192.168.1.10 192.168.1.12]"))
+		})
+
+		It("fails when the range cannot hold all the reservations", func() {
+			_, err := ServiceIPs(types.RangeConfiguration{Range: "192.168.1.0/30"}, 3)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("allocation strategies", func() {
+		reservelist := []types.IPReservation{{IP: net.ParseIP("192.168.1.1"), PodRef: "default/other"}}
+
+		It("assigns the lowest free IP when sequential", func() {
+			ipRange := types.RangeConfiguration{Range: "192.168.1.0/24", AllocationStrategy: types.SequentialAllocation}
+			newip, _, err := AssignIP(ipRange, reservelist, nil, "0xdeadbeef", "default/pod", "", "net1", "", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newip.String()).To(Equal("192.168.1.2/24"))
+		})
+
+		It("assigns a free IP of the range when random", func() {
+			ipRange := types.RangeConfiguration{
+				Range:              "192.168.1.0/24",
+				RangeStart:         net.ParseIP("192.168.1.10"),
+				RangeEnd:           net.ParseIP("192.168.1.20"),
+				OmitRanges:         []string{"192.168.1.12/30"},
+				AllocationStrategy: types.RandomAllocation,
+			}
+			assigned := map[string]bool{}
+			for i := 0; i < 100; i++ {
+				newip, _, err := AssignIP(ipRange, reservelist, nil, "0xdeadbeef", "default/pod", "", "net1", "", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				assigned[newip.IP.String()] = true
+			}
+			Expect(len(assigned)).To(BeNumerically(">", 1))
+			for ip := range assigned {
+				Expect(net.ParseIP(ip)).To(BeElementOf(net.ParseIP("192.168.1.10"), net.ParseIP("192.168.1.11"),
+					net.ParseIP("192.168.1.16"), net.ParseIP("192.168.1.17"), net.ParseIP("192.168.1.18"),
+					net.ParseIP("192.168.1.19"), net.ParseIP("192.168.1.20")))
+			}
+		})
+
+		It("assigns the last free IP of the range when random", func() {
+			ipRange := types.RangeConfiguration{Range: "192.168.1.0/30", AllocationStrategy: types.RandomAllocation}
+			for i := 0; i < 10; i++ {
+				newip, _, err := AssignIP(ipRange, reservelist, nil, "0xdeadbeef", "default/pod", "", "net1", "", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(newip.String()).To(Equal("192.168.1.2/30"))
+			}
+		})
+
+		It("assigns the free IPs never released first when lru", func() {
+			ipRange := types.RangeConfiguration{Range: "192.168.1.0/24", AllocationStrategy: types.LRUAllocation}
+			released := map[string]time.Time{"192.168.1.2": time.Now(), "192.168.1.3": time.Now()}
+			newip, _, err := AssignIP(ipRange, reservelist, released, "0xdeadbeef", "default/pod", "", "net1", "", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newip.String()).To(Equal("192.168.1.4/24"))
+		})
+
+		It("assigns the free IP released the longest ago when lru", func() {
+			ipRange := types.RangeConfiguration{Range: "192.168.1.0/29", AllocationStrategy: types.LRUAllocation}
+			now := time.Now()
+			released := map[string]time.Time{}
+			for i, ip := range []string{"192.168.1.2", "192.168.1.3", "192.168.1.4", "192.168.1.5", "192.168.1.6"} {
+				released[ip] = now.Add(-time.Duration(i) * time.Minute)
+			}
+			released["192.168.1.5"] = now.Add(-time.Hour)
+			newip, _, err := AssignIP(ipRange, reservelist, released, "0xdeadbeef", "default/pod", "", "net1", "", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newip.String()).To(Equal("192.168.1.5/29"))
+		})
+
+		It("assigns IPs of random interface identifiers when random-iid", func() {
+			ipRange := types.RangeConfiguration{Range: "2001:db8::/64", AllocationStrategy: types.RandomIIDAllocation}
+			_, ipNet, _ := net.ParseCIDR(ipRange.Range)
+			var allocated []types.IPReservation
+			for i := 0; i < 10; i++ {
+				newip, updated, err := AssignIP(ipRange, allocated, nil, fmt.Sprintf("0xdeadbeef%d", i), fmt.Sprintf("default/pod%d", i), "", "net1", "", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ipNet.Contains(newip.IP)).To(BeTrue())
+				Expect(iphelpers.IPGetOffset(newip.IP, ipNet.IP)).To(BeNumerically(">", uint64(1)<<32), "the interface identifier should not be a low one")
+				allocated = updated
+			}
+			Expect(allocated).To(HaveLen(10))
+		})
+
+		It("assigns the free IPs left in a crowded range when random-iid, but never the anycast ones", func() {
+			ipRange := types.RangeConfiguration{
+				Range:              "2001:db8::/64",
+				RangeStart:         net.ParseIP("2001:db8::fdff:ffff:ffff:ff7c"),
+				RangeEnd:           net.ParseIP("2001:db8::fdff:ffff:ffff:ffff"),
+				AllocationStrategy: types.RandomIIDAllocation,
+			}
+			allocated := []types.IPReservation{
+				{IP: net.ParseIP("2001:db8::fdff:ffff:ffff:ff7c"), PodRef: "default/other"},
+				{IP: net.ParseIP("2001:db8::fdff:ffff:ffff:ff7e"), PodRef: "default/another"},
+				{IP: net.ParseIP("2001:db8::fdff:ffff:ffff:ff7f"), PodRef: "default/yet-another"},
+			}
+			for i := 0; i < 10; i++ {
+				newip, _, err := AssignIP(ipRange, allocated, nil, "0xdeadbeef", "default/pod", "", "net1", "", nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(newip.String()).To(Equal("2001:db8::fdff:ffff:ffff:ff7d/64"))
+			}
+
+			allocated = append(allocated, types.IPReservation{IP: net.ParseIP("2001:db8::fdff:ffff:ffff:ff7d"), PodRef: "default/last"})
+			_, _, err := AssignIP(ipRange, allocated, nil, "0xdeadbeef", "default/pod", "", "net1", "", nil, nil)
+			Expect(err).To(BeAssignableToTypeOf(AssignmentError{}))
+		})
+
+		It("fails when the range is exhausted whatever the strategy", func() {
+			for _, strategy := range []string{types.SequentialAllocation, types.RandomAllocation, types.LRUAllocation} {
+				ipRange := types.RangeConfiguration{Range: "192.168.1.0/30", AllocationStrategy: strategy}
+				allocated := append([]types.IPReservation{}, reservelist...)
+				allocated = append(allocated, types.IPReservation{IP: net.ParseIP("192.168.1.2"), PodRef: "default/another"})
+				_, _, err := AssignIP(ipRange, allocated, nil, "0xdeadbeef", "default/pod", "", "net1", "", nil, nil)
+				Expect(err).To(BeAssignableToTypeOf(AssignmentError{}))
+			}
+		})
+	})
+
+	Context("allocating from a range set", func() {
+		var ipRange types.RangeConfiguration
+
+		BeforeEach(func() {
+			ipRange = types.RangeConfiguration{RangeSet: []string{"10.0.0.0/30", "192.168.1.0/24"}}
+			Expect(ipRange.Normalize()).To(Succeed())
+		})
+
+		It("assigns from the next CIDR once the first is exhausted", func() {
+			reservelist := []types.IPReservation{
+				{IP: net.ParseIP("10.0.0.1"), PodRef: "default/other"},
+				{IP: net.ParseIP("10.0.0.2"), PodRef: "default/another"},
+			}
+			newip, _, err := AssignIP(ipRange, reservelist, nil, "0xdeadbeef", "default/pod", "", "net1", "", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newip.String()).To(Equal("192.168.1.1/24"))
+		})
+
+		It("assigns a requested IP of any CIDR", func() {
+			newip, _, err := AssignIP(ipRange, nil, nil, "0xdeadbeef", "default/pod", "", "net1", "", net.ParseIP("192.168.1.42"), nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newip.String()).To(Equal("192.168.1.42/24"))
+		})
+
+		It("rejects a requested IP between the CIDRs", func() {
+			_, _, err := AssignIP(ipRange, nil, nil, "0xdeadbeef", "default/pod", "", "net1", "", net.ParseIP("172.16.0.1"), nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("in debug mode", func() {
+		var previousLevel string
+
+		BeforeEach(func() {
+			previousLevel = logging.GetLoggingLevel().String()
+			logging.SetLogLevel("debug")
+		})
+
+		AfterEach(func() {
+			logging.SetLogLevel(previousLevel)
+		})
+
+		It("records why the candidate IPs were skipped", func() {
+			_, ipnet, err := net.ParseCIDR("192.168.1.0/28")
+			Expect(err).NotTo(HaveOccurred())
+
+			reserved := []types.IPReservation{
+				{IP: net.ParseIP("192.168.1.3"), PodRef: "default/pod1"},
+				{IP: net.ParseIP("192.168.1.4"), PodRef: "default/pod2"},
+				{IP: net.ParseIP("192.168.1.7"), PodRef: "default/pod3"},
+			}
+			_, _, err = IterateForAssignment(*ipnet, net.ParseIP("192.168.1.3"), net.ParseIP("192.168.1.9"), reserved,
+				[]string{"192.168.1.4/30", "192.168.1.8/30"}, "0xdeadbeef", "", "", "")
+
+			var assignmentErr AssignmentError
+			Expect(errors.As(err, &assignmentErr)).To(BeTrue())
+			Expect(fmt.Sprint(assignmentErr.Skipped())).To(Equal("[" +
+				"192.168.1.1-192.168.1.2 (before range_start) " +
+				"192.168.1.3-192.168.1.4 (reserved) " +
+				"192.168.1.5-192.168.1.7 (excluded by 192.168.1.4/30) " +
+				"192.168.1.8-192.168.1.9 (excluded by 192.168.1.8/30) " +
+				"192.168.1.10-192.168.1.14 (after range_end)]"))
+			Expect(err).To(MatchError(ContainSubstring("skipped: 192.168.1.1-192.168.1.2 (before range_start)")))
+		})
+
+		It("bounds the trace of skipped IPs", func() {
+			_, ipnet, err := net.ParseCIDR("192.168.1.0/24")
+			Expect(err).NotTo(HaveOccurred())
+
+			var excluded []string
+			for i := 1; i < 255; i++ {
+				excluded = append(excluded, fmt.Sprintf("192.168.1.%d", i))
+			}
+			_, _, err = IterateForAssignment(*ipnet, nil, nil, nil, excluded, "0xdeadbeef", "", "", "")
+
+			var assignmentErr AssignmentError
+			Expect(errors.As(err, &assignmentErr)).To(BeTrue())
+			Expect(assignmentErr.Skipped()).To(HaveLen(maxSkipTraceLength))
+			Expect(err).To(MatchError(HaveSuffix(fmt.Sprintf("and %d more", 254-maxSkipTraceLength))))
+		})
+	})
+
+	It("does not record the skipped IPs outside of debug mode", func() {
+		previousLevel := logging.GetLoggingLevel().String()
+		logging.SetLogLevel("verbose")
+		defer logging.SetLogLevel(previousLevel)
+
+		_, ipnet, err := net.ParseCIDR("192.168.1.0/30")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, _, err = IterateForAssignment(*ipnet, nil, nil, nil, []string{"192.168.1.0/30"}, "0xdeadbeef", "", "", "")
+
+		var assignmentErr AssignmentError
+		Expect(errors.As(err, &assignmentErr)).To(BeTrue())
+		Expect(assignmentErr.Skipped()).To(BeEmpty())
+	})
+
+	Context("deallocating the IP of a pod", func() {
+		reservations := func() []types.IPReservation {
+			return []types.IPReservation{{IP: net.ParseIP("192.168.1.1"), ContainerID: "0xdeadbeef", IfName: "net1", PodRef: "default/pod-a"}}
+		}
+
+		It("releases the IP of the pod interface", func() {
+			remaining, ip := DeallocatePodIP(reservations(), "0xdeadbeef", "net1", "default/pod-a")
+			Expect(ip).To(Equal(net.ParseIP("192.168.1.1")))
+			Expect(remaining).To(BeEmpty())
+		})
+
+		It("leaves the IP of the container of another pod alone", func() {
+			remaining, ip := DeallocatePodIP(reservations(), "0xdeadbeef", "net1", "default/pod-b")
+			Expect(ip).To(BeNil())
+			Expect(remaining).To(HaveLen(1))
+		})
+
+		It("releases the IP of the container interface of any pod without a pod reference", func() {
+			remaining, ip := DeallocatePodIP(reservations(), "0xdeadbeef", "net1", "")
+			Expect(ip).To(Equal(net.ParseIP("192.168.1.1")))
+			Expect(remaining).To(BeEmpty())
+		})
+	})
 })
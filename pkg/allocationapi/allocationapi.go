@@ -0,0 +1,611 @@
+// Package allocationapi serves the address allocations of the whereabouts CNI plugin as an API aggregated to the
+// Kubernetes API server: the plugin creates allocations, releases and checks, which the aggregated API server fulfills
+// by writing the IP pools itself. The credentials of the nodes then need no write access to the whereabouts resources,
+// only the permission to create those requests.
+package allocationapi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/config"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/daemon"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+const (
+	// GroupName is the API group of the allocation API
+	GroupName = "allocation.whereabouts.cni.cncf.io"
+	// Version is the version of the allocation API
+	Version = "v1alpha1"
+)
+
+// The resources of the allocation API, which only support create, for the CNI ADD, DEL and CHECK respectively
+const (
+	AllocationsResource = "allocations"
+	ReleasesResource    = "releases"
+	ChecksResource      = "checks"
+)
+
+const (
+	groupVersion       = GroupName + "/" + Version
+	apisPath           = "/apis"
+	groupPath          = apisPath + "/" + GroupName
+	versionPath        = groupPath + "/" + Version
+	healthzPath        = "/healthz"
+	createVerb         = "create"
+	maxRequestBytes    = 1 << 20
+	readHeaderTimeout  = 5 * time.Second
+	contentTypeHeader  = "Content-Type"
+	jsonContentType    = "application/json"
+	defaultUserHeader  = "X-Remote-User"
+	defaultGroupHeader = "X-Remote-Group"
+	authenticationNS   = "kube-system"
+	authenticationCM   = "extension-apiserver-authentication"
+	requestHeaderCAKey = "requestheader-client-ca-file"
+	allowedNamesKey    = "requestheader-allowed-names"
+	userHeadersKey     = "requestheader-username-headers"
+	groupHeadersKey    = "requestheader-group-headers"
+	extraPrefixesKey   = "requestheader-extra-headers-prefix"
+	defaultExtraPrefix = "X-Remote-Extra-"
+)
+
+const (
+	// nodeUserPrefix prefixes the users of the kubelets
+	nodeUserPrefix = "system:node:"
+	// nodeNameExtraKey is the extra of the users of the service account tokens bound to a pod, telling its node
+	nodeNameExtraKey = "authentication.kubernetes.io/node-name"
+	whereaboutsIPAM  = "whereabouts"
+)
+
+// Request is an allocation, release or check: its spec is the CNI invocation of the plugin, its status the addresses
+// allocated by an allocation, or verified by a check
+type Request struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   daemon.Request `json:"spec"`
+	Status RequestStatus  `json:"status,omitempty"`
+}
+
+// RequestStatus carries the addresses of the request, in CIDR notation
+type RequestStatus struct {
+	IPs []string `json:"ips,omitempty"`
+}
+
+type resource struct {
+	name string
+	kind string
+	mode int
+	// timeLimit bounds the request as the plugin bounds the matching CNI command
+	timeLimit time.Duration
+}
+
+var resources = []resource{
+	{name: AllocationsResource, kind: "Allocation", mode: types.Allocate, timeLimit: types.AddTimeLimit},
+	{name: ReleasesResource, kind: "Release", mode: types.Deallocate, timeLimit: types.DelTimeLimit},
+	{name: ChecksResource, kind: "Check", mode: types.Check, timeLimit: types.CheckTimeLimit},
+}
+
+// manager serves the requests, i.e. the daemon.Server
+type manager interface {
+	Manage(ctx context.Context, mode int, request daemon.Request) ([]net.IPNet, error)
+}
+
+type subjectAccessReviewer func(ctx context.Context, review *authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error)
+
+type podGetter func(ctx context.Context, namespace, name string) (*v1.Pod, error)
+
+type netAttachDefGetter func(ctx context.Context, namespace, name string) (*nadv1.NetworkAttachmentDefinition, error)
+
+// RequestHeaderConfig tells how the aggregation layer authenticates the requests it proxies: it presents a client
+// certificate signed by the client CA, with one of the allowed names, and passes the user in the request headers
+type RequestHeaderConfig struct {
+	ClientCAs *x509.CertPool
+	// AllowedNames are the common names the client certificate of the aggregation layer may have; any when empty
+	AllowedNames    []string
+	UsernameHeaders []string
+	GroupHeaders    []string
+	// ExtraHeaderPrefixes prefix the headers carrying the extras of the user, e.g. the node of a bound token
+	ExtraHeaderPrefixes []string
+}
+
+// LoadRequestHeaderConfig reads the configuration of the aggregation layer the API server publishes in the
+// kube-system/extension-apiserver-authentication configmap
+func LoadRequestHeaderConfig(ctx context.Context, client *kubernetes.Client) (*RequestHeaderConfig, error) {
+	configMap, err := client.GetConfigMap(ctx, authenticationNS, authenticationCM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the %s/%s configmap: %w", authenticationNS, authenticationCM, err)
+	}
+	return newRequestHeaderConfig(configMap.Data)
+}
+
+func newRequestHeaderConfig(data map[string]string) (*RequestHeaderConfig, error) {
+	caBundle := data[requestHeaderCAKey]
+	if caBundle == "" {
+		return nil, fmt.Errorf("the API server publishes no %s: is the aggregation layer enabled?", requestHeaderCAKey)
+	}
+	requestHeader := &RequestHeaderConfig{ClientCAs: x509.NewCertPool()}
+	for block, rest := pem.Decode([]byte(caBundle)); block != nil; block, rest = pem.Decode(rest) {
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", requestHeaderCAKey, err)
+		}
+		requestHeader.ClientCAs.AddCert(certificate)
+	}
+	for key, list := range map[string]*[]string{
+		allowedNamesKey:  &requestHeader.AllowedNames,
+		userHeadersKey:   &requestHeader.UsernameHeaders,
+		groupHeadersKey:  &requestHeader.GroupHeaders,
+		extraPrefixesKey: &requestHeader.ExtraHeaderPrefixes,
+	} {
+		if data[key] == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(data[key]), list); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+	if len(requestHeader.UsernameHeaders) == 0 {
+		requestHeader.UsernameHeaders = []string{defaultUserHeader}
+	}
+	if len(requestHeader.GroupHeaders) == 0 {
+		requestHeader.GroupHeaders = []string{defaultGroupHeader}
+	}
+	if len(requestHeader.ExtraHeaderPrefixes) == 0 {
+		requestHeader.ExtraHeaderPrefixes = []string{defaultExtraPrefix}
+	}
+	return requestHeader, nil
+}
+
+// Server serves the allocation API, authenticating the requests proxied by the aggregation layer and delegating their
+// authorization to the API server. Each request is then bound to the node of its user, see Server.admit.
+type Server struct {
+	manager         manager
+	requestHeader   RequestHeaderConfig
+	reviewAccess    subjectAccessReviewer
+	getPod          podGetter
+	getNetAttachDef netAttachDefGetter
+}
+
+// NewServer returns a Server fulfilling the requests through the daemon server, and authorizing them through the
+// client, which requires the system:auth-delegator cluster role, and the permission to read the pods and the
+// network-attachment-definitions
+func NewServer(daemonServer *daemon.Server, client *kubernetes.Client, requestHeader RequestHeaderConfig) *Server {
+	return &Server{
+		manager:         daemonServer,
+		requestHeader:   requestHeader,
+		reviewAccess:    client.ReviewSubjectAccess,
+		getPod:          client.GetPod,
+		getNetAttachDef: client.GetNetAttachDef,
+	}
+}
+
+// NewHTTPServer returns the HTTP server of the allocation API. It must be served over TLS, its TLS configuration
+// verifying the client certificates against the client CA of the request header configuration; the health checks of the
+// kubelet present none.
+func (s *Server) NewHTTPServer(address string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(apisPath, s.authenticated(s.serveGroupList))
+	mux.HandleFunc(groupPath, s.authenticated(s.serveGroup))
+	mux.HandleFunc(versionPath, s.authenticated(s.serveResourceList))
+	for _, resource := range resources {
+		mux.HandleFunc(versionPath+"/"+resource.name, s.authenticated(s.serveCreate(resource)))
+	}
+	mux.HandleFunc(healthzPath, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+		TLSConfig: &tls.Config{
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			ClientCAs:  s.requestHeader.ClientCAs,
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+}
+
+// user is the user the aggregation layer proxies the request of
+type user struct {
+	name   string
+	groups []string
+	extra  map[string][]string
+}
+
+// nodeName returns the node the user is bound to: that of a kubelet, or the node of the pod a service account token
+// is bound to, e.g. the projected token of the whereabouts daemonset
+func (u user) nodeName() string {
+	if nodeName, isNode := strings.CutPrefix(u.name, nodeUserPrefix); isNode {
+		return nodeName
+	}
+	if nodeNames := u.extra[nodeNameExtraKey]; len(nodeNames) == 1 {
+		return nodeNames[0]
+	}
+	return ""
+}
+
+type authenticatedHandler func(w http.ResponseWriter, r *http.Request, requester user)
+
+// authenticated only serves the requests proxied by the aggregation layer, i.e. presenting a verified client
+// certificate with an allowed name, along with the user they are proxied for
+func (s *Server) authenticated(handler authenticatedHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			writeStatus(w, http.StatusUnauthorized, metav1.StatusReasonUnauthorized, "the allocation API only serves the requests of the aggregation layer")
+			return
+		}
+		if commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName; !s.isAllowedName(commonName) {
+			writeStatus(w, http.StatusUnauthorized, metav1.StatusReasonUnauthorized, fmt.Sprintf("client certificate %q is not allowed to proxy requests", commonName))
+			return
+		}
+		requester := user{name: firstHeader(r.Header, s.requestHeader.UsernameHeaders)}
+		if requester.name == "" {
+			writeStatus(w, http.StatusUnauthorized, metav1.StatusReasonUnauthorized, "the request names no user")
+			return
+		}
+		for _, header := range s.requestHeader.GroupHeaders {
+			requester.groups = append(requester.groups, r.Header.Values(header)...)
+		}
+		requester.extra = extraHeaders(r.Header, s.requestHeader.ExtraHeaderPrefixes)
+		handler(w, r, requester)
+	}
+}
+
+func (s *Server) isAllowedName(commonName string) bool {
+	if len(s.requestHeader.AllowedNames) == 0 {
+		return true
+	}
+	for _, name := range s.requestHeader.AllowedNames {
+		if name == commonName {
+			return true
+		}
+	}
+	return false
+}
+
+func firstHeader(header http.Header, names []string) string {
+	for _, name := range names {
+		if value := strings.TrimSpace(header.Get(name)); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// extraHeaders returns the extras of the user, keyed by the lowercase and unescaped suffix of their headers
+func extraHeaders(header http.Header, prefixes []string) map[string][]string {
+	extra := map[string][]string{}
+	for name, values := range header {
+		for _, prefix := range prefixes {
+			if len(name) <= len(prefix) || !strings.EqualFold(name[:len(prefix)], prefix) {
+				continue
+			}
+			key, err := url.PathUnescape(strings.ToLower(name[len(prefix):]))
+			if err != nil {
+				continue
+			}
+			extra[key] = append(extra[key], values...)
+		}
+	}
+	return extra
+}
+
+func (s *Server) serveGroupList(w http.ResponseWriter, r *http.Request, _ user) {
+	if r.URL.Path != apisPath {
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, "the server could not find the requested resource")
+		return
+	}
+	writeJSON(w, http.StatusOK, &metav1.APIGroupList{
+		TypeMeta: metav1.TypeMeta{Kind: "APIGroupList", APIVersion: "v1"},
+		Groups:   []metav1.APIGroup{apiGroup()},
+	})
+}
+
+func (s *Server) serveGroup(w http.ResponseWriter, _ *http.Request, _ user) {
+	group := apiGroup()
+	group.TypeMeta = metav1.TypeMeta{Kind: "APIGroup", APIVersion: "v1"}
+	writeJSON(w, http.StatusOK, &group)
+}
+
+func (s *Server) serveResourceList(w http.ResponseWriter, _ *http.Request, _ user) {
+	resourceList := &metav1.APIResourceList{
+		TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+		GroupVersion: groupVersion,
+	}
+	for _, resource := range resources {
+		resourceList.APIResources = append(resourceList.APIResources, metav1.APIResource{
+			Name:  resource.name,
+			Kind:  resource.kind,
+			Verbs: metav1.Verbs{createVerb},
+		})
+	}
+	writeJSON(w, http.StatusOK, resourceList)
+}
+
+func apiGroup() metav1.APIGroup {
+	version := metav1.GroupVersionForDiscovery{GroupVersion: groupVersion, Version: Version}
+	return metav1.APIGroup{
+		Name:             GroupName,
+		Versions:         []metav1.GroupVersionForDiscovery{version},
+		PreferredVersion: version,
+	}
+}
+
+// serveCreate fulfills the creation of a request of the resource by the requester, once the API server authorizes it
+func (s *Server) serveCreate(resource resource) authenticatedHandler {
+	return func(w http.ResponseWriter, r *http.Request, requester user) {
+		if r.Method != http.MethodPost {
+			writeStatus(w, http.StatusMethodNotAllowed, metav1.StatusReasonMethodNotAllowed, fmt.Sprintf("%s only supports create", resource.name))
+			return
+		}
+		if err := s.authorize(r.Context(), requester, resource); err != nil {
+			writeStatus(w, http.StatusForbidden, metav1.StatusReasonForbidden, err.Error())
+			return
+		}
+
+		var request Request
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&request); err != nil {
+			writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, fmt.Sprintf("invalid %s: %v", resource.kind, err))
+			return
+		}
+		if request.Spec.RequestID == "" {
+			request.Spec.RequestID = logging.NewRequestID()
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), resource.timeLimit)
+		defer cancel()
+		ctx = logging.WithFields(ctx, logging.Fields{RequestID: request.Spec.RequestID, ContainerID: request.Spec.ContainerID, IfName: request.Spec.IfName})
+		podGone, err := s.admit(ctx, requester, resource, &request.Spec)
+		var admissionErr *admissionError
+		if errors.As(err, &admissionErr) {
+			writeStatus(w, admissionErr.code, admissionErr.reason, admissionErr.message)
+			return
+		} else if err != nil {
+			writeStatus(w, http.StatusInternalServerError, metav1.StatusReasonInternalError, err.Error())
+			return
+		}
+
+		var ips []net.IPNet
+		if podGone {
+			logging.FromContext(ctx).Debugf("not serving the %s of user %s: the pod is gone", resource.kind, requester.name)
+		} else {
+			logging.FromContext(ctx).Debugf("serving the %s of user %s", resource.kind, requester.name)
+			ips, err = s.manager.Manage(ctx, resource.mode, request.Spec)
+		}
+		if err != nil {
+			_ = logging.FromContext(ctx).Errorf("failed to serve the %s of container %s: %v", resource.kind, request.Spec.ContainerID, err)
+			writeStatus(w, http.StatusInternalServerError, metav1.StatusReasonInternalError, err.Error())
+			return
+		}
+
+		request.TypeMeta = metav1.TypeMeta{Kind: resource.kind, APIVersion: groupVersion}
+		request.CreationTimestamp = metav1.Now()
+		request.Status = RequestStatus{}
+		for _, ip := range ips {
+			request.Status.IPs = append(request.Status.IPs, ip.String())
+		}
+		writeJSON(w, http.StatusCreated, &request)
+	}
+}
+
+// authorize has the API server tell whether the requester may create requests of the resource
+func (s *Server) authorize(ctx context.Context, requester user, resource resource) error {
+	review, err := s.reviewAccess(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   requester.name,
+			Groups: requester.groups,
+			Extra:  reviewExtra(requester.extra),
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Verb:     createVerb,
+				Group:    GroupName,
+				Version:  Version,
+				Resource: resource.name,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to authorize user %s: %w", requester.name, err)
+	}
+	if !review.Status.Allowed {
+		message := fmt.Sprintf("user %q cannot create resource %q in API group %q", requester.name, resource.name, GroupName)
+		if review.Status.Reason != "" {
+			message += ": " + review.Status.Reason
+		}
+		return fmt.Errorf("%s", message)
+	}
+	return nil
+}
+
+func reviewExtra(extra map[string][]string) map[string]authorizationv1.ExtraValue {
+	if len(extra) == 0 {
+		return nil
+	}
+	reviewExtra := map[string]authorizationv1.ExtraValue{}
+	for key, values := range extra {
+		reviewExtra[key] = values
+	}
+	return reviewExtra
+}
+
+// admissionError rejects a request the user is authorized to create, but not for the pod or network it names
+type admissionError struct {
+	code    int
+	reason  metav1.StatusReason
+	message string
+}
+
+func (e *admissionError) Error() string {
+	return e.message
+}
+
+func forbidden(format string, args ...interface{}) error {
+	return &admissionError{code: http.StatusForbidden, reason: metav1.StatusReasonForbidden, message: fmt.Sprintf(format, args...)}
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &admissionError{code: http.StatusBadRequest, reason: metav1.StatusReasonBadRequest, message: fmt.Sprintf(format, args...)}
+}
+
+// admit binds the request to the node of its user, as the NodeRestriction admission plugin binds the kubelets: the
+// pod the CNI args name must run on that node. The network configuration and CNI args of the request are rebuilt from
+// the pod and the network-attachment-definition it selects for the interface, so that the user picks neither the
+// ranges nor the addresses. The release of a pod gone from the cluster - or replaced by one of the same name - is
+// not served, which admit reports: the IP control loop and the reconciler release the addresses of those.
+func (s *Server) admit(ctx context.Context, requester user, resource resource, request *daemon.Request) (bool, error) {
+	nodeName := requester.nodeName()
+	if nodeName == "" {
+		return false, forbidden("user %q is neither a node nor bound to one", requester.name)
+	}
+
+	args := types.IPAMEnvArgs{}
+	if err := cnitypes.LoadArgs(request.Args, &args); err != nil {
+		return false, badRequest("invalid CNI args: %v", err)
+	}
+	namespace, name := string(args.K8S_POD_NAMESPACE), string(args.K8S_POD_NAME)
+	if namespace == "" || name == "" {
+		return false, badRequest("the CNI args name no pod")
+	}
+
+	pod, err := s.getPod(ctx, namespace, name)
+	podGone := k8serrors.IsNotFound(err)
+	if err != nil && !podGone {
+		return false, fmt.Errorf("failed to get pod %s/%s: %w", namespace, name, err)
+	}
+	podGone = podGone || (args.K8S_POD_UID != "" && string(pod.GetUID()) != string(args.K8S_POD_UID))
+	if podGone && resource.mode == types.Deallocate {
+		return true, nil
+	} else if podGone {
+		return false, forbidden("pod %s/%s does not exist", namespace, name)
+	}
+	if pod.Spec.NodeName != nodeName {
+		return false, forbidden("user %q cannot manage the addresses of pod %s/%s: it does not run on node %s", requester.name, namespace, name, nodeName)
+	}
+
+	networkConfig, err := s.networkConfig(ctx, pod, request.IfName)
+	if err != nil {
+		return false, err
+	}
+	request.Config = networkConfig
+	request.Args = fmt.Sprintf("IgnoreUnknown=1;K8S_POD_NAMESPACE=%s;K8S_POD_NAME=%s;K8S_POD_UID=%s", namespace, name, pod.GetUID())
+	if args.K8S_POD_INFRA_CONTAINER_ID != "" {
+		request.Args += fmt.Sprintf(";K8S_POD_INFRA_CONTAINER_ID=%s", args.K8S_POD_INFRA_CONTAINER_ID)
+	}
+	if args.MAC != "" {
+		request.Args += fmt.Sprintf(";MAC=%s", args.MAC)
+	}
+	return false, nil
+}
+
+// networkConfig returns the network configuration of the interface of the pod, after the network-attachment-definition
+// the pod selects for it, along with the IPs the selection requests
+func (s *Server) networkConfig(ctx context.Context, pod *v1.Pod, ifName string) ([]byte, error) {
+	selections, err := kubernetes.PodNetworkSelections(pod)
+	if err != nil {
+		return nil, forbidden("invalid networks of pod %s/%s: %v", pod.GetNamespace(), pod.GetName(), err)
+	}
+	for i, selection := range selections {
+		if kubernetes.SelectionInterface(i, selection) != ifName {
+			continue
+		}
+		namespace := selection.Namespace
+		if namespace == "" {
+			namespace = pod.GetNamespace()
+		}
+		netAttachDef, err := s.getNetAttachDef(ctx, namespace, selection.Name)
+		if k8serrors.IsNotFound(err) {
+			return nil, forbidden("network-attachment-definition %s/%s does not exist", namespace, selection.Name)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get network-attachment-definition %s/%s: %w", namespace, selection.Name, err)
+		}
+		pluginConfig, err := whereaboutsPluginConfig(netAttachDef.Spec.Config, selection.IPRequest)
+		if err != nil {
+			return nil, forbidden("network-attachment-definition %s/%s: %v", namespace, selection.Name, err)
+		}
+		return pluginConfig, nil
+	}
+	return nil, forbidden("pod %s/%s selects no network for interface %s", pod.GetNamespace(), pod.GetName(), ifName)
+}
+
+// whereaboutsPluginConfig returns the configuration of the plugin of the network configuration - or list - whose IPAM
+// is whereabouts, as multus passes it: named after the network, and carrying the requested IPs
+func whereaboutsPluginConfig(networkConfig string, requestedIPs []string) ([]byte, error) {
+	var pluginConfig map[string]json.RawMessage
+	if err := config.UnmarshalNetConf([]byte(networkConfig), &pluginConfig); err != nil {
+		return nil, fmt.Errorf("invalid network configuration: %w", err)
+	}
+	if rawPlugins, isList := pluginConfig["plugins"]; isList {
+		var plugins []map[string]json.RawMessage
+		if err := json.Unmarshal(rawPlugins, &plugins); err != nil {
+			return nil, fmt.Errorf("invalid plugins: %w", err)
+		}
+		listConfig := pluginConfig
+		pluginConfig = nil
+		for _, plugin := range plugins {
+			if isWhereaboutsPlugin(plugin) {
+				pluginConfig = plugin
+				pluginConfig["name"], pluginConfig["cniVersion"] = listConfig["name"], listConfig["cniVersion"]
+				break
+			}
+		}
+	}
+	if pluginConfig == nil || !isWhereaboutsPlugin(pluginConfig) {
+		return nil, fmt.Errorf("the network configuration has no %s IPAM", whereaboutsIPAM)
+	}
+
+	delete(pluginConfig, "args")
+	if len(requestedIPs) > 0 {
+		netArgs, err := json.Marshal(types.NetArgs{CNI: &types.CNIArgs{IPs: requestedIPs}})
+		if err != nil {
+			return nil, err
+		}
+		pluginConfig["args"] = netArgs
+	}
+	return json.Marshal(pluginConfig)
+}
+
+func isWhereaboutsPlugin(pluginConfig map[string]json.RawMessage) bool {
+	var ipam struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal(pluginConfig["ipam"], &ipam) == nil && ipam.Type == whereaboutsIPAM
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason metav1.StatusReason, message string) {
+	writeJSON(w, code, &metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Message:  message,
+		Reason:   reason,
+		Code:     int32(code),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, object interface{}) {
+	w.Header().Set(contentTypeHeader, jsonContentType)
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(object); err != nil {
+		logging.Debugf("failed to write the allocation API response: %v", err)
+	}
+}
@@ -0,0 +1,397 @@
+package allocationapi
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/daemon"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+const (
+	proxyName = "front-proxy-client"
+	nodeUser  = "system:serviceaccount:kube-system:whereabouts-node"
+	nodeName  = "node1"
+	podArgs   = "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod;K8S_POD_UID=pod-uid;IP=10.0.0.200"
+)
+
+const netAttachDefConfig = `{
+  "cniVersion": "0.3.1",
+  "name": "net",
+  "plugins": [
+    {
+      "type": "macvlan",
+      "ipam": {
+        "type": "whereabouts",
+        "range": "10.0.0.0/24"
+      }
+    },
+    {
+      "type": "tuning"
+    }
+  ]
+}`
+
+type fakeManager struct {
+	modes    []int
+	requests []daemon.Request
+	err      error
+}
+
+func (m *fakeManager) Manage(_ context.Context, mode int, request daemon.Request) ([]net.IPNet, error) {
+	m.modes = append(m.modes, mode)
+	m.requests = append(m.requests, request)
+	if m.err != nil {
+		return nil, m.err
+	}
+	if request.ContainerID == "" {
+		return nil, fmt.Errorf("missing container ID")
+	}
+	return []net.IPNet{{IP: net.ParseIP("10.0.0.1"), Mask: net.CIDRMask(24, 32)}}, nil
+}
+
+// allowCreate authorizes the node users only
+func allowCreate(_ context.Context, review *authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error) {
+	attributes := review.Spec.ResourceAttributes
+	isNodeUser := review.Spec.User == nodeUser || strings.HasPrefix(review.Spec.User, nodeUserPrefix)
+	review.Status.Allowed = isNodeUser && attributes.Verb == createVerb && attributes.Group == GroupName
+	return review, nil
+}
+
+// getPod returns the pod of the CNI args, running on the node
+func getPod(_ context.Context, namespace, name string) (*v1.Pod, error) {
+	if namespace != "default" || name != "pod" {
+		return nil, k8serrors.NewNotFound(v1.Resource("pods"), name)
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			UID:         "pod-uid",
+			Annotations: map[string]string{nadv1.NetworkAttachmentAnnot: `[{"name": "net", "ips": ["10.0.0.5"]}]`},
+		},
+		Spec: v1.PodSpec{NodeName: nodeName},
+	}, nil
+}
+
+func getNetAttachDef(_ context.Context, namespace, name string) (*nadv1.NetworkAttachmentDefinition, error) {
+	if namespace != "default" || name != "net" {
+		return nil, k8serrors.NewNotFound(nadv1.Resource("network-attachment-definitions"), name)
+	}
+	return &nadv1.NetworkAttachmentDefinition{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       nadv1.NetworkAttachmentDefinitionSpec{Config: netAttachDefConfig},
+	}, nil
+}
+
+func newTestServer(manager manager) *Server {
+	return &Server{
+		manager: manager,
+		requestHeader: RequestHeaderConfig{
+			AllowedNames:        []string{proxyName},
+			UsernameHeaders:     []string{defaultUserHeader},
+			GroupHeaders:        []string{defaultGroupHeader},
+			ExtraHeaderPrefixes: []string{defaultExtraPrefix},
+		},
+		reviewAccess:    allowCreate,
+		getPod:          getPod,
+		getNetAttachDef: getNetAttachDef,
+	}
+}
+
+// proxied has the requests look proxied by the aggregation layer for the user, bound to the node when set
+func proxied(handler http.Handler, commonName, user, node string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if commonName != "" {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: commonName}}}}}
+		}
+		if user != "" {
+			r.Header.Set(defaultUserHeader, user)
+		}
+		if node != "" {
+			r.Header.Set(defaultExtraPrefix+"Authentication.kubernetes.io%2fnode-name", node)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user: {}
+`, server.URL)
+	path := filepath.Join(t.TempDir(), "whereabouts.kubeconfig")
+	if err := os.WriteFile(path, []byte(kubeconfig), 0600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	client, err := NewClient(types.KubernetesConfig{KubeConfigPath: path})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return client
+}
+
+func TestAllocationAPI(t *testing.T) {
+	request := daemon.Request{ContainerID: "container", IfName: "net1", Args: podArgs, Config: []byte(`{"ipam": {"type": "whereabouts", "range": "192.168.0.0/16"}}`)}
+	cases := []struct {
+		name        string
+		commonName  string
+		user        string
+		node        string
+		managerErr  error
+		expectedErr string
+	}{
+		{
+			name:       "allowed user",
+			commonName: proxyName,
+			user:       nodeUser,
+			node:       nodeName,
+		},
+		{
+			name:        "not proxied",
+			user:        nodeUser,
+			node:        nodeName,
+			expectedErr: "only serves the requests of the aggregation layer",
+		},
+		{
+			name:        "not an allowed proxy",
+			commonName:  "someone",
+			user:        nodeUser,
+			node:        nodeName,
+			expectedErr: `client certificate "someone" is not allowed to proxy requests`,
+		},
+		{
+			name:        "no user",
+			commonName:  proxyName,
+			expectedErr: "the request names no user",
+		},
+		{
+			name:        "forbidden user",
+			commonName:  proxyName,
+			user:        "system:anonymous",
+			expectedErr: `user "system:anonymous" cannot create resource "allocations"`,
+		},
+		{
+			name:        "user bound to no node",
+			commonName:  proxyName,
+			user:        nodeUser,
+			expectedErr: "is neither a node nor bound to one",
+		},
+		{
+			name:        "pod of another node",
+			commonName:  proxyName,
+			user:        nodeUser,
+			node:        "node2",
+			expectedErr: "it does not run on node node2",
+		},
+		{
+			name:        "failed allocation",
+			commonName:  proxyName,
+			user:        nodeUser,
+			node:        nodeName,
+			managerErr:  fmt.Errorf("pool exhausted"),
+			expectedErr: "pool exhausted",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			manager := &fakeManager{err: tc.managerErr}
+			handler := newTestServer(manager).NewHTTPServer("").Handler
+			client := newTestClient(t, proxied(handler, tc.commonName, tc.user, tc.node))
+
+			ips, err := client.Allocate(context.TODO(), request)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("Expected error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(ips) != 1 || ips[0].String() != "10.0.0.1/24" {
+				t.Errorf("Expected the allocated address 10.0.0.1/24, got %v", ips)
+			}
+
+			if err := client.Check(context.TODO(), request); err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if err := client.Deallocate(context.TODO(), request); err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			expectedModes := []int{types.Allocate, types.Check, types.Deallocate}
+			if fmt.Sprint(manager.modes) != fmt.Sprint(expectedModes) {
+				t.Errorf("Expected the modes %v, got %v", expectedModes, manager.modes)
+			}
+		})
+	}
+}
+
+func TestAllocationAPIUsesThePodNetwork(t *testing.T) {
+	manager := &fakeManager{}
+	handler := newTestServer(manager).NewHTTPServer("").Handler
+	client := newTestClient(t, proxied(handler, proxyName, "system:node:"+nodeName, ""))
+
+	request := daemon.Request{ContainerID: "container", IfName: "net1", Args: podArgs, Config: []byte(`{"ipam": {"type": "whereabouts", "range": "192.168.0.0/16"}}`)}
+	if _, err := client.Allocate(context.TODO(), request); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(manager.requests) != 1 {
+		t.Fatalf("Expected one request served, got %d", len(manager.requests))
+	}
+	served := manager.requests[0]
+	for _, expected := range []string{`"range":"10.0.0.0/24"`, `"name":"net"`, `"ips":["10.0.0.5"]`} {
+		if !strings.Contains(string(served.Config), expected) {
+			t.Errorf("Expected the configuration of the network-attachment-definition, with %s, got %s", expected, served.Config)
+		}
+	}
+	if strings.Contains(string(served.Config), "192.168.0.0/16") {
+		t.Errorf("Expected the configuration of the request to be ignored, got %s", served.Config)
+	}
+	if strings.Contains(served.Args, "IP=") {
+		t.Errorf("Expected the IPs of the CNI args to be dropped, got %s", served.Args)
+	}
+
+	request.IfName = "net2"
+	if _, err := client.Allocate(context.TODO(), request); err == nil || !strings.Contains(err.Error(), "selects no network for interface net2") {
+		t.Errorf("Expected the interface the pod does not select to be forbidden, got %v", err)
+	}
+}
+
+func TestAllocationAPIReleasesGonePods(t *testing.T) {
+	manager := &fakeManager{}
+	handler := newTestServer(manager).NewHTTPServer("").Handler
+	client := newTestClient(t, proxied(handler, proxyName, nodeUser, nodeName))
+
+	for _, args := range []string{
+		"K8S_POD_NAMESPACE=default;K8S_POD_NAME=deleted-pod",
+		"K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod;K8S_POD_UID=previous-pod-uid",
+	} {
+		request := daemon.Request{ContainerID: "container", IfName: "net1", Args: args}
+		if err := client.Deallocate(context.TODO(), request); err != nil {
+			t.Errorf("Expected no error releasing a gone pod, got %v", err)
+		}
+		if _, err := client.Allocate(context.TODO(), request); err == nil || !strings.Contains(err.Error(), "does not exist") {
+			t.Errorf("Expected the allocation of a gone pod to be forbidden, got %v", err)
+		}
+	}
+	if len(manager.modes) != 0 {
+		t.Errorf("Expected no request served, got the modes %v", manager.modes)
+	}
+}
+
+func TestWhereaboutsPluginConfig(t *testing.T) {
+	if _, err := whereaboutsPluginConfig(`{"cniVersion": "0.3.1", "name": "net", "type": "macvlan", "ipam": {"type": "host-local"}}`, nil); err == nil {
+		t.Errorf("Expected an error for a network configuration of another IPAM")
+	}
+
+	pluginConfig, err := whereaboutsPluginConfig(`{"cniVersion": "0.3.1", "name": "net", "type": "macvlan", "args": {"cni": {"ips": ["10.0.0.9"]}}, "ipam": {"type": "whereabouts", "range": "10.0.0.0/24"}}`, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if strings.Contains(string(pluginConfig), "10.0.0.9") {
+		t.Errorf("Expected the args of the network configuration to be dropped, got %s", pluginConfig)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	handler := proxied(newTestServer(&fakeManager{}).NewHTTPServer("").Handler, proxyName, nodeUser, nodeName)
+	client := newTestClient(t, handler)
+
+	resourceList, err := client.restClient.Get().AbsPath(versionPath).Do(context.TODO()).Raw()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, resource := range []string{AllocationsResource, ReleasesResource, ChecksResource} {
+		if !strings.Contains(string(resourceList), fmt.Sprintf(`"name":%q`, resource)) {
+			t.Errorf("Expected the discovery of resource %s, got %s", resource, resourceList)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, healthzPath, nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected the health check to succeed, got status %d", recorder.Code)
+	}
+}
+
+func TestNewRequestHeaderConfig(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "front-proxy-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	caBundle := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+
+	requestHeader, err := newRequestHeaderConfig(map[string]string{
+		requestHeaderCAKey: caBundle,
+		allowedNamesKey:    `["front-proxy-client"]`,
+		userHeadersKey:     `["X-Remote-User"]`,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(requestHeader.AllowedNames) != 1 || requestHeader.AllowedNames[0] != proxyName {
+		t.Errorf("Expected the allowed names [%s], got %v", proxyName, requestHeader.AllowedNames)
+	}
+	if len(requestHeader.GroupHeaders) != 1 || requestHeader.GroupHeaders[0] != defaultGroupHeader {
+		t.Errorf("Expected the default group header, got %v", requestHeader.GroupHeaders)
+	}
+
+	if _, err := newRequestHeaderConfig(map[string]string{}); err == nil {
+		t.Errorf("Expected an error without the client CA")
+	}
+	if _, err := newRequestHeaderConfig(map[string]string{requestHeaderCAKey: caBundle, allowedNamesKey: "front-proxy-client"}); err == nil {
+		t.Errorf("Expected an error with invalid allowed names")
+	}
+}
@@ -0,0 +1,96 @@
+package allocationapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/daemon"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// Client forwards the CNI ADD, DEL and CHECK of the plugin to the allocation API, through the Kubernetes API server
+type Client struct {
+	restClient rest.Interface
+}
+
+// NewClient returns a Client of the allocation API authenticating with the kubeconfig, or its token file, whose user
+// only needs to be allowed to create the allocations, releases and checks
+func NewClient(kubernetesConfig types.KubernetesConfig) (*Client, error) {
+	config, err := kubernetes.PluginClientConfig(kubernetesConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the kubeconfig %s: %w", kubernetesConfig.KubeConfigPath, err)
+	}
+	kubernetes.ConfigureUserAgent(config, kubernetes.AllocationUserAgent, kubernetes.RateLimit{})
+	clientSet, err := k8sclient.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{restClient: clientSet.Discovery().RESTClient()}, nil
+}
+
+// Allocate has the allocation API allocate the addresses of the request
+func (c *Client) Allocate(ctx context.Context, request daemon.Request) ([]net.IPNet, error) {
+	created, err := c.create(ctx, AllocationsResource, "Allocation", request)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IPNet
+	for _, cidr := range created.Status.IPs {
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("the allocation API returned an invalid address %q: %w", cidr, err)
+		}
+		ips = append(ips, net.IPNet{IP: ip, Mask: ipNet.Mask})
+	}
+	return ips, nil
+}
+
+// Deallocate has the allocation API release the addresses of the request
+func (c *Client) Deallocate(ctx context.Context, request daemon.Request) error {
+	_, err := c.create(ctx, ReleasesResource, "Release", request)
+	return err
+}
+
+// Check has the allocation API verify the addresses of the request are still allocated to the pod
+func (c *Client) Check(ctx context.Context, request daemon.Request) error {
+	_, err := c.create(ctx, ChecksResource, "Check", request)
+	return err
+}
+
+func (c *Client) create(ctx context.Context, resource, kind string, request daemon.Request) (*Request, error) {
+	body, err := json.Marshal(&Request{
+		TypeMeta: metav1.TypeMeta{Kind: kind, APIVersion: groupVersion},
+		Spec:     request,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := c.restClient.Post().
+		AbsPath(apisPath, GroupName, Version, resource).
+		SetHeader(contentTypeHeader, jsonContentType).
+		Body(body).
+		Do(ctx).
+		Raw()
+	if err != nil {
+		// the status of the failure tells why, rather than its code
+		status := &metav1.Status{}
+		if json.Unmarshal(raw, status) == nil && status.Message != "" {
+			return nil, fmt.Errorf("whereabouts allocation API: %s", status.Message)
+		}
+		return nil, fmt.Errorf("whereabouts allocation API: %w", err)
+	}
+	created := &Request{}
+	if err := json.Unmarshal(raw, created); err != nil {
+		return nil, fmt.Errorf("invalid response of the whereabouts allocation API: %w", err)
+	}
+	return created, nil
+}
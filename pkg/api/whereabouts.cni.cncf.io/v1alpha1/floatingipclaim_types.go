@@ -0,0 +1,59 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FloatingIPClaimSpec defines the desired state of FloatingIPClaim
+type FloatingIPClaimSpec struct {
+	// Pool is the name of the IPPool the floating IP is claimed from
+	Pool string `json:"pool"`
+
+	// PoolNamespace is the namespace of the IPPool, set to the pool_namespace of its network if any. Defaults to the
+	// namespace of the whereabouts deployment.
+	PoolNamespace string `json:"poolNamespace,omitempty"`
+
+	// IP is the address claimed from the pool, any free address of the pool when empty. Pool and IP are only read
+	// until the claim is bound.
+	IP string `json:"ip,omitempty"`
+
+	// Holder is the name of the pod, in the namespace of the claim, the floating IP is assigned to. The IP is handed
+	// off on its changes, and stays with the claim while no pod holds it.
+	Holder string `json:"holder,omitempty"`
+
+	// IfName is the interface of the holder the floating IP is assigned to
+	IfName string `json:"ifName,omitempty"`
+}
+
+// FloatingIPClaimStatus defines the observed state of FloatingIPClaim
+type FloatingIPClaimStatus struct {
+	// IP is the floating IP bound to the claim
+	IP string `json:"ip,omitempty"`
+
+	// HolderRef is the pod the floating IP is assigned to, as namespace/name, empty while no pod holds it
+	HolderRef string `json:"holderRef,omitempty"`
+
+	// Message explains why the claim could not be bound, or handed off to its holder
+	Message string `json:"message,omitempty"`
+}
+
+// +genclient
+// +kubebuilder:object:root=true
+
+// FloatingIPClaim is the Schema for the floatingipclaims API
+type FloatingIPClaim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FloatingIPClaimSpec   `json:"spec,omitempty"`
+	Status FloatingIPClaimStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// FloatingIPClaimList contains a list of FloatingIPClaim
+type FloatingIPClaimList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FloatingIPClaim `json:"items"`
+}
@@ -1,7 +1,11 @@
 package v1alpha1
 
 import (
+	"bytes"
+	"encoding/json"
 	"net"
+	"sort"
+	"strconv"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -10,11 +14,116 @@ import (
 type IPPoolSpec struct {
 	// Range is a RFC 4632/4291-style string that represents an IP address and prefix length in CIDR notation
 	Range string `json:"range"`
+	// RangeSet are the CIDRs of the range set the pool allocates from, in order, when the range spans non-contiguous
+	// networks. Range is the first of them, the offsets of the allocations counting from its first IP.
+	RangeSet []string `json:"rangeSet,omitempty"`
 	// Allocations is the set of allocated IPs for the given range. Its` indices are a direct mapping to the
 	// IP with the same index/offset for the pool's range.
 	Allocations map[string]IPAllocation `json:"allocations"`
 }
 
+// MarshalJSON serializes the allocations in the numeric order of their offsets - rather than in the lexical order
+// of their keys - keeping the serialized pools stable and their diffs minimal.
+func (s IPPoolSpec) MarshalJSON() ([]byte, error) {
+	type ipPoolSpec IPPoolSpec
+	return json.Marshal(struct {
+		ipPoolSpec
+		Allocations orderedAllocations `json:"allocations"`
+	}{
+		ipPoolSpec:  ipPoolSpec(s),
+		Allocations: orderedAllocations(s.Allocations),
+	})
+}
+
+// orderedAllocations serializes the allocations sorted by offset. Keys which are not offsets are sorted lexically,
+// after the offsets.
+type orderedAllocations map[string]IPAllocation
+
+func (a orderedAllocations) MarshalJSON() ([]byte, error) {
+	if a == nil {
+		return []byte("null"), nil
+	}
+
+	keys := make([]string, 0, len(a))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		offsetI, errI := strconv.ParseUint(keys[i], 10, 64)
+		offsetJ, errJ := strconv.ParseUint(keys[j], 10, 64)
+		switch {
+		case errI == nil && errJ == nil:
+			return offsetI < offsetJ
+		case errI == nil || errJ == nil:
+			return errI == nil
+		default:
+			return keys[i] < keys[j]
+		}
+	})
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		encodedAllocation, err := json.Marshal(a[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encodedKey)
+		buf.WriteByte(':')
+		buf.Write(encodedAllocation)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// IPPoolStatus defines the observed state of IPPool
+type IPPoolStatus struct {
+	// Reservations is the set of addresses of the range set aside for network services (e.g. gateways, VRRP),
+	// which are never allocated to pods
+	Reservations []ServiceReservation `json:"reservations,omitempty"`
+	// RangeStart is the first IP of the range which can be allocated, honoring the range_start of the network
+	RangeStart string `json:"rangeStart,omitempty"`
+	// RangeEnd is the last IP of the range which can be allocated, honoring the range_end of the network
+	RangeEnd string `json:"rangeEnd,omitempty"`
+	// Released is the time the free IPs of the range were last released, keyed by the offset of the IP like the
+	// allocations. It is only recorded for the ranges allocated with the lru allocation strategy.
+	Released map[string]metav1.Time `json:"released,omitempty"`
+	// ExternallyUsed is the time the IPs of the range were found answering ICMP echo requests while being allocated,
+	// keyed by the offset of the IP like the allocations. These IPs are used outside of whereabouts, and not
+	// allocated to pods until the quarantine of the network expires - never, without one.
+	ExternallyUsed map[string]metav1.Time `json:"externallyUsed,omitempty"`
+	// Orphaned is the time the IP reconciler first found the allocations of the range orphaned, keyed by the offset of
+	// the IP like the allocations. The grace period of the pool runs from then.
+	Orphaned map[string]metav1.Time `json:"orphaned,omitempty"`
+	// StickyIPs is the offset of the IP last allocated to each pod, keyed by the pod reference - or by the MAC address
+	// of the interface, for the networks keying their reservations by MAC address. The pod is allocated that IP again,
+	// provided it is free, e.g. when a StatefulSet pod is rescheduled. It is only recorded for the networks enabling
+	// sticky IPs or keying their reservations by MAC address.
+	StickyIPs map[string]string `json:"stickyIPs,omitempty"`
+	// Conditions are the observed conditions of the pool, e.g. Exhausted while the allocations fail for lack of a
+	// free IP
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// IPPoolExhausted is the type of the condition of an IP pool which had no free IP left for the last allocation. It
+// is cleared by the next update of the allocations of the pool.
+const IPPoolExhausted = "Exhausted"
+
+// ServiceReservation represents an address of the range reserved for a named network service
+type ServiceReservation struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+}
+
 // ParseCIDR formats the Range of the IPPool
 func (i IPPool) ParseCIDR() (net.IP, *net.IPNet, error) {
 	return net.ParseCIDR(i.Spec.Range)
@@ -24,7 +133,14 @@ func (i IPPool) ParseCIDR() (net.IP, *net.IPNet, error) {
 type IPAllocation struct {
 	ContainerID string `json:"id"`
 	PodRef      string `json:"podref"`
+	PodUID      string `json:"poduid,omitempty"`
 	IfName      string `json:"ifname,omitempty"`
+	// MAC is the MAC address of the interface the IP is reserved for, recorded by the networks keying their
+	// reservations by MAC address
+	MAC string `json:"mac,omitempty"`
+
+	// AllocatedAt is the time the IP was allocated to the pod of PodUID
+	AllocatedAt *metav1.Time `json:"allocatedAt,omitempty"`
 }
 
 // +genclient
@@ -35,7 +151,8 @@ type IPPool struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec IPPoolSpec `json:"spec,omitempty"`
+	Spec   IPPoolSpec   `json:"spec,omitempty"`
+	Status IPPoolStatus `json:"status,omitempty"`
 }
 
 // +kubebuilder:object:root=true
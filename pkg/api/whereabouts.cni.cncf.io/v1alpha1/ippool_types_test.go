@@ -0,0 +1,70 @@
+package v1alpha1
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestIPPoolSpecMarshalJSON(t *testing.T) {
+	cases := []struct {
+		name     string
+		spec     IPPoolSpec
+		expected string
+	}{
+		{
+			name: "Allocations in numeric order",
+			spec: IPPoolSpec{
+				Range: "10.0.0.0/24",
+				Allocations: map[string]IPAllocation{
+					"10": {ContainerID: "c", PodRef: "default/pod10"},
+					"2":  {ContainerID: "a", PodRef: "default/pod2", IfName: "net1"},
+					"9":  {ContainerID: "b", PodRef: "default/pod9"},
+				},
+			},
+			expected: `{"range":"10.0.0.0/24","allocations":{` +
+				`"2":{"id":"a","podref":"default/pod2","ifname":"net1"},` +
+				`"9":{"id":"b","podref":"default/pod9"},` +
+				`"10":{"id":"c","podref":"default/pod10"}}}`,
+		},
+		{
+			name: "Keys which are not offsets last",
+			spec: IPPoolSpec{
+				Range: "10.0.0.0/24",
+				Allocations: map[string]IPAllocation{
+					"b":  {PodRef: "default/b"},
+					"11": {PodRef: "default/pod11"},
+					"a":  {PodRef: "default/a"},
+				},
+			},
+			expected: `{"range":"10.0.0.0/24","allocations":{` +
+				`"11":{"id":"","podref":"default/pod11"},` +
+				`"a":{"id":"","podref":"default/a"},` +
+				`"b":{"id":"","podref":"default/b"}}}`,
+		},
+		{
+			name:     "No allocations",
+			spec:     IPPoolSpec{Range: "10.0.0.0/24"},
+			expected: `{"range":"10.0.0.0/24","allocations":null}`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			serialized, err := json.Marshal(tc.spec)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if string(serialized) != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, serialized)
+			}
+
+			var spec IPPoolSpec
+			if err := json.Unmarshal(serialized, &spec); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !reflect.DeepEqual(spec, tc.spec) {
+				t.Errorf("Expected %+v, got %+v", tc.spec, spec)
+			}
+		})
+	}
+}
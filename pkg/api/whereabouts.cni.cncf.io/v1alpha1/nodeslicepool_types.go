@@ -14,6 +14,19 @@ type NodeSlicePoolSpec struct {
 
 	// SliceSize is the size of subnets or slices of the range that each node will be assigned
 	SliceSize string `json:"sliceSize"`
+
+	// AdditionalRanges are the ranges of the other IP family of a dual-stack network, sliced along with Range: each
+	// node is assigned a slice of every range
+	AdditionalRanges []NodeSliceRange `json:"additionalRanges,omitempty"`
+}
+
+// NodeSliceRange is a range divided in slices of the given size
+type NodeSliceRange struct {
+	// Range is a RFC 4632/4291-style string that represents an IP address and prefix length in CIDR notation
+	Range string `json:"range"`
+
+	// SliceSize is the size of subnets or slices of the range that each node will be assigned
+	SliceSize string `json:"sliceSize"`
 }
 
 // NodeSlicePoolStatus defines the desired state of NodeSlicePool
@@ -28,6 +41,10 @@ type NodeSliceAllocation struct {
 
 	// SliceRange is the subnet of this slice
 	SliceRange string `json:"sliceRange"`
+
+	// AdditionalSliceRanges are the subnets of the slices of the AdditionalRanges, in their order, assigned to the node
+	// along with SliceRange
+	AdditionalSliceRanges []string `json:"additionalSliceRanges,omitempty"`
 }
 
 // ParseCIDR formats the Range of the IPPool
@@ -60,6 +60,10 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 		&OverlappingRangeIPReservationList{},
 		&NodeSlicePool{},
 		&NodeSlicePoolList{},
+		&FloatingIPClaim{},
+		&FloatingIPClaimList{},
+		&WhereaboutsConfig{},
+		&WhereaboutsConfigList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
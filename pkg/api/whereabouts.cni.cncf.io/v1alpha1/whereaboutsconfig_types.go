@@ -0,0 +1,61 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WhereaboutsConfigSpec defines the desired state of WhereaboutsConfig
+type WhereaboutsConfigSpec struct {
+	// Reconciler are the settings of the IP reconciler runs the IP control loop schedules
+	Reconciler ReconcilerConfig `json:"reconciler,omitempty"`
+}
+
+// ReconcilerConfig are the settings of the IP reconciler runs, applied by the IP control loop as they change
+type ReconcilerConfig struct {
+	// Schedule is the cron expression of the runs. The cron expression of the cron schedule config map, or else the
+	// reconciler_cron_expression of the flat file, applies when neither Schedule nor Interval are set.
+	Schedule string `json:"schedule,omitempty"`
+
+	// Interval is the period between two runs, e.g. 30m, in place of a cron expression. Schedule takes precedence.
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Concurrency is the number of IP pools a run updates concurrently, one at a time when unset
+	// +kubebuilder:validation:Minimum=0
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// DryRun has the runs only report what they would clean up, without updating the IP pools nor deleting the
+	// cluster wide reservations
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// MaxChurnPercent is the share of a pool's allocations a run may delete, 50 when unset. The pools exceeding it are
+	// left untouched, and the run reports them.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	MaxChurnPercent int `json:"maxChurnPercent,omitempty"`
+
+	// AllowMassDeletion has the runs delete the orphaned allocations of the pools even above MaxChurnPercent, e.g.
+	// after a mass pod deletion
+	AllowMassDeletion bool `json:"allowMassDeletion,omitempty"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// WhereaboutsConfig is the Schema for the whereaboutsconfigs API
+type WhereaboutsConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec WhereaboutsConfigSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WhereaboutsConfigList contains a list of WhereaboutsConfig
+type WhereaboutsConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WhereaboutsConfig `json:"items"`
+}
@@ -5,12 +5,106 @@
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FloatingIPClaim) DeepCopyInto(out *FloatingIPClaim) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FloatingIPClaim.
+func (in *FloatingIPClaim) DeepCopy() *FloatingIPClaim {
+	if in == nil {
+		return nil
+	}
+	out := new(FloatingIPClaim)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *FloatingIPClaim) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FloatingIPClaimList) DeepCopyInto(out *FloatingIPClaimList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]FloatingIPClaim, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FloatingIPClaimList.
+func (in *FloatingIPClaimList) DeepCopy() *FloatingIPClaimList {
+	if in == nil {
+		return nil
+	}
+	out := new(FloatingIPClaimList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *FloatingIPClaimList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FloatingIPClaimSpec) DeepCopyInto(out *FloatingIPClaimSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FloatingIPClaimSpec.
+func (in *FloatingIPClaimSpec) DeepCopy() *FloatingIPClaimSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(FloatingIPClaimSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FloatingIPClaimStatus) DeepCopyInto(out *FloatingIPClaimStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FloatingIPClaimStatus.
+func (in *FloatingIPClaimStatus) DeepCopy() *FloatingIPClaimStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(FloatingIPClaimStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IPAllocation) DeepCopyInto(out *IPAllocation) {
 	*out = *in
+	if in.AllocatedAt != nil {
+		in, out := &in.AllocatedAt, &out.AllocatedAt
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPAllocation.
@@ -29,6 +123,7 @@ func (in *IPPool) DeepCopyInto(out *IPPool) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPPool.
@@ -84,11 +179,16 @@ func (in *IPPoolList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IPPoolSpec) DeepCopyInto(out *IPPoolSpec) {
 	*out = *in
+	if in.RangeSet != nil {
+		in, out := &in.RangeSet, &out.RangeSet
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.Allocations != nil {
 		in, out := &in.Allocations, &out.Allocations
 		*out = make(map[string]IPAllocation, len(*in))
 		for key, val := range *in {
-			(*out)[key] = val
+			(*out)[key] = *val.DeepCopy()
 		}
 	}
 }
@@ -103,9 +203,69 @@ func (in *IPPoolSpec) DeepCopy() *IPPoolSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPPoolStatus) DeepCopyInto(out *IPPoolStatus) {
+	*out = *in
+	if in.Reservations != nil {
+		in, out := &in.Reservations, &out.Reservations
+		*out = make([]ServiceReservation, len(*in))
+		copy(*out, *in)
+	}
+	if in.Released != nil {
+		in, out := &in.Released, &out.Released
+		*out = make(map[string]v1.Time, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.ExternallyUsed != nil {
+		in, out := &in.ExternallyUsed, &out.ExternallyUsed
+		*out = make(map[string]v1.Time, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.Orphaned != nil {
+		in, out := &in.Orphaned, &out.Orphaned
+		*out = make(map[string]v1.Time, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.StickyIPs != nil {
+		in, out := &in.StickyIPs, &out.StickyIPs
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPPoolStatus.
+func (in *IPPoolStatus) DeepCopy() *IPPoolStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(IPPoolStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeSliceAllocation) DeepCopyInto(out *NodeSliceAllocation) {
 	*out = *in
+	if in.AdditionalSliceRanges != nil {
+		in, out := &in.AdditionalSliceRanges, &out.AdditionalSliceRanges
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeSliceAllocation.
@@ -123,7 +283,7 @@ func (in *NodeSlicePool) DeepCopyInto(out *NodeSlicePool) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -180,6 +340,11 @@ func (in *NodeSlicePoolList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeSlicePoolSpec) DeepCopyInto(out *NodeSlicePoolSpec) {
 	*out = *in
+	if in.AdditionalRanges != nil {
+		in, out := &in.AdditionalRanges, &out.AdditionalRanges
+		*out = make([]NodeSliceRange, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeSlicePoolSpec.
@@ -192,13 +357,30 @@ func (in *NodeSlicePoolSpec) DeepCopy() *NodeSlicePoolSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeSliceRange) DeepCopyInto(out *NodeSliceRange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeSliceRange.
+func (in *NodeSliceRange) DeepCopy() *NodeSliceRange {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeSliceRange)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeSlicePoolStatus) DeepCopyInto(out *NodeSlicePoolStatus) {
 	*out = *in
 	if in.Allocations != nil {
 		in, out := &in.Allocations, &out.Allocations
 		*out = make([]NodeSliceAllocation, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 }
 
@@ -284,3 +466,112 @@ func (in *OverlappingRangeIPReservationSpec) DeepCopy() *OverlappingRangeIPReser
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReconcilerConfig) DeepCopyInto(out *ReconcilerConfig) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReconcilerConfig.
+func (in *ReconcilerConfig) DeepCopy() *ReconcilerConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ReconcilerConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceReservation) DeepCopyInto(out *ServiceReservation) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceReservation.
+func (in *ServiceReservation) DeepCopy() *ServiceReservation {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceReservation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WhereaboutsConfig) DeepCopyInto(out *WhereaboutsConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WhereaboutsConfig.
+func (in *WhereaboutsConfig) DeepCopy() *WhereaboutsConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(WhereaboutsConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WhereaboutsConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WhereaboutsConfigList) DeepCopyInto(out *WhereaboutsConfigList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WhereaboutsConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WhereaboutsConfigList.
+func (in *WhereaboutsConfigList) DeepCopy() *WhereaboutsConfigList {
+	if in == nil {
+		return nil
+	}
+	out := new(WhereaboutsConfigList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WhereaboutsConfigList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WhereaboutsConfigSpec) DeepCopyInto(out *WhereaboutsConfigSpec) {
+	*out = *in
+	in.Reconciler.DeepCopyInto(&out.Reconciler)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WhereaboutsConfigSpec.
+func (in *WhereaboutsConfigSpec) DeepCopy() *WhereaboutsConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WhereaboutsConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -6,15 +6,23 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	cnitypes "github.com/containernetworking/cni/pkg/types"
 	"github.com/imdario/mergo"
 
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/leaderelection"
 	netutils "k8s.io/utils/net"
 
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/platform"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
 )
 
@@ -30,14 +38,30 @@ func canonicalizeIP(ip *net.IP) error {
 	return fmt.Errorf("IP %s not v4 nor v6", *ip)
 }
 
+// ConfigureLogging sets the process wide logging up after the log_file, log_level and log_format of the configuration.
+// Only the plugin, serving a single invocation, does so: the long-running processes loading the configurations of many
+// networks configure their logging once, on startup.
+func ConfigureLogging(ipamConf *types.IPAMConfig) {
+	if ipamConf.LogFile != "" {
+		logging.SetLogFile(ipamConf.LogFile)
+	}
+	if ipamConf.LogLevel != "" {
+		logging.SetLogLevel(ipamConf.LogLevel)
+	}
+	if ipamConf.LogFormat != "" {
+		logging.SetLogFormat(ipamConf.LogFormat)
+	}
+}
+
 // LoadIPAMConfig creates IPAMConfig using json encoded configuration provided
 // as `bytes`. At the moment values provided in envArgs are ignored so there
-// is no possibility to overload the json configuration using envArgs
+// is no possibility to overload the json configuration using envArgs. The logging
+// configuration is not applied, see ConfigureLogging.
 func LoadIPAMConfig(bytes []byte, envArgs string, extraConfigPaths ...string) (*types.IPAMConfig, string, error) {
 
 	var n types.Net
-	if err := json.Unmarshal(bytes, &n); err != nil {
-		return nil, "", fmt.Errorf("LoadIPAMConfig - JSON Parsing Error: %s / bytes: %s", err, bytes)
+	if err := UnmarshalNetConf(bytes, &n); err != nil {
+		return nil, "", fmt.Errorf("LoadIPAMConfig - JSON Parsing Error: %w", err)
 	}
 
 	if n.IPAM == nil {
@@ -45,6 +69,7 @@ func LoadIPAMConfig(bytes []byte, envArgs string, extraConfigPaths ...string) (*
 	} else if !isNetworkRelevant(n.IPAM) {
 		return nil, "", NewInvalidPluginError(n.IPAM.Type)
 	}
+	warnDeprecatedParameters(n.Name, bytes)
 
 	args := types.IPAMEnvArgs{}
 	if err := cnitypes.LoadArgs(envArgs, &args); err != nil {
@@ -52,6 +77,8 @@ func LoadIPAMConfig(bytes []byte, envArgs string, extraConfigPaths ...string) (*
 	}
 	n.IPAM.PodName = string(args.K8S_POD_NAME)
 	n.IPAM.PodNamespace = string(args.K8S_POD_NAMESPACE)
+	n.IPAM.PodUID = string(args.K8S_POD_UID)
+	n.IPAM.MAC = string(args.MAC)
 
 	flatipam, foundflatfile, err := GetFlatIPAM(false, n.IPAM, extraConfigPaths...)
 	if err != nil {
@@ -61,28 +88,30 @@ func LoadIPAMConfig(bytes []byte, envArgs string, extraConfigPaths ...string) (*
 	// Now let's try to merge the configurations...
 	// NB: Don't try to do any initialization before this point or it won't account for merged flat file.
 	var OverlappingRanges bool = n.IPAM.OverlappingRanges
+	foreignRanges := n.IPAM.ForeignRanges
 	if err := mergo.Merge(&n, flatipam); err != nil {
 		logging.Errorf("Merge error with flat file: %s", err)
 	}
 	n.IPAM.OverlappingRanges = OverlappingRanges
-
-	// Logging
-	if n.IPAM.LogFile != "" {
-		logging.SetLogFile(n.IPAM.LogFile)
-	}
-	if n.IPAM.LogLevel != "" {
-		logging.SetLogLevel(n.IPAM.LogLevel)
+	// The foreign ranges of the flat file are cluster wide guardrails: a network configuration can add to them, not
+	// override them.
+	if flatipam.IPAM != nil {
+		n.IPAM.ForeignRanges = append(append([]string{}, flatipam.IPAM.ForeignRanges...), foreignRanges...)
 	}
 
 	if foundflatfile != "" {
 		logging.Debugf("Used defaults from parsed flat file config @ %s", foundflatfile)
 	}
 
-	if n.IPAM.Range != "" {
+	if n.IPAM.Range != "" && len(n.IPAM.RangeSet) > 0 {
+		return nil, "", fmt.Errorf("range and range_set cannot both be set: list the CIDR of the range in the range set")
+	}
+
+	if n.IPAM.Range != "" || len(n.IPAM.RangeSet) > 0 {
 
 		oldRange := types.RangeConfiguration{
-			OmitRanges: n.IPAM.OmitRanges,
 			Range:      n.IPAM.Range,
+			RangeSet:   n.IPAM.RangeSet,
 			RangeStart: n.IPAM.RangeStart,
 			RangeEnd:   n.IPAM.RangeEnd,
 		}
@@ -91,43 +120,30 @@ func LoadIPAMConfig(bytes []byte, envArgs string, extraConfigPaths ...string) (*
 	}
 
 	for idx := range n.IPAM.IPRanges {
-		if r := strings.SplitN(n.IPAM.IPRanges[idx].Range, "-", 2); len(r) == 2 {
-			firstip := netutils.ParseIPSloppy(r[0])
-			if firstip == nil {
-				return nil, "", fmt.Errorf("invalid range start IP: %s", r[0])
-			}
-			lastip, ipNet, err := netutils.ParseCIDRSloppy(r[1])
-			if err != nil {
-				return nil, "", fmt.Errorf("invalid CIDR (do you have the 'range' parameter set for Whereabouts?) '%s': %s", r[1], err)
-			}
-			if !ipNet.Contains(firstip) {
-				return nil, "", fmt.Errorf("invalid range start for CIDR %s: %s", ipNet.String(), firstip)
-			}
-			n.IPAM.IPRanges[idx].Range = ipNet.String()
-			n.IPAM.IPRanges[idx].RangeStart = firstip
-			n.IPAM.IPRanges[idx].RangeEnd = lastip
-		} else {
-			firstip, ipNet, err := netutils.ParseCIDRSloppy(n.IPAM.IPRanges[idx].Range)
-			if err != nil {
-				logging.Debugf("invalid cidr error on range %v, within ranges %v", n.IPAM.IPRanges[idx].Range, n.IPAM.IPRanges)
-				return nil, "", fmt.Errorf("invalid CIDR %s: %s", n.IPAM.IPRanges[idx].Range, err)
-			}
-			n.IPAM.IPRanges[idx].Range = ipNet.String()
-			if n.IPAM.IPRanges[idx].RangeStart == nil {
-				firstip = netutils.ParseIPSloppy(firstip.Mask(ipNet.Mask).String()) // if range_start is not net then pick the first network address
-				n.IPAM.IPRanges[idx].RangeStart = firstip
-			}
+		if err := n.IPAM.IPRanges[idx].Normalize(); err != nil {
+			logging.Debugf("invalid range %v, within ranges %v: %v", n.IPAM.IPRanges[idx].Range, n.IPAM.IPRanges, err)
+			return nil, "", err
 		}
 	}
 
+	if err := applyGlobalExcludes(n.IPAM); err != nil {
+		return nil, "", err
+	}
+
 	n.IPAM.OmitRanges = nil
 	n.IPAM.Range = ""
+	n.IPAM.RangeSet = nil
 	n.IPAM.RangeStart = nil
 	n.IPAM.RangeEnd = nil
 
-	if n.IPAM.Kubernetes.KubeConfigPath == "" {
+	// the IPAM daemon talks to the datastore on behalf of the plugin, and the etcd3 datastore needs no kubeconfig
+	if n.IPAM.Kubernetes.KubeConfigPath == "" && n.IPAM.DaemonSocket == "" && len(n.IPAM.Etcd3.Endpoints) == 0 {
 		return nil, "", storageError()
 	}
+	// the plugin reaches the allocation API with its kubeconfig
+	if n.IPAM.AllocationAPI && n.IPAM.DaemonSocket != "" {
+		return nil, "", fmt.Errorf("allocation_api and daemon_socket cannot both be set: the plugin forwards its requests to either")
+	}
 
 	if n.IPAM.GatewayStr != "" {
 		gwip := netutils.ParseIPSloppy(n.IPAM.GatewayStr)
@@ -136,27 +152,85 @@ func LoadIPAMConfig(bytes []byte, envArgs string, extraConfigPaths ...string) (*
 		}
 		n.IPAM.Gateway = gwip
 	}
-	for i := range n.IPAM.OmitRanges {
-		_, _, err := netutils.ParseCIDRSloppy(n.IPAM.OmitRanges[i])
-		if err != nil {
-			return nil, "", fmt.Errorf("invalid CIDR in exclude list %s: %s", n.IPAM.OmitRanges[i], err)
-		}
-	}
 
 	if err := configureStatic(&n, args); err != nil {
 		return nil, "", err
 	}
 
-	if n.IPAM.LeaderLeaseDuration == 0 {
-		n.IPAM.LeaderLeaseDuration = types.DefaultLeaderLeaseDuration
+	if err := validateForeignRanges(n.IPAM); err != nil {
+		return nil, "", err
+	}
+
+	switch n.IPAM.ResultOrder {
+	case "", types.ResultOrderV4First, types.ResultOrderV6First:
+	default:
+		return nil, "", fmt.Errorf("invalid result order %q, expected %q or %q", n.IPAM.ResultOrder, types.ResultOrderV4First, types.ResultOrderV6First)
+	}
+
+	if err := validateAddressFamilyPolicy(n.IPAM); err != nil {
+		return nil, "", err
+	}
+
+	if err := validatePoolShards(n.IPAM); err != nil {
+		return nil, "", err
+	}
+
+	if err := validatePoolNamespace(n.IPAM); err != nil {
+		return nil, "", err
+	}
+
+	if err := validateRangeSets(n.IPAM); err != nil {
+		return nil, "", err
+	}
+
+	if err := validateAllocationStrategies(n.IPAM); err != nil {
+		return nil, "", err
+	}
+
+	if err := validateIPv6AddressMode(n.IPAM); err != nil {
+		return nil, "", err
+	}
+
+	if err := validateGatewayStrategy(n.IPAM); err != nil {
+		return nil, "", err
+	}
+
+	if err := validateReservationKey(n.IPAM); err != nil {
+		return nil, "", err
+	}
+
+	if err := validateTracingEndpoint(n.IPAM); err != nil {
+		return nil, "", err
+	}
+
+	if err := validateNodeUnreadyGracePeriod(n.IPAM); err != nil {
+		return nil, "", err
+	}
+
+	switch n.IPAM.VerifyUnused {
+	case "", types.VerifyUnusedICMP:
+	default:
+		return nil, "", fmt.Errorf("invalid verify_unused %q, expected %q", n.IPAM.VerifyUnused, types.VerifyUnusedICMP)
+	}
+	if n.IPAM.VerifyUnusedQuarantine < 0 || n.IPAM.VerifyUnusedTimeout < 0 {
+		return nil, "", fmt.Errorf("verify_unused_quarantine and verify_unused_timeout cannot be negative")
+	}
+	if n.IPAM.VerifyUnusedTimeout == 0 {
+		n.IPAM.VerifyUnusedTimeout = types.DefaultVerifyUnusedTimeout
+	}
+
+	if err := validateRetryPolicy(n.IPAM); err != nil {
+		return nil, "", err
 	}
 
-	if n.IPAM.LeaderRenewDeadline == 0 {
-		n.IPAM.LeaderRenewDeadline = types.DefaultLeaderRenewDeadline
+	requestedIPs, err := parseRequestedIPs(n.Args)
+	if err != nil {
+		return nil, "", err
 	}
+	n.IPAM.RequestedIPs = requestedIPs
 
-	if n.IPAM.LeaderRetryPeriod == 0 {
-		n.IPAM.LeaderRetryPeriod = types.DefaultLeaderRetryPeriod
+	if err := validateLeaderElection(n.IPAM); err != nil {
+		return nil, "", err
 	}
 
 	// Copy net name into IPAM so not to drag Net struct around
@@ -165,6 +239,383 @@ func LoadIPAMConfig(bytes []byte, envArgs string, extraConfigPaths ...string) (*
 	return n.IPAM, n.CNIVersion, nil
 }
 
+// applyGlobalExcludes adds the top level exclusions to those of each range of their IP family, so that a dual-stack
+// configuration can list them once for both its ranges
+func applyGlobalExcludes(ipamConf *types.IPAMConfig) error {
+	for _, omitRange := range ipamConf.OmitRanges {
+		excluded, err := iphelpers.ParseExcludedRange(omitRange)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR in exclude list %s: %s", omitRange, err)
+		}
+		for idx := range ipamConf.IPRanges {
+			ipNet, err := ipamConf.IPRanges[idx].Network()
+			if err != nil {
+				return fmt.Errorf("invalid CIDR %s: %s", ipamConf.IPRanges[idx].Range, err)
+			}
+			if iphelpers.IsIPv4(excluded.IP) == iphelpers.IsIPv4(ipNet.IP) {
+				ipamConf.IPRanges[idx].OmitRanges = append(ipamConf.IPRanges[idx].OmitRanges, omitRange)
+			}
+		}
+	}
+	return nil
+}
+
+// validateRetryPolicy makes sure the backoff between the retries of the IP pool updates and the allocation deadline
+// are consistent, defaulting the backoff
+func validateRetryPolicy(ipamConf *types.IPAMConfig) error {
+	if ipamConf.BackoffBaseMs < 0 || ipamConf.BackoffMaxMs < 0 || ipamConf.AllocationTimeout < 0 {
+		return fmt.Errorf("backoff_base_ms, backoff_max_ms and allocation_timeout cannot be negative")
+	}
+	if ipamConf.BackoffBaseMs == 0 {
+		ipamConf.BackoffBaseMs = types.DefaultBackoffBaseMs
+	}
+	if ipamConf.BackoffMaxMs == 0 {
+		ipamConf.BackoffMaxMs = max(types.DefaultBackoffMaxMs, ipamConf.BackoffBaseMs)
+	}
+	if ipamConf.BackoffMaxMs < ipamConf.BackoffBaseMs {
+		return fmt.Errorf("backoff_max_ms %d cannot be lower than backoff_base_ms %d", ipamConf.BackoffMaxMs, ipamConf.BackoffBaseMs)
+	}
+	return nil
+}
+
+// validateLeaderElection makes sure the leader election timings are consistent - the lease outlasting the renew
+// deadline, itself outlasting the jittered retry period - which client-go only checks once electing, failing every
+// allocation. The unset timings are derived from the set ones, in the proportions of the defaults.
+func validateLeaderElection(ipamConf *types.IPAMConfig) error {
+	if ipamConf.LeaderLeaseDuration < 0 || ipamConf.LeaderRenewDeadline < 0 || ipamConf.LeaderRetryPeriod < 0 {
+		return fmt.Errorf("leader_lease_duration, leader_renew_deadline and leader_retry_period cannot be negative")
+	}
+	if ipamConf.LeaderFallbackConflicts < 0 {
+		return fmt.Errorf("leader_fallback_conflicts cannot be negative")
+	}
+	if ipamConf.LeaderFallbackConflicts == 0 {
+		ipamConf.LeaderFallbackConflicts = types.DefaultLeaderFallbackConflicts
+	}
+
+	if ipamConf.LeaderRenewDeadline == 0 {
+		switch {
+		case ipamConf.LeaderLeaseDuration != 0:
+			ipamConf.LeaderRenewDeadline = ipamConf.LeaderLeaseDuration * types.DefaultLeaderRenewDeadline / types.DefaultLeaderLeaseDuration
+		case ipamConf.LeaderRetryPeriod != 0:
+			ipamConf.LeaderRenewDeadline = ipamConf.LeaderRetryPeriod * types.DefaultLeaderRenewDeadline / types.DefaultLeaderRetryPeriod
+		default:
+			ipamConf.LeaderRenewDeadline = types.DefaultLeaderRenewDeadline
+		}
+	}
+	if ipamConf.LeaderLeaseDuration == 0 {
+		ipamConf.LeaderLeaseDuration = ipamConf.LeaderRenewDeadline * types.DefaultLeaderLeaseDuration / types.DefaultLeaderRenewDeadline
+	}
+	if ipamConf.LeaderRetryPeriod == 0 {
+		ipamConf.LeaderRetryPeriod = max(ipamConf.LeaderRenewDeadline*types.DefaultLeaderRetryPeriod/types.DefaultLeaderRenewDeadline, 1)
+	}
+
+	if ipamConf.LeaderRenewDeadline >= ipamConf.LeaderLeaseDuration {
+		return fmt.Errorf("leader_renew_deadline %d must be lower than leader_lease_duration %d",
+			ipamConf.LeaderRenewDeadline, ipamConf.LeaderLeaseDuration)
+	}
+	if float64(ipamConf.LeaderRenewDeadline) <= leaderelection.JitterFactor*float64(ipamConf.LeaderRetryPeriod) {
+		return fmt.Errorf("leader_retry_period %d must be lower than leader_renew_deadline %d by a factor of more than %.1f",
+			ipamConf.LeaderRetryPeriod, ipamConf.LeaderRenewDeadline, leaderelection.JitterFactor)
+	}
+	return nil
+}
+
+// parseRequestedIPs parses the IPs requested through the args of the network config, e.g. by the cni-args of the
+// network selection elements of Multus
+func parseRequestedIPs(args *types.NetArgs) ([]net.IP, error) {
+	if args == nil || args.CNI == nil {
+		return nil, nil
+	}
+
+	var requestedIPs []net.IP
+	for _, item := range args.CNI.IPs {
+		ipstr := strings.TrimSpace(item)
+		ip := netutils.ParseIPSloppy(ipstr)
+		if ip == nil {
+			var err error
+			if ip, _, err = netutils.ParseCIDRSloppy(ipstr); err != nil {
+				return nil, fmt.Errorf("invalid requested IP %s: %s", ipstr, err)
+			}
+		}
+		requestedIPs = append(requestedIPs, ip)
+	}
+	return requestedIPs, nil
+}
+
+// validateAddressFamilyPolicy makes sure the families of the ranges match the address family policy, catching
+// e.g. a single stack network deployed on a dual stack cluster
+func validateAddressFamilyPolicy(ipamConf *types.IPAMConfig) error {
+	if ipamConf.AddressFamilyPolicy == "" {
+		return nil
+	}
+
+	var v4Ranges, v6Ranges []string
+	for _, ipRange := range ipamConf.IPRanges {
+		ipNet, err := ipRange.Network()
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %s: %s", ipRange.Range, err)
+		}
+		if ipNet.IP.To4() != nil {
+			v4Ranges = append(v4Ranges, ipRange.Range)
+		} else {
+			v6Ranges = append(v6Ranges, ipRange.Range)
+		}
+	}
+
+	switch ipamConf.AddressFamilyPolicy {
+	case types.AddressFamilyPolicyRequireDualStack:
+		if len(v4Ranges) == 0 || len(v6Ranges) == 0 {
+			return fmt.Errorf("address family policy %q requires both IPv4 and IPv6 ranges, got IPv4 ranges %v and IPv6 ranges %v",
+				ipamConf.AddressFamilyPolicy, v4Ranges, v6Ranges)
+		}
+	case types.AddressFamilyPolicyV4Only:
+		if len(v6Ranges) > 0 {
+			return fmt.Errorf("address family policy %q forbids the IPv6 ranges %v", ipamConf.AddressFamilyPolicy, v6Ranges)
+		}
+	case types.AddressFamilyPolicyV6Only:
+		if len(v4Ranges) > 0 {
+			return fmt.Errorf("address family policy %q forbids the IPv4 ranges %v", ipamConf.AddressFamilyPolicy, v4Ranges)
+		}
+	default:
+		return fmt.Errorf("invalid address family policy %q, expected %q, %q or %q", ipamConf.AddressFamilyPolicy,
+			types.AddressFamilyPolicyRequireDualStack, types.AddressFamilyPolicyV4Only, types.AddressFamilyPolicyV6Only)
+	}
+	return nil
+}
+
+// validatePoolNamespace makes sure the pool namespace is a valid namespace name, and that the network has no node
+// slices: the node slice controller manages their NodeSlicePools in its own namespace
+func validatePoolNamespace(ipamConf *types.IPAMConfig) error {
+	if ipamConf.PoolNamespace == "" {
+		return nil
+	}
+	if errs := validation.IsDNS1123Label(ipamConf.PoolNamespace); len(errs) > 0 {
+		return fmt.Errorf("invalid pool namespace %q: %s", ipamConf.PoolNamespace, strings.Join(errs, ", "))
+	}
+	for _, ipRange := range ipamConf.IPRanges {
+		if ipamConf.RangeNodeSliceSize(ipRange) != "" {
+			return fmt.Errorf("pool namespace %q is not supported along with node slices", ipamConf.PoolNamespace)
+		}
+	}
+	return nil
+}
+
+// validatePoolShards makes sure each range can be split in the requested number of IP pools
+func validatePoolShards(ipamConf *types.IPAMConfig) error {
+	if ipamConf.PoolShards == 0 || ipamConf.PoolShards == 1 {
+		return nil
+	}
+	if ipamConf.NodeSliceSize != "" {
+		return fmt.Errorf("pool_shards cannot be combined with node_slice_size: the node slices already split the range")
+	}
+
+	for _, ipRange := range ipamConf.IPRanges {
+		ipNet, err := ipRange.Network()
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %s: %s", ipRange.Range, err)
+		}
+		if _, err := iphelpers.SplitSubnet(*ipNet, ipamConf.PoolShards); err != nil {
+			return fmt.Errorf("invalid pool_shards: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateRangeSets makes sure the range sets are not split in further IP pools: their CIDRs are allocated from as a
+// single pool
+func validateRangeSets(ipamConf *types.IPAMConfig) error {
+	for _, ipRange := range ipamConf.IPRanges {
+		if len(ipRange.RangeSet) == 0 {
+			continue
+		}
+		if ipamConf.PoolShards > 1 {
+			return fmt.Errorf("range set %v cannot be combined with pool_shards", ipRange.RangeSet)
+		}
+		if ipamConf.RangeNodeSliceSize(ipRange) != "" {
+			return fmt.Errorf("range set %v cannot be combined with node_slice_size", ipRange.RangeSet)
+		}
+	}
+	return nil
+}
+
+// validateAllocationStrategies makes sure the allocation strategy of each range is known, setting that of the
+// configuration on the ranges without their own
+func validateAllocationStrategies(ipamConf *types.IPAMConfig) error {
+	for idx := range ipamConf.IPRanges {
+		strategy := ipamConf.RangeAllocationStrategy(ipamConf.IPRanges[idx])
+		switch strategy {
+		case "", types.SequentialAllocation, types.RandomAllocation, types.LRUAllocation:
+		default:
+			return fmt.Errorf("invalid allocation_strategy %q of range %s, expected %q, %q or %q", strategy,
+				ipamConf.IPRanges[idx].Range, types.SequentialAllocation, types.RandomAllocation, types.LRUAllocation)
+		}
+		ipamConf.IPRanges[idx].AllocationStrategy = strategy
+	}
+	return nil
+}
+
+// validateIPv6AddressMode makes sure the IPv6 address mode is known. In random-iid mode, the IPv6 ranges - /64
+// networks, split neither in node slices nor in pool shards - are set to assign IPs of random interface identifiers.
+func validateIPv6AddressMode(ipamConf *types.IPAMConfig) error {
+	switch ipamConf.IPv6AddressMode {
+	case "":
+		return nil
+	case types.IPv6AddressModeRandomIID:
+	default:
+		return fmt.Errorf("invalid ipv6_address_mode %q, expected %q", ipamConf.IPv6AddressMode, types.IPv6AddressModeRandomIID)
+	}
+
+	for idx := range ipamConf.IPRanges {
+		ipRange := &ipamConf.IPRanges[idx]
+		ipNet, err := ipRange.Network()
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %s: %s", ipRange.Range, err)
+		}
+		if iphelpers.IsIPv4(ipNet.IP) {
+			continue
+		}
+		for _, subrange := range ipRange.Subranges() {
+			subnet, err := subrange.Network()
+			if err != nil {
+				return fmt.Errorf("invalid CIDR %s: %s", subrange.Range, err)
+			}
+			if ones, _ := subnet.Mask.Size(); ones != 64 {
+				return fmt.Errorf("ipv6_address_mode %q needs /64 IPv6 ranges, %s is a /%d", ipamConf.IPv6AddressMode, subnet, ones)
+			}
+		}
+		if ipamConf.RangeNodeSliceSize(*ipRange) != "" || ipamConf.PoolShards > 1 {
+			return fmt.Errorf("ipv6_address_mode %q cannot be combined with node slices or pool shards, which split range %s",
+				ipamConf.IPv6AddressMode, ipRange.Range)
+		}
+		if ipRange.AllocationStrategy == types.LRUAllocation {
+			return fmt.Errorf("ipv6_address_mode %q cannot be combined with the %q allocation strategy of range %s",
+				ipamConf.IPv6AddressMode, types.LRUAllocation, ipRange.Range)
+		}
+		ipRange.AllocationStrategy = types.RandomIIDAllocation
+	}
+	return nil
+}
+
+// validateGatewayStrategy makes sure the gateway strategy is known. The per-node-slice-first-ip strategy needs node
+// slices holding, besides their gateway, at least one IP to assign.
+func validateGatewayStrategy(ipamConf *types.IPAMConfig) error {
+	switch ipamConf.GatewayStrategy {
+	case "":
+		return nil
+	case types.GatewayStrategyPerNodeSliceFirstIP:
+	default:
+		return fmt.Errorf("invalid gateway_strategy %q, expected %q", ipamConf.GatewayStrategy, types.GatewayStrategyPerNodeSliceFirstIP)
+	}
+
+	var sliced bool
+	for _, ipRange := range ipamConf.IPRanges {
+		sliceSize := ipamConf.RangeNodeSliceSize(ipRange)
+		if sliceSize == "" {
+			continue
+		}
+		sliced = true
+		ipNet, err := ipRange.Network()
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %s: %s", ipRange.Range, err)
+		}
+		ones, err := strconv.Atoi(strings.TrimPrefix(sliceSize, "/"))
+		if err != nil {
+			return fmt.Errorf("invalid node_slice_size %q of range %s: %s", sliceSize, ipRange.Range, err)
+		}
+		if _, bits := ipNet.Mask.Size(); ones > bits-2 {
+			return fmt.Errorf("gateway_strategy %q needs node slices of at least two usable IPs, those of range %s are /%d",
+				ipamConf.GatewayStrategy, ipRange.Range, ones)
+		}
+	}
+	if !sliced {
+		return fmt.Errorf("gateway_strategy %q needs node_slice_size", ipamConf.GatewayStrategy)
+	}
+	return nil
+}
+
+// validateReservationKey makes sure the reservation key is known, normalizing the MAC CNI arg the mac reservation key
+// keys the allocations by. The arg is only required by the allocations.
+func validateReservationKey(ipamConf *types.IPAMConfig) error {
+	switch ipamConf.ReservationKey {
+	case "", types.ReservationKeyPod:
+		return nil
+	case types.ReservationKeyMAC:
+	default:
+		return fmt.Errorf("invalid reservation_key %q, expected %q or %q", ipamConf.ReservationKey, types.ReservationKeyPod, types.ReservationKeyMAC)
+	}
+
+	if ipamConf.MAC == "" {
+		return nil
+	}
+	mac, err := net.ParseMAC(ipamConf.MAC)
+	if err != nil {
+		return fmt.Errorf("invalid MAC CNI arg %q: %s", ipamConf.MAC, err)
+	}
+	ipamConf.MAC = mac.String()
+	return nil
+}
+
+// validateTracingEndpoint makes sure the tracing endpoint is the HTTP URL of an OTLP collector
+func validateTracingEndpoint(ipamConf *types.IPAMConfig) error {
+	if ipamConf.TracingEndpoint == "" {
+		return nil
+	}
+	endpoint, err := url.Parse(ipamConf.TracingEndpoint)
+	if err != nil {
+		return fmt.Errorf("invalid tracing_endpoint %q: %s", ipamConf.TracingEndpoint, err)
+	}
+	if (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return fmt.Errorf("invalid tracing_endpoint %q, expected the http or https URL of an OTLP collector", ipamConf.TracingEndpoint)
+	}
+	return nil
+}
+
+// validateNodeUnreadyGracePeriod makes sure the grace period of the NotReady nodes is a positive duration, of a network
+// with node slices
+func validateNodeUnreadyGracePeriod(ipamConf *types.IPAMConfig) error {
+	if ipamConf.NodeUnreadyGracePeriod == "" {
+		return nil
+	}
+	gracePeriod, err := time.ParseDuration(ipamConf.NodeUnreadyGracePeriod)
+	if err != nil || gracePeriod <= 0 {
+		return fmt.Errorf("invalid node_unready_grace_period %q, expected a positive duration, e.g. 10m", ipamConf.NodeUnreadyGracePeriod)
+	}
+	if ipamConf.NodeSliceSize == "" {
+		return fmt.Errorf("node_unready_grace_period needs node_slice_size")
+	}
+	return nil
+}
+
+// validateForeignRanges makes sure neither the ranges nor the static addresses of the configuration overlap the ranges
+// managed by another IPAM, e.g. the cluster pod CIDR
+func validateForeignRanges(ipamConf *types.IPAMConfig) error {
+	for idx, foreignRange := range ipamConf.ForeignRanges {
+		_, foreignNet, err := netutils.ParseCIDRSloppy(foreignRange)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR in foreign ranges %s: %s", foreignRange, err)
+		}
+		ipamConf.ForeignRanges[idx] = foreignNet.String()
+
+		for _, ipRange := range ipamConf.IPRanges {
+			for _, subrange := range ipRange.Subranges() {
+				ipNet, err := subrange.Network()
+				if err != nil {
+					return fmt.Errorf("invalid CIDR %s: %s", subrange.Range, err)
+				}
+				if ipNet.Contains(foreignNet.IP) || foreignNet.Contains(ipNet.IP) {
+					return fmt.Errorf("range %s overlaps the foreign range %s, which whereabouts must never allocate from", subrange.Range, foreignRange)
+				}
+			}
+		}
+		for _, address := range ipamConf.Addresses {
+			if foreignNet.Contains(address.Address.IP) {
+				return fmt.Errorf("address %s belongs to the foreign range %s, which whereabouts must never allocate from", address.AddressStr, foreignRange)
+			}
+		}
+	}
+	return nil
+}
+
 func pathExists(path string) bool {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -176,6 +627,68 @@ func pathExists(path string) bool {
 	return true
 }
 
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// readFlatConfigDir merges the flat configuration files of the directory - those ending in .conf or .json - in the
+// lexical order of their names, like sysctl.d: the settings of a file override those of the files before it, objects
+// such as kubernetes being merged setting by setting. Hidden entries are skipped, e.g. the ..data directory of a
+// mounted ConfigMap, whose keys are links into it. The directory is not found when it holds no such file.
+func readFlatConfigDir(dir string) ([]byte, bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading flat configuration directory @ %s with: %s", dir, err)
+	}
+
+	merged := map[string]interface{}{}
+	found := false
+	// the entries are sorted by file name
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || (filepath.Ext(name) != ".conf" && filepath.Ext(name) != ".json") {
+			continue
+		}
+		confpath := filepath.Join(dir, name)
+		if isDir(confpath) {
+			continue
+		}
+		jsonBytes, err := os.ReadFile(confpath)
+		if err != nil {
+			return nil, false, fmt.Errorf("LoadIPAMConfig Flatfile (%s) - io.ReadAll error: %s", confpath, err)
+		}
+		var settings map[string]interface{}
+		if err := UnmarshalNetConf(jsonBytes, &settings); err != nil {
+			return nil, false, fmt.Errorf("LoadIPAMConfig Flatfile (%s) - JSON Parsing Error: %w", confpath, err)
+		}
+		mergeSettings(merged, settings)
+		found = true
+	}
+	if !found {
+		return nil, false, nil
+	}
+
+	jsonBytes, err := json.Marshal(merged)
+	if err != nil {
+		return nil, false, err
+	}
+	return jsonBytes, true, nil
+}
+
+// mergeSettings overrides the settings of base with those of override, merging the objects both set
+func mergeSettings(base, override map[string]interface{}) {
+	for key, value := range override {
+		baseObject, baseIsObject := base[key].(map[string]interface{})
+		overrideObject, overrideIsObject := value.(map[string]interface{})
+		if baseIsObject && overrideIsObject {
+			mergeSettings(baseObject, overrideObject)
+			continue
+		}
+		base[key] = value
+	}
+}
+
 func configureStatic(n *types.Net, args types.IPAMEnvArgs) error {
 
 	// Validate all ranges
@@ -225,7 +738,7 @@ func configureStatic(n *types.Net, args types.IPAMEnvArgs) error {
 
 func GetFlatIPAM(isControlLoop bool, IPAM *types.IPAMConfig, extraConfigPaths ...string) (types.Net, string, error) {
 	// Once we have our basics, let's look for our (optional) configuration file
-	confdirs := []string{"/etc/kubernetes/cni/net.d/whereabouts.d/whereabouts.conf", "/etc/cni/net.d/whereabouts.d/whereabouts.conf", "/host/etc/cni/net.d/whereabouts.d/whereabouts.conf"}
+	confdirs := platform.FlatConfigPaths()
 	confdirs = append(confdirs, extraConfigPaths...)
 	// We prefix the optional configuration path (so we look there first)
 
@@ -240,6 +753,21 @@ func GetFlatIPAM(isControlLoop bool, IPAM *types.IPAMConfig, extraConfigPaths ..
 	foundflatfile := ""
 	for _, confpath := range confdirs {
 		if pathExists(confpath) {
+			if isDir(confpath) {
+				jsonBytes, found, err := readFlatConfigDir(confpath)
+				if err != nil {
+					return flatipam, foundflatfile, err
+				}
+				if !found {
+					continue
+				}
+				if err := UnmarshalNetConf(jsonBytes, &flatipam.IPAM); err != nil {
+					return flatipam, foundflatfile, fmt.Errorf("LoadIPAMConfig Flatfile (%s) - JSON Parsing Error: %w", confpath, err)
+				}
+				foundflatfile = confpath
+				return flatipam, foundflatfile, nil
+			}
+
 			jsonFile, err := os.Open(confpath)
 			if err != nil {
 				return flatipam, foundflatfile, fmt.Errorf("error opening flat configuration file @ %s with: %s", confpath, err)
@@ -252,8 +780,8 @@ func GetFlatIPAM(isControlLoop bool, IPAM *types.IPAMConfig, extraConfigPaths ..
 				return flatipam, foundflatfile, fmt.Errorf("LoadIPAMConfig Flatfile (%s) - io.ReadAll error: %s", confpath, err)
 			}
 
-			if err := json.Unmarshal(jsonBytes, &flatipam.IPAM); err != nil {
-				return flatipam, foundflatfile, fmt.Errorf("LoadIPAMConfig Flatfile (%s) - JSON Parsing Error: %s / bytes: %s", confpath, err, jsonBytes)
+			if err := UnmarshalNetConf(jsonBytes, &flatipam.IPAM); err != nil {
+				return flatipam, foundflatfile, fmt.Errorf("LoadIPAMConfig Flatfile (%s) - JSON Parsing Error: %w", confpath, err)
 			}
 
 			foundflatfile = confpath
@@ -339,7 +867,7 @@ func LoadIPAMConfiguration(bytes []byte, envArgs string, extraConfigPaths ...str
 
 func loadPluginConfigList(bytes []byte) (*types.NetConfList, error) {
 	var netConfList types.NetConfList
-	if err := json.Unmarshal(bytes, &netConfList); err != nil {
+	if err := UnmarshalNetConf(bytes, &netConfList); err != nil {
 		return nil, err
 	}
 
@@ -348,7 +876,7 @@ func loadPluginConfigList(bytes []byte) (*types.NetConfList, error) {
 
 func loadPluginConfig(bytes []byte) (*cnitypes.NetConf, error) {
 	var pluginConfig cnitypes.NetConf
-	if err := json.Unmarshal(bytes, &pluginConfig); err != nil {
+	if err := UnmarshalNetConf(bytes, &pluginConfig); err != nil {
 		return nil, err
 	}
 	return &pluginConfig, nil
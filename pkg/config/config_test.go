@@ -5,11 +5,13 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
 )
 
@@ -267,7 +269,8 @@ var _ = Describe("Allocation operations", func() {
 		ipamConfig, _, err := LoadIPAMConfig([]byte(conf), "", confPath)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(ipamConfig.IPRanges[0].Range).To(Equal("192.168.1.0/24"))
-		Expect(ipamConfig.IPRanges[0].RangeStart).To(Equal(net.ParseIP("192.168.1.0")))
+		Expect(ipamConfig.IPRanges[0].RangeStart).To(Equal(net.ParseIP("192.168.1.1")))
+		Expect(ipamConfig.IPRanges[0].RangeEnd).To(Equal(net.ParseIP("192.168.1.254")))
 	})
 
 	It("allows for leading zeroes in the range when the start range is provided", func() {
@@ -382,6 +385,751 @@ var _ = Describe("Allocation operations", func() {
 		Expect(err).To(MatchError("invalid range start for CIDR 192.168.2.16/28: 192.168.1.5"))
 	})
 
+	It("errors when the range start or end lie outside of the range", func() {
+		loadConfig := func(ipRange string) error {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ipvlan",
+				"master": "foo0",
+				"ipam": {
+					"type": "whereabouts",
+					"kubernetes": {
+						"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"
+					},
+					%s
+				}
+			}`, ipRange)
+
+			confPath := filepath.Join(tmpDir, "whereabouts.conf")
+			Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+
+			_, _, err := LoadIPAMConfig([]byte(conf), "", confPath)
+			return err
+		}
+
+		Expect(loadConfig(`"range": "192.168.1.0/24", "range_end": "192.168.2.25"`)).To(MatchError(
+			"invalid range end for CIDR 192.168.1.0/24: 192.168.2.25"))
+		Expect(loadConfig(`"range": "192.168.1.0/24", "range_start": "192.168.1.200", "range_end": "192.168.1.100"`)).To(MatchError(
+			"invalid range 192.168.1.0/24: range start 192.168.1.200 is after range end 192.168.1.100"))
+	})
+
+	It("errors when an invalid result order is specified", func() {
+		invalidConf := `{
+			"cniVersion": "0.3.1",
+            "name": "mynet",
+			"type": "ipvlan",
+			"master": "foo0",
+			"ipam": {
+				"type": "whereabouts",
+				"kubernetes": {
+					"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"
+				},
+				"range": "192.168.1.0/24",
+				"result_order": "v6-preferred"
+			}
+		}`
+
+		confPath := filepath.Join(tmpDir, "whereabouts.conf")
+		Expect(os.WriteFile(confPath, []byte(invalidConf), 0755)).To(Succeed())
+
+		_, _, err := LoadIPAMConfig([]byte(invalidConf), "", confPath)
+		Expect(err).To(MatchError(`invalid result order "v6-preferred", expected "v4-first" or "v6-first"`))
+	})
+
+	It("errors when an invalid node unready grace period is specified", func() {
+		invalidConf := `{
+			"cniVersion": "0.3.1",
+			"name": "mynet",
+			"type": "ipvlan",
+			"master": "foo0",
+			"ipam": {
+				"type": "whereabouts",
+				"kubernetes": {
+					"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"
+				},
+				"range": "192.168.0.0/16",
+				"node_slice_size": "/24",
+				"node_unready_grace_period": "10"
+			}
+		}`
+
+		confPath := filepath.Join(tmpDir, "whereabouts.conf")
+		Expect(os.WriteFile(confPath, []byte(invalidConf), 0755)).To(Succeed())
+
+		_, _, err := LoadIPAMConfig([]byte(invalidConf), "", confPath)
+		Expect(err).To(MatchError(HavePrefix(`invalid node_unready_grace_period "10"`)))
+
+		validConf := strings.Replace(invalidConf, `"10"`, `"10m"`, 1)
+		ipamConf, _, err := LoadIPAMConfig([]byte(validConf), "", confPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipamConf.NodeUnreadyGracePeriod).To(Equal("10m"))
+
+		_, _, err = LoadIPAMConfig([]byte(strings.Replace(validConf, `"node_slice_size": "/24",`, "", 1)), "", confPath)
+		Expect(err).To(MatchError("node_unready_grace_period needs node_slice_size"))
+	})
+
+	It("errors when both the allocation API and the daemon socket are set", func() {
+		conf := `{
+			"cniVersion": "0.3.1",
+			"name": "mynet",
+			"type": "ipvlan",
+			"master": "foo0",
+			"ipam": {
+				"type": "whereabouts",
+				"kubernetes": {
+					"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"
+				},
+				"range": "192.168.0.0/16",
+				"allocation_api": true
+			}
+		}`
+
+		confPath := filepath.Join(tmpDir, "whereabouts.conf")
+		Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+
+		ipamConf, _, err := LoadIPAMConfig([]byte(conf), "", confPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipamConf.AllocationAPI).To(BeTrue())
+
+		invalidConf := strings.Replace(conf, `"allocation_api": true`, `"allocation_api": true, "daemon_socket": "/run/whereabouts/whereabouts.sock"`, 1)
+		_, _, err = LoadIPAMConfig([]byte(invalidConf), "", confPath)
+		Expect(err).To(MatchError(HavePrefix("allocation_api and daemon_socket cannot both be set")))
+	})
+
+	It("errors when an invalid pool namespace is specified", func() {
+		invalidConf := `{
+			"cniVersion": "0.3.1",
+			"name": "mynet",
+			"type": "ipvlan",
+			"master": "foo0",
+			"ipam": {
+				"type": "whereabouts",
+				"kubernetes": {
+					"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"
+				},
+				"range": "192.168.1.0/24",
+				"pool_namespace": "Tenant_A"
+			}
+		}`
+
+		confPath := filepath.Join(tmpDir, "whereabouts.conf")
+		Expect(os.WriteFile(confPath, []byte(invalidConf), 0755)).To(Succeed())
+
+		_, _, err := LoadIPAMConfig([]byte(invalidConf), "", confPath)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix(`invalid pool namespace "Tenant_A"`))
+	})
+
+	Context("with an address family policy", func() {
+		loadConfig := func(policy string, ranges ...string) error {
+			var ipRanges []string
+			for _, ipRange := range ranges {
+				ipRanges = append(ipRanges, fmt.Sprintf(`{"range": %q}`, ipRange))
+			}
+			conf := fmt.Sprintf(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ipvlan",
+				"master": "foo0",
+				"ipam": {
+					"type": "whereabouts",
+					"kubernetes": {
+						"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"
+					},
+					"ipRanges": [%s],
+					"address_family_policy": %q
+				}
+			}`, strings.Join(ipRanges, ","), policy)
+
+			confPath := filepath.Join(tmpDir, "whereabouts.conf")
+			Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+
+			_, _, err := LoadIPAMConfig([]byte(conf), "", confPath)
+			return err
+		}
+
+		It("accepts ranges of the families the policy expects", func() {
+			Expect(loadConfig(types.AddressFamilyPolicyRequireDualStack, "192.168.1.0/24", "abcd::/64")).To(Succeed())
+			Expect(loadConfig(types.AddressFamilyPolicyV4Only, "192.168.1.0/24")).To(Succeed())
+			Expect(loadConfig(types.AddressFamilyPolicyV6Only, "abcd::/64")).To(Succeed())
+		})
+
+		It("rejects a single stack configuration when dual stack is required", func() {
+			Expect(loadConfig(types.AddressFamilyPolicyRequireDualStack, "192.168.1.0/24")).To(MatchError(
+				`address family policy "require_dual_stack" requires both IPv4 and IPv6 ranges, got IPv4 ranges [192.168.1.0/24] and IPv6 ranges []`))
+		})
+
+		It("rejects the ranges of the other family", func() {
+			Expect(loadConfig(types.AddressFamilyPolicyV4Only, "192.168.1.0/24", "abcd::/64")).To(MatchError(
+				`address family policy "v4_only" forbids the IPv6 ranges [abcd::/64]`))
+			Expect(loadConfig(types.AddressFamilyPolicyV6Only, "192.168.1.0/24", "abcd::/64")).To(MatchError(
+				`address family policy "v6_only" forbids the IPv4 ranges [192.168.1.0/24]`))
+		})
+
+		It("rejects unknown policies", func() {
+			Expect(loadConfig("v4_preferred", "192.168.1.0/24")).To(MatchError(
+				`invalid address family policy "v4_preferred", expected "require_dual_stack", "v4_only" or "v6_only"`))
+		})
+	})
+
+	Context("with pool shards", func() {
+		loadConfig := func(shards int, extra string) error {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ipvlan",
+				"master": "foo0",
+				"ipam": {
+					"type": "whereabouts",
+					"kubernetes": {
+						"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"
+					},
+					"range": "192.168.0.0/16",
+					%s
+					"pool_shards": %d
+				}
+			}`, extra, shards)
+
+			confPath := filepath.Join(tmpDir, "whereabouts.conf")
+			Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+
+			_, _, err := LoadIPAMConfig([]byte(conf), "", confPath)
+			return err
+		}
+
+		It("accepts a power of two", func() {
+			Expect(loadConfig(4, "")).To(Succeed())
+		})
+
+		It("rejects a number of shards which cannot split the range", func() {
+			Expect(loadConfig(3, "")).To(MatchError(
+				"invalid pool_shards: cannot split subnet 192.168.0.0/16 in 3 parts: not a power of two"))
+			Expect(loadConfig(1<<17, "")).To(MatchError(
+				"invalid pool_shards: cannot split subnet 192.168.0.0/16 in 131072 parts: it is too small"))
+		})
+
+		It("rejects shards on node slice networks", func() {
+			Expect(loadConfig(4, `"node_slice_size": "/24",`)).To(MatchError(
+				"pool_shards cannot be combined with node_slice_size: the node slices already split the range"))
+		})
+	})
+
+	Context("with a retry policy", func() {
+		loadConfig := func(retryPolicy string) (*types.IPAMConfig, error) {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ipvlan",
+				"master": "foo0",
+				"ipam": {
+					"type": "whereabouts",
+					"kubernetes": {
+						"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"
+					},
+					%s
+					"range": "192.168.0.0/16"
+				}
+			}`, retryPolicy)
+
+			confPath := filepath.Join(tmpDir, "whereabouts.conf")
+			Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+
+			ipamConfig, _, err := LoadIPAMConfig([]byte(conf), "", confPath)
+			return ipamConfig, err
+		}
+
+		It("defaults the backoff", func() {
+			ipamConfig, err := loadConfig("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.BackoffBaseMs).To(Equal(types.DefaultBackoffBaseMs))
+			Expect(ipamConfig.BackoffMaxMs).To(Equal(types.DefaultBackoffMaxMs))
+			Expect(ipamConfig.AllocationTimeout).To(BeZero())
+		})
+
+		It("can be set", func() {
+			ipamConfig, err := loadConfig(`"backoff_base_ms": 100, "backoff_max_ms": 5000, "allocation_timeout": 30000,`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.BackoffBaseMs).To(Equal(100))
+			Expect(ipamConfig.BackoffMaxMs).To(Equal(5000))
+			Expect(ipamConfig.AllocationTimeout).To(Equal(30000))
+		})
+
+		It("rejects a max delay lower than the base delay", func() {
+			_, err := loadConfig(`"backoff_base_ms": 100, "backoff_max_ms": 50,`)
+			Expect(err).To(MatchError("backoff_max_ms 50 cannot be lower than backoff_base_ms 100"))
+		})
+
+		It("rejects negative values", func() {
+			_, err := loadConfig(`"allocation_timeout": -1,`)
+			Expect(err).To(MatchError("backoff_base_ms, backoff_max_ms and allocation_timeout cannot be negative"))
+		})
+	})
+
+	Context("with leader election timings", func() {
+		loadConfig := func(timings string) (*types.IPAMConfig, error) {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ipvlan",
+				"master": "foo0",
+				"ipam": {
+					"type": "whereabouts",
+					"kubernetes": {
+						"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"
+					},
+					%s
+					"range": "192.168.0.0/16"
+				}
+			}`, timings)
+
+			confPath := filepath.Join(tmpDir, "whereabouts.conf")
+			Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+
+			ipamConfig, _, err := LoadIPAMConfig([]byte(conf), "", confPath)
+			return ipamConfig, err
+		}
+
+		It("defaults them", func() {
+			ipamConfig, err := loadConfig("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.LeaderLeaseDuration).To(Equal(types.DefaultLeaderLeaseDuration))
+			Expect(ipamConfig.LeaderRenewDeadline).To(Equal(types.DefaultLeaderRenewDeadline))
+			Expect(ipamConfig.LeaderRetryPeriod).To(Equal(types.DefaultLeaderRetryPeriod))
+		})
+
+		It("derives the unset ones from those set", func() {
+			ipamConfig, err := loadConfig(`"leader_lease_duration": 600,`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.LeaderLeaseDuration).To(Equal(600))
+			Expect(ipamConfig.LeaderRenewDeadline).To(Equal(400))
+			Expect(ipamConfig.LeaderRetryPeriod).To(Equal(200))
+
+			ipamConfig, err = loadConfig(`"leader_retry_period": 2000,`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.LeaderLeaseDuration).To(Equal(6000))
+			Expect(ipamConfig.LeaderRenewDeadline).To(Equal(4000))
+			Expect(ipamConfig.LeaderRetryPeriod).To(Equal(2000))
+		})
+
+		It("rejects a renew deadline outlasting the lease", func() {
+			_, err := loadConfig(`"leader_lease_duration": 1000, "leader_renew_deadline": 1000,`)
+			Expect(err).To(MatchError("leader_renew_deadline 1000 must be lower than leader_lease_duration 1000"))
+		})
+
+		It("rejects a retry period too close to the renew deadline", func() {
+			_, err := loadConfig(`"leader_renew_deadline": 1000, "leader_retry_period": 900,`)
+			Expect(err).To(MatchError("leader_retry_period 900 must be lower than leader_renew_deadline 1000 by a factor of more than 1.2"))
+		})
+
+		It("rejects negative values", func() {
+			_, err := loadConfig(`"leader_lease_duration": -1,`)
+			Expect(err).To(MatchError("leader_lease_duration, leader_renew_deadline and leader_retry_period cannot be negative"))
+		})
+
+		It("defaults the conflicts falling back to leader election", func() {
+			ipamConfig, err := loadConfig(`"no_leader_election": true,`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.NoLeaderElection).To(BeTrue())
+			Expect(ipamConfig.LeaderFallbackConflicts).To(Equal(types.DefaultLeaderFallbackConflicts))
+
+			_, err = loadConfig(`"no_leader_election": true, "leader_fallback_conflicts": -1,`)
+			Expect(err).To(MatchError("leader_fallback_conflicts cannot be negative"))
+		})
+	})
+
+	Context("with excludes", func() {
+		loadConfig := func(excludes string) (*types.IPAMConfig, error) {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ipvlan",
+				"master": "foo0",
+				"ipam": {
+					"type": "whereabouts",
+					"kubernetes": {
+						"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"
+					},
+					%s
+				}
+			}`, excludes)
+
+			confPath := filepath.Join(tmpDir, "whereabouts.conf")
+			Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+
+			ipamConfig, _, err := LoadIPAMConfig([]byte(conf), "", confPath)
+			return ipamConfig, err
+		}
+
+		It("keeps the excludes of each range", func() {
+			ipamConfig, err := loadConfig(`"ipRanges": [
+				{"range": "192.168.0.0/24", "exclude": ["192.168.0.0/28", "192.168.0.100"]},
+				{"range": "abcd::/64", "exclude": ["abcd::/120"]}
+			]`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.IPRanges).To(HaveLen(2))
+			Expect(ipamConfig.IPRanges[0].OmitRanges).To(Equal([]string{"192.168.0.0/28", "192.168.0.100"}))
+			Expect(ipamConfig.IPRanges[1].OmitRanges).To(Equal([]string{"abcd::/120"}))
+		})
+
+		It("adds the top level excludes to the ranges of their IP family", func() {
+			ipamConfig, err := loadConfig(`"range": "192.168.0.0/24",
+				"exclude": ["192.168.0.0/28", "abcd::1"],
+				"ipRanges": [
+					{"range": "10.0.0.0/24", "exclude": ["10.0.0.0/28"]},
+					{"range": "abcd::/64"}
+				]`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.OmitRanges).To(BeEmpty())
+			Expect(ipamConfig.IPRanges).To(HaveLen(3))
+			Expect(ipamConfig.IPRanges[0].OmitRanges).To(Equal([]string{"192.168.0.0/28"}))
+			Expect(ipamConfig.IPRanges[1].OmitRanges).To(Equal([]string{"10.0.0.0/28", "192.168.0.0/28"}))
+			Expect(ipamConfig.IPRanges[2].OmitRanges).To(Equal([]string{"abcd::1"}))
+		})
+
+		It("rejects an invalid exclude", func() {
+			_, err := loadConfig(`"ipRanges": [{"range": "192.168.0.0/24", "exclude": ["192.168.0.0/33"]}]`)
+			Expect(err).To(MatchError(HavePrefix("invalid CIDR in exclude list 192.168.0.0/33")))
+		})
+
+		It("rejects an exclude of the other IP family", func() {
+			_, err := loadConfig(`"ipRanges": [{"range": "192.168.0.0/24", "exclude": ["abcd::/120"]}]`)
+			Expect(err).To(MatchError("invalid exclude abcd::/120 for range 192.168.0.0/24: the IP families differ"))
+		})
+
+		It("sets the allocation strategy of the configuration on the ranges without their own", func() {
+			ipamConfig, err := loadConfig(`"range": "192.168.0.0/24",
+				"allocation_strategy": "random",
+				"ipRanges": [{"range": "abcd::/64", "allocation_strategy": "lru"}]`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.IPRanges).To(HaveLen(2))
+			Expect(ipamConfig.IPRanges[0].AllocationStrategy).To(Equal(types.RandomAllocation))
+			Expect(ipamConfig.IPRanges[1].AllocationStrategy).To(Equal(types.LRUAllocation))
+		})
+
+		It("rejects an unknown allocation strategy", func() {
+			_, err := loadConfig(`"ipRanges": [{"range": "192.168.0.0/24", "allocation_strategy": "highest"}]`)
+			Expect(err).To(MatchError(`invalid allocation_strategy "highest" of range 192.168.0.0/24, expected "sequential", "random" or "lru"`))
+		})
+
+		It("assigns random interface identifiers to the IPv6 ranges in random-iid mode", func() {
+			ipamConfig, err := loadConfig(`"range": "192.168.0.0/24", "ipv6_address_mode": "random-iid",
+				"ipRanges": [{"range": "abcd::/64"}]`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.IPRanges).To(HaveLen(2))
+			Expect(ipamConfig.IPRanges[0].AllocationStrategy).To(BeEmpty())
+			Expect(ipamConfig.IPRanges[1].AllocationStrategy).To(Equal(types.RandomIIDAllocation))
+		})
+
+		It("rejects the random-iid mode along with IPv6 ranges other than /64 ones, or with the lru allocation strategy", func() {
+			_, err := loadConfig(`"ipv6_address_mode": "random-iid", "ipRanges": [{"range": "abcd::/96"}]`)
+			Expect(err).To(MatchError(`ipv6_address_mode "random-iid" needs /64 IPv6 ranges, abcd::/96 is a /96`))
+
+			_, err = loadConfig(`"ipv6_address_mode": "random-iid", "ipRanges": [{"range": "abcd::/64", "allocation_strategy": "lru"}]`)
+			Expect(err).To(MatchError(`ipv6_address_mode "random-iid" cannot be combined with the "lru" allocation strategy of range abcd::/64`))
+
+			_, err = loadConfig(`"range": "abcd::/64", "ipv6_address_mode": "eui-64"`)
+			Expect(err).To(MatchError(`invalid ipv6_address_mode "eui-64", expected "random-iid"`))
+		})
+
+		It("takes the first IP of the node slices as their gateway with the per-node-slice-first-ip gateway strategy", func() {
+			ipamConfig, err := loadConfig(`"range": "10.0.0.0/16", "node_slice_size": "/24", "gateway": "10.0.0.254",
+				"gateway_strategy": "per-node-slice-first-ip", "ipRanges": [{"range": "fd00::/48", "node_slice_size": "/64"}]`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.GatewayOf(net.ParseIP("10.0.7.42")).String()).To(Equal("10.0.7.1"))
+			Expect(ipamConfig.GatewayOf(net.ParseIP("fd00:0:0:3::42")).String()).To(Equal("fd00:0:0:3::1"))
+			Expect(ipamConfig.GatewayOf(net.ParseIP("10.1.0.5")).String()).To(Equal("10.0.0.254"))
+		})
+
+		It("rejects the per-node-slice-first-ip gateway strategy without node slices of two usable IPs at least", func() {
+			_, err := loadConfig(`"range": "10.0.0.0/16", "gateway_strategy": "per-node-slice-first-ip"`)
+			Expect(err).To(MatchError(`gateway_strategy "per-node-slice-first-ip" needs node_slice_size`))
+
+			_, err = loadConfig(`"range": "10.0.0.0/16", "node_slice_size": "/31", "gateway_strategy": "per-node-slice-first-ip"`)
+			Expect(err).To(MatchError(`gateway_strategy "per-node-slice-first-ip" needs node slices of at least two usable IPs, those of range 10.0.0.0/16 are /31`))
+
+			_, err = loadConfig(`"range": "10.0.0.0/16", "node_slice_size": "/24", "gateway_strategy": "last-ip"`)
+			Expect(err).To(MatchError(`invalid gateway_strategy "last-ip", expected "per-node-slice-first-ip"`))
+		})
+
+		It("only accepts the HTTP URL of an OTLP collector as tracing endpoint", func() {
+			ipamConfig, err := loadConfig(`"range": "192.168.0.0/24", "tracing_endpoint": "http://otel-collector.monitoring:4318"`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.TracingEndpoint).To(Equal("http://otel-collector.monitoring:4318"))
+
+			_, err = loadConfig(`"range": "192.168.0.0/24", "tracing_endpoint": "otel-collector.monitoring:4317"`)
+			Expect(err).To(MatchError(`invalid tracing_endpoint "otel-collector.monitoring:4317", expected the http or https URL of an OTLP collector`))
+		})
+
+		It("only accepts the pod and mac reservation keys", func() {
+			ipamConfig, err := loadConfig(`"range": "192.168.0.0/24", "reservation_key": "mac"`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.ReservationKey).To(Equal(types.ReservationKeyMAC))
+
+			_, err = loadConfig(`"range": "192.168.0.0/24", "reservation_key": "hostname"`)
+			Expect(err).To(MatchError(`invalid reservation_key "hostname", expected "pod" or "mac"`))
+		})
+
+		It("keys the reservations by the normalized MAC CNI arg", func() {
+			conf := `{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ipvlan",
+				"ipam": {
+					"type": "whereabouts",
+					"kubernetes": {"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"},
+					"range": "192.168.0.0/24",
+					"reservation_key": "mac"
+				}
+			}`
+			confPath := filepath.Join(tmpDir, "whereabouts.conf")
+			Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+
+			ipamConfig, _, err := LoadIPAMConfig([]byte(conf), "IgnoreUnknown=1;MAC=0A:58:C0:A8:00:05", confPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.MAC).To(Equal("0a:58:c0:a8:00:05"))
+
+			_, _, err = LoadIPAMConfig([]byte(conf), "IgnoreUnknown=1;MAC=0a:58", confPath)
+			Expect(err).To(MatchError(HavePrefix(`invalid MAC CNI arg "0a:58"`)))
+		})
+
+		It("only accepts the icmp verification of the unused IPs", func() {
+			ipamConfig, err := loadConfig(`"range": "192.168.0.0/24", "verify_unused": "icmp"`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.VerifyUnused).To(Equal(types.VerifyUnusedICMP))
+
+			_, err = loadConfig(`"range": "192.168.0.0/24", "verify_unused": "arp"`)
+			Expect(err).To(MatchError(`invalid verify_unused "arp", expected "icmp"`))
+		})
+
+		It("quarantines the externally used IPs for the given seconds", func() {
+			ipamConfig, err := loadConfig(`"range": "192.168.0.0/24", "verify_unused": "icmp", "verify_unused_quarantine": 3600`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.VerifyUnusedQuarantine).To(Equal(3600))
+
+			_, err = loadConfig(`"range": "192.168.0.0/24", "verify_unused": "icmp", "verify_unused_quarantine": -1`)
+			Expect(err).To(MatchError("verify_unused_quarantine and verify_unused_timeout cannot be negative"))
+		})
+
+		It("awaits the answer of the verified IPs for the given milliseconds", func() {
+			ipamConfig, err := loadConfig(`"range": "192.168.0.0/24", "verify_unused": "icmp"`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.VerifyUnusedTimeout).To(Equal(types.DefaultVerifyUnusedTimeout))
+
+			ipamConfig, err = loadConfig(`"range": "192.168.0.0/24", "verify_unused": "icmp", "verify_unused_timeout": 100`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.VerifyUnusedTimeout).To(Equal(100))
+
+			_, err = loadConfig(`"range": "192.168.0.0/24", "verify_unused": "icmp", "verify_unused_timeout": -1`)
+			Expect(err).To(MatchError("verify_unused_quarantine and verify_unused_timeout cannot be negative"))
+		})
+
+		It("allocates from the CIDRs of a range set as a single range", func() {
+			ipamConfig, err := loadConfig(`"range_set": ["10.1.4.0/26", "10.1.9.130/25"], "range_end": "10.1.9.200",
+				"ipRanges": [{"range_set": ["abcd::/120", "abcd::1:0/120"]}]`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.RangeSet).To(BeEmpty())
+			Expect(ipamConfig.IPRanges).To(HaveLen(2))
+			Expect(ipamConfig.IPRanges[0].Range).To(Equal("10.1.4.0/26"))
+			Expect(ipamConfig.IPRanges[0].RangeSet).To(Equal([]string{"10.1.4.0/26", "10.1.9.128/25"}))
+			Expect(ipamConfig.IPRanges[0].RangeStart.String()).To(Equal("10.1.4.1"))
+			Expect(ipamConfig.IPRanges[0].RangeEnd.String()).To(Equal("10.1.9.200"))
+			Expect(ipamConfig.IPRanges[0].Contains(net.ParseIP("10.1.9.130"))).To(BeTrue())
+			Expect(ipamConfig.IPRanges[0].Contains(net.ParseIP("10.1.5.1"))).To(BeFalse())
+			Expect(ipamConfig.IPRanges[1].Range).To(Equal("abcd::/120"))
+		})
+
+		It("rejects invalid range sets", func() {
+			_, err := loadConfig(`"range": "10.1.4.0/26", "range_set": ["10.1.9.128/25"]`)
+			Expect(err).To(MatchError("range and range_set cannot both be set: list the CIDR of the range in the range set"))
+			_, err = loadConfig(`"range_set": ["10.1.9.128/25", "10.1.4.0/26"]`)
+			Expect(err).To(MatchError("invalid range set [10.1.9.128/25 10.1.4.0/26]: 10.1.4.0/26 must follow 10.1.9.128/25 without overlapping it"))
+			_, err = loadConfig(`"range_set": ["10.1.4.0/26", "abcd::/120"]`)
+			Expect(err).To(MatchError("invalid range set [10.1.4.0/26 abcd::/120]: the IP families of 10.1.4.0/26 and abcd::/120 differ"))
+			_, err = loadConfig(`"range_set": ["10.1.4.0/26", "10.1.9.128/25"], "pool_shards": 2`)
+			Expect(err).To(MatchError("range set [10.1.4.0/26 10.1.9.128/25] cannot be combined with pool_shards"))
+		})
+	})
+
+	Context("with requested IPs", func() {
+		loadConfig := func(ips string) (*types.IPAMConfig, error) {
+			conf := fmt.Sprintf(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ipvlan",
+				"master": "foo0",
+				"args": {"cni": {"ips": [%s]}},
+				"ipam": {
+					"type": "whereabouts",
+					"kubernetes": {
+						"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"
+					},
+					"range": "192.168.0.0/16"
+				}
+			}`, ips)
+
+			confPath := filepath.Join(tmpDir, "whereabouts.conf")
+			Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+
+			ipamConfig, _, err := LoadIPAMConfig([]byte(conf), "", confPath)
+			return ipamConfig, err
+		}
+
+		It("reads the IPs requested through the args, with or without their prefix length", func() {
+			ipamConfig, err := loadConfig(`"192.168.1.53", "fd00::53/64"`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.RequestedIPs).To(Equal([]net.IP{net.ParseIP("192.168.1.53"), net.ParseIP("fd00::53")}))
+		})
+
+		It("rejects invalid IPs", func() {
+			_, err := loadConfig(`"192.168.1.300"`)
+			Expect(err).To(MatchError(ContainSubstring("invalid requested IP 192.168.1.300")))
+		})
+	})
+
+	Context("with a flat configuration directory", func() {
+		var confDir string
+
+		BeforeEach(func() {
+			confDir = filepath.Join(tmpDir, "whereabouts.d")
+			Expect(os.Mkdir(confDir, 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(confDir, "00-base.conf"), []byte(`{
+				"kubernetes": {
+					"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig",
+					"k8s_api_root": "https://10.0.0.1:443"
+				},
+				"log_level": "error",
+				"log_file": "/tmp/whereabouts.log"
+			}`), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(confDir, "10-staging.json"), []byte(`{
+				"kubernetes": {
+					"k8s_api_root": "https://10.0.0.2:443"
+				},
+				"log_level": "debug"
+			}`), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(confDir, "README.md"), []byte("not a configuration"), 0644)).To(Succeed())
+			Expect(os.Mkdir(filepath.Join(confDir, "..data"), 0755)).To(Succeed())
+		})
+
+		It("merges the files in the lexical order of their names", func() {
+			flatIPAM, foundFlatFile, err := GetFlatIPAM(true, &types.IPAMConfig{}, confDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(foundFlatFile).To(Equal(confDir))
+			Expect(flatIPAM.IPAM.LogLevel).To(Equal("debug"))
+			Expect(flatIPAM.IPAM.LogFile).To(Equal("/tmp/whereabouts.log"))
+			Expect(flatIPAM.IPAM.Kubernetes.KubeConfigPath).To(Equal("/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"))
+			Expect(flatIPAM.IPAM.Kubernetes.K8sAPIRoot).To(Equal("https://10.0.0.2:443"))
+		})
+
+		It("locates the parsing errors in their file", func() {
+			Expect(os.WriteFile(filepath.Join(confDir, "20-broken.conf"), []byte(`{"log_level": }`), 0644)).To(Succeed())
+
+			_, _, err := GetFlatIPAM(true, &types.IPAMConfig{}, confDir)
+			Expect(err).To(MatchError(ContainSubstring(filepath.Join(confDir, "20-broken.conf"))))
+		})
+
+		It("skips a directory without configuration file", func() {
+			emptyDir := filepath.Join(tmpDir, "empty.d")
+			Expect(os.Mkdir(emptyDir, 0755)).To(Succeed())
+
+			_, _, err := GetFlatIPAM(true, &types.IPAMConfig{}, emptyDir)
+			Expect(err).To(MatchError(NewConfigFileNotFoundError()))
+		})
+	})
+
+	Context("with foreign ranges set in the flat file", func() {
+		var confPath string
+
+		BeforeEach(func() {
+			flatConf := `{
+				"kubernetes": {
+					"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"
+				},
+				"foreign_ranges": ["10.244.0.0/16"]
+			}`
+			confPath = filepath.Join(tmpDir, "whereabouts.conf")
+			Expect(os.WriteFile(confPath, []byte(flatConf), 0755)).To(Succeed())
+		})
+
+		It("adds the foreign ranges of the network configuration to those of the flat file", func() {
+			conf := `{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ipvlan",
+				"master": "foo0",
+				"ipam": {
+					"type": "whereabouts",
+					"range": "192.168.1.0/24",
+					"foreign_ranges": ["10.96.0.0/12"]
+				}
+			}`
+
+			ipamConfig, _, err := LoadIPAMConfig([]byte(conf), "", confPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipamConfig.ForeignRanges).To(Equal([]string{"10.244.0.0/16", "10.96.0.0/12"}))
+		})
+
+		It("errors when a range overlaps a foreign range", func() {
+			conf := `{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ipvlan",
+				"master": "foo0",
+				"ipam": {
+					"type": "whereabouts",
+					"range": "10.244.10.0/24"
+				}
+			}`
+
+			_, _, err := LoadIPAMConfig([]byte(conf), "", confPath)
+			Expect(err).To(MatchError("range 10.244.10.0/24 overlaps the foreign range 10.244.0.0/16, which whereabouts must never allocate from"))
+		})
+
+		It("errors when a static address belongs to a foreign range", func() {
+			conf := `{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ipvlan",
+				"master": "foo0",
+				"ipam": {
+					"type": "whereabouts",
+					"range": "192.168.1.0/24",
+					"addresses": [{"address": "10.244.3.7/24"}]
+				}
+			}`
+
+			_, _, err := LoadIPAMConfig([]byte(conf), "", confPath)
+			Expect(err).To(MatchError("address 10.244.3.7/24 belongs to the foreign range 10.244.0.0/16, which whereabouts must never allocate from"))
+		})
+	})
+
+	It("does not require a kubeconfig with the etcd3 datastore", func() {
+		conf := `{
+			"cniVersion": "0.3.1",
+			"name": "mynet",
+			"type": "ipvlan",
+			"master": "foo0",
+			"ipam": {
+				"type": "whereabouts",
+				"datastore": "etcd3",
+				"etcd3": {
+					"endpoints": ["https://etcd-0:2379"],
+					"cert_file": "/etc/etcd/client.crt",
+					"key_file": "/etc/etcd/client.key"
+				},
+				"range": "192.168.1.0/24"
+			}
+		}`
+
+		confPath := filepath.Join(tmpDir, "whereabouts.conf")
+		Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+
+		ipamConf, _, err := LoadIPAMConfig([]byte(conf), "", confPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipamConf.Etcd3.Endpoints).To(Equal([]string{"https://etcd-0:2379"}))
+		Expect(ipamConf.Etcd3.CertFile).To(Equal("/etc/etcd/client.crt"))
+	})
+
 	It("errors when an invalid IPAM struct is specified", func() {
 		invalidConf := `{
 			"cniVersion": "0.3.1",
@@ -396,7 +1144,79 @@ var _ = Describe("Allocation operations", func() {
 		Expect(err).To(
 			MatchError(
 				HavePrefix(
-					"LoadIPAMConfig - JSON Parsing Error: invalid character 'a' looking for beginning of object key string")))
+					"LoadIPAMConfig - JSON Parsing Error: line 7, column 5: invalid character 'a' looking for beginning of object key string")))
+	})
+
+	It("counts the deprecated parameters of the network configurations", func() {
+		conf := `{
+			"cniVersion": "0.3.1",
+			"name": "deprecated-net",
+			"type": "ipvlan",
+			"ipam": {
+				"type": "whereabouts",
+				"range": "192.168.1.0/24",
+				"ipRanges": [{"range": "192.168.2.0/24"}],
+				"datastore": "etcd",
+				"etcd_host": "etcd.cluster.local:2379",
+				"etcd_password": "",
+				"kubernetes": {"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"}
+			}
+		}`
+		confPath := filepath.Join(tmpDir, "whereabouts.conf")
+		Expect(os.WriteFile(confPath, []byte(conf), 0755)).To(Succeed())
+
+		for i := 0; i < 2; i++ {
+			_, _, err := LoadIPAMConfig([]byte(conf), "", confPath)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(metrics.DeprecatedConfig.Value("range", "deprecated-net")).To(BeEquivalentTo(2))
+		Expect(metrics.DeprecatedConfig.Value("datastore", "deprecated-net")).To(BeEquivalentTo(2))
+		Expect(metrics.DeprecatedConfig.Value("etcd_host", "deprecated-net")).To(BeEquivalentTo(2))
+		Expect(metrics.DeprecatedConfig.Value("etcd_password", "deprecated-net")).To(BeZero())
+
+		_, _, err := LoadIPAMConfig([]byte(strings.Replace(conf, `"ipRanges": [{"range": "192.168.2.0/24"}],`, "", 1)), "", confPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(metrics.DeprecatedConfig.Value("range", "deprecated-net")).To(BeEquivalentTo(2))
+	})
+
+	Context("parsing network configurations with comments and trailing commas", func() {
+		conf := `{
+			"cniVersion": "0.3.1",
+			"name": "mynet", // the network
+			"type": "ipvlan",
+			/* "master": "foo0", */
+			"ipam": {
+				"type": "whereabouts",
+				"range": "192.168.1.0/24",
+				"exclude": ["192.168.1.0/28", "192.168.1.16//28 is no comment",],
+			},
+		}`
+
+		AfterEach(func() {
+			Expect(os.Unsetenv(TolerantParsingEnvVariable)).To(Succeed())
+		})
+
+		It("rejects them by default", func() {
+			_, _, err := LoadIPAMConfig([]byte(conf), "")
+			Expect(err).To(MatchError(HavePrefix("LoadIPAMConfig - JSON Parsing Error: line 3, column 21: invalid character '/'")))
+		})
+
+		It("ignores them when parsing tolerantly", func() {
+			Expect(os.Setenv(TolerantParsingEnvVariable, "true")).To(Succeed())
+			var netConf struct {
+				IPAM struct {
+					OmitRanges []string `json:"exclude"`
+				} `json:"ipam"`
+			}
+			Expect(UnmarshalNetConf([]byte(conf), &netConf)).To(Succeed())
+			Expect(netConf.IPAM.OmitRanges).To(Equal([]string{"192.168.1.0/28", "192.168.1.16//28 is no comment"}))
+		})
+
+		It("still locates the errors when parsing tolerantly", func() {
+			Expect(os.Setenv(TolerantParsingEnvVariable, "true")).To(Succeed())
+			_, _, err := LoadIPAMConfig([]byte(strings.Replace(conf, `"type": "ipvlan",`, `"type": "ipvlan",,`, 1)), "")
+			Expect(err).To(MatchError(HavePrefix("LoadIPAMConfig - JSON Parsing Error: line 4, column 21: invalid character ','")))
+		})
 	})
 })
 
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
+)
+
+// deprecatedParameter is an IPAM parameter slated for removal
+type deprecatedParameter struct {
+	name string
+	// advice tells how to update the network configurations using the parameter
+	advice string
+	// usedBy tells whether the IPAM configuration, keyed by parameter, uses the parameter in its deprecated form
+	usedBy func(ipam map[string]json.RawMessage) bool
+}
+
+var deprecatedParameters = []deprecatedParameter{
+	{
+		name:   "range",
+		advice: "list the range in ipRanges along with the others",
+		usedBy: func(ipam map[string]json.RawMessage) bool {
+			var ipRanges []json.RawMessage
+			return isSet(ipam["range"]) && json.Unmarshal(ipam["ipRanges"], &ipRanges) == nil && len(ipRanges) > 0
+		},
+	},
+	{
+		name:   "datastore",
+		advice: "the etcd datastore was removed, the kubernetes datastore is used instead; remove the parameter",
+		usedBy: func(ipam map[string]json.RawMessage) bool {
+			var datastore string
+			return json.Unmarshal(ipam["datastore"], &datastore) == nil && datastore == "etcd"
+		},
+	},
+	etcdParameter("etcd_host"),
+	etcdParameter("etcd_username"),
+	etcdParameter("etcd_password"),
+	etcdParameter("etcd_key_file"),
+	etcdParameter("etcd_cert_file"),
+	etcdParameter("etcd_ca_cert_file"),
+}
+
+func etcdParameter(name string) deprecatedParameter {
+	return deprecatedParameter{
+		name:   name,
+		advice: "the etcd datastore was removed, the parameter is ignored; remove it",
+		usedBy: func(ipam map[string]json.RawMessage) bool {
+			return isSet(ipam[name])
+		},
+	}
+}
+
+// warnedDeprecations holds the network and parameter pairs already warned about by the process
+var warnedDeprecations sync.Map
+
+// warnDeprecatedParameters counts the deprecated parameters the IPAM configuration of the network uses, warning about
+// each once per process: the long running processes load the configuration of a network over and over.
+func warnDeprecatedParameters(networkName string, netConf []byte) {
+	var conf struct {
+		IPAM map[string]json.RawMessage `json:"ipam"`
+	}
+	if err := UnmarshalNetConf(netConf, &conf); err != nil {
+		return
+	}
+	for _, parameter := range deprecatedParameters {
+		if !parameter.usedBy(conf.IPAM) {
+			continue
+		}
+		metrics.DeprecatedConfig.Inc(parameter.name, networkName)
+		if _, warned := warnedDeprecations.LoadOrStore(networkName+"/"+parameter.name, struct{}{}); !warned {
+			logging.Warningf("network %q uses the deprecated IPAM parameter %q: %s", networkName, parameter.name, parameter.advice)
+		}
+	}
+}
+
+// isSet tells whether the raw value of a parameter is set, and neither null nor empty
+func isSet(value json.RawMessage) bool {
+	switch string(value) {
+	case "", "null", `""`:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TolerantParsingEnvVariable enables, when set to "true", the tolerant parsing of the network configurations: their
+// comments and trailing commas are ignored rather than rejected
+const TolerantParsingEnvVariable = "WHEREABOUTS_TOLERANT_CONFIG_PARSING"
+
+// JSONError is an error decoding a network configuration, located at the line and column of the offending character
+type JSONError struct {
+	Line   int
+	Column int
+	err    error
+}
+
+func (e JSONError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %v", e.Line, e.Column, e.err)
+}
+
+func (e JSONError) Unwrap() error {
+	return e.err
+}
+
+// UnmarshalNetConf decodes a network configuration, or the flat file, into v. Its syntax errors are reported as
+// JSONError; the type errors name the offending field already. With tolerant parsing enabled, the comments - both //
+// and /* */ - and the trailing commas of the configuration are ignored.
+func UnmarshalNetConf(data []byte, v interface{}) error {
+	if tolerantParsing() {
+		data = stripJSONExtensions(data)
+	}
+	err := json.Unmarshal(data, v)
+	if err == nil {
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return newJSONError(data, syntaxErr.Offset, err)
+	}
+	return err
+}
+
+func tolerantParsing() bool {
+	return strings.EqualFold(strings.TrimSpace(os.Getenv(TolerantParsingEnvVariable)), "true")
+}
+
+// newJSONError locates the error at the offset, the number of bytes read when it occurred
+func newJSONError(data []byte, offset int64, err error) JSONError {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	read := data[:offset]
+	return JSONError{
+		Line:   bytes.Count(read, []byte("\n")) + 1,
+		Column: len(read) - bytes.LastIndexByte(read, '\n') - 1,
+		err:    err,
+	}
+}
+
+// stripJSONExtensions blanks out the comments and the trailing commas of the JSON document. The newlines and the
+// offsets of the remaining characters are kept, so that the errors point to the right place of the original document.
+func stripJSONExtensions(data []byte) []byte {
+	stripped := make([]byte, len(data))
+	copy(stripped, data)
+
+	inString := false
+	for i := 0; i < len(stripped); i++ {
+		c := stripped[i]
+		switch {
+		case inString:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '/' && i+1 < len(stripped) && stripped[i+1] == '/':
+			for ; i < len(stripped) && stripped[i] != '\n'; i++ {
+				stripped[i] = ' '
+			}
+		case c == '/' && i+1 < len(stripped) && stripped[i+1] == '*':
+			end := bytes.Index(stripped[i+2:], []byte("*/"))
+			if end < 0 {
+				// an unterminated comment is left for the decoder to report
+				return stripped
+			}
+			for last := i + 2 + end + 1; i <= last; i++ {
+				if stripped[i] != '\n' {
+					stripped[i] = ' '
+				}
+			}
+			i--
+		}
+	}
+
+	inString = false
+	for i := 0; i < len(stripped); i++ {
+		c := stripped[i]
+		switch {
+		case inString:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == ',':
+			next := i + 1
+			for next < len(stripped) && isJSONWhitespace(stripped[next]) {
+				next++
+			}
+			if next < len(stripped) && (stripped[next] == '}' || stripped[next] == ']') {
+				stripped[i] = ' '
+			}
+		}
+	}
+	return stripped
+}
+
+func isJSONWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
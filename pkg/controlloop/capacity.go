@@ -0,0 +1,154 @@
+package controlloop
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	wbclientset "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+// CapacityPath is the path the capacity endpoint is served on
+const CapacityPath = "/capacity"
+
+const (
+	capacityNetworkParam = "network"
+	capacityPodsParam    = "pods"
+)
+
+// NodeCapacity reports how many more pods a node can accept on a node slice network
+type NodeCapacity struct {
+	Network    string `json:"network"`
+	Node       string `json:"node"`
+	SliceRange string `json:"sliceRange,omitempty"`
+	// Capacity is the number of usable addresses of the node's slice
+	Capacity uint64 `json:"capacity"`
+	// Allocated is the number of addresses of the slice allocated to pods or reserved for network services
+	Allocated uint64 `json:"allocated"`
+	Available uint64 `json:"available"`
+	Requested uint64 `json:"requested"`
+	CanAccept bool   `json:"canAccept"`
+}
+
+// CapacityChecker answers whether its node can accept more pods on a node slice network, based on the utilization of
+// the slice assigned to the node. Addresses excluded by the network configuration are not accounted for, hence the
+// available addresses are an upper bound.
+type CapacityChecker struct {
+	wbClient  wbclientset.Interface
+	namespace string
+	nodeName  string
+}
+
+// NewCapacityChecker returns a CapacityChecker for the node slices of the given node
+func NewCapacityChecker(wbClient wbclientset.Interface, nodeName string) *CapacityChecker {
+	return &CapacityChecker{
+		wbClient:  wbClient,
+		namespace: ipPoolsNamespace(),
+		nodeName:  nodeName,
+	}
+}
+
+// Check computes the capacity of the node on the network - the name of its NodeSlicePool, i.e. the network_name or,
+// for unnamed networks, the name of the network configuration - and whether it can accept the requested pods. A node
+// without a slice of the network cannot accept any pod.
+func (cc *CapacityChecker) Check(ctx context.Context, network string, pods uint64) (*NodeCapacity, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	nodeSlicePool, err := cc.wbClient.WhereaboutsV1alpha1().NodeSlicePools(cc.namespace).Get(ctxWithTimeout, network, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := &NodeCapacity{Network: network, Node: cc.nodeName, Requested: pods}
+	for _, allocation := range nodeSlicePool.Status.Allocations {
+		if allocation.NodeName == cc.nodeName {
+			capacity.SliceRange = allocation.SliceRange
+			break
+		}
+	}
+	if capacity.SliceRange == "" {
+		return capacity, nil
+	}
+
+	_, sliceNet, err := net.ParseCIDR(capacity.SliceRange)
+	if err != nil {
+		return nil, fmt.Errorf("invalid slice range %q of node %s: %w", capacity.SliceRange, cc.nodeName, err)
+	}
+	capacity.Capacity = iphelpers.UsableIPCount(*sliceNet)
+
+	allocated, err := cc.allocatedAddresses(ctxWithTimeout, network, capacity.SliceRange)
+	if err != nil {
+		return nil, err
+	}
+	capacity.Allocated = allocated
+	if capacity.Capacity > capacity.Allocated {
+		capacity.Available = capacity.Capacity - capacity.Allocated
+	}
+	capacity.CanAccept = capacity.Available >= pods
+	return capacity, nil
+}
+
+// allocatedAddresses counts the addresses in use in the IP pool of the node's slice. The pool of a named network is
+// looked up first, then the pool of an unnamed one; a missing pool has no allocation.
+func (cc *CapacityChecker) allocatedAddresses(ctx context.Context, network, sliceRange string) (uint64, error) {
+	for _, networkName := range []string{network, wbclient.UnnamedNetwork} {
+		poolName := wbclient.IPPoolName(wbclient.PoolIdentifier{IpRange: sliceRange, NetworkName: networkName, NodeName: cc.nodeName})
+		pool, err := cc.wbClient.WhereaboutsV1alpha1().IPPools(cc.namespace).Get(ctx, poolName, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return 0, fmt.Errorf("failed to retrieve the IP pool %s: %w", poolName, err)
+		}
+		return uint64(len(pool.Spec.Allocations) + len(pool.Status.Reservations)), nil
+	}
+	return 0, nil
+}
+
+// ServeHTTP answers GET /capacity?network=<network>&pods=<count> with the NodeCapacity of the node, as JSON. The
+// requested pods default to 1.
+func (cc *CapacityChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	network := r.URL.Query().Get(capacityNetworkParam)
+	if network == "" {
+		http.Error(w, fmt.Sprintf("missing %q query parameter", capacityNetworkParam), http.StatusBadRequest)
+		return
+	}
+	pods := uint64(1)
+	if podsParam := r.URL.Query().Get(capacityPodsParam); podsParam != "" {
+		var err error
+		if pods, err = strconv.ParseUint(podsParam, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid %q query parameter: %v", capacityPodsParam, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	capacity, err := cc.Check(r.Context(), network, pods)
+	if k8serrors.IsNotFound(err) {
+		http.Error(w, fmt.Sprintf("network %s has no node slices", network), http.StatusNotFound)
+		return
+	} else if err != nil {
+		_ = logging.Errorf("failed to compute the capacity of network %s: %v", network, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(capacity); err != nil {
+		logging.Debugf("failed to write the capacity of network %s: %v", network, err)
+	}
+}
@@ -0,0 +1,88 @@
+package controlloop
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+var _ = Describe("Node slice capacity", func() {
+	const (
+		networkName = "slicenet"
+		nodeName    = "node1"
+		sliceRange  = "10.0.0.0/29"
+	)
+
+	var checker *CapacityChecker
+
+	BeforeEach(func() {
+		pool := ipPool(
+			kubernetes.PoolIdentifier{IpRange: sliceRange, NetworkName: networkName, NodeName: nodeName},
+			ipPoolsNamespace(),
+			"default/pod1", "default/pod2")
+		checker = NewCapacityChecker(fakewbclient.NewSimpleClientset(
+			nodeSlicePool(networkName, ipPoolsNamespace(), "10.0.0.0/24", "/29", nodeName, sliceRange),
+			pool,
+		), nodeName)
+	})
+
+	It("accepts pods fitting in the available addresses of the node's slice", func() {
+		capacity, err := checker.Check(context.TODO(), networkName, 4)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*capacity).To(Equal(NodeCapacity{
+			Network:    networkName,
+			Node:       nodeName,
+			SliceRange: sliceRange,
+			Capacity:   6,
+			Allocated:  2,
+			Available:  4,
+			Requested:  4,
+			CanAccept:  true,
+		}))
+	})
+
+	It("refuses pods exceeding the available addresses of the node's slice", func() {
+		capacity, err := checker.Check(context.TODO(), networkName, 5)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(capacity.CanAccept).To(BeFalse())
+	})
+
+	It("refuses pods on a node without a slice", func() {
+		capacity, err := NewCapacityChecker(fakewbclient.NewSimpleClientset(
+			nodeSlicePool(networkName, ipPoolsNamespace(), "10.0.0.0/24", "/29", "node2", sliceRange),
+		), nodeName).Check(context.TODO(), networkName, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(capacity.SliceRange).To(BeEmpty())
+		Expect(capacity.CanAccept).To(BeFalse())
+	})
+
+	It("serves the capacity queries", func() {
+		recorder := httptest.NewRecorder()
+		checker.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, CapacityPath+"?network="+networkName+"&pods=3", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		var capacity NodeCapacity
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &capacity)).To(Succeed())
+		Expect(capacity.Available).To(BeEquivalentTo(4))
+		Expect(capacity.CanAccept).To(BeTrue())
+	})
+
+	It("reports networks without node slices as not found", func() {
+		recorder := httptest.NewRecorder()
+		checker.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, CapacityPath+"?network=othernet", nil))
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("rejects queries without a network", func() {
+		recorder := httptest.NewRecorder()
+		checker.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, CapacityPath, nil))
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,491 @@
+package controlloop
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbclientset "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/platform"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/reconciler"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// The modes of the startup crosswalk
+const (
+	StartupReconcileOff    = "off"
+	StartupReconcileReport = "report"
+	StartupReconcileFix    = "fix"
+)
+
+const startupCrosswalkLeaseName = "whereabouts-startup-crosswalk"
+
+// CrosswalkEntry is an address the IP pools and the network-status of the pods disagree on
+type CrosswalkEntry struct {
+	PodRef string `json:"podRef"`
+	IP     string `json:"ip"`
+	Pool   string `json:"pool"`
+}
+
+// CrosswalkReport lists the inconsistencies found by the startup crosswalk
+type CrosswalkReport struct {
+	// Unrecorded are the addresses the running pods of the node list in their network-status, missing from their pool
+	Unrecorded []CrosswalkEntry `json:"unrecorded,omitempty"`
+	// Conflicting are the addresses the running pods of the node list in their network-status, allocated to - or
+	// reserved cluster wide by - a different pod
+	Conflicting []CrosswalkEntry `json:"conflicting,omitempty"`
+	// Orphaned are the allocations of pods which do not exist
+	Orphaned []CrosswalkEntry `json:"orphaned,omitempty"`
+	// ChurnProtectedPools are the IP pools whose orphaned allocations are left alone for exceeding the churn limit
+	ChurnProtectedPools []string `json:"churnProtectedPools,omitempty"`
+}
+
+// StartupCrosswalk compares, once, the IP pools with the network-status of the pods: every address a running pod of
+// the node lists in its network-status should be allocated to it in its pool, and the pools should only hold
+// allocations of existing pods. The pools of the networks opted out of reconciliation are left alone.
+type StartupCrosswalk struct {
+	client    *wbclient.Client
+	nodeName  string
+	mountPath string
+	// resolveContainerID finds the container id the unrecorded addresses are allocated under; they are only reported
+	// when nil
+	resolveContainerID ContainerIDResolver
+	// maxChurnPercent is the share of a pool's allocations ReleaseOrphans may release, as for the IP reconciler
+	maxChurnPercent int
+}
+
+// NewStartupCrosswalk returns a StartupCrosswalk for the running pods of the given node
+func NewStartupCrosswalk(k8sClient kubernetes.Interface, wbClient wbclientset.Interface, nadClient nadclient.Interface, nodeName string, resolveContainerID ContainerIDResolver) *StartupCrosswalk {
+	return &StartupCrosswalk{
+		client:             wbclient.NewKubernetesClientWithNetAttachDefs(wbClient, k8sClient, nadClient),
+		nodeName:           nodeName,
+		mountPath:          platform.HostMountPath,
+		resolveContainerID: resolveContainerID,
+		maxChurnPercent:    reconciler.DefaultMaxChurnPercent,
+	}
+}
+
+// RunOrphanCrosswalk competes with the other control loop instances for a cluster-wide lease. The holder looks the IP
+// pools over for orphaned allocations once - releasing them when fix is set - then keeps the lease, so that the other
+// instances do not repeat it. It blocks until the context is cancelled.
+func RunOrphanCrosswalk(ctx context.Context, identity string, k8sClient kubernetes.Interface, wbClient wbclientset.Interface, nadClient nadclient.Interface, fix bool) {
+	RunWhileLeading(ctx, startupCrosswalkLeaseName, identity, k8sClient, "crosswalk the orphaned allocations", func(leaderCtx context.Context) {
+		report, err := NewStartupCrosswalk(k8sClient, wbClient, nadClient, "", nil).ReleaseOrphans(leaderCtx, fix)
+		if report != nil {
+			logging.Verbosef("startup crosswalk: %d orphaned IPs, %d IP pools protected from churn", len(report.Orphaned),
+				len(report.ChurnProtectedPools))
+		}
+		if err != nil {
+			_ = logging.Errorf("startup crosswalk failure: %v", err)
+		}
+		<-leaderCtx.Done()
+	})
+}
+
+// crosswalkState is what a crosswalk compares, along with the changes it finds
+type crosswalkState struct {
+	netAttachDefs []nadv1.NetworkAttachmentDefinition
+	pools         []*wbclient.KubernetesIPPool
+	pods          []v1.Pod
+	existingPods  map[string]struct{}
+	// reservations are the overlapping range reservations, by namespace and name
+	reservations map[string]*whereaboutsv1alpha1.OverlappingRangeIPReservation
+	changes      map[string]*poolChanges
+}
+
+// list reads the network-attachment-definitions, the pools of the networks not opted out of reconciliation, the
+// overlapping range reservations and the pods
+func (sc *StartupCrosswalk) list(ctx context.Context) (*crosswalkState, error) {
+	netAttachDefs, err := sc.client.ListNetAttachDefs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the network-attachment-definitions: %w", err)
+	}
+	unreconciledNetworks := wbclient.NewUnreconciledNetworks(netAttachDefs)
+
+	// the pools and the reservations are listed before the pods, hence all the pods they hold allocations of are
+	// listed too, unless deleted
+	pools, err := sc.client.ListIPPools(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the IP pools: %w", err)
+	}
+	reservations, err := sc.client.ListOverlappingIPs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the overlapping range reservations: %w", err)
+	}
+	pods, err := sc.client.ListPods(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the pods: %w", err)
+	}
+
+	state := &crosswalkState{
+		netAttachDefs: netAttachDefs,
+		pods:          pods,
+		existingPods:  map[string]struct{}{},
+		reservations:  map[string]*whereaboutsv1alpha1.OverlappingRangeIPReservation{},
+		changes:       map[string]*poolChanges{},
+	}
+	for _, pool := range pools {
+		if k8sPool, ok := pool.(*wbclient.KubernetesIPPool); ok && !unreconciledNetworks.ContainsPool(k8sPool.Name(), k8sPool.Range()) {
+			state.pools = append(state.pools, k8sPool)
+			state.changes[k8sPool.Name()] = &poolChanges{pool: k8sPool}
+		}
+	}
+	for i := range reservations {
+		state.reservations[podID(reservations[i].GetNamespace(), reservations[i].GetName())] = &reservations[i]
+	}
+	for i := range pods {
+		state.existingPods[podID(pods[i].GetNamespace(), pods[i].GetName())] = struct{}{}
+	}
+	return state, nil
+}
+
+// Run reports the inconsistencies of the running pods of the node and, when fix is set, allocates the unrecorded
+// addresses to their pods - under the container id of their sandbox - and reserves them cluster wide on the networks
+// of overlapping ranges, releasing the allocations and reservations of pods which do not exist they collide with.
+// Conflicting addresses are only reported, as telling which pod actually uses them takes a human, as are the
+// unrecorded addresses of pods whose sandbox the container runtime does not tell.
+func (sc *StartupCrosswalk) Run(ctx context.Context, fix bool) (*CrosswalkReport, error) {
+	state, err := sc.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &CrosswalkReport{}
+	for i := range state.pods {
+		pod := &state.pods[i]
+		if pod.Spec.NodeName != sc.nodeName || pod.Status.Phase != v1.PodRunning || pod.GetDeletionTimestamp() != nil {
+			continue
+		}
+		sc.crosswalkPod(ctx, pod, fix, state, report)
+	}
+
+	for _, entry := range report.Unrecorded {
+		logging.Verbosef("IP %s of pod %s is not allocated in the IP pool %s", entry.IP, entry.PodRef, entry.Pool)
+	}
+	for _, entry := range report.Conflicting {
+		_ = logging.Errorf("IP %s of pod %s is allocated to another pod in the IP pool %s", entry.IP, entry.PodRef, entry.Pool)
+	}
+	if !fix {
+		return report, nil
+	}
+	return report, sc.apply(ctx, state)
+}
+
+// ReleaseOrphans reports the allocations of pods which do not exist and, when fix is set, releases them along with
+// their overlapping range reservations. As the IP reconciler, it spares the allocations within the grace period of
+// their pool, and the pools holding more orphaned allocations than the churn limit. As it looks every pool over, a
+// single control loop instance runs it: see RunOrphanCrosswalk.
+func (sc *StartupCrosswalk) ReleaseOrphans(ctx context.Context, fix bool) (*CrosswalkReport, error) {
+	state, err := sc.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	report := &CrosswalkReport{}
+	for _, pool := range state.pools {
+		gracePeriod := reconciler.AllocationGracePeriod(pool)
+		var orphaned []types.IPReservation
+		for _, allocation := range pool.Allocations() {
+			if podExists(state.existingPods, allocation.PodRef) {
+				continue
+			}
+			if now.Sub(allocation.AllocatedAt) < gracePeriod {
+				logging.Debugf("IP %s of the IP pool %s was allocated to pod %s less than %s ago; skipping",
+					allocation.IP, pool.Name(), allocation.PodRef, gracePeriod)
+				continue
+			}
+			orphaned = append(orphaned, allocation)
+		}
+		if reconciler.ExceedsMaxChurn(sc.maxChurnPercent, len(orphaned), len(pool.Allocations())) {
+			_ = logging.Errorf("IP pool %s holds %d orphaned allocations out of %d, more than %d%%; leaving them alone",
+				pool.Name(), len(orphaned), len(pool.Allocations()), sc.maxChurnPercent)
+			report.ChurnProtectedPools = append(report.ChurnProtectedPools, pool.Name())
+			continue
+		}
+		for _, allocation := range orphaned {
+			report.Orphaned = append(report.Orphaned, CrosswalkEntry{PodRef: allocation.PodRef, IP: allocation.IP.String(), Pool: pool.Name()})
+			state.changes[pool.Name()].released = append(state.changes[pool.Name()].released, allocation)
+		}
+	}
+
+	for _, entry := range report.Orphaned {
+		logging.Verbosef("IP %s of the IP pool %s is allocated to pod %s, which does not exist", entry.IP, entry.Pool, entry.PodRef)
+	}
+	if !fix {
+		return report, nil
+	}
+	updateErr := sc.apply(ctx, state)
+
+	// the reservations are only released along with the allocations, as the pool updates may fail
+	var released []wbclient.ReleasedAllocations
+	for _, change := range state.changes {
+		if change.applied && len(change.released) > 0 {
+			released = append(released, wbclient.ReleasedAllocations{Pool: change.pool, Allocations: change.released})
+		}
+	}
+	if len(released) > 0 {
+		if err := sc.client.ReleaseOverlappingIPs(ctx, released); err != nil {
+			return report, err
+		}
+	}
+	return report, updateErr
+}
+
+// apply updates the pools, logging the failures; it returns the last
+func (sc *StartupCrosswalk) apply(ctx context.Context, state *crosswalkState) error {
+	var updateErr error
+	for _, change := range state.changes {
+		if err := change.apply(ctx, sc.client); err != nil {
+			updateErr = fmt.Errorf("failed to update the IP pool %s: %w", change.pool.Name(), err)
+			_ = logging.Errorf("startup crosswalk: %v", updateErr)
+		}
+	}
+	return updateErr
+}
+
+// crosswalkPod checks the addresses the network-status of the pod lists are allocated to it in their pools
+func (sc *StartupCrosswalk) crosswalkPod(ctx context.Context, pod *v1.Pod, fix bool, state *crosswalkState, report *CrosswalkReport) {
+	podRef := podID(pod.GetNamespace(), pod.GetName())
+	ifaceStatuses, err := podNetworkStatus(pod)
+	if err != nil {
+		logging.Debugf("failed to parse the network-status of pod %s: %v", podRef, err)
+		return
+	}
+
+	containerID := ""
+	for _, ifaceStatus := range ifaceStatuses {
+		if ifaceStatus.Default || len(ifaceStatus.IPs) == 0 {
+			continue
+		}
+		nad := findNetAttachDef(state.netAttachDefs, ifaceStatus.Name)
+		if nad == nil || wbclient.ReconcileDisabled(nad.GetAnnotations()) {
+			continue
+		}
+		ipamConfig, err := ipamConfiguration(nad, pod.GetNamespace(), pod.GetName(), sc.mountPath)
+		if err != nil {
+			if !isInvalidPluginType(err) {
+				logging.Debugf("failed to read the IPAM configuration of network %s of pod %s: %v", ifaceStatus.Name, podRef, err)
+			}
+			continue
+		}
+
+		for _, ipStr := range ifaceStatus.IPs {
+			ip := net.ParseIP(ipStr)
+			if ip == nil {
+				continue
+			}
+			pool := sc.podPool(ip, ipamConfig, state.pools)
+			if pool == nil {
+				logging.Debugf("no IP pool of network %s holds IP %s of pod %s", ifaceStatus.Name, ip, podRef)
+				continue
+			}
+			entry := CrosswalkEntry{PodRef: podRef, IP: ip.String(), Pool: pool.Name()}
+
+			// an allocation - or a reservation - of a pod which does not exist is released, making room for the
+			// running pod
+			allocation := allocationOf(pool, ip)
+			if allocation != nil && allocation.PodRef == podRef {
+				continue
+			}
+			if allocation != nil && podExists(state.existingPods, allocation.PodRef) {
+				report.Conflicting = append(report.Conflicting, entry)
+				continue
+			}
+			var reservation, staleReservation *whereaboutsv1alpha1.OverlappingRangeIPReservation
+			if ipamConfig.OverlappingRanges {
+				reservation = state.reservations[podID(pool.Namespace(), wbclient.NormalizeIP(ip, ipamConfig.NetworkName))]
+				if reservation != nil && reservation.Spec.PodRef != podRef {
+					if podExists(state.existingPods, reservation.Spec.PodRef) {
+						report.Conflicting = append(report.Conflicting, entry)
+						continue
+					}
+					staleReservation = reservation
+				}
+			}
+			report.Unrecorded = append(report.Unrecorded, entry)
+			if !fix {
+				continue
+			}
+
+			if containerID == "" {
+				if containerID, err = sc.containerID(ctx, pod); err != nil {
+					_ = logging.Errorf("not recording IP %s of pod %s: %v", ip, podRef, err)
+					continue
+				}
+			}
+			change := state.changes[pool.Name()]
+			recorded := types.IPReservation{IP: ip, ContainerID: containerID, PodRef: podRef, IfName: ifaceStatus.Interface}
+			change.recorded = append(change.recorded, recorded)
+			if allocation != nil {
+				change.released = append(change.released, *allocation)
+			}
+			if staleReservation != nil {
+				change.staleReservations = append(change.staleReservations, staleReservation)
+			}
+			if reservation == nil || staleReservation != nil {
+				change.reservations = append(change.reservations, overlappingReservation{networkName: ipamConfig.NetworkName, allocation: recorded})
+			}
+		}
+	}
+}
+
+// containerID resolves the container id of the sandbox of the running pod
+func (sc *StartupCrosswalk) containerID(ctx context.Context, pod *v1.Pod) (string, error) {
+	if sc.resolveContainerID == nil {
+		return "", fmt.Errorf("no container id resolver")
+	}
+	containerID, err := sc.resolveContainerID(ctx, pod.GetNamespace(), pod.GetName())
+	if err != nil {
+		return "", err
+	}
+	if containerID == "" {
+		return "", fmt.Errorf("the container runtime has no ready sandbox of the pod")
+	}
+	return containerID, nil
+}
+
+// podPool finds the pool of the network the IP was allocated from: the pool of the range holding it or, for node
+// slice and sharded networks, the pool of the node's slice - or of the shard - holding it
+func (sc *StartupCrosswalk) podPool(ip net.IP, ipamConfig *types.IPAMConfig, pools []*wbclient.KubernetesIPPool) *wbclient.KubernetesIPPool {
+	nodeName := ""
+	if ipamConfig.NodeSliceSize != "" {
+		nodeName = sc.nodeName
+	}
+	for _, pool := range pools {
+		_, poolNet, err := net.ParseCIDR(pool.Range())
+		if err != nil || !poolNet.Contains(ip) {
+			continue
+		}
+		poolIdentifier := wbclient.PoolIdentifier{IpRange: pool.Range(), NetworkName: ipamConfig.NetworkName, NodeName: nodeName, Shard: ipamConfig.PoolShards > 1}
+		if pool.Name() == wbclient.IPPoolName(poolIdentifier) {
+			return pool
+		}
+	}
+	return nil
+}
+
+func findNetAttachDef(netAttachDefs []nadv1.NetworkAttachmentDefinition, networkStatusName string) *nadv1.NetworkAttachmentDefinition {
+	for i := range netAttachDefs {
+		if podID(netAttachDefs[i].GetNamespace(), netAttachDefs[i].GetName()) == networkStatusName {
+			return &netAttachDefs[i]
+		}
+	}
+	return nil
+}
+
+func podExists(existingPods map[string]struct{}, podRef string) bool {
+	_, found := existingPods[podRef]
+	return found
+}
+
+func allocationOf(pool *wbclient.KubernetesIPPool, ip net.IP) *types.IPReservation {
+	allocations := pool.Allocations()
+	for i := range allocations {
+		if allocations[i].IP.Equal(ip) {
+			return &allocations[i]
+		}
+	}
+	return nil
+}
+
+// poolChanges are the allocations the startup crosswalk adds to, and releases from, a pool, along with their overlapping
+// range reservations
+type poolChanges struct {
+	pool     *wbclient.KubernetesIPPool
+	recorded []types.IPReservation
+	released []types.IPReservation
+	// reservations are the overlapping range reservations of the recorded allocations, created before the pool update
+	reservations []overlappingReservation
+	// staleReservations are the reservations of pods which do not exist, held on recorded addresses
+	staleReservations []*whereaboutsv1alpha1.OverlappingRangeIPReservation
+	// applied tells the pool update succeeded
+	applied bool
+}
+
+// overlappingReservation is the cluster wide reservation of an allocation on a network of overlapping ranges
+type overlappingReservation struct {
+	networkName string
+	allocation  types.IPReservation
+}
+
+// apply reserves the recorded addresses cluster wide, then updates the pool; the reservations are deleted again when
+// the pool update fails
+func (pc *poolChanges) apply(ctx context.Context, client *wbclient.Client) error {
+	if len(pc.recorded) == 0 && len(pc.released) == 0 {
+		return nil
+	}
+
+	for _, reservation := range pc.staleReservations {
+		if err := client.DeleteOverlappingIP(ctx, reservation); err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete the overlapping range reservation %s: %w", reservation.GetName(), err)
+		}
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	var created []overlappingReservation
+	for _, reservation := range pc.reservations {
+		allocation := reservation.allocation
+		if err := client.CreateOverlappingIP(ctxWithTimeout, pc.pool.Namespace(), allocation.IP, reservation.networkName,
+			whereaboutsv1alpha1.OverlappingRangeIPReservationSpec{
+				ContainerID: allocation.ContainerID,
+				PodRef:      allocation.PodRef,
+				IfName:      allocation.IfName,
+			}); err != nil {
+			pc.rollback(ctx, client, created)
+			return err
+		}
+		created = append(created, reservation)
+	}
+
+	var reservations []types.IPReservation
+	for _, allocation := range pc.pool.Allocations() {
+		if !releasedIP(pc.released, allocation.IP) {
+			reservations = append(reservations, allocation)
+		}
+	}
+	reservations = append(reservations, pc.recorded...)
+
+	if err := pc.pool.Update(ctxWithTimeout, reservations); err != nil {
+		pc.rollback(ctx, client, created)
+		return err
+	}
+	pc.applied = true
+	return nil
+}
+
+// rollback deletes the overlapping range reservations created for a pool update which failed
+func (pc *poolChanges) rollback(ctx context.Context, client *wbclient.Client, created []overlappingReservation) {
+	for _, reservation := range created {
+		name := wbclient.NormalizeIP(reservation.allocation.IP, reservation.networkName)
+		err := client.DeleteOverlappingIP(ctx, &whereaboutsv1alpha1.OverlappingRangeIPReservation{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: pc.pool.Namespace()},
+		})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			_ = logging.Errorf("failed to roll the overlapping range reservation %s back: %v", name, err)
+		}
+	}
+}
+
+func releasedIP(released []types.IPReservation, ip net.IP) bool {
+	for _, allocation := range released {
+		if allocation.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
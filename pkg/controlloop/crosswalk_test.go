@@ -0,0 +1,252 @@
+package controlloop
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	nad "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/platform"
+)
+
+var _ = Describe("Startup crosswalk", func() {
+	const (
+		namespace   = "default"
+		networkName = "net"
+		nodeName    = "node1"
+		ipRange     = "10.0.0.0/24"
+	)
+
+	var (
+		mountPath string
+		wbClient  *fakewbclient.Clientset
+		crosswalk *StartupCrosswalk
+	)
+
+	runningPod := func(name, node, ip string) *v1.Pod {
+		pod := podSpec(name, namespace, node)
+		pod.Annotations[nad.NetworkStatusAnnot] = `[{"name":"default/net","interface":"net1","ips":["` + ip + `"]}]`
+		pod.Status.Phase = v1.PodRunning
+		return pod
+	}
+
+	BeforeEach(func() {
+		const configFilePermissions = 0755
+
+		var err error
+		mountPath, err = os.MkdirTemp("", "crosswalk")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.MkdirAll(path.Join(mountPath, path.Dir(platform.WhereaboutsConfigPath)), configFilePermissions)).To(Succeed())
+		Expect(os.WriteFile(
+			path.Join(mountPath, platform.WhereaboutsConfigPath),
+			[]byte(dummyWhereaboutsConfig()), configFilePermissions)).To(Succeed())
+
+		k8sClient := fakek8sclient.NewSimpleClientset(
+			runningPod("recorded", nodeName, "10.0.0.1"),
+			runningPod("unrecorded", nodeName, "10.0.0.2"),
+			runningPod("conflicting", nodeName, "10.0.0.3"),
+			runningPod("elsewhere", "node2", "10.0.0.4"),
+			runningPod("reused", nodeName, "10.0.0.6"),
+			runningPod("reserved", nodeName, "10.0.0.7"),
+		)
+		wbClient = fakewbclient.NewSimpleClientset(&v1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: ipPoolsNamespace(), ResourceVersion: "1"},
+			Spec: v1alpha1.IPPoolSpec{
+				Range: ipRange,
+				Allocations: map[string]v1alpha1.IPAllocation{
+					"1": {ContainerID: "c1", PodRef: "default/recorded", IfName: "net1"},
+					"3": {ContainerID: "c4", PodRef: "default/elsewhere", IfName: "net1"},
+					"5": {ContainerID: "c5", PodRef: "default/gone", IfName: "net1"},
+					"6": {ContainerID: "c6", PodRef: "default/gone-too", IfName: "net1"},
+				},
+			},
+		},
+			crosswalkReservation("10.0.0.5", "c5", "default/gone"),
+			crosswalkReservation("10.0.0.6", "c6", "default/gone-too"),
+			crosswalkReservation("10.0.0.7", "c4", "default/elsewhere"))
+		nadClient, err := newFakeNetAttachDefClient(namespace, netAttachDef(networkName, namespace, dummyNetSpec(networkName, ipRange)))
+		Expect(err).NotTo(HaveOccurred())
+
+		crosswalk = NewStartupCrosswalk(k8sClient, wbClient, nadClient, nodeName, func(ctx context.Context, namespace, name string) (string, error) {
+			return "sandbox-" + name, nil
+		})
+		crosswalk.mountPath = mountPath
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(mountPath)).To(Succeed())
+	})
+
+	It("reports the inconsistencies of the running pods of the node", func() {
+		report, err := crosswalk.Run(context.TODO(), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Unrecorded).To(ConsistOf(
+			CrosswalkEntry{PodRef: "default/unrecorded", IP: "10.0.0.2", Pool: "10.0.0.0-24"},
+			CrosswalkEntry{PodRef: "default/reused", IP: "10.0.0.6", Pool: "10.0.0.0-24"}))
+		Expect(report.Conflicting).To(ConsistOf(
+			CrosswalkEntry{PodRef: "default/conflicting", IP: "10.0.0.3", Pool: "10.0.0.0-24"},
+			CrosswalkEntry{PodRef: "default/reserved", IP: "10.0.0.7", Pool: "10.0.0.0-24"}))
+		Expect(report.Orphaned).To(BeEmpty())
+
+		Expect(crosswalkPoolAllocations(wbClient)).To(HaveLen(4))
+		Expect(crosswalkReservations(wbClient)).To(HaveLen(3))
+	})
+
+	It("records the unrecorded allocations, along with their overlapping range reservations", func() {
+		_, err := crosswalk.Run(context.TODO(), true)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(crosswalkPoolAllocations(wbClient)).To(Equal(map[string]v1alpha1.IPAllocation{
+			"1": {ContainerID: "c1", PodRef: "default/recorded", IfName: "net1"},
+			"2": {ContainerID: "sandbox-unrecorded", PodRef: "default/unrecorded", IfName: "net1"},
+			"3": {ContainerID: "c4", PodRef: "default/elsewhere", IfName: "net1"},
+			"5": {ContainerID: "c5", PodRef: "default/gone", IfName: "net1"},
+			"6": {ContainerID: "sandbox-reused", PodRef: "default/reused", IfName: "net1"},
+		}))
+		Expect(crosswalkReservations(wbClient)).To(Equal(map[string]v1alpha1.OverlappingRangeIPReservationSpec{
+			"10.0.0.2": {ContainerID: "sandbox-unrecorded", PodRef: "default/unrecorded", IfName: "net1"},
+			"10.0.0.5": {ContainerID: "c5", PodRef: "default/gone"},
+			"10.0.0.6": {ContainerID: "sandbox-reused", PodRef: "default/reused", IfName: "net1"},
+			"10.0.0.7": {ContainerID: "c4", PodRef: "default/elsewhere"},
+		}))
+	})
+
+	It("only reports the unrecorded allocations of pods whose sandbox is unknown", func() {
+		crosswalk.resolveContainerID = func(ctx context.Context, namespace, name string) (string, error) {
+			return "", nil
+		}
+
+		report, err := crosswalk.Run(context.TODO(), true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Unrecorded).To(HaveLen(2))
+		Expect(crosswalkPoolAllocations(wbClient)).To(HaveLen(4))
+		Expect(crosswalkReservations(wbClient)).To(HaveLen(3))
+	})
+
+	It("deletes the overlapping range reservations again when the pool update fails", func() {
+		wbClient.PrependReactor("patch", "ippools", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, k8serrors.NewConflict(v1alpha1.Resource("ippools"), "10.0.0.0-24", nil)
+		})
+
+		_, err := crosswalk.Run(context.TODO(), true)
+		Expect(err).To(HaveOccurred())
+		Expect(crosswalkReservations(wbClient)).To(Equal(map[string]v1alpha1.OverlappingRangeIPReservationSpec{
+			"10.0.0.5": {ContainerID: "c5", PodRef: "default/gone"},
+			"10.0.0.7": {ContainerID: "c4", PodRef: "default/elsewhere"},
+		}))
+	})
+
+	It("releases the orphaned allocations, along with their overlapping range reservations", func() {
+		report, err := crosswalk.ReleaseOrphans(context.TODO(), true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Orphaned).To(ConsistOf(
+			CrosswalkEntry{PodRef: "default/gone", IP: "10.0.0.5", Pool: "10.0.0.0-24"},
+			CrosswalkEntry{PodRef: "default/gone-too", IP: "10.0.0.6", Pool: "10.0.0.0-24"}))
+
+		Expect(crosswalkPoolAllocations(wbClient)).To(Equal(map[string]v1alpha1.IPAllocation{
+			"1": {ContainerID: "c1", PodRef: "default/recorded", IfName: "net1"},
+			"3": {ContainerID: "c4", PodRef: "default/elsewhere", IfName: "net1"},
+		}))
+		Expect(crosswalkReservations(wbClient)).To(Equal(map[string]v1alpha1.OverlappingRangeIPReservationSpec{
+			"10.0.0.7": {ContainerID: "c4", PodRef: "default/elsewhere"},
+		}))
+	})
+
+	It("spares the orphaned allocations within the grace period of their pool", func() {
+		allocations := crosswalkPoolAllocations(wbClient)
+		allocation := allocations["5"]
+		allocation.AllocatedAt = &metav1.Time{Time: time.Now()}
+		allocations["5"] = allocation
+		crosswalkUpdatePoolAllocations(wbClient, allocations)
+
+		report, err := crosswalk.ReleaseOrphans(context.TODO(), true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Orphaned).To(ConsistOf(
+			CrosswalkEntry{PodRef: "default/gone-too", IP: "10.0.0.6", Pool: "10.0.0.0-24"}))
+		Expect(crosswalkPoolAllocations(wbClient)).To(HaveKey("5"))
+		Expect(crosswalkReservations(wbClient)).To(HaveKey("10.0.0.5"))
+	})
+
+	It("keeps the overlapping range reservations of the spared allocations of a pod whose others are released", func() {
+		allocations := crosswalkPoolAllocations(wbClient)
+		allocations["5"] = v1alpha1.IPAllocation{ContainerID: "c5", PodRef: "default/gone", IfName: "net1", AllocatedAt: &metav1.Time{Time: time.Now()}}
+		allocations["6"] = v1alpha1.IPAllocation{ContainerID: "c5", PodRef: "default/gone", IfName: "net2"}
+		crosswalkUpdatePoolAllocations(wbClient, allocations)
+		_, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(ipPoolsNamespace()).Update(
+			context.TODO(), crosswalkReservation("10.0.0.6", "c5", "default/gone"), metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		report, err := crosswalk.ReleaseOrphans(context.TODO(), true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Orphaned).To(ConsistOf(
+			CrosswalkEntry{PodRef: "default/gone", IP: "10.0.0.6", Pool: "10.0.0.0-24"}))
+		Expect(crosswalkReservations(wbClient)).To(Equal(map[string]v1alpha1.OverlappingRangeIPReservationSpec{
+			"10.0.0.5": {ContainerID: "c5", PodRef: "default/gone"},
+			"10.0.0.7": {ContainerID: "c4", PodRef: "default/elsewhere"},
+		}))
+	})
+
+	It("leaves the pools holding more orphaned allocations than the churn limit alone", func() {
+		allocations := crosswalkPoolAllocations(wbClient)
+		for i := 10; i < 20; i++ {
+			allocations[strconv.Itoa(i)] = v1alpha1.IPAllocation{ContainerID: fmt.Sprintf("c%d", i), PodRef: fmt.Sprintf("default/gone-%d", i), IfName: "net1"}
+		}
+		crosswalkUpdatePoolAllocations(wbClient, allocations)
+
+		report, err := crosswalk.ReleaseOrphans(context.TODO(), true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Orphaned).To(BeEmpty())
+		Expect(report.ChurnProtectedPools).To(ConsistOf("10.0.0.0-24"))
+		Expect(crosswalkPoolAllocations(wbClient)).To(HaveLen(14))
+		Expect(crosswalkReservations(wbClient)).To(HaveLen(3))
+	})
+})
+
+func crosswalkReservation(ip, containerID, podRef string) *v1alpha1.OverlappingRangeIPReservation {
+	return &v1alpha1.OverlappingRangeIPReservation{
+		ObjectMeta: metav1.ObjectMeta{Name: ip, Namespace: ipPoolsNamespace()},
+		Spec:       v1alpha1.OverlappingRangeIPReservationSpec{ContainerID: containerID, PodRef: podRef},
+	}
+}
+
+func crosswalkReservations(wbClient wbclient.Interface) map[string]v1alpha1.OverlappingRangeIPReservationSpec {
+	reservations, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(ipPoolsNamespace()).List(context.TODO(), metav1.ListOptions{})
+	Expect(err).NotTo(HaveOccurred())
+	specs := map[string]v1alpha1.OverlappingRangeIPReservationSpec{}
+	for _, reservation := range reservations.Items {
+		specs[reservation.GetName()] = reservation.Spec
+	}
+	return specs
+}
+
+func crosswalkPoolAllocations(wbClient wbclient.Interface) map[string]v1alpha1.IPAllocation {
+	pool, err := wbClient.WhereaboutsV1alpha1().IPPools(ipPoolsNamespace()).Get(context.TODO(), "10.0.0.0-24", metav1.GetOptions{})
+	Expect(err).NotTo(HaveOccurred())
+	return pool.Spec.Allocations
+}
+
+func crosswalkUpdatePoolAllocations(wbClient wbclient.Interface, allocations map[string]v1alpha1.IPAllocation) {
+	pools := wbClient.WhereaboutsV1alpha1().IPPools(ipPoolsNamespace())
+	pool, err := pools.Get(context.TODO(), "10.0.0.0-24", metav1.GetOptions{})
+	Expect(err).NotTo(HaveOccurred())
+	pool.Spec.Allocations = allocations
+	_, err = pools.Update(context.TODO(), pool, metav1.UpdateOptions{})
+	Expect(err).NotTo(HaveOccurred())
+}
@@ -0,0 +1,99 @@
+package controlloop
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	v1coreinformerfactory "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	v1corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
+	nadinformers "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions"
+
+	wbclientset "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+const deadNodeCleanupLeaseName = "whereabouts-dead-node-cleanup"
+
+// RunDeadNodeCleanup competes with the other control loop instances for a cluster-wide lease. While
+// holding it, it garbage collects the IP addresses of deleted pods scheduled on nodes which no longer
+// exist: the node-local pod controllers only watch their own node, hence nobody else would. Once elected, it
+// first goes through the pods bound to the nodes missing from the node list, whose node may have gone while no
+// instance was leading. It blocks until the context is cancelled.
+func RunDeadNodeCleanup(ctx context.Context, identity string, k8sClient kubernetes.Interface, wbClient wbclientset.Interface, nadClient nadclient.Interface, recorder record.EventRecorder, workers int) {
+	RunWhileLeading(ctx, deadNodeCleanupLeaseName, identity, k8sClient, "clean up the IP addresses of pods on dead nodes", func(leaderCtx context.Context) {
+		runDeadNodePodController(leaderCtx, k8sClient, wbClient, nadClient, recorder, workers)
+	})
+}
+
+func runDeadNodePodController(ctx context.Context, k8sClient kubernetes.Interface, wbClient wbclientset.Interface, nadClient nadclient.Interface, recorder record.EventRecorder, workers int) {
+	k8sCoreInformerFactory := v1coreinformerfactory.NewSharedInformerFactory(k8sClient, noResyncPeriod)
+	wbInformerFactory := wbinformers.NewSharedInformerFactory(wbClient, noResyncPeriod)
+	netAttachDefInformerFactory := nadinformers.NewSharedInformerFactory(nadClient, noResyncPeriod)
+
+	controller := newDeadNodePodController(
+		k8sClient,
+		wbClient,
+		k8sCoreInformerFactory,
+		wbInformerFactory,
+		netAttachDefInformerFactory,
+		recorder,
+		wbclient.DeallocateBatch)
+
+	k8sCoreInformerFactory.Start(ctx.Done())
+	wbInformerFactory.Start(ctx.Done())
+	netAttachDefInformerFactory.Start(ctx.Done())
+
+	controller.Start(ctx.Done(), workers)
+	enqueuePodsOnDeadNodes(controller)
+	<-ctx.Done()
+	controller.Shutdown()
+}
+
+// newDeadNodePodController creates a PodController, fed by cluster-wide informers, which only
+// garbage collects the addresses of pods whose node is gone.
+func newDeadNodePodController(k8sCoreClient kubernetes.Interface, wbClient wbclientset.Interface, k8sCoreInformerFactory v1coreinformerfactory.SharedInformerFactory, wbSharedInformerFactory wbinformers.SharedInformerFactory, netAttachDefInformerFactory nadinformers.SharedInformerFactory, recorder record.EventRecorder, cleanupFunc garbageCollector) *PodController {
+	nodeInformer := k8sCoreInformerFactory.Core().V1().Nodes()
+	nodesInformer := nodeInformer.Informer()
+	nodeLister := nodeInformer.Lister()
+
+	pc := newPodController(k8sCoreClient, wbClient, k8sCoreInformerFactory, wbSharedInformerFactory, netAttachDefInformerFactory, nil, recorder, cleanupFunc)
+	arePodsSynched := pc.arePodsSynched
+	pc.arePodsSynched = func() bool {
+		return arePodsSynched() && nodesInformer.HasSynced()
+	}
+	pc.deletionFilter = func(pod *v1.Pod) bool {
+		return podOnDeadNode(nodeLister, pod)
+	}
+	return pc
+}
+
+func podOnDeadNode(nodeLister v1corelisters.NodeLister, pod *v1.Pod) bool {
+	nodeName := pod.Spec.NodeName
+	if nodeName == "" {
+		return false
+	}
+	_, err := nodeLister.Get(nodeName)
+	return k8serrors.IsNotFound(err)
+}
+
+// enqueuePodsOnDeadNodes garbage collects the addresses of the pods, left in the synced cache, bound to nodes which no
+// longer exist, as if they were deleted: their node may have been deleted while no control loop instance was leading.
+// The allocations of the pods already deleted by then are left to the IP reconciler.
+func enqueuePodsOnDeadNodes(pc *PodController) {
+	pods, err := pc.podLister.List(labels.Everything())
+	if err != nil {
+		_ = logging.Errorf("failed to list the pods bound to dead nodes: %v", err)
+		return
+	}
+	for _, pod := range pods {
+		pc.onPodDelete(pod)
+	}
+}
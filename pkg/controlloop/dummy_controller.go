@@ -5,7 +5,6 @@ package controlloop
 
 import (
 	"context"
-	"net"
 
 	kubeClient "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
 
@@ -17,10 +16,8 @@ import (
 	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
 	nadinformers "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions"
 
-	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
 	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
 	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
-	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
 )
 
 type dummyPodController struct {
@@ -54,21 +51,26 @@ func newDummyPodController(
 		netAttachDefInformerFactory,
 		nil,
 		recorder,
-		func(_ context.Context, _ int, ipamConfig types.IPAMConfig, client *kubeClient.KubernetesIPAM) ([]net.IPNet, error) {
-			ipPools := castToIPPool(wbInformerFactory.Whereabouts().V1alpha1().IPPools().Informer().GetStore().List())
-			for _, pool := range ipPools {
+		func(_ context.Context, _ *kubeClient.KubernetesIPAM, deallocations []kubeClient.PodDeallocation) ([]kubeClient.PodDeallocation, error) {
+			// the pools are read from the API, as the IPAM does: the informer cache lags behind the cleanups of a storm
+			// of deletions, and updating a stale copy would bring back the allocations cleaned up meanwhile
+			var released []kubeClient.PodDeallocation
+			for _, deallocation := range deallocations {
+				pool, err := wbClient.WhereaboutsV1alpha1().IPPools(deallocation.PoolNamespace).Get(context.TODO(), deallocation.PoolName, metav1.GetOptions{})
+				if err != nil {
+					return released, err
+				}
 				for index, allocation := range pool.Spec.Allocations {
-					if allocation.PodRef == ipamConfig.GetPodRef() {
+					if allocation.ContainerID == deallocation.ContainerID && allocation.IfName == deallocation.IfName {
 						delete(pool.Spec.Allocations, index)
-						_, err := wbClient.WhereaboutsV1alpha1().IPPools(ipPoolsNamespace()).Update(context.TODO(), &pool, metav1.UpdateOptions{})
-						if err != nil {
-							return []net.IPNet{}, err // no need to bother computing the allocated range
-						}
 					}
 				}
+				if _, err := wbClient.WhereaboutsV1alpha1().IPPools(deallocation.PoolNamespace).Update(context.TODO(), pool, metav1.UpdateOptions{}); err != nil {
+					return released, err
+				}
+				released = append(released, deallocation)
 			}
-
-			return []net.IPNet{}, nil
+			return released, nil
 		})
 
 	alwaysReady := func() bool { return true }
@@ -92,7 +94,7 @@ func newDummyPodController(
 	if err := controller.initControllerCaches(k8sClient, wbClient, nadClient); err != nil {
 		return nil, err
 	}
-	go podController.Start(stopChannel)
+	go podController.Start(stopChannel, DefaultWorkers)
 
 	return controller, nil
 }
@@ -153,15 +155,3 @@ func (dpc *dummyPodController) synchPods(k8sClient k8sclient.Interface) error {
 	}
 	return nil
 }
-
-func castToIPPool(pools []interface{}) []v1alpha1.IPPool {
-	var ipPools []v1alpha1.IPPool
-	for _, pool := range pools {
-		castPool, isPool := pool.(*v1alpha1.IPPool)
-		if !isPool {
-			continue
-		}
-		ipPools = append(ipPools, *castPool)
-	}
-	return ipPools
-}
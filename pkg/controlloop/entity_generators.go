@@ -31,6 +31,38 @@ func dummyNetSpec(networkName string, ipRange string) string {
     }`, networkName, ipRange)
 }
 
+func dummyNodeSliceNetSpec(networkName string, ipRange string, sliceSize string) string {
+	return fmt.Sprintf(`{
+      "cniVersion": "0.3.0",
+      "name": "%s",
+      "type": "macvlan",
+      "master": "eth0",
+      "mode": "bridge",
+      "ipam": {
+        "type": "whereabouts",
+        "range": "%s",
+        "node_slice_size": "%s",
+        "network_name": "%s"
+      }
+    }`, networkName, ipRange, sliceSize, networkName)
+}
+
+func nodeSlicePool(name string, namespace string, ipRange string, sliceSize string, nodeName string, sliceRange string) *v1alpha1.NodeSlicePool {
+	return &v1alpha1.NodeSlicePool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1alpha1.NodeSlicePoolSpec{
+			Range:     ipRange,
+			SliceSize: sliceSize,
+		},
+		Status: v1alpha1.NodeSlicePoolStatus{
+			Allocations: []v1alpha1.NodeSliceAllocation{{NodeName: nodeName, SliceRange: sliceRange}},
+		},
+	}
+}
+
 func dummyNonWhereaboutsIPAMNetSpec(networkName string) string {
 	return fmt.Sprintf(`{
       "cniVersion": "0.3.0",
@@ -0,0 +1,245 @@
+package controlloop
+
+import (
+	"context"
+	"net"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbclientset "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+	wblister "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/listers/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+const (
+	floatingIPsLeaseName = "whereabouts-floating-ips"
+
+	// FloatingIPClaimFinalizer holds the deletion of a FloatingIPClaim until its floating IP is released
+	FloatingIPClaimFinalizer = "whereabouts.cni.cncf.io/floating-ip"
+)
+
+// RunFloatingIPClaims competes with the other control loop instances for a cluster-wide lease. While holding it, it
+// binds each FloatingIPClaim to an IP of its pool, assigns the IP to the pod holding the claim, hands it off whenever
+// the holder changes, and releases it once the claim is deleted. It blocks until the context is cancelled.
+func RunFloatingIPClaims(ctx context.Context, identity string, k8sClient kubernetes.Interface, wbClient wbclientset.Interface) {
+	RunWhileLeading(ctx, floatingIPsLeaseName, identity, k8sClient, "assign the floating IPs of the claims", func(leaderCtx context.Context) {
+		wbInformerFactory := wbinformers.NewSharedInformerFactory(wbClient, noResyncPeriod)
+
+		claims := newFloatingIPClaims(wbclient.NewKubernetesClient(wbClient, k8sClient), wbClient, wbInformerFactory)
+
+		wbInformerFactory.Start(leaderCtx.Done())
+
+		claims.run(leaderCtx)
+	})
+}
+
+// floatingIPClaims keeps the IP pool allocation of each claim assigned to its holder. The allocations are told apart
+// by their container ID, see types.FloatingIPClaimContainerID; the allocation of a claim no pod holds is assigned
+// to the claim itself.
+type floatingIPClaims struct {
+	client      *wbclient.Client
+	wbClient    wbclientset.Interface
+	claimLister wblister.FloatingIPClaimLister
+	synced      []cache.InformerSynced
+	workqueue   workqueue.TypedRateLimitingInterface[string]
+}
+
+func newFloatingIPClaims(client *wbclient.Client, wbClient wbclientset.Interface, wbInformerFactory wbinformers.SharedInformerFactory) *floatingIPClaims {
+	claimInformer := wbInformerFactory.Whereabouts().V1alpha1().FloatingIPClaims()
+
+	c := &floatingIPClaims{
+		client:      client,
+		wbClient:    wbClient,
+		claimLister: claimInformer.Lister(),
+		synced:      []cache.InformerSynced{claimInformer.Informer().HasSynced},
+		workqueue: workqueue.NewTypedRateLimitingQueue[string](
+			workqueue.DefaultTypedControllerRateLimiter[string]()),
+	}
+
+	_, _ = claimInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(_, newObj interface{}) { c.enqueue(newObj) },
+	})
+	return c
+}
+
+func (c *floatingIPClaims) run(ctx context.Context) {
+	defer c.workqueue.ShutDown()
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.synced...); !ok {
+		logging.Verbosef("failed waiting for caches to sync")
+		return
+	}
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		for c.processNextWorkItem(ctx) {
+		}
+	}, 0)
+	<-ctx.Done()
+}
+
+func (c *floatingIPClaims) processNextWorkItem(ctx context.Context) bool {
+	key, shouldQuit := c.workqueue.Get()
+	if shouldQuit {
+		return false
+	}
+	defer c.workqueue.Done(key)
+
+	if err := c.sync(ctx, key); err != nil {
+		_ = logging.Errorf("failed to assign the floating IP of claim %s: %v", key, err)
+		c.workqueue.AddRateLimited(key)
+		return true
+	}
+	c.workqueue.Forget(key)
+	return true
+}
+
+// sync binds the claim to an IP of its pool, or hands the IP off to the current holder of the claim. The IP of a
+// claim being deleted is released.
+func (c *floatingIPClaims) sync(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	claim, err := c.claimLister.FloatingIPClaims(namespace).Get(name)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	claim = claim.DeepCopy()
+	claimID := types.FloatingIPClaimContainerID(namespace, name)
+
+	if claim.GetDeletionTimestamp() != nil {
+		if !hasFloatingIPFinalizer(claim) {
+			return nil
+		}
+		if err := c.client.ReleaseFloatingIP(ctx, claimPoolNamespace(claim), claim.Spec.Pool, claimID); err != nil {
+			return err
+		}
+		claim.SetFinalizers(withoutFloatingIPFinalizer(claim.GetFinalizers()))
+		return c.update(ctx, claim)
+	}
+
+	if !hasFloatingIPFinalizer(claim) {
+		// the claim is bound once the finalizer guarantees its IP is released
+		claim.SetFinalizers(append(claim.GetFinalizers(), FloatingIPClaimFinalizer))
+		return c.update(ctx, claim)
+	}
+
+	holderRef := ""
+	if claim.Spec.Holder != "" {
+		holderRef = podID(namespace, claim.Spec.Holder)
+	}
+
+	status := claim.Status
+	switch {
+	case status.IP == "":
+		var requestedIP net.IP
+		if claim.Spec.IP != "" {
+			requestedIP = net.ParseIP(claim.Spec.IP)
+		}
+		ip, err := c.client.ClaimFloatingIP(ctx, claimPoolNamespace(claim), claim.Spec.Pool, claimID, requestedIP,
+			assigneeRef(claim, holderRef), claim.Spec.IfName)
+		if err != nil {
+			return c.failed(ctx, claim, err)
+		}
+		status.IP = ip.String()
+		status.HolderRef = holderRef
+	case status.HolderRef != holderRef:
+		_, err := c.client.MoveIP(ctx, claim.Spec.Pool, wbclient.IPMove{
+			IP:              net.ParseIP(status.IP),
+			FromPodRef:      assigneeRef(claim, status.HolderRef),
+			FromContainerID: claimID,
+			ToPodRef:        assigneeRef(claim, holderRef),
+			ToContainerID:   claimID,
+			ToIfName:        claim.Spec.IfName,
+		})
+		if err != nil {
+			return c.failed(ctx, claim, err)
+		}
+		logging.Verbosef("handed floating IP %s of claim %s off from %q to %q", status.IP, key, status.HolderRef, holderRef)
+		status.HolderRef = holderRef
+	}
+	status.Message = ""
+
+	if status == claim.Status {
+		return nil
+	}
+	claim.Status = status
+	return c.update(ctx, claim)
+}
+
+// failed records the error in the status of the claim, and returns it for the claim to be synced anew
+func (c *floatingIPClaims) failed(ctx context.Context, claim *whereaboutsv1alpha1.FloatingIPClaim, err error) error {
+	if claim.Status.Message != err.Error() {
+		claim.Status.Message = err.Error()
+		if updateErr := c.update(ctx, claim); updateErr != nil {
+			logging.Debugf("failed to record the error of floating IP claim %s/%s: %v", claim.GetNamespace(), claim.GetName(), updateErr)
+		}
+	}
+	return err
+}
+
+func (c *floatingIPClaims) update(ctx context.Context, claim *whereaboutsv1alpha1.FloatingIPClaim) error {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	_, err := c.wbClient.WhereaboutsV1alpha1().FloatingIPClaims(claim.GetNamespace()).Update(ctxWithTimeout, claim, metav1.UpdateOptions{})
+	return err
+}
+
+func (c *floatingIPClaims) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		_ = logging.Errorf("failed to get the key of floating IP claim %+v: %v", obj, err)
+		return
+	}
+	c.workqueue.Add(key)
+}
+
+// claimPoolNamespace is the namespace of the IP pool of the claim: its pool namespace, or else that of the whereabouts
+// deployment
+func claimPoolNamespace(claim *whereaboutsv1alpha1.FloatingIPClaim) string {
+	if claim.Spec.PoolNamespace != "" {
+		return claim.Spec.PoolNamespace
+	}
+	return ipPoolsNamespace()
+}
+
+// assigneeRef is the pod reference the floating IP of the claim is allocated to: the holder, or the claim itself while
+// no pod holds it
+func assigneeRef(claim *whereaboutsv1alpha1.FloatingIPClaim, holderRef string) string {
+	if holderRef != "" {
+		return holderRef
+	}
+	return podID(claim.GetNamespace(), claim.GetName())
+}
+
+func hasFloatingIPFinalizer(claim *whereaboutsv1alpha1.FloatingIPClaim) bool {
+	for _, finalizer := range claim.GetFinalizers() {
+		if finalizer == FloatingIPClaimFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func withoutFloatingIPFinalizer(finalizers []string) []string {
+	var kept []string
+	for _, finalizer := range finalizers {
+		if finalizer != FloatingIPClaimFinalizer {
+			kept = append(kept, finalizer)
+		}
+	}
+	return kept
+}
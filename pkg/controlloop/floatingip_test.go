@@ -0,0 +1,131 @@
+package controlloop
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+var _ = Describe("Floating IP claims", func() {
+	const (
+		namespace = "default"
+		claimName = "db-vip"
+		poolName  = "10.0.0.0-24"
+	)
+
+	var (
+		wbClient wbclient.Interface
+		cancel   context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		pool := ipPool(kubernetes.PoolIdentifier{IpRange: "10.0.0.0/24"}, ipPoolsNamespace())
+		pool.ResourceVersion = "1"
+		pool.Spec.Allocations = map[string]v1alpha1.IPAllocation{
+			"1": {ContainerID: "container-other", PodRef: "default/other", IfName: "net1"},
+		}
+		claim := &v1alpha1.FloatingIPClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: claimName, Namespace: namespace, ResourceVersion: "1"},
+			Spec:       v1alpha1.FloatingIPClaimSpec{Pool: poolName, Holder: "db-0", IfName: "net1"},
+		}
+
+		k8sClient := fakek8sclient.NewSimpleClientset()
+		wbClient = fakewbclient.NewSimpleClientset(pool, claim)
+
+		wbInformerFactory := wbinformers.NewSharedInformerFactory(wbClient, noResyncPeriod)
+		claims := newFloatingIPClaims(kubernetes.NewKubernetesClient(wbClient, k8sClient), wbClient, wbInformerFactory)
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		wbInformerFactory.Start(ctx.Done())
+		go claims.run(ctx)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	getClaim := func() (*v1alpha1.FloatingIPClaim, error) {
+		return wbClient.WhereaboutsV1alpha1().FloatingIPClaims(namespace).Get(context.TODO(), claimName, metav1.GetOptions{})
+	}
+
+	claimStatus := func() (v1alpha1.FloatingIPClaimStatus, error) {
+		claim, err := getClaim()
+		if err != nil {
+			return v1alpha1.FloatingIPClaimStatus{}, err
+		}
+		return claim.Status, nil
+	}
+
+	poolAllocations := func() (map[string]v1alpha1.IPAllocation, error) {
+		pool, err := wbClient.WhereaboutsV1alpha1().IPPools(ipPoolsNamespace()).Get(context.TODO(), poolName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return pool.Spec.Allocations, nil
+	}
+
+	It("binds the claim to a free IP of the pool, assigned to the holder", func() {
+		Eventually(claimStatus).Should(Equal(v1alpha1.FloatingIPClaimStatus{IP: "10.0.0.2", HolderRef: "default/db-0"}))
+
+		allocations, err := poolAllocations()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(allocations).To(HaveLen(2))
+		Expect(allocations["2"].ContainerID).To(Equal(types.FloatingIPClaimContainerID(namespace, claimName)))
+		Expect(allocations["2"].PodRef).To(Equal("default/db-0"))
+		Expect(allocations["2"].IfName).To(Equal("net1"))
+
+		claim, err := getClaim()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claim.Finalizers).To(ConsistOf(FloatingIPClaimFinalizer))
+	})
+
+	It("hands the IP off to the new holder", func() {
+		Eventually(claimStatus).Should(HaveField("HolderRef", "default/db-0"))
+
+		claim, err := getClaim()
+		Expect(err).NotTo(HaveOccurred())
+		claim.Spec.Holder = "db-1"
+		_, err = wbClient.WhereaboutsV1alpha1().FloatingIPClaims(namespace).Update(context.TODO(), claim, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(claimStatus).Should(Equal(v1alpha1.FloatingIPClaimStatus{IP: "10.0.0.2", HolderRef: "default/db-1"}))
+		allocations, err := poolAllocations()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(allocations["2"].PodRef).To(Equal("default/db-1"))
+		Expect(allocations["2"].ContainerID).To(Equal(types.FloatingIPClaimContainerID(namespace, claimName)))
+	})
+
+	It("releases the IP of the claim being deleted", func() {
+		Eventually(claimStatus).Should(HaveField("IP", "10.0.0.2"))
+
+		claim, err := getClaim()
+		Expect(err).NotTo(HaveOccurred())
+		now := metav1.Now()
+		claim.DeletionTimestamp = &now
+		_, err = wbClient.WhereaboutsV1alpha1().FloatingIPClaims(namespace).Update(context.TODO(), claim, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(poolAllocations).Should(HaveLen(1))
+		Eventually(func() []string {
+			claim, err := getClaim()
+			if k8serrors.IsNotFound(err) {
+				return nil
+			}
+			Expect(err).NotTo(HaveOccurred())
+			return claim.Finalizers
+		}).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,100 @@
+package controlloop
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// The paths the liveness and readiness probes of the pod controller are served on
+const (
+	HealthzPath = "/healthz"
+	ReadyzPath  = "/readyz"
+)
+
+// DefaultStallTimeout is how long the pod deletion queue may hold items without any of them being processed before
+// the pod controller is deemed wedged. It outlasts the cleanup of a pod with several allocations, each bounded by the
+// DEL time limit.
+const DefaultStallTimeout = 5 * time.Minute
+
+// queueProgress tracks the processing of the pod deletion queue by the workers
+type queueProgress struct {
+	// lastProgress is the time, in Unix nanoseconds, a worker last picked or completed an item
+	lastProgress atomic.Int64
+	inFlight     atomic.Int32
+}
+
+// wake starts the stall clock as an idle queue gets an item
+func (p *queueProgress) wake() {
+	if p.inFlight.Load() == 0 {
+		p.lastProgress.Store(time.Now().UnixNano())
+	}
+}
+
+func (p *queueProgress) start() {
+	p.inFlight.Add(1)
+	p.lastProgress.Store(time.Now().UnixNano())
+}
+
+func (p *queueProgress) done() {
+	p.inFlight.Add(-1)
+	p.lastProgress.Store(time.Now().UnixNano())
+}
+
+// Ready reports whether the pod controller serves pod deletions: the pods, IP pools and
+// network-attachment-definitions informers have synced their caches.
+func (pc *PodController) Ready() error {
+	informers := []struct {
+		name      string
+		hasSynced func() bool
+	}{
+		{name: "pods", hasSynced: pc.arePodsSynched},
+		{name: "IP pools", hasSynced: pc.areIPPoolsSynched},
+		{name: "network-attachment-definitions", hasSynced: pc.areNetAttachDefsSynched},
+	}
+	for _, informer := range informers {
+		if informer.hasSynced == nil || !informer.hasSynced() {
+			return fmt.Errorf("the %s informer has not synced", informer.name)
+		}
+	}
+	return nil
+}
+
+// Healthy reports whether the pod controller makes progress: its queue is not shut down, and pending or in flight items
+// were picked or completed by a worker within the stall timeout. An idle queue is healthy.
+func (pc *PodController) Healthy() error {
+	if pc.workqueue.ShuttingDown() {
+		return fmt.Errorf("the pod deletion queue is shut down")
+	}
+	if pc.workqueue.Len() == 0 && pc.progress.inFlight.Load() == 0 {
+		return nil
+	}
+	stallTimeout := pc.stallTimeout
+	if stallTimeout <= 0 {
+		stallTimeout = DefaultStallTimeout
+	}
+	lastProgress := time.Unix(0, pc.progress.lastProgress.Load())
+	if stalled := time.Since(lastProgress); stalled > stallTimeout {
+		return fmt.Errorf("the pod deletion queue made no progress for %s, with %d pending and %d in flight items",
+			stalled.Round(time.Second), pc.workqueue.Len(), pc.progress.inFlight.Load())
+	}
+	return nil
+}
+
+// SetStallTimeout sets how long the queue may hold items without progress before the controller is deemed unhealthy
+func (pc *PodController) SetStallTimeout(timeout time.Duration) {
+	pc.stallTimeout = timeout
+}
+
+// HealthHandler answers the probes with the result of the check: OK, or Service Unavailable along with its failure
+func HealthHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}
+}
@@ -0,0 +1,75 @@
+package controlloop
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+var _ = Describe("PodController health", func() {
+	var pc *PodController
+
+	synced := func() bool { return true }
+	notSynced := func() bool { return false }
+
+	BeforeEach(func() {
+		pc = &PodController{
+			workqueue:               workqueue.NewTypedRateLimitingQueue[string](workqueue.DefaultTypedControllerRateLimiter[string]()),
+			pendingPods:             map[string]*deletedPod{},
+			arePodsSynched:          synced,
+			areIPPoolsSynched:       synced,
+			areNetAttachDefsSynched: synced,
+		}
+	})
+
+	AfterEach(func() {
+		pc.Shutdown()
+	})
+
+	It("is ready once the informers synced", func() {
+		Expect(pc.Ready()).To(Succeed())
+	})
+
+	It("is not ready while an informer syncs", func() {
+		pc.areIPPoolsSynched = notSynced
+		Expect(pc.Ready()).To(MatchError("the IP pools informer has not synced"))
+	})
+
+	It("is healthy while idle", func() {
+		Expect(pc.Healthy()).To(Succeed())
+	})
+
+	It("is healthy while the queued items are processed in time", func() {
+		pc.onPodDelete(podSpec("tiny-winy-pod", "default", "node1"))
+		Expect(pc.Healthy()).To(Succeed())
+	})
+
+	It("is unhealthy once the queue stalls", func() {
+		pc.SetStallTimeout(time.Millisecond)
+		pc.onPodDelete(podSpec("tiny-winy-pod", "default", "node1"))
+
+		Eventually(pc.Healthy).Should(MatchError(ContainSubstring("made no progress")))
+	})
+
+	It("is unhealthy once the queue is shut down", func() {
+		pc.Shutdown()
+		Expect(pc.Healthy()).To(MatchError("the pod deletion queue is shut down"))
+	})
+
+	It("answers the probes with the result of the check", func() {
+		recorder := httptest.NewRecorder()
+		HealthHandler(func() error { return nil })(recorder, httptest.NewRequest(http.MethodGet, HealthzPath, nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		recorder = httptest.NewRecorder()
+		HealthHandler(func() error { return fmt.Errorf("wedged") })(recorder, httptest.NewRequest(http.MethodGet, ReadyzPath, nil))
+		Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(recorder.Body.String()).To(ContainSubstring("wedged"))
+	})
+})
@@ -0,0 +1,310 @@
+package controlloop
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
+	nadinformers "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions"
+	nadlister "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/listers/k8s.cni.cncf.io/v1"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbclientset "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+	wblister "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/listers/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+const (
+	ipRegistryLeaseName = "whereabouts-ip-registry"
+
+	// IPRegistryAnnotation, set to "true" on a network-attachment-definition, has the elected control loop instance
+	// render the IP addresses allocated on the network into a ConfigMap of the same namespace
+	IPRegistryAnnotation = "whereabouts.cni.cncf.io/ip-registry"
+
+	// IPRegistrySuffix is appended to the name of the network-attachment-definition to name its ConfigMap
+	IPRegistrySuffix = "-whereabouts-ips"
+
+	// IPRegistryIPsKey holds the allocated IP addresses as host CIDRs, one per line, e.g. the nets of a Calico
+	// GlobalNetworkSet
+	IPRegistryIPsKey = "ips"
+
+	// IPRegistryAllocationsKey holds the allocations as a JSON list of IPRegistryEntry
+	IPRegistryAllocationsKey = "allocations"
+
+	netAttachDefKind = "NetworkAttachmentDefinition"
+)
+
+// IPRegistryEntry is an IP address allocated on a network, and the pod interface it is allocated to
+type IPRegistryEntry struct {
+	IP     string `json:"ip"`
+	PodRef string `json:"podRef"`
+	IfName string `json:"ifName,omitempty"`
+}
+
+// RunIPRegistry competes with the other control loop instances for a cluster-wide lease. While holding it, it keeps
+// a ConfigMap listing the IP addresses allocated on each network-attachment-definition annotated with
+// IPRegistryAnnotation up to date, for policy engines to firewall the secondary interfaces of the pods. It blocks
+// until the context is cancelled.
+func RunIPRegistry(ctx context.Context, identity string, k8sClient kubernetes.Interface, wbClient wbclientset.Interface, nadClient nadclient.Interface) {
+	RunWhileLeading(ctx, ipRegistryLeaseName, identity, k8sClient, "render the IP registries of the network-attachment-definitions", func(leaderCtx context.Context) {
+		wbInformerFactory := wbinformers.NewSharedInformerFactory(wbClient, noResyncPeriod)
+		nadInformerFactory := nadinformers.NewSharedInformerFactory(nadClient, noResyncPeriod)
+
+		registry := newIPRegistry(k8sClient, wbInformerFactory, nadInformerFactory)
+
+		wbInformerFactory.Start(leaderCtx.Done())
+		nadInformerFactory.Start(leaderCtx.Done())
+
+		registry.run(leaderCtx)
+	})
+}
+
+// ipRegistry renders the ConfigMap of an annotated network-attachment-definition on its changes, and on the changes
+// of any IP pool. The ConfigMap is owned by the network-attachment-definition, hence deleted along with it.
+type ipRegistry struct {
+	k8sClient          kubernetes.Interface
+	ipPoolLister       wblister.IPPoolLister
+	netAttachDefLister nadlister.NetworkAttachmentDefinitionLister
+	synced             []cache.InformerSynced
+	workqueue          workqueue.TypedRateLimitingInterface[string]
+}
+
+func newIPRegistry(k8sClient kubernetes.Interface, wbInformerFactory wbinformers.SharedInformerFactory, nadInformerFactory nadinformers.SharedInformerFactory) *ipRegistry {
+	ipPoolInformer := wbInformerFactory.Whereabouts().V1alpha1().IPPools()
+	netAttachDefInformer := nadInformerFactory.K8sCniCncfIo().V1().NetworkAttachmentDefinitions()
+
+	r := &ipRegistry{
+		k8sClient:          k8sClient,
+		ipPoolLister:       ipPoolInformer.Lister(),
+		netAttachDefLister: netAttachDefInformer.Lister(),
+		synced: []cache.InformerSynced{
+			ipPoolInformer.Informer().HasSynced,
+			netAttachDefInformer.Informer().HasSynced,
+		},
+		workqueue: workqueue.NewTypedRateLimitingQueue[string](
+			workqueue.DefaultTypedControllerRateLimiter[string]()),
+	}
+
+	_, _ = netAttachDefInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if netAttachDef, ok := obj.(*nadv1.NetworkAttachmentDefinition); ok && ipRegistryEnabled(netAttachDef) {
+				r.enqueue(netAttachDef)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNetAttachDef, _ := oldObj.(*nadv1.NetworkAttachmentDefinition)
+			netAttachDef, ok := newObj.(*nadv1.NetworkAttachmentDefinition)
+			// the ConfigMap of a network-attachment-definition no longer annotated is deleted
+			if ok && (ipRegistryEnabled(netAttachDef) || (oldNetAttachDef != nil && ipRegistryEnabled(oldNetAttachDef))) {
+				r.enqueue(netAttachDef)
+			}
+		},
+	})
+	_, _ = ipPoolInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(_ interface{}) { r.enqueueRegistries() },
+		UpdateFunc: func(_, _ interface{}) { r.enqueueRegistries() },
+		DeleteFunc: func(_ interface{}) { r.enqueueRegistries() },
+	})
+	return r
+}
+
+func (r *ipRegistry) run(ctx context.Context) {
+	defer r.workqueue.ShutDown()
+	if ok := cache.WaitForCacheSync(ctx.Done(), r.synced...); !ok {
+		logging.Verbosef("failed waiting for caches to sync")
+		return
+	}
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		for r.processNextWorkItem(ctx) {
+		}
+	}, 0)
+	<-ctx.Done()
+}
+
+func (r *ipRegistry) processNextWorkItem(ctx context.Context) bool {
+	key, shouldQuit := r.workqueue.Get()
+	if shouldQuit {
+		return false
+	}
+	defer r.workqueue.Done(key)
+
+	if err := r.sync(ctx, key); err != nil {
+		_ = logging.Errorf("failed to render the IP registry of network-attachment-definition %s: %v", key, err)
+		r.workqueue.AddRateLimited(key)
+		return true
+	}
+	r.workqueue.Forget(key)
+	return true
+}
+
+// sync renders the ConfigMap of the network-attachment-definition, or deletes it once the annotation is removed
+func (r *ipRegistry) sync(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	netAttachDef, err := r.netAttachDefLister.NetworkAttachmentDefinitions(namespace).Get(name)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	if !ipRegistryEnabled(netAttachDef) {
+		err := r.k8sClient.CoreV1().ConfigMaps(namespace).Delete(ctxWithTimeout, name+IPRegistrySuffix, metav1.DeleteOptions{})
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	entries, err := r.allocations(netAttachDef)
+	if err != nil {
+		return err
+	}
+	configMap, err := ipRegistryConfigMap(netAttachDef, entries)
+	if err != nil {
+		return err
+	}
+
+	current, err := r.k8sClient.CoreV1().ConfigMaps(namespace).Get(ctxWithTimeout, configMap.GetName(), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = r.k8sClient.CoreV1().ConfigMaps(namespace).Create(ctxWithTimeout, configMap, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(current.Data, configMap.Data) {
+		return nil
+	}
+	current = current.DeepCopy()
+	current.Data = configMap.Data
+	_, err = r.k8sClient.CoreV1().ConfigMaps(namespace).Update(ctxWithTimeout, current, metav1.UpdateOptions{})
+	return err
+}
+
+// allocations lists the allocations of the IP pools of the network-attachment-definition's ranges, or of their node
+// slices, sorted by IP address. Unnamed networks sharing a range share its pool, hence its allocations.
+func (r *ipRegistry) allocations(netAttachDef *nadv1.NetworkAttachmentDefinition) ([]IPRegistryEntry, error) {
+	networks := wbclient.NewWhereaboutsNetworks([]nadv1.NetworkAttachmentDefinition{*netAttachDef})
+	pools, err := r.ipPoolLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []IPRegistryEntry
+	for _, pool := range pools {
+		if networks.PoolNetwork(pool.GetName(), pool.Spec.Range) == wbclient.UnknownNetwork {
+			continue
+		}
+		firstIP, _, err := pool.ParseCIDR()
+		if err != nil {
+			logging.Debugf("skipping the allocations of IP pool %s: %v", pool.GetName(), err)
+			continue
+		}
+		for offset, allocation := range pool.Spec.Allocations {
+			ip, ok := allocationIP(pool, firstIP, offset)
+			if !ok {
+				continue
+			}
+			entries = append(entries, IPRegistryEntry{IP: ip.String(), PodRef: allocation.PodRef, IfName: allocation.IfName})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(entries[i].IP).To16(), net.ParseIP(entries[j].IP).To16()) < 0
+	})
+	return entries, nil
+}
+
+func allocationIP(pool *whereaboutsv1alpha1.IPPool, firstIP net.IP, offset string) (net.IP, bool) {
+	numOffset, err := strconv.ParseUint(offset, 10, 64)
+	if err != nil {
+		logging.Debugf("skipping the allocation of invalid offset %q of IP pool %s", offset, pool.GetName())
+		return nil, false
+	}
+	return iphelpers.IPAddOffset(firstIP, numOffset), true
+}
+
+// ipRegistryConfigMap renders the ConfigMap of the network-attachment-definition, owned by it
+func ipRegistryConfigMap(netAttachDef *nadv1.NetworkAttachmentDefinition, entries []IPRegistryEntry) (*v1.ConfigMap, error) {
+	hostCIDRs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ip := net.ParseIP(entry.IP)
+		bits := net.IPv6len * 8
+		if ip.To4() != nil {
+			bits = net.IPv4len * 8
+		}
+		hostCIDRs = append(hostCIDRs, (&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}).String())
+	}
+	if entries == nil {
+		entries = []IPRegistryEntry{}
+	}
+	allocations, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	// blocking the deletion of the network-attachment-definition would take the permission to update it
+	ownerRef := metav1.NewControllerRef(netAttachDef, nadv1.SchemeGroupVersion.WithKind(netAttachDefKind))
+	ownerRef.BlockOwnerDeletion = nil
+
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            netAttachDef.GetName() + IPRegistrySuffix,
+			Namespace:       netAttachDef.GetNamespace(),
+			OwnerReferences: []metav1.OwnerReference{*ownerRef},
+		},
+		Data: map[string]string{
+			IPRegistryIPsKey:         strings.Join(hostCIDRs, "\n"),
+			IPRegistryAllocationsKey: string(allocations),
+		},
+	}, nil
+}
+
+func (r *ipRegistry) enqueue(netAttachDef *nadv1.NetworkAttachmentDefinition) {
+	r.workqueue.Add(podID(netAttachDef.GetNamespace(), netAttachDef.GetName()))
+}
+
+// enqueueRegistries has all the annotated network-attachment-definitions render their ConfigMap anew
+func (r *ipRegistry) enqueueRegistries() {
+	netAttachDefs, err := r.netAttachDefLister.List(labels.Everything())
+	if err != nil {
+		_ = logging.Errorf("failed to list the network-attachment-definitions: %v", err)
+		return
+	}
+	for _, netAttachDef := range netAttachDefs {
+		if ipRegistryEnabled(netAttachDef) {
+			r.enqueue(netAttachDef)
+		}
+	}
+}
+
+func ipRegistryEnabled(netAttachDef *nadv1.NetworkAttachmentDefinition) bool {
+	return netAttachDef.GetAnnotations()[IPRegistryAnnotation] == "true"
+}
@@ -0,0 +1,126 @@
+package controlloop
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "k8s.io/client-go/kubernetes"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+
+	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
+	nadinformers "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+var _ = Describe("IP registries", func() {
+	const (
+		namespace   = "default"
+		networkName = "secure-net"
+	)
+
+	var (
+		k8sClient k8sclient.Interface
+		wbClient  wbclient.Interface
+		nadClient nadclient.Interface
+		cancel    context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		registered := netAttachDef(networkName, namespace, dummyNetSpec(networkName, "10.0.0.0/24"))
+		registered.Annotations = map[string]string{IPRegistryAnnotation: "true"}
+		unregistered := netAttachDef("other-net", namespace, dummyNetSpec("other-net", "10.0.1.0/24"))
+
+		pool := ipPool(kubernetes.PoolIdentifier{IpRange: "10.0.0.0/24"}, ipPoolsNamespace())
+		pool.Spec.Allocations = map[string]v1alpha1.IPAllocation{
+			"12": {PodRef: "default/pod-b", IfName: "net1"},
+			"3":  {PodRef: "default/pod-a", IfName: "net1"},
+		}
+		otherPool := ipPool(kubernetes.PoolIdentifier{IpRange: "10.0.1.0/24"}, ipPoolsNamespace(), "default/pod-c")
+
+		k8sClient = fakek8sclient.NewSimpleClientset()
+		wbClient = fakewbclient.NewSimpleClientset(pool, otherPool)
+		var err error
+		nadClient, err = newFakeNetAttachDefClient(namespace, registered, unregistered)
+		Expect(err).NotTo(HaveOccurred())
+
+		wbInformerFactory := wbinformers.NewSharedInformerFactory(wbClient, noResyncPeriod)
+		nadInformerFactory := nadinformers.NewSharedInformerFactory(nadClient, noResyncPeriod)
+		registry := newIPRegistry(k8sClient, wbInformerFactory, nadInformerFactory)
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		wbInformerFactory.Start(ctx.Done())
+		nadInformerFactory.Start(ctx.Done())
+		go registry.run(ctx)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	configMapData := func(name string) func() (map[string]string, error) {
+		return func() (map[string]string, error) {
+			configMap, err := k8sClient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name+IPRegistrySuffix, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			return configMap.Data, nil
+		}
+	}
+
+	It("renders the allocations of the annotated network-attachment-definitions", func() {
+		Eventually(configMapData(networkName)).Should(Equal(map[string]string{
+			IPRegistryIPsKey: "10.0.0.3/32\n10.0.0.12/32",
+			IPRegistryAllocationsKey: `[{"ip":"10.0.0.3","podRef":"default/pod-a","ifName":"net1"},` +
+				`{"ip":"10.0.0.12","podRef":"default/pod-b","ifName":"net1"}]`,
+		}))
+
+		configMap, err := k8sClient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), networkName+IPRegistrySuffix, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configMap.OwnerReferences).To(HaveLen(1))
+		Expect(configMap.OwnerReferences[0].Kind).To(Equal("NetworkAttachmentDefinition"))
+		Expect(configMap.OwnerReferences[0].Name).To(Equal(networkName))
+
+		Consistently(func() bool {
+			_, err := configMapData("other-net")()
+			return k8serrors.IsNotFound(err)
+		}, 500*time.Millisecond).Should(BeTrue())
+	})
+
+	It("renders the allocations anew on the changes of the IP pools", func() {
+		Eventually(configMapData(networkName)).Should(HaveKeyWithValue(IPRegistryIPsKey, "10.0.0.3/32\n10.0.0.12/32"))
+
+		pool, err := wbClient.WhereaboutsV1alpha1().IPPools(ipPoolsNamespace()).Get(context.TODO(), "10.0.0.0-24", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		delete(pool.Spec.Allocations, "3")
+		_, err = wbClient.WhereaboutsV1alpha1().IPPools(ipPoolsNamespace()).Update(context.TODO(), pool, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(configMapData(networkName)).Should(HaveKeyWithValue(IPRegistryIPsKey, "10.0.0.12/32"))
+	})
+
+	It("deletes the ConfigMap once the annotation is removed", func() {
+		Eventually(configMapData(networkName)).Should(HaveKey(IPRegistryIPsKey))
+
+		registered, err := nadClient.K8sCniCncfIoV1().NetworkAttachmentDefinitions(namespace).Get(context.TODO(), networkName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		registered.Annotations = nil
+		_, err = nadClient.K8sCniCncfIoV1().NetworkAttachmentDefinitions(namespace).Update(context.TODO(), registered, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() bool {
+			_, err := k8sClient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), networkName+IPRegistrySuffix, metav1.GetOptions{})
+			return k8serrors.IsNotFound(err)
+		}).Should(BeTrue())
+	})
+})
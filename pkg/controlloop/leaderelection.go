@@ -0,0 +1,58 @@
+package controlloop
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// RunWhileLeading competes with the other control loop instances for the cluster-wide lease, running the task - which
+// returns once its context is cancelled - while holding it. It blocks until the context is cancelled.
+func RunWhileLeading(ctx context.Context, leaseName, identity string, k8sClient kubernetes.Interface, task string, run func(ctx context.Context)) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaseName,
+			Namespace: ipPoolsNamespace(),
+		},
+		Client: k8sClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	for ctx.Err() == nil {
+		le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   renewDeadline,
+			RetryPeriod:     retryPeriod,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(leaderCtx context.Context) {
+					logging.Verbosef("elected to %s", task)
+					run(leaderCtx)
+				},
+				OnStoppedLeading: func() {
+					logging.Verbosef("no longer elected to %s", task)
+				},
+			},
+		})
+		if err != nil {
+			_ = logging.Errorf("failed to create the %s leader elector: %v", leaseName, err)
+			return
+		}
+		le.Run(ctx)
+	}
+}
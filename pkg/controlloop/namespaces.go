@@ -0,0 +1,185 @@
+package controlloop
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	v1coreinformerfactory "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	v1corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
+
+	wbclientset "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+const (
+	deletedNamespaceCleanupLeaseName = "whereabouts-deleted-namespace-cleanup"
+	namespaceCleanupRetryPeriod      = 30 * time.Second
+)
+
+// RunDeletedNamespaceCleanup competes with the other control loop instances for a cluster-wide lease. While holding
+// it, it releases the IP addresses of the pods of deleted namespaces, right away on the deletion of a namespace and
+// once on start: the delete events of their pods never reach the control loops which were down at the time. It blocks
+// until the context is cancelled.
+func RunDeletedNamespaceCleanup(ctx context.Context, identity string, k8sClient kubernetes.Interface, wbClient wbclientset.Interface, nadClient nadclient.Interface) {
+	RunWhileLeading(ctx, deletedNamespaceCleanupLeaseName, identity, k8sClient, "clean up the IP addresses of pods of deleted namespaces", func(leaderCtx context.Context) {
+		k8sCoreInformerFactory := v1coreinformerfactory.NewSharedInformerFactory(k8sClient, noResyncPeriod)
+		cleanup := newNamespaceCleanup(k8sCoreInformerFactory, wbclient.NewKubernetesClientWithNetAttachDefs(wbClient, k8sClient, nadClient))
+		k8sCoreInformerFactory.Start(leaderCtx.Done())
+		cleanup.run(leaderCtx)
+	})
+}
+
+// namespaceCleanup sweeps the IP pools for the allocations of pods of deleted namespaces. The sweeps triggered while
+// one is running are coalesced into a single one.
+type namespaceCleanup struct {
+	client              *wbclient.Client
+	namespaceLister     v1corelisters.NamespaceLister
+	areNamespacesSynced cache.InformerSynced
+	sweeps              chan struct{}
+}
+
+func newNamespaceCleanup(k8sCoreInformerFactory v1coreinformerfactory.SharedInformerFactory, client *wbclient.Client) *namespaceCleanup {
+	namespaceInformer := k8sCoreInformerFactory.Core().V1().Namespaces()
+	nc := &namespaceCleanup{
+		client:              client,
+		namespaceLister:     namespaceInformer.Lister(),
+		areNamespacesSynced: namespaceInformer.Informer().HasSynced,
+		sweeps:              make(chan struct{}, 1),
+	}
+	_, _ = namespaceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(_ interface{}) {
+			nc.triggerSweep()
+		},
+	})
+	return nc
+}
+
+func (nc *namespaceCleanup) triggerSweep() {
+	select {
+	case nc.sweeps <- struct{}{}:
+	default:
+		// a sweep is already pending
+	}
+}
+
+func (nc *namespaceCleanup) run(ctx context.Context) {
+	// never sweep without knowing which namespaces exist
+	if !cache.WaitForCacheSync(ctx.Done(), nc.areNamespacesSynced) {
+		return
+	}
+
+	nc.triggerSweep()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-nc.sweeps:
+			releasedIPs, err := nc.sweep(ctx)
+			if len(releasedIPs) > 0 {
+				logging.Verbosef("released the addresses of pods of deleted namespaces: %v", releasedIPs)
+			}
+			if err != nil {
+				_ = logging.Errorf("failed to release the addresses of pods of deleted namespaces: %v", err)
+				time.AfterFunc(namespaceCleanupRetryPeriod, nc.triggerSweep)
+			}
+		}
+	}
+}
+
+func (nc *namespaceCleanup) sweep(ctx context.Context) ([]string, error) {
+	netAttachDefs, err := nc.client.ListNetAttachDefs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the network-attachment-definitions: %w", err)
+	}
+	unreconciledNetworks := wbclient.NewUnreconciledNetworks(netAttachDefs)
+
+	pools, err := nc.client.ListIPPools(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the IP pools: %w", err)
+	}
+
+	var released []wbclient.ReleasedAllocations
+	var releasedIPs []string
+	var updateErr error
+	// whether the namespaces of the allocations are deleted, as confirmed by the API server
+	deletedNamespaces := map[string]bool{}
+	for _, pool := range pools {
+		if k8sPool, ok := pool.(*wbclient.KubernetesIPPool); ok && unreconciledNetworks.ContainsPool(k8sPool.Name(), k8sPool.Range()) {
+			continue
+		}
+
+		var remaining []types.IPReservation
+		var poolReleased []types.IPReservation
+		var poolReleasedIPs []string
+		for _, allocation := range pool.Allocations() {
+			deleted, err := nc.namespaceDeleted(ctx, allocation.PodRef, deletedNamespaces)
+			if err != nil {
+				updateErr = err
+			}
+			if !deleted {
+				remaining = append(remaining, allocation)
+				continue
+			}
+			logging.Debugf("releasing IP %s of pod %s: its namespace was deleted", allocation.IP, allocation.PodRef)
+			poolReleased = append(poolReleased, allocation)
+			poolReleasedIPs = append(poolReleasedIPs, allocation.IP.String())
+		}
+		if len(poolReleasedIPs) == 0 {
+			continue
+		}
+
+		requestCtx, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+		err := pool.Update(requestCtx, remaining)
+		cancel()
+		if err != nil {
+			updateErr = fmt.Errorf("failed to update the reservation list: %w", err)
+			continue
+		}
+		released = append(released, wbclient.ReleasedAllocations{Pool: pool, Allocations: poolReleased})
+		for range poolReleasedIPs {
+			metrics.GarbageCollectedIPs.Inc()
+		}
+		releasedIPs = append(releasedIPs, poolReleasedIPs...)
+	}
+
+	if len(released) > 0 {
+		if err := nc.client.ReleaseOverlappingIPs(ctx, released); err != nil {
+			return releasedIPs, err
+		}
+	}
+	return releasedIPs, updateErr
+}
+
+// namespaceDeleted tells whether the namespace of the pod reference no longer exists. The namespaces missing from the
+// informer cache - which may lag behind, e.g. on a namespace created moments ago - are confirmed deleted by the API
+// server, once per sweep: the answers are kept in deletedNamespaces.
+func (nc *namespaceCleanup) namespaceDeleted(ctx context.Context, podRef string, deletedNamespaces map[string]bool) (bool, error) {
+	namespace, _, found := strings.Cut(podRef, "/")
+	if !found || namespace == "" {
+		return false, nil
+	}
+	if _, err := nc.namespaceLister.Get(namespace); !k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	if deleted, found := deletedNamespaces[namespace]; found {
+		return deleted, nil
+	}
+
+	_, err := nc.client.GetNamespace(ctx, namespace)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to get namespace %s: %w", namespace, err)
+	}
+	deletedNamespaces[namespace] = err != nil
+	return err != nil, nil
+}
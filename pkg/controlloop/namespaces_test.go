@@ -0,0 +1,108 @@
+package controlloop
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1coreinformerfactory "k8s.io/client-go/informers"
+	k8sclient "k8s.io/client-go/kubernetes"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+
+	fakenadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+var _ = Describe("Deleted namespaces cleanup", func() {
+	var (
+		k8sClient k8sclient.Interface
+		wbClient  wbclient.Interface
+		cancel    context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		k8sClient = fakek8sclient.NewSimpleClientset(
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-a"}},
+		)
+		wbClient = fakewbclient.NewSimpleClientset(
+			&v1alpha1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: ipPoolsNamespace(), ResourceVersion: "1"},
+				Spec: v1alpha1.IPPoolSpec{
+					Range: "10.0.0.0/24",
+					Allocations: map[string]v1alpha1.IPAllocation{
+						"1": {ContainerID: "container-a", PodRef: "default/pod-a", IfName: "net1"},
+						"2": {ContainerID: "container-b", PodRef: "tenant-a/pod-b", IfName: "net1"},
+						"3": {ContainerID: "container-c", PodRef: "tenant-b/pod-c", IfName: "net1"},
+					},
+				},
+			},
+			&v1alpha1.OverlappingRangeIPReservation{
+				ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.2", Namespace: ipPoolsNamespace()},
+				Spec:       v1alpha1.OverlappingRangeIPReservationSpec{ContainerID: "container-b", PodRef: "tenant-a/pod-b", IfName: "net1"},
+			},
+		)
+
+		k8sInformerFactory := v1coreinformerfactory.NewSharedInformerFactory(k8sClient, noResyncPeriod)
+		cleanup := newNamespaceCleanup(k8sInformerFactory,
+			kubernetes.NewKubernetesClientWithNetAttachDefs(wbClient, k8sClient, fakenadclient.NewSimpleClientset()))
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		k8sInformerFactory.Start(ctx.Done())
+		go cleanup.run(ctx)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("releases the allocations of the namespaces deleted while the control loop was down", func() {
+		Eventually(func() []string { return poolAllocations(wbClient) }).Should(ConsistOf("1", "2"))
+	})
+
+	It("releases the allocations of a namespace once deleted", func() {
+		Eventually(func() []string { return poolAllocations(wbClient) }).Should(ConsistOf("1", "2"))
+
+		Expect(k8sClient.CoreV1().Namespaces().Delete(context.TODO(), "tenant-a", metav1.DeleteOptions{})).To(Succeed())
+		Eventually(func() []string { return poolAllocations(wbClient) }).Should(ConsistOf("1"))
+		Eventually(func() error {
+			_, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(ipPoolsNamespace()).Get(context.TODO(), "10.0.0.2", metav1.GetOptions{})
+			return err
+		}).Should(HaveOccurred())
+	})
+
+	It("keeps the allocations of the namespaces missing from the cache but not deleted", func() {
+		// the namespace informer lags behind the API server, which knows tenant-b
+		staleInformerFactory := v1coreinformerfactory.NewSharedInformerFactory(fakek8sclient.NewSimpleClientset(), noResyncPeriod)
+		liveClient := fakek8sclient.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-b"}})
+		staleWbClient := fakewbclient.NewSimpleClientset(&v1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: ipPoolsNamespace(), ResourceVersion: "1"},
+			Spec: v1alpha1.IPPoolSpec{
+				Range: "10.0.0.0/24",
+				Allocations: map[string]v1alpha1.IPAllocation{
+					"3": {ContainerID: "container-c", PodRef: "tenant-b/pod-c", IfName: "net1"},
+					"4": {ContainerID: "container-d", PodRef: "tenant-c/pod-d", IfName: "net1"},
+				},
+			},
+		})
+		cleanup := newNamespaceCleanup(staleInformerFactory,
+			kubernetes.NewKubernetesClientWithNetAttachDefs(staleWbClient, liveClient, fakenadclient.NewSimpleClientset()))
+		ctx, stop := context.WithCancel(context.Background())
+		defer stop()
+		staleInformerFactory.Start(ctx.Done())
+		staleInformerFactory.WaitForCacheSync(ctx.Done())
+
+		releasedIPs, err := cleanup.sweep(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(releasedIPs).To(ConsistOf("10.0.0.4"))
+		Expect(poolAllocations(staleWbClient)).To(ConsistOf("3"))
+	})
+})
@@ -8,13 +8,16 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"k8s.io/client-go/kubernetes"
 
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	k8stypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	v1coreinformerfactory "k8s.io/client-go/informers"
 	v1corelisters "k8s.io/client-go/listers/core/v1"
@@ -34,16 +37,20 @@ import (
 	wblister "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/listers/whereabouts.cni.cncf.io/v1alpha1"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/platform"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
 	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
 )
 
 const (
-	defaultMountPath      = "/host"
 	ipReconcilerQueueName = "pod-updates"
 	syncPeriod            = time.Second
-	whereaboutsConfigPath = "/etc/cni/net.d/whereabouts.d/whereabouts.conf"
 	maxRetries            = 2
+
+	// DefaultWorkers is the default number of goroutines consuming the pod deletion queue
+	DefaultWorkers = 1
 )
 
 const (
@@ -57,7 +64,8 @@ const (
 	noResyncPeriod                   = 0
 )
 
-type garbageCollector func(ctx context.Context, mode int, ipamConf types.IPAMConfig, client *wbclient.KubernetesIPAM) ([]net.IPNet, error)
+// garbageCollector releases the allocations of the interfaces of a deleted pod, returning those released
+type garbageCollector func(ctx context.Context, ipam *wbclient.KubernetesIPAM, deallocations []wbclient.PodDeallocation) ([]wbclient.PodDeallocation, error)
 
 type PodController struct {
 	k8sClient               kubernetes.Interface
@@ -73,14 +81,20 @@ type PodController struct {
 	netAttachDefLister      nadlister.NetworkAttachmentDefinitionLister
 	broadcaster             record.EventBroadcaster
 	recorder                record.EventRecorder
-	workqueue               workqueue.TypedRateLimitingInterface[*v1.Pod]
+	workqueue               workqueue.TypedRateLimitingInterface[string]
+	pendingPodsLock         sync.Mutex
+	pendingPods             map[string]*deletedPod
 	mountPath               string
 	cleanupFunc             garbageCollector
+	poolLocks               *poolLocker
+	deletionFilter          func(pod *v1.Pod) bool
+	progress                queueProgress
+	stallTimeout            time.Duration
 }
 
 // NewPodController ...
 func NewPodController(k8sCoreClient kubernetes.Interface, wbClient wbclientset.Interface, k8sCoreInformerFactory v1coreinformerfactory.SharedInformerFactory, wbSharedInformerFactory wbinformers.SharedInformerFactory, netAttachDefInformerFactory nadinformers.SharedInformerFactory, broadcaster record.EventBroadcaster, recorder record.EventRecorder) *PodController {
-	return newPodController(k8sCoreClient, wbClient, k8sCoreInformerFactory, wbSharedInformerFactory, netAttachDefInformerFactory, broadcaster, recorder, wbclient.IPManagement)
+	return newPodController(k8sCoreClient, wbClient, k8sCoreInformerFactory, wbSharedInformerFactory, netAttachDefInformerFactory, broadcaster, recorder, wbclient.DeallocateBatch)
 }
 
 // PodInformerFactory is a wrapper around NewSharedInformerFactoryWithOptions. Before returning the informer, it will
@@ -89,7 +103,9 @@ func NewPodController(k8sCoreClient kubernetes.Interface, wbClient wbclientset.I
 func PodInformerFactory(k8sClientSet kubernetes.Interface) (v1coreinformerfactory.SharedInformerFactory, error) {
 	nodeName := os.Getenv(podControllerNodeNameEnvVariable)
 	logging.Debugf("Filtering pods with filter key '%s' and filter value '%s'", podControllerFilterKey, nodeName)
-	if _, err := k8sClientSet.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{}); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), storage.RequestTimeout)
+	defer cancel()
+	if _, err := k8sClientSet.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{}); err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("Could not find node with node name '%s'.", nodeName))
 	}
 	return v1coreinformerfactory.NewSharedInformerFactoryWithOptions(
@@ -108,17 +124,10 @@ func newPodController(k8sCoreClient kubernetes.Interface, wbClient wbclientset.I
 	networksInformer := netAttachDefInformer.Informer()
 	podsInformer := k8sPodFilteredInformer.Informer()
 
-	queue := workqueue.NewTypedRateLimitingQueue[*v1.Pod](
-		workqueue.DefaultTypedControllerRateLimiter[*v1.Pod]())
-
-	podsInformer.AddEventHandler(
-		cache.ResourceEventHandlerFuncs{
-			DeleteFunc: func(obj interface{}) {
-				onPodDelete(queue, obj)
-			},
-		})
+	queue := workqueue.NewTypedRateLimitingQueue[string](
+		workqueue.DefaultTypedControllerRateLimiter[string]())
 
-	return &PodController{
+	pc := &PodController{
 		k8sClient:               k8sCoreClient,
 		wbClient:                wbClient,
 		arePodsSynched:          podsInformer.HasSynced,
@@ -133,19 +142,37 @@ func newPodController(k8sCoreClient kubernetes.Interface, wbClient wbclientset.I
 		ipPoolLister:            ipPoolInformer.Lister(),
 		netAttachDefLister:      netAttachDefInformer.Lister(),
 		workqueue:               queue,
+		pendingPods:             map[string]*deletedPod{},
 		cleanupFunc:             cleanupFunc,
+		poolLocks:               newPoolLocker(),
 	}
+
+	podsInformer.AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			DeleteFunc: pc.onPodDelete,
+		})
+
+	return pc
 }
 
-// Start runs worker thread after performing cache synchronization
-func (pc *PodController) Start(stopChan <-chan struct{}) {
+// Start runs the given number of worker threads after performing cache synchronization. Cleanups
+// of IP addresses belonging to the same IPPool are serialized across workers.
+func (pc *PodController) Start(stopChan <-chan struct{}, workers int) {
 	logging.Verbosef("starting network controller")
 
 	if ok := cache.WaitForCacheSync(stopChan, pc.arePodsSynched, pc.areNetAttachDefsSynched, pc.areIPPoolsSynched); !ok {
 		logging.Verbosef("failed waiting for caches to sync")
 	}
 
-	go wait.Until(pc.worker, syncPeriod, stopChan)
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+	logging.Verbosef("starting %d workers", workers)
+	pc.progress.wake()
+	ctx := wait.ContextForChannel(stopChan)
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, pc.worker, syncPeriod)
+	}
 }
 
 // Shutdown stops the PodController worker queue
@@ -153,35 +180,59 @@ func (pc *PodController) Shutdown() {
 	pc.workqueue.ShutDown()
 }
 
-func (pc *PodController) worker() {
-	for pc.processNextWorkItem() {
+func (pc *PodController) worker(ctx context.Context) {
+	for pc.processNextWorkItem(ctx) {
 	}
 }
 
-func (pc *PodController) processNextWorkItem() bool {
-	queueItem, shouldQuit := pc.workqueue.Get()
+func (pc *PodController) processNextWorkItem(ctx context.Context) bool {
+	key, shouldQuit := pc.workqueue.Get()
 	if shouldQuit {
 		return false
 	}
-	defer pc.workqueue.Done(queueItem)
+	defer pc.workqueue.Done(key)
+	pc.progress.start()
+	defer pc.progress.done()
+
+	pod := pc.pendingPod(key)
+	if pod == nil {
+		logging.Verbosef("no pending deletion for queue key %s", key)
+		pc.workqueue.Forget(key)
+		return true
+	}
 
-	pod := queueItem
-	err := pc.garbageCollectPodIPs(pod)
+	err := pc.garbageCollectPodIPs(ctx, pod)
 	logging.Verbosef("result of garbage collecting pods: %+v", err)
-	pc.handleResult(pod, err)
+	pc.handleResult(key, pod, err)
 
 	return true
 }
 
-func (pc *PodController) garbageCollectPodIPs(pod *v1.Pod) error {
-	podNamespace := pod.GetNamespace()
-	podName := pod.GetName()
+func (pc *PodController) pendingPod(key string) *deletedPod {
+	pc.pendingPodsLock.Lock()
+	defer pc.pendingPodsLock.Unlock()
+	return pc.pendingPods[key]
+}
+
+// forget drops the queue key's rate limiting history along with its pod payload
+func (pc *PodController) forget(key string) {
+	pc.pendingPodsLock.Lock()
+	delete(pc.pendingPods, key)
+	pc.pendingPodsLock.Unlock()
+	pc.workqueue.Forget(key)
+}
+
+func (pc *PodController) garbageCollectPodIPs(ctx context.Context, pod *deletedPod) error {
+	podNamespace := pod.namespace
+	podName := pod.name
 
-	ifaceStatuses, err := podNetworkStatus(pod)
+	ifaceStatuses, err := parseNetworkStatus(pod.networkStatus)
 	if err != nil {
 		return fmt.Errorf("failed to access the network status for pod [%s/%s]: %v", podName, podNamespace, err)
 	}
 
+	batches := map[string]*deallocationBatch{}
+	var batchKeys []string
 	for _, ifaceStatus := range ifaceStatuses {
 		if ifaceStatus.Default {
 			logging.Verbosef("skipped net-attach-def for default network")
@@ -191,13 +242,17 @@ func (pc *PodController) garbageCollectPodIPs(pod *v1.Pod) error {
 		if err != nil {
 			return fmt.Errorf("failed to get network-attachment-definition for iface %s: %+v", ifaceStatus.Name, err)
 		}
+		if wbclient.ReconcileDisabled(nad.GetAnnotations()) {
+			logging.Verbosef("skipped net-attach-def %s/%s: opted out of reconciliation", nad.GetNamespace(), nad.GetName())
+			continue
+		}
 
-		mountPath := defaultMountPath
+		mountPath := platform.HostMountPath
 		if pc.mountPath != "" {
 			mountPath = pc.mountPath
 		}
 
-		logging.Verbosef("the NAD's config: %s", nad.Spec)
+		logging.Verbosef("the NAD's config: %s", logging.SanitizeConfig(nad.Spec.Config))
 		ipamConfig, err := ipamConfiguration(nad, podNamespace, podName, mountPath)
 		if err != nil && isInvalidPluginType(err) {
 			logging.Debugf("error while computing something: %v", err)
@@ -206,9 +261,34 @@ func (pc *PodController) garbageCollectPodIPs(pod *v1.Pod) error {
 			return fmt.Errorf("failed to create an IPAM configuration for the pod %s iface %s: %+v", podID(podNamespace, podName), ifaceStatus.Name, err)
 		}
 
+		client := *wbclient.NewKubernetesClient(pc.wbClient, pc.k8sClient)
 		var pools []*whereaboutsv1alpha1.IPPool
+		var poolRanges []types.RangeConfiguration
 		for _, rangeConfig := range ipamConfig.IPRanges {
-			pool, err := pc.ipPool(wbclient.PoolIdentifier{IpRange: rangeConfig.Range, NetworkName: ipamConfig.NetworkName})
+			if ipamConfig.PoolShards > 1 {
+				shardPools, err := pc.shardPools(rangeConfig.Range, ipamConfig)
+				if err != nil {
+					return fmt.Errorf("failed to get the IPPool data: %+v", err)
+				}
+				pools = append(pools, shardPools...)
+				for range shardPools {
+					poolRanges = append(poolRanges, rangeConfig)
+				}
+				continue
+			}
+			poolIdentifier := wbclient.PoolIdentifier{IpRange: rangeConfig.Range, NetworkName: ipamConfig.NetworkName}
+			if ipamConfig.NodeSliceSize != "" {
+				// the addresses were allocated from the slice of the pod's node - which might not be ours, when
+				// cleaning up after dead nodes
+				nodeSliceRange, err := wbclient.GetNodeSliceOfRange(
+					ctx, wbclient.NewKubernetesIPAMWithClient("", "", *ipamConfig, ipPoolsNamespace(), client), pod.nodeName, rangeConfig.Range)
+				if err != nil {
+					return fmt.Errorf("failed to get the node slice of node %s: %+v", pod.nodeName, err)
+				}
+				poolIdentifier.IpRange = nodeSliceRange
+				poolIdentifier.NodeName = pod.nodeName
+			}
+			pool, err := pc.ipPool(poolsNamespace(ipamConfig), poolIdentifier)
 
 			if err != nil {
 				return fmt.Errorf("failed to get the IPPool data: %+v", err)
@@ -217,60 +297,134 @@ func (pc *PodController) garbageCollectPodIPs(pod *v1.Pod) error {
 			logging.Verbosef("pool range [%s]", pool.Spec.Range)
 
 			pools = append(pools, pool)
+			poolRanges = append(poolRanges, rangeConfig)
 		}
 
-		for _, pool := range pools {
+		// the networks allocating from node slices elect a leader per node slice, the others share the whereabouts
+		// lease
+		batchKey := ""
+		if ipamConfig.NodeSliceSize != "" {
+			batchKey = nad.GetNamespace() + "/" + nad.GetName()
+		}
+		batch, found := batches[batchKey]
+		if !found {
+			batch = &deallocationBatch{
+				ipam: wbclient.NewKubernetesIPAMWithClient("", "", *ipamConfig, ipPoolsNamespace(), client),
+			}
+			batch.ipam.NodeName = pod.nodeName
+			batches[batchKey] = batch
+			batchKeys = append(batchKeys, batchKey)
+		}
+		for poolIndex, pool := range pools {
 			for allocationIndex, allocation := range pool.Spec.Allocations {
-				if allocation.PodRef == podID(podNamespace, podName) {
+				// the allocations of another pod of the same name, e.g. the next incarnation of a StatefulSet pod, are
+				// left to it, and the floating IPs to their claims
+				if allocation.PodRef == podID(podNamespace, podName) && (allocation.PodUID == "" || allocation.PodUID == string(pod.uid)) &&
+					!types.IsFloatingIPClaim(allocation.ContainerID) {
 					logging.Verbosef("stale allocation to cleanup: %+v", allocation)
-
-					client := *wbclient.NewKubernetesClient(nil, pc.k8sClient)
-					wbClient := &wbclient.KubernetesIPAM{
-						Client: client,
-						Config: *ipamConfig,
-					}
-
-					if err != nil {
-						logging.Debugf("error while generating the IPAM client: %v", err)
-						continue
-					}
-					if _, err := pc.cleanupFunc(context.TODO(), types.Deallocate, *ipamConfig, wbClient); err != nil {
-						logging.Errorf("failed to cleanup allocation: %v", err)
-					}
-					if err := pc.addressGarbageCollected(pod, nad.GetName(), pool.Spec.Range, allocationIndex); err != nil {
-						logging.Errorf("failed to issue event for successful IP address cleanup: %v", err)
-					}
+					batch.add(wbclient.PodDeallocation{
+						IPAMConfig:    *ipamConfig,
+						Range:         poolRanges[poolIndex],
+						PoolName:      pool.GetName(),
+						PoolNamespace: pool.GetNamespace(),
+						ContainerID:   allocation.ContainerID,
+						IfName:        allocation.IfName,
+					}, nad.GetName(), pool.Spec.Range, allocationIndex)
 				}
 			}
 		}
 	}
 
+	// the allocations of all the interfaces of the pod are released at once, per lease: a pod with many interfaces
+	// does not go through as many leader elections
+	for _, batchKey := range batchKeys {
+		pc.deallocateBatch(ctx, pod, batches[batchKey])
+	}
 	return nil
 }
 
+// deallocationBatch gathers the allocations of the interfaces of a pod released in a single leader election
+type deallocationBatch struct {
+	ipam          *wbclient.KubernetesIPAM
+	deallocations []wbclient.PodDeallocation
+	// cleanups are the events of the deallocations, keyed by deallocationKey
+	cleanups map[string]allocationCleanup
+	pools    []string
+}
+
+// allocationCleanup tells the allocation of a deallocation, to issue its event once released
+type allocationCleanup struct {
+	networkName     string
+	ipRange         string
+	allocationIndex string
+}
+
+func (b *deallocationBatch) add(deallocation wbclient.PodDeallocation, networkName, ipRange, allocationIndex string) {
+	if b.cleanups == nil {
+		b.cleanups = map[string]allocationCleanup{}
+	}
+	b.deallocations = append(b.deallocations, deallocation)
+	b.cleanups[deallocationKey(deallocation)] = allocationCleanup{networkName: networkName, ipRange: ipRange, allocationIndex: allocationIndex}
+	for _, pool := range b.pools {
+		if pool == deallocation.PoolName {
+			return
+		}
+	}
+	b.pools = append(b.pools, deallocation.PoolName)
+}
+
+// deallocationKey identifies a deallocation by its IP pool and interface
+func deallocationKey(deallocation wbclient.PodDeallocation) string {
+	return strings.Join([]string{deallocation.PoolNamespace, deallocation.PoolName, deallocation.ContainerID, deallocation.IfName}, "/")
+}
+
+// deallocateBatch releases the allocations of the batch, all of its pools locked, and issues the events of those
+// released
+func (pc *PodController) deallocateBatch(ctx context.Context, pod *deletedPod, batch *deallocationBatch) {
+	if len(batch.deallocations) == 0 {
+		return
+	}
+	unlock := pc.poolLocks.Lock(batch.pools...)
+	cleanupCtx, cancel := context.WithTimeout(ctx, types.DelTimeLimit)
+	released, err := pc.cleanupFunc(cleanupCtx, batch.ipam, batch.deallocations)
+	cancel()
+	unlock()
+	if err != nil {
+		logging.Errorf("failed to cleanup allocation: %v", err)
+	}
+	metrics.GarbageCollectedIPs.Add(uint64(len(released)))
+	for _, deallocation := range released {
+		cleanup := batch.cleanups[deallocationKey(deallocation)]
+		if err := pc.addressGarbageCollected(pod, cleanup.networkName, cleanup.ipRange, cleanup.allocationIndex); err != nil {
+			logging.Errorf("failed to issue event for successful IP address cleanup: %v", err)
+		}
+	}
+}
+
 func isInvalidPluginType(err error) bool {
 	_, isInvalidPluginError := err.(*config.InvalidPluginError)
 	return isInvalidPluginError
 }
 
-func (pc *PodController) handleResult(pod *v1.Pod, err error) {
+func (pc *PodController) handleResult(key string, pod *deletedPod, err error) {
 	if err == nil {
-		pc.workqueue.Forget(pod)
+		pc.forget(key)
 		return
 	}
 
-	podNamespace := pod.GetNamespace()
-	podName := pod.GetName()
-	currentRetries := pc.workqueue.NumRequeues(pod)
+	podNamespace := pod.namespace
+	podName := pod.name
+	currentRetries := pc.workqueue.NumRequeues(key)
 	if currentRetries <= maxRetries {
 		logging.Verbosef(
 			"re-queuing IP address reconciliation request for pod %s; retry #: %d",
 			podID(podNamespace, podName),
 			currentRetries)
-		pc.workqueue.AddRateLimited(pod)
+		pc.workqueue.AddRateLimited(key)
 		return
 	}
 
+	pc.forget(key)
 	pc.addressGarbageCollectionFailed(pod, err)
 }
 
@@ -296,15 +450,37 @@ func (pc *PodController) ifaceNetAttachDef(ifaceStatus nadv1.NetworkStatus) (*na
 	return nad, nil
 }
 
-func (pc *PodController) ipPool(poolIdentifier wbclient.PoolIdentifier) (*whereaboutsv1alpha1.IPPool, error) {
-	pool, err := pc.ipPoolLister.IPPools(ipPoolsNamespace()).Get(wbclient.IPPoolName(poolIdentifier))
+func (pc *PodController) ipPool(namespace string, poolIdentifier wbclient.PoolIdentifier) (*whereaboutsv1alpha1.IPPool, error) {
+	pool, err := pc.ipPoolLister.IPPools(namespace).Get(wbclient.IPPoolName(poolIdentifier))
 	if err != nil {
 		return nil, err
 	}
 	return pool, nil
 }
 
-func (pc *PodController) addressGarbageCollected(pod *v1.Pod, networkName string, ipRange string, allocationIndex string) error {
+// shardPools returns the pools the range is split across; those of the shards which never held an allocation do
+// not exist
+func (pc *PodController) shardPools(ipRange string, ipamConfig *types.IPAMConfig) ([]*whereaboutsv1alpha1.IPPool, error) {
+	poolIdentifiers, err := wbclient.ShardPoolIdentifiers(ipRange, ipamConfig.NetworkName, ipamConfig.PoolShards)
+	if err != nil {
+		return nil, err
+	}
+
+	var pools []*whereaboutsv1alpha1.IPPool
+	for _, poolIdentifier := range poolIdentifiers {
+		pool, err := pc.ipPool(poolsNamespace(ipamConfig), poolIdentifier)
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+func (pc *PodController) addressGarbageCollected(pod *deletedPod, networkName string, ipRange string, allocationIndex string) error {
 	if pc.recorder != nil {
 		ip, _, err := net.ParseCIDR(ipRange)
 		if err != nil {
@@ -315,7 +491,7 @@ func (pc *PodController) addressGarbageCollected(pod *v1.Pod, networkName string
 			return err
 		}
 		pc.recorder.Eventf(
-			pod,
+			pod.reference(),
 			v1.EventTypeNormal,
 			addressGarbageCollected,
 			"successful cleanup of IP address [%s] from network %s",
@@ -325,43 +501,62 @@ func (pc *PodController) addressGarbageCollected(pod *v1.Pod, networkName string
 	return nil
 }
 
-func (pc *PodController) addressGarbageCollectionFailed(pod *v1.Pod, err error) {
+func (pc *PodController) addressGarbageCollectionFailed(pod *deletedPod, err error) {
 	logging.Errorf(
 		"dropping pod [%s] deletion out of the queue - could not reconcile IP: %+v",
-		podID(pod.GetNamespace(), pod.GetName()),
+		podID(pod.namespace, pod.name),
 		err)
 
-	pc.workqueue.Forget(pod)
-
 	if pc.recorder != nil {
 		pc.recorder.Eventf(
-			pod,
+			pod.reference(),
 			v1.EventTypeWarning,
 			addressGarbageCollectionFailed,
 			"failed to garbage collect addresses for pod %s",
-			podID(pod.GetNamespace(), pod.GetName()))
+			podID(pod.namespace, pod.name))
 	}
 }
 
-func onPodDelete(queue workqueue.TypedRateLimitingInterface[*v1.Pod], obj interface{}) {
+func (pc *PodController) onPodDelete(obj interface{}) {
 	pod, err := podFromTombstone(obj)
 	if err != nil {
 		logging.Errorf("cannot create pod object from %v on pod delete: %v", obj, err)
 		return
 	}
 
+	if pc.deletionFilter != nil && !pc.deletionFilter(pod) {
+		return
+	}
+
 	logging.Verbosef("deleted pod [%s]", podID(pod.GetNamespace(), pod.GetName()))
-	queue.Add(stripPod(pod)) // we only need the pod's metadata & its network-status annotations. Hence we strip it.
+	key := podQueueKey(pod)
+	pc.pendingPodsLock.Lock()
+	pc.pendingPods[key] = newDeletedPod(pod)
+	pc.pendingPodsLock.Unlock()
+	if pc.workqueue.Len() == 0 {
+		pc.progress.wake()
+	}
+	pc.workqueue.Add(key)
 }
 
 func podID(podNamespace string, podName string) string {
 	return fmt.Sprintf("%s/%s", podNamespace, podName)
 }
 
+// podQueueKey identifies a pod deletion in the work queue; the UID tells apart pods re-created
+// with the same name, while repeated deletions of the same pod collapse into a single item.
+func podQueueKey(pod *v1.Pod) string {
+	return fmt.Sprintf("%s/%s", podID(pod.GetNamespace(), pod.GetName()), pod.GetUID())
+}
+
 func podNetworkStatus(pod *v1.Pod) ([]nadv1.NetworkStatus, error) {
+	return parseNetworkStatus(pod.Annotations[nadv1.NetworkStatusAnnot])
+}
+
+// parseNetworkStatus parses the network-status annotation of a pod; a pod without one has no interface statuses
+func parseNetworkStatus(networkStatus string) ([]nadv1.NetworkStatus, error) {
 	var ifaceStatuses []nadv1.NetworkStatus
-	networkStatus, found := pod.Annotations[nadv1.NetworkStatusAnnot]
-	if found {
+	if networkStatus != "" {
 		if err := json.Unmarshal([]byte(networkStatus), &ifaceStatuses); err != nil {
 			return nil, err
 		}
@@ -370,7 +565,7 @@ func podNetworkStatus(pod *v1.Pod) ([]nadv1.NetworkStatus, error) {
 }
 
 func ipamConfiguration(nad *nadv1.NetworkAttachmentDefinition, podNamespace string, podName string, mountPath string) (*types.IPAMConfig, error) {
-	mounterWhereaboutsConfigFilePath := mountPath + whereaboutsConfigPath
+	mounterWhereaboutsConfigFilePath := mountPath + platform.WhereaboutsConfigPath
 
 	ipamConfig, err := config.LoadIPAMConfiguration([]byte(nad.Spec.Config), "", mounterWhereaboutsConfigFilePath)
 	if err != nil {
@@ -379,6 +574,9 @@ func ipamConfiguration(nad *nadv1.NetworkAttachmentDefinition, podNamespace stri
 	ipamConfig.PodName = podName
 	ipamConfig.PodNamespace = podNamespace
 	ipamConfig.Kubernetes.KubeConfigPath = mountPath + ipamConfig.Kubernetes.KubeConfigPath // must use the mount path
+	if ipamConfig.Kubernetes.TokenFile != "" {
+		ipamConfig.Kubernetes.TokenFile = mountPath + ipamConfig.Kubernetes.TokenFile
+	}
 
 	return ipamConfig, nil
 }
@@ -393,6 +591,15 @@ func ipPoolsNamespace() string {
 	return wbDefaultNamespace
 }
 
+// poolsNamespace returns the namespace of the IP pools of the network configuration: its pool_namespace, or else
+// that of the whereabouts deployment
+func poolsNamespace(ipamConfig *types.IPAMConfig) string {
+	if ipamConfig.PoolNamespace != "" {
+		return ipamConfig.PoolNamespace
+	}
+	return ipPoolsNamespace()
+}
+
 func podFromTombstone(obj interface{}) (*v1.Pod, error) {
 	pod, isPod := obj.(*v1.Pod)
 	if !isPod {
@@ -408,9 +615,36 @@ func podFromTombstone(obj interface{}) (*v1.Pod, error) {
 	return pod, nil
 }
 
-func stripPod(pod *v1.Pod) *v1.Pod {
-	newPod := pod.DeepCopy()
-	newPod.Spec = v1.PodSpec{}
-	newPod.Status = v1.PodStatus{}
-	return newPod
+// deletedPod holds what garbage collecting the addresses of a deleted pod takes, rather than the pod itself: during
+// mass deletions, the queue would otherwise retain the whole metadata - annotations, labels, managed fields - of
+// every deleted pod until its item is processed.
+type deletedPod struct {
+	name      string
+	namespace string
+	uid       k8stypes.UID
+	// nodeName tells which node slice the pod's addresses were allocated from
+	nodeName string
+	// networkStatus is the network-status annotation of the pod
+	networkStatus string
+}
+
+func newDeletedPod(pod *v1.Pod) *deletedPod {
+	return &deletedPod{
+		name:          pod.GetName(),
+		namespace:     pod.GetNamespace(),
+		uid:           pod.GetUID(),
+		nodeName:      pod.Spec.NodeName,
+		networkStatus: pod.Annotations[nadv1.NetworkStatusAnnot],
+	}
+}
+
+// reference refers the events of the garbage collection to the deleted pod
+func (p *deletedPod) reference() *v1.ObjectReference {
+	return &v1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  p.namespace,
+		Name:       p.name,
+		UID:        p.uid,
+	}
 }
@@ -15,9 +15,13 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stypes "k8s.io/apimachinery/pkg/types"
 	k8sclient "k8s.io/client-go/kubernetes"
 	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+	v1corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 
 	nad "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
@@ -26,6 +30,7 @@ import (
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
 	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
 	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/platform"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
 )
 
@@ -48,9 +53,9 @@ var _ = Describe("IPControlLoop", func() {
 		var err error
 		cniConfigDir, err = os.MkdirTemp("", "multus-config")
 		Expect(err).ToNot(HaveOccurred())
-		Expect(os.MkdirAll(path.Join(cniConfigDir, path.Dir(whereaboutsConfigPath)), configFilePermissions)).To(Succeed())
+		Expect(os.MkdirAll(path.Join(cniConfigDir, path.Dir(platform.WhereaboutsConfigPath)), configFilePermissions)).To(Succeed())
 		Expect(os.WriteFile(
-			path.Join(cniConfigDir, whereaboutsConfigPath),
+			path.Join(cniConfigDir, platform.WhereaboutsConfigPath),
 			[]byte(dummyWhereaboutsConfig()), configFilePermissions)).To(Succeed())
 	})
 
@@ -174,6 +179,29 @@ var _ = Describe("IPControlLoop", func() {
 						Eventually(<-eventRecorder.Events).Should(Equal("Normal IPAddressGarbageCollected successful cleanup of IP address [192.168.2.0] from network meganet"))
 					})
 				})
+
+				When("the allocation belongs to another pod of the same name, and the associated pod is deleted", func() {
+					BeforeEach(func() {
+						ipPool, err := wbClient.WhereaboutsV1alpha1().IPPools(dummyNetworkPool.GetNamespace()).Get(context.TODO(), dummyNetworkPool.GetName(), metav1.GetOptions{})
+						Expect(err).NotTo(HaveOccurred())
+						for key, allocation := range ipPool.Spec.Allocations {
+							allocation.PodUID = "uid-of-the-next-incarnation"
+							ipPool.Spec.Allocations[key] = allocation
+						}
+						_, err = wbClient.WhereaboutsV1alpha1().IPPools(dummyNetworkPool.GetNamespace()).Update(context.TODO(), ipPool, metav1.UpdateOptions{})
+						Expect(err).NotTo(HaveOccurred())
+
+						Expect(k8sClient.CoreV1().Pods(namespace).Delete(context.TODO(), pod.GetName(), metav1.DeleteOptions{})).To(Succeed())
+					})
+
+					It("the allocation is left in the IPPool", func() {
+						Consistently(func() (map[string]v1alpha1.IPAllocation, error) {
+							ipPool, err := wbClient.WhereaboutsV1alpha1().IPPools(dummyNetworkPool.GetNamespace()).Get(
+								context.TODO(), dummyNetworkPool.GetName(), metav1.GetOptions{})
+							return ipPool.Spec.Allocations, err
+						}).ShouldNot(BeEmpty(), "the ip control loop should leave the address of the next incarnation of the pod alone")
+					})
+				})
 			})
 
 			Context("the network attachment was deleted", func() {
@@ -227,6 +255,64 @@ var _ = Describe("IPControlLoop", func() {
 			})
 		})
 
+		Context("node slice IPPool featuring an allocation for the pod", func() {
+			const (
+				sliceSize  = "/26"
+				sliceRange = "192.168.2.64/26"
+			)
+
+			var (
+				nodeSliceNetworkPool *v1alpha1.IPPool
+				wbClient             wbclient.Interface
+				eventRecorder        *record.FakeRecorder
+				netAttachDefClient   nadclient.Interface
+				stopChannel          chan struct{}
+			)
+
+			BeforeEach(func() {
+				nodeSliceNetworkPool = ipPool(
+					kubernetes.PoolIdentifier{IpRange: sliceRange, NetworkName: networkName, NodeName: nodeName},
+					ipPoolsNamespace(),
+					podReference(pod))
+				wbClient = fakewbclient.NewSimpleClientset(
+					nodeSliceNetworkPool,
+					nodeSlicePool(networkName, ipPoolsNamespace(), dummyNetIPRange, sliceSize, nodeName, sliceRange))
+
+				var err error
+				netAttachDefClient, err = newFakeNetAttachDefClient(namespace, netAttachDef(networkName, namespace, dummyNodeSliceNetSpec(networkName, dummyNetIPRange, sliceSize)))
+				Expect(err).NotTo(HaveOccurred())
+
+				const maxEvents = 10
+				stopChannel = make(chan struct{})
+				eventRecorder = record.NewFakeRecorder(maxEvents)
+
+				dummyPodController, podControllerError = newDummyPodController(k8sClient, wbClient, netAttachDefClient, stopChannel, cniConfigDir, eventRecorder)
+				Expect(podControllerError).NotTo(HaveOccurred())
+				Expect(dummyPodController).NotTo(BeNil())
+			})
+
+			AfterEach(func() {
+				if podControllerError != nil {
+					return
+				}
+				stopChannel <- struct{}{}
+			})
+
+			When("the associated pod is deleted", func() {
+				BeforeEach(func() {
+					Expect(k8sClient.CoreV1().Pods(namespace).Delete(context.TODO(), pod.GetName(), metav1.DeleteOptions{})).To(Succeed())
+				})
+
+				It("garbage collects the stale IP addresses from the slice of the pod's node", func() {
+					Eventually(func() (map[string]v1alpha1.IPAllocation, error) {
+						ipPool, err := wbClient.WhereaboutsV1alpha1().IPPools(nodeSliceNetworkPool.GetNamespace()).Get(
+							context.TODO(), nodeSliceNetworkPool.GetName(), metav1.GetOptions{})
+						return ipPool.Spec.Allocations, err
+					}).Should(BeEmpty(), "the ip control loop should have removed this stale address")
+				})
+			})
+		})
+
 		Context("with secondary networks whose type is *not* whereabouts", func() {
 			var (
 				wbClient           wbclient.Interface
@@ -272,6 +358,170 @@ var _ = Describe("IPControlLoop", func() {
 	})
 })
 
+var _ = Describe("PodController queue", func() {
+	var pc *PodController
+
+	BeforeEach(func() {
+		pc = &PodController{
+			workqueue:   workqueue.NewTypedRateLimitingQueue[string](workqueue.DefaultTypedControllerRateLimiter[string]()),
+			pendingPods: map[string]*deletedPod{},
+		}
+	})
+
+	AfterEach(func() {
+		pc.Shutdown()
+	})
+
+	It("deduplicates repeated deletions of the same pod", func() {
+		pod := podSpec("tiny-winy-pod", "default", "node1")
+		pod.UID = "uid-1"
+
+		pc.onPodDelete(pod)
+		pc.onPodDelete(cache.DeletedFinalStateUnknown{Key: "default/tiny-winy-pod", Obj: pod})
+
+		Expect(pc.workqueue.Len()).To(Equal(1))
+		Expect(pc.pendingPods).To(HaveKey("default/tiny-winy-pod/uid-1"))
+	})
+
+	It("tells apart re-created pods with the same name", func() {
+		pod := podSpec("tiny-winy-pod", "default", "node1")
+		pod.UID = "uid-1"
+		recreatedPod := pod.DeepCopy()
+		recreatedPod.UID = k8stypes.UID("uid-2")
+
+		pc.onPodDelete(pod)
+		pc.onPodDelete(recreatedPod)
+
+		Expect(pc.workqueue.Len()).To(Equal(2))
+	})
+
+	It("keeps only what garbage collecting the pod's addresses takes", func() {
+		pod := podSpec("tiny-winy-pod", "default", "node1", "net1")
+		pod.UID = "uid-1"
+		pod.Labels = map[string]string{"app": "tiny"}
+		pod.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubelet"}}
+
+		pc.onPodDelete(pod)
+
+		Expect(pc.pendingPod("default/tiny-winy-pod/uid-1")).To(Equal(&deletedPod{
+			name:          "tiny-winy-pod",
+			namespace:     "default",
+			uid:           "uid-1",
+			nodeName:      "node1",
+			networkStatus: pod.Annotations[nad.NetworkStatusAnnot],
+		}))
+	})
+
+	It("drops the pod payload once the item is forgotten", func() {
+		pod := podSpec("tiny-winy-pod", "default", "node1")
+		pc.onPodDelete(pod)
+
+		key := podQueueKey(pod)
+		pc.handleResult(key, pc.pendingPod(key), nil)
+
+		Expect(pc.pendingPods).To(BeEmpty())
+		Expect(pc.workqueue.NumRequeues(key)).To(BeZero())
+	})
+})
+
+var _ = Describe("Dead node cleanup", func() {
+	var nodeLister v1corelisters.NodeLister
+
+	BeforeEach(func() {
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		Expect(indexer.Add(nodeSpec("node1"))).To(Succeed())
+		nodeLister = v1corelisters.NewNodeLister(indexer)
+	})
+
+	It("ignores pods scheduled on existing nodes", func() {
+		Expect(podOnDeadNode(nodeLister, podSpec("tiny-winy-pod", "default", "node1"))).To(BeFalse())
+	})
+
+	It("ignores pods which were never scheduled", func() {
+		Expect(podOnDeadNode(nodeLister, podSpec("tiny-winy-pod", "default", ""))).To(BeFalse())
+	})
+
+	It("selects pods scheduled on nodes which no longer exist", func() {
+		Expect(podOnDeadNode(nodeLister, podSpec("tiny-winy-pod", "default", "node2"))).To(BeTrue())
+	})
+
+	It("does not enqueue deletions rejected by the filter", func() {
+		pc := &PodController{
+			workqueue:      workqueue.NewTypedRateLimitingQueue[string](workqueue.DefaultTypedControllerRateLimiter[string]()),
+			pendingPods:    map[string]*deletedPod{},
+			deletionFilter: func(pod *v1.Pod) bool { return podOnDeadNode(nodeLister, pod) },
+		}
+		defer pc.Shutdown()
+
+		pc.onPodDelete(podSpec("live-node-pod", "default", "node1"))
+		pc.onPodDelete(podSpec("dead-node-pod", "default", "node2"))
+
+		Expect(pc.workqueue.Len()).To(Equal(1))
+	})
+
+	It("enqueues the pods left on nodes deleted before the election", func() {
+		podIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		Expect(podIndexer.Add(podSpec("live-node-pod", "default", "node1"))).To(Succeed())
+		Expect(podIndexer.Add(podSpec("dead-node-pod", "default", "node2"))).To(Succeed())
+		pc := &PodController{
+			podLister:      v1corelisters.NewPodLister(podIndexer),
+			workqueue:      workqueue.NewTypedRateLimitingQueue[string](workqueue.DefaultTypedControllerRateLimiter[string]()),
+			pendingPods:    map[string]*deletedPod{},
+			deletionFilter: func(pod *v1.Pod) bool { return podOnDeadNode(nodeLister, pod) },
+		}
+		defer pc.Shutdown()
+
+		enqueuePodsOnDeadNodes(pc)
+
+		Expect(pc.workqueue.Len()).To(Equal(1))
+		Expect(pc.pendingPods).To(HaveLen(1))
+		for _, pod := range pc.pendingPods {
+			Expect(pod.name).To(Equal("dead-node-pod"))
+		}
+	})
+})
+
+var _ = Describe("PoolLocker", func() {
+	It("serializes access to the same pool", func() {
+		locker := newPoolLocker()
+		unlock := locker.Lock("pool-a", "pool-b")
+
+		acquired := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			release := locker.Lock("pool-b")
+			close(acquired)
+			release()
+		}()
+
+		Consistently(acquired, 100*time.Millisecond).ShouldNot(BeClosed())
+		unlock()
+		Eventually(acquired).Should(BeClosed())
+	})
+
+	It("does not block cleanups for other pools", func() {
+		locker := newPoolLocker()
+		unlock := locker.Lock("pool-a")
+		defer unlock()
+
+		acquired := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			release := locker.Lock("pool-b", "pool-b")
+			close(acquired)
+			release()
+		}()
+
+		Eventually(acquired).Should(BeClosed())
+	})
+
+	It("forgets locks once released", func() {
+		locker := newPoolLocker()
+		locker.Lock("pool-a", "pool-b")()
+		Expect(locker.locks).To(BeEmpty())
+	})
+})
+
 func newFakeNetAttachDefClient(namespace string, networkAttachments ...nad.NetworkAttachmentDefinition) (nadclient.Interface, error) {
 	netAttachDefClient := fakenadclient.NewSimpleClientset()
 	gvr := metav1.GroupVersionResource{
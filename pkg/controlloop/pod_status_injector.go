@@ -0,0 +1,119 @@
+//go:build test
+// +build test
+
+package controlloop
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8sclient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	nad "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+)
+
+// informerTimeout bounds the wait for the pod informer to catch up with the changes of the injector
+const informerTimeout = 5 * time.Second
+
+// podStatusInjector stands in for the container runtime and the informers of a pod controller under test: it runs the
+// pods of a node, attached to their networks, and reproduces what the controller sees in production - storms of
+// deletions, deletions replayed by informer relists, and network-status annotations only partially written.
+type podStatusInjector struct {
+	k8sClient  k8sclient.Interface
+	controller *dummyPodController
+	namespace  string
+	nodeName   string
+
+	mu sync.Mutex
+	// incarnations counts the pods run per name, telling apart the UIDs of pods re-created with the same name
+	incarnations map[string]int
+}
+
+func newPodStatusInjector(k8sClient k8sclient.Interface, controller *dummyPodController, namespace, nodeName string) *podStatusInjector {
+	return &podStatusInjector{
+		k8sClient:    k8sClient,
+		controller:   controller,
+		namespace:    namespace,
+		nodeName:     nodeName,
+		incarnations: map[string]int{},
+	}
+}
+
+// runPod creates a running pod attached to the networks, as the runtime reports it once the network plugins are done,
+// and waits for the pod informer to list it
+func (psi *podStatusInjector) runPod(name string, networks ...string) (*v1.Pod, error) {
+	psi.mu.Lock()
+	psi.incarnations[name]++
+	incarnation := psi.incarnations[name]
+	psi.mu.Unlock()
+
+	pod := podSpec(name, psi.namespace, psi.nodeName, networks...)
+	pod.UID = k8stypes.UID(fmt.Sprintf("%s-%d", name, incarnation))
+	pod.Status.Phase = v1.PodRunning
+	pod, err := psi.k8sClient.CoreV1().Pods(psi.namespace).Create(context.TODO(), pod, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return pod, psi.waitForInformer(pod, func(cached *v1.Pod) bool { return cached.GetUID() == pod.GetUID() })
+}
+
+// deleteStorm deletes the pods concurrently, as draining a node or rolling out a large workload does
+func (psi *podStatusInjector) deleteStorm(pods ...*v1.Pod) error {
+	errs := make(chan error, len(pods))
+	var wg sync.WaitGroup
+	for _, pod := range pods {
+		wg.Add(1)
+		go func(pod *v1.Pod) {
+			defer wg.Done()
+			errs <- psi.k8sClient.CoreV1().Pods(pod.GetNamespace()).Delete(context.TODO(), pod.GetName(), metav1.DeleteOptions{})
+		}(pod)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayDeletions delivers the deletions of the pods anew, as the tombstones an informer hands out when a relist -
+// e.g. once its watch expired - finds pods gone whose deletion it did not witness
+func (psi *podStatusInjector) replayDeletions(pods ...*v1.Pod) {
+	for _, pod := range pods {
+		psi.controller.onPodDelete(cache.DeletedFinalStateUnknown{Key: podID(pod.GetNamespace(), pod.GetName()), Obj: pod})
+	}
+}
+
+// setNetworkStatus overwrites the network-status of the pod - e.g. with truncated JSON, or interfaces missing their
+// IPs - as a partial update of its annotations leaves it, and waits for the pod informer to see it
+func (psi *podStatusInjector) setNetworkStatus(pod *v1.Pod, networkStatus string) (*v1.Pod, error) {
+	pod = pod.DeepCopy()
+	pod.Annotations[nad.NetworkStatusAnnot] = networkStatus
+	pod, err := psi.k8sClient.CoreV1().Pods(pod.GetNamespace()).Update(context.TODO(), pod, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return pod, psi.waitForInformer(pod, func(cached *v1.Pod) bool {
+		return cached.Annotations[nad.NetworkStatusAnnot] == networkStatus
+	})
+}
+
+func (psi *podStatusInjector) waitForInformer(pod *v1.Pod, caughtUp func(cached *v1.Pod) bool) error {
+	return wait.PollUntilContextTimeout(context.TODO(), 10*time.Millisecond, informerTimeout, true, func(context.Context) (bool, error) {
+		obj, exists, err := psi.controller.podCache.GetByKey(podID(pod.GetNamespace(), pod.GetName()))
+		if err != nil || !exists {
+			return false, err
+		}
+		cached, isPod := obj.(*v1.Pod)
+		return isPod && caughtUp(cached), nil
+	})
+}
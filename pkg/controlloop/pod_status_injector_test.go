@@ -0,0 +1,144 @@
+package controlloop
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "k8s.io/client-go/kubernetes"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/platform"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+var _ = Describe("IPControlLoop garbage collection races", func() {
+	const (
+		ipRange     = "192.168.3.0/24"
+		namespace   = "default"
+		networkName = "stormnet"
+		nodeName    = "stormnode"
+		stormSize   = 20
+	)
+
+	var (
+		cniConfigDir  string
+		k8sClient     k8sclient.Interface
+		wbClient      wbclient.Interface
+		eventRecorder *record.FakeRecorder
+		stopChannel   chan struct{}
+		injector      *podStatusInjector
+		pool          *v1alpha1.IPPool
+	)
+
+	allocatedPods := func() ([]string, error) {
+		currentPool, err := wbClient.WhereaboutsV1alpha1().IPPools(pool.GetNamespace()).Get(context.TODO(), pool.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		var podRefs []string
+		for _, allocation := range currentPool.Spec.Allocations {
+			podRefs = append(podRefs, allocation.PodRef)
+		}
+		return podRefs, nil
+	}
+
+	BeforeEach(func() {
+		const configFilePermissions = 0755
+
+		var err error
+		cniConfigDir, err = os.MkdirTemp("", "multus-config")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.MkdirAll(path.Join(cniConfigDir, path.Dir(platform.WhereaboutsConfigPath)), configFilePermissions)).To(Succeed())
+		Expect(os.WriteFile(
+			path.Join(cniConfigDir, platform.WhereaboutsConfigPath),
+			[]byte(dummyWhereaboutsConfig()), configFilePermissions)).To(Succeed())
+		os.Setenv("NODENAME", nodeName)
+
+		var podRefs []string
+		for i := 0; i < stormSize; i++ {
+			podRefs = append(podRefs, podID(namespace, fmt.Sprintf("pod-%d", i)))
+		}
+		pool = ipPool(kubernetes.PoolIdentifier{IpRange: ipRange, NetworkName: kubernetes.UnnamedNetwork}, ipPoolsNamespace(), podRefs...)
+		pool.ResourceVersion = "1"
+		k8sClient = fakek8sclient.NewSimpleClientset(nodeSpec(nodeName))
+		wbClient = fakewbclient.NewSimpleClientset(pool)
+		netAttachDefClient, err := newFakeNetAttachDefClient(namespace, netAttachDef(networkName, namespace, dummyNetSpec(networkName, ipRange)))
+		Expect(err).NotTo(HaveOccurred())
+
+		const maxEvents = stormSize * 2
+		stopChannel = make(chan struct{})
+		eventRecorder = record.NewFakeRecorder(maxEvents)
+		controller, err := newDummyPodController(k8sClient, wbClient, netAttachDefClient, stopChannel, cniConfigDir, eventRecorder)
+		Expect(err).NotTo(HaveOccurred())
+		injector = newPodStatusInjector(k8sClient, controller, namespace, nodeName)
+	})
+
+	AfterEach(func() {
+		close(stopChannel)
+		Expect(os.RemoveAll(cniConfigDir)).To(Succeed())
+	})
+
+	When("a storm of deletions hits the pods sharing a pool", func() {
+		It("garbage collects the addresses of every pod", func() {
+			var pods []*v1.Pod
+			for i := 0; i < stormSize; i++ {
+				pod, err := injector.runPod(fmt.Sprintf("pod-%d", i), networkName)
+				Expect(err).NotTo(HaveOccurred())
+				pods = append(pods, pod)
+			}
+
+			Expect(injector.deleteStorm(pods...)).To(Succeed())
+
+			Eventually(allocatedPods, 10*time.Second).Should(BeEmpty(), "no allocation of the deleted pods should survive the storm")
+		})
+	})
+
+	When("an informer relist replays the deletion of a pod re-created with the same name", func() {
+		It("leaves the addresses of the new incarnation alone", func() {
+			pod, err := injector.runPod("pod-0", networkName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(injector.deleteStorm(pod)).To(Succeed())
+			Eventually(allocatedPods).ShouldNot(ContainElement(podReference(pod)))
+
+			nextIncarnation, err := injector.runPod("pod-0", networkName)
+			Expect(err).NotTo(HaveOccurred())
+			currentPool, err := wbClient.WhereaboutsV1alpha1().IPPools(pool.GetNamespace()).Get(context.TODO(), pool.GetName(), metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			currentPool.Spec.Allocations["0"] = v1alpha1.IPAllocation{PodRef: podReference(nextIncarnation), PodUID: string(nextIncarnation.GetUID())}
+			_, err = wbClient.WhereaboutsV1alpha1().IPPools(pool.GetNamespace()).Update(context.TODO(), currentPool, metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			injector.replayDeletions(pod)
+
+			Consistently(allocatedPods).Should(ContainElement(podReference(nextIncarnation)),
+				"the replayed deletion of the previous incarnation must not release the addresses of the new one")
+		})
+	})
+
+	When("a pod is deleted with a partially written network-status", func() {
+		It("keeps its addresses, and reports the failed cleanup", func() {
+			pod, err := injector.runPod("pod-0", networkName)
+			Expect(err).NotTo(HaveOccurred())
+			pod, err = injector.setNetworkStatus(pod, fmt.Sprintf(`[{"name": "%s/%s", "interf`, namespace, networkName))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(injector.deleteStorm(pod)).To(Succeed())
+
+			Eventually(eventRecorder.Events, 5*time.Second).Should(Receive(Equal(fmt.Sprintf(
+				"Warning IPAddressGarbageCollectionFailed failed to garbage collect addresses for pod %s", podReference(pod)))))
+			Expect(allocatedPods()).To(ContainElement(podReference(pod)))
+		})
+	})
+})
@@ -0,0 +1,78 @@
+package controlloop
+
+import (
+	"sort"
+	"sync"
+)
+
+// poolLocker serializes the garbage collection of IP addresses belonging to
+// the same IPPool, while allowing cleanups for different pools to proceed in
+// parallel.
+type poolLocker struct {
+	mu    sync.Mutex
+	locks map[string]*poolLock
+}
+
+type poolLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newPoolLocker() *poolLocker {
+	return &poolLocker{locks: map[string]*poolLock{}}
+}
+
+// Lock acquires the locks for all the given pool names, and returns a function
+// releasing them. Locks are always taken in lexicographical order, to prevent
+// deadlocks between workers handling overlapping sets of pools.
+func (pl *poolLocker) Lock(poolNames ...string) func() {
+	names := uniqueSorted(poolNames)
+	for _, name := range names {
+		pl.acquire(name).Lock()
+	}
+
+	return func() {
+		for i := len(names) - 1; i >= 0; i-- {
+			pl.release(names[i])
+		}
+	}
+}
+
+func (pl *poolLocker) acquire(name string) *poolLock {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	lock, found := pl.locks[name]
+	if !found {
+		lock = &poolLock{}
+		pl.locks[name] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+func (pl *poolLocker) release(name string) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	lock := pl.locks[name]
+	lock.Unlock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(pl.locks, name)
+	}
+}
+
+func uniqueSorted(names []string) []string {
+	seen := map[string]struct{}{}
+	var result []string
+	for _, name := range names {
+		if _, found := seen[name]; found {
+			continue
+		}
+		seen[name] = struct{}{}
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
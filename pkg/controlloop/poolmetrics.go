@@ -0,0 +1,234 @@
+package controlloop
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
+	nadinformers "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions"
+	nadlister "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/listers/k8s.cni.cncf.io/v1"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbclientset "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+	wblister "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/listers/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+const poolMetricsLeaseName = "whereabouts-pool-metrics"
+
+// RunPoolMetrics competes with the other control loop instances for a cluster-wide lease. While holding it, it exposes
+// the capacity, allocated and utilization gauges of all the IP pools, which it drops once the lease is lost: a single
+// instance exports them. It blocks until the context is cancelled.
+func RunPoolMetrics(ctx context.Context, identity string, k8sClient kubernetes.Interface, wbClient wbclientset.Interface, nadClient nadclient.Interface) {
+	RunWhileLeading(ctx, poolMetricsLeaseName, identity, k8sClient, "expose the IP pool metrics", func(leaderCtx context.Context) {
+		wbInformerFactory := wbinformers.NewSharedInformerFactory(wbClient, noResyncPeriod)
+		netAttachDefInformerFactory := nadinformers.NewSharedInformerFactory(nadClient, noResyncPeriod)
+
+		pm := newPoolMetrics(wbInformerFactory, netAttachDefInformerFactory)
+
+		wbInformerFactory.Start(leaderCtx.Done())
+		netAttachDefInformerFactory.Start(leaderCtx.Done())
+
+		<-leaderCtx.Done()
+		pm.forgetAll()
+	})
+}
+
+// poolMetrics keeps the capacity, allocated and utilization gauges of the IP pools in line with the IP pool informer.
+// The network of a pool is told by the network-attachment-definitions, hence their index is rebuilt, and the gauges of
+// all the pools set anew, whenever these change.
+type poolMetrics struct {
+	ipPoolLister       wblister.IPPoolLister
+	netAttachDefLister nadlister.NetworkAttachmentDefinitionLister
+
+	mu sync.Mutex
+	// index holds the whereabouts networks of the network-attachment-definitions, labelling the pools
+	index wbclient.WhereaboutsNetworks
+	// networks are the network labels the gauges of each pool are set with, to drop them once the pool is deleted or
+	// its network changes
+	networks map[string]string
+	// stopped is set once the gauges are dropped, for the events still in flight not to set them again
+	stopped bool
+}
+
+func newPoolMetrics(wbInformerFactory wbinformers.SharedInformerFactory, netAttachDefInformerFactory nadinformers.SharedInformerFactory) *poolMetrics {
+	ipPoolInformer := wbInformerFactory.Whereabouts().V1alpha1().IPPools()
+	netAttachDefInformer := netAttachDefInformerFactory.K8sCniCncfIo().V1().NetworkAttachmentDefinitions()
+
+	pm := &poolMetrics{
+		ipPoolLister:       ipPoolInformer.Lister(),
+		netAttachDefLister: netAttachDefInformer.Lister(),
+		networks:           map[string]string{},
+	}
+
+	_, _ = ipPoolInformer.Informer().AddEventHandler(pm.poolEventHandler())
+	_, _ = netAttachDefInformer.Informer().AddEventHandler(pm.netAttachDefEventHandler())
+	return pm
+}
+
+func (pm *poolMetrics) poolEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pool, isPool := obj.(*whereaboutsv1alpha1.IPPool); isPool {
+				pm.observe(pool)
+			}
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			if pool, isPool := newObj.(*whereaboutsv1alpha1.IPPool); isPool {
+				pm.observe(pool)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, isTombstone := obj.(cache.DeletedFinalStateUnknown); isTombstone {
+				obj = tombstone.Obj
+			}
+			if pool, isPool := obj.(*whereaboutsv1alpha1.IPPool); isPool {
+				pm.forget(pool.GetName())
+			}
+		},
+	}
+}
+
+func (pm *poolMetrics) netAttachDefEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { pm.observeAll() },
+		UpdateFunc: func(_, _ interface{}) { pm.observeAll() },
+		DeleteFunc: func(interface{}) { pm.observeAll() },
+	}
+}
+
+// observeAll rebuilds the network index and sets the gauges of all the IP pools, once the
+// network-attachment-definitions changed
+func (pm *poolMetrics) observeAll() {
+	index := pm.networkIndex()
+	pm.mu.Lock()
+	pm.index = index
+	pm.mu.Unlock()
+
+	pools, err := pm.ipPoolLister.List(labels.Everything())
+	if err != nil {
+		logging.Debugf("failed to list the IP pools: %v", err)
+		return
+	}
+	for _, pool := range pools {
+		pm.observe(pool)
+	}
+}
+
+func (pm *poolMetrics) observe(pool *whereaboutsv1alpha1.IPPool) {
+	capacity, err := poolCapacity(pool)
+	if err != nil {
+		logging.Debugf("failed to compute the capacity of IP pool %s: %v", pool.GetName(), err)
+		return
+	}
+	allocated := uint64(len(pool.Spec.Allocations) + len(pool.Status.Reservations))
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.stopped {
+		return
+	}
+	network := pm.index.PoolNetwork(pool.GetName(), pool.Spec.Range)
+	if previous, found := pm.networks[pool.GetName()]; found && previous != network {
+		deletePoolGauges(pool.GetName(), previous)
+	}
+	pm.networks[pool.GetName()] = network
+
+	metrics.IPPoolCapacity.Set(float64(capacity), pool.GetName(), network)
+	metrics.IPPoolAllocated.Set(float64(allocated), pool.GetName(), network)
+	utilization := 0.0
+	if capacity > 0 {
+		utilization = float64(allocated) * 100 / float64(capacity)
+	}
+	metrics.IPPoolUtilization.Set(utilization, pool.GetName(), network)
+}
+
+func (pm *poolMetrics) forget(poolName string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if network, found := pm.networks[poolName]; found {
+		deletePoolGauges(poolName, network)
+		delete(pm.networks, poolName)
+	}
+}
+
+// forgetAll drops the gauges of all the IP pools, once another instance exports them
+func (pm *poolMetrics) forgetAll() {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	for poolName, network := range pm.networks {
+		deletePoolGauges(poolName, network)
+	}
+	pm.networks = map[string]string{}
+	pm.stopped = true
+}
+
+// networkIndex collects the whereabouts networks of the network-attachment-definitions
+func (pm *poolMetrics) networkIndex() wbclient.WhereaboutsNetworks {
+	netAttachDefs, err := pm.netAttachDefLister.List(labels.Everything())
+	if err != nil {
+		logging.Debugf("failed to list the network-attachment-definitions: %v", err)
+		return nil
+	}
+	values := make([]nadv1.NetworkAttachmentDefinition, 0, len(netAttachDefs))
+	for _, netAttachDef := range netAttachDefs {
+		values = append(values, *netAttachDef)
+	}
+	return wbclient.NewWhereaboutsNetworks(values)
+}
+
+func deletePoolGauges(poolName, network string) {
+	metrics.IPPoolCapacity.Delete(poolName, network)
+	metrics.IPPoolAllocated.Delete(poolName, network)
+	metrics.IPPoolUtilization.Delete(poolName, network)
+}
+
+// poolCapacity counts the IPs the pool can allocate: the usable IPs of the CIDRs of its range set or, on a single
+// range, those between the range start and end the pool was last updated with, if any. Addresses excluded by the
+// network configuration are not accounted for, hence the capacity is an upper bound.
+func poolCapacity(pool *whereaboutsv1alpha1.IPPool) (uint64, error) {
+	if len(pool.Spec.RangeSet) > 0 {
+		var capacity uint64
+		for _, cidr := range pool.Spec.RangeSet {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return 0, err
+			}
+			usable := iphelpers.UsableIPCount(*ipNet)
+			if capacity > math.MaxUint64-usable {
+				return math.MaxUint64, nil
+			}
+			capacity += usable
+		}
+		return capacity, nil
+	}
+
+	_, ipNet, err := pool.ParseCIDR()
+	if err != nil {
+		return 0, err
+	}
+	ones, totalBits := ipNet.Mask.Size()
+	if totalBits-ones >= 64 || !iphelpers.HasUsableIPs(*ipNet) {
+		return iphelpers.UsableIPCount(*ipNet), nil
+	}
+	firstIP, lastIP, err := iphelpers.GetIPRange(*ipNet, net.ParseIP(pool.Status.RangeStart), net.ParseIP(pool.Status.RangeEnd))
+	if err != nil {
+		return 0, err
+	}
+	offset, err := iphelpers.IPGetOffset(lastIP, firstIP)
+	if err != nil {
+		return 0, err
+	}
+	return offset + 1, nil
+}
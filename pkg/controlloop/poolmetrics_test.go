@@ -0,0 +1,109 @@
+package controlloop
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
+	nadinformers "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+var _ = Describe("IP pool metrics", func() {
+	const (
+		namespace = "default"
+		poolRange = "192.168.50.0/28"
+		poolName  = "192.168.50.0-28"
+	)
+
+	var (
+		wbClient    wbclient.Interface
+		nadClient   nadclient.Interface
+		poolMetrics *poolMetrics
+		cancel      context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		pool := ipPool(kubernetes.PoolIdentifier{IpRange: poolRange}, ipPoolsNamespace(), "default/pod-a", "default/pod-b", "default/pod-c")
+		pool.Status = v1alpha1.IPPoolStatus{
+			Reservations: []v1alpha1.ServiceReservation{{Name: "gateway", IP: "192.168.50.1"}},
+			RangeStart:   "192.168.50.1",
+			RangeEnd:     "192.168.50.10",
+		}
+
+		wbClient = fakewbclient.NewSimpleClientset(pool)
+		var err error
+		nadClient, err = newFakeNetAttachDefClient(namespace)
+		Expect(err).NotTo(HaveOccurred())
+
+		wbInformerFactory := wbinformers.NewSharedInformerFactory(wbClient, noResyncPeriod)
+		nadInformerFactory := nadinformers.NewSharedInformerFactory(nadClient, noResyncPeriod)
+		poolMetrics = newPoolMetrics(wbInformerFactory, nadInformerFactory)
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		wbInformerFactory.Start(ctx.Done())
+		nadInformerFactory.Start(ctx.Done())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	gauge := func(gaugeVec *metrics.GaugeVec, network string) func() float64 {
+		return func() float64 {
+			value, _ := gaugeVec.Value(poolName, network)
+			return value
+		}
+	}
+
+	It("exposes the capacity and utilization of the pools, labelled by network", func() {
+		Eventually(gauge(metrics.IPPoolCapacity, kubernetes.UnknownNetwork)).Should(BeEquivalentTo(10))
+		Expect(gauge(metrics.IPPoolAllocated, kubernetes.UnknownNetwork)()).To(BeEquivalentTo(4))
+		Expect(gauge(metrics.IPPoolUtilization, kubernetes.UnknownNetwork)()).To(BeEquivalentTo(40))
+
+		network := netAttachDef("metrics-net", namespace, dummyNetSpec("metrics-net", poolRange))
+		_, err := nadClient.K8sCniCncfIoV1().NetworkAttachmentDefinitions(namespace).Create(context.TODO(), &network, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(gauge(metrics.IPPoolUtilization, poolRange)).Should(BeEquivalentTo(40))
+		_, found := metrics.IPPoolUtilization.Value(poolName, kubernetes.UnknownNetwork)
+		Expect(found).To(BeFalse())
+	})
+
+	It("drops the gauges of the deleted pools", func() {
+		Eventually(gauge(metrics.IPPoolAllocated, kubernetes.UnknownNetwork)).Should(BeEquivalentTo(4))
+
+		Expect(wbClient.WhereaboutsV1alpha1().IPPools(ipPoolsNamespace()).Delete(context.TODO(), poolName, metav1.DeleteOptions{})).To(Succeed())
+
+		Eventually(func() bool {
+			_, found := metrics.IPPoolAllocated.Value(poolName, kubernetes.UnknownNetwork)
+			return found
+		}).Should(BeFalse())
+	})
+
+	It("drops the gauges of all the pools once the lease is lost", func() {
+		Eventually(gauge(metrics.IPPoolAllocated, kubernetes.UnknownNetwork)).Should(BeEquivalentTo(4))
+
+		poolMetrics.forgetAll()
+
+		_, found := metrics.IPPoolAllocated.Value(poolName, kubernetes.UnknownNetwork)
+		Expect(found).To(BeFalse())
+
+		pool, err := wbClient.WhereaboutsV1alpha1().IPPools(ipPoolsNamespace()).Get(context.TODO(), poolName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		poolMetrics.observe(pool)
+		_, found = metrics.IPPoolAllocated.Value(poolName, kubernetes.UnknownNetwork)
+		Expect(found).To(BeFalse())
+	})
+})
@@ -0,0 +1,228 @@
+package controlloop
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	v1coreinformerfactory "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	v1corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
+	nadinformers "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions"
+	nadlister "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/listers/k8s.cni.cncf.io/v1"
+
+	wbclientset "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/platform"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+const (
+	preReservationsLeaseName = "whereabouts-pre-reservations"
+
+	// PreReserveAnnotation, set to "true" on a pod, has the elected control loop instance allocate the IPs of its
+	// whereabouts networks as soon as the scheduler binds it to a node, before the CNI ADD of its interfaces
+	PreReserveAnnotation = "whereabouts.cni.cncf.io/pre-reserve"
+
+	// PreReservedIPsAnnotation lists the IPs pre-reserved for the pod, comma separated - the IPs its interfaces get,
+	// which external ACLs can be provisioned with before the pod starts
+	PreReservedIPsAnnotation = "whereabouts.cni.cncf.io/pre-reserved-ips"
+)
+
+// RunPreReservations competes with the other control loop instances for a cluster-wide lease. While holding it, it
+// allocates the IPs of the whereabouts networks of the pods annotated with PreReserveAnnotation once they are bound to
+// a node, and lists them in their PreReservedIPsAnnotation: the CNI ADD of each interface then claims the IP
+// pre-reserved for it. It blocks until the context is cancelled.
+func RunPreReservations(ctx context.Context, identity string, k8sClient kubernetes.Interface, wbClient wbclientset.Interface, nadClient nadclient.Interface) {
+	RunWhileLeading(ctx, preReservationsLeaseName, identity, k8sClient, "pre-reserve the IPs of the bound pods", func(leaderCtx context.Context) {
+		podInformerFactory := v1coreinformerfactory.NewSharedInformerFactory(k8sClient, noResyncPeriod)
+		nadInformerFactory := nadinformers.NewSharedInformerFactory(nadClient, noResyncPeriod)
+
+		preReservations := newPreReservations(k8sClient, wbclient.NewKubernetesClient(wbClient, k8sClient),
+			podInformerFactory, nadInformerFactory, wbclient.IPManagement)
+
+		podInformerFactory.Start(leaderCtx.Done())
+		nadInformerFactory.Start(leaderCtx.Done())
+
+		preReservations.run(leaderCtx)
+	})
+}
+
+// preReservations allocates the IPs of the bound pods asking for it with a container ID of their own, see
+// types.PreReservationContainerID, and the interface names multus gives them. The reconciler spares these allocations
+// while their pod lives, and releases them along with the allocations of the deleted pods otherwise.
+type preReservations struct {
+	k8sClient          kubernetes.Interface
+	client             *wbclient.Client
+	podLister          v1corelisters.PodLister
+	netAttachDefLister nadlister.NetworkAttachmentDefinitionLister
+	synced             []cache.InformerSynced
+	workqueue          workqueue.TypedRateLimitingInterface[string]
+	mountPath          string
+	allocate           func(ctx context.Context, mode int, ipamConf types.IPAMConfig, client *wbclient.KubernetesIPAM) ([]net.IPNet, error)
+}
+
+func newPreReservations(k8sClient kubernetes.Interface, client *wbclient.Client, podInformerFactory v1coreinformerfactory.SharedInformerFactory, nadInformerFactory nadinformers.SharedInformerFactory, allocate func(ctx context.Context, mode int, ipamConf types.IPAMConfig, client *wbclient.KubernetesIPAM) ([]net.IPNet, error)) *preReservations {
+	podInformer := podInformerFactory.Core().V1().Pods()
+	netAttachDefInformer := nadInformerFactory.K8sCniCncfIo().V1().NetworkAttachmentDefinitions()
+
+	p := &preReservations{
+		k8sClient:          k8sClient,
+		client:             client,
+		podLister:          podInformer.Lister(),
+		netAttachDefLister: netAttachDefInformer.Lister(),
+		synced: []cache.InformerSynced{
+			podInformer.Informer().HasSynced,
+			netAttachDefInformer.Informer().HasSynced,
+		},
+		workqueue: workqueue.NewTypedRateLimitingQueue[string](
+			workqueue.DefaultTypedControllerRateLimiter[string]()),
+		mountPath: platform.HostMountPath,
+		allocate:  allocate,
+	}
+
+	// the pods are bound to their node through an update
+	_, _ = podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    p.enqueue,
+		UpdateFunc: func(_, newObj interface{}) { p.enqueue(newObj) },
+	})
+	return p
+}
+
+func (p *preReservations) run(ctx context.Context) {
+	defer p.workqueue.ShutDown()
+	if ok := cache.WaitForCacheSync(ctx.Done(), p.synced...); !ok {
+		logging.Verbosef("failed waiting for caches to sync")
+		return
+	}
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		for p.processNextWorkItem(ctx) {
+		}
+	}, 0)
+	<-ctx.Done()
+}
+
+func (p *preReservations) processNextWorkItem(ctx context.Context) bool {
+	key, shouldQuit := p.workqueue.Get()
+	if shouldQuit {
+		return false
+	}
+	defer p.workqueue.Done(key)
+
+	if err := p.sync(ctx, key); err != nil {
+		_ = logging.Errorf("failed to pre-reserve the IPs of pod %s: %v", key, err)
+		p.workqueue.AddRateLimited(key)
+		return true
+	}
+	p.workqueue.Forget(key)
+	return true
+}
+
+// sync allocates the IPs of the whereabouts networks of the pod, and lists them in its annotation. The networks
+// keying their allocations by MAC address are skipped, as the MAC address of an interface is only known on its ADD.
+func (p *preReservations) sync(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	pod, err := p.podLister.Pods(namespace).Get(name)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !awaitsPreReservation(pod) {
+		return nil
+	}
+
+	selections, err := wbclient.PodNetworkSelections(pod)
+	if err != nil {
+		return fmt.Errorf("failed to parse the networks of the pod: %w", err)
+	}
+
+	var preReservedIPs []string
+	for i, selection := range selections {
+		netAttachDefNamespace := selection.Namespace
+		if netAttachDefNamespace == "" {
+			netAttachDefNamespace = namespace
+		}
+		nad, err := p.netAttachDefLister.NetworkAttachmentDefinitions(netAttachDefNamespace).Get(selection.Name)
+		if err != nil {
+			return fmt.Errorf("failed to get network-attachment-definition %s/%s: %w", netAttachDefNamespace, selection.Name, err)
+		}
+		ipamConfig, err := ipamConfiguration(nad, namespace, name, p.mountPath)
+		if err != nil && isInvalidPluginType(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to create an IPAM configuration for network %s/%s: %w", netAttachDefNamespace, selection.Name, err)
+		}
+		if ipamConfig.ReservationKey == types.ReservationKeyMAC {
+			logging.Verbosef("not pre-reserving the IPs of pod %s on network %s/%s: they are keyed by MAC address", key, netAttachDefNamespace, selection.Name)
+			continue
+		}
+		ipamConfig.PodUID = string(pod.GetUID())
+
+		ipam := wbclient.NewKubernetesIPAMWithClient(types.PreReservationContainerID(string(pod.GetUID())), wbclient.SelectionInterface(i, selection), *ipamConfig, ipPoolsNamespace(), *p.client)
+		ipam.NodeName = pod.Spec.NodeName
+
+		allocateCtx, cancel := context.WithTimeout(ctx, types.AddTimeLimit)
+		ipNets, err := p.allocate(allocateCtx, types.Allocate, *ipamConfig, ipam)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to pre-reserve the IPs of network %s/%s: %w", netAttachDefNamespace, selection.Name, err)
+		}
+		for _, ipNet := range ipNets {
+			preReservedIPs = append(preReservedIPs, ipNet.IP.String())
+		}
+	}
+	if len(preReservedIPs) == 0 {
+		return nil
+	}
+	logging.Verbosef("pre-reserved IPs %v for pod %s on node %s", preReservedIPs, key, pod.Spec.NodeName)
+
+	pod = pod.DeepCopy()
+	pod.Annotations[PreReservedIPsAnnotation] = strings.Join(preReservedIPs, ",")
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+	_, err = p.k8sClient.CoreV1().Pods(namespace).Update(ctxWithTimeout, pod, metav1.UpdateOptions{})
+	return err
+}
+
+func (p *preReservations) enqueue(obj interface{}) {
+	pod, isPod := obj.(*v1.Pod)
+	if !isPod || !awaitsPreReservation(pod) {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(pod)
+	if err != nil {
+		_ = logging.Errorf("failed to get the key of pod %+v: %v", pod, err)
+		return
+	}
+	p.workqueue.Add(key)
+}
+
+// awaitsPreReservation tells whether the pod asks for its IPs to be pre-reserved, is bound to a node, and neither had
+// them pre-reserved yet nor had its interfaces added
+func awaitsPreReservation(pod *v1.Pod) bool {
+	annotations := pod.GetAnnotations()
+	if annotations[PreReserveAnnotation] != "true" || pod.Spec.NodeName == "" || pod.GetDeletionTimestamp() != nil {
+		return false
+	}
+	_, preReserved := annotations[PreReservedIPsAnnotation]
+	_, added := annotations[nadv1.NetworkStatusAnnot]
+	return !preReserved && !added
+}
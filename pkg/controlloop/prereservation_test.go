@@ -0,0 +1,161 @@
+package controlloop
+
+import (
+	"context"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	v1coreinformerfactory "k8s.io/client-go/informers"
+	k8sclient "k8s.io/client-go/kubernetes"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+
+	nad "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	nadinformers "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions"
+
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/platform"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+var _ = Describe("Pre-reservations", func() {
+	const (
+		namespace = "default"
+		nodeName  = "node1"
+	)
+
+	type preReservation struct {
+		podRef   string
+		podUID   string
+		ifName   string
+		nodeName string
+	}
+
+	var (
+		mountPath string
+		k8sClient k8sclient.Interface
+		cancel    context.CancelFunc
+
+		lock            sync.Mutex
+		preReservations []preReservation
+	)
+
+	boundPod := func(name, node, networks string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				UID:       k8stypes.UID(name + "-uid"),
+				Annotations: map[string]string{
+					PreReserveAnnotation:       "true",
+					nad.NetworkAttachmentAnnot: networks,
+				},
+			},
+			Spec: v1.PodSpec{NodeName: node},
+		}
+	}
+
+	BeforeEach(func() {
+		const configFilePermissions = 0755
+
+		var err error
+		mountPath, err = os.MkdirTemp("", "prereservation")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.MkdirAll(path.Join(mountPath, path.Dir(platform.WhereaboutsConfigPath)), configFilePermissions)).To(Succeed())
+		Expect(os.WriteFile(
+			path.Join(mountPath, platform.WhereaboutsConfigPath),
+			[]byte(dummyWhereaboutsConfig()), configFilePermissions)).To(Succeed())
+
+		unbound := boundPod("unbound", "", "blue-net")
+		optedOut := boundPod("opted-out", nodeName, "blue-net")
+		delete(optedOut.Annotations, PreReserveAnnotation)
+		k8sClient = fakek8sclient.NewSimpleClientset(
+			boundPod("bound", nodeName, "other-net,blue-net@eth1,default/red-net"), unbound, optedOut)
+		nadClient, err := newFakeNetAttachDefClient(namespace,
+			netAttachDef("blue-net", namespace, dummyNetSpec("blue-net", "10.0.0.0/24")),
+			netAttachDef("red-net", namespace, dummyNetSpec("red-net", "10.1.0.0/24")),
+			netAttachDef("other-net", namespace, `{"cniVersion": "0.3.0", "name": "other-net", "type": "macvlan", "ipam": {"type": "dhcp"}}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		lock.Lock()
+		preReservations = nil
+		lock.Unlock()
+		allocate := func(_ context.Context, mode int, ipamConf types.IPAMConfig, client *kubernetes.KubernetesIPAM) ([]net.IPNet, error) {
+			Expect(mode).To(Equal(types.Allocate))
+			lock.Lock()
+			defer lock.Unlock()
+			preReservations = append(preReservations, preReservation{
+				podRef: ipamConf.GetPodRef(), podUID: ipamConf.PodUID, ifName: client.IfName, nodeName: client.NodeName,
+			})
+			_, ipNet, _ := net.ParseCIDR(ipamConf.IPRanges[0].Range)
+			ipNet.IP[len(ipNet.IP)-1] = byte(len(preReservations))
+			return []net.IPNet{*ipNet}, nil
+		}
+
+		podInformerFactory := v1coreinformerfactory.NewSharedInformerFactory(k8sClient, noResyncPeriod)
+		nadInformerFactory := nadinformers.NewSharedInformerFactory(nadClient, noResyncPeriod)
+		controller := newPreReservations(k8sClient, kubernetes.NewKubernetesClient(fakewbclient.NewSimpleClientset(), k8sClient),
+			podInformerFactory, nadInformerFactory, allocate)
+		controller.mountPath = mountPath
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		podInformerFactory.Start(ctx.Done())
+		nadInformerFactory.Start(ctx.Done())
+		go controller.run(ctx)
+	})
+
+	AfterEach(func() {
+		cancel()
+		Expect(os.RemoveAll(mountPath)).To(Succeed())
+	})
+
+	allocated := func() []preReservation {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]preReservation{}, preReservations...)
+	}
+
+	podAnnotation := func(name string) func() (string, error) {
+		return func() (string, error) {
+			pod, err := k8sClient.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+			if err != nil {
+				return "", err
+			}
+			return pod.GetAnnotations()[PreReservedIPsAnnotation], nil
+		}
+	}
+
+	It("pre-reserves the IPs of the whereabouts networks of the bound pods, on the interfaces multus names", func() {
+		Eventually(podAnnotation("bound")).Should(Equal("10.0.0.1,10.1.0.2"))
+		Expect(allocated()).To(Equal([]preReservation{
+			{podRef: "default/bound", podUID: "bound-uid", ifName: "eth1", nodeName: nodeName},
+			{podRef: "default/bound", podUID: "bound-uid", ifName: "net3", nodeName: nodeName},
+		}))
+		Consistently(allocated).Should(HaveLen(2))
+	})
+
+	It("pre-reserves the IPs of a pod once it is bound to a node", func() {
+		Consistently(podAnnotation("unbound"), time.Second).Should(BeEmpty())
+		Expect(podAnnotation("opted-out")()).To(BeEmpty())
+
+		pod, err := k8sClient.CoreV1().Pods(namespace).Get(context.TODO(), "unbound", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		pod.Spec.NodeName = "node2"
+		_, err = k8sClient.CoreV1().Pods(namespace).Update(context.TODO(), pod, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(podAnnotation("unbound")).ShouldNot(BeEmpty())
+		Expect(allocated()).To(ContainElement(preReservation{podRef: "default/unbound", podUID: "unbound-uid", ifName: "net1", nodeName: "node2"}))
+		Expect(podAnnotation("opted-out")()).To(BeEmpty())
+	})
+})
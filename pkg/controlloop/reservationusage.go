@@ -0,0 +1,230 @@
+package controlloop
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
+	nadinformers "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions"
+	nadlister "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/listers/k8s.cni.cncf.io/v1"
+
+	wbclientset "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+	wblister "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/listers/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+const (
+	reservationUsageLeaseName = "whereabouts-reservation-usage"
+
+	// ReservationUsageConfigMapName names the ConfigMap, in the namespace of the IP pools, holding the number of
+	// overlapping range reservations of each network
+	ReservationUsageConfigMapName = "whereabouts-reservation-usage"
+
+	// ReservationUsageKey holds the usage of the overlapping range reservations as a JSON ReservationUsage
+	ReservationUsageKey = "usage"
+
+	// reservationUsageDelay coalesces the bursts of reservation changes, e.g. the ones of a rollout, into a single
+	// rendering of the usage
+	reservationUsageDelay = 5 * time.Second
+
+	// reservationUsageItem is the single item of the work queue: the bursts of changes coalesce into it
+	reservationUsageItem = "reservation-usage"
+)
+
+// ReservationUsage is the number of overlapping range reservations, in total and by network label: the network name
+// on named networks, the range otherwise, and UnknownNetwork for the reservations of no known network
+type ReservationUsage struct {
+	Total int            `json:"total"`
+	Used  map[string]int `json:"used"`
+}
+
+// RunReservationUsage competes with the other control loop instances for a cluster-wide lease. While holding it, it
+// keeps the number of overlapping range reservations of each network up to date in the ReservationUsageConfigMapName
+// ConfigMap, sparing the admins the listing of the reservations of the whole cluster. It blocks until the context is
+// cancelled.
+func RunReservationUsage(ctx context.Context, identity string, k8sClient kubernetes.Interface, wbClient wbclientset.Interface, nadClient nadclient.Interface) {
+	RunWhileLeading(ctx, reservationUsageLeaseName, identity, k8sClient, "count the overlapping range reservations of the networks", func(leaderCtx context.Context) {
+		wbInformerFactory := wbinformers.NewSharedInformerFactory(wbClient, noResyncPeriod)
+		nadInformerFactory := nadinformers.NewSharedInformerFactory(nadClient, noResyncPeriod)
+
+		usage := newReservationUsage(k8sClient, wbInformerFactory, nadInformerFactory, reservationUsageDelay)
+
+		wbInformerFactory.Start(leaderCtx.Done())
+		nadInformerFactory.Start(leaderCtx.Done())
+
+		usage.run(leaderCtx)
+	})
+}
+
+// reservationUsage renders the usage ConfigMap once the overlapping range reservations, or the
+// network-attachment-definitions telling their networks, stop changing for the delay
+type reservationUsage struct {
+	k8sClient          kubernetes.Interface
+	reservationLister  wblister.OverlappingRangeIPReservationLister
+	netAttachDefLister nadlister.NetworkAttachmentDefinitionLister
+	synced             []cache.InformerSynced
+	workqueue          workqueue.TypedRateLimitingInterface[string]
+	delay              time.Duration
+}
+
+func newReservationUsage(k8sClient kubernetes.Interface, wbInformerFactory wbinformers.SharedInformerFactory, nadInformerFactory nadinformers.SharedInformerFactory, delay time.Duration) *reservationUsage {
+	reservationInformer := wbInformerFactory.Whereabouts().V1alpha1().OverlappingRangeIPReservations()
+	netAttachDefInformer := nadInformerFactory.K8sCniCncfIo().V1().NetworkAttachmentDefinitions()
+
+	u := &reservationUsage{
+		k8sClient:          k8sClient,
+		reservationLister:  reservationInformer.Lister(),
+		netAttachDefLister: netAttachDefInformer.Lister(),
+		synced: []cache.InformerSynced{
+			reservationInformer.Informer().HasSynced,
+			netAttachDefInformer.Informer().HasSynced,
+		},
+		workqueue: workqueue.NewTypedRateLimitingQueue[string](
+			workqueue.DefaultTypedControllerRateLimiter[string]()),
+		delay: delay,
+	}
+
+	// the reservations are only counted, hence their updates are of no interest
+	_, _ = reservationInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(_ interface{}) { u.enqueue() },
+		DeleteFunc: func(_ interface{}) { u.enqueue() },
+	})
+	_, _ = netAttachDefInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(_ interface{}) { u.enqueue() },
+		UpdateFunc: func(_, _ interface{}) { u.enqueue() },
+		DeleteFunc: func(_ interface{}) { u.enqueue() },
+	})
+	return u
+}
+
+func (u *reservationUsage) run(ctx context.Context) {
+	defer u.workqueue.ShutDown()
+	if ok := cache.WaitForCacheSync(ctx.Done(), u.synced...); !ok {
+		logging.Verbosef("failed waiting for caches to sync")
+		return
+	}
+	u.workqueue.Add(reservationUsageItem)
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		for u.processNextWorkItem(ctx) {
+		}
+	}, 0)
+	<-ctx.Done()
+}
+
+func (u *reservationUsage) processNextWorkItem(ctx context.Context) bool {
+	key, shouldQuit := u.workqueue.Get()
+	if shouldQuit {
+		return false
+	}
+	defer u.workqueue.Done(key)
+
+	if err := u.sync(ctx); err != nil {
+		_ = logging.Errorf("failed to render the usage of the overlapping range reservations: %v", err)
+		u.workqueue.AddRateLimited(key)
+		return true
+	}
+	u.workqueue.Forget(key)
+	return true
+}
+
+// sync renders the usage ConfigMap
+func (u *reservationUsage) sync(ctx context.Context) error {
+	usage, err := u.count()
+	if err != nil {
+		return err
+	}
+	encodedUsage, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ReservationUsageConfigMapName,
+			Namespace: ipPoolsNamespace(),
+		},
+		Data: map[string]string{ReservationUsageKey: string(encodedUsage)},
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	current, err := u.k8sClient.CoreV1().ConfigMaps(configMap.GetNamespace()).Get(ctxWithTimeout, configMap.GetName(), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = u.k8sClient.CoreV1().ConfigMaps(configMap.GetNamespace()).Create(ctxWithTimeout, configMap, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(current.Data, configMap.Data) {
+		return nil
+	}
+	current = current.DeepCopy()
+	current.Data = configMap.Data
+	_, err = u.k8sClient.CoreV1().ConfigMaps(configMap.GetNamespace()).Update(ctxWithTimeout, current, metav1.UpdateOptions{})
+	return err
+}
+
+// count tells the network of each overlapping range reservation through the network-attachment-definitions
+func (u *reservationUsage) count() (*ReservationUsage, error) {
+	netAttachDefs, err := u.netAttachDefLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	listedNetAttachDefs := make([]nadv1.NetworkAttachmentDefinition, 0, len(netAttachDefs))
+	for _, netAttachDef := range netAttachDefs {
+		listedNetAttachDefs = append(listedNetAttachDefs, *netAttachDef)
+	}
+	networks := wbclient.NewWhereaboutsNetworks(listedNetAttachDefs)
+
+	reservations, err := u.reservationLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	usage := &ReservationUsage{Used: map[string]int{}}
+	for _, reservation := range reservations {
+		usage.Total++
+		usage.Used[reservationNetwork(networks, reservation.GetName())]++
+	}
+	return usage, nil
+}
+
+func (u *reservationUsage) enqueue() {
+	u.workqueue.AddAfter(reservationUsageItem, u.delay)
+}
+
+// reservationNetwork returns the label of the network of the overlapping range reservation. Its name holds the
+// normalized IP, prefixed by the network name on named networks: each suffix of the name making up a valid IP is
+// tried, as network names may hold dashes too.
+func reservationNetwork(networks wbclient.WhereaboutsNetworks, reservationName string) string {
+	for idx := -1; idx < len(reservationName); idx++ {
+		if idx >= 0 && reservationName[idx] != '-' {
+			continue
+		}
+		ip := net.ParseIP(strings.ReplaceAll(reservationName[idx+1:], "-", ":"))
+		if ip == nil {
+			continue
+		}
+		if network := networks.ClusterWideIPNetwork(reservationName, ip); network != wbclient.UnknownNetwork {
+			return network
+		}
+	}
+	return wbclient.UnknownNetwork
+}
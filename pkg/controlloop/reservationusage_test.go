@@ -0,0 +1,111 @@
+package controlloop
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "k8s.io/client-go/kubernetes"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+
+	nadinformers "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+var _ = Describe("Overlapping range reservation usage", func() {
+	const (
+		namespace   = "default"
+		networkName = "blue-net"
+	)
+
+	var (
+		k8sClient k8sclient.Interface
+		wbClient  wbclient.Interface
+		cancel    context.CancelFunc
+	)
+
+	reservation := func(name string) *v1alpha1.OverlappingRangeIPReservation {
+		return &v1alpha1.OverlappingRangeIPReservation{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ipPoolsNamespace()},
+			Spec:       v1alpha1.OverlappingRangeIPReservationSpec{PodRef: "default/pod"},
+		}
+	}
+
+	BeforeEach(func() {
+		named := netAttachDef(networkName, namespace, `{
+			"cniVersion": "0.3.0",
+			"name": "blue-net",
+			"type": "macvlan",
+			"ipam": {"type": "whereabouts", "range": "10.0.0.0/24", "network_name": "blue-net"}
+		}`)
+		unnamed := netAttachDef("other-net", namespace, dummyNetSpec("other-net", "fd00::/64"))
+
+		k8sClient = fakek8sclient.NewSimpleClientset()
+		wbClient = fakewbclient.NewSimpleClientset(
+			reservation("blue-net-10.0.0.1"),
+			reservation("blue-net-10.0.0.2"),
+			reservation("fd00--5"),
+			reservation("10.9.9.9"))
+		nadClient, err := newFakeNetAttachDefClient(namespace, named, unnamed)
+		Expect(err).NotTo(HaveOccurred())
+
+		wbInformerFactory := wbinformers.NewSharedInformerFactory(wbClient, noResyncPeriod)
+		nadInformerFactory := nadinformers.NewSharedInformerFactory(nadClient, noResyncPeriod)
+		const noDelay = 0
+		usage := newReservationUsage(k8sClient, wbInformerFactory, nadInformerFactory, noDelay)
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		wbInformerFactory.Start(ctx.Done())
+		nadInformerFactory.Start(ctx.Done())
+		go usage.run(ctx)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	currentUsage := func() (*ReservationUsage, error) {
+		configMap, err := k8sClient.CoreV1().ConfigMaps(ipPoolsNamespace()).Get(context.TODO(), ReservationUsageConfigMapName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		usage := &ReservationUsage{}
+		return usage, json.Unmarshal([]byte(configMap.Data[ReservationUsageKey]), usage)
+	}
+
+	It("counts the reservations of each network", func() {
+		Eventually(currentUsage).Should(Equal(&ReservationUsage{
+			Total: 4,
+			Used: map[string]int{
+				networkName:               2,
+				"fd00::/64":               1,
+				kubernetes.UnknownNetwork: 1,
+			},
+		}))
+	})
+
+	It("counts the reservations anew once one is deleted", func() {
+		Eventually(currentUsage).Should(HaveField("Total", 4))
+
+		Expect(wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(ipPoolsNamespace()).Delete(
+			context.TODO(), "blue-net-10.0.0.1", metav1.DeleteOptions{})).To(Succeed())
+
+		Eventually(currentUsage).Should(Equal(&ReservationUsage{
+			Total: 3,
+			Used: map[string]int{
+				networkName:               1,
+				"fd00::/64":               1,
+				kubernetes.UnknownNetwork: 1,
+			},
+		}))
+	})
+})
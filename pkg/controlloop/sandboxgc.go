@@ -0,0 +1,248 @@
+package controlloop
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
+
+	wbclientset "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// DefaultSandboxGCInterval is the default period between two collections of the addresses of vanished sandboxes
+const DefaultSandboxGCInterval = 5 * time.Minute
+
+// SandboxLister lists the ids of the pod sandboxes the container runtime of the node knows of
+type SandboxLister func(ctx context.Context) (map[string]struct{}, error)
+
+// CrictlSandboxLister lists the pod sandboxes through crictl, talking to the container runtime at the given endpoint -
+// or at the one crictl is configured with, when empty.
+func CrictlSandboxLister(crictlPath, runtimeEndpoint string) SandboxLister {
+	return func(ctx context.Context) (map[string]struct{}, error) {
+		args := []string{"pods", "--quiet", "--no-trunc"}
+		if runtimeEndpoint != "" {
+			args = append([]string{"--runtime-endpoint", runtimeEndpoint}, args...)
+		}
+		out, err := exec.CommandContext(ctx, crictlPath, args...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the pod sandboxes of the container runtime: %w", err)
+		}
+
+		sandboxes := map[string]struct{}{}
+		for _, line := range strings.Split(string(out), "\n") {
+			if sandboxID := strings.TrimSpace(line); sandboxID != "" {
+				sandboxes[sandboxID] = struct{}{}
+			}
+		}
+		return sandboxes, nil
+	}
+}
+
+// ContainerIDResolver returns the id of the ready sandbox of the pod, which whereabouts records as the container id of
+// its allocations, or "" when the container runtime of the node has none
+type ContainerIDResolver func(ctx context.Context, namespace, name string) (string, error)
+
+// CrictlContainerIDResolver resolves the sandboxes of the pods through crictl, talking to the container runtime at the
+// given endpoint - or at the one crictl is configured with, when empty.
+func CrictlContainerIDResolver(crictlPath, runtimeEndpoint string) ContainerIDResolver {
+	return func(ctx context.Context, namespace, name string) (string, error) {
+		// crictl matches the names and namespaces as regular expressions
+		args := []string{"pods", "--quiet", "--no-trunc", "--state", "ready",
+			"--namespace", "^" + regexp.QuoteMeta(namespace) + "$", "--name", "^" + regexp.QuoteMeta(name) + "$"}
+		if runtimeEndpoint != "" {
+			args = append([]string{"--runtime-endpoint", runtimeEndpoint}, args...)
+		}
+		out, err := exec.CommandContext(ctx, crictlPath, args...).Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to find the sandbox of pod %s/%s in the container runtime: %w", namespace, name, err)
+		}
+
+		sandboxIDs := strings.Fields(string(out))
+		if len(sandboxIDs) > 1 {
+			return "", fmt.Errorf("pod %s/%s has %d ready sandboxes", namespace, name, len(sandboxIDs))
+		}
+		if len(sandboxIDs) == 0 {
+			return "", nil
+		}
+		return sandboxIDs[0], nil
+	}
+}
+
+// SandboxGarbageCollector releases the addresses allocated to pod sandboxes which vanished without whereabouts hearing
+// of it, e.g. sandboxes failing before their pause container started. Their pods never list these addresses in their
+// network-status annotation, hence neither the pod controller nor the reconciler can tell them apart from the
+// addresses of sandboxes being set up. An allocation of a pod of the node is released once its container id has been
+// missing from the container runtime for longer than the grace period, unless the pod lists its IP by then.
+type SandboxGarbageCollector struct {
+	k8sClient     kubernetes.Interface
+	client        *wbclient.Client
+	nodeName      string
+	listSandboxes SandboxLister
+	gracePeriod   time.Duration
+	// missingSince records when the container ids were first found missing from the container runtime
+	missingSince map[string]time.Time
+	now          func() time.Time
+}
+
+// NewSandboxGarbageCollector returns a SandboxGarbageCollector for the allocations of the pods of the given node
+func NewSandboxGarbageCollector(k8sClient kubernetes.Interface, wbClient wbclientset.Interface, nadClient nadclient.Interface, nodeName string, listSandboxes SandboxLister, gracePeriod time.Duration) *SandboxGarbageCollector {
+	return &SandboxGarbageCollector{
+		k8sClient:     k8sClient,
+		client:        wbclient.NewKubernetesClientWithNetAttachDefs(wbClient, k8sClient, nadClient),
+		nodeName:      nodeName,
+		listSandboxes: listSandboxes,
+		gracePeriod:   gracePeriod,
+		missingSince:  map[string]time.Time{},
+		now:           time.Now,
+	}
+}
+
+// Run collects the addresses of vanished sandboxes every interval, until the context is cancelled
+func (gc *SandboxGarbageCollector) Run(ctx context.Context, interval time.Duration) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		releasedIPs, err := gc.collect(ctx)
+		if err != nil {
+			_ = logging.Errorf("failed to collect the addresses of vanished sandboxes: %v", err)
+		}
+		if len(releasedIPs) > 0 {
+			logging.Verbosef("released the addresses of vanished sandboxes: %v", releasedIPs)
+		}
+	}, interval)
+}
+
+func (gc *SandboxGarbageCollector) collect(ctx context.Context) ([]net.IP, error) {
+	podIPs, err := gc.nodePodIPs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// never release anything without knowing which sandboxes exist
+	sandboxes, err := gc.listSandboxes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	netAttachDefs, err := gc.client.ListNetAttachDefs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the network-attachment-definitions: %w", err)
+	}
+	unreconciledNetworks := wbclient.NewUnreconciledNetworks(netAttachDefs)
+
+	pools, err := gc.client.ListIPPools(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the IP pools: %w", err)
+	}
+
+	now := gc.now()
+	missingSince := map[string]time.Time{}
+	var released []wbclient.ReleasedAllocations
+	var releasedIPs []net.IP
+	var updateErr error
+	for _, pool := range pools {
+		if k8sPool, ok := pool.(*wbclient.KubernetesIPPool); ok && unreconciledNetworks.ContainsPool(k8sPool.Name(), k8sPool.Range()) {
+			continue
+		}
+
+		var remaining []types.IPReservation
+		var poolReleasedIPs []net.IP
+		var poolReleased []types.IPReservation
+		for _, allocation := range pool.Allocations() {
+			ips, onNode := podIPs[allocation.PodRef]
+			_, isListed := ips[allocation.IP.String()]
+			_, sandboxExists := sandboxes[allocation.ContainerID]
+			// the pre-reservations of the pods of the node precede their sandboxes
+			if !onNode || isListed || sandboxExists || allocation.ContainerID == "" || types.IsPreReservation(allocation.ContainerID) {
+				remaining = append(remaining, allocation)
+				continue
+			}
+
+			since, found := gc.missingSince[allocation.ContainerID]
+			if !found {
+				since = now
+			}
+			missingSince[allocation.ContainerID] = since
+			if now.Sub(since) < gc.gracePeriod {
+				remaining = append(remaining, allocation)
+				continue
+			}
+
+			logging.Debugf("releasing IP %s of pod %s: its sandbox %s vanished %s ago", allocation.IP, allocation.PodRef, allocation.ContainerID, now.Sub(since))
+			poolReleased = append(poolReleased, allocation)
+			poolReleasedIPs = append(poolReleasedIPs, allocation.IP)
+		}
+		if len(poolReleasedIPs) == 0 {
+			continue
+		}
+
+		requestCtx, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+		err := pool.Update(requestCtx, remaining)
+		cancel()
+		if err != nil {
+			// the allocations are released on the next run
+			updateErr = fmt.Errorf("failed to update the reservation list: %w", err)
+			continue
+		}
+		released = append(released, wbclient.ReleasedAllocations{Pool: pool, Allocations: poolReleased})
+		for _, allocation := range poolReleased {
+			delete(missingSince, allocation.ContainerID)
+		}
+		releasedIPs = append(releasedIPs, poolReleasedIPs...)
+	}
+	gc.missingSince = missingSince
+
+	if len(released) > 0 {
+		if err := gc.client.ReleaseOverlappingIPs(ctx, released); err != nil {
+			return releasedIPs, err
+		}
+	}
+	return releasedIPs, updateErr
+}
+
+// nodePodIPs indexes the IPs listed in the network-status annotation of the pods of the node by pod reference
+func (gc *SandboxGarbageCollector) nodePodIPs(ctx context.Context) (map[string]map[string]struct{}, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	pods, err := gc.k8sClient.CoreV1().Pods(metav1.NamespaceAll).List(ctxWithTimeout, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector(podControllerFilterKey, gc.nodeName).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the pods of node %s: %w", gc.nodeName, err)
+	}
+
+	podIPs := map[string]map[string]struct{}{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName != gc.nodeName {
+			continue
+		}
+		ips := map[string]struct{}{}
+		ifaceStatuses, err := podNetworkStatus(pod)
+		if err != nil {
+			// a pod whose IPs cannot be told is left alone
+			logging.Debugf("failed to parse the network-status of pod %s: %v", podID(pod.GetNamespace(), pod.GetName()), err)
+			continue
+		}
+		for _, ifaceStatus := range ifaceStatuses {
+			for _, ip := range ifaceStatus.IPs {
+				ips[ip] = struct{}{}
+			}
+		}
+		podIPs[podID(pod.GetNamespace(), pod.GetName())] = ips
+	}
+	return podIPs, nil
+}
@@ -0,0 +1,129 @@
+package controlloop
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+
+	nad "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	fakenadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+)
+
+var _ = Describe("Vanished sandboxes garbage collection", func() {
+	const (
+		namespace   = "default"
+		nodeName    = "node1"
+		gracePeriod = time.Minute
+		ipRange     = "10.0.0.0/24"
+	)
+
+	var (
+		wbClient  wbclient.Interface
+		sandboxes map[string]struct{}
+		now       time.Time
+		gc        *SandboxGarbageCollector
+	)
+
+	BeforeEach(func() {
+		listedPod := podSpec("pod4", namespace, nodeName)
+		listedPod.Annotations[nad.NetworkStatusAnnot] = `[{"name":"default/net","interface":"net1","ips":["10.0.0.4"]}]`
+		k8sClient := fakek8sclient.NewSimpleClientset(
+			podSpec("pod1", namespace, nodeName),
+			podSpec("pod2", namespace, nodeName),
+			podSpec("pod3", namespace, "node2"),
+			listedPod,
+		)
+
+		wbClient = fakewbclient.NewSimpleClientset(
+			&v1alpha1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: ipPoolsNamespace(), ResourceVersion: "1"},
+				Spec: v1alpha1.IPPoolSpec{
+					Range: ipRange,
+					Allocations: map[string]v1alpha1.IPAllocation{
+						"1": {ContainerID: "vanished", PodRef: "default/pod1", IfName: "net1"},
+						"2": {ContainerID: "running", PodRef: "default/pod2", IfName: "net1"},
+						"3": {ContainerID: "other-node", PodRef: "default/pod3", IfName: "net1"},
+						"4": {ContainerID: "listed", PodRef: "default/pod4", IfName: "net1"},
+					},
+				},
+			},
+			&v1alpha1.OverlappingRangeIPReservation{
+				ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.1", Namespace: ipPoolsNamespace()},
+				Spec:       v1alpha1.OverlappingRangeIPReservationSpec{ContainerID: "vanished", PodRef: "default/pod1", IfName: "net1"},
+			},
+		)
+
+		sandboxes = map[string]struct{}{"running": {}}
+		now = time.Now()
+		gc = NewSandboxGarbageCollector(k8sClient, wbClient, fakenadclient.NewSimpleClientset(), nodeName, func(context.Context) (map[string]struct{}, error) {
+			return sandboxes, nil
+		}, gracePeriod)
+		gc.now = func() time.Time { return now }
+	})
+
+	It("keeps the allocations of sandboxes vanished within the grace period", func() {
+		Expect(gc.collect(context.TODO())).To(BeEmpty())
+
+		now = now.Add(gracePeriod / 2)
+		Expect(gc.collect(context.TODO())).To(BeEmpty())
+		Expect(poolAllocations(wbClient)).To(ConsistOf("1", "2", "3", "4"))
+	})
+
+	It("releases the allocations of the node's sandboxes vanished for longer than the grace period", func() {
+		Expect(gc.collect(context.TODO())).To(BeEmpty())
+
+		now = now.Add(gracePeriod)
+		Expect(gc.collect(context.TODO())).To(Equal([]net.IP{net.ParseIP("10.0.0.1")}))
+		Expect(poolAllocations(wbClient)).To(ConsistOf("2", "3", "4"))
+
+		_, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(ipPoolsNamespace()).Get(context.TODO(), "10.0.0.1", metav1.GetOptions{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("restarts the grace period of sandboxes showing up again", func() {
+		Expect(gc.collect(context.TODO())).To(BeEmpty())
+
+		sandboxes["vanished"] = struct{}{}
+		now = now.Add(gracePeriod / 2)
+		Expect(gc.collect(context.TODO())).To(BeEmpty())
+
+		delete(sandboxes, "vanished")
+		now = now.Add(gracePeriod / 2)
+		Expect(gc.collect(context.TODO())).To(BeEmpty())
+		Expect(poolAllocations(wbClient)).To(ConsistOf("1", "2", "3", "4"))
+	})
+
+	It("releases nothing when the sandboxes cannot be listed", func() {
+		Expect(gc.collect(context.TODO())).To(BeEmpty())
+
+		gc.listSandboxes = func(context.Context) (map[string]struct{}, error) {
+			return nil, fmt.Errorf("runtime unavailable")
+		}
+		now = now.Add(gracePeriod)
+		_, err := gc.collect(context.TODO())
+		Expect(err).To(MatchError("runtime unavailable"))
+		Expect(poolAllocations(wbClient)).To(ConsistOf("1", "2", "3", "4"))
+	})
+})
+
+func poolAllocations(wbClient wbclient.Interface) []string {
+	pool, err := wbClient.WhereaboutsV1alpha1().IPPools(ipPoolsNamespace()).Get(context.TODO(), "10.0.0.0-24", metav1.GetOptions{})
+	Expect(err).NotTo(HaveOccurred())
+
+	var offsets []string
+	for offset := range pool.Spec.Allocations {
+		offsets = append(offsets, offset)
+	}
+	return offsets
+}
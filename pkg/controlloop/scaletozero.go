@@ -0,0 +1,346 @@
+package controlloop
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	v1coreinformerfactory "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	v1corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	wbclientset "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+	wblister "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/listers/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+)
+
+const (
+	scaleToZeroLeaseName = "whereabouts-scale-to-zero"
+
+	// AllocationsReleasedReason is the reason of the event emitted on a workload scaled to zero, once the IP addresses
+	// of all its pods are released
+	AllocationsReleasedReason = "IPAllocationsReleased"
+
+	replicaSetKind  = "ReplicaSet"
+	statefulSetKind = "StatefulSet"
+)
+
+// RunScaleToZeroNotifier competes with the other control loop instances for a cluster-wide lease. While holding it,
+// it watches the ReplicaSets and StatefulSets matching the selector: once one is scaled to zero, and the IP addresses
+// of all the pods it had are released, it emits an IPAllocationsReleased event on it. It blocks until the context is
+// cancelled.
+func RunScaleToZeroNotifier(ctx context.Context, identity string, k8sClient kubernetes.Interface, wbClient wbclientset.Interface, recorder record.EventRecorder, selector labels.Selector) {
+	RunWhileLeading(ctx, scaleToZeroLeaseName, identity, k8sClient, "notify the release of the IP addresses of workloads scaled to zero", func(leaderCtx context.Context) {
+		workloadInformerFactory := v1coreinformerfactory.NewSharedInformerFactoryWithOptions(k8sClient, noResyncPeriod,
+			v1coreinformerfactory.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.LabelSelector = selector.String()
+			}))
+		podInformerFactory := v1coreinformerfactory.NewSharedInformerFactory(k8sClient, noResyncPeriod)
+		wbInformerFactory := wbinformers.NewSharedInformerFactory(wbClient, noResyncPeriod)
+
+		notifier := newScaleToZeroNotifier(workloadInformerFactory, podInformerFactory, wbInformerFactory, recorder)
+
+		workloadInformerFactory.Start(leaderCtx.Done())
+		podInformerFactory.Start(leaderCtx.Done())
+		wbInformerFactory.Start(leaderCtx.Done())
+
+		notifier.run(leaderCtx)
+	})
+}
+
+// scaleToZeroNotifier tracks the pods of the selected workloads, for their allocations to be looked up once the pods
+// are gone. The pods deleted before it starts - e.g. while another control loop instance held the lease - are unknown
+// to it: a workload it saw no pod of is never notified.
+type scaleToZeroNotifier struct {
+	recorder          record.EventRecorder
+	replicaSetLister  appslisters.ReplicaSetLister
+	statefulSetLister appslisters.StatefulSetLister
+	podInformer       cache.SharedIndexInformer
+	podLister         v1corelisters.PodLister
+	ipPoolLister      wblister.IPPoolLister
+	// workloadsSynced tell the workloads are listed, which the pods are tracked after
+	workloadsSynced  []cache.InformerSynced
+	synced           []cache.InformerSynced
+	workqueue        workqueue.TypedRateLimitingInterface[string]
+	workloadPodsLock sync.Mutex
+	// workloadPods are the references of the pods of each workload, by workload key, since its last notification
+	workloadPods map[string]map[string]struct{}
+}
+
+func newScaleToZeroNotifier(workloadInformerFactory, podInformerFactory v1coreinformerfactory.SharedInformerFactory, wbInformerFactory wbinformers.SharedInformerFactory, recorder record.EventRecorder) *scaleToZeroNotifier {
+	replicaSetInformer := workloadInformerFactory.Apps().V1().ReplicaSets()
+	statefulSetInformer := workloadInformerFactory.Apps().V1().StatefulSets()
+	podInformer := podInformerFactory.Core().V1().Pods()
+	ipPoolInformer := wbInformerFactory.Whereabouts().V1alpha1().IPPools()
+
+	n := &scaleToZeroNotifier{
+		recorder:          recorder,
+		replicaSetLister:  replicaSetInformer.Lister(),
+		statefulSetLister: statefulSetInformer.Lister(),
+		podInformer:       podInformer.Informer(),
+		podLister:         podInformer.Lister(),
+		ipPoolLister:      ipPoolInformer.Lister(),
+		workloadsSynced: []cache.InformerSynced{
+			replicaSetInformer.Informer().HasSynced,
+			statefulSetInformer.Informer().HasSynced,
+		},
+		synced: []cache.InformerSynced{
+			podInformer.Informer().HasSynced,
+			ipPoolInformer.Informer().HasSynced,
+		},
+		workqueue: workqueue.NewTypedRateLimitingQueue[string](
+			workqueue.DefaultTypedControllerRateLimiter[string]()),
+		workloadPods: map[string]map[string]struct{}{},
+	}
+
+	for kind, informer := range map[string]cache.SharedIndexInformer{
+		replicaSetKind:  replicaSetInformer.Informer(),
+		statefulSetKind: statefulSetInformer.Informer(),
+	} {
+		kind := kind
+		_, _ = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { n.enqueueWorkload(kind, obj) },
+			UpdateFunc: func(_, newObj interface{}) { n.enqueueWorkload(kind, newObj) },
+			DeleteFunc: func(obj interface{}) { n.forgetWorkload(kind, obj) },
+		})
+	}
+	_, _ = ipPoolInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(_, _ interface{}) { n.enqueueTrackedWorkloads() },
+		DeleteFunc: func(_ interface{}) { n.enqueueTrackedWorkloads() },
+	})
+	return n
+}
+
+func (n *scaleToZeroNotifier) run(ctx context.Context) {
+	defer n.workqueue.ShutDown()
+	// the pods are tracked once the workloads are listed, lest the pods of the workloads not listed yet be dropped: the
+	// pods listed already are handed to the handler as it is added
+	if ok := cache.WaitForCacheSync(ctx.Done(), n.workloadsSynced...); !ok {
+		logging.Verbosef("failed waiting for caches to sync")
+		return
+	}
+	podRegistration, err := n.podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    n.trackPod,
+		UpdateFunc: func(_, newObj interface{}) { n.trackPod(newObj) },
+		DeleteFunc: n.onPodDelete,
+	})
+	if err != nil {
+		_ = logging.Errorf("failed to watch the pods: %v", err)
+		return
+	}
+	defer func() { _ = n.podInformer.RemoveEventHandler(podRegistration) }()
+	if ok := cache.WaitForCacheSync(ctx.Done(), append(n.synced, podRegistration.HasSynced)...); !ok {
+		logging.Verbosef("failed waiting for caches to sync")
+		return
+	}
+	go wait.UntilWithContext(ctx, func(ctx context.Context) {
+		for n.processNextWorkItem() {
+		}
+	}, 0)
+	<-ctx.Done()
+}
+
+func (n *scaleToZeroNotifier) processNextWorkItem() bool {
+	key, shouldQuit := n.workqueue.Get()
+	if shouldQuit {
+		return false
+	}
+	defer n.workqueue.Done(key)
+
+	if err := n.sync(key); err != nil {
+		_ = logging.Errorf("failed to check the IP addresses of workload %s: %v", key, err)
+		n.workqueue.AddRateLimited(key)
+		return true
+	}
+	n.workqueue.Forget(key)
+	return true
+}
+
+// sync emits the event of the workload once it is scaled to zero, its pods are gone, and so are their allocations
+func (n *scaleToZeroNotifier) sync(key string) error {
+	workload, uid, replicas, err := n.workload(key)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if replicas > 0 {
+		return nil
+	}
+
+	podRefs := n.trackedPods(key)
+	if len(podRefs) == 0 {
+		return nil
+	}
+
+	pods, err := n.podLister.Pods(workload.GetNamespace()).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		if owner := metav1.GetControllerOf(pod); owner != nil && owner.UID == uid {
+			logging.Debugf("workload %s scaled to zero still has pod %s", key, pod.GetName())
+			return nil
+		}
+	}
+	pools, err := n.ipPoolLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, pool := range pools {
+		for _, allocation := range pool.Spec.Allocations {
+			if _, found := podRefs[allocation.PodRef]; found {
+				logging.Debugf("workload %s scaled to zero still has IP addresses allocated to pod %s", key, allocation.PodRef)
+				return nil
+			}
+		}
+	}
+
+	n.recorder.Eventf(workload, v1.EventTypeNormal, AllocationsReleasedReason,
+		"Released the IP addresses of the %d pods of the workload scaled to zero", len(podRefs))
+	n.workloadPodsLock.Lock()
+	delete(n.workloadPods, key)
+	n.workloadPodsLock.Unlock()
+	return nil
+}
+
+// workloadObject is a ReplicaSet or a StatefulSet
+type workloadObject interface {
+	runtime.Object
+	metav1.Object
+}
+
+// workload returns the workload of the key, its UID, and its number of replicas - the desired or, while the pods are
+// scaled down, the current one
+func (n *scaleToZeroNotifier) workload(key string) (workloadObject, types.UID, int32, error) {
+	kind, namespace, name, err := splitWorkloadKey(key)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	switch kind {
+	case replicaSetKind:
+		replicaSet, err := n.replicaSetLister.ReplicaSets(namespace).Get(name)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		return replicaSet, replicaSet.GetUID(), max(desiredReplicas(replicaSet.Spec.Replicas), replicaSet.Status.Replicas), nil
+	default:
+		statefulSet, err := n.statefulSetLister.StatefulSets(namespace).Get(name)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		return statefulSet, statefulSet.GetUID(), max(desiredReplicas(statefulSet.Spec.Replicas), statefulSet.Status.Replicas), nil
+	}
+}
+
+func (n *scaleToZeroNotifier) trackedPods(key string) map[string]struct{} {
+	n.workloadPodsLock.Lock()
+	defer n.workloadPodsLock.Unlock()
+	podRefs := make(map[string]struct{}, len(n.workloadPods[key]))
+	for podRef := range n.workloadPods[key] {
+		podRefs[podRef] = struct{}{}
+	}
+	return podRefs
+}
+
+// trackPod records the pod as one of its workload's, provided the workload is selected
+func (n *scaleToZeroNotifier) trackPod(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return
+	}
+	key, found := podWorkloadKey(pod)
+	if !found {
+		return
+	}
+	if _, _, _, err := n.workload(key); err != nil {
+		return
+	}
+
+	n.workloadPodsLock.Lock()
+	defer n.workloadPodsLock.Unlock()
+	if n.workloadPods[key] == nil {
+		n.workloadPods[key] = map[string]struct{}{}
+	}
+	n.workloadPods[key][podID(pod.GetNamespace(), pod.GetName())] = struct{}{}
+}
+
+func (n *scaleToZeroNotifier) onPodDelete(obj interface{}) {
+	pod, err := podFromTombstone(obj)
+	if err != nil {
+		_ = logging.Errorf("%v", err)
+		return
+	}
+	if key, found := podWorkloadKey(pod); found {
+		n.workqueue.Add(key)
+	}
+}
+
+func (n *scaleToZeroNotifier) enqueueWorkload(kind string, obj interface{}) {
+	if workload, ok := obj.(metav1.Object); ok {
+		n.workqueue.Add(workloadKey(kind, workload.GetNamespace(), workload.GetName()))
+	}
+}
+
+func (n *scaleToZeroNotifier) forgetWorkload(kind string, obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if workload, ok := obj.(metav1.Object); ok {
+		n.workloadPodsLock.Lock()
+		delete(n.workloadPods, workloadKey(kind, workload.GetNamespace(), workload.GetName()))
+		n.workloadPodsLock.Unlock()
+	}
+}
+
+// enqueueTrackedWorkloads has the workloads whose pods were seen check their allocations anew
+func (n *scaleToZeroNotifier) enqueueTrackedWorkloads() {
+	n.workloadPodsLock.Lock()
+	defer n.workloadPodsLock.Unlock()
+	for key := range n.workloadPods {
+		n.workqueue.Add(key)
+	}
+}
+
+func podWorkloadKey(pod *v1.Pod) (string, bool) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.APIVersion != appsv1.SchemeGroupVersion.String() || (owner.Kind != replicaSetKind && owner.Kind != statefulSetKind) {
+		return "", false
+	}
+	return workloadKey(owner.Kind, pod.GetNamespace(), owner.Name), true
+}
+
+func workloadKey(kind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", kind, namespace, name)
+}
+
+func splitWorkloadKey(key string) (string, string, string, error) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 {
+		return "", "", "", fmt.Errorf("invalid workload key %q", key)
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+func desiredReplicas(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
@@ -0,0 +1,119 @@
+package controlloop
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1coreinformerfactory "k8s.io/client-go/informers"
+	k8sclient "k8s.io/client-go/kubernetes"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+var _ = Describe("Scale to zero notifications", func() {
+	const (
+		namespace = "default"
+		rsName    = "web"
+		podName   = "web-x8k2p"
+	)
+
+	var (
+		k8sClient k8sclient.Interface
+		wbClient  wbclient.Interface
+		recorder  *record.FakeRecorder
+		cancel    context.CancelFunc
+		pool      = kubernetes.PoolIdentifier{IpRange: "10.0.0.0/24"}
+	)
+
+	BeforeEach(func() {
+		replicas := int32(1)
+		replicaSet := &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{Name: rsName, Namespace: namespace, UID: "web-uid"},
+			Spec:       appsv1.ReplicaSetSpec{Replicas: &replicas},
+			Status:     appsv1.ReplicaSetStatus{Replicas: replicas},
+		}
+		pod := podSpec(podName, namespace, "node1")
+		pod.OwnerReferences = []metav1.OwnerReference{
+			*metav1.NewControllerRef(replicaSet, appsv1.SchemeGroupVersion.WithKind(replicaSetKind)),
+		}
+		k8sClient = fakek8sclient.NewSimpleClientset(replicaSet, pod)
+		wbClient = fakewbclient.NewSimpleClientset(ipPool(pool, ipPoolsNamespace(), podID(namespace, podName)))
+		recorder = record.NewFakeRecorder(10)
+
+		k8sInformerFactory := v1coreinformerfactory.NewSharedInformerFactory(k8sClient, noResyncPeriod)
+		wbInformerFactory := wbinformers.NewSharedInformerFactory(wbClient, noResyncPeriod)
+		notifier := newScaleToZeroNotifier(k8sInformerFactory, k8sInformerFactory, wbInformerFactory, recorder)
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		k8sInformerFactory.Start(ctx.Done())
+		wbInformerFactory.Start(ctx.Done())
+		go notifier.run(ctx)
+		Eventually(func() map[string]struct{} {
+			return notifier.trackedPods(workloadKey(replicaSetKind, namespace, rsName))
+		}).Should(HaveKey(podID(namespace, podName)))
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	scaleToZero := func() {
+		replicaSet, err := k8sClient.AppsV1().ReplicaSets(namespace).Get(context.TODO(), rsName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		replicas := int32(0)
+		replicaSet.Spec.Replicas = &replicas
+		replicaSet.Status.Replicas = replicas
+		_, err = k8sClient.AppsV1().ReplicaSets(namespace).Update(context.TODO(), replicaSet, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient.CoreV1().Pods(namespace).Delete(context.TODO(), podName, metav1.DeleteOptions{})).To(Succeed())
+	}
+
+	It("waits for the allocations of the pods to be released", func() {
+		scaleToZero()
+		Consistently(recorder.Events, 500*time.Millisecond).ShouldNot(Receive())
+
+		_, err := wbClient.WhereaboutsV1alpha1().IPPools(ipPoolsNamespace()).Update(context.TODO(),
+			ipPool(pool, ipPoolsNamespace()), metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(recorder.Events).Should(Receive(Equal(
+			"Normal IPAllocationsReleased Released the IP addresses of the 1 pods of the workload scaled to zero")))
+	})
+
+	It("tracks the pods listed before the workloads", func() {
+		workloadInformerFactory := v1coreinformerfactory.NewSharedInformerFactory(k8sClient, noResyncPeriod)
+		podInformerFactory := v1coreinformerfactory.NewSharedInformerFactory(k8sClient, noResyncPeriod)
+		wbInformerFactory := wbinformers.NewSharedInformerFactory(wbClient, noResyncPeriod)
+		notifier := newScaleToZeroNotifier(workloadInformerFactory, podInformerFactory, wbInformerFactory, recorder)
+
+		ctx, stop := context.WithCancel(context.Background())
+		defer stop()
+		podInformerFactory.Start(ctx.Done())
+		wbInformerFactory.Start(ctx.Done())
+		go notifier.run(ctx)
+		podInformerFactory.WaitForCacheSync(ctx.Done())
+
+		workloadInformerFactory.Start(ctx.Done())
+		Eventually(func() map[string]struct{} {
+			return notifier.trackedPods(workloadKey(replicaSetKind, namespace, rsName))
+		}).Should(HaveKey(podID(namespace, podName)))
+	})
+
+	It("does not notify the workloads which are not scaled to zero", func() {
+		Expect(k8sClient.CoreV1().Pods(namespace).Delete(context.TODO(), podName, metav1.DeleteOptions{})).To(Succeed())
+		_, err := wbClient.WhereaboutsV1alpha1().IPPools(ipPoolsNamespace()).Update(context.TODO(),
+			ipPool(pool, ipPoolsNamespace()), metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Consistently(recorder.Events, 500*time.Millisecond).ShouldNot(Receive())
+	})
+})
@@ -0,0 +1,92 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// the host part of the URLs is irrelevant, the requests always go through the socket
+const daemonURL = "http://whereabouts"
+
+// Client forwards the CNI ADD, DEL and CHECK of the plugin to the daemon
+type Client struct {
+	httpClient *http.Client
+}
+
+// NewClient returns a Client of the daemon listening on the UNIX socket
+func NewClient(socketPath string) *Client {
+	return &Client{
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, unixSocketNetworkType, socketPath)
+				},
+			},
+		},
+	}
+}
+
+// Allocate has the daemon allocate the addresses of the request
+func (c *Client) Allocate(ctx context.Context, request Request) ([]net.IPNet, error) {
+	response, err := c.do(ctx, AllocatePath, request)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IPNet
+	for _, cidr := range response.IPs {
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("the daemon returned an invalid address %q: %w", cidr, err)
+		}
+		ips = append(ips, net.IPNet{IP: ip, Mask: ipNet.Mask})
+	}
+	return ips, nil
+}
+
+// Deallocate has the daemon release the addresses of the request
+func (c *Client) Deallocate(ctx context.Context, request Request) error {
+	_, err := c.do(ctx, DeallocatePath, request)
+	return err
+}
+
+// Check has the daemon verify the addresses of the request are still allocated to the pod
+func (c *Client) Check(ctx context.Context, request Request) error {
+	_, err := c.do(ctx, CheckPath, request)
+	return err
+}
+
+func (c *Client) do(ctx context.Context, path string, request Request) (*Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, daemonURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set(contentTypeHeader, jsonContentType)
+
+	httpResponse, err := c.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the whereabouts daemon: %w", err)
+	}
+	defer httpResponse.Body.Close()
+
+	response := &Response{}
+	if err := json.NewDecoder(httpResponse.Body).Decode(response); err != nil {
+		return nil, fmt.Errorf("invalid response of the whereabouts daemon (status %d): %w", httpResponse.StatusCode, err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("whereabouts daemon: %s", response.Error)
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("whereabouts daemon: unexpected status %d", httpResponse.StatusCode)
+	}
+	return response, nil
+}
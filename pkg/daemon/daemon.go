@@ -0,0 +1,248 @@
+// Package daemon serves the address allocations of the whereabouts CNI plugin from a long-running node process: the
+// plugin no longer loads a kubeconfig and builds a Kubernetes client on each invocation, the daemon shares its own
+// across them.
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/config"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/tracing"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// DefaultSocketPath is the UNIX socket the daemon listens on by default
+const DefaultSocketPath = "/run/whereabouts/whereabouts.sock"
+
+// The paths the daemon serves the CNI ADD, DEL and CHECK of the plugin on
+const (
+	AllocatePath   = "/allocate"
+	DeallocatePath = "/deallocate"
+	CheckPath      = "/check"
+)
+
+const (
+	socketDirPermissions  = 0700
+	socketPermissions     = 0600
+	maxRequestBytes       = 1 << 20
+	readHeaderTimeout     = 5 * time.Second
+	contentTypeHeader     = "Content-Type"
+	jsonContentType       = "application/json"
+	unixSocketNetworkType = "unix"
+)
+
+// Request is a CNI ADD, DEL or CHECK the plugin forwards to the daemon
+type Request struct {
+	// RequestID correlates the log lines of the daemon with those of the plugin; one is generated when unset
+	RequestID   string `json:"requestID,omitempty"`
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifName"`
+	// Args are the CNI_ARGS of the invocation, which tell the pod
+	Args string `json:"args,omitempty"`
+	// Config is the network configuration the plugin was invoked with
+	Config json.RawMessage `json:"config"`
+}
+
+// Response carries the addresses allocated by an ADD, or verified by a CHECK, in CIDR notation, or the error of the
+// request
+type Response struct {
+	IPs   []string `json:"ips,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+type ipManagement func(ctx context.Context, mode int, ipamConf types.IPAMConfig, client *kubernetes.KubernetesIPAM) ([]net.IPNet, error)
+
+type ipCheck func(ctx context.Context, client *kubernetes.KubernetesIPAM, ipamConf types.IPAMConfig) ([]net.IPNet, error)
+
+// Server allocates and releases addresses on behalf of the plugin, through a shared Kubernetes client
+type Server struct {
+	client    kubernetes.Client
+	namespace string
+	manageIPs ipManagement
+	checkIPs  ipCheck
+	// semaphores cap the concurrent requests per IP pool; unlimited when nil
+	semaphores *poolSemaphores
+	// flatConfigPaths are looked for the flat configuration file, on top of the default locations
+	flatConfigPaths []string
+}
+
+// NewServer returns a Server managing the whereabouts resources of the given namespace through the client. The
+// kubeconfig of the network configurations is ignored. The requests share their leader elections, under the hostname.
+// Neither are the logging parameters of the network configurations honored: the logging is set up once, on startup.
+func NewServer(client *kubernetes.Client, namespace string) *Server {
+	server := &Server{
+		client:    *client,
+		namespace: namespace,
+		manageIPs: kubernetes.IPManagement,
+		checkIPs:  kubernetes.CheckAllocations,
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		identity = fmt.Sprintf("whereabouts-daemon-%d", os.Getpid())
+	}
+	server.client.ShareLeaderElections(identity)
+	return server
+}
+
+// ShareInformers has the requests read the pods of the node, and the node slices, from informers shared among them,
+// until the context is done
+func (s *Server) ShareInformers(ctx context.Context, nodeName string) error {
+	return s.client.ShareInformers(ctx, nodeName, s.namespace)
+}
+
+// LimitConcurrentRequests caps the requests the server serves concurrently on each IP pool; the others wait their turn
+// instead of racing for the same pool and retrying on conflicts. A limit of 0 lifts the cap.
+func (s *Server) LimitConcurrentRequests(limit int) {
+	if limit <= 0 {
+		s.semaphores = nil
+		return
+	}
+	s.semaphores = newPoolSemaphores(limit)
+}
+
+// LookForFlatConfig has the server look for the flat configuration file merged with the network configurations of the
+// requests at the given paths, on top of the default locations - e.g. when the flat file of the node is mounted
+// elsewhere in the container serving the requests.
+func (s *Server) LookForFlatConfig(paths ...string) {
+	s.flatConfigPaths = paths
+}
+
+// NewHTTPServer returns the HTTP server of the daemon, to be served on the listener returned by Listen
+func (s *Server) NewHTTPServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(AllocatePath, s.handle(types.Allocate, types.AddTimeLimit))
+	mux.HandleFunc(DeallocatePath, s.handle(types.Deallocate, types.DelTimeLimit))
+	mux.HandleFunc(CheckPath, s.handle(types.Check, types.CheckTimeLimit))
+	return &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+}
+
+func (s *Server) handle(mode int, timeLimit time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var request Request
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&request); err != nil {
+			writeResponse(w, http.StatusBadRequest, &Response{Error: fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+
+		if request.RequestID == "" {
+			request.RequestID = logging.NewRequestID()
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeLimit)
+		defer cancel()
+		ctx = logging.WithFields(ctx, logging.Fields{RequestID: request.RequestID, ContainerID: request.ContainerID, IfName: request.IfName})
+		ips, err := s.Manage(ctx, mode, request)
+		if err != nil {
+			_ = logging.FromContext(ctx).Errorf("failed to serve the request of container %s: %v", request.ContainerID, err)
+			writeResponse(w, http.StatusInternalServerError, &Response{Error: err.Error()})
+			return
+		}
+
+		response := &Response{}
+		for _, ip := range ips {
+			response.IPs = append(response.IPs, ip.String())
+		}
+		writeResponse(w, http.StatusOK, response)
+	}
+}
+
+// Manage serves a request of the given mode - types.Allocate, types.Deallocate or types.Check - returning the addresses
+// allocated, or verified
+func (s *Server) Manage(ctx context.Context, mode int, request Request) ([]net.IPNet, error) {
+	ipamConf, _, err := config.LoadIPAMConfig(request.Config, request.Args, s.flatConfigPaths...)
+	if err != nil {
+		return nil, fmt.Errorf("IPAM configuration load failed: %w", err)
+	}
+	if datastore := storage.DatastoreOf(*ipamConf); datastore != storage.DefaultDatastore {
+		return nil, fmt.Errorf("the whereabouts daemon only serves the %s datastore, not %s", storage.DefaultDatastore, datastore)
+	}
+
+	ctx, finishTrace := tracing.Trace(ctx, ipamConf.TracingEndpoint, "whereabouts.daemon."+types.ModeName(mode),
+		tracing.String("pod", ipamConf.GetPodRef()), tracing.String("interface", request.IfName))
+	defer func() {
+		if exportErr := finishTrace(err); exportErr != nil {
+			logging.Debugf("failed to export the trace: %v", exportErr)
+		}
+	}()
+
+	if s.semaphores != nil {
+		semaphoreCtx, span := tracing.Start(ctx, "daemon.semaphore")
+		var release func()
+		release, err = s.semaphores.Acquire(semaphoreCtx, poolNames(*ipamConf)...)
+		span.End(err)
+		if err != nil {
+			return nil, fmt.Errorf("timed out waiting for a concurrent request on the same IP pool: %w", err)
+		}
+		defer release()
+	}
+
+	fields := logging.FieldsFromContext(ctx)
+	fields.PodRef = ipamConf.GetPodRef()
+	ctx = logging.WithFields(ctx, fields)
+	logging.FromContext(ctx).Debugf("Beginning IPAM (mode: %d) for ContainerID: %q - podRef: %q - ifName: %q", mode, request.ContainerID, ipamConf.GetPodRef(), request.IfName)
+	ipam := kubernetes.NewKubernetesIPAMWithClient(request.ContainerID, request.IfName, *ipamConf, s.namespace, s.client)
+	var ips []net.IPNet
+	if mode == types.Check {
+		ips, err = s.checkIPs(ctx, ipam, *ipamConf)
+	} else {
+		ips, err = s.manageIPs(ctx, mode, *ipamConf, ipam)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error at storage engine: %w", err)
+	}
+	return ips, nil
+}
+
+func poolNames(ipamConf types.IPAMConfig) []string {
+	var names []string
+	for _, ipRange := range ipamConf.IPRanges {
+		names = append(names, kubernetes.IPPoolName(kubernetes.PoolIdentifier{IpRange: ipRange.Range, NetworkName: ipamConf.NetworkName}))
+	}
+	return names
+}
+
+func writeResponse(w http.ResponseWriter, status int, response *Response) {
+	w.Header().Set(contentTypeHeader, jsonContentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logging.Debugf("failed to write the daemon response: %v", err)
+	}
+}
+
+// Listen listens on the UNIX socket, replacing the one a previous daemon left behind. Only the owner of the process -
+// i.e. root, like the container runtime invoking the plugin - can connect.
+func Listen(socketPath string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socketPath), socketDirPermissions); err != nil {
+		return nil, fmt.Errorf("failed to create the directory of socket %s: %w", socketPath, err)
+	}
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove the stale socket %s: %w", socketPath, err)
+	}
+
+	listener, err := net.Listen(unixSocketNetworkType, socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on socket %s: %w", socketPath, err)
+	}
+	if err := os.Chmod(socketPath, socketPermissions); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict the permissions of socket %s: %w", socketPath, err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,160 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+const networkConfig = `{
+  "cniVersion": "0.3.1",
+  "name": "net",
+  "type": "macvlan",
+  "ipam": {
+    "type": "whereabouts",
+    "range": "10.0.0.0/24",
+    "daemon_socket": "/run/whereabouts/whereabouts.sock",
+    "configuration_path": %q
+  }
+}`
+
+func TestDaemon(t *testing.T) {
+	flatConfigPath := filepath.Join(t.TempDir(), "whereabouts.conf")
+	if err := os.WriteFile(flatConfigPath, []byte(`{"log_level": "debug"}`), 0600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	allocated := net.IPNet{IP: net.ParseIP("10.0.0.1"), Mask: net.CIDRMask(24, 32)}
+	cases := []struct {
+		name          string
+		config        string
+		manageErr     error
+		expectedIPs   []net.IPNet
+		expectedError string
+	}{
+		{
+			name:        "Allocation",
+			config:      fmt.Sprintf(networkConfig, flatConfigPath),
+			expectedIPs: []net.IPNet{allocated},
+		},
+		{
+			name:          "Storage error",
+			config:        fmt.Sprintf(networkConfig, flatConfigPath),
+			manageErr:     fmt.Errorf("pool exhausted"),
+			expectedError: "pool exhausted",
+		},
+		{
+			name:          "Invalid network configuration",
+			config:        `{"name": "net"}`,
+			expectedError: "IPAM configuration load failed",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var requestedMode int
+			var requestedPod string
+			server := NewServer(kubernetes.NewKubernetesClient(fakewbclient.NewSimpleClientset(), fakek8sclient.NewSimpleClientset()), "kube-system")
+			server.manageIPs = func(_ context.Context, mode int, ipamConf types.IPAMConfig, _ *kubernetes.KubernetesIPAM) ([]net.IPNet, error) {
+				requestedMode = mode
+				requestedPod = ipamConf.GetPodRef()
+				if tc.manageErr != nil {
+					return nil, tc.manageErr
+				}
+				return []net.IPNet{allocated}, nil
+			}
+			checked := false
+			server.checkIPs = func(_ context.Context, _ *kubernetes.KubernetesIPAM, _ types.IPAMConfig) ([]net.IPNet, error) {
+				checked = true
+				return []net.IPNet{allocated}, nil
+			}
+
+			socketPath := filepath.Join(t.TempDir(), "whereabouts.sock")
+			listener, err := Listen(socketPath)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			httpServer := server.NewHTTPServer()
+			go func() { _ = httpServer.Serve(listener) }()
+			defer httpServer.Close()
+
+			request := Request{
+				ContainerID: "container",
+				IfName:      "net1",
+				Args:        "K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod",
+				Config:      []byte(tc.config),
+			}
+			ips, err := NewClient(socketPath).Allocate(context.TODO(), request)
+			if tc.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Errorf("Expected an error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(ips) != 1 || ips[0].String() != allocated.String() {
+				t.Errorf("Expected %v, got %v", tc.expectedIPs, ips)
+			}
+			if requestedMode != types.Allocate || requestedPod != "default/pod" {
+				t.Errorf("Expected an allocation for pod default/pod, got mode %d for pod %s", requestedMode, requestedPod)
+			}
+
+			if err := NewClient(socketPath).Deallocate(context.TODO(), request); err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if requestedMode != types.Deallocate {
+				t.Errorf("Expected a deallocation, got mode %d", requestedMode)
+			}
+
+			if err := NewClient(socketPath).Check(context.TODO(), request); err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if !checked {
+				t.Errorf("Expected a check of the allocations")
+			}
+		})
+	}
+}
+
+func TestDaemonKeepsItsLogging(t *testing.T) {
+	flatConfigPath := filepath.Join(t.TempDir(), "whereabouts.conf")
+	logFile := filepath.Join(t.TempDir(), "whereabouts.log")
+	if err := os.WriteFile(flatConfigPath, []byte(fmt.Sprintf(`{"log_level": "debug", "log_file": %q}`, logFile)), 0600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	previousLevel := logging.GetLoggingLevel().String()
+	logging.SetLogLevel("error")
+	defer logging.SetLogLevel(previousLevel)
+
+	server := NewServer(kubernetes.NewKubernetesClient(fakewbclient.NewSimpleClientset(), fakek8sclient.NewSimpleClientset()), "kube-system")
+	server.manageIPs = func(_ context.Context, _ int, _ types.IPAMConfig, _ *kubernetes.KubernetesIPAM) ([]net.IPNet, error) {
+		return nil, nil
+	}
+	request := Request{
+		ContainerID: "container",
+		IfName:      "net1",
+		Args:        "K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod",
+		Config:      []byte(fmt.Sprintf(networkConfig, flatConfigPath)),
+	}
+	if _, err := server.Manage(context.TODO(), types.Allocate, request); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if level := logging.GetLoggingLevel(); level != logging.ErrorLevel {
+		t.Errorf("Expected the logging level of the daemon to be kept, got %s", level)
+	}
+	if _, err := os.Stat(logFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the log file of the network configuration not to be opened, got %v", err)
+	}
+}
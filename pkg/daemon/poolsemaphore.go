@@ -0,0 +1,90 @@
+package daemon
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// poolSemaphores caps the concurrent requests per IP pool: a burst of allocations on a single hot network otherwise
+// turns into a storm of conflicting pool updates, each retried, while requests for different pools proceed in
+// parallel.
+type poolSemaphores struct {
+	mu         sync.Mutex
+	limit      int
+	semaphores map[string]*poolSemaphore
+}
+
+type poolSemaphore struct {
+	slots chan struct{}
+	refs  int
+}
+
+func newPoolSemaphores(limit int) *poolSemaphores {
+	return &poolSemaphores{limit: limit, semaphores: map[string]*poolSemaphore{}}
+}
+
+// Acquire takes a slot of the semaphores of all the given pools, waiting until the context is done, and returns a
+// function releasing them. Slots are always taken in lexicographical order, to prevent deadlocks between requests
+// spanning overlapping sets of pools.
+func (ps *poolSemaphores) Acquire(ctx context.Context, poolNames ...string) (func(), error) {
+	names := uniqueSorted(poolNames)
+	acquired := make([]*poolSemaphore, 0, len(names))
+	for _, name := range names {
+		semaphore := ps.reference(name)
+		select {
+		case semaphore.slots <- struct{}{}:
+			acquired = append(acquired, semaphore)
+		case <-ctx.Done():
+			ps.unreference(name)
+			ps.releaseAll(names, acquired)
+			return nil, ctx.Err()
+		}
+	}
+	return func() { ps.releaseAll(names, acquired) }, nil
+}
+
+func (ps *poolSemaphores) releaseAll(names []string, acquired []*poolSemaphore) {
+	for i := len(acquired) - 1; i >= 0; i-- {
+		<-acquired[i].slots
+		ps.unreference(names[i])
+	}
+}
+
+func (ps *poolSemaphores) reference(name string) *poolSemaphore {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	semaphore, found := ps.semaphores[name]
+	if !found {
+		semaphore = &poolSemaphore{slots: make(chan struct{}, ps.limit)}
+		ps.semaphores[name] = semaphore
+	}
+	semaphore.refs++
+	return semaphore
+}
+
+func (ps *poolSemaphores) unreference(name string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	semaphore := ps.semaphores[name]
+	semaphore.refs--
+	if semaphore.refs == 0 {
+		delete(ps.semaphores, name)
+	}
+}
+
+func uniqueSorted(names []string) []string {
+	seen := map[string]struct{}{}
+	var result []string
+	for _, name := range names {
+		if _, found := seen[name]; found {
+			continue
+		}
+		seen[name] = struct{}{}
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPoolSemaphores(t *testing.T) {
+	semaphores := newPoolSemaphores(1)
+
+	release, err := semaphores.Acquire(context.TODO(), "pool-b", "pool-a")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := semaphores.Acquire(ctx, "pool-a"); err == nil {
+		t.Errorf("Expected the pool to be at capacity, got no error")
+	}
+
+	otherRelease, err := semaphores.Acquire(context.TODO(), "pool-c")
+	if err != nil {
+		t.Fatalf("Expected another pool to be available, got %v", err)
+	}
+	otherRelease()
+
+	acquired := make(chan func())
+	go func() {
+		waitingRelease, err := semaphores.Acquire(context.TODO(), "pool-a", "pool-a")
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		acquired <- waitingRelease
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("Expected the request to wait for the pool to be released")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case waitingRelease := <-acquired:
+		waitingRelease()
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the request to acquire the released pool")
+	}
+
+	if len(semaphores.semaphores) != 0 {
+		t.Errorf("Expected no semaphore left, got %d", len(semaphores.semaphores))
+	}
+}
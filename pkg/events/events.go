@@ -0,0 +1,233 @@
+// Package events streams the changes of the whereabouts IP pools and node slice pools as typed events - the
+// allocations added to and released from the pools, and the slices assigned to nodes - for other controllers to
+// embed, rather than diffing the pools themselves.
+package events
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+
+	"k8s.io/client-go/tools/cache"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+)
+
+// DefaultBufferSize is the number of events of each kind buffered for slow consumers
+const DefaultBufferSize = 1024
+
+// OverflowPolicy tells what happens to the events of a kind once its buffer is full
+type OverflowPolicy int
+
+const (
+	// Block has the informers wait for the consumer, which delays the events of all the kinds; the informers keep
+	// buffering the watched changes meanwhile
+	Block OverflowPolicy = iota
+	// Drop discards the events, counting them in Stream.Dropped
+	Drop
+)
+
+// AllocationEvent is an IP allocated in, or released from, an IP pool
+type AllocationEvent struct {
+	Namespace  string
+	Pool       string
+	Range      string
+	IP         net.IP
+	Allocation whereaboutsv1alpha1.IPAllocation
+}
+
+// SliceEvent is a slice of a node slice pool assigned to a node
+type SliceEvent struct {
+	Namespace  string
+	Pool       string
+	Range      string
+	NodeName   string
+	SliceRange string
+}
+
+// Options configures a Stream
+type Options struct {
+	// BufferSize is the number of events of each kind buffered; DefaultBufferSize when 0
+	BufferSize int
+	// OverflowPolicy tells what happens to the events once a buffer is full
+	OverflowPolicy OverflowPolicy
+	// ReplayExisting streams the allocations and slices existing when the informers start as added and assigned
+	ReplayExisting bool
+}
+
+// Stream exposes the events of the pools watched by a shared informer factory
+type Stream struct {
+	ctx                context.Context
+	options            Options
+	allocationAdded    chan AllocationEvent
+	allocationReleased chan AllocationEvent
+	sliceAssigned      chan SliceEvent
+	dropped            atomic.Uint64
+}
+
+// NewStream registers the event handlers of the stream on the IP pool and node slice pool informers of the factory,
+// which the caller then starts. The stream stops sending events once the context is done; its channels are never
+// closed.
+func NewStream(ctx context.Context, informerFactory wbinformers.SharedInformerFactory, options Options) (*Stream, error) {
+	if options.BufferSize <= 0 {
+		options.BufferSize = DefaultBufferSize
+	}
+	s := &Stream{
+		ctx:                ctx,
+		options:            options,
+		allocationAdded:    make(chan AllocationEvent, options.BufferSize),
+		allocationReleased: make(chan AllocationEvent, options.BufferSize),
+		sliceAssigned:      make(chan SliceEvent, options.BufferSize),
+	}
+
+	informers := informerFactory.Whereabouts().V1alpha1()
+	if _, err := informers.IPPools().Informer().AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if !isInInitialList || options.ReplayExisting {
+				s.onIPPoolChange(nil, ipPool(obj))
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) { s.onIPPoolChange(ipPool(oldObj), ipPool(newObj)) },
+		DeleteFunc: func(obj interface{}) { s.onIPPoolChange(ipPool(obj), nil) },
+	}); err != nil {
+		return nil, fmt.Errorf("failed to watch the IP pools: %w", err)
+	}
+	if _, err := informers.NodeSlicePools().Informer().AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if !isInInitialList || options.ReplayExisting {
+				s.onNodeSlicePoolChange(nil, nodeSlicePool(obj))
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			s.onNodeSlicePoolChange(nodeSlicePool(oldObj), nodeSlicePool(newObj))
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to watch the node slice pools: %w", err)
+	}
+	return s, nil
+}
+
+// AllocationAdded streams the IPs allocated in the pools
+func (s *Stream) AllocationAdded() <-chan AllocationEvent {
+	return s.allocationAdded
+}
+
+// AllocationReleased streams the IPs released from the pools, including those of deleted pools
+func (s *Stream) AllocationReleased() <-chan AllocationEvent {
+	return s.allocationReleased
+}
+
+// SliceAssigned streams the slices assigned to nodes
+func (s *Stream) SliceAssigned() <-chan SliceEvent {
+	return s.sliceAssigned
+}
+
+// Dropped returns the number of events discarded by the Drop overflow policy
+func (s *Stream) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *Stream) onIPPoolChange(oldPool, newPool *whereaboutsv1alpha1.IPPool) {
+	oldAllocations := allocations(oldPool)
+	newAllocations := allocations(newPool)
+	for offset, allocation := range oldAllocations {
+		if newAllocation, found := newAllocations[offset]; !found || newAllocation != allocation {
+			if event, ok := allocationEvent(oldPool, offset, allocation); ok {
+				send(s, s.allocationReleased, event)
+			}
+		}
+	}
+	for offset, allocation := range newAllocations {
+		if oldAllocation, found := oldAllocations[offset]; !found || oldAllocation != allocation {
+			if event, ok := allocationEvent(newPool, offset, allocation); ok {
+				send(s, s.allocationAdded, event)
+			}
+		}
+	}
+}
+
+func (s *Stream) onNodeSlicePoolChange(oldPool, newPool *whereaboutsv1alpha1.NodeSlicePool) {
+	if newPool == nil {
+		return
+	}
+	assignedNodes := map[string]string{}
+	if oldPool != nil {
+		for _, allocation := range oldPool.Status.Allocations {
+			assignedNodes[allocation.SliceRange] = allocation.NodeName
+		}
+	}
+	for _, allocation := range newPool.Status.Allocations {
+		if allocation.NodeName == "" || assignedNodes[allocation.SliceRange] == allocation.NodeName {
+			continue
+		}
+		send(s, s.sliceAssigned, SliceEvent{
+			Namespace:  newPool.GetNamespace(),
+			Pool:       newPool.GetName(),
+			Range:      newPool.Spec.Range,
+			NodeName:   allocation.NodeName,
+			SliceRange: allocation.SliceRange,
+		})
+	}
+}
+
+func send[T any](s *Stream, events chan T, event T) {
+	if s.options.OverflowPolicy == Drop {
+		select {
+		case events <- event:
+		default:
+			s.dropped.Add(1)
+			logging.Debugf("dropped event %+v: the consumer is late", event)
+		}
+		return
+	}
+
+	select {
+	case events <- event:
+	case <-s.ctx.Done():
+	}
+}
+
+func allocationEvent(pool *whereaboutsv1alpha1.IPPool, offset string, allocation whereaboutsv1alpha1.IPAllocation) (AllocationEvent, bool) {
+	firstIP, _, err := pool.ParseCIDR()
+	if err != nil {
+		logging.Debugf("skipping the allocations of IP pool %s: %v", pool.GetName(), err)
+		return AllocationEvent{}, false
+	}
+	numOffset, err := strconv.ParseUint(offset, 10, 64)
+	if err != nil {
+		logging.Debugf("skipping the allocation of invalid offset %q of IP pool %s", offset, pool.GetName())
+		return AllocationEvent{}, false
+	}
+	return AllocationEvent{
+		Namespace:  pool.GetNamespace(),
+		Pool:       pool.GetName(),
+		Range:      pool.Spec.Range,
+		IP:         iphelpers.IPAddOffset(firstIP, numOffset),
+		Allocation: allocation,
+	}, true
+}
+
+func allocations(pool *whereaboutsv1alpha1.IPPool) map[string]whereaboutsv1alpha1.IPAllocation {
+	if pool == nil {
+		return nil
+	}
+	return pool.Spec.Allocations
+}
+
+func ipPool(obj interface{}) *whereaboutsv1alpha1.IPPool {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pool, _ := obj.(*whereaboutsv1alpha1.IPPool)
+	return pool
+}
+
+func nodeSlicePool(obj interface{}) *whereaboutsv1alpha1.NodeSlicePool {
+	pool, _ := obj.(*whereaboutsv1alpha1.NodeSlicePool)
+	return pool
+}
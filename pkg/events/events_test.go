@@ -0,0 +1,131 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+)
+
+const (
+	namespace    = "kube-system"
+	eventTimeout = 5 * time.Second
+)
+
+func ipPoolWithAllocations(allocations map[string]whereaboutsv1alpha1.IPAllocation) *whereaboutsv1alpha1.IPPool {
+	return &whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: namespace},
+		Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: allocations},
+	}
+}
+
+func startStream(t *testing.T, ctx context.Context, wbClient *fakewbclient.Clientset, options Options) *Stream {
+	informerFactory := wbinformers.NewSharedInformerFactory(wbClient, 0)
+	stream, err := NewStream(ctx, informerFactory, options)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	informerFactory.Start(ctx.Done())
+	informerFactory.WaitForCacheSync(ctx.Done())
+	return stream
+}
+
+func receive[T any](t *testing.T, events <-chan T) T {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(eventTimeout):
+		t.Fatalf("Expected an event, got none")
+	}
+	var none T
+	return none
+}
+
+func TestAllocationEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	podA := whereaboutsv1alpha1.IPAllocation{ContainerID: "container-a", PodRef: "default/pod-a", IfName: "net1"}
+	podB := whereaboutsv1alpha1.IPAllocation{ContainerID: "container-b", PodRef: "default/pod-b", IfName: "net1"}
+	wbClient := fakewbclient.NewSimpleClientset(ipPoolWithAllocations(map[string]whereaboutsv1alpha1.IPAllocation{"1": podA}))
+	stream := startStream(t, ctx, wbClient, Options{ReplayExisting: true})
+
+	if event := receive(t, stream.AllocationAdded()); event.IP.String() != "10.0.0.1" || event.Allocation != podA {
+		t.Errorf("Expected the existing allocation of 10.0.0.1 to be replayed, got %+v", event)
+	}
+
+	if _, err := wbClient.WhereaboutsV1alpha1().IPPools(namespace).Update(ctx,
+		ipPoolWithAllocations(map[string]whereaboutsv1alpha1.IPAllocation{"2": podB}), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if event := receive(t, stream.AllocationReleased()); event.IP.String() != "10.0.0.1" || event.Allocation != podA {
+		t.Errorf("Expected 10.0.0.1 to be released, got %+v", event)
+	}
+	if event := receive(t, stream.AllocationAdded()); event.IP.String() != "10.0.0.2" || event.Allocation != podB {
+		t.Errorf("Expected 10.0.0.2 to be allocated, got %+v", event)
+	}
+
+	if err := wbClient.WhereaboutsV1alpha1().IPPools(namespace).Delete(ctx, "10.0.0.0-24", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if event := receive(t, stream.AllocationReleased()); event.IP.String() != "10.0.0.2" {
+		t.Errorf("Expected the allocations of the deleted pool to be released, got %+v", event)
+	}
+}
+
+func TestSliceAssignedEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nodeSlicePool := &whereaboutsv1alpha1.NodeSlicePool{
+		ObjectMeta: metav1.ObjectMeta{Name: "net1", Namespace: namespace},
+		Spec:       whereaboutsv1alpha1.NodeSlicePoolSpec{Range: "10.0.0.0/16", SliceSize: "/24"},
+		Status: whereaboutsv1alpha1.NodeSlicePoolStatus{Allocations: []whereaboutsv1alpha1.NodeSliceAllocation{
+			{NodeName: "node-a", SliceRange: "10.0.0.0/24"},
+			{SliceRange: "10.0.1.0/24"},
+		}},
+	}
+	wbClient := fakewbclient.NewSimpleClientset(nodeSlicePool)
+	stream := startStream(t, ctx, wbClient, Options{})
+
+	nodeSlicePool = nodeSlicePool.DeepCopy()
+	nodeSlicePool.Status.Allocations[1].NodeName = "node-b"
+	if _, err := wbClient.WhereaboutsV1alpha1().NodeSlicePools(namespace).Update(ctx, nodeSlicePool, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if event := receive(t, stream.SliceAssigned()); event.NodeName != "node-b" || event.SliceRange != "10.0.1.0/24" {
+		t.Errorf("Expected slice 10.0.1.0/24 to be assigned to node-b, got %+v", event)
+	}
+	select {
+	case event := <-stream.SliceAssigned():
+		t.Errorf("Expected the existing slices not to be replayed, got %+v", event)
+	default:
+	}
+}
+
+func TestDropOverflowPolicy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	allocation := whereaboutsv1alpha1.IPAllocation{ContainerID: "container", PodRef: "default/pod"}
+	wbClient := fakewbclient.NewSimpleClientset(ipPoolWithAllocations(map[string]whereaboutsv1alpha1.IPAllocation{
+		"1": allocation, "2": allocation, "3": allocation,
+	}))
+	stream := startStream(t, ctx, wbClient, Options{BufferSize: 1, OverflowPolicy: Drop, ReplayExisting: true})
+
+	// the handlers keep on going while the buffer is full
+	deadline := time.Now().Add(eventTimeout)
+	for stream.Dropped() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if dropped := stream.Dropped(); dropped != 2 {
+		t.Errorf("Expected 2 dropped events, got %d", dropped)
+	}
+	receive(t, stream.AllocationAdded())
+}
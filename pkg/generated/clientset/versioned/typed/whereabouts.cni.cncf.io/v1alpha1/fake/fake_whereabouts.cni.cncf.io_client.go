@@ -27,6 +27,10 @@ type FakeWhereaboutsV1alpha1 struct {
 	*testing.Fake
 }
 
+func (c *FakeWhereaboutsV1alpha1) FloatingIPClaims(namespace string) v1alpha1.FloatingIPClaimInterface {
+	return &FakeFloatingIPClaims{c, namespace}
+}
+
 func (c *FakeWhereaboutsV1alpha1) IPPools(namespace string) v1alpha1.IPPoolInterface {
 	return &FakeIPPools{c, namespace}
 }
@@ -39,6 +43,10 @@ func (c *FakeWhereaboutsV1alpha1) OverlappingRangeIPReservations(namespace strin
 	return &FakeOverlappingRangeIPReservations{c, namespace}
 }
 
+func (c *FakeWhereaboutsV1alpha1) WhereaboutsConfigs() v1alpha1.WhereaboutsConfigInterface {
+	return &FakeWhereaboutsConfigs{c}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeWhereaboutsV1alpha1) RESTClient() rest.Interface {
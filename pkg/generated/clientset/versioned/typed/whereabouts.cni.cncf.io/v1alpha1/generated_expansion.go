@@ -17,8 +17,12 @@ limitations under the License.
 
 package v1alpha1
 
+type FloatingIPClaimExpansion interface{}
+
 type IPPoolExpansion interface{}
 
 type NodeSlicePoolExpansion interface{}
 
 type OverlappingRangeIPReservationExpansion interface{}
+
+type WhereaboutsConfigExpansion interface{}
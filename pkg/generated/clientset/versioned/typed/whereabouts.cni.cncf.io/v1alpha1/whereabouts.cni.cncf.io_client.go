@@ -27,9 +27,11 @@ import (
 
 type WhereaboutsV1alpha1Interface interface {
 	RESTClient() rest.Interface
+	FloatingIPClaimsGetter
 	IPPoolsGetter
 	NodeSlicePoolsGetter
 	OverlappingRangeIPReservationsGetter
+	WhereaboutsConfigsGetter
 }
 
 // WhereaboutsV1alpha1Client is used to interact with features provided by the whereabouts.cni.cncf.io group.
@@ -37,6 +39,10 @@ type WhereaboutsV1alpha1Client struct {
 	restClient rest.Interface
 }
 
+func (c *WhereaboutsV1alpha1Client) FloatingIPClaims(namespace string) FloatingIPClaimInterface {
+	return newFloatingIPClaims(c, namespace)
+}
+
 func (c *WhereaboutsV1alpha1Client) IPPools(namespace string) IPPoolInterface {
 	return newIPPools(c, namespace)
 }
@@ -49,6 +55,10 @@ func (c *WhereaboutsV1alpha1Client) OverlappingRangeIPReservations(namespace str
 	return newOverlappingRangeIPReservations(c, namespace)
 }
 
+func (c *WhereaboutsV1alpha1Client) WhereaboutsConfigs() WhereaboutsConfigInterface {
+	return newWhereaboutsConfigs(c)
+}
+
 // NewForConfig creates a new WhereaboutsV1alpha1Client for the given config.
 // NewForConfig is equivalent to NewForConfigAndClient(c, httpClient),
 // where httpClient was generated with rest.HTTPClientFor(c).
@@ -23,12 +23,16 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// FloatingIPClaims returns a FloatingIPClaimInformer.
+	FloatingIPClaims() FloatingIPClaimInformer
 	// IPPools returns a IPPoolInformer.
 	IPPools() IPPoolInformer
 	// NodeSlicePools returns a NodeSlicePoolInformer.
 	NodeSlicePools() NodeSlicePoolInformer
 	// OverlappingRangeIPReservations returns a OverlappingRangeIPReservationInformer.
 	OverlappingRangeIPReservations() OverlappingRangeIPReservationInformer
+	// WhereaboutsConfigs returns a WhereaboutsConfigInformer.
+	WhereaboutsConfigs() WhereaboutsConfigInformer
 }
 
 type version struct {
@@ -42,6 +46,11 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// FloatingIPClaims returns a FloatingIPClaimInformer.
+func (v *version) FloatingIPClaims() FloatingIPClaimInformer {
+	return &floatingIPClaimInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // IPPools returns a IPPoolInformer.
 func (v *version) IPPools() IPPoolInformer {
 	return &iPPoolInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
@@ -56,3 +65,8 @@ func (v *version) NodeSlicePools() NodeSlicePoolInformer {
 func (v *version) OverlappingRangeIPReservations() OverlappingRangeIPReservationInformer {
 	return &overlappingRangeIPReservationInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// WhereaboutsConfigs returns a WhereaboutsConfigInformer.
+func (v *version) WhereaboutsConfigs() WhereaboutsConfigInformer {
+	return &whereaboutsConfigInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
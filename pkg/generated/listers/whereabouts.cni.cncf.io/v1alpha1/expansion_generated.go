@@ -17,6 +17,14 @@ limitations under the License.
 
 package v1alpha1
 
+// FloatingIPClaimListerExpansion allows custom methods to be added to
+// FloatingIPClaimLister.
+type FloatingIPClaimListerExpansion interface{}
+
+// FloatingIPClaimNamespaceListerExpansion allows custom methods to be added to
+// FloatingIPClaimNamespaceLister.
+type FloatingIPClaimNamespaceListerExpansion interface{}
+
 // IPPoolListerExpansion allows custom methods to be added to
 // IPPoolLister.
 type IPPoolListerExpansion interface{}
@@ -40,3 +48,7 @@ type OverlappingRangeIPReservationListerExpansion interface{}
 // OverlappingRangeIPReservationNamespaceListerExpansion allows custom methods to be added to
 // OverlappingRangeIPReservationNamespaceLister.
 type OverlappingRangeIPReservationNamespaceListerExpansion interface{}
+
+// WhereaboutsConfigListerExpansion allows custom methods to be added to
+// WhereaboutsConfigLister.
+type WhereaboutsConfigListerExpansion interface{}
@@ -0,0 +1,149 @@
+// Package hostlocal converts the addresses handed out by the host-local IPAM plugin into whereabouts IP pool
+// allocations, easing the migration of existing workloads from host-local to whereabouts.
+package hostlocal
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// DefaultDataDir is where host-local stores its allocations, one directory per network
+const DefaultDataDir = "/var/lib/cni/networks"
+
+const (
+	lastReservedIPPrefix = "last_reserved_ip."
+	lockFile             = "lock"
+	lineBreak            = "\r\n"
+)
+
+// Allocation is an address host-local handed out to a container interface
+type Allocation struct {
+	IP          net.IP
+	ContainerID string
+	IfName      string
+}
+
+// PodRefResolver returns the reference - namespace/name - of the pod owning the container, and whether it was found
+type PodRefResolver func(containerID string) (string, bool)
+
+// ReadAllocations reads the allocations host-local recorded in the data directory of a network, e.g.
+// /var/lib/cni/networks/<network name>. Each allocation is a file named after the IP address, holding the container
+// id and - since CNI 0.4.0 - the interface name.
+func ReadAllocations(networkDir string) ([]Allocation, error) {
+	entries, err := os.ReadDir(networkDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the host-local data directory %s: %w", networkDir, err)
+	}
+
+	var allocations []Allocation
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == lockFile || strings.HasPrefix(entry.Name(), lastReservedIPPrefix) {
+			continue
+		}
+
+		ip := net.ParseIP(fileNameToIP(entry.Name()))
+		if ip == nil {
+			continue
+		}
+
+		contents, err := os.ReadFile(filepath.Join(networkDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the host-local allocation of %s: %w", ip, err)
+		}
+
+		allocation := Allocation{IP: ip}
+		fields := strings.SplitN(strings.TrimSpace(string(contents)), lineBreak, 2)
+		allocation.ContainerID = strings.TrimSpace(fields[0])
+		if len(fields) > 1 {
+			allocation.IfName = strings.TrimSpace(fields[1])
+		}
+		allocations = append(allocations, allocation)
+	}
+	return allocations, nil
+}
+
+// ToIPReservations converts the allocations within the range into whereabouts reservations, resolving the pod owning
+// each of them. The allocations whose pod cannot be resolved are returned apart, and left out of the reservations.
+func ToIPReservations(allocations []Allocation, ipRange string, resolve PodRefResolver) ([]types.IPReservation, []Allocation, error) {
+	_, ipNet, err := net.ParseCIDR(ipRange)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid range %q: %w", ipRange, err)
+	}
+
+	var reservations []types.IPReservation
+	var unresolved []Allocation
+	for _, allocation := range allocations {
+		if !ipNet.Contains(allocation.IP) {
+			continue
+		}
+
+		podRef, found := resolve(allocation.ContainerID)
+		if !found {
+			unresolved = append(unresolved, allocation)
+			continue
+		}
+		reservations = append(reservations, types.IPReservation{
+			IP:          allocation.IP,
+			ContainerID: allocation.ContainerID,
+			PodRef:      podRef,
+			IfName:      allocation.IfName,
+		})
+	}
+	return reservations, unresolved, nil
+}
+
+// MergeIntoIPPool adds the reservations to the allocations of the pool. Reservations already present in the pool are
+// left as is, while an address the pool allocated to a different pod is reported as an error, leaving the pool
+// untouched.
+func MergeIntoIPPool(pool *whereaboutsv1alpha1.IPPool, reservations []types.IPReservation) error {
+	firstIP, _, err := pool.ParseCIDR()
+	if err != nil {
+		return err
+	}
+
+	allocations := map[string]whereaboutsv1alpha1.IPAllocation{}
+	for _, reservation := range reservations {
+		offset, err := iphelpers.IPGetOffset(reservation.IP, firstIP)
+		if err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%d", offset)
+
+		if existing, found := pool.Spec.Allocations[key]; found && existing.PodRef != reservation.PodRef {
+			return fmt.Errorf("IP %s is allocated to pod %s in pool %s, not to %s",
+				reservation.IP, existing.PodRef, pool.GetName(), reservation.PodRef)
+		} else if found {
+			continue
+		}
+		allocations[key] = whereaboutsv1alpha1.IPAllocation{
+			ContainerID: reservation.ContainerID,
+			PodRef:      reservation.PodRef,
+			IfName:      reservation.IfName,
+		}
+	}
+
+	if pool.Spec.Allocations == nil {
+		pool.Spec.Allocations = map[string]whereaboutsv1alpha1.IPAllocation{}
+	}
+	for key, allocation := range allocations {
+		pool.Spec.Allocations[key] = allocation
+	}
+	return nil
+}
+
+// fileNameToIP reverts the escaping of the IPv6 addresses host-local applies on Windows, where ':' is not allowed in
+// file names
+func fileNameToIP(name string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ReplaceAll(name, "_", ":")
+	}
+	return name
+}
@@ -0,0 +1,110 @@
+package hostlocal
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestHostLocal(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "hostlocal")
+}
+
+var _ = Describe("Host-local conversion", func() {
+	var networkDir string
+
+	BeforeEach(func() {
+		var err error
+		networkDir, err = os.MkdirTemp("", "hostlocal")
+		Expect(err).NotTo(HaveOccurred())
+
+		for name, contents := range map[string]string{
+			"10.10.0.2":          "container-a\r\neth0",
+			"10.10.0.3":          "container-b",
+			"10.20.0.2":          "container-c\r\nnet1",
+			"last_reserved_ip.0": "10.10.0.3",
+			"lock":               "",
+		} {
+			Expect(os.WriteFile(filepath.Join(networkDir, name), []byte(contents), 0o600)).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(networkDir)).To(Succeed())
+	})
+
+	It("reads the allocations of a network", func() {
+		allocations, err := ReadAllocations(networkDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(allocations).To(ConsistOf(
+			Allocation{IP: net.ParseIP("10.10.0.2"), ContainerID: "container-a", IfName: "eth0"},
+			Allocation{IP: net.ParseIP("10.10.0.3"), ContainerID: "container-b"},
+			Allocation{IP: net.ParseIP("10.20.0.2"), ContainerID: "container-c", IfName: "net1"},
+		))
+	})
+
+	It("converts the allocations within the range, setting the unresolved ones apart", func() {
+		allocations, err := ReadAllocations(networkDir)
+		Expect(err).NotTo(HaveOccurred())
+
+		reservations, unresolved, err := ToIPReservations(allocations, "10.10.0.0/24", func(containerID string) (string, bool) {
+			if containerID == "container-a" {
+				return "default/pod-a", true
+			}
+			return "", false
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reservations).To(ConsistOf(types.IPReservation{
+			IP:          net.ParseIP("10.10.0.2"),
+			ContainerID: "container-a",
+			PodRef:      "default/pod-a",
+			IfName:      "eth0",
+		}))
+		Expect(unresolved).To(ConsistOf(Allocation{IP: net.ParseIP("10.10.0.3"), ContainerID: "container-b"}))
+	})
+
+	Context("merging reservations into an IP pool", func() {
+		var pool *whereaboutsv1alpha1.IPPool
+
+		BeforeEach(func() {
+			pool = &whereaboutsv1alpha1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "10.10.0.0-24"},
+				Spec: whereaboutsv1alpha1.IPPoolSpec{
+					Range: "10.10.0.0/24",
+					Allocations: map[string]whereaboutsv1alpha1.IPAllocation{
+						"5": {ContainerID: "container-e", PodRef: "default/pod-e", IfName: "eth0"},
+					},
+				},
+			}
+		})
+
+		It("adds the allocations at their offset in the range", func() {
+			Expect(MergeIntoIPPool(pool, []types.IPReservation{
+				{IP: net.ParseIP("10.10.0.2"), ContainerID: "container-a", PodRef: "default/pod-a", IfName: "eth0"},
+				{IP: net.ParseIP("10.10.0.5"), ContainerID: "container-e", PodRef: "default/pod-e", IfName: "eth0"},
+			})).To(Succeed())
+			Expect(pool.Spec.Allocations).To(Equal(map[string]whereaboutsv1alpha1.IPAllocation{
+				"2": {ContainerID: "container-a", PodRef: "default/pod-a", IfName: "eth0"},
+				"5": {ContainerID: "container-e", PodRef: "default/pod-e", IfName: "eth0"},
+			}))
+		})
+
+		It("refuses addresses allocated to another pod", func() {
+			Expect(MergeIntoIPPool(pool, []types.IPReservation{
+				{IP: net.ParseIP("10.10.0.2"), ContainerID: "container-a", PodRef: "default/pod-a", IfName: "eth0"},
+				{IP: net.ParseIP("10.10.0.5"), ContainerID: "container-b", PodRef: "default/pod-b", IfName: "eth0"},
+			})).To(MatchError("IP 10.10.0.5 is allocated to pod default/pod-e in pool 10.10.0.0-24, not to default/pod-b"))
+			Expect(pool.Spec.Allocations).To(HaveLen(1))
+		})
+	})
+})
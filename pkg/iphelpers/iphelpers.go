@@ -1,10 +1,10 @@
 package iphelpers
 
 import (
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
+	"math/bits"
 	"net"
 	"strconv"
 	"strings"
@@ -29,7 +29,12 @@ func CompareIPs(ipX net.IP, ipY net.IP) int {
 	return 0
 }
 
-// DivideRangeBySize takes an ipRange i.e. 11.0.0.0/8 and a sliceSize i.e. /24
+// maxIPv6SliceBits bounds the number of slices DivideRangeBySize divides an IPv6 range into to 2^maxIPv6SliceBits, as
+// they may hold far more slices than could be listed. IPv4 ranges are not bounded, for their existing layouts to stay
+// valid.
+const maxIPv6SliceBits = 16
+
+// DivideRangeBySize takes an ipRange i.e. 11.0.0.0/8 or fd00::/48 and a sliceSize i.e. /24 or /64
 // and returns a list of IPNets that divide the input range into sizes
 func DivideRangeBySize(inputNetwork string, sliceSizeString string) ([]string, error) {
 	// Remove "/" from the start of the sliceSize
@@ -47,40 +52,56 @@ func DivideRangeBySize(inputNetwork string, sliceSizeString string) ([]string, e
 	if !ip.Equal(ipNet.IP) {
 		return nil, errors.New("netCIDR is not a valid network address")
 	}
-	netMaskSize, _ := ipNet.Mask.Size()
-	if netMaskSize > int(sliceSize) {
+	netMaskSize, addressBits := ipNet.Mask.Size()
+	if netMaskSize > sliceSize {
 		return nil, errors.New("subnetMaskSize must be greater or equal than netMaskSize")
 	}
-
-	totalSubnetsInNetwork := math.Pow(2, float64(sliceSize)-float64(netMaskSize))
-	totalHostsInSubnet := math.Pow(2, 32-float64(sliceSize))
-	subnetIntAddresses := make([]uint32, int(totalSubnetsInNetwork))
-	// first subnet address is same as the network address
-	subnetIntAddresses[0] = ip2int(ip.To4())
-	for i := 1; i < int(totalSubnetsInNetwork); i++ {
-		subnetIntAddresses[i] = subnetIntAddresses[i-1] + uint32(totalHostsInSubnet)
+	if sliceSize > addressBits {
+		return nil, fmt.Errorf("subnetMaskSize must be lower or equal than %d", addressBits)
+	}
+	if addressBits == 8*net.IPv6len && sliceSize-netMaskSize > maxIPv6SliceBits {
+		return nil, fmt.Errorf("dividing %s by /%d makes more than %d slices", inputNetwork, sliceSize, 1<<maxIPv6SliceBits)
 	}
 
-	subnetCIDRs := make([]string, 0)
-	for _, sia := range subnetIntAddresses {
-		subnetCIDRs = append(
-			subnetCIDRs,
-			int2ip(sia).String()+"/"+strconv.Itoa(int(sliceSize)),
-		)
+	subnets, err := SplitSubnet(*ipNet, 1<<(sliceSize-netMaskSize))
+	if err != nil {
+		return nil, err
+	}
+	subnetCIDRs := make([]string, 0, len(subnets))
+	for _, subnet := range subnets {
+		subnetCIDRs = append(subnetCIDRs, subnet.String())
 	}
 	return subnetCIDRs, nil
 }
 
-func ip2int(ip net.IP) uint32 {
-	if len(ip) == 16 {
-		panic("cannot convert IPv6 into uint32")
+// SplitSubnet splits the subnet into the given number of equally sized subnets, in ascending order. The number of
+// subnets must be a power of two. It supports both IPv4 and IPv6.
+func SplitSubnet(ipnet net.IPNet, parts int) ([]net.IPNet, error) {
+	if parts <= 0 || parts&(parts-1) != 0 {
+		return nil, fmt.Errorf("cannot split subnet %s in %d parts: not a power of two", ipnet.String(), parts)
+	}
+	ones, size := ipnet.Mask.Size()
+	splitBits := bits.TrailingZeros(uint(parts))
+	if ones+splitBits > size {
+		return nil, fmt.Errorf("cannot split subnet %s in %d parts: it is too small", ipnet.String(), parts)
+	}
+
+	networkIP := NetworkIP(ipnet)
+	mask := net.CIDRMask(ones+splitBits, size)
+	subnets := make([]net.IPNet, 0, parts)
+	for i := 0; i < parts; i++ {
+		ip := make(net.IP, len(networkIP))
+		copy(ip, networkIP)
+		// the index of the subnet makes up the bits following the prefix of the subnet being split
+		for bit := 0; bit < splitBits; bit++ {
+			if i&(1<<(splitBits-1-bit)) != 0 {
+				position := ones + bit
+				ip[position/8] |= 0x80 >> (position % 8)
+			}
+		}
+		subnets = append(subnets, net.IPNet{IP: ip, Mask: mask})
 	}
-	return binary.BigEndian.Uint32(ip)
-}
-func int2ip(nn uint32) net.IP {
-	ip := make(net.IP, 4)
-	binary.BigEndian.PutUint32(ip, nn)
-	return ip
+	return subnets, nil
 }
 
 // IsIPInRange returns true if a given IP is within the continuous range of start and end IP (inclusively).
@@ -142,6 +163,20 @@ func HasUsableIPs(ipnet net.IPNet) bool {
 	return totalBits-ones > 1
 }
 
+// UsableIPCount counts the usable IPs of this subnet (i.e. all but the network and the broadcast IP), saturating at
+// math.MaxUint64 for the subnets holding more.
+func UsableIPCount(ipnet net.IPNet) uint64 {
+	ones, totalBits := ipnet.Mask.Size()
+	hostBits := totalBits - ones
+	if hostBits <= 1 {
+		return 0
+	}
+	if hostBits >= 64 {
+		return math.MaxUint64
+	}
+	return (uint64(1) << hostBits) - 2
+}
+
 // IncIP increases the given IP address by one. IncIP will overflow for all 0xf adresses.
 func IncIP(ip net.IP) net.IP {
 	// Allocate a new IP.
@@ -233,6 +268,31 @@ func IsIPv4(checkip net.IP) bool {
 	return checkip.To4() != nil
 }
 
+// ParseExcludedRange parses a provided string to a net.IPNet.
+// If the provided string is a valid CIDR, return the net.IPNet for that CIDR.
+// If the provided string is a valid IP address, add the /32 or /128 prefix to form the CIDR and return the net.IPNet.
+// Otherwise, return the error.
+func ParseExcludedRange(s string) (*net.IPNet, error) {
+	// Try parsing CIDRs.
+	_, subnet, err := net.ParseCIDR(s)
+	if err == nil {
+		return subnet, nil
+	}
+	// The user might have given a single IP address, try parsing that - if it does not parse, return the error that
+	// we got earlier.
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, err
+	}
+	// If the address parses, check if it's IPv4 or IPv6 and add the correct prefix.
+	if ip.To4() != nil {
+		_, subnet, err = net.ParseCIDR(fmt.Sprintf("%s/32", s))
+	} else {
+		_, subnet, err = net.ParseCIDR(fmt.Sprintf("%s/128", s))
+	}
+	return subnet, err
+}
+
 // GetIPRange returns the first and last IP in a range.
 // If either rangeStart or rangeEnd are inside the range of first usable IP to last usable IP, then use them. Otherwise,
 // they will be silently ignored and the first usable IP and/or last usable IP will be used. A valid rangeEnd cannot
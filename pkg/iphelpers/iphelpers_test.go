@@ -2,6 +2,7 @@ package iphelpers
 
 import (
 	"fmt"
+	"math"
 	"net"
 	"testing"
 
@@ -431,6 +432,23 @@ var _ = Describe("HasUsableIPs operations", func() {
 	})
 })
 
+var _ = Describe("UsableIPCount operations", func() {
+	It("counts all but the network and broadcast IPs", func() {
+		_, ipnet, _ := net.ParseCIDR("192.168.0.0/28")
+		Expect(UsableIPCount(*ipnet)).To(Equal(uint64(14)))
+	})
+
+	It("counts no usable IPs in IPv4 /31", func() {
+		_, ipnet, _ := net.ParseCIDR("192.168.0.0/31")
+		Expect(UsableIPCount(*ipnet)).To(BeZero())
+	})
+
+	It("saturates on IPv6 /64", func() {
+		_, ipnet, _ := net.ParseCIDR("2000::/64")
+		Expect(UsableIPCount(*ipnet)).To(Equal(uint64(math.MaxUint64)))
+	})
+})
+
 var _ = Describe("IncIPAddress operations", func() {
 	When("IP addresses are increased without rolling over", func() {
 		It("works with IPv4", func() {
@@ -968,6 +986,30 @@ func TestDivideRangeBySize(t *testing.T) {
 			sliceSize:      "10",
 			expectedResult: []string{"10.0.0.0/10", "10.64.0.0/10", "10.128.0.0/10", "10.192.0.0/10"},
 		},
+		{
+			name:        "Network divided by a slice larger than the address",
+			netRange:    "10.0.0.0/8",
+			sliceSize:   "/33",
+			expectError: true,
+		},
+		{
+			name:           "IPv6 network divided /62 by /64",
+			netRange:       "fd00:10::/62",
+			sliceSize:      "/64",
+			expectedResult: []string{"fd00:10::/64", "fd00:10:0:1::/64", "fd00:10:0:2::/64", "fd00:10:0:3::/64"},
+		},
+		{
+			name:           "IPv6 network divided /112 by /114",
+			netRange:       "fd00::/112",
+			sliceSize:      "/114",
+			expectedResult: []string{"fd00::/114", "fd00::4000/114", "fd00::8000/114", "fd00::c000/114"},
+		},
+		{
+			name:        "IPv6 network divided in too many slices",
+			netRange:    "fd00::/48",
+			sliceSize:   "/80",
+			expectError: true,
+		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -989,3 +1031,78 @@ func TestDivideRangeBySize(t *testing.T) {
 		})
 	}
 }
+
+func TestDivideIPv4RangeInManySlices(t *testing.T) {
+	// only the IPv6 ranges are bounded in slices
+	result, err := DivideRangeBySize("10.0.0.0/8", "/26")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 1<<18 || result[0] != "10.0.0.0/26" || result[len(result)-1] != "10.255.255.192/26" {
+		t.Errorf("Expected the 2^18 slices of 10.0.0.0/8, got %d slices from %s to %s", len(result), result[0], result[len(result)-1])
+	}
+}
+
+func TestSplitSubnet(t *testing.T) {
+	cases := []struct {
+		name           string
+		subnet         string
+		parts          int
+		expectedResult []string
+		expectError    bool
+	}{
+		{
+			name:           "IPv4 subnet split in 4",
+			subnet:         "10.0.0.0/16",
+			parts:          4,
+			expectedResult: []string{"10.0.0.0/18", "10.0.64.0/18", "10.0.128.0/18", "10.0.192.0/18"},
+		},
+		{
+			name:           "IPv4 subnet split in 1",
+			subnet:         "10.0.0.0/24",
+			parts:          1,
+			expectedResult: []string{"10.0.0.0/24"},
+		},
+		{
+			name:           "IPv6 subnet split in 2",
+			subnet:         "fd00::/64",
+			parts:          2,
+			expectedResult: []string{"fd00::/65", "fd00::8000:0:0:0/65"},
+		},
+		{
+			name:        "Not a power of two",
+			subnet:      "10.0.0.0/16",
+			parts:       3,
+			expectError: true,
+		},
+		{
+			name:        "Subnet too small",
+			subnet:      "10.0.0.0/31",
+			parts:       4,
+			expectError: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, subnet, _ := net.ParseCIDR(tc.subnet)
+			result, err := SplitSubnet(*subnet, tc.parts)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("expected error but did not get it")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result) != len(tc.expectedResult) {
+				t.Fatalf("Expected result: %s, got result: %v", tc.expectedResult, result)
+			}
+			for i := range result {
+				if result[i].String() != tc.expectedResult[i] {
+					t.Fatalf("Expected result: %s, got result: %v", tc.expectedResult, result)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// Fields correlate the log lines of a CNI invocation, which the JSON format logs along with each line: the invocations
+// of concurrent pods interleave their lines in the same log file.
+type Fields struct {
+	// RequestID identifies the invocation, forwarded to the IPAM daemon when it serves it
+	RequestID   string `json:"requestID,omitempty"`
+	ContainerID string `json:"containerID,omitempty"`
+	PodRef      string `json:"podRef,omitempty"`
+	IfName      string `json:"ifName,omitempty"`
+}
+
+// processFields are logged along with the lines of the whole process, e.g. the CNI plugin, serving a single
+// invocation
+var processFields Fields
+
+type fieldsKey struct{}
+
+// NewRequestID generates the ID of an invocation
+func NewRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// SetFields sets the fields logged along with every line of the process. Processes serving concurrent invocations,
+// e.g. the IPAM daemon, carry the fields of each in its context instead.
+func SetFields(fields Fields) {
+	processFields = fields
+}
+
+// WithFields returns a context carrying the fields, which the Logger of the context logs along with its lines
+func WithFields(ctx context.Context, fields Fields) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// FieldsFromContext returns the fields carried by the context, or those of the process when it carries none
+func FieldsFromContext(ctx context.Context) Fields {
+	if fields, ok := ctx.Value(fieldsKey{}).(Fields); ok {
+		return fields
+	}
+	return processFields
+}
+
+// Logger logs its lines along with the fields of an invocation
+type Logger struct {
+	fields Fields
+}
+
+// FromContext returns the Logger of the fields carried by the context
+func FromContext(ctx context.Context) Logger {
+	return Logger{fields: FieldsFromContext(ctx)}
+}
+
+// Debugf logs at debug level
+func (l Logger) Debugf(format string, a ...interface{}) {
+	printf(l.fields, DebugLevel, format, a...)
+}
+
+// Warningf logs at warning level
+func (l Logger) Warningf(format string, a ...interface{}) {
+	printf(l.fields, WarningLevel, format, a...)
+}
+
+// Verbosef logs at verbose level
+func (l Logger) Verbosef(format string, a ...interface{}) {
+	printf(l.fields, VerboseLevel, format, a...)
+}
+
+// Errorf logs at error level, returning the error
+func (l Logger) Errorf(format string, a ...interface{}) error {
+	printf(l.fields, ErrorLevel, format, a...)
+	return fmt.Errorf(format, a...)
+}
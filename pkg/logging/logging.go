@@ -15,7 +15,9 @@
 package logging
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"time"
@@ -30,15 +32,26 @@ type Level uint32
 const (
 	PanicLevel Level = iota
 	ErrorLevel
+	WarningLevel
 	VerboseLevel
 	DebugLevel
 	MaxLevel
 	UnknownLevel
 )
 
+// The formats of the log lines
+const (
+	// TextFormat logs plain text lines, the default
+	TextFormat = "text"
+	// JSONFormat logs a JSON object per line, holding the correlation fields of the invocation
+	JSONFormat = "json"
+)
+
 var loggingStderr bool
 var loggingFp *os.File
+var loggingFilename string
 var loggingLevel Level
+var loggingFormat string
 
 const defaultTimestampFormat = time.RFC3339
 
@@ -50,6 +63,8 @@ func (l Level) String() string {
 		return "verbose"
 	case ErrorLevel:
 		return "error"
+	case WarningLevel:
+		return "warning"
 	case DebugLevel:
 		return "debug"
 	}
@@ -58,30 +73,62 @@ func (l Level) String() string {
 
 // Printf provides basic Printf functionality for logs
 func Printf(level Level, format string, a ...interface{}) {
-	header := "%s [%s] "
+	printf(processFields, level, format, a...)
+}
+
+func printf(fields Fields, level Level, format string, a ...interface{}) {
 	t := time.Now()
 	if level > loggingLevel {
 		return
 	}
 
 	if loggingStderr {
-		fmt.Fprintf(os.Stderr, header, t.Format(defaultTimestampFormat), level)
-		fmt.Fprintf(os.Stderr, format, a...)
-		fmt.Fprintf(os.Stderr, "\n")
+		writeLine(os.Stderr, t, fields, level, format, a...)
 	}
 
 	if loggingFp != nil {
-		fmt.Fprintf(loggingFp, header, t.Format(defaultTimestampFormat), level)
-		fmt.Fprintf(loggingFp, format, a...)
-		fmt.Fprintf(loggingFp, "\n")
+		writeLine(loggingFp, t, fields, level, format, a...)
 	}
 }
 
+// jsonLine is a log line in the JSON format
+type jsonLine struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"msg"`
+	Fields
+}
+
+func writeLine(w io.Writer, t time.Time, fields Fields, level Level, format string, a ...interface{}) {
+	message := Sanitize(fmt.Sprintf(format, a...))
+	if loggingFormat != JSONFormat {
+		fmt.Fprintf(w, "%s [%s] %s\n", t.Format(defaultTimestampFormat), level, message)
+		return
+	}
+
+	line, err := json.Marshal(jsonLine{
+		Time:    t.Format(defaultTimestampFormat),
+		Level:   level.String(),
+		Message: message,
+		Fields:  fields,
+	})
+	if err != nil {
+		fmt.Fprintf(w, "%s [%s] cannot format log line: %v\n", t.Format(defaultTimestampFormat), level, err)
+		return
+	}
+	fmt.Fprintf(w, "%s\n", line)
+}
+
 // Debugf defines our printf for debug level.
 func Debugf(format string, a ...interface{}) {
 	Printf(DebugLevel, format, a...)
 }
 
+// Warningf defines our printf for warning level.
+func Warningf(format string, a ...interface{}) {
+	Printf(WarningLevel, format, a...)
+}
+
 // Verbosef defines our printf for Verbose level.
 func Verbosef(format string, a ...interface{}) {
 	Printf(VerboseLevel, format, a...)
@@ -112,6 +159,8 @@ func getLoggingLevel(levelStr string) Level {
 		return DebugLevel
 	case "verbose":
 		return VerboseLevel
+	case "warning":
+		return WarningLevel
 	case "error":
 		return ErrorLevel
 	case "panic":
@@ -129,27 +178,44 @@ func SetLogLevel(levelStr string) {
 	}
 }
 
+// SetLogFormat sets the format of the log lines, either TextFormat or JSONFormat
+func SetLogFormat(format string) {
+	switch strings.ToLower(format) {
+	case TextFormat:
+		loggingFormat = TextFormat
+	case JSONFormat:
+		loggingFormat = JSONFormat
+	default:
+		fmt.Fprintf(os.Stderr, "Whereabouts logging: cannot set logging format to %s\n", format)
+	}
+}
+
 // SetLogStderr enables logging to stderr
 func SetLogStderr(enable bool) {
 	loggingStderr = enable
 }
 
-// SetLogFile defines which log file we'll log to
+// SetLogFile defines which log file we'll log to. The file already logged to is kept open, and the previous one closed.
 func SetLogFile(filename string) {
-	if filename == "" {
+	if filename == "" || (loggingFp != nil && filename == loggingFilename) {
 		return
 	}
 
 	fp, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
-		loggingFp = nil
-		fmt.Fprintf(os.Stderr, "Whereabouts logging: cannot open %s", filename)
+		fmt.Fprintf(os.Stderr, "Whereabouts logging: cannot open %s\n", filename)
+		return
+	}
+	if loggingFp != nil {
+		loggingFp.Close()
 	}
 	loggingFp = fp
+	loggingFilename = filename
 }
 
 func init() {
 	loggingStderr = true
 	loggingFp = nil
 	loggingLevel = DebugLevel
+	loggingFormat = TextFormat
 }
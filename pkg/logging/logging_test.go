@@ -15,6 +15,11 @@
 package logging
 
 import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
@@ -32,6 +37,8 @@ var _ = Describe("logging operations", func() {
 		loggingStderr = false
 		loggingFp = nil
 		loggingLevel = PanicLevel
+		loggingFormat = TextFormat
+		processFields = Fields{}
 	})
 
 	It("Check file setter with empty", func() {
@@ -44,6 +51,27 @@ var _ = Describe("logging operations", func() {
 		Expect(loggingFp).NotTo(Equal(nil))
 	})
 
+	It("keeps the log file open when set again, and closes it when replaced", func() {
+		dir, err := os.MkdirTemp("", "whereabouts-logging")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		SetLogFile(filepath.Join(dir, "first.log"))
+		first := loggingFp
+		Expect(first).NotTo(BeNil())
+		SetLogFile(filepath.Join(dir, "first.log"))
+		Expect(loggingFp).To(BeIdenticalTo(first))
+
+		SetLogFile(filepath.Join(dir, "second.log"))
+		Expect(loggingFp).NotTo(BeIdenticalTo(first))
+		_, err = first.WriteString("closed")
+		Expect(err).To(HaveOccurred())
+
+		SetLogFile(filepath.Join(dir, "missing", "third.log"))
+		Expect(loggingFp.Name()).To(Equal(filepath.Join(dir, "second.log")))
+		loggingFp.Close()
+	})
+
 	It("Check loglevel setter", func() {
 		SetLogLevel("debug")
 		Expect(loggingLevel).To(Equal(DebugLevel))
@@ -51,6 +79,8 @@ var _ = Describe("logging operations", func() {
 		Expect(loggingLevel).To(Equal(ErrorLevel))
 		SetLogLevel("VERbose")
 		Expect(loggingLevel).To(Equal(VerboseLevel))
+		SetLogLevel("warning")
+		Expect(loggingLevel).To(Equal(WarningLevel))
 		SetLogLevel("PANIC")
 		Expect(loggingLevel).To(Equal(PanicLevel))
 	})
@@ -66,4 +96,77 @@ var _ = Describe("logging operations", func() {
 		SetLogStderr(!currentVal)
 		Expect(loggingStderr).NotTo(Equal(currentVal))
 	})
+
+	It("Check log format setter", func() {
+		SetLogFormat("JSON")
+		Expect(loggingFormat).To(Equal(JSONFormat))
+		SetLogFormat("xxxx")
+		Expect(loggingFormat).To(Equal(JSONFormat))
+		SetLogFormat("text")
+		Expect(loggingFormat).To(Equal(TextFormat))
+	})
+
+	Context("logging JSON lines", func() {
+		var (
+			logDir  string
+			logFile string
+		)
+
+		readLines := func() []map[string]string {
+			content, err := os.ReadFile(logFile)
+			Expect(err).NotTo(HaveOccurred())
+			var lines []map[string]string
+			for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+				var fields map[string]string
+				Expect(json.Unmarshal([]byte(line), &fields)).To(Succeed())
+				lines = append(lines, fields)
+			}
+			return lines
+		}
+
+		BeforeEach(func() {
+			var err error
+			logDir, err = os.MkdirTemp("", "whereabouts-logging")
+			Expect(err).NotTo(HaveOccurred())
+			logFile = filepath.Join(logDir, "whereabouts.log")
+			SetLogFile(logFile)
+			SetLogLevel("debug")
+			SetLogFormat(JSONFormat)
+		})
+
+		AfterEach(func() {
+			Expect(loggingFp.Close()).To(Succeed())
+			Expect(os.RemoveAll(logDir)).To(Succeed())
+		})
+
+		It("logs the fields of the process along with each line", func() {
+			SetFields(Fields{RequestID: "0123456789abcdef", ContainerID: "abc", PodRef: "default/pod1", IfName: "net1"})
+			Debugf("allocating %s", "10.0.0.1")
+
+			Expect(readLines()).To(ConsistOf(SatisfyAll(
+				HaveKeyWithValue("level", "debug"),
+				HaveKeyWithValue("msg", "allocating 10.0.0.1"),
+				HaveKeyWithValue("requestID", "0123456789abcdef"),
+				HaveKeyWithValue("containerID", "abc"),
+				HaveKeyWithValue("podRef", "default/pod1"),
+				HaveKeyWithValue("ifName", "net1"),
+				HaveKey("time"))))
+		})
+
+		It("logs the fields of the context in place of those of the process", func() {
+			SetFields(Fields{RequestID: "process"})
+			ctx := WithFields(context.Background(), Fields{RequestID: "request", ContainerID: "abc"})
+			Expect(FromContext(ctx).Errorf("failed")).To(MatchError("failed"))
+			FromContext(context.Background()).Verbosef("done")
+
+			Expect(readLines()).To(ConsistOf(
+				SatisfyAll(HaveKeyWithValue("msg", "failed"), HaveKeyWithValue("requestID", "request"), HaveKeyWithValue("containerID", "abc")),
+				SatisfyAll(HaveKeyWithValue("msg", "done"), HaveKeyWithValue("requestID", "process"), Not(HaveKey("containerID")))))
+		})
+	})
+
+	It("generates distinct request IDs", func() {
+		Expect(NewRequestID()).To(HaveLen(16))
+		Expect(NewRequestID()).NotTo(Equal(NewRequestID()))
+	})
 })
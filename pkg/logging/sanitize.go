@@ -0,0 +1,137 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces the sensitive values of the log lines
+const Redacted = "<redacted>"
+
+var (
+	// pemBlocks are the certificates and keys written out in PEM
+	pemBlocks = regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]+-----.*?-----END [A-Z0-9 ]+-----`)
+	// bearerTokens are the tokens of authorization headers
+	bearerTokens = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`)
+	// jsonWebTokens are the service account tokens, whose header always starts with {"
+	jsonWebTokens = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	// sensitiveJSONValues are the values of the sensitive keys of JSON documents, e.g. kubeconfigs and etcd settings
+	sensitiveJSONValues = regexp.MustCompile(`(?i)("(?:` + sensitiveKeys + `)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+	// sensitiveValues are the values of the sensitive keys of YAML documents and of the structs printed with %+v
+	sensitiveValues = regexp.MustCompile(`(?i)\b((?:` + sensitiveKeys + `)\s*[:=]\s*)(?:"(?:[^"\\]|\\.)*"|[^\s",}]+)`)
+)
+
+const sensitiveKeys = `token|password|client-key-data|client-certificate-data|certificate-authority-data|etcd_password`
+
+// configDumpFields are the keys of the network configurations whose values are logged as is by SanitizeConfig: the
+// IPAM parameters telling how addresses are allocated, and the common parameters of the CNI plugins. Credentials,
+// the paths to them - kubeconfig, token and etcd certificate files - and the parameters of other plugins are
+// redacted.
+var configDumpFields = map[string]bool{
+	// the network configuration
+	"cniVersion": true, "name": true, "type": true, "master": true, "mode": true, "bridge": true, "vlan": true,
+	"mtu": true, "isGateway": true, "ipMasq": true, "hairpinMode": true,
+	// the IPAM configuration
+	"Name": true, "Gateway": true, "PodName": true, "PodNamespace": true, "PodUID": true, "datastore": true,
+	"range": true, "range_start": true, "range_end": true, "range_set": true, "exclude": true, "gateway": true,
+	"address": true, "node_slice_size": true, "pool_shards": true, "allocation_strategy": true, "verify_unused": true,
+	"verify_unused_quarantine": true, "verify_unused_timeout": true, "leader_lease_duration": true,
+	"leader_renew_deadline": true, "leader_retry_period": true, "no_leader_election": true,
+	"leader_fallback_conflicts": true, "log_level": true,
+	"log_format": true, "log_file": true, "reconciler_cron_expression": true, "enable_overlapping_ranges": true,
+	"sleep_for_race": true, "backoff_base_ms": true, "backoff_max_ms": true, "allocation_timeout": true,
+	"seed_overlapping_ips": true, "service_reservations": true, "result_order": true, "foreign_ranges": true,
+	"daemon_socket": true, "allocation_api": true, "address_family_policy": true, "require_pod_uid_match": true,
+	"enable_sticky_ips": true, "strict_range_check": true, "pool_namespace": true, "ipv6_address_mode": true,
+	"gateway_strategy": true, "reservation_key": true, "node_unready_grace_period": true, "network_name": true,
+	"configuration_path": true,
+	// the routes and DNS settings
+	"dst": true, "gw": true, "priority": true, "table": true, "scope": true, "nameservers": true, "domain": true,
+	"search": true, "options": true,
+}
+
+// Sanitize masks the certificates, keys and tokens of a log line. The lines are sanitized before they are written, so
+// that a debug log collected for troubleshooting does not leak the credentials of the cluster.
+func Sanitize(line string) string {
+	line = pemBlocks.ReplaceAllString(line, Redacted)
+	line = bearerTokens.ReplaceAllString(line, "${1}"+Redacted)
+	line = jsonWebTokens.ReplaceAllString(line, Redacted)
+	line = sensitiveJSONValues.ReplaceAllString(line, `${1}"`+Redacted+`"`)
+	return sensitiveValues.ReplaceAllString(line, "${1}"+Redacted)
+}
+
+// SanitizeConfig returns the JSON dump of a configuration for the debug logs, holding the values of the allowed fields
+// only: the other values are redacted, the objects and lists of objects being sanitized in turn. The configuration is
+// either the raw JSON, as a string or bytes, or a value marshalled to JSON, e.g. an IPAM configuration.
+func SanitizeConfig(config interface{}) string {
+	var raw []byte
+	switch c := config.(type) {
+	case []byte:
+		raw = c
+	case string:
+		raw = []byte(c)
+	default:
+		var err error
+		if raw, err = json.Marshal(config); err != nil {
+			return fmt.Sprintf("<invalid configuration: %v>", err)
+		}
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return Redacted
+	}
+	var sanitized strings.Builder
+	encoder := json.NewEncoder(&sanitized)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(sanitizeValue(document)); err != nil {
+		return Redacted
+	}
+	return strings.TrimSuffix(sanitized.String(), "\n")
+}
+
+func sanitizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			v[key] = sanitizeField(key, field)
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = sanitizeValue(v[i])
+		}
+		return v
+	}
+	return value
+}
+
+func sanitizeField(key string, value interface{}) interface{} {
+	if containsObjects(value) {
+		return sanitizeValue(value)
+	}
+	if value == nil || configDumpFields[key] {
+		return value
+	}
+	if s, ok := value.(string); ok && strings.TrimSpace(s) == "" {
+		return value
+	}
+	return Redacted
+}
+
+// containsObjects tells whether the value is an object, or a list holding objects, sanitized field by field
+func containsObjects(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return true
+	case []interface{}:
+		for _, item := range v {
+			if containsObjects(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,537 @@
+// Package metrics instruments the allocation path of whereabouts, exposing counters and histograms in the Prometheus
+// text format: served over HTTP by the long-running processes - the node daemon and the control loop - or written to
+// a file for the textfile collector of the node exporter.
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Path is the path the metrics are served on
+const Path = "/metrics"
+
+const (
+	textContentType   = "text/plain; version=0.0.4; charset=utf-8"
+	textfilePerms     = 0644
+	readHeaderTimeout = 5 * time.Second
+)
+
+// DurationBuckets are the upper bounds, in seconds, of the histograms of durations: from the allocations served
+// right away to those waiting for the leader election or retrying on conflicts
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// restorable is implemented by the metrics whose values can be restored from a previous textfile: the counters, which
+// would otherwise restart from zero along with the short-lived processes writing them
+type restorable interface {
+	restore(labelValues map[string]string, value uint64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Registry holds a set of metrics, written in the order of their names
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]metric{}}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, found := r.metrics[m.name()]; found {
+		panic(fmt.Sprintf("metric %s registered twice", m.name()))
+	}
+	r.metrics[m.name()] = m
+}
+
+// NewCounter registers a counter
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{metricName: name, help: help}
+	r.register(c)
+	return c
+}
+
+// NewCounterVec registers a counter partitioned by the values of the given labels
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{metricName: name, help: help, labelNames: labelNames, series: map[string]*counterSeries{}}
+	r.register(c)
+	return c
+}
+
+// NewGaugeVec registers a gauge partitioned by the values of the given labels
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{metricName: name, help: help, labelNames: labelNames, series: map[string]*gaugeSeries{}}
+	r.register(g)
+	return g
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds, in increasing order
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{metricName: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+	r.register(h)
+	return h
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		r.metrics[name].write(&buf)
+	}
+	r.mu.Unlock()
+	return buf.WriteTo(w)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", textContentType)
+		_, _ = r.WriteTo(w)
+	})
+}
+
+// TLSFiles are the files of the TLS the metrics are served over: the certificate and its private key, and the CA
+// bundle the client certificates of the scrapers must be signed by, if any. Without certificate, the metrics are
+// served over plain HTTP. The files are read once, when creating the server.
+type TLSFiles struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// NewServer returns a server exposing the metrics of the registry under Path, over TLS when given a certificate
+func (r *Registry) NewServer(address string, tlsFiles TLSFiles) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle(Path, r.Handler())
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	if tlsFiles.CertFile == "" && tlsFiles.KeyFile == "" {
+		if tlsFiles.ClientCAFile != "" {
+			return nil, fmt.Errorf("verifying the client certificates requires a TLS certificate and key")
+		}
+		return server, nil
+	}
+	if tlsFiles.CertFile == "" || tlsFiles.KeyFile == "" {
+		return nil, fmt.Errorf("serving the metrics over TLS requires both a certificate and its private key")
+	}
+
+	certificate, err := tls.LoadX509KeyPair(tlsFiles.CertFile, tlsFiles.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the TLS certificate of the metrics server: %w", err)
+	}
+	server.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if tlsFiles.ClientCAFile != "" {
+		caBundle, err := os.ReadFile(tlsFiles.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the client CA bundle of the metrics server: %w", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificate found in the client CA bundle %s", tlsFiles.ClientCAFile)
+		}
+		server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		server.TLSConfig.ClientCAs = clientCAs
+	}
+	return server, nil
+}
+
+// ListenAndServe serves the metrics server, over TLS when it was created with a certificate
+func ListenAndServe(server *http.Server) error {
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+// WriteTextfile writes the metrics to the file at path - which the node exporter textfile collector expects to end in
+// .prom - replacing it at once so that the collector never reads a partial file
+func (r *Registry) WriteTextfile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := r.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(textfilePerms); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// RestoreTextfile adds the counts of the counters written to the file at path by a previous WriteTextfile, letting
+// the short-lived processes - e.g. the one-shot IP reconciler - accumulate them across runs. A missing file is not an
+// error; the series of unregistered metrics are ignored.
+func (r *Registry) RestoreTextfile(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, labelValues, value, err := parseSample(line)
+		if err != nil {
+			return fmt.Errorf("failed to parse the metrics of %s: %v", path, err)
+		}
+		if m, isRestorable := r.metrics[name].(restorable); isRestorable {
+			m.restore(labelValues, value)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseSample parses a line of the Prometheus text format holding an integer sample, e.g. name{label="value"} 1
+func parseSample(line string) (string, map[string]string, uint64, error) {
+	separator := strings.LastIndexByte(line, ' ')
+	if separator < 0 {
+		return "", nil, 0, fmt.Errorf("invalid sample %q", line)
+	}
+	value, err := strconv.ParseUint(line[separator+1:], 10, 64)
+	if err != nil {
+		// not a counter sample, e.g. the sum of a histogram
+		return "", nil, 0, nil
+	}
+
+	series := line[:separator]
+	labelsStart := strings.IndexByte(series, '{')
+	if labelsStart < 0 {
+		return series, nil, value, nil
+	}
+	if !strings.HasSuffix(series, "}") {
+		return "", nil, 0, fmt.Errorf("invalid sample %q", line)
+	}
+	labelValues := map[string]string{}
+	labels := series[labelsStart+1 : len(series)-1]
+	for labels != "" {
+		equals := strings.Index(labels, `="`)
+		if equals < 0 {
+			return "", nil, 0, fmt.Errorf("invalid labels in sample %q", line)
+		}
+		labelName := labels[:equals]
+		var labelValue strings.Builder
+		idx := equals + 2
+		for ; idx < len(labels) && labels[idx] != '"'; idx++ {
+			if labels[idx] == '\\' && idx+1 < len(labels) {
+				idx++
+				if labels[idx] == 'n' {
+					labelValue.WriteByte('\n')
+					continue
+				}
+			}
+			labelValue.WriteByte(labels[idx])
+		}
+		if idx >= len(labels) {
+			return "", nil, 0, fmt.Errorf("unterminated label value in sample %q", line)
+		}
+		labelValues[labelName] = labelValue.String()
+		labels = strings.TrimPrefix(labels[idx+1:], ",")
+	}
+	return series[:labelsStart], labelValues, value, nil
+}
+
+// Counter is a monotonically increasing count
+type Counter struct {
+	metricName string
+	help       string
+	value      atomic.Uint64
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add increments the counter by delta
+func (c *Counter) Add(delta uint64) {
+	c.value.Add(delta)
+}
+
+// Value returns the count
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+func (c *Counter) restore(labelValues map[string]string, value uint64) {
+	if len(labelValues) == 0 {
+		c.Add(value)
+	}
+}
+
+func (c *Counter) name() string {
+	return c.metricName
+}
+
+func (c *Counter) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.metricName, c.help, c.metricName, c.metricName, c.Value())
+}
+
+// CounterVec is a set of counters, one per combination of the values of its labels
+type CounterVec struct {
+	metricName string
+	help       string
+	labelNames []string
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labelValues []string
+	value       uint64
+}
+
+// Inc increments by one the counter of the label values, given in the order of the label names
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments by delta the counter of the label values, given in the order of the label names
+func (c *CounterVec) Add(delta uint64, labelValues ...string) {
+	if len(labelValues) != len(c.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", c.metricName, len(c.labelNames), len(labelValues)))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	series, found := c.series[key]
+	if !found {
+		series = &counterSeries{labelValues: append([]string{}, labelValues...)}
+		c.series[key] = series
+	}
+	series.value += delta
+}
+
+// Value returns the count of the label values, given in the order of the label names
+func (c *CounterVec) Value(labelValues ...string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if series, found := c.series[strings.Join(labelValues, "\xff")]; found {
+		return series.value
+	}
+	return 0
+}
+
+func (c *CounterVec) restore(labelValues map[string]string, value uint64) {
+	if len(labelValues) != len(c.labelNames) {
+		return
+	}
+	values := make([]string, 0, len(c.labelNames))
+	for _, labelName := range c.labelNames {
+		labelValue, found := labelValues[labelName]
+		if !found {
+			return
+		}
+		values = append(values, labelValue)
+	}
+	c.Add(value, values...)
+}
+
+func (c *CounterVec) name() string {
+	return c.metricName
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.metricName, c.help, c.metricName)
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := c.series[key]
+		labels := make([]string, 0, len(c.labelNames))
+		for i, labelName := range c.labelNames {
+			labels = append(labels, fmt.Sprintf("%s=\"%s\"", labelName, labelValueEscaper.Replace(series.labelValues[i])))
+		}
+		fmt.Fprintf(w, "%s{%s} %d\n", c.metricName, strings.Join(labels, ","), series.value)
+	}
+}
+
+// GaugeVec is a set of gauges, one per combination of the values of its labels
+type GaugeVec struct {
+	metricName string
+	help       string
+	labelNames []string
+
+	mu     sync.Mutex
+	series map[string]*gaugeSeries
+}
+
+type gaugeSeries struct {
+	labelValues []string
+	value       float64
+}
+
+// Set sets the gauge of the label values, given in the order of the label names
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	if len(labelValues) != len(g.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", g.metricName, len(g.labelNames), len(labelValues)))
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	series, found := g.series[key]
+	if !found {
+		series = &gaugeSeries{labelValues: append([]string{}, labelValues...)}
+		g.series[key] = series
+	}
+	series.value = value
+}
+
+// Delete drops the gauge of the label values, e.g. those of a deleted object, which is no longer written
+func (g *GaugeVec) Delete(labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.series, strings.Join(labelValues, "\xff"))
+}
+
+// Value returns the gauge of the label values, given in the order of the label names, and whether it is set
+func (g *GaugeVec) Value(labelValues ...string) (float64, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if series, found := g.series[strings.Join(labelValues, "\xff")]; found {
+		return series.value, true
+	}
+	return 0, false
+}
+
+func (g *GaugeVec) name() string {
+	return g.metricName
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.metricName, g.help, g.metricName)
+	keys := make([]string, 0, len(g.series))
+	for key := range g.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := g.series[key]
+		labels := make([]string, 0, len(g.labelNames))
+		for i, labelName := range g.labelNames {
+			labels = append(labels, fmt.Sprintf("%s=\"%s\"", labelName, labelValueEscaper.Replace(series.labelValues[i])))
+		}
+		fmt.Fprintf(w, "%s{%s} %s\n", g.metricName, strings.Join(labels, ","), formatFloat(series.value))
+	}
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	metricName string
+	help       string
+	buckets    []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records a value
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// ObserveSince records the seconds elapsed since start
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) name() string {
+	return h.metricName
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.metricName, h.help, h.metricName)
+	var cumulative uint64
+	for i, upperBound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.metricName, formatFloat(upperBound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.metricName, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", h.metricName, formatFloat(h.sum), h.metricName, h.count)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
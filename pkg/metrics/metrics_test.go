@@ -0,0 +1,272 @@
+package metrics
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const expectedText = `# HELP test_duration_seconds Duration of the tests
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 1
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 5.55
+test_duration_seconds_count 3
+# HELP test_runs_total Number of test runs
+# TYPE test_runs_total counter
+test_runs_total 2
+`
+
+func newTestRegistry() *Registry {
+	registry := NewRegistry()
+	runs := registry.NewCounter("test_runs_total", "Number of test runs")
+	duration := registry.NewHistogram("test_duration_seconds", "Duration of the tests", []float64{0.1, 1})
+	runs.Inc()
+	runs.Inc()
+	for _, value := range []float64{0.05, 0.5, 5} {
+		duration.Observe(value)
+	}
+	return registry
+}
+
+func TestWriteTo(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := newTestRegistry().WriteTo(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if buf.String() != expectedText {
+		t.Errorf("Expected the metrics:\n%s\ngot:\n%s", expectedText, buf.String())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	newTestRegistry().Handler().ServeHTTP(recorder, httptest.NewRequest("GET", Path, nil))
+	if contentType := recorder.Header().Get("Content-Type"); contentType != textContentType {
+		t.Errorf("Expected content type %q, got %q", textContentType, contentType)
+	}
+	if recorder.Body.String() != expectedText {
+		t.Errorf("Expected the metrics:\n%s\ngot:\n%s", expectedText, recorder.Body.String())
+	}
+}
+
+// writeCertificate writes a certificate of the name, signed by the parent - or self-signed without one - and its key
+// in PEM to the directory, returning the certificate, its key and their files
+func writeCertificate(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	for file, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	return certificate, key, certFile, keyFile
+}
+
+func TestNewServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, caFile, _ := writeCertificate(t, dir, "ca", nil, nil)
+	_, _, certFile, keyFile := writeCertificate(t, dir, "metrics", ca, caKey)
+	_, _, clientCertFile, clientKeyFile := writeCertificate(t, dir, "scraper", ca, caKey)
+
+	for _, invalid := range []TLSFiles{
+		{CertFile: certFile},
+		{ClientCAFile: caFile},
+		{CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile},
+	} {
+		if _, err := newTestRegistry().NewServer("127.0.0.1:0", invalid); err == nil {
+			t.Errorf("Expected an error creating a server with %+v, got none", invalid)
+		}
+	}
+
+	server, err := newTestRegistry().NewServer("127.0.0.1:0", TLSFiles{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	go func() { _ = server.ServeTLS(listener, "", "") }()
+	defer server.Close()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca)
+	scrape := func(certificates []tls.Certificate) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      rootCAs,
+			Certificates: certificates,
+			MinVersion:   tls.VersionTLS12,
+		}}}
+		response, err := client.Get("https://" + listener.Addr().String() + Path)
+		if err != nil {
+			return "", err
+		}
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		return string(body), err
+	}
+
+	if _, err := scrape(nil); err == nil {
+		t.Errorf("Expected scraping without client certificate to fail")
+	}
+	clientCertificate, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	body, err := scrape([]tls.Certificate{clientCertificate})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if body != expectedText {
+		t.Errorf("Expected the metrics:\n%s\ngot:\n%s", expectedText, body)
+	}
+}
+
+func TestWriteTextfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "whereabouts.prom")
+	if err := newTestRegistry().WriteTextfile(path); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if string(content) != expectedText {
+		t.Errorf("Expected the metrics:\n%s\ngot:\n%s", expectedText, content)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("Expected the temporary file to be renamed, got %v", entries)
+	}
+}
+
+const expectedLabeledText = `# HELP test_deletions_total Number of test deletions
+# TYPE test_deletions_total counter
+test_deletions_total{cause="gone",network="net\"1"} 1
+test_deletions_total{cause="stale",network="net1"} 2
+`
+
+func TestCounterVec(t *testing.T) {
+	registry := NewRegistry()
+	deletions := registry.NewCounterVec("test_deletions_total", "Number of test deletions", "cause", "network")
+	deletions.Inc("stale", "net1")
+	deletions.Inc("gone", `net"1`)
+	deletions.Inc("stale", "net1")
+
+	var buf bytes.Buffer
+	if _, err := registry.WriteTo(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if buf.String() != expectedLabeledText {
+		t.Errorf("Expected the metrics:\n%s\ngot:\n%s", expectedLabeledText, buf.String())
+	}
+	if value := deletions.Value("stale", "net1"); value != 2 {
+		t.Errorf("Expected 2, got %d", value)
+	}
+}
+
+const expectedGaugeText = `# HELP test_utilization_percent Utilization of the tests
+# TYPE test_utilization_percent gauge
+test_utilization_percent{pool="pool-a",network="net1"} 12.5
+`
+
+func TestGaugeVec(t *testing.T) {
+	registry := NewRegistry()
+	utilization := registry.NewGaugeVec("test_utilization_percent", "Utilization of the tests", "pool", "network")
+	utilization.Set(50, "pool-a", "net1")
+	utilization.Set(12.5, "pool-a", "net1")
+	utilization.Set(100, "pool-b", "net1")
+	utilization.Delete("pool-b", "net1")
+
+	var buf bytes.Buffer
+	if _, err := registry.WriteTo(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if buf.String() != expectedGaugeText {
+		t.Errorf("Expected the metrics:\n%s\ngot:\n%s", expectedGaugeText, buf.String())
+	}
+	if value, found := utilization.Value("pool-a", "net1"); !found || value != 12.5 {
+		t.Errorf("Expected 12.5, got %v", value)
+	}
+	if _, found := utilization.Value("pool-b", "net1"); found {
+		t.Errorf("Expected the deleted gauge not to be set")
+	}
+}
+
+func TestRestoreTextfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "whereabouts.prom")
+	previous := NewRegistry()
+	previousDeletions := previous.NewCounterVec("test_deletions_total", "Number of test deletions", "cause", "network")
+	previousDeletions.Inc("gone", `net"1`)
+	var buf bytes.Buffer
+	_, _ = newTestRegistry().WriteTo(&buf)
+	_, _ = previous.WriteTo(&buf)
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	registry := NewRegistry()
+	runs := registry.NewCounter("test_runs_total", "Number of test runs")
+	deletions := registry.NewCounterVec("test_deletions_total", "Number of test deletions", "cause", "network")
+	runs.Inc()
+	if err := registry.RestoreTextfile(path); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if value := runs.Value(); value != 3 {
+		t.Errorf("Expected the restored count to add up to 3, got %d", value)
+	}
+	if value := deletions.Value("gone", `net"1`); value != 1 {
+		t.Errorf("Expected the restored labeled count to be 1, got %d", value)
+	}
+
+	if err := NewRegistry().RestoreTextfile(filepath.Join(t.TempDir(), "missing.prom")); err != nil {
+		t.Errorf("Expected no error on a missing file, got %v", err)
+	}
+}
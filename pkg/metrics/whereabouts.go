@@ -0,0 +1,49 @@
+package metrics
+
+// Default is the registry of the metrics of the allocation path, exposed by the node daemon and the control loop. The
+// CNI plugin allocating on its own - without daemon_socket - exits right after each request, hence does not expose
+// them.
+var Default = NewRegistry()
+
+var (
+	// AllocationDuration tracks how long the ADDs take, from the start of the leader election to the update of the
+	// IP pools
+	AllocationDuration = Default.NewHistogram("whereabouts_allocation_duration_seconds",
+		"Duration of the IP allocations, leader election included", DurationBuckets)
+	// LeaderElectionWait tracks how long the ADDs and DELs wait to be elected leader
+	LeaderElectionWait = Default.NewHistogram("whereabouts_leader_election_wait_seconds",
+		"Time spent waiting to be elected leader before updating the IP pools", DurationBuckets)
+	// IPPoolUpdateRetries counts the updates of the IP pools retried after a conflict
+	IPPoolUpdateRetries = Default.NewCounter("whereabouts_ip_pool_update_retries_total",
+		"Number of IP pool updates retried after conflicting with another update")
+	// IPPoolExhaustions counts the allocations failing as their range has no free IP left
+	IPPoolExhaustions = Default.NewCounter("whereabouts_ip_pool_exhaustions_total",
+		"Number of allocations failing on a range with no free IP left")
+	// DeallocationFailures counts the DELs failing to release their IPs, which are left to the garbage collection
+	DeallocationFailures = Default.NewCounter("whereabouts_deallocation_failures_total",
+		"Number of IP releases failing")
+	// GarbageCollectedIPs counts the IPs of deleted pods released by the control loop
+	GarbageCollectedIPs = Default.NewCounter("whereabouts_garbage_collected_ips_total",
+		"Number of IPs of deleted pods released by the control loop")
+	// ReconcilerDeletions counts the IP pool allocations and cluster wide reservations deleted by the IP reconciler, by
+	// cause and network: e.g. a steady stream of pod_gone deletions on a network hints at a runtime never calling DEL
+	ReconcilerDeletions = Default.NewCounterVec("whereabouts_reconciler_deletions_total",
+		"Number of IP pool allocations and cluster wide reservations deleted by the IP reconciler, by cause and network",
+		"cause", "network")
+	// IPPoolCapacity is the number of IPs each IP pool can allocate, by pool and network
+	IPPoolCapacity = Default.NewGaugeVec("whereabouts_ippool_capacity",
+		"Number of IPs the IP pool can allocate, by pool and network", "pool", "network")
+	// IPPoolAllocated is the number of IPs of each IP pool allocated to pods or reserved for network services
+	IPPoolAllocated = Default.NewGaugeVec("whereabouts_ippool_allocated",
+		"Number of IPs of the IP pool allocated to pods or reserved for network services, by pool and network",
+		"pool", "network")
+	// IPPoolUtilization is the percentage of the capacity of each IP pool allocated: alerting on it warns before the
+	// allocations start failing on exhausted pools
+	IPPoolUtilization = Default.NewGaugeVec("whereabouts_ippool_utilization_percent",
+		"Percentage of the IPs of the IP pool allocated, by pool and network", "pool", "network")
+	// DeprecatedConfig counts the loads of network configurations using deprecated parameters, by parameter and
+	// network: it points at the network-attachment-definitions to update before the parameters are removed
+	DeprecatedConfig = Default.NewCounterVec("whereabouts_deprecated_config_total",
+		"Number of network configurations loaded using a deprecated parameter, by parameter and network",
+		"parameter", "network")
+)
@@ -0,0 +1,181 @@
+package node_controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	clientset "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+// NodeSlicesPath is the path the node slice assignments are served on
+const NodeSlicesPath = "/v1/nodeslices"
+
+const (
+	nodeSlicesNetworkParam = "network"
+	nodeSlicesNodeParam    = "node"
+)
+
+// NodeSlicePoolAssignments are the slices of the ranges of a node slice network, and the nodes they are assigned to
+type NodeSlicePoolAssignments struct {
+	// Network is the name of the NodeSlicePool, i.e. the network_name or, for unnamed networks, the name of the
+	// network configuration
+	Network          string                    `json:"network"`
+	Range            string                    `json:"range"`
+	SliceSize        string                    `json:"sliceSize"`
+	AdditionalRanges []v1alpha1.NodeSliceRange `json:"additionalRanges,omitempty"`
+	Slices           []NodeSliceAssignment     `json:"slices"`
+}
+
+// NodeSliceAssignment is a slice of the range, along with the slices of the additional ranges of dual-stack networks,
+// and the node it is assigned to - none for available slices
+type NodeSliceAssignment struct {
+	Node string `json:"node,omitempty"`
+	SliceUtilization
+	AdditionalSlices []SliceUtilization `json:"additionalSlices,omitempty"`
+}
+
+// SliceUtilization reports how many addresses of a slice are in use. Addresses excluded by the network configuration
+// are not accounted for, hence the capacity is an upper bound.
+type SliceUtilization struct {
+	SliceRange string `json:"sliceRange"`
+	// Capacity is the number of usable addresses of the slice
+	Capacity uint64 `json:"capacity"`
+	// Allocated is the number of addresses of the slice allocated to pods or reserved for network services
+	Allocated uint64 `json:"allocated"`
+}
+
+// NodeSliceAPI answers the read-only queries of the node slice assignments, from the NodeSlicePools and the IP pools
+// of their slices
+type NodeSliceAPI struct {
+	whereaboutsclientset clientset.Interface
+	namespace            string
+}
+
+// NewNodeSliceAPI returns a NodeSliceAPI for the NodeSlicePools and IP pools of the given namespace
+func NewNodeSliceAPI(whereaboutsclientset clientset.Interface, namespace string) *NodeSliceAPI {
+	return &NodeSliceAPI{
+		whereaboutsclientset: whereaboutsclientset,
+		namespace:            namespace,
+	}
+}
+
+// Assignments lists the slice assignments of the network - of all the node slice networks when empty - sorted by
+// network. Only the slices assigned to the node are listed when set.
+func (a *NodeSliceAPI) Assignments(ctx context.Context, network, node string) ([]NodeSlicePoolAssignments, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	var nodeSlicePools []v1alpha1.NodeSlicePool
+	if network != "" {
+		nodeSlicePool, err := a.whereaboutsclientset.WhereaboutsV1alpha1().NodeSlicePools(a.namespace).Get(ctxWithTimeout, network, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		nodeSlicePools = append(nodeSlicePools, *nodeSlicePool)
+	} else {
+		nodeSlicePoolList, err := a.whereaboutsclientset.WhereaboutsV1alpha1().NodeSlicePools(a.namespace).List(ctxWithTimeout, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		nodeSlicePools = nodeSlicePoolList.Items
+	}
+	sort.Slice(nodeSlicePools, func(i, j int) bool {
+		return nodeSlicePools[i].GetName() < nodeSlicePools[j].GetName()
+	})
+
+	ipPoolList, err := a.whereaboutsclientset.WhereaboutsV1alpha1().IPPools(a.namespace).List(ctxWithTimeout, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the IP pools: %w", err)
+	}
+	ipPools := make(map[string]*v1alpha1.IPPool, len(ipPoolList.Items))
+	for i := range ipPoolList.Items {
+		ipPools[ipPoolList.Items[i].GetName()] = &ipPoolList.Items[i]
+	}
+
+	assignments := make([]NodeSlicePoolAssignments, 0, len(nodeSlicePools))
+	for _, nodeSlicePool := range nodeSlicePools {
+		poolAssignments := NodeSlicePoolAssignments{
+			Network:          nodeSlicePool.GetName(),
+			Range:            nodeSlicePool.Spec.Range,
+			SliceSize:        nodeSlicePool.Spec.SliceSize,
+			AdditionalRanges: nodeSlicePool.Spec.AdditionalRanges,
+			Slices:           []NodeSliceAssignment{},
+		}
+		for _, allocation := range nodeSlicePool.Status.Allocations {
+			if node != "" && allocation.NodeName != node {
+				continue
+			}
+			assignment := NodeSliceAssignment{
+				Node:             allocation.NodeName,
+				SliceUtilization: sliceUtilization(ipPools, nodeSlicePool.GetName(), allocation.NodeName, allocation.SliceRange),
+			}
+			for _, sliceRange := range allocation.AdditionalSliceRanges {
+				assignment.AdditionalSlices = append(assignment.AdditionalSlices,
+					sliceUtilization(ipPools, nodeSlicePool.GetName(), allocation.NodeName, sliceRange))
+			}
+			poolAssignments.Slices = append(poolAssignments.Slices, assignment)
+		}
+		assignments = append(assignments, poolAssignments)
+	}
+	return assignments, nil
+}
+
+// sliceUtilization counts the addresses in use in the IP pool of the node's slice: the pool of a named network is
+// looked up first, then the pool of an unnamed one. Available slices, and slices without pool, have no allocation.
+func sliceUtilization(ipPools map[string]*v1alpha1.IPPool, network, node, sliceRange string) SliceUtilization {
+	utilization := SliceUtilization{SliceRange: sliceRange}
+	_, sliceNet, err := net.ParseCIDR(sliceRange)
+	if err != nil {
+		return utilization
+	}
+	utilization.Capacity = iphelpers.UsableIPCount(*sliceNet)
+	if node == "" {
+		return utilization
+	}
+
+	for _, networkName := range []string{network, wbclient.UnnamedNetwork} {
+		poolName := wbclient.IPPoolName(wbclient.PoolIdentifier{IpRange: sliceRange, NetworkName: networkName, NodeName: node})
+		if pool, found := ipPools[poolName]; found {
+			utilization.Allocated = uint64(len(pool.Spec.Allocations) + len(pool.Status.Reservations))
+			break
+		}
+	}
+	return utilization
+}
+
+// ServeHTTP answers GET /v1/nodeslices?network=<network>&node=<node> with the NodeSlicePoolAssignments, as JSON. Both
+// query parameters are optional.
+func (a *NodeSliceAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	network := r.URL.Query().Get(nodeSlicesNetworkParam)
+	assignments, err := a.Assignments(r.Context(), network, r.URL.Query().Get(nodeSlicesNodeParam))
+	if errors.IsNotFound(err) {
+		http.Error(w, fmt.Sprintf("network %s has no node slices", network), http.StatusNotFound)
+		return
+	} else if err != nil {
+		klog.FromContext(r.Context()).Error(err, "Error listing the node slice assignments")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(assignments); err != nil {
+		klog.FromContext(r.Context()).V(4).Info("Error writing the node slice assignments", "err", err)
+	}
+}
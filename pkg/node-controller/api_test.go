@@ -0,0 +1,107 @@
+package node_controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+)
+
+func newNodeSliceAPIClient() *fake.Clientset {
+	return fake.NewSimpleClientset(
+		newNodeSlicePool("net-a", "10.0.0.0/24", "/28", v1alpha1.NodeSlicePoolStatus{Allocations: []v1alpha1.NodeSliceAllocation{
+			{NodeName: "node1", SliceRange: "10.0.0.0/28"},
+			{NodeName: "node2", SliceRange: "10.0.0.16/28"},
+			{NodeName: "", SliceRange: "10.0.0.32/28"},
+		}}),
+		newNodeSlicePool("net-b", "10.1.0.0/16", "/24", v1alpha1.NodeSlicePoolStatus{Allocations: []v1alpha1.NodeSliceAllocation{
+			{NodeName: "node1", SliceRange: "10.1.0.0/24"},
+		}}),
+		&v1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "net-a-node1-10.0.0.0-28", Namespace: "default"},
+			Spec: v1alpha1.IPPoolSpec{Range: "10.0.0.0/28", Allocations: map[string]v1alpha1.IPAllocation{
+				"1": {PodRef: "default/pod-a"},
+				"2": {PodRef: "default/pod-b"},
+			}},
+			Status: v1alpha1.IPPoolStatus{Reservations: []v1alpha1.ServiceReservation{{Name: "gateway", IP: "10.0.0.14"}}},
+		},
+	)
+}
+
+func TestNodeSliceAssignments(t *testing.T) {
+	api := NewNodeSliceAPI(newNodeSliceAPIClient(), "default")
+
+	assignments, err := api.Assignments(context.TODO(), "net-a", "")
+	if err != nil {
+		t.Fatalf("Expected no error listing the assignments, got %v", err)
+	}
+	expected := []NodeSlicePoolAssignments{{
+		Network:   "net-a",
+		Range:     "10.0.0.0/24",
+		SliceSize: "/28",
+		Slices: []NodeSliceAssignment{
+			{Node: "node1", SliceUtilization: SliceUtilization{SliceRange: "10.0.0.0/28", Capacity: 14, Allocated: 3}},
+			{Node: "node2", SliceUtilization: SliceUtilization{SliceRange: "10.0.0.16/28", Capacity: 14}},
+			{SliceUtilization: SliceUtilization{SliceRange: "10.0.0.32/28", Capacity: 14}},
+		},
+	}}
+	if !reflect.DeepEqual(assignments, expected) {
+		t.Errorf("Expected the assignments %+v, got %+v", expected, assignments)
+	}
+
+	assignments, err = api.Assignments(context.TODO(), "", "node1")
+	if err != nil {
+		t.Fatalf("Expected no error listing the assignments of node1, got %v", err)
+	}
+	if len(assignments) != 2 || assignments[0].Network != "net-a" || assignments[1].Network != "net-b" {
+		t.Fatalf("Expected the assignments of both networks, got %+v", assignments)
+	}
+	for _, poolAssignments := range assignments {
+		if len(poolAssignments.Slices) != 1 || poolAssignments.Slices[0].Node != "node1" {
+			t.Errorf("Expected the only slice of node1 in network %s, got %+v", poolAssignments.Network, poolAssignments.Slices)
+		}
+	}
+}
+
+func TestNodeSliceAPI(t *testing.T) {
+	api := NewNodeSliceAPI(newNodeSliceAPIClient(), "default")
+
+	for _, tc := range []struct {
+		name           string
+		method         string
+		target         string
+		expectedStatus int
+		expectedSlices int
+	}{
+		{name: "network", method: http.MethodGet, target: NodeSlicesPath + "?network=net-a", expectedStatus: http.StatusOK, expectedSlices: 3},
+		{name: "network and node", method: http.MethodGet, target: NodeSlicesPath + "?network=net-a&node=node2", expectedStatus: http.StatusOK, expectedSlices: 1},
+		{name: "unknown network", method: http.MethodGet, target: NodeSlicesPath + "?network=missing", expectedStatus: http.StatusNotFound},
+		{name: "not a GET", method: http.MethodPost, target: NodeSlicesPath, expectedStatus: http.StatusMethodNotAllowed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			api.ServeHTTP(recorder, httptest.NewRequest(tc.method, tc.target, nil))
+			if recorder.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tc.expectedStatus, recorder.Code, recorder.Body.String())
+			}
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var assignments []NodeSlicePoolAssignments
+			if err := json.Unmarshal(recorder.Body.Bytes(), &assignments); err != nil {
+				t.Fatalf("Expected a JSON list of assignments, got %v", err)
+			}
+			if len(assignments) != 1 || len(assignments[0].Slices) != tc.expectedSlices {
+				t.Errorf("Expected %d slices, got %+v", tc.expectedSlices, assignments)
+			}
+		})
+	}
+}
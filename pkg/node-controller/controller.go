@@ -34,15 +34,12 @@ import (
 	whereaboutsInformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions/whereabouts.cni.cncf.io/v1alpha1"
 	whereaboutsListers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/listers/whereabouts.cni.cncf.io/v1alpha1"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/platform"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
 )
 
 const controllerAgentName = "node-controller"
 
-const (
-	whereaboutsConfigPath = "/etc/cni/net.d/whereabouts.d/whereabouts.conf"
-)
-
 // Controller is the controller implementation for Foo resources
 type Controller struct {
 	// kubeclientset is a standard kubernetes clientset
@@ -144,7 +141,16 @@ func NewController(
 	})
 
 	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    c.requeueNADs,
+		AddFunc: c.requeueNADs,
+		UpdateFunc: func(old, cur interface{}) {
+			// the slices of the nodes NotReady beyond node_unready_grace_period are released, and assigned anew
+			// once they are ready again
+			_, wasNotReady := nodeNotReadySince(old.(*corev1.Node))
+			_, notReady := nodeNotReadySince(cur.(*corev1.Node))
+			if wasNotReady != notReady {
+				c.requeueNADs(cur)
+			}
+		},
 		DeleteFunc: c.requeueNADs,
 	})
 
@@ -347,6 +353,15 @@ func (c *Controller) syncHandler(ctx context.Context, key string) error {
 
 	logger.Info("About to update node slices for network-attachment-definition",
 		"network-attachment-definition", klog.KRef(namespace, name))
+	desiredSpec := nodeSlicePoolSpec(ipamConf)
+	nodes, recheckAfter, err := c.getAssignableNodes(ctx, ipamConf)
+	if err != nil {
+		return err
+	}
+	if recheckAfter > 0 {
+		// a NotReady node exceeds the grace period by then, releasing its slice
+		c.workqueue.AddAfter(key, recheckAfter)
+	}
 
 	currentNodeSlicePool, err := c.nodeSlicePoolLister.NodeSlicePools(c.whereaboutsNamespace).Get(getSliceName(ipamConf))
 	if err != nil {
@@ -367,30 +382,16 @@ func (c *Controller) syncHandler(ctx context.Context, key string) error {
 					*metav1.NewControllerRef(nad, cncfV1.SchemeGroupVersion.WithKind("NetworkAttachmentDefinition")),
 				},
 			},
-			// only supports single range with node slice
-			Spec: v1alpha1.NodeSlicePoolSpec{
-				Range:     ipamConf.IPRanges[0].Range,
-				SliceSize: ipamConf.NodeSliceSize,
-			},
+			Spec: desiredSpec,
 		}
-		allocations := []v1alpha1.NodeSliceAllocation{}
 		logger.Info(fmt.Sprintf("node slice: %v", nodeslice))
 
 		//TODO: handle case when full, we could fire an event
-		subnets, err := iphelpers.DivideRangeBySize(nodeslice.Spec.Range, ipamConf.NodeSliceSize)
-		if err != nil {
-			return err
-		}
-		logger.Info(fmt.Sprintf("subnets: %v", subnets))
-		for _, subnet := range subnets {
-			allocations = append(allocations, v1alpha1.NodeSliceAllocation{
-				SliceRange: subnet,
-			})
-		}
-		nodes, err := c.getNodeList()
+		allocations, err := sliceAllocations(nodeslice.Spec)
 		if err != nil {
 			return err
 		}
+		logger.Info(fmt.Sprintf("slices: %v", allocations))
 		for _, node := range nodes {
 			logger.Info(fmt.Sprintf("assigning node to slice: %v", node.Name))
 			assignNodeToSlice(allocations, node.Name)
@@ -417,23 +418,11 @@ func (c *Controller) syncHandler(ctx context.Context, key string) error {
 			nodeslice.OwnerReferences = append(nodeslice.OwnerReferences, getAuxiliaryOwnerRef(nad))
 		}
 		// node slice currently exists
-		if currentNodeSlicePool.Spec.SliceSize != ipamConf.NodeSliceSize ||
-			currentNodeSlicePool.Spec.Range != ipamConf.IPRanges[0].Range {
+		if nodeSlicePoolSpecChanged(currentNodeSlicePool.Spec, desiredSpec) {
 			logger.Info("network-attachment-definition range or slice size changed, re-allocating node slices",
-				"new range", ipamConf.IPRanges[0].Range, "new slice size", ipamConf.NodeSliceSize)
+				"new range", desiredSpec.Range, "new slice size", desiredSpec.SliceSize, "new additional ranges", desiredSpec.AdditionalRanges)
 			// slices have changed so redo the slicing and reassign nodes
-			subnets, err := iphelpers.DivideRangeBySize(ipamConf.IPRanges[0].Range, ipamConf.NodeSliceSize)
-			if err != nil {
-				return err
-			}
-
-			allocations := []v1alpha1.NodeSliceAllocation{}
-			for _, subnet := range subnets {
-				allocations = append(allocations, v1alpha1.NodeSliceAllocation{
-					SliceRange: subnet,
-				})
-			}
-			nodes, err := c.getNodeList()
+			allocations, err := sliceAllocations(desiredSpec)
 			if err != nil {
 				return err
 			}
@@ -441,10 +430,7 @@ func (c *Controller) syncHandler(ctx context.Context, key string) error {
 				assignNodeToSlice(allocations, node.Name)
 			}
 
-			nodeslice.Spec = v1alpha1.NodeSlicePoolSpec{
-				Range:     ipamConf.IPRanges[0].Range,
-				SliceSize: ipamConf.NodeSliceSize,
-			}
+			nodeslice.Spec = desiredSpec
 			nodeslice.Status = v1alpha1.NodeSlicePoolStatus{
 				Allocations: allocations,
 			}
@@ -456,17 +442,13 @@ func (c *Controller) syncHandler(ctx context.Context, key string) error {
 			logger.Info("node slice exists and range configuration did not change, ensuring nodes assigned")
 			//slices have not changed so only make sure all nodes are assigned
 			allocations := nodeslice.Status.Allocations
-			nodes, err := c.getNodeList()
-			if err != nil {
-				return err
-			}
 			for _, node := range nodes {
 				assignNodeToSlice(allocations, node.Name)
 			}
 			removeUnusedNodes(allocations, nodes)
 			nodeslice.Status.Allocations = allocations
 
-			_, err = c.whereaboutsclientset.WhereaboutsV1alpha1().NodeSlicePools(c.whereaboutsNamespace).Update(context.TODO(), nodeslice, metav1.UpdateOptions{})
+			_, err = c.whereaboutsclientset.WhereaboutsV1alpha1().NodeSlicePools(c.whereaboutsNamespace).Update(ctx, nodeslice, metav1.UpdateOptions{})
 			if err != nil {
 				logger.Info(fmt.Sprintf("Error updating NSP with no changes: %v", err))
 				return err
@@ -491,6 +473,53 @@ func (c *Controller) getNodeList() ([]*corev1.Node, error) {
 	return nodes, nil
 }
 
+// getAssignableNodes returns the nodes which may hold a slice of the network. With node_unready_grace_period set, the
+// nodes NotReady - or unreachable - for longer are left out, releasing their slices to the nodes joining. It also
+// returns how long until the next NotReady node exceeds the grace period, zero when none is within it.
+func (c *Controller) getAssignableNodes(ctx context.Context, ipamConf *types.IPAMConfig) ([]*corev1.Node, time.Duration, error) {
+	nodes, err := c.getNodeList()
+	if err != nil || ipamConf.NodeUnreadyGracePeriod == "" {
+		return nodes, 0, err
+	}
+	gracePeriod, err := time.ParseDuration(ipamConf.NodeUnreadyGracePeriod)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	now := time.Now()
+	var recheckAfter time.Duration
+	assignable := make([]*corev1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		notReadySince, notReady := nodeNotReadySince(node)
+		if !notReady {
+			assignable = append(assignable, node)
+			continue
+		}
+		remaining := gracePeriod - now.Sub(notReadySince)
+		if remaining <= 0 {
+			klog.FromContext(ctx).Info("leaving out the node NotReady for longer than the grace period",
+				"node", node.Name, "notReadySince", notReadySince, "gracePeriod", gracePeriod)
+			continue
+		}
+		assignable = append(assignable, node)
+		if recheckAfter == 0 || remaining < recheckAfter {
+			recheckAfter = remaining
+		}
+	}
+	return assignable, recheckAfter, nil
+}
+
+// nodeNotReadySince returns since when the Ready condition of the node is not true, i.e. false, or unknown once the
+// node is unreachable. The nodes without Ready condition yet are deemed ready.
+func nodeNotReadySince(node *corev1.Node) (time.Time, bool) {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady && condition.Status != corev1.ConditionTrue {
+			return condition.LastTransitionTime.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
 // since multiple NADs can be attached to the same network, we need to make sure their settings match in this case
 func (c *Controller) checkForMultiNadMismatch(name, namespace string) error {
 	nad, err := c.nadLister.NetworkAttachmentDefinitions(namespace).Get(name)
@@ -523,11 +552,69 @@ func (c *Controller) checkForMultiNadMismatch(name, namespace string) error {
 
 func checkIpamConfMatch(conf1, conf2 *types.IPAMConfig) bool {
 	if conf1.NetworkName == conf2.NetworkName {
-		return conf1.IPRanges[0].Range == conf2.IPRanges[0].Range && conf1.NodeSliceSize == conf2.NodeSliceSize
+		return !nodeSlicePoolSpecChanged(nodeSlicePoolSpec(conf1), nodeSlicePoolSpec(conf2))
 	}
 	return true
 }
 
+// nodeSlicePoolSpec returns the spec of the NodeSlicePool of the network: its first range along with, for a dual-stack
+// network, the range of the other IP family
+func nodeSlicePoolSpec(ipamConf *types.IPAMConfig) v1alpha1.NodeSlicePoolSpec {
+	spec := v1alpha1.NodeSlicePoolSpec{
+		Range:     ipamConf.IPRanges[0].Range,
+		SliceSize: ipamConf.RangeNodeSliceSize(ipamConf.IPRanges[0]),
+	}
+	for _, ipRange := range ipamConf.IPRanges[1:] {
+		spec.AdditionalRanges = append(spec.AdditionalRanges, v1alpha1.NodeSliceRange{
+			Range:     ipRange.Range,
+			SliceSize: ipamConf.RangeNodeSliceSize(ipRange),
+		})
+	}
+	return spec
+}
+
+func nodeSlicePoolSpecChanged(current, desired v1alpha1.NodeSlicePoolSpec) bool {
+	if current.Range != desired.Range || current.SliceSize != desired.SliceSize ||
+		len(current.AdditionalRanges) != len(desired.AdditionalRanges) {
+		return true
+	}
+	for i := range desired.AdditionalRanges {
+		if current.AdditionalRanges[i] != desired.AdditionalRanges[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// sliceAllocations divides the ranges of the spec in slices, the nth allocation holding the nth slice of every range.
+// There are as many allocations as slices of the range divided in the fewest.
+func sliceAllocations(spec v1alpha1.NodeSlicePoolSpec) ([]v1alpha1.NodeSliceAllocation, error) {
+	subnets, err := iphelpers.DivideRangeBySize(spec.Range, spec.SliceSize)
+	if err != nil {
+		return nil, err
+	}
+	allocations := []v1alpha1.NodeSliceAllocation{}
+	for _, subnet := range subnets {
+		allocations = append(allocations, v1alpha1.NodeSliceAllocation{
+			SliceRange: subnet,
+		})
+	}
+
+	for _, additionalRange := range spec.AdditionalRanges {
+		additionalSubnets, err := iphelpers.DivideRangeBySize(additionalRange.Range, additionalRange.SliceSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(additionalSubnets) < len(allocations) {
+			allocations = allocations[:len(additionalSubnets)]
+		}
+		for i := range allocations {
+			allocations[i].AdditionalSliceRanges = append(allocations[i].AdditionalSliceRanges, additionalSubnets[i])
+		}
+	}
+	return allocations, nil
+}
+
 func hasOwnerRef(nodeSlice *v1alpha1.NodeSlicePool, name string) bool {
 	for _, ownerRef := range nodeSlice.OwnerReferences {
 		if ownerRef.Name == name {
@@ -565,16 +652,14 @@ func removeUnusedNodes(allocations []v1alpha1.NodeSliceAllocation, nodes []*core
 	for i, allocation := range allocations {
 		if allocation.NodeName != "" {
 			if _, ok := nodeMap[allocation.NodeName]; !ok {
-				allocations[i] = v1alpha1.NodeSliceAllocation{
-					SliceRange: allocation.SliceRange,
-				}
+				allocations[i].NodeName = ""
 			}
 		}
 	}
 }
 
 func ipamConfiguration(nad *cncfV1.NetworkAttachmentDefinition, mountPath string) (*types.IPAMConfig, error) {
-	mounterWhereaboutsConfigFilePath := mountPath + whereaboutsConfigPath
+	mounterWhereaboutsConfigFilePath := mountPath + platform.WhereaboutsConfigPath
 
 	ipamConfig, err := config.LoadIPAMConfiguration([]byte(nad.Spec.Config), "", mounterWhereaboutsConfigFilePath)
 	if err != nil {
@@ -589,10 +674,7 @@ func assignNodeToSlice(allocations []v1alpha1.NodeSliceAllocation, nodeName stri
 	}
 	for i, allocation := range allocations {
 		if allocation.NodeName == "" {
-			allocations[i] = v1alpha1.NodeSliceAllocation{
-				SliceRange: allocation.SliceRange,
-				NodeName:   nodeName,
-			}
+			allocations[i].NodeName = nodeName
 			return
 		}
 	}
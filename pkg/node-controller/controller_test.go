@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -116,6 +117,30 @@ func newNad(name string, networkName string, networkRange string, sliceSize stri
 	}
 }
 
+func newDualStackNad(name string, networkName string, v4Range string, v4SliceSize string, v6Range string, v6SliceSize string) *k8snetplumbersv1.NetworkAttachmentDefinition {
+	nad := newNad(name, networkName, v4Range, v4SliceSize)
+	nad.Spec.Config = fmt.Sprintf(`
+		{
+			"cniVersion": "0.3.1",
+			"name": "test-name",
+			"plugins": [
+				{
+					"type": "macvlan",
+					"master": "test",
+					"ipam": {
+						"configuration_path": "/tmp/whereabouts.conf",
+						"type": "whereabouts",
+						"ipRanges": [{"range": "%s"}, {"range": "%s", "node_slice_size": "%s"}],
+						"node_slice_size": "%s",
+						"network_name": "%s",
+						"enable_overlapping_ranges": false
+					}
+				}
+			]
+		}`, v4Range, v6Range, v6SliceSize, v4SliceSize, networkName)
+	return nad
+}
+
 func getOwnerRefs(nads []*k8snetplumbersv1.NetworkAttachmentDefinition) []metav1.OwnerReference {
 	if len(nads) == 1 {
 		return []metav1.OwnerReference{
@@ -452,6 +477,75 @@ func TestCreatesNodeSlicePoolsWithNodes(t *testing.T) {
 	f.run(context.TODO(), getKey(nad, t))
 }
 
+// TestCreatesIPv6NodeSlicePoolsWithNodes tests that a new nad of an IPv6 range results in its nodeslicepool being
+// created correctly
+func TestCreatesIPv6NodeSlicePoolsWithNodes(t *testing.T) {
+	f := newFixture(t)
+	nad := newNad("test", "test", "fd00:10::/62", "/64")
+	node1 := newNode("node1")
+	node2 := newNode("node2")
+	nodeSlicePool := newNodeSlicePool("test", "fd00:10::/62", "/64",
+		v1alpha1.NodeSlicePoolStatus{
+			Allocations: []v1alpha1.NodeSliceAllocation{
+				{
+					NodeName:   "node1",
+					SliceRange: "fd00:10::/64",
+				},
+				{
+					NodeName:   "node2",
+					SliceRange: "fd00:10:0:1::/64",
+				},
+				{
+					NodeName:   "",
+					SliceRange: "fd00:10:0:2::/64",
+				},
+				{
+					NodeName:   "",
+					SliceRange: "fd00:10:0:3::/64",
+				},
+			},
+		}, nad)
+
+	f.nadLister = append(f.nadLister, nad)
+	f.nodeLister = append(f.nodeLister, node1, node2)
+	f.kubeobjects = append(f.kubeobjects, node1, node2)
+	f.nadObjects = append(f.nadObjects, nad)
+	f.expectNodeSlicePoolCreateAction(nodeSlicePool)
+
+	f.run(context.TODO(), getKey(nad, t))
+}
+
+func TestCreatesDualStackNodeSlicePoolsWithNodes(t *testing.T) {
+	f := newFixture(t)
+	nad := newDualStackNad("test", "test", "10.0.0.0/23", "/24", "fd00:10::/61", "/64")
+	node1 := newNode("node1")
+	node2 := newNode("node2")
+	nodeSlicePool := newNodeSlicePool("test", "10.0.0.0/23", "/24",
+		v1alpha1.NodeSlicePoolStatus{
+			Allocations: []v1alpha1.NodeSliceAllocation{
+				{
+					NodeName:              "node1",
+					SliceRange:            "10.0.0.0/24",
+					AdditionalSliceRanges: []string{"fd00:10::/64"},
+				},
+				{
+					NodeName:              "node2",
+					SliceRange:            "10.0.1.0/24",
+					AdditionalSliceRanges: []string{"fd00:10:0:1::/64"},
+				},
+			},
+		}, nad)
+	nodeSlicePool.Spec.AdditionalRanges = []v1alpha1.NodeSliceRange{{Range: "fd00:10::/61", SliceSize: "/64"}}
+
+	f.nadLister = append(f.nadLister, nad)
+	f.nodeLister = append(f.nodeLister, node1, node2)
+	f.kubeobjects = append(f.kubeobjects, node1, node2)
+	f.nadObjects = append(f.nadObjects, nad)
+	f.expectNodeSlicePoolCreateAction(nodeSlicePool)
+
+	f.run(context.TODO(), getKey(nad, t))
+}
+
 // TestDoNothing checks for no action taken when no nad exists
 func TestDoNothing(t *testing.T) {
 	f := newFixture(t)
@@ -579,6 +673,84 @@ func TestNodeLeaves(t *testing.T) {
 	f.run(context.TODO(), getKey(nad, t))
 }
 
+func newNotReadyNode(name string, notReadySince time.Time) *v1.Node {
+	node := newNode(name)
+	node.Status.Conditions = []v1.NodeCondition{{
+		Type:               v1.NodeReady,
+		Status:             v1.ConditionUnknown,
+		LastTransitionTime: metav1.NewTime(notReadySince),
+	}}
+	return node
+}
+
+// TestNodeNotReadyBeyondGracePeriod tests the release of the slice of a node NotReady for longer than the
+// node_unready_grace_period of the network
+func TestNodeNotReadyBeyondGracePeriod(t *testing.T) {
+	f := newFixture(t)
+	nad := newNad("test", "test", "10.0.0.0/8", "/10")
+	nad.Spec.Config = strings.Replace(nad.Spec.Config, `"enable_overlapping_ranges": false`,
+		`"enable_overlapping_ranges": false, "node_unready_grace_period": "10m"`, 1)
+	nodes := []*v1.Node{
+		newNotReadyNode("node1", time.Now().Add(-time.Hour)),
+		newNotReadyNode("node2", time.Now().Add(-time.Minute)),
+		newNode("node3"),
+	}
+	nodeSlicePool := newNodeSlicePool("test", "10.0.0.0/8", "/10",
+		v1alpha1.NodeSlicePoolStatus{
+			Allocations: []v1alpha1.NodeSliceAllocation{
+				{
+					NodeName:   "node1",
+					SliceRange: "10.0.0.0/10",
+				},
+				{
+					NodeName:   "node2",
+					SliceRange: "10.64.0.0/10",
+				},
+				{
+					NodeName:   "",
+					SliceRange: "10.128.0.0/10",
+				},
+				{
+					NodeName:   "",
+					SliceRange: "10.192.0.0/10",
+				},
+			},
+		}, nad)
+
+	expectedNodeSlicePool := newNodeSlicePool("test", "10.0.0.0/8", "/10",
+		v1alpha1.NodeSlicePoolStatus{
+			Allocations: []v1alpha1.NodeSliceAllocation{
+				{
+					NodeName:   "",
+					SliceRange: "10.0.0.0/10",
+				},
+				{
+					NodeName:   "node2",
+					SliceRange: "10.64.0.0/10",
+				},
+				{
+					NodeName:   "node3",
+					SliceRange: "10.128.0.0/10",
+				},
+				{
+					NodeName:   "",
+					SliceRange: "10.192.0.0/10",
+				},
+			},
+		}, nad)
+
+	f.nadLister = append(f.nadLister, nad)
+	f.nadObjects = append(f.nadObjects, nad)
+	f.nodeSlicePoolLister = append(f.nodeSlicePoolLister, nodeSlicePool)
+	f.whereaboutsObjects = append(f.whereaboutsObjects, nodeSlicePool)
+	for _, node := range nodes {
+		f.kubeobjects = append(f.kubeobjects, node)
+		f.nodeLister = append(f.nodeLister, node)
+	}
+	f.expectNodeSlicePoolUpdateAction(expectedNodeSlicePool)
+	f.run(context.TODO(), getKey(nad, t))
+}
+
 // TestNadDelete tests the deletion of NodeSlicePool after its only owning NAD is deleted
 func TestNadDelete(t *testing.T) {
 	f := newFixture(t)
@@ -0,0 +1,95 @@
+package node_controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+// LeaseName is the lease the node slice controller replicas compete for
+const LeaseName = "whereabouts-node-slice-controller"
+
+// LeaderElectionConfig is the lease the node slice controller replicas compete for, and its timings
+type LeaderElectionConfig struct {
+	LeaseName      string
+	LeaseNamespace string
+	Identity       string
+	// LeaseDuration is how long the standby replicas wait before taking over a lease which was not renewed
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader retries renewing the lease before giving it up
+	RenewDeadline time.Duration
+	// RetryPeriod is the period between two attempts to acquire, or renew, the lease
+	RetryPeriod time.Duration
+}
+
+// RunWithLeaderElection competes with the other replicas for the lease, running the controller - which returns once
+// its context is cancelled - while holding it, so that a single replica updates the NodeSlicePools at a time. It
+// blocks until the context is cancelled, or the controller fails. Losing the lease is an error: the replica must exit
+// rather than run on the caches it built as the leader.
+func RunWithLeaderElection(ctx context.Context, kubeClient kubernetes.Interface, config LeaderElectionConfig, run func(ctx context.Context) error) error {
+	logger := klog.FromContext(ctx)
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      config.LeaseName,
+			Namespace: config.LeaseNamespace,
+		},
+		Client: kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: config.Identity,
+		},
+	}
+
+	electionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	started := make(chan struct{})
+	runErr := make(chan error, 1)
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            config.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				close(started)
+				logger.Info("Elected leader", "lease", config.LeaseName, "identity", config.Identity)
+				runErr <- run(leaderCtx)
+				// a failed controller gives the lease up for another replica to take over
+				cancel()
+			},
+			OnStoppedLeading: func() {
+				logger.Info("No longer the leader", "lease", config.LeaseName, "identity", config.Identity)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != config.Identity {
+					logger.Info("Standing by", "lease", config.LeaseName, "leader", identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create the leader elector: %w", err)
+	}
+	le.Run(electionCtx)
+
+	select {
+	case <-started:
+		// the controller stops along with the leader context
+		if err := <-runErr; err != nil {
+			return err
+		}
+	default:
+	}
+	if ctx.Err() == nil {
+		return fmt.Errorf("lost lease %s/%s", config.LeaseNamespace, config.LeaseName)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package node_controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func testLeaderElectionConfig(identity string) LeaderElectionConfig {
+	return LeaderElectionConfig{
+		LeaseName:      LeaseName,
+		LeaseNamespace: "kube-system",
+		Identity:       identity,
+		LeaseDuration:  time.Second,
+		RenewDeadline:  500 * time.Millisecond,
+		RetryPeriod:    100 * time.Millisecond,
+	}
+}
+
+func TestRunWithLeaderElectionSingleLeader(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset()
+	leading := make(chan string, 2)
+	cancels := map[string]context.CancelFunc{}
+	results := map[string]chan error{}
+	for _, identity := range []string{"replica-a", "replica-b"} {
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		cancels[identity] = cancel
+		result := make(chan error, 1)
+		results[identity] = result
+		go func(identity string) {
+			result <- RunWithLeaderElection(ctx, kubeClient, testLeaderElectionConfig(identity), func(leaderCtx context.Context) error {
+				leading <- identity
+				<-leaderCtx.Done()
+				return nil
+			})
+		}(identity)
+	}
+
+	var leader string
+	select {
+	case leader = <-leading:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no replica was elected")
+	}
+	select {
+	case identity := <-leading:
+		t.Fatalf("both %s and %s run the controller", leader, identity)
+	case <-time.After(2 * time.Second):
+	}
+
+	cancels[leader]()
+	if err := <-results[leader]; err != nil {
+		t.Errorf("the stopped leader failed: %v", err)
+	}
+	select {
+	case identity := <-leading:
+		if identity == leader {
+			t.Errorf("the stopped leader %s was elected again", leader)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the standby replica did not take over")
+	}
+}
+
+func TestRunWithLeaderElectionControllerFailure(t *testing.T) {
+	failure := errors.New("caches did not sync")
+	err := RunWithLeaderElection(context.TODO(), k8sfake.NewSimpleClientset(), testLeaderElectionConfig("replica-a"), func(_ context.Context) error {
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("expected the controller failure, got: %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+// Package platform abstracts the node facing details - host file paths and
+// node name discovery - which differ between the operating systems whereabouts
+// runs on.
+package platform
+
+import (
+	"os"
+	"strings"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+)
+
+const nodeNameEnvVariable = "NODENAME"
+
+// NodeName returns the name of the node the process runs on. The NODENAME
+// environment variable takes precedence over the discovered hostname.
+func NodeName() (string, error) {
+	if envName := strings.TrimSpace(os.Getenv(nodeNameEnvVariable)); envName != "" {
+		return envName, nil
+	}
+
+	name, err := hostname()
+	if err != nil {
+		return "", err
+	}
+	logging.Debugf("discovered current hostname as: %s", name)
+	return name, nil
+}
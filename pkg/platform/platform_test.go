@@ -0,0 +1,44 @@
+package platform
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPlatform(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "platform")
+}
+
+var _ = Describe("Node name discovery", func() {
+	var previousNodeName string
+
+	BeforeEach(func() {
+		previousNodeName = os.Getenv(nodeNameEnvVariable)
+	})
+
+	AfterEach(func() {
+		Expect(os.Setenv(nodeNameEnvVariable, previousNodeName)).To(Succeed())
+	})
+
+	It("prefers the NODENAME environment variable", func() {
+		Expect(os.Setenv(nodeNameEnvVariable, " worker-1 \n")).To(Succeed())
+		Expect(NodeName()).To(Equal("worker-1"))
+	})
+
+	It("falls back to the host's name", func() {
+		Expect(os.Setenv(nodeNameEnvVariable, "")).To(Succeed())
+		expectedName, err := hostname()
+		if err != nil {
+			Skip("the host name cannot be discovered in this environment")
+		}
+		Expect(NodeName()).To(Equal(expectedName))
+	})
+
+	It("includes the flat configuration file path in the search locations", func() {
+		Expect(FlatConfigPaths()).To(ContainElement(WhereaboutsConfigPath))
+	})
+})
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package platform
+
+import (
+	"os"
+	"strings"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+)
+
+const (
+	// HostMountPath is where the control loop finds the host's filesystem
+	HostMountPath = "/host"
+	// WhereaboutsConfigPath is the location of the whereabouts flat configuration file on the host
+	WhereaboutsConfigPath = "/etc/cni/net.d/whereabouts.d/whereabouts.conf"
+
+	hostnamePath = "/etc/hostname"
+)
+
+// FlatConfigPaths lists the locations where the whereabouts flat configuration file is searched for
+func FlatConfigPaths() []string {
+	return []string{
+		"/etc/kubernetes/cni/net.d/whereabouts.d/whereabouts.conf",
+		WhereaboutsConfigPath,
+		HostMountPath + WhereaboutsConfigPath,
+	}
+}
+
+func hostname() (string, error) {
+	data, err := os.ReadFile(hostnamePath)
+	if err != nil {
+		logging.Errorf("Error reading file %s: %v", hostnamePath, err)
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
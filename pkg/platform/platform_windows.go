@@ -0,0 +1,37 @@
+//go:build windows
+
+package platform
+
+import (
+	"os"
+	"strings"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+)
+
+const (
+	// HostMountPath is where the control loop finds the host's filesystem; host process containers
+	// share it, hence no prefix is required
+	HostMountPath = ""
+	// WhereaboutsConfigPath is the location of the whereabouts flat configuration file on the host
+	WhereaboutsConfigPath = `C:\etc\cni\net.d\whereabouts.d\whereabouts.conf`
+)
+
+// FlatConfigPaths lists the locations where the whereabouts flat configuration file is searched for
+func FlatConfigPaths() []string {
+	return []string{
+		`C:\k\cni\config\whereabouts.d\whereabouts.conf`,
+		WhereaboutsConfigPath,
+	}
+}
+
+// hostname returns the computer name; kubelet registers Windows nodes using
+// its lowercase form
+func hostname() (string, error) {
+	name, err := os.Hostname()
+	if err != nil {
+		logging.Errorf("Error discovering the hostname: %v", err)
+		return "", err
+	}
+	return strings.ToLower(strings.TrimSpace(name)), nil
+}
@@ -0,0 +1,49 @@
+// Package profiling serves the pprof and runtime debug endpoints of the whereabouts controllers, e.g. to profile the
+// memory growth of their informer caches on big clusters.
+package profiling
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+const readHeaderTimeout = 5 * time.Second
+
+// NewServer returns a server exposing the pprof endpoints under /debug/pprof/, and the runtime memory statistics under
+// /debug/vars. As these disclose the internals of the process, the address must be a loopback one.
+func NewServer(address string) (*http.Server, error) {
+	if err := validateLoopback(address); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}, nil
+}
+
+func validateLoopback(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid profiling address %q: %w", address, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("refusing to serve the profiling endpoints on %q: only loopback addresses are allowed", address)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package profiling
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewServer(t *testing.T) {
+	cases := []struct {
+		name          string
+		address       string
+		expectedError bool
+	}{
+		{
+			name:    "IPv4 loopback",
+			address: "127.0.0.1:6060",
+		},
+		{
+			name:    "IPv6 loopback",
+			address: "[::1]:6060",
+		},
+		{
+			name:    "Localhost",
+			address: "localhost:6060",
+		},
+		{
+			name:          "All interfaces",
+			address:       ":6060",
+			expectedError: true,
+		},
+		{
+			name:          "Routable address",
+			address:       "10.0.0.1:6060",
+			expectedError: true,
+		},
+		{
+			name:          "Missing port",
+			address:       "127.0.0.1",
+			expectedError: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, err := NewServer(tc.address)
+			if tc.expectedError {
+				if err == nil {
+					t.Errorf("Expected an error for address %q, got none", tc.address)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			for _, path := range []string{"/debug/pprof/", "/debug/vars"} {
+				recorder := httptest.NewRecorder()
+				server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+				if recorder.Code != http.StatusOK {
+					t.Errorf("Expected status %d for %s, got %d", http.StatusOK, path, recorder.Code)
+				}
+			}
+		})
+	}
+}
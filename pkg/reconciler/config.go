@@ -1,28 +1,41 @@
 package reconciler
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/go-co-op/gocron/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/tools/cache"
 
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/config"
+	wbclientset "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
 )
 
 type ConfigWatcher struct {
-	configDir       string
-	configPath      string
-	currentSchedule string
-	job             gocron.Job
-	scheduler       gocron.Scheduler
-	handlerFunc     func()
-	jobFactoryFunc  func(string) gocron.JobDefinition
-	watcher         *fsnotify.Watcher
+	configDir  string
+	configPath string
+	// fileSchedule is the cron expression of the configuration file, or else of the flat file
+	fileSchedule string
+	// reconcilerConfig are the settings of the watched WhereaboutsConfig, overriding the file schedule
+	reconcilerConfig whereaboutsv1alpha1.ReconcilerConfig
+	currentSchedule  string
+	lock             sync.Mutex
+	job              gocron.Job
+	scheduler        gocron.Scheduler
+	handlerFunc      func()
+	jobFactoryFunc   func(string) gocron.JobDefinition
+	watcher          *fsnotify.Watcher
 }
 
 func NewConfigWatcher(configPath string, scheduler gocron.Scheduler, configWatcher *fsnotify.Watcher, handlerFunc func()) (*ConfigWatcher, error) {
@@ -60,6 +73,7 @@ func newConfigWatcher(
 	return &ConfigWatcher{
 		configDir:       filepath.Dir(configPath),
 		configPath:      configPath,
+		fileSchedule:    schedule,
 		currentSchedule: schedule,
 		job:             job,
 		scheduler:       scheduler,
@@ -107,6 +121,7 @@ func (c *ConfigWatcher) syncConfig(relevantEventPredicate func(event fsnotify.Ev
 			updatedSchedule, err := determineCronExpression(c.configPath)
 			if err != nil {
 				_ = logging.Errorf("error determining cron expression from %q: %v", c.configPath, err)
+				continue
 			}
 			logging.Verbosef(
 				"configuration updated to file %q. New cron expression: %s",
@@ -114,24 +129,10 @@ func (c *ConfigWatcher) syncConfig(relevantEventPredicate func(event fsnotify.Ev
 				updatedSchedule,
 			)
 
-			if updatedSchedule == c.currentSchedule {
-				logging.Debugf("no changes in schedule, nothing to do.")
-				continue
-			}
-			updatedJob, err := c.scheduler.Update(
-				c.job.ID(),
-				c.jobFactoryFunc(updatedSchedule),
-				gocron.NewTask(c.handlerFunc),
-			)
-			if err != nil {
-				_ = logging.Errorf("error updating job %q configuration: %v", c.job.ID().String(), err)
-			}
-			c.currentSchedule = updatedSchedule
-			logging.Verbosef(
-				"successfully updated CRON configuration id %q - new cron expression: %s",
-				updatedJob.ID().String(),
-				updatedSchedule,
-			)
+			c.lock.Lock()
+			c.fileSchedule = updatedSchedule
+			c.reschedule()
+			c.lock.Unlock()
 		case err, ok := <-c.watcher.Errors:
 			_ = logging.Errorf("error when listening to config changes: %v", err)
 			if !ok {
@@ -140,3 +141,87 @@ func (c *ConfigWatcher) syncConfig(relevantEventPredicate func(event fsnotify.Ev
 		}
 	}
 }
+
+// reschedule updates the job after the effective schedule: the schedule, or else the interval, of the
+// WhereaboutsConfig, or else the file schedule. The caller holds the lock.
+func (c *ConfigWatcher) reschedule() {
+	updatedSchedule := c.fileSchedule
+	if c.reconcilerConfig.Schedule != "" {
+		updatedSchedule = c.reconcilerConfig.Schedule
+	} else if c.reconcilerConfig.Interval != nil && c.reconcilerConfig.Interval.Duration > 0 {
+		updatedSchedule = "@every " + c.reconcilerConfig.Interval.Duration.String()
+	}
+
+	if updatedSchedule == c.currentSchedule {
+		logging.Debugf("no changes in schedule, nothing to do.")
+		return
+	}
+	updatedJob, err := c.scheduler.Update(
+		c.job.ID(),
+		c.jobFactoryFunc(updatedSchedule),
+		gocron.NewTask(c.handlerFunc),
+	)
+	if err != nil {
+		_ = logging.Errorf("error updating job %q configuration to %q: %v", c.job.ID().String(), updatedSchedule, err)
+		return
+	}
+	c.job = updatedJob
+	c.currentSchedule = updatedSchedule
+	logging.Verbosef(
+		"successfully updated CRON configuration id %q - new cron expression: %s",
+		updatedJob.ID().String(),
+		updatedSchedule,
+	)
+}
+
+// SetReconcilerConfig applies the reconciler settings of a WhereaboutsConfig: the job is rescheduled at once, while the
+// other settings apply from the next run on
+func (c *ConfigWatcher) SetReconcilerConfig(reconcilerConfig whereaboutsv1alpha1.ReconcilerConfig) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reconcilerConfig = reconcilerConfig
+	c.reschedule()
+}
+
+// RunSettings returns the settings of the next reconciler run
+func (c *ConfigWatcher) RunSettings() RunSettings {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return RunSettings{
+		DryRun:            c.reconcilerConfig.DryRun,
+		Concurrency:       c.reconcilerConfig.Concurrency,
+		MaxChurnPercent:   c.reconcilerConfig.MaxChurnPercent,
+		AllowMassDeletion: c.reconcilerConfig.AllowMassDeletion,
+	}
+}
+
+// WatchWhereaboutsConfig applies the reconciler settings of the WhereaboutsConfig of the given name as it changes,
+// until the context is done. Once it is deleted, the file schedule applies again.
+func (c *ConfigWatcher) WatchWhereaboutsConfig(ctx context.Context, wbClient wbclientset.Interface, name string) {
+	wbInformerFactory := wbinformers.NewSharedInformerFactoryWithOptions(wbClient, 0,
+		wbinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	informer := wbInformerFactory.Whereabouts().V1alpha1().WhereaboutsConfigs().Informer()
+
+	apply := func(obj interface{}) {
+		whereaboutsConfig, isConfig := obj.(*whereaboutsv1alpha1.WhereaboutsConfig)
+		if !isConfig || whereaboutsConfig.GetName() != name {
+			return
+		}
+		logging.Verbosef("applying the reconciler settings of WhereaboutsConfig %s: %+v", name, whereaboutsConfig.Spec.Reconciler)
+		c.SetReconcilerConfig(whereaboutsConfig.Spec.Reconciler)
+	}
+	_, _ = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    apply,
+		UpdateFunc: func(_, newObj interface{}) { apply(newObj) },
+		DeleteFunc: func(obj interface{}) {
+			if key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj); err != nil || key != name {
+				return
+			}
+			logging.Verbosef("WhereaboutsConfig %s deleted; falling back to the configured schedule", name)
+			c.SetReconcilerConfig(whereaboutsv1alpha1.ReconcilerConfig{})
+		},
+	})
+	wbInformerFactory.Start(ctx.Done())
+}
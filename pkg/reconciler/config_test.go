@@ -1,6 +1,7 @@
 package reconciler
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"time"
@@ -10,6 +11,10 @@ import (
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/go-co-op/gocron/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbfake "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
 )
 
 var _ = Describe("Reconciler configuration watcher", func() {
@@ -58,6 +63,72 @@ var _ = Describe("Reconciler configuration watcher", func() {
 		dummyConfig.Close()
 	})
 
+	When("a WhereaboutsConfig is watched", func() {
+		const (
+			configName          = "default"
+			crdCronWithSeconds  = "0/1 * * * * *"
+			initialFileSchedule = "0/1 2 3 * * *"
+		)
+
+		var (
+			cancel   context.CancelFunc
+			wbClient *wbfake.Clientset
+		)
+
+		BeforeEach(func() {
+			wbClient = wbfake.NewSimpleClientset(
+				&whereaboutsv1alpha1.WhereaboutsConfig{
+					ObjectMeta: metav1.ObjectMeta{Name: configName},
+					Spec: whereaboutsv1alpha1.WhereaboutsConfigSpec{
+						Reconciler: whereaboutsv1alpha1.ReconcilerConfig{Schedule: crdCronWithSeconds, Concurrency: 4, DryRun: true, AllowMassDeletion: true},
+					},
+				},
+				&whereaboutsv1alpha1.WhereaboutsConfig{
+					ObjectMeta: metav1.ObjectMeta{Name: "other"},
+					Spec: whereaboutsv1alpha1.WhereaboutsConfigSpec{
+						Reconciler: whereaboutsv1alpha1.ReconcilerConfig{Schedule: "0 0 1 1 * *"},
+					},
+				},
+			)
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			config.WatchWhereaboutsConfig(ctx, wbClient, configName)
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		It("the schedule and run settings follow it, and the handler function is executed", func() {
+			Eventually(func() string { return currentSchedule(config) }).Should(Equal(crdCronWithSeconds))
+			Expect(config.RunSettings()).To(Equal(RunSettings{DryRun: true, Concurrency: 4, AllowMassDeletion: true}))
+			Eventually(mailbox).WithTimeout(time.Minute).Should(Receive())
+		})
+
+		It("the interval schedules the runs when it sets no schedule", func() {
+			Eventually(func() string { return currentSchedule(config) }).Should(Equal(crdCronWithSeconds))
+
+			Expect(wbClient.WhereaboutsV1alpha1().WhereaboutsConfigs().Update(context.TODO(), &whereaboutsv1alpha1.WhereaboutsConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: configName},
+				Spec: whereaboutsv1alpha1.WhereaboutsConfigSpec{
+					Reconciler: whereaboutsv1alpha1.ReconcilerConfig{Interval: &metav1.Duration{Duration: time.Hour}},
+				},
+			}, metav1.UpdateOptions{})).NotTo(BeNil())
+
+			Eventually(func() string { return currentSchedule(config) }).Should(Equal("@every 1h0m0s"))
+			Expect(config.RunSettings()).To(Equal(RunSettings{}))
+		})
+
+		It("the file schedule applies again once it is deleted", func() {
+			Eventually(func() string { return currentSchedule(config) }).Should(Equal(crdCronWithSeconds))
+
+			Expect(wbClient.WhereaboutsV1alpha1().WhereaboutsConfigs().Delete(context.TODO(), configName, metav1.DeleteOptions{})).To(Succeed())
+
+			Eventually(func() string { return currentSchedule(config) }).Should(Equal(initialFileSchedule))
+			Expect(config.RunSettings()).To(Equal(RunSettings{}))
+		})
+	})
+
 	When("the cron job expression is updated in the file-system", func() {
 		const updatedCronWithSeconds = "0/1 * * * * *"
 
@@ -72,6 +143,12 @@ var _ = Describe("Reconciler configuration watcher", func() {
 	})
 })
 
+func currentSchedule(config *ConfigWatcher) string {
+	config.lock.Lock()
+	defer config.lock.Unlock()
+	return config.currentSchedule
+}
+
 func newConfigWatcherForTests(configPath string, scheduler gocron.Scheduler, configWatcher *fsnotify.Watcher, handlerFunc func()) (*ConfigWatcher, error) {
 	return newConfigWatcher(
 		configPath,
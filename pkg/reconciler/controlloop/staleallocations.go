@@ -0,0 +1,285 @@
+package controlloop
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	v1coreinformerfactory "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	v1corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/controlloop"
+	wbclientset "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+	wblister "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/listers/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+const (
+	staleAllocationsLeaseName = "whereabouts-stale-allocations"
+	noResyncPeriod            = 0
+)
+
+// RunStaleAllocationCleanup competes with the other control loop instances for a cluster-wide lease. While holding
+// it, it watches the IP pools and the pods of the whole cluster, and releases the IP addresses allocated to pods which
+// no longer exist as soon as either changes: the node-local pod controllers miss the deletions happening while they
+// are down, which would otherwise wait for the next run of the IP reconciler. It blocks until the context is
+// cancelled.
+func RunStaleAllocationCleanup(ctx context.Context, identity string, k8sClient kubernetes.Interface, wbClient wbclientset.Interface, nadClient nadclient.Interface, workers int) {
+	controlloop.RunWhileLeading(ctx, staleAllocationsLeaseName, identity, k8sClient, "release the IP addresses of pods which no longer exist", func(leaderCtx context.Context) {
+		podInformerFactory := v1coreinformerfactory.NewSharedInformerFactory(k8sClient, noResyncPeriod)
+		wbInformerFactory := wbinformers.NewSharedInformerFactory(wbClient, noResyncPeriod)
+
+		controller := newStaleAllocationController(
+			wbclient.NewKubernetesClientWithNetAttachDefs(wbClient, k8sClient, nadClient),
+			podInformerFactory,
+			wbInformerFactory)
+
+		podInformerFactory.Start(leaderCtx.Done())
+		wbInformerFactory.Start(leaderCtx.Done())
+
+		controller.run(leaderCtx, workers)
+	})
+}
+
+// staleAllocationController validates the pod references of the allocations of an IP pool against the pod informer
+// whenever the pool changes, or a pod it serves is deleted. As the informer may lag behind, the pods missing from it
+// are fetched before their allocations are released.
+type staleAllocationController struct {
+	client       *wbclient.Client
+	podLister    v1corelisters.PodLister
+	ipPoolLister wblister.IPPoolLister
+	synced       []cache.InformerSynced
+	workqueue    workqueue.TypedRateLimitingInterface[string]
+}
+
+func newStaleAllocationController(client *wbclient.Client, podInformerFactory v1coreinformerfactory.SharedInformerFactory, wbInformerFactory wbinformers.SharedInformerFactory) *staleAllocationController {
+	podInformer := podInformerFactory.Core().V1().Pods()
+	ipPoolInformer := wbInformerFactory.Whereabouts().V1alpha1().IPPools()
+
+	c := &staleAllocationController{
+		client:       client,
+		podLister:    podInformer.Lister(),
+		ipPoolLister: ipPoolInformer.Lister(),
+		synced: []cache.InformerSynced{
+			podInformer.Informer().HasSynced,
+			ipPoolInformer.Informer().HasSynced,
+		},
+		workqueue: workqueue.NewTypedRateLimitingQueue[string](
+			workqueue.DefaultTypedControllerRateLimiter[string]()),
+	}
+
+	_, _ = ipPoolInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueuePool,
+		UpdateFunc: func(_, newObj interface{}) { c.enqueuePool(newObj) },
+	})
+	_, _ = podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: c.onPodDelete,
+	})
+	return c
+}
+
+func (c *staleAllocationController) run(ctx context.Context, workers int) {
+	defer c.workqueue.ShutDown()
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.synced...); !ok {
+		logging.Verbosef("failed waiting for caches to sync")
+		return
+	}
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, func(ctx context.Context) {
+			for c.processNextWorkItem(ctx) {
+			}
+		}, 0)
+	}
+	<-ctx.Done()
+}
+
+func (c *staleAllocationController) processNextWorkItem(ctx context.Context) bool {
+	key, shouldQuit := c.workqueue.Get()
+	if shouldQuit {
+		return false
+	}
+	defer c.workqueue.Done(key)
+
+	if err := c.sync(ctx, key); err != nil {
+		_ = logging.Errorf("failed to release the stale allocations of IP pool %s: %v", key, err)
+		c.workqueue.AddRateLimited(key)
+		return true
+	}
+	c.workqueue.Forget(key)
+	return true
+}
+
+// sync releases the allocations of the IP pool whose pods no longer exist
+func (c *staleAllocationController) sync(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	pool, err := c.ipPoolLister.IPPools(namespace).Get(name)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	candidates := c.staleCandidates(pool)
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	netAttachDefs, err := c.client.ListNetAttachDefs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list the network-attachment-definitions: %w", err)
+	}
+	if wbclient.NewUnreconciledNetworks(netAttachDefs).ContainsPool(name, pool.Spec.Range) {
+		logging.Debugf("pool %s belongs to a network opted out of reconciliation; skipping", name)
+		return nil
+	}
+
+	stale := map[string]string{}
+	for containerID, allocation := range candidates {
+		gone, err := c.podGone(ctx, allocation)
+		if err != nil {
+			return err
+		}
+		if gone {
+			stale[containerID] = allocation.PodRef
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	return c.release(ctx, namespace, name, stale)
+}
+
+// staleCandidates returns, by container id, the allocations of the pool whose pod the informer does not know of, or
+// knows of another incarnation of
+func (c *staleAllocationController) staleCandidates(pool *v1alpha1.IPPool) map[string]v1alpha1.IPAllocation {
+	candidates := map[string]v1alpha1.IPAllocation{}
+	for _, allocation := range pool.Spec.Allocations {
+		if allocation.PodRef == "" || types.IsFloatingIPClaim(allocation.ContainerID) {
+			continue
+		}
+		namespace, name, found := strings.Cut(allocation.PodRef, "/")
+		if !found {
+			continue
+		}
+		pod, err := c.podLister.Pods(namespace).Get(name)
+		if err == nil && !otherIncarnation(pod, allocation) {
+			continue
+		}
+		candidates[allocation.ContainerID] = allocation
+	}
+	return candidates
+}
+
+// podGone fetches the pod of the allocation, telling whether it - or at least the incarnation the allocation was made
+// for - no longer exists
+func (c *staleAllocationController) podGone(ctx context.Context, allocation v1alpha1.IPAllocation) (bool, error) {
+	namespace, name, _ := strings.Cut(allocation.PodRef, "/")
+	pod, err := c.client.GetPod(ctx, namespace, name)
+	if k8serrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get pod %s: %w", allocation.PodRef, err)
+	}
+	return otherIncarnation(pod, allocation), nil
+}
+
+// release drops the allocations of the pods which no longer exist, keyed by container id, from the current version of
+// the IP pool, along with their overlapping range reservations
+func (c *staleAllocationController) release(ctx context.Context, namespace, name string, stale map[string]string) error {
+	pool, err := c.client.GetIPPool(ctx, namespace, name)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var remaining []types.IPReservation
+	var released []types.IPReservation
+	var releasedIPs []string
+	for _, allocation := range pool.Allocations() {
+		if podRef, found := stale[allocation.ContainerID]; !found || podRef != allocation.PodRef {
+			remaining = append(remaining, allocation)
+			continue
+		}
+		logging.Debugf("releasing IP %s of pod %s: the pod no longer exists", allocation.IP, allocation.PodRef)
+		released = append(released, allocation)
+		releasedIPs = append(releasedIPs, allocation.IP.String())
+	}
+	if len(releasedIPs) == 0 {
+		return nil
+	}
+
+	requestCtx, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	err = pool.Update(requestCtx, remaining)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to update the reservation list: %w", err)
+	}
+	for range releasedIPs {
+		metrics.GarbageCollectedIPs.Inc()
+	}
+	logging.Verbosef("released the addresses of pods which no longer exist from IP pool %s: %v", name, releasedIPs)
+	return c.client.ReleaseOverlappingIPs(ctx, []wbclient.ReleasedAllocations{{Pool: pool, Allocations: released}})
+}
+
+func (c *staleAllocationController) enqueuePool(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		_ = logging.Errorf("%v", err)
+		return
+	}
+	c.workqueue.Add(key)
+}
+
+// onPodDelete has the pools holding allocations of the deleted pod validate them anew
+func (c *staleAllocationController) onPodDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		_ = logging.Errorf("received unexpected object: %v", obj)
+		return
+	}
+	podRef := fmt.Sprintf("%s/%s", pod.GetNamespace(), pod.GetName())
+
+	pools, err := c.ipPoolLister.List(labels.Everything())
+	if err != nil {
+		_ = logging.Errorf("failed to list the IP pools: %v", err)
+		return
+	}
+	for _, pool := range pools {
+		for _, allocation := range pool.Spec.Allocations {
+			if allocation.PodRef == podRef {
+				c.enqueuePool(pool)
+				break
+			}
+		}
+	}
+}
+
+// otherIncarnation tells whether the pod was re-created with the same name since the allocation was made
+func otherIncarnation(pod *v1.Pod, allocation v1alpha1.IPAllocation) bool {
+	return allocation.PodUID != "" && string(pod.GetUID()) != allocation.PodUID
+}
@@ -0,0 +1,127 @@
+package controlloop
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	v1coreinformerfactory "k8s.io/client-go/informers"
+	k8sclient "k8s.io/client-go/kubernetes"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+
+	fakenadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbclientset "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+func TestStaleAllocationController(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Release the IP addresses of pods which no longer exist")
+}
+
+var _ = Describe("Stale allocation controller", func() {
+	const (
+		namespace     = "default"
+		poolName      = "10.10.10.0-24"
+		poolNamespace = "kube-system"
+		workers       = 2
+	)
+
+	var (
+		k8sClient k8sclient.Interface
+		wbClient  wbclientset.Interface
+		cancel    context.CancelFunc
+	)
+
+	allocatedPods := func() ([]string, error) {
+		pool, err := wbClient.WhereaboutsV1alpha1().IPPools(poolNamespace).Get(context.TODO(), poolName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		var podRefs []string
+		for _, allocation := range pool.Spec.Allocations {
+			podRefs = append(podRefs, allocation.PodRef)
+		}
+		return podRefs, nil
+	}
+
+	startController := func(pods ...runtime.Object) {
+		k8sClient = fakek8sclient.NewSimpleClientset(pods...)
+
+		podInformerFactory := v1coreinformerfactory.NewSharedInformerFactory(k8sClient, noResyncPeriod)
+		wbInformerFactory := wbinformers.NewSharedInformerFactory(wbClient, noResyncPeriod)
+		controller := newStaleAllocationController(
+			wbclient.NewKubernetesClientWithNetAttachDefs(wbClient, k8sClient, fakenadclient.NewSimpleClientset()),
+			podInformerFactory,
+			wbInformerFactory)
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.TODO())
+		podInformerFactory.Start(ctx.Done())
+		wbInformerFactory.Start(ctx.Done())
+		go controller.run(ctx, workers)
+	}
+
+	BeforeEach(func() {
+		wbClient = fakewbclient.NewSimpleClientset(
+			&v1alpha1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: poolName, Namespace: poolNamespace, ResourceVersion: "1"},
+				Spec: v1alpha1.IPPoolSpec{
+					Range: "10.10.10.0/24",
+					Allocations: map[string]v1alpha1.IPAllocation{
+						"1": {ContainerID: "alive-container", PodRef: "default/alive", PodUID: "alive-uid"},
+						"2": {ContainerID: "gone-container", PodRef: "default/gone", PodUID: "gone-uid"},
+						"3": {ContainerID: "recreated-container", PodRef: "default/recreated", PodUID: "recreated-uid-1"},
+					},
+				},
+			},
+			&v1alpha1.OverlappingRangeIPReservation{
+				ObjectMeta: metav1.ObjectMeta{Name: "10.10.10.2", Namespace: poolNamespace},
+				Spec:       v1alpha1.OverlappingRangeIPReservationSpec{ContainerID: "gone-container", PodRef: "default/gone"},
+			},
+		)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("releases the IP addresses of the pods which no longer exist, and of the previous incarnations of pods", func() {
+		startController(pod(namespace, "alive", "alive-uid"), pod(namespace, "recreated", "recreated-uid-2"))
+
+		Eventually(allocatedPods, 5*time.Second).Should(ConsistOf("default/alive"))
+		Eventually(func() ([]v1alpha1.OverlappingRangeIPReservation, error) {
+			reservations, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(poolNamespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			return reservations.Items, nil
+		}).Should(BeEmpty())
+	})
+
+	It("releases the IP addresses of a pod once it is deleted", func() {
+		startController(pod(namespace, "alive", "alive-uid"), pod(namespace, "gone", "gone-uid"), pod(namespace, "recreated", "recreated-uid-1"))
+		Consistently(allocatedPods).Should(HaveLen(3))
+
+		Expect(k8sClient.CoreV1().Pods(namespace).Delete(context.TODO(), "gone", metav1.DeleteOptions{})).To(Succeed())
+
+		Eventually(allocatedPods, 5*time.Second).Should(ConsistOf("default/alive", "default/recreated"))
+	})
+})
+
+func pod(namespace, name, uid string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: k8stypes.UID(uid)},
+	}
+}
@@ -0,0 +1,152 @@
+package reconciler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+)
+
+// CursorConfigMapName is the name of the config map persisting the reconciler cursor across incremental runs
+const CursorConfigMapName = "whereabouts-reconciler-cursor"
+
+// trackedPool is implemented by the pools whose changes can be tracked across reconciler runs
+type trackedPool interface {
+	Name() string
+	Namespace() string
+	ResourceVersion() string
+}
+
+// poolCursor records the state of a pool, and of the pods it serves, the last time it held no orphaned allocation
+type poolCursor struct {
+	ResourceVersion string `json:"resourceVersion"`
+	PodsDigest      string `json:"podsDigest"`
+	LastReconciled  string `json:"lastReconciled"`
+}
+
+// reconcilerCursor lets incremental runs skip the pools which did not change since they were last found clean
+type reconcilerCursor struct {
+	configMap *v1.ConfigMap
+	// persisted tells whether the config map already exists
+	persisted bool
+	pools     map[string]poolCursor
+	nextPools map[string]poolCursor
+}
+
+func loadCursor(ctx context.Context, k8sClient *kubernetes.Client, namespace string) (*reconcilerCursor, error) {
+	cursor := &reconcilerCursor{
+		configMap: &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: CursorConfigMapName, Namespace: namespace},
+		},
+		pools:     map[string]poolCursor{},
+		nextPools: map[string]poolCursor{},
+	}
+
+	configMap, err := k8sClient.GetConfigMap(ctx, namespace, CursorConfigMapName)
+	if k8serrors.IsNotFound(err) {
+		return cursor, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to retrieve the reconciler cursor: %w", err)
+	}
+
+	cursor.configMap = configMap
+	cursor.persisted = true
+	for key, value := range configMap.Data {
+		var entry poolCursor
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			logging.Debugf("ignoring invalid reconciler cursor entry %s: %v", key, err)
+			continue
+		}
+		cursor.pools[key] = entry
+	}
+	return cursor, nil
+}
+
+// unchanged reports whether the pool, and the pods it serves, are as they were when it was last found clean. The
+// entry of an unchanged pool is carried over to the next cursor.
+func (c *reconcilerCursor) unchanged(pool trackedPool, podsDigest string) bool {
+	key := cursorKey(pool)
+	entry, found := c.pools[key]
+	if !found || entry.ResourceVersion != pool.ResourceVersion() || entry.PodsDigest != podsDigest {
+		return false
+	}
+	c.nextPools[key] = entry
+	return true
+}
+
+// markClean records the pool as holding no orphaned allocation
+func (c *reconcilerCursor) markClean(pool trackedPool, podsDigest string, now time.Time) {
+	c.nextPools[cursorKey(pool)] = poolCursor{
+		ResourceVersion: pool.ResourceVersion(),
+		PodsDigest:      podsDigest,
+		LastReconciled:  now.UTC().Format(time.RFC3339),
+	}
+}
+
+func (c *reconcilerCursor) save(ctx context.Context, k8sClient kubernetes.Client) error {
+	data := map[string]string{}
+	for key, entry := range c.nextPools {
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		data[key] = string(value)
+	}
+	c.configMap.Data = data
+
+	var err error
+	if c.persisted {
+		err = k8sClient.UpdateConfigMap(ctx, c.configMap)
+	} else {
+		err = k8sClient.CreateConfigMap(ctx, c.configMap)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save the reconciler cursor: %w", err)
+	}
+	return nil
+}
+
+func cursorKey(pool trackedPool) string {
+	return fmt.Sprintf("%s.%s", pool.Namespace(), pool.Name())
+}
+
+// podsDigest fingerprints what the reconciler knows about the pods allocated addresses from the pool: whether they
+// are alive, their UID, phase and IPs.
+func podsDigest(pool storage.IPPool, livePods map[string]podWrapper) string {
+	podRefs := map[string]void{}
+	for _, allocation := range pool.Allocations() {
+		podRefs[allocation.PodRef] = void{}
+	}
+	sortedPodRefs := make([]string, 0, len(podRefs))
+	for podRef := range podRefs {
+		sortedPodRefs = append(sortedPodRefs, podRef)
+	}
+	sort.Strings(sortedPodRefs)
+
+	hash := sha256.New()
+	for _, podRef := range sortedPodRefs {
+		fmt.Fprintf(hash, "%s\n", podRef)
+		livePod, isAlive := livePods[podRef]
+		if !isAlive {
+			continue
+		}
+		ips := make([]string, 0, len(livePod.ips))
+		for ip := range livePod.ips {
+			ips = append(ips, ip)
+		}
+		sort.Strings(ips)
+		fmt.Fprintf(hash, "alive %s %s %v\n", livePod.uid, livePod.phase, ips)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
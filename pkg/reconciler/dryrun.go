@@ -0,0 +1,85 @@
+package reconciler
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	wbscheme "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/scheme"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+const (
+	// WouldDeleteAllocationReason is the reason of the events emitted, in dry-run mode, for the IP pool allocations a
+	// run would delete
+	WouldDeleteAllocationReason = "WouldDeleteAllocation"
+	// WouldDeleteReservationReason is the reason of the events emitted, in dry-run mode, for the cluster wide
+	// reservations a run would delete
+	WouldDeleteReservationReason = "WouldDeleteReservation"
+)
+
+// namespacedPool is implemented by the pools backed by an IPPool object, which the events can refer to
+type namespacedPool interface {
+	Name() string
+	Namespace() string
+}
+
+// SetDryRun sets whether the run only reports what it would delete - as events, logs and in its report - without
+// updating the IP pools nor deleting the cluster wide reservations
+func (rl *ReconcileLooper) SetDryRun(dryRun bool) {
+	rl.dryRun = dryRun
+}
+
+// RecordEvents records the events of the run to the cluster it reconciles, as the given component. It returns the
+// function to call once the run completes, stopping the recording.
+func (rl *ReconcileLooper) RecordEvents(component string) func() {
+	// the events refer to whereabouts objects
+	utilruntime.Must(wbscheme.AddToScheme(scheme.Scheme))
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: rl.k8sClient.Events()})
+	rl.recorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component})
+	return broadcaster.Shutdown
+}
+
+// reportWouldDeleteAllocations reports the IP pool allocations a dry run would delete
+func (rl ReconcileLooper) reportWouldDeleteAllocations(pool storage.IPPool, allocations []types.IPReservation) {
+	var poolRef runtime.Object
+	poolName := "unknown"
+	if namespacedPool, isNamespaced := pool.(namespacedPool); isNamespaced {
+		poolName = namespacedPool.Name()
+		poolRef = &v1.ObjectReference{
+			APIVersion: whereaboutsv1alpha1.SchemeGroupVersion.String(),
+			Kind:       "IPPool",
+			Namespace:  namespacedPool.Namespace(),
+			Name:       namespacedPool.Name(),
+		}
+	}
+	for _, allocation := range allocations {
+		cause := DeletionCausePodGone
+		if _, isAlive := rl.liveWhereaboutsPods[allocation.PodRef]; isAlive {
+			cause = DeletionCauseContainerIDMismatch
+		}
+		logging.Verbosef("dry run: would remove the allocation of IP %s to pod %s from IP pool %s (cause: %s)",
+			allocation.IP, allocation.PodRef, poolName, cause)
+		if rl.recorder != nil && poolRef != nil {
+			rl.recorder.Eventf(poolRef, v1.EventTypeNormal, WouldDeleteAllocationReason,
+				"would delete the allocation of IP %s to pod %s (cause: %s)", allocation.IP, allocation.PodRef, cause)
+		}
+	}
+}
+
+// reportWouldDeleteReservation reports a cluster wide reservation a dry run would delete
+func (rl ReconcileLooper) reportWouldDeleteReservation(reservation *whereaboutsv1alpha1.OverlappingRangeIPReservation, cause string) {
+	logging.Verbosef("dry run: would remove cluster wide IP reservation [%s] of pod %s (cause: %s)",
+		reservation.GetName(), reservation.Spec.PodRef, cause)
+	if rl.recorder != nil {
+		rl.recorder.Eventf(reservation, v1.EventTypeNormal, WouldDeleteReservationReason,
+			"would delete the reservation of pod %s (cause: %s)", reservation.Spec.PodRef, cause)
+	}
+}
@@ -1,24 +1,111 @@
 package reconciler
 
 import (
+	"context"
+	"time"
+
+	"k8s.io/client-go/tools/record"
+
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
 )
 
-func ReconcileIPs(errorChan chan error) {
+// DefaultReconcilerTimeout bounds the duration of a whole reconciler run
+const DefaultReconcilerTimeout = 5 * time.Minute
+
+// ReconcileReport summarizes the outcome of a single reconciler run
+type ReconcileReport struct {
+	// DryRun tells the run only reported what it would clean up, which the cleaned up IPs and reservations list
+	DryRun                  bool     `json:"dryRun,omitempty"`
+	CleanedUpIPs            []string `json:"cleanedUpIPs"`
+	CleanedUpOverlappingIPs []string `json:"cleanedUpOverlappingIPs"`
+	// CompactedPools are the IP pools whose allocations were compacted, as namespace/name
+	CompactedPools []string `json:"compactedPools,omitempty"`
+	// RepairedOverlappingIPs are the cluster wide reservations created or rewritten after the IP pool allocations, as
+	// namespace/name
+	RepairedOverlappingIPs []string `json:"repairedOverlappingIPs,omitempty"`
+	// TimedOutPools are the IP pools given up on for exceeding their own deadline, as namespace/name
+	TimedOutPools []string `json:"timedOutPools,omitempty"`
+	// ChurnProtectedPools are the IP pools left untouched for holding more orphaned allocations than the churn limit
+	// allows, along with their orphaned and total allocations
+	ChurnProtectedPools []string `json:"churnProtectedPools,omitempty"`
+	Errors              []string `json:"errors,omitempty"`
+}
+
+// RunSettings are the settings of the scheduled reconciler runs, which a WhereaboutsConfig may change between runs
+type RunSettings struct {
+	// DryRun has the run only report what it would clean up
+	DryRun bool
+	// Concurrency is the number of IP pools the run updates at once
+	Concurrency int
+	// MaxChurnPercent is the share of a pool's allocations the run may delete, DefaultMaxChurnPercent when 0
+	MaxChurnPercent int
+	// AllowMassDeletion has the run delete the orphaned allocations of the pools even above MaxChurnPercent
+	AllowMassDeletion bool
+}
+
+// ReconcileIPs runs a single reconciliation pass using the in-cluster configuration, through a dedicated client
+// throttled by the rate limit, and sends its outcome over the error channel. The deletions of the orphaned cluster
+// wide reservations are recorded as events when a recorder is provided. The pod list guard is shared by the runs of
+// the caller.
+func ReconcileIPs(errorChan chan error, rateLimit kubernetes.RateLimit, recorder record.EventRecorder, podListGuard *PodListGuard,
+	settings RunSettings) {
 	logging.Verbosef("starting reconciler run")
 
-	ipReconcileLoop, err := NewReconcileLooper()
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultReconcilerTimeout)
+	defer cancel()
+
+	ipReconcileLoop, err := NewReconcileLooperWithKubeconfig(ctx, "", rateLimit)
 	if err != nil {
 		_ = logging.Errorf("failed to create the reconcile looper: %v", err)
 		errorChan <- err
 		return
 	}
+	ipReconcileLoop.SetEventRecorder(recorder)
+	ipReconcileLoop.SetPodListGuard(podListGuard)
+	ipReconcileLoop.SetDryRun(settings.DryRun)
+	ipReconcileLoop.SetConcurrency(settings.Concurrency)
+	if settings.AllowMassDeletion {
+		ipReconcileLoop.SetMaxChurnPercent(100)
+	} else if settings.MaxChurnPercent > 0 {
+		ipReconcileLoop.SetMaxChurnPercent(settings.MaxChurnPercent)
+	}
 
-	cleanedUpIps, err := ipReconcileLoop.ReconcileIPPools()
+	_, err = InvokeIPReconciler(ctx, ipReconcileLoop)
+	errorChan <- err
+}
+
+// InvokeIPReconciler runs a single reconciliation pass - first over the IPPools, then over the cluster wide
+// (overlapping ranges) reservations, be their pod gone or their IP pool allocation missing - and reports what was
+// cleaned up, or would be in dry-run mode. The returned error is the first cleanup failure; the report is always
+// returned, and holds every error encountered.
+func InvokeIPReconciler(ctx context.Context, ipReconcileLoop *ReconcileLooper) (*ReconcileReport, error) {
+	report := &ReconcileReport{
+		DryRun:                  ipReconcileLoop.dryRun,
+		CleanedUpIPs:            []string{},
+		CleanedUpOverlappingIPs: []string{},
+	}
+
+	podListGuard := ipReconcileLoop.podListGuard
+	if podListGuard == nil {
+		podListGuard = &PodListGuard{}
+	}
+	if err := podListGuard.check(ipReconcileLoop.podCount, ipReconcileLoop.minLivePods); err != nil {
+		_ = logging.Errorf("refusing to reconcile against an implausible pod list: %v", err)
+		report.Errors = append(report.Errors, err.Error())
+		return report, err
+	}
+
+	cleanedUpIps, err := ipReconcileLoop.ReconcileIPPools(ctx)
+	for _, ip := range cleanedUpIps {
+		report.CleanedUpIPs = append(report.CleanedUpIPs, ip.String())
+	}
+	report.TimedOutPools = ipReconcileLoop.TimedOutPools()
+	report.ChurnProtectedPools = ipReconcileLoop.ChurnProtectedPools()
 	if err != nil {
 		_ = logging.Errorf("failed to clean up IP for allocations: %v", err)
-		errorChan <- err
-		return
+		report.Errors = append(report.Errors, err.Error())
+		return report, err
 	}
 
 	if len(cleanedUpIps) > 0 {
@@ -27,10 +114,59 @@ func ReconcileIPs(errorChan chan error) {
 		logging.Debugf("no IP addresses to cleanup")
 	}
 
-	if err := ipReconcileLoop.ReconcileOverlappingIPAddresses(); err != nil {
-		errorChan <- err
-		return
+	cleanedUpOverlappingIPs, err := ipReconcileLoop.reconcileOverlappingIPAddresses(ctx)
+	report.CleanedUpOverlappingIPs = append(report.CleanedUpOverlappingIPs, cleanedUpOverlappingIPs...)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report, err
+	}
+
+	cleanedUpOrphanedReservations, err := ipReconcileLoop.ReconcileOrphanedReservations(ctx)
+	report.CleanedUpOverlappingIPs = append(report.CleanedUpOverlappingIPs, cleanedUpOrphanedReservations...)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report, err
+	}
+
+	if ipReconcileLoop.compact {
+		compactions, err := ipReconcileLoop.k8sClient.CompactIPPools(ctx, "", ipReconcileLoop.dryRun)
+		for _, compaction := range compactions {
+			report.CompactedPools = append(report.CompactedPools, compaction.Namespace+"/"+compaction.Pool)
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			return report, err
+		}
+	}
+
+	if ipReconcileLoop.verifyReservations {
+		drifts, err := ipReconcileLoop.k8sClient.VerifyOverlappingReservations(ctx, ipReconcileLoop.dryRun)
+		for _, drift := range drifts {
+			if drift.Repaired || (ipReconcileLoop.dryRun && drift.Drift != kubernetes.ReservationConflicting) {
+				report.RepairedOverlappingIPs = append(report.RepairedOverlappingIPs, drift.Namespace+"/"+drift.Reservation)
+			}
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			return report, err
+		}
+	}
+
+	// a dry run leaves the pools it found dirty as they are: it must not skip them next time
+	if ipReconcileLoop.cursor != nil && !ipReconcileLoop.dryRun {
+		if err := ipReconcileLoop.cursor.save(ctx, ipReconcileLoop.k8sClient); err != nil {
+			_ = logging.Errorf("%v", err)
+			report.Errors = append(report.Errors, err.Error())
+			return report, err
+		}
+	}
+
+	// the pools protected from churn were skipped, the rest of the run carrying on: the run still fails over them
+	if err := ipReconcileLoop.ChurnProtectionError(); err != nil {
+		_ = logging.Errorf("%v", err)
+		report.Errors = append(report.Errors, err.Error())
+		return report, err
 	}
 
-	errorChan <- nil
+	return report, nil
 }
@@ -7,12 +7,15 @@ import (
 	"net"
 	"strings"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	multusv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	fakenadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
 
 	v1 "k8s.io/api/core/v1"
@@ -20,6 +23,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	k8sclient "k8s.io/client-go/kubernetes"
 	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 
 	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
 	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
@@ -74,21 +78,41 @@ var _ = Describe("Whereabouts IP reconciler", func() {
 				Context("reconciling the IPPool", func() {
 					BeforeEach(func() {
 						var err error
-						reconcileLooper, err = NewReconcileLooperWithClient(kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
+						reconcileLooper, err = NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
 						Expect(err).NotTo(HaveOccurred())
 					})
 
 					It("should report the deleted IP reservation", func() {
-						Expect(reconcileLooper.ReconcileIPPools()).To(Equal([]net.IP{net.ParseIP("10.10.10.1")}))
+						Expect(reconcileLooper.ReconcileIPPools(context.TODO())).To(Equal([]net.IP{net.ParseIP("10.10.10.1")}))
 					})
 
 					It("the pool's orphaned IP should be deleted after the reconcile loop", func() {
-						_, err := reconcileLooper.ReconcileIPPools()
+						_, err := reconcileLooper.ReconcileIPPools(context.TODO())
 						Expect(err).NotTo(HaveOccurred())
 						poolAfterCleanup, err := wbClient.WhereaboutsV1alpha1().IPPools(namespace).Get(context.TODO(), pool.GetName(), metav1.GetOptions{})
 						Expect(err).NotTo(HaveOccurred())
 						Expect(poolAfterCleanup.Spec.Allocations).To(BeEmpty())
 					})
+
+					It("should report the deleted IP reservation when invoked as a one-shot run", func() {
+						report, err := InvokeIPReconciler(context.TODO(), reconcileLooper)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(report.CleanedUpIPs).To(Equal([]string{"10.10.10.1"}))
+						Expect(report.CleanedUpOverlappingIPs).To(BeEmpty())
+						Expect(report.Errors).To(BeEmpty())
+					})
+
+					It("should only report the IP reservation it would delete in dry-run mode", func() {
+						reconcileLooper.SetDryRun(true)
+						report, err := InvokeIPReconciler(context.TODO(), reconcileLooper)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(report.DryRun).To(BeTrue())
+						Expect(report.CleanedUpIPs).To(Equal([]string{"10.10.10.1"}))
+
+						poolAfterRun, err := wbClient.WhereaboutsV1alpha1().IPPools(namespace).Get(context.TODO(), pool.GetName(), metav1.GetOptions{})
+						Expect(err).NotTo(HaveOccurred())
+						Expect(poolAfterRun.Spec.Allocations).To(Equal(pool.Spec.Allocations))
+					})
 				})
 			})
 		})
@@ -138,18 +162,18 @@ var _ = Describe("Whereabouts IP reconciler", func() {
 			Context("reconciling the IPPool", func() {
 				BeforeEach(func() {
 					var err error
-					reconcileLooper, err = NewReconcileLooperWithClient(kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
+					reconcileLooper, err = NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
 					Expect(err).NotTo(HaveOccurred())
 				})
 
 				It("should report the dead pod's IP address as deleted", func() {
-					deletedIPAddrs, err := reconcileLooper.ReconcileIPPools()
+					deletedIPAddrs, err := reconcileLooper.ReconcileIPPools(context.TODO())
 					Expect(err).NotTo(HaveOccurred())
 					Expect(deletedIPAddrs).To(Equal([]net.IP{net.ParseIP("10.10.10.1")}))
 				})
 
 				It("the IPPool should have only the IP reservation of the live pod", func() {
-					deletedIPAddrs, err := reconcileLooper.ReconcileIPPools()
+					deletedIPAddrs, err := reconcileLooper.ReconcileIPPools(context.TODO())
 					Expect(err).NotTo(HaveOccurred())
 					Expect(deletedIPAddrs).NotTo(BeEmpty())
 
@@ -185,15 +209,16 @@ var _ = Describe("Whereabouts IP reconciler", func() {
 					PodRef: fmt.Sprintf("%s/%s", namespace, podName),
 				},
 			}
+			markOrphaned(pool, time.Now().Add(-time.Hour), "2")
 			wbClient = fakewbclient.NewSimpleClientset(pool)
 
 			By("initializing the reconciler")
 			var err error
-			reconcileLooper, err = NewReconcileLooperWithClient(kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
+			reconcileLooper, err = NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
 			Expect(err).NotTo(HaveOccurred())
 
 			By("reconciling and checking that the correct entry is deleted")
-			deletedIPAddrs, err := reconcileLooper.ReconcileIPPools()
+			deletedIPAddrs, err := reconcileLooper.ReconcileIPPools(context.TODO())
 			Expect(err).NotTo(HaveOccurred())
 			Expect(deletedIPAddrs).To(Equal([]net.IP{net.ParseIP("10.10.10.2")}))
 
@@ -209,6 +234,122 @@ var _ = Describe("Whereabouts IP reconciler", func() {
 		})
 	})
 
+	Context("reconciling the allocations recording their pod UID and allocation time", func() {
+		const poolName = "pool1"
+
+		var (
+			pool     *v1alpha1.IPPool
+			wbClient wbclient.Interface
+		)
+
+		reconcile := func() []net.IP {
+			looper, err := NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
+			Expect(err).NotTo(HaveOccurred())
+			deletedIPAddrs, err := looper.ReconcileIPPools(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+			return deletedIPAddrs
+		}
+
+		BeforeEach(func() {
+			pod := generatePod(namespace, podName, ipInNetwork{ip: firstIPInRange, networkName: networkName})
+			pod.UID = "uid-2"
+			k8sClientSet = fakek8sclient.NewSimpleClientset(pod)
+			pool = generateIPPoolSpec(ipRange, namespace, poolName, podName)
+		})
+
+		It("keeps the allocation of the live pod", func() {
+			pool.Spec.Allocations["1"] = v1alpha1.IPAllocation{PodRef: fmt.Sprintf("%s/%s", namespace, podName), PodUID: "uid-2"}
+			wbClient = fakewbclient.NewSimpleClientset(pool)
+
+			Expect(reconcile()).To(BeEmpty())
+		})
+
+		It("deletes the allocation of a predecessor of the same name", func() {
+			pool.Spec.Allocations["1"] = v1alpha1.IPAllocation{PodRef: fmt.Sprintf("%s/%s", namespace, podName), PodUID: "uid-1"}
+			wbClient = fakewbclient.NewSimpleClientset(pool)
+
+			Expect(reconcile()).To(Equal([]net.IP{net.ParseIP(firstIPInRange)}))
+		})
+
+		It("spares the allocations within the grace period", func() {
+			allocatedAt := metav1.NewTime(time.Now().Add(-time.Minute))
+			pool.Spec.Allocations["2"] = v1alpha1.IPAllocation{PodRef: fmt.Sprintf("%s/%s", namespace, "pod2"), AllocatedAt: &allocatedAt}
+			wbClient = fakewbclient.NewSimpleClientset(pool)
+
+			Expect(reconcile()).To(BeEmpty())
+		})
+
+		It("spares the allocations within the grace period of the pool's annotation", func() {
+			allocatedAt := metav1.NewTime(time.Now().Add(-time.Hour))
+			pool.Spec.Allocations["2"] = v1alpha1.IPAllocation{PodRef: fmt.Sprintf("%s/%s", namespace, "pod2"), AllocatedAt: &allocatedAt}
+			pool.SetAnnotations(map[string]string{kubernetes.GracePeriodAnnotation: "2h"})
+			wbClient = fakewbclient.NewSimpleClientset(pool)
+
+			Expect(reconcile()).To(BeEmpty())
+		})
+
+		It("deletes the allocations found orphaned past the grace period of the pool's annotation", func() {
+			allocatedAt := metav1.NewTime(time.Now().Add(-time.Minute))
+			pool.Spec.Allocations["2"] = v1alpha1.IPAllocation{PodRef: fmt.Sprintf("%s/%s", namespace, "pod2"), AllocatedAt: &allocatedAt}
+			markOrphaned(pool, time.Now().Add(-time.Minute), "2")
+			pool.SetAnnotations(map[string]string{kubernetes.GracePeriodAnnotation: "30s"})
+			wbClient = fakewbclient.NewSimpleClientset(pool)
+
+			Expect(reconcile()).To(Equal([]net.IP{net.ParseIP("10.10.10.2")}))
+		})
+
+		It("spares the allocations first found orphaned, recording when", func() {
+			allocatedAt := metav1.NewTime(time.Now().Add(-time.Hour))
+			pool.Spec.Allocations["2"] = v1alpha1.IPAllocation{PodRef: fmt.Sprintf("%s/%s", namespace, "pod2"), AllocatedAt: &allocatedAt}
+			pool.SetAnnotations(map[string]string{kubernetes.GracePeriodAnnotation: "30s"})
+			wbClient = fakewbclient.NewSimpleClientset(pool)
+
+			Expect(reconcile()).To(BeEmpty())
+
+			poolAfterReconcile, err := wbClient.WhereaboutsV1alpha1().IPPools(namespace).Get(context.TODO(), poolName, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(poolAfterReconcile.Status.Orphaned).To(HaveKey("2"))
+			Expect(poolAfterReconcile.Status.Orphaned["2"].Time).To(BeTemporally("~", time.Now(), time.Minute))
+		})
+
+		It("forgets the orphaning of the allocations taken over", func() {
+			pool.Spec.Allocations["1"] = v1alpha1.IPAllocation{PodRef: fmt.Sprintf("%s/%s", namespace, podName), PodUID: "uid-2"}
+			wbClient = fakewbclient.NewSimpleClientset(pool)
+
+			Expect(reconcile()).To(BeEmpty())
+
+			poolAfterReconcile, err := wbClient.WhereaboutsV1alpha1().IPPools(namespace).Get(context.TODO(), poolName, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(poolAfterReconcile.Status.Orphaned).To(BeEmpty())
+		})
+
+		It("spares the pre-reservations of the live pods", func() {
+			pool.Spec.Allocations["2"] = v1alpha1.IPAllocation{
+				ContainerID: types.PreReservationContainerID("uid-2"), PodRef: fmt.Sprintf("%s/%s", namespace, podName), PodUID: "uid-2", IfName: "net2"}
+			wbClient = fakewbclient.NewSimpleClientset(pool)
+
+			Expect(reconcile()).To(BeEmpty())
+		})
+
+		It("deletes the pre-reservations of the deleted pods", func() {
+			pool.Spec.Allocations["2"] = v1alpha1.IPAllocation{
+				ContainerID: types.PreReservationContainerID("uid-3"), PodRef: fmt.Sprintf("%s/%s", namespace, "pod2"), PodUID: "uid-3", IfName: "net1"}
+			markOrphaned(pool, time.Now().Add(-time.Hour), "2")
+			wbClient = fakewbclient.NewSimpleClientset(pool)
+
+			Expect(reconcile()).To(Equal([]net.IP{net.ParseIP("10.10.10.2")}))
+		})
+
+		It("falls back to the default grace period on an invalid annotation", func() {
+			allocatedAt := metav1.NewTime(time.Now().Add(-time.Minute))
+			pool.Spec.Allocations["2"] = v1alpha1.IPAllocation{PodRef: fmt.Sprintf("%s/%s", namespace, "pod2"), AllocatedAt: &allocatedAt}
+			pool.SetAnnotations(map[string]string{kubernetes.GracePeriodAnnotation: "ten minutes"})
+			wbClient = fakewbclient.NewSimpleClientset(pool)
+
+			Expect(reconcile()).To(BeEmpty())
+		})
+	})
+
 	Context("reconciling cluster wide IPs - overlapping IPs", func() {
 		const (
 			numberOfPods       = 3
@@ -239,6 +380,7 @@ var _ = Describe("Whereabouts IP reconciler", func() {
 		}
 
 		BeforeEach(func() {
+			pods, pools, clusterWideIPs = nil, nil, nil
 			ips := []string{firstIPInRange, secondIPInRange, thirdIPInRange}
 			networks := []string{firstNetworkName, secondNetworkName}
 			for i := 0; i < numberOfPods; i++ {
@@ -271,15 +413,214 @@ var _ = Describe("Whereabouts IP reconciler", func() {
 
 		It("will delete an orphaned IP address", func() {
 			Expect(k8sClientSet.CoreV1().Pods(namespace).Delete(context.TODO(), pods[podIndexToRemove].Name, metav1.DeleteOptions{})).NotTo(HaveOccurred())
-			newReconciler, err := NewReconcileLooperWithClient(kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
+			newReconciler, err := NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
 			Expect(err).NotTo(HaveOccurred())
-			Expect(newReconciler.ReconcileOverlappingIPAddresses()).To(Succeed())
+			Expect(newReconciler.ReconcileOverlappingIPAddresses(context.TODO())).To(Succeed())
 
 			expectedClusterWideIPs := 2
 			clusterWideIPAllocations, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).List(context.TODO(), metav1.ListOptions{})
 			Expect(err).NotTo(HaveOccurred())
 			Expect(clusterWideIPAllocations.Items).To(HaveLen(expectedClusterWideIPs))
 		})
+
+		It("keeps the cluster wide IP of a floating IP claim", func() {
+			claimedIP := generateClusterWideIPReservation(namespace, "10.10.10.100", namespace+"/vip")
+			claimedIP.Spec.ContainerID = types.FloatingIPClaimContainerID(namespace, "vip")
+			_, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).Create(context.TODO(), claimedIP, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			newReconciler, err := NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newReconciler.ReconcileOverlappingIPAddresses(context.TODO())).To(Succeed())
+
+			_, err = wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).Get(context.TODO(), "10.10.10.100", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("reconciling cluster wide IPs of a named network, e.g. a node slice one", func() {
+		const (
+			networkName = "net1"
+			ipRange     = "10.10.10.0/24"
+			livePodIP   = "10.10.10.1"
+			deadPodIP   = "10.10.10.2"
+		)
+
+		var (
+			podClientSet k8sclient.Interface
+			wbClient     wbclient.Interface
+		)
+
+		BeforeEach(func() {
+			livePod := generatePod(namespace, "live-pod", ipInNetwork{ip: livePodIP, networkName: networkName})
+			podClientSet = fakek8sclient.NewSimpleClientset(livePod)
+
+			pool := generateIPPoolSpec(ipRange, namespace, "net1-node1-10.10.10.0-24", livePod.GetName(), "dead-pod")
+			wbClient = fakewbclient.NewSimpleClientset(
+				pool,
+				generateClusterWideIPReservation(namespace, kubernetes.NormalizeIP(net.ParseIP(livePodIP), networkName), fmt.Sprintf("%s/live-pod", namespace)),
+				generateClusterWideIPReservation(namespace, kubernetes.NormalizeIP(net.ParseIP(deadPodIP), networkName), fmt.Sprintf("%s/dead-pod", namespace)))
+		})
+
+		It("only deletes the reservations of dead pods", func() {
+			newReconciler, err := NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, podClientSet))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newReconciler.ReconcileOverlappingIPAddresses(context.TODO())).To(Succeed())
+
+			clusterWideIPAllocations, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).List(context.TODO(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterWideIPAllocations.Items).To(HaveLen(1))
+			Expect(clusterWideIPAllocations.Items[0].GetName()).To(Equal("net1-10.10.10.1"))
+		})
+
+		It("leaves the pools and reservations of a network opted out of reconciliation alone", func() {
+			netAttachDef := &multusv1.NetworkAttachmentDefinition{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        networkName,
+					Namespace:   namespace,
+					Annotations: map[string]string{kubernetes.ReconcileAnnotation: "false"},
+				},
+				Spec: multusv1.NetworkAttachmentDefinitionSpec{
+					Config: `{"cniVersion": "0.3.1", "name": "net1", "type": "macvlan", "ipam": {"type": "whereabouts", "range": "10.10.0.0/16", "node_slice_size": "/24", "network_name": "net1"}}`,
+				},
+			}
+			nadClient := fakenadclient.NewSimpleClientset()
+			_, err := nadClient.K8sCniCncfIoV1().NetworkAttachmentDefinitions(namespace).Create(context.TODO(), netAttachDef, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			newReconciler, err := NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClientWithNetAttachDefs(wbClient, podClientSet, nadClient))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newReconciler.ReconcileIPPools(context.TODO())).To(BeEmpty())
+			Expect(newReconciler.ReconcileOverlappingIPAddresses(context.TODO())).To(Succeed())
+
+			pool, err := wbClient.WhereaboutsV1alpha1().IPPools(namespace).Get(context.TODO(), "net1-node1-10.10.10.0-24", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pool.Spec.Allocations).To(HaveLen(2))
+
+			clusterWideIPAllocations, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).List(context.TODO(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterWideIPAllocations.Items).To(HaveLen(2))
+		})
+	})
+
+	Context("reconciling the IP pools of node slices", func() {
+		const (
+			networkName = "net1"
+			sliceRange  = "10.10.10.0/26"
+			livePodIP   = "10.10.10.1"
+		)
+
+		var (
+			podClientSet k8sclient.Interface
+			wbClient     wbclient.Interface
+		)
+
+		BeforeEach(func() {
+			livePod := generatePod(namespace, "live-pod", ipInNetwork{ip: livePodIP, networkName: networkName})
+			podClientSet = fakek8sclient.NewSimpleClientset(livePod)
+
+			nodeSlicePool := &v1alpha1.NodeSlicePool{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: networkName},
+				Spec:       v1alpha1.NodeSlicePoolSpec{Range: "10.10.10.0/24", SliceSize: "/26"},
+				Status: v1alpha1.NodeSlicePoolStatus{
+					Allocations: []v1alpha1.NodeSliceAllocation{{NodeName: "node1", SliceRange: sliceRange}},
+				},
+			}
+			wbClient = fakewbclient.NewSimpleClientset(
+				nodeSlicePool,
+				generateIPPoolSpec(sliceRange, namespace, "net1-node1-10.10.10.0-26", livePod.GetName(), "dead-pod"))
+		})
+
+		It("deletes the allocations of dead pods, counted against the network of their node slice pool", func() {
+			// no network-attachment-definition is listed: the node slice pool tells the network of its slices' pools
+			newReconciler, err := NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, podClientSet))
+			Expect(err).NotTo(HaveOccurred())
+			deletions := metrics.ReconcilerDeletions.Value(DeletionCausePodGone, networkName)
+
+			Expect(newReconciler.ReconcileIPPools(context.TODO())).To(Equal([]net.IP{net.ParseIP("10.10.10.2")}))
+			Expect(metrics.ReconcilerDeletions.Value(DeletionCausePodGone, networkName) - deletions).To(BeEquivalentTo(1))
+
+			slicePool, err := wbClient.WhereaboutsV1alpha1().IPPools(namespace).Get(context.TODO(), "net1-node1-10.10.10.0-26", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(slicePool.Spec.Allocations).To(HaveLen(1))
+			Expect(slicePool.Spec.Allocations["1"].PodRef).To(Equal(fmt.Sprintf("%s/live-pod", namespace)))
+		})
+	})
+
+	Context("reconciling cluster wide IPs no IP pool allocation backs", func() {
+		const (
+			ipRange      = "10.10.10.0/24"
+			backedIP     = "10.10.10.1"
+			orphanedIP   = "10.10.10.4"
+			inFlightIP   = "10.10.10.5"
+			backedPod    = "backed-pod"
+			orphanedPod  = "orphaned-pod"
+			inFlightPod  = "in-flight-pod"
+			poolName     = "pool1"
+			eventsBuffer = 10
+		)
+
+		var (
+			podClientSet k8sclient.Interface
+			wbClient     wbclient.Interface
+			recorder     *record.FakeRecorder
+		)
+
+		BeforeEach(func() {
+			// the pods hold both the IP their pool allocates them - .1, .2 and .3 - and the one of their reservation
+			podClientSet = fakek8sclient.NewSimpleClientset(
+				generatePod(namespace, backedPod, ipInNetwork{ip: backedIP, networkName: networkName}),
+				generatePod(namespace, orphanedPod, ipInNetwork{ip: "10.10.10.2", networkName: networkName}, ipInNetwork{ip: orphanedIP, networkName: "net2"}),
+				generatePod(namespace, inFlightPod, ipInNetwork{ip: "10.10.10.3", networkName: networkName}, ipInNetwork{ip: inFlightIP, networkName: "net2"}))
+
+			inFlightReservation := generateClusterWideIPReservation(namespace, inFlightIP, fmt.Sprintf("%s/%s", namespace, inFlightPod))
+			inFlightReservation.CreationTimestamp = metav1.Now()
+			wbClient = fakewbclient.NewSimpleClientset(
+				generateIPPoolSpec(ipRange, namespace, poolName, backedPod, orphanedPod, inFlightPod),
+				generateClusterWideIPReservation(namespace, backedIP, fmt.Sprintf("%s/%s", namespace, backedPod)),
+				generateClusterWideIPReservation(namespace, orphanedIP, fmt.Sprintf("%s/%s", namespace, orphanedPod)),
+				inFlightReservation)
+			recorder = record.NewFakeRecorder(eventsBuffer)
+		})
+
+		It("deletes them once past the grace period, emitting an event per deletion", func() {
+			newReconciler, err := NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, podClientSet))
+			Expect(err).NotTo(HaveOccurred())
+			newReconciler.SetEventRecorder(recorder)
+			deletions := metrics.ReconcilerDeletions.Value(DeletionCauseReservationOrphan, kubernetes.UnknownNetwork)
+
+			Expect(newReconciler.ReconcileOverlappingIPAddresses(context.TODO())).To(Succeed())
+			Expect(newReconciler.ReconcileOrphanedReservations(context.TODO())).To(ConsistOf(orphanedIP))
+			Expect(metrics.ReconcilerDeletions.Value(DeletionCauseReservationOrphan, kubernetes.UnknownNetwork) - deletions).To(BeEquivalentTo(1))
+
+			clusterWideIPAllocations, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).List(context.TODO(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			var remaining []string
+			for _, reservation := range clusterWideIPAllocations.Items {
+				remaining = append(remaining, reservation.GetName())
+			}
+			Expect(remaining).To(ConsistOf(backedIP, inFlightIP))
+
+			Expect(recorder.Events).To(HaveLen(1))
+			Expect(<-recorder.Events).To(ContainSubstring(OrphanedReservationDeletedReason))
+		})
+
+		It("only reports them in dry-run mode, emitting an event per reservation it would delete", func() {
+			newReconciler, err := NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, podClientSet))
+			Expect(err).NotTo(HaveOccurred())
+			newReconciler.SetEventRecorder(recorder)
+			newReconciler.SetDryRun(true)
+			deletions := metrics.ReconcilerDeletions.Value(DeletionCauseReservationOrphan, kubernetes.UnknownNetwork)
+
+			Expect(newReconciler.ReconcileOrphanedReservations(context.TODO())).To(ConsistOf(orphanedIP))
+			Expect(metrics.ReconcilerDeletions.Value(DeletionCauseReservationOrphan, kubernetes.UnknownNetwork)).To(Equal(deletions))
+
+			clusterWideIPAllocations, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).List(context.TODO(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterWideIPAllocations.Items).To(HaveLen(3))
+
+			Expect(recorder.Events).To(HaveLen(1))
+			Expect(<-recorder.Events).To(ContainSubstring(WouldDeleteReservationReason))
+		})
 	})
 
 	Context("reconciling cluster wide IPs - overlapping IPs (ipv6)", func() {
@@ -337,9 +678,9 @@ var _ = Describe("Whereabouts IP reconciler", func() {
 		})
 
 		It("will not delete an IP address that isn't orphaned after running reconciler", func() {
-			newReconciler, err := NewReconcileLooperWithClient(kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
+			newReconciler, err := NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
 			Expect(err).NotTo(HaveOccurred())
-			Expect(newReconciler.ReconcileOverlappingIPAddresses()).To(Succeed())
+			Expect(newReconciler.ReconcileOverlappingIPAddresses(context.TODO())).To(Succeed())
 
 			expectedClusterWideIPs := 1
 			clusterWideIPAllocations, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).List(context.TODO(), metav1.ListOptions{})
@@ -349,6 +690,113 @@ var _ = Describe("Whereabouts IP reconciler", func() {
 		})
 	})
 
+	Context("reconciling a cluster wide reservation left behind by an interrupted allocation", func() {
+		const (
+			poolName = "pool1"
+			strayIP  = "10.10.10.5"
+		)
+
+		var (
+			podClientSet k8sclient.Interface
+			wbClient     wbclient.Interface
+		)
+
+		BeforeEach(func() {
+			pod := generatePod(namespace, podName, ipInNetwork{ip: firstIPInRange, networkName: networkName})
+			podClientSet = fakek8sclient.NewSimpleClientset(pod)
+
+			podRef := fmt.Sprintf("%s/%s", namespace, podName)
+			wbClient = fakewbclient.NewSimpleClientset(
+				generateIPPoolSpec(ipRange, namespace, poolName, podName),
+				generateClusterWideIPReservation(namespace, firstIPInRange, podRef),
+				generateClusterWideIPReservation(namespace, strayIP, podRef))
+		})
+
+		It("deletes the reservation of the IP the live pod does not hold", func() {
+			newReconciler, err := NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, podClientSet))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newReconciler.ReconcileOverlappingIPAddresses(context.TODO())).To(Succeed())
+
+			clusterWideIPAllocations, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).List(context.TODO(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusterWideIPAllocations.Items).To(HaveLen(1))
+			Expect(clusterWideIPAllocations.Items[0].GetName()).To(Equal(firstIPInRange))
+		})
+	})
+
+	Context("incremental runs", func() {
+		const poolName = "pool1"
+
+		var (
+			podClientSet k8sclient.Interface
+			wbClient     wbclient.Interface
+		)
+
+		runIncrementalReconciler := func() *ReconcileReport {
+			looper, err := NewIncrementalReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, podClientSet), namespace)
+			Expect(err).NotTo(HaveOccurred())
+			report, err := InvokeIPReconciler(context.TODO(), looper)
+			Expect(err).NotTo(HaveOccurred())
+			return report
+		}
+
+		cursorEntry := func() poolCursor {
+			configMap, err := podClientSet.CoreV1().ConfigMaps(namespace).Get(context.TODO(), CursorConfigMapName, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(configMap.Data).To(HaveKey(namespace + "." + poolName))
+			var entry poolCursor
+			Expect(json.Unmarshal([]byte(configMap.Data[namespace+"."+poolName]), &entry)).To(Succeed())
+			return entry
+		}
+
+		BeforeEach(func() {
+			pod := generatePod(namespace, podName, ipInNetwork{ip: firstIPInRange, networkName: networkName})
+			podClientSet = fakek8sclient.NewSimpleClientset(pod)
+			pool := generateIPPoolSpec(ipRange, namespace, poolName, podName)
+			pool.Status.Orphaned = nil
+			wbClient = fakewbclient.NewSimpleClientset(pool)
+
+			Expect(runIncrementalReconciler().CleanedUpIPs).To(BeEmpty())
+		})
+
+		It("record the pools found clean", func() {
+			entry := cursorEntry()
+			Expect(entry.ResourceVersion).To(Equal("1"))
+			Expect(entry.LastReconciled).NotTo(BeEmpty())
+		})
+
+		It("skip the pools unchanged since", func() {
+			const lastReconciled = "2000-01-01T00:00:00Z"
+			configMap, err := podClientSet.CoreV1().ConfigMaps(namespace).Get(context.TODO(), CursorConfigMapName, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			entry := cursorEntry()
+			entry.LastReconciled = lastReconciled
+			entryBytes, err := json.Marshal(entry)
+			Expect(err).NotTo(HaveOccurred())
+			configMap.Data[namespace+"."+poolName] = string(entryBytes)
+			_, err = podClientSet.CoreV1().ConfigMaps(namespace).Update(context.TODO(), configMap, metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runIncrementalReconciler().CleanedUpIPs).To(BeEmpty())
+			Expect(cursorEntry().LastReconciled).To(Equal(lastReconciled))
+		})
+
+		It("reconcile the pools whose pods changed", func() {
+			Expect(podClientSet.CoreV1().Pods(namespace).Delete(context.TODO(), podName, metav1.DeleteOptions{})).To(Succeed())
+			Expect(runIncrementalReconciler().CleanedUpIPs).To(BeEmpty())
+
+			By("letting the grace period of the allocation found orphaned run out")
+			pool, err := wbClient.WhereaboutsV1alpha1().IPPools(namespace).Get(context.TODO(), poolName, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pool.Status.Orphaned).To(HaveKey("1"))
+			markOrphaned(pool, time.Now().Add(-time.Hour), "1")
+			_, err = wbClient.WhereaboutsV1alpha1().IPPools(namespace).Update(context.TODO(), pool, metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runIncrementalReconciler().CleanedUpIPs).To(Equal([]string{firstIPInRange}))
+		})
+	})
+
 	Context("a pod in pending state, without an IP in its network-status", func() {
 		const poolName = "pool1"
 
@@ -368,12 +816,99 @@ var _ = Describe("Whereabouts IP reconciler", func() {
 
 			pool = generateIPPoolSpec(ipRange, namespace, poolName, pod.Name)
 			wbClient = fakewbclient.NewSimpleClientset(pool)
-			reconcileLooper, err = NewReconcileLooperWithClient(kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
+			reconcileLooper, err = NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
 			Expect(err).NotTo(HaveOccurred())
 		})
 
 		It("can be reconciled", func() {
-			Expect(reconcileLooper.ReconcileIPPools()).NotTo(BeEmpty())
+			Expect(reconcileLooper.ReconcileIPPools(context.TODO())).NotTo(BeEmpty())
+		})
+	})
+
+	Context("a pool protected from churn", func() {
+		const (
+			protectedPoolName  = "pool1"
+			protectedIPRange   = "10.10.10.0/24"
+			reconciledPoolName = "pool2"
+			reconciledIPRange  = "10.10.20.0/24"
+		)
+
+		var wbClient wbclient.Interface
+
+		BeforeEach(func() {
+			var deadPods []string
+			for i := 0; i < 12; i++ {
+				deadPods = append(deadPods, fmt.Sprintf("dead-pod%d", i))
+			}
+			k8sClientSet = fakek8sclient.NewSimpleClientset(generatePod(namespace, podName))
+			wbClient = fakewbclient.NewSimpleClientset(
+				generateIPPoolSpec(protectedIPRange, namespace, protectedPoolName, deadPods...),
+				generateIPPoolSpec(reconciledIPRange, namespace, reconciledPoolName, "gone-pod"),
+				generateClusterWideIPReservation(namespace, "10.10.10.1", namespace+"/dead-pod0"),
+				generateClusterWideIPReservation(namespace, "10.10.20.1", namespace+"/gone-pod"))
+
+			var err error
+			reconcileLooper, err = NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("is skipped along with its cluster wide reservations, while the run carries on", func() {
+			report, err := InvokeIPReconciler(context.TODO(), reconcileLooper)
+			Expect(err).To(MatchError(ContainSubstring("12 of 12")))
+			Expect(report.CleanedUpIPs).To(Equal([]string{"10.10.20.1"}))
+			Expect(report.CleanedUpOverlappingIPs).To(Equal([]string{"10.10.20.1"}))
+			Expect(report.ChurnProtectedPools).To(Equal([]string{namespace + "/" + protectedPoolName + " 12 of 12"}))
+
+			pool, err := wbClient.WhereaboutsV1alpha1().IPPools(namespace).Get(context.TODO(), protectedPoolName, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pool.Spec.Allocations).To(HaveLen(12))
+			_, err = wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).Get(context.TODO(), "10.10.10.1", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("is reconciled when mass deletions are allowed", func() {
+			reconcileLooper.SetMaxChurnPercent(100)
+			report, err := InvokeIPReconciler(context.TODO(), reconcileLooper)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.CleanedUpIPs).To(HaveLen(13))
+			Expect(report.CleanedUpOverlappingIPs).To(HaveLen(2))
+		})
+	})
+
+	Context("a pool outliving its own deadline", func() {
+		const (
+			poolName        = "pool1"
+			pendingPoolName = "pool2"
+			pendingPodName  = "pod2"
+			pendingIPRange  = "10.10.20.0/24"
+		)
+
+		var defaultPoolTimeout time.Duration
+
+		BeforeEach(func() {
+			defaultPoolTimeout = poolTimeout
+			poolTimeout = 100 * time.Millisecond
+
+			// the pool of the pending pod re-fetches it until its deadline, while the pod of the other pool is gone
+			k8sClientSet = fakek8sclient.NewSimpleClientset(generatePendingPod(namespace, pendingPodName))
+			wbClient := fakewbclient.NewSimpleClientset(
+				generateIPPoolSpec(ipRange, namespace, poolName, podName),
+				generateIPPoolSpec(pendingIPRange, namespace, pendingPoolName, pendingPodName))
+
+			var err error
+			reconcileLooper, err = NewReconcileLooperWithClient(context.TODO(), kubernetes.NewKubernetesClient(wbClient, k8sClientSet))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			poolTimeout = defaultPoolTimeout
+		})
+
+		It("is skipped, while the other pools are reconciled", func() {
+			report, err := InvokeIPReconciler(context.TODO(), reconcileLooper)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.CleanedUpIPs).To(Equal([]string{"10.10.10.1"}))
+			Expect(report.TimedOutPools).To(Equal([]string{namespace + "/" + pendingPoolName}))
 		})
 	})
 })
@@ -392,6 +927,25 @@ func (dp dummyPool) Update(context.Context, []types.IPReservation) error {
 	return nil
 }
 
+// failingPool is a pool whose updates fail
+type failingPool struct {
+	dummyPool
+}
+
+func (fp failingPool) Update(context.Context, []types.IPReservation) error {
+	return fmt.Errorf("the update of the pool failed")
+}
+
+// blockedPool is a pool whose updates hang until their context is done
+type blockedPool struct {
+	dummyPool
+}
+
+func (bp blockedPool) Update(ctx context.Context, _ []types.IPReservation) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
 var _ = Describe("IPReconciler", func() {
 	var ipReconciler *ReconcileLooper
 
@@ -409,7 +963,7 @@ var _ = Describe("IPReconciler", func() {
 		})
 
 		It("does not delete anything", func() {
-			reconciledIPs, err := ipReconciler.ReconcileIPPools()
+			reconciledIPs, err := ipReconciler.ReconcileIPPools(context.TODO())
 			Expect(err).NotTo(HaveOccurred())
 			Expect(reconciledIPs).To(BeEmpty())
 		})
@@ -437,7 +991,7 @@ var _ = Describe("IPReconciler", func() {
 		})
 
 		It("does delete the orphaned IP address", func() {
-			reconciledIPs, err := ipReconciler.ReconcileIPPools()
+			reconciledIPs, err := ipReconciler.ReconcileIPPools(context.TODO())
 			Expect(err).NotTo(HaveOccurred())
 			Expect(reconciledIPs).To(Equal([]net.IP{net.ParseIP(firstIPInRange)}))
 		})
@@ -457,14 +1011,117 @@ var _ = Describe("IPReconciler", func() {
 			})
 
 			It("does delete *only the orphaned* the IP address", func() {
-				reconciledIPs, err := ipReconciler.ReconcileIPPools()
+				reconciledIPs, err := ipReconciler.ReconcileIPPools(context.TODO())
 				Expect(err).NotTo(HaveOccurred())
 				Expect(reconciledIPs).To(ConsistOf([]net.IP{net.ParseIP("192.168.14.2")}))
 			})
 		})
 	})
+
+	When("the pools are updated concurrently", func() {
+		const pools = 8
+
+		BeforeEach(func() {
+			var orphanedIPs []OrphanedIPReservations
+			for i := 0; i < pools; i++ {
+				reservations := generateIPReservation(fmt.Sprintf("192.168.16.%d", i+1), fmt.Sprintf("default/pod%d", i))
+				orphanedIPs = append(orphanedIPs, OrphanedIPReservations{
+					Pool:        dummyPool{orphans: reservations},
+					Allocations: reservations,
+				})
+			}
+			ipReconciler = newIPReconciler(orphanedIPs...)
+			ipReconciler.SetConcurrency(4)
+		})
+
+		It("reports the orphaned IP addresses of every pool, in order", func() {
+			reconciledIPs, err := ipReconciler.ReconcileIPPools(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reconciledIPs).To(HaveLen(pools))
+			for i, ip := range reconciledIPs {
+				Expect(ip.String()).To(Equal(fmt.Sprintf("192.168.16.%d", i+1)))
+			}
+		})
+
+		It("fails once the update of a pool fails", func() {
+			reservations := generateIPReservation("192.168.17.1", "default/pod")
+			ipReconciler.orphanedIPs = append(ipReconciler.orphanedIPs, OrphanedIPReservations{
+				Pool:        failingPool{dummyPool{orphans: reservations}},
+				Allocations: reservations,
+			})
+			reconciledIPs, err := ipReconciler.ReconcileIPPools(context.TODO())
+			Expect(err).To(MatchError(ContainSubstring("the update of the pool failed")))
+			Expect(reconciledIPs).To(BeEmpty())
+		})
+	})
+
+	When("most of a pool's allocations look orphaned", func() {
+		const (
+			allocations = 20
+			orphans     = 15
+		)
+
+		BeforeEach(func() {
+			var reservations []types.IPReservation
+			for i := 0; i < allocations; i++ {
+				reservations = append(reservations, generateIPReservation(fmt.Sprintf("192.168.15.%d", i+1), fmt.Sprintf("default/pod%d", i))...)
+			}
+			ipReconciler = newIPReconciler(OrphanedIPReservations{
+				Pool:        dummyPool{orphans: reservations},
+				Allocations: reservations[:orphans],
+			})
+			ipReconciler.SetMaxChurnPercent(DefaultMaxChurnPercent)
+		})
+
+		It("refuses to delete them", func() {
+			reconciledIPs, err := ipReconciler.ReconcileIPPools(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reconciledIPs).To(BeEmpty())
+			Expect(ipReconciler.ChurnProtectedPools()).To(HaveLen(1))
+			Expect(ipReconciler.ChurnProtectionError()).To(MatchError(ContainSubstring("15 of 20")))
+		})
+
+		It("reconciles the other pools all the same", func() {
+			reservations := generateIPReservation("192.168.16.1", "default/pod")
+			ipReconciler.orphanedIPs = append(ipReconciler.orphanedIPs, OrphanedIPReservations{
+				Pool:        dummyPool{orphans: reservations},
+				Allocations: reservations,
+			})
+			reconciledIPs, err := ipReconciler.ReconcileIPPools(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reconciledIPs).To(Equal([]net.IP{net.ParseIP("192.168.16.1")}))
+			Expect(ipReconciler.ChurnProtectedPools()).To(HaveLen(1))
+		})
+
+		It("deletes them when explicitly allowed to", func() {
+			ipReconciler.SetMaxChurnPercent(100)
+			reconciledIPs, err := ipReconciler.ReconcileIPPools(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reconciledIPs).To(HaveLen(orphans))
+		})
+	})
+
+	When("the update of a pool outlives the deadline its search started", func() {
+		BeforeEach(func() {
+			reservations := generateIPReservation("192.168.17.1", "default/pod")
+			ipReconciler = newIPReconciler(OrphanedIPReservations{
+				Pool:        blockedPool{dummyPool{orphans: reservations}},
+				Allocations: reservations,
+				deadline:    time.Now().Add(100 * time.Millisecond),
+			})
+		})
+
+		It("gives the pool up once its deadline is over", func() {
+			reconciledIPs, err := ipReconciler.ReconcileIPPools(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reconciledIPs).To(BeEmpty())
+			Expect(ipReconciler.TimedOutPools()).To(HaveLen(1))
+		})
+	})
 })
 
+// generateIPPoolSpec generates the pool of the allocations of the pods. The allocations are recorded as found orphaned
+// an hour ago, for those of the pods gone to be past their grace period.
 func generateIPPoolSpec(ipRange string, namespace string, poolName string, podNames ...string) *v1alpha1.IPPool {
 	allocations := map[string]v1alpha1.IPAllocation{}
 	for i, podName := range podNames {
@@ -472,13 +1129,26 @@ func generateIPPoolSpec(ipRange string, namespace string, poolName string, podNa
 			PodRef: fmt.Sprintf("%s/%s", namespace, podName),
 		}
 	}
-	return &v1alpha1.IPPool{
+	pool := &v1alpha1.IPPool{
 		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: poolName, ResourceVersion: "1"},
 		Spec: v1alpha1.IPPoolSpec{
 			Range:       ipRange,
 			Allocations: allocations,
 		},
 	}
+	for offset := range allocations {
+		markOrphaned(pool, time.Now().Add(-time.Hour), offset)
+	}
+	return pool
+}
+
+func markOrphaned(pool *v1alpha1.IPPool, since time.Time, offsets ...string) {
+	if pool.Status.Orphaned == nil {
+		pool.Status.Orphaned = map[string]metav1.Time{}
+	}
+	for _, offset := range offsets {
+		pool.Status.Orphaned[offset] = metav1.NewTime(since)
+	}
 }
 
 func generateClusterWideIPReservation(namespace string, ip string, ownerPodRef string) *v1alpha1.OverlappingRangeIPReservation {
@@ -5,92 +5,353 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
 
 	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
 )
 
+const (
+	// DefaultMaxChurnPercent is the share of a pool's allocations a single reconciler pass may delete
+	DefaultMaxChurnPercent = 50
+	// churnProtectionMinAllocations is the pool size under which the churn limit does not apply: deleting most of
+	// the allocations of small pools is routine.
+	churnProtectionMinAllocations = 10
+)
+
+// The causes of the reconciler deletions, labelling the metrics.ReconcilerDeletions counters
+const (
+	// DeletionCausePodGone is the cause of the deletion of the IP pool allocations of pods which no longer exist
+	DeletionCausePodGone = "pod_gone"
+	// DeletionCauseContainerIDMismatch is the cause of the deletion of the IP pool allocations of live pods not holding
+	// their IP: these were left behind by a previous sandbox of the pod, whose container ID no longer matches
+	DeletionCauseContainerIDMismatch = "container_id_mismatch"
+	// DeletionCausePoolOrphan is the cause of the deletion of the cluster wide reservations of pods no longer holding
+	// their IP, which follow the orphaned IP pool allocations
+	DeletionCausePoolOrphan = "pool_orphan"
+	// DeletionCauseReservationOrphan is the cause of the deletion of the cluster wide reservations no IP pool
+	// allocation backs
+	DeletionCauseReservationOrphan = "reservation_orphan"
+)
+
+// poolTimeout bounds the reconciliation of each IP pool - first the search for its orphaned allocations, re-fetching
+// its pending pods, then their deletion - so that a pathological pool is given up, rather than the whole run
+var poolTimeout = time.Minute
+
 type ReconcileLooper struct {
 	k8sClient              kubernetes.Client
 	liveWhereaboutsPods    map[string]podWrapper
 	orphanedIPs            []OrphanedIPReservations
 	orphanedClusterWideIPs []whereaboutsv1alpha1.OverlappingRangeIPReservation
+	orphanedReservations   []orphanedReservation
+	poolAllocationOwners   map[string]map[string]struct{}
+	recorder               record.EventRecorder
+	dryRun                 bool
+	concurrency            int
+	compact                bool
+	verifyReservations     bool
+	maxChurnPercent        int
+	minLivePods            int
+	podListGuard           *PodListGuard
+	podCount               int
+	cursor                 *reconcilerCursor
+	unreconciledNetworks   kubernetes.UnreconciledNetworks
+	networks               kubernetes.WhereaboutsNetworks
+	nodeSlices             kubernetes.NodeSlices
+	// timedOutPools are the pools whose reconciliation exceeded their deadline, and was given up
+	timedOutPools []string
+	// churnProtectedPools are the pools left untouched for holding too many orphaned allocations, and
+	// churnProtectedIPs their orphaned allocations, whose cluster wide reservations are left untouched as well
+	churnProtectedPools []string
+	churnProtectedIPs   map[string]struct{}
+}
+
+// rangedPool is implemented by the pools whose network can be told from their name and range
+type rangedPool interface {
+	Name() string
+	Range() string
 }
 
 type OrphanedIPReservations struct {
 	Pool        storage.IPPool
 	Allocations []types.IPReservation
+	// deadline is that of the reconciliation of the pool, set once the search for its orphaned allocations starts
+	deadline time.Time
+	// orphanedSince, when not nil, is the time each allocation of the pool was first found orphaned, keyed by IP, to
+	// record in the pool
+	orphanedSince map[string]time.Time
+}
+
+// poolContext bounds the reconciliation of a pool by its deadline, or by poolTimeout from now when it has none
+func poolContext(ctx context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return context.WithTimeout(ctx, poolTimeout)
+	}
+	return context.WithDeadline(ctx, deadline)
 }
 
-func NewReconcileLooper() (*ReconcileLooper, error) {
-	logging.Debugf("NewReconcileLooper - inferred connection data")
-	k8sClient, err := kubernetes.NewClient()
+func NewReconcileLooper(ctx context.Context) (*ReconcileLooper, error) {
+	return NewReconcileLooperWithKubeconfig(ctx, "", kubernetes.RateLimit{})
+}
+
+// NewReconcileLooperWithKubeconfig creates a ReconcileLooper connecting to the cluster described by the provided
+// kubeconfig file, through a dedicated client throttled by the rate limit. When no path is provided, the in-cluster
+// configuration is used.
+func NewReconcileLooperWithKubeconfig(ctx context.Context, kubeconfigPath string, rateLimit kubernetes.RateLimit) (*ReconcileLooper, error) {
+	k8sClient, err := newReconcilerClient(kubeconfigPath, rateLimit)
+	if err != nil {
+		return nil, err
+	}
+	return NewReconcileLooperWithClient(ctx, k8sClient)
+}
+
+// NewIncrementalReconcileLooper creates a ReconcileLooper which skips the pools found clean by a previous run, unless
+// they - or the pods they serve - changed since. The cursor tracking them is persisted in a config map of the given
+// namespace once the run completes. When no kubeconfig path is provided, the in-cluster configuration is used.
+func NewIncrementalReconcileLooper(ctx context.Context, kubeconfigPath, cursorNamespace string, rateLimit kubernetes.RateLimit) (*ReconcileLooper, error) {
+	k8sClient, err := newReconcilerClient(kubeconfigPath, rateLimit)
+	if err != nil {
+		return nil, err
+	}
+	return NewIncrementalReconcileLooperWithClient(ctx, k8sClient, cursorNamespace)
+}
+
+func newReconcilerClient(kubeconfigPath string, rateLimit kubernetes.RateLimit) (*kubernetes.Client, error) {
+	if kubeconfigPath == "" {
+		logging.Debugf("NewReconcileLooper - inferred connection data")
+	} else {
+		logging.Debugf("NewReconcileLooper - using the provided kubeconfig")
+	}
+	k8sClient, err := kubernetes.NewClientWithUserAgent(kubeconfigPath, kubernetes.ReconcilerUserAgent, rateLimit)
 	if err != nil {
 		return nil, logging.Errorf("failed to instantiate the Kubernetes client: %+v", err)
 	}
-	return NewReconcileLooperWithClient(k8sClient)
+	return k8sClient, nil
+}
+
+func NewIncrementalReconcileLooperWithClient(ctx context.Context, k8sClient *kubernetes.Client, cursorNamespace string) (*ReconcileLooper, error) {
+	cursor, err := loadCursor(ctx, k8sClient, cursorNamespace)
+	if err != nil {
+		return nil, logging.Errorf("%v", err)
+	}
+	return newReconcileLooper(ctx, k8sClient, cursor)
 }
 
-func NewReconcileLooperWithClient(k8sClient *kubernetes.Client) (*ReconcileLooper, error) {
-	ipPools, err := k8sClient.ListIPPools()
+func NewReconcileLooperWithClient(ctx context.Context, k8sClient *kubernetes.Client) (*ReconcileLooper, error) {
+	return newReconcileLooper(ctx, k8sClient, nil)
+}
+
+func newReconcileLooper(ctx context.Context, k8sClient *kubernetes.Client, cursor *reconcilerCursor) (*ReconcileLooper, error) {
+	ipPools, err := k8sClient.ListIPPools(ctx)
 	if err != nil {
 		return nil, logging.Errorf("failed to retrieve all IP pools: %v", err)
 	}
 
-	pods, err := k8sClient.ListPods()
+	pods, err := k8sClient.ListPods(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	netAttachDefs, err := k8sClient.ListNetAttachDefs(ctx)
+	if err != nil {
+		return nil, logging.Errorf("failed to retrieve all network-attachment-definitions: %v", err)
+	}
+
+	nodeSlicePools, err := k8sClient.ListNodeSlicePools(ctx)
+	if k8serrors.IsNotFound(err) {
+		// the NodeSlicePool CRD is only installed along with the node slice controller
+		logging.Debugf("NodeSlicePools are not served by the cluster: %v", err)
+	} else if err != nil {
+		return nil, logging.Errorf("failed to retrieve all node slice pools: %v", err)
+	}
+
 	whereaboutsPodRefs := getPodRefsServedByWhereabouts(ipPools)
 	looper := &ReconcileLooper{
-		k8sClient:           *k8sClient,
-		liveWhereaboutsPods: indexPods(pods, whereaboutsPodRefs),
+		k8sClient:            *k8sClient,
+		liveWhereaboutsPods:  indexPods(pods, whereaboutsPodRefs),
+		maxChurnPercent:      DefaultMaxChurnPercent,
+		podCount:             len(pods),
+		cursor:               cursor,
+		unreconciledNetworks: kubernetes.NewUnreconciledNetworks(netAttachDefs),
+		networks:             kubernetes.NewWhereaboutsNetworks(netAttachDefs),
+		nodeSlices:           kubernetes.NewNodeSlices(nodeSlicePools),
+		poolAllocationOwners: indexPoolAllocations(ipPools),
 	}
 
-	if err := looper.findOrphanedIPsPerPool(ipPools); err != nil {
+	if err := looper.findOrphanedIPsPerPool(ctx, ipPools); err != nil {
 		return nil, err
 	}
 
-	if err := looper.findClusterWideIPReservations(); err != nil {
+	if err := looper.findClusterWideIPReservations(ctx); err != nil {
 		return nil, err
 	}
 	return looper, nil
 }
 
-func (rl *ReconcileLooper) findOrphanedIPsPerPool(ipPools []storage.IPPool) error {
+// SetMinLivePods sets the number of pods under which the pod list is deemed incomplete, e.g. because the API returned
+// partial data, in which case no allocation is treated as orphaned. 0 disables the check.
+func (rl *ReconcileLooper) SetMinLivePods(minPods int) {
+	rl.minLivePods = minPods
+}
+
+// SetPodListGuard sets the guard checking the pod count of the run against that of the previous runs sharing it. A
+// sudden drop of the pod count is only caught by the runs of a long-lived process sharing their guard.
+func (rl *ReconcileLooper) SetPodListGuard(guard *PodListGuard) {
+	rl.podListGuard = guard
+}
+
+// SetMaxChurnPercent sets the share of a pool's allocations a single pass may delete. Pools exceeding it are left
+// untouched, which protects healthy pools from mass-deletion bugs, e.g. an incomplete pod list. 100 disables the limit.
+func (rl *ReconcileLooper) SetMaxChurnPercent(percent int) {
+	rl.maxChurnPercent = percent
+}
+
+// SetConcurrency sets the number of IP pools the run updates at once. 0 and 1 update them one after the other.
+func (rl *ReconcileLooper) SetConcurrency(concurrency int) {
+	rl.concurrency = concurrency
+}
+
+// SetCompaction sets whether the run compacts the IP pools once reconciled: their allocations are rewritten, the
+// tombstones dropped, see kubernetes.CompactIPPool
+func (rl *ReconcileLooper) SetCompaction(compact bool) {
+	rl.compact = compact
+}
+
+// SetReservationVerification sets whether the run repairs the cluster wide reservations missing for, or disagreeing
+// with, the IP pool allocations, see kubernetes.Client.VerifyOverlappingReservations
+func (rl *ReconcileLooper) SetReservationVerification(verify bool) {
+	rl.verifyReservations = verify
+}
+
+func (rl *ReconcileLooper) findOrphanedIPsPerPool(ctx context.Context, ipPools []storage.IPPool) error {
+	now := time.Now()
 	for _, pool := range ipPools {
+		if rangedPool, isRanged := pool.(rangedPool); isRanged && rl.unreconciledNetworks.ContainsPool(rangedPool.Name(), rangedPool.Range()) {
+			logging.Debugf("pool %s belongs to a network opted out of reconciliation; skipping", rangedPool.Name())
+			continue
+		}
+
+		if slicedPool, isNamespaced := pool.(namespacedPool); isNamespaced {
+			if nodeSlicePool, nodeName, isSlice := rl.nodeSlices.SlicePool(slicedPool.Namespace(), slicedPool.Name()); isSlice {
+				logging.Debugf("pool %s serves the slice of node %s of node slice pool %s", slicedPool.Name(), nodeName, nodeSlicePool)
+			}
+		}
+
+		trackedPool, isTracked := pool.(trackedPool)
+		isTracked = isTracked && rl.cursor != nil
+		var digest string
+		if isTracked {
+			digest = podsDigest(pool, rl.liveWhereaboutsPods)
+			if rl.cursor.unchanged(trackedPool, digest) {
+				logging.Debugf("pool %s unchanged since it was last reconciled; skipping", trackedPool.Name())
+				continue
+			}
+		}
+
+		gracePeriod := AllocationGracePeriod(pool)
+		trackingPool, tracksOrphans := pool.(orphanTrackingPool)
+		var orphanedSince, nextOrphanedSince map[string]time.Time
+		if tracksOrphans {
+			orphanedSince = trackingPool.OrphanedSince()
+			nextOrphanedSince = map[string]time.Time{}
+		}
 		orphanIP := OrphanedIPReservations{
-			Pool: pool,
+			Pool:     pool,
+			deadline: time.Now().Add(poolTimeout),
 		}
+		poolCtx, cancel := poolContext(ctx, orphanIP.deadline)
+		var inFlight bool
 		for _, ipReservation := range pool.Allocations() {
 			logging.Debugf("the IP reservation: %s", ipReservation)
 			if ipReservation.PodRef == "" {
 				_ = logging.Errorf("pod ref missing for Allocations: %s", ipReservation)
 				continue
 			}
-			if !rl.isOrphanedIP(ipReservation.PodRef, ipReservation.IP.String()) {
+			if types.IsFloatingIPClaim(ipReservation.ContainerID) {
+				logging.Debugf("IP %s is the floating IP of %s; skipping", ipReservation.IP, ipReservation.ContainerID)
+				continue
+			}
+			if types.IsPreReservation(ipReservation.ContainerID) && rl.isLivePod(ipReservation.PodRef, ipReservation.PodUID) {
+				logging.Debugf("IP %s is pre-reserved for pod ref %s, not started yet; skipping", ipReservation.IP, ipReservation.PodRef)
+				inFlight = true
+				continue
+			}
+			if now.Sub(ipReservation.AllocatedAt) < gracePeriod {
+				logging.Debugf("IP %s was allocated to pod ref %s at %s; skipping", ipReservation.IP, ipReservation.PodRef, ipReservation.AllocatedAt)
+				inFlight = true
+				continue
+			}
+			if !rl.isOrphanedIP(poolCtx, ipReservation.PodRef, ipReservation.PodUID, ipReservation.IP.String()) {
 				logging.Debugf("pod ref %s is not listed in the live pods list", ipReservation.PodRef)
+				if tracksOrphans {
+					since := rl.orphanedSince(orphanedSince, ipReservation, now)
+					nextOrphanedSince[ipReservation.IP.String()] = since
+					if now.Sub(since) < gracePeriod {
+						logging.Debugf("IP %s of pod ref %s was first found orphaned at %s; skipping", ipReservation.IP, ipReservation.PodRef, since)
+						inFlight = true
+						continue
+					}
+				}
 				orphanIP.Allocations = append(orphanIP.Allocations, ipReservation)
 			}
 		}
-		if len(orphanIP.Allocations) > 0 {
+		timedOut := poolCtx.Err() != nil && ctx.Err() == nil
+		cancel()
+		if timedOut {
+			// the pods which could not be re-fetched in time are no evidence of orphaned allocations
+			rl.timeOut(pool)
+			continue
+		}
+		if tracksOrphans && !rl.dryRun && !sameOrphanedSince(orphanedSince, nextOrphanedSince) {
+			orphanIP.orphanedSince = nextOrphanedSince
+		}
+		if len(orphanIP.Allocations) > 0 || orphanIP.orphanedSince != nil {
 			rl.orphanedIPs = append(rl.orphanedIPs, orphanIP)
+		} else if isTracked && !inFlight && !rl.servesPendingPods(pool) {
+			rl.cursor.markClean(trackedPool, digest, now)
 		}
 	}
 
 	return nil
 }
 
-func (rl ReconcileLooper) isOrphanedIP(podRef string, ip string) bool {
+// servesPendingPods reports whether pending pods were allocated addresses from the pool: these may not list their IPs
+// yet, and are hence reconciled again on the next run.
+func (rl ReconcileLooper) servesPendingPods(pool storage.IPPool) bool {
+	for _, allocation := range pool.Allocations() {
+		if livePod, isAlive := rl.liveWhereaboutsPods[allocation.PodRef]; isAlive && livePod.phase == v1.PodPending {
+			return true
+		}
+	}
+	return false
+}
+
+// isLivePod tells whether the pod of the podRef - and of the UID, when recorded - is live
+func (rl ReconcileLooper) isLivePod(podRef, podUID string) bool {
+	livePod, isAlive := rl.liveWhereaboutsPods[podRef]
+	return isAlive && (podUID == "" || livePod.uid == "" || string(livePod.uid) == podUID)
+}
+
+// isOrphanedIP tells whether the live pod of the podRef holds the IP - despite its name. An IP allocated to a pod of
+// another UID than the live one, when recorded, belongs to a predecessor of the same name, e.g. a recreated
+// StatefulSet pod.
+func (rl ReconcileLooper) isOrphanedIP(ctx context.Context, podRef, podUID, ip string) bool {
 	for livePodRef, livePod := range rl.liveWhereaboutsPods {
 		if podRef == livePodRef {
+			if podUID != "" && livePod.uid != "" && podUID != string(livePod.uid) {
+				logging.Debugf("IP %s of pod ref %s belongs to pod UID %s, not the live %s", ip, podRef, podUID, livePod.uid)
+				return false
+			}
 			isFound := isIpOnPod(&livePod, podRef, ip)
 			if !isFound && (livePod.phase == v1.PodPending) {
 				/* Sometimes pods are still coming up, and may not yet have Multus
@@ -104,7 +365,7 @@ func (rl ReconcileLooper) isOrphanedIP(podRef string, ip string) bool {
 
 				for retries < storage.PodRefreshRetries {
 					retries += 1
-					podToMatch = rl.refreshPod(livePodRef)
+					podToMatch = rl.refreshPod(ctx, livePodRef)
 					if podToMatch == nil {
 						logging.Debugf("Cleaning up...")
 						return false
@@ -118,7 +379,12 @@ func (rl ReconcileLooper) isOrphanedIP(podRef string, ip string) bool {
 							logging.Debugf("Pod now has IP annotation while in Pending")
 							return true
 						}
-						time.Sleep(time.Duration(250) * time.Millisecond)
+						select {
+						case <-ctx.Done():
+							// the deadline of the pool passed: the pod is left alone
+							return true
+						case <-time.After(time.Duration(250) * time.Millisecond):
+						}
 					}
 				}
 				isFound = isIpOnPod(podToMatch, podRef, ip)
@@ -130,14 +396,14 @@ func (rl ReconcileLooper) isOrphanedIP(podRef string, ip string) bool {
 	return false
 }
 
-func (rl ReconcileLooper) refreshPod(podRef string) *podWrapper {
+func (rl ReconcileLooper) refreshPod(ctx context.Context, podRef string) *podWrapper {
 	namespace, podName := splitPodRef(podRef)
 	if namespace == "" || podName == "" {
 		logging.Errorf("Invalid podRef format: %s", podRef)
 		return nil
 	}
 
-	pod, err := rl.k8sClient.GetPod(namespace, podName)
+	pod, err := rl.k8sClient.GetPod(ctx, namespace, podName)
 	if err != nil {
 		logging.Errorf("Failed to refresh Pod %s: %s\n", podRef, err)
 		return nil
@@ -162,7 +428,7 @@ func composePodRef(pod v1.Pod) string {
 	return fmt.Sprintf("%s/%s", pod.GetNamespace(), pod.GetName())
 }
 
-func (rl ReconcileLooper) ReconcileIPPools() ([]net.IP, error) {
+func (rl *ReconcileLooper) ReconcileIPPools(ctx context.Context) ([]net.IP, error) {
 	findAllocationIndex := func(reservation types.IPReservation, reservations []types.IPReservation) int {
 		for idx, r := range reservations {
 			if r.PodRef == reservation.PodRef && r.IP.Equal(reservation.IP) {
@@ -173,11 +439,17 @@ func (rl ReconcileLooper) ReconcileIPPools() ([]net.IP, error) {
 	}
 
 	var totalCleanedUpIps []net.IP
+	var updates []*poolUpdate
 	for _, orphanedIP := range rl.orphanedIPs {
 		currentIPReservations := orphanedIP.Pool.Allocations()
+		if ExceedsMaxChurn(rl.maxChurnPercent, len(orphanedIP.Allocations), len(currentIPReservations)) {
+			rl.protectFromChurn(orphanedIP, len(currentIPReservations))
+			continue
+		}
 
 		// Process orphaned allocation peer pool
 		var cleanedUpIpsPerPool []net.IP
+		var cleanedUpAllocationsPerPool []types.IPReservation
 		for _, allocation := range orphanedIP.Allocations {
 			idx := findAllocationIndex(allocation, currentIPReservations)
 			if idx < 0 {
@@ -191,63 +463,295 @@ func (rl ReconcileLooper) ReconcileIPPools() ([]net.IP, error) {
 			currentIPReservations = currentIPReservations[:len(currentIPReservations)-1]
 
 			cleanedUpIpsPerPool = append(cleanedUpIpsPerPool, allocation.IP)
+			cleanedUpAllocationsPerPool = append(cleanedUpAllocationsPerPool, allocation)
 		}
 
-		if len(cleanedUpIpsPerPool) != 0 {
-			logging.Debugf("Going to update the reserve list to: %+v", currentIPReservations)
+		if len(cleanedUpIpsPerPool) != 0 && rl.dryRun {
+			rl.reportWouldDeleteAllocations(orphanedIP.Pool, cleanedUpAllocationsPerPool)
+			totalCleanedUpIps = append(totalCleanedUpIps, cleanedUpIpsPerPool...)
+		} else if len(cleanedUpIpsPerPool) != 0 || orphanedIP.orphanedSince != nil {
+			if trackingPool, tracksOrphans := orphanedIP.Pool.(orphanTrackingPool); tracksOrphans && orphanedIP.orphanedSince != nil {
+				trackingPool.SetOrphanedSince(orphanedIP.orphanedSince)
+			}
+			updates = append(updates, &poolUpdate{
+				pool:         orphanedIP.Pool,
+				deadline:     orphanedIP.deadline,
+				reservations: currentIPReservations,
+				ips:          cleanedUpIpsPerPool,
+				allocations:  cleanedUpAllocationsPerPool,
+			})
+		}
+	}
 
-			ctx, cancel := context.WithTimeout(context.Background(), storage.RequestTimeout)
-			if err := orphanedIP.Pool.Update(ctx, currentIPReservations); err != nil {
-				cancel()
-				return nil, logging.Errorf("failed to update the reservation list: %v", err)
+	rl.updatePools(ctx, updates)
+	var updateErr error
+	for _, update := range updates {
+		switch {
+		case !update.attempted:
+			continue
+		case update.timedOut:
+			rl.timeOut(update.pool)
+		case update.err != nil:
+			if updateErr == nil {
+				updateErr = update.err
 			}
+		default:
+			totalCleanedUpIps = append(totalCleanedUpIps, update.ips...)
+			rl.countPoolDeletions(update.pool, update.allocations)
+		}
+	}
+	if updateErr != nil {
+		return nil, logging.Errorf("failed to update the reservation list: %v", updateErr)
+	}
+
+	return totalCleanedUpIps, nil
+}
+
+// ChurnProtectedPools returns the pools left untouched for holding more orphaned allocations than the churn limit
+// allows, along with their orphaned and total allocations
+func (rl *ReconcileLooper) ChurnProtectedPools() []string {
+	return rl.churnProtectedPools
+}
+
+// ChurnProtectionError returns the error reporting the pools left untouched by the churn limit, if any
+func (rl *ReconcileLooper) ChurnProtectionError() error {
+	if len(rl.churnProtectedPools) == 0 {
+		return nil
+	}
+	return fmt.Errorf("refusing to delete more than %d%% of the allocations of a pool (orphaned allocations: %s); re-run with an explicit override if this is expected",
+		rl.maxChurnPercent, strings.Join(rl.churnProtectedPools, ", "))
+}
 
+// protectFromChurn skips the pool, along with the cluster wide reservations of its orphaned allocations: the other
+// pools are reconciled all the same
+func (rl *ReconcileLooper) protectFromChurn(orphanedIP OrphanedIPReservations, allocations int) {
+	poolName := poolName(orphanedIP.Pool)
+	_ = logging.Errorf("refusing to delete %d of the %d allocations of IP pool %s, above %d%%; skipping it",
+		len(orphanedIP.Allocations), allocations, poolName, rl.maxChurnPercent)
+	rl.churnProtectedPools = append(rl.churnProtectedPools, fmt.Sprintf("%s %d of %d", poolName, len(orphanedIP.Allocations), allocations))
+	if rl.churnProtectedIPs == nil {
+		rl.churnProtectedIPs = map[string]struct{}{}
+	}
+	for _, allocation := range orphanedIP.Allocations {
+		rl.churnProtectedIPs[churnProtectedIP(allocation.PodRef, allocation.IP.String())] = struct{}{}
+	}
+}
+
+// isChurnProtected tells whether the cluster wide reservation is that of an orphaned allocation of a pool left
+// untouched by the churn limit
+func (rl ReconcileLooper) isChurnProtected(reservation whereaboutsv1alpha1.OverlappingRangeIPReservation) bool {
+	ip := net.ParseIP(reservedIP(reservation.GetName(), rl.liveWhereaboutsPods[reservation.Spec.PodRef]))
+	if ip == nil {
+		return false
+	}
+	_, isProtected := rl.churnProtectedIPs[churnProtectedIP(reservation.Spec.PodRef, ip.String())]
+	return isProtected
+}
+
+func churnProtectedIP(podRef, ip string) string {
+	return podRef + "@" + ip
+}
+
+// poolUpdate is the rewrite of the allocations of an IP pool, rid of its orphaned ones
+type poolUpdate struct {
+	pool         storage.IPPool
+	reservations []types.IPReservation
+	ips          []net.IP
+	allocations  []types.IPReservation
+	deadline     time.Time
+	// attempted tells the update was issued: none is once an update failed
+	attempted bool
+	timedOut  bool
+	err       error
+}
+
+// updatePools issues the updates of the IP pools, up to the concurrency of the looper at once. Once an update fails -
+// short of timing out - the following ones are not issued, the run stopping at the first failure.
+func (rl *ReconcileLooper) updatePools(ctx context.Context, updates []*poolUpdate) {
+	slots := make(chan struct{}, max(rl.concurrency, 1))
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for _, update := range updates {
+		slots <- struct{}{}
+		if failed.Load() {
+			<-slots
+			break
+		}
+		update.attempted = true
+		wg.Add(1)
+		go func(update *poolUpdate) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			logging.Debugf("Going to update the reserve list to: %+v", update.reservations)
+
+			// the update shares the deadline of the search for the orphaned allocations of the pool
+			poolCtx, cancel := poolContext(ctx, update.deadline)
+			update.err = update.pool.Update(poolCtx, update.reservations)
+			update.timedOut = update.err != nil && poolCtx.Err() != nil && ctx.Err() == nil
 			cancel()
-			totalCleanedUpIps = append(totalCleanedUpIps, cleanedUpIpsPerPool...)
+			if update.err != nil && !update.timedOut {
+				failed.Store(true)
+			}
+		}(update)
+	}
+	wg.Wait()
+}
+
+// TimedOutPools returns the pools whose reconciliation exceeded their deadline, as namespace/name
+func (rl *ReconcileLooper) TimedOutPools() []string {
+	return rl.timedOutPools
+}
+
+func (rl *ReconcileLooper) timeOut(pool storage.IPPool) {
+	poolName := poolName(pool)
+	_ = logging.Errorf("reconciling IP pool %s took longer than %s; skipping it", poolName, poolTimeout)
+	rl.timedOutPools = append(rl.timedOutPools, poolName)
+}
+
+// poolName returns the name of the pool, as namespace/name for those backed by an IPPool object
+func poolName(pool storage.IPPool) string {
+	if namespacedPool, isNamespaced := pool.(namespacedPool); isNamespaced {
+		return namespacedPool.Namespace() + "/" + namespacedPool.Name()
+	} else if rangedPool, isRanged := pool.(rangedPool); isRanged {
+		return rangedPool.Name()
+	}
+	return ""
+}
+
+// countPoolDeletions accounts for the deleted IP pool allocations, telling the pods gone from those whose sandbox
+// changed
+func (rl ReconcileLooper) countPoolDeletions(pool storage.IPPool, allocations []types.IPReservation) {
+	network := rl.poolNetwork(pool)
+	for _, allocation := range allocations {
+		cause := DeletionCausePodGone
+		if _, isAlive := rl.liveWhereaboutsPods[allocation.PodRef]; isAlive {
+			cause = DeletionCauseContainerIDMismatch
 		}
+		metrics.ReconcilerDeletions.Inc(cause, network)
 	}
+}
 
-	return totalCleanedUpIps, nil
+// poolNetwork returns the label of the network of the IP pool. The pools of node slices are told by their
+// NodeSlicePool, their network-attachment-definition possibly being gone or unreadable.
+func (rl ReconcileLooper) poolNetwork(pool storage.IPPool) string {
+	if slicedPool, isNamespaced := pool.(namespacedPool); isNamespaced {
+		if network, isSlice := rl.nodeSlices.PoolNetwork(slicedPool.Namespace(), slicedPool.Name()); isSlice {
+			return network
+		}
+	}
+	if rangedPool, isRanged := pool.(rangedPool); isRanged {
+		return rl.networks.PoolNetwork(rangedPool.Name(), rangedPool.Range())
+	}
+	return kubernetes.UnknownNetwork
 }
 
-func (rl *ReconcileLooper) findClusterWideIPReservations() error {
-	clusterWideIPReservations, err := rl.k8sClient.ListOverlappingIPs()
+// clusterWideIPNetwork returns the label of the network of the cluster wide reservation
+func (rl ReconcileLooper) clusterWideIPNetwork(reservation whereaboutsv1alpha1.OverlappingRangeIPReservation) string {
+	ip := reservedIP(reservation.GetName(), rl.liveWhereaboutsPods[reservation.Spec.PodRef])
+	return rl.networks.ClusterWideIPNetwork(reservation.GetName(), net.ParseIP(ip))
+}
+
+// ExceedsMaxChurn tells whether deleting the orphaned allocations of a pool holding the given allocations deletes more
+// than maxChurnPercent of them; the pools of fewer than churnProtectionMinAllocations allocations never do
+func ExceedsMaxChurn(maxChurnPercent, orphanedAllocations, allocations int) bool {
+	if maxChurnPercent >= 100 || allocations < churnProtectionMinAllocations {
+		return false
+	}
+	return orphanedAllocations*100 > allocations*maxChurnPercent
+}
+
+func (rl *ReconcileLooper) findClusterWideIPReservations(ctx context.Context) error {
+	clusterWideIPReservations, err := rl.k8sClient.ListOverlappingIPs(ctx)
 	if err != nil {
 		return logging.Errorf("failed to list all OverLappingIPs: %v", err)
 	}
 
+	now := time.Now()
 	for _, clusterWideIPReservation := range clusterWideIPReservations {
-		ip := clusterWideIPReservation.GetName()
+		podRef := clusterWideIPReservation.Spec.PodRef
+		if types.IsFloatingIPClaim(clusterWideIPReservation.Spec.ContainerID) {
+			logging.Debugf("cluster wide IP %s is the floating IP of %s; skipping", clusterWideIPReservation.GetName(), clusterWideIPReservation.Spec.ContainerID)
+			continue
+		}
 		// De-normalize the IP
 		// In the UpdateOverlappingRangeAllocation function, the IP address is created with a "normalized" name to comply with the k8s api.
 		// We must denormalize here in order to properly look up the IP address in the regular format, which pods use.
-		denormalizedip := strings.ReplaceAll(ip, "-", ":")
-
-		podRef := clusterWideIPReservation.Spec.PodRef
+		denormalizedip := reservedIP(clusterWideIPReservation.GetName(), rl.liveWhereaboutsPods[podRef])
+		if rl.unreconciledNetworks.ContainsClusterWideIP(clusterWideIPReservation.GetName(), net.ParseIP(denormalizedip)) {
+			logging.Debugf("cluster wide IP %s belongs to a network opted out of reconciliation; skipping", clusterWideIPReservation.GetName())
+			continue
+		}
 
-		if !rl.isOrphanedIP(podRef, denormalizedip) {
+		if !rl.isOrphanedIP(ctx, podRef, "", denormalizedip) {
 			logging.Debugf("pod ref %s is not listed in the live pods list", podRef)
 			rl.orphanedClusterWideIPs = append(rl.orphanedClusterWideIPs, clusterWideIPReservation)
+		} else if !rl.isBackedByPool(clusterWideIPReservation, denormalizedip, now) {
+			logging.Debugf("no IP pool allocates the cluster wide IP %s to pod ref %s", clusterWideIPReservation.GetName(), podRef)
+			rl.orphanedReservations = append(rl.orphanedReservations, orphanedReservation{
+				reservation: clusterWideIPReservation,
+				ip:          denormalizedip,
+			})
 		}
 	}
 
 	return nil
 }
 
-func (rl ReconcileLooper) ReconcileOverlappingIPAddresses() error {
+// reservedIP returns the IP of a cluster wide reservation, whose name holds the normalized IP - prefixed by the network
+// name on named networks, e.g. the node slice ones. The IPs of the owner pod, when alive, tell where the IP starts;
+// otherwise, the longest suffix of the name making up a valid IP is used.
+func reservedIP(reservationName string, ownerPod podWrapper) string {
+	for ip := range ownerPod.ips {
+		normalizedIP := kubernetes.NormalizeIP(net.ParseIP(ip), kubernetes.UnnamedNetwork)
+		if reservationName == normalizedIP || strings.HasSuffix(reservationName, "-"+normalizedIP) {
+			return ip
+		}
+	}
+
+	for idx := -1; idx < len(reservationName); idx++ {
+		if idx >= 0 && reservationName[idx] != '-' {
+			continue
+		}
+		if candidate := strings.ReplaceAll(reservationName[idx+1:], "-", ":"); net.ParseIP(candidate) != nil {
+			return candidate
+		}
+	}
+	return strings.ReplaceAll(reservationName, "-", ":")
+}
+
+func (rl ReconcileLooper) ReconcileOverlappingIPAddresses(ctx context.Context) error {
+	_, err := rl.reconcileOverlappingIPAddresses(ctx)
+	return err
+}
+
+func (rl ReconcileLooper) reconcileOverlappingIPAddresses(ctx context.Context) ([]string, error) {
 	var failedReconciledClusterWideIPs []string
+	var reconciledClusterWideIPs []string
 
 	for _, overlappingIPStruct := range rl.orphanedClusterWideIPs {
-		if err := rl.k8sClient.DeleteOverlappingIP(&overlappingIPStruct); err != nil {
+		if rl.isChurnProtected(overlappingIPStruct) {
+			logging.Debugf("cluster wide IP %s belongs to an IP pool left untouched by the churn limit; skipping", overlappingIPStruct.GetName())
+			continue
+		}
+		if rl.dryRun {
+			rl.reportWouldDeleteReservation(&overlappingIPStruct, DeletionCausePoolOrphan)
+			reconciledClusterWideIPs = append(reconciledClusterWideIPs, overlappingIPStruct.GetName())
+			continue
+		}
+		if err := rl.k8sClient.DeleteOverlappingIP(ctx, &overlappingIPStruct); err != nil {
 			logging.Errorf("failed to remove cluster wide IP: %s", overlappingIPStruct.GetName())
 			failedReconciledClusterWideIPs = append(failedReconciledClusterWideIPs, overlappingIPStruct.GetName())
 			continue
 		}
 		logging.Verbosef("removed stale overlappingIP allocation [%s]", overlappingIPStruct.GetName())
+		metrics.ReconcilerDeletions.Inc(DeletionCausePoolOrphan, rl.clusterWideIPNetwork(overlappingIPStruct))
+		reconciledClusterWideIPs = append(reconciledClusterWideIPs, overlappingIPStruct.GetName())
 	}
 
 	if len(failedReconciledClusterWideIPs) != 0 {
-		return logging.Errorf("could not reconcile cluster wide IPs: %v", failedReconciledClusterWideIPs)
+		return reconciledClusterWideIPs, logging.Errorf("could not reconcile cluster wide IPs: %v", failedReconciledClusterWideIPs)
 	}
-	return nil
+	return reconciledClusterWideIPs, nil
 }
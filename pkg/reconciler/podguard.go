@@ -0,0 +1,40 @@
+package reconciler
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	// maxPodCountDropPercent is how much the pod count may shrink between two runs before the pod list is deemed
+	// incomplete
+	maxPodCountDropPercent = 50
+	// podCountDropMinPods is the pod count under which shrinking clusters are not suspicious
+	podCountDropMinPods = 10
+)
+
+// PodListGuard refuses implausible pod lists, as treating the allocations of pods missing from them as orphaned
+// would wipe healthy pools. It remembers the pod count across the runs sharing it, e.g. those of the IP control loop.
+type PodListGuard struct {
+	lock         sync.Mutex
+	lastPodCount int
+}
+
+// check validates the number of pods listed by the current run against the minimum expected, and against the count
+// of the previous run. A sudden drop is only accepted once confirmed by the next run.
+func (g *PodListGuard) check(podCount, minPods int) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	previousPodCount := g.lastPodCount
+	g.lastPodCount = podCount
+
+	if podCount < minPods {
+		return fmt.Errorf("the pod list holds %d pods, fewer than the %d expected", podCount, minPods)
+	}
+	if previousPodCount >= podCountDropMinPods && podCount*100 < previousPodCount*(100-maxPodCountDropPercent) {
+		return fmt.Errorf("the pod list holds %d pods, down from %d on the previous run; waiting for the next run to confirm it",
+			podCount, previousPodCount)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package reconciler
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pod list guard", func() {
+	var guard *PodListGuard
+
+	BeforeEach(func() {
+		guard = &PodListGuard{}
+	})
+
+	It("accepts an empty pod list when no minimum is set", func() {
+		Expect(guard.check(0, 0)).To(Succeed())
+	})
+
+	It("refuses a pod list shorter than the minimum", func() {
+		Expect(guard.check(2, 3)).To(MatchError("the pod list holds 2 pods, fewer than the 3 expected"))
+	})
+
+	It("refuses a sudden drop of the pod count until the next run confirms it", func() {
+		Expect(guard.check(100, 0)).To(Succeed())
+		Expect(guard.check(20, 0)).To(MatchError(ContainSubstring("down from 100")))
+		Expect(guard.check(20, 0)).To(Succeed())
+	})
+
+	It("accepts small clusters shrinking", func() {
+		Expect(guard.check(4, 0)).To(Succeed())
+		Expect(guard.check(0, 0)).To(Succeed())
+	})
+})
@@ -0,0 +1,162 @@
+package reconciler
+
+import (
+	"context"
+	"net"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage/kubernetes"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+const (
+	// OrphanedReservationDeletedReason is the reason of the events emitted on the deletion of the cluster wide
+	// reservations no IP pool allocation backs
+	OrphanedReservationDeletedReason = "OrphanedReservationDeleted"
+
+	// orphanedReservationGracePeriod spares the reservations of the allocations in flight: the cluster wide
+	// reservation of an IP is created before the IP pool allocation backing it.
+	orphanedReservationGracePeriod = 5 * time.Minute
+
+	// OrphanedAllocationGracePeriod spares the allocations of the pods being created: these may not be listed among
+	// the live pods, nor list their IPs, yet. The GracePeriodAnnotation of an IP pool overrides it.
+	OrphanedAllocationGracePeriod = 5 * time.Minute
+)
+
+// annotatedPool is implemented by the pools carrying annotations
+type annotatedPool interface {
+	Name() string
+	Annotations() map[string]string
+}
+
+// orphanTrackingPool is implemented by the pools recording when their allocations were first found orphaned
+type orphanTrackingPool interface {
+	OrphanedSince() map[string]time.Time
+	SetOrphanedSince(orphanedSince map[string]time.Time)
+}
+
+// orphanedReservation is a cluster wide reservation no IP pool allocation backs, along with its IP
+type orphanedReservation struct {
+	reservation whereaboutsv1alpha1.OverlappingRangeIPReservation
+	ip          string
+}
+
+// SetEventRecorder sets the recorder of the events emitted on the deletion of the orphaned cluster wide reservations.
+// No event is emitted when unset.
+func (rl *ReconcileLooper) SetEventRecorder(recorder record.EventRecorder) {
+	rl.recorder = recorder
+}
+
+// AllocationGracePeriod returns how long the orphaned allocations of the pool are spared, from the time they were first
+// found orphaned: the duration of its GracePeriodAnnotation, or else the default grace period
+func AllocationGracePeriod(pool storage.IPPool) time.Duration {
+	annotated, isAnnotated := pool.(annotatedPool)
+	if !isAnnotated {
+		return OrphanedAllocationGracePeriod
+	}
+	value, found := annotated.Annotations()[kubernetes.GracePeriodAnnotation]
+	if !found {
+		return OrphanedAllocationGracePeriod
+	}
+	gracePeriod, err := time.ParseDuration(value)
+	if err != nil || gracePeriod < 0 {
+		_ = logging.Errorf("invalid %s annotation %q of pool %s, using the default grace period of %s",
+			kubernetes.GracePeriodAnnotation, value, annotated.Name(), OrphanedAllocationGracePeriod)
+		return OrphanedAllocationGracePeriod
+	}
+	return gracePeriod
+}
+
+// orphanedSince returns the time the allocation was first found orphaned: as recorded in its pool, or else now. A dry
+// run recording nothing, it falls back to the time of the allocation instead.
+func (rl ReconcileLooper) orphanedSince(orphanedSince map[string]time.Time, allocation types.IPReservation, now time.Time) time.Time {
+	if since, found := orphanedSince[allocation.IP.String()]; found {
+		return since
+	}
+	if rl.dryRun {
+		return allocation.AllocatedAt
+	}
+	return now
+}
+
+// sameOrphanedSince tells whether the times the allocations were first found orphaned are those already recorded
+func sameOrphanedSince(recorded, orphanedSince map[string]time.Time) bool {
+	if len(recorded) != len(orphanedSince) {
+		return false
+	}
+	for ip, since := range orphanedSince {
+		if recordedSince, found := recorded[ip]; !found || !recordedSince.Equal(since) {
+			return false
+		}
+	}
+	return true
+}
+
+// indexPoolAllocations returns the pod references holding each IP in the IP pools
+func indexPoolAllocations(ipPools []storage.IPPool) map[string]map[string]struct{} {
+	owners := map[string]map[string]struct{}{}
+	for _, pool := range ipPools {
+		for _, allocation := range pool.Allocations() {
+			ip := allocation.IP.String()
+			if owners[ip] == nil {
+				owners[ip] = map[string]struct{}{}
+			}
+			owners[ip][allocation.PodRef] = struct{}{}
+		}
+	}
+	return owners
+}
+
+// isBackedByPool tells whether an IP pool allocates the IP of the cluster wide reservation to its pod. Those created
+// within the grace period are deemed backed, their allocation possibly being in flight.
+func (rl ReconcileLooper) isBackedByPool(reservation whereaboutsv1alpha1.OverlappingRangeIPReservation, ip string, now time.Time) bool {
+	if now.Sub(reservation.GetCreationTimestamp().Time) < orphanedReservationGracePeriod {
+		return true
+	}
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		// the IP cannot be told from the name: leave it be
+		return true
+	}
+	_, backed := rl.poolAllocationOwners[parsedIP.String()][reservation.Spec.PodRef]
+	return backed
+}
+
+// ReconcileOrphanedReservations deletes the cluster wide reservations no IP pool allocation backs, e.g. those left
+// behind by a node crashing in the middle of an allocation, emitting an event per deletion. It returns the names of
+// the deleted reservations.
+func (rl ReconcileLooper) ReconcileOrphanedReservations(ctx context.Context) ([]string, error) {
+	var deleted, failed []string
+	for _, orphan := range rl.orphanedReservations {
+		if rl.dryRun {
+			rl.reportWouldDeleteReservation(&orphan.reservation, DeletionCauseReservationOrphan)
+			deleted = append(deleted, orphan.reservation.GetName())
+			continue
+		}
+		if err := rl.k8sClient.DeleteOverlappingIP(ctx, &orphan.reservation); err != nil {
+			_ = logging.Errorf("failed to remove orphaned cluster wide IP %s: %v", orphan.reservation.GetName(), err)
+			failed = append(failed, orphan.reservation.GetName())
+			continue
+		}
+		logging.Verbosef("removed cluster wide IP reservation [%s] of pod %s, which no IP pool allocation backs",
+			orphan.reservation.GetName(), orphan.reservation.Spec.PodRef)
+		metrics.ReconcilerDeletions.Inc(DeletionCauseReservationOrphan, rl.networks.ClusterWideIPNetwork(orphan.reservation.GetName(), net.ParseIP(orphan.ip)))
+		if rl.recorder != nil {
+			rl.recorder.Eventf(&orphan.reservation, v1.EventTypeNormal, OrphanedReservationDeletedReason,
+				"deleted the reservation of IP %s to pod %s, which no IP pool allocation backs", orphan.ip, orphan.reservation.Spec.PodRef)
+		}
+		deleted = append(deleted, orphan.reservation.GetName())
+	}
+
+	if len(failed) != 0 {
+		return deleted, logging.Errorf("could not remove orphaned cluster wide IPs: %v", failed)
+	}
+	return deleted, nil
+}
@@ -8,11 +8,13 @@ import (
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 type podWrapper struct {
 	ips   map[string]void
 	phase v1.PodPhase
+	uid   types.UID
 }
 
 type void struct{}
@@ -25,6 +27,7 @@ func wrapPod(pod v1.Pod) *podWrapper {
 	return &podWrapper{
 		ips:   podIPSet,
 		phase: pod.Status.Phase,
+		uid:   pod.UID,
 	}
 }
 
@@ -0,0 +1,308 @@
+package etcd3
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// client talks to the JSON gateway of the etcd v3 API, which the etcd members serve on their client URLs: the plugin
+// needs no gRPC client. The keys and values travel base64 encoded, as the []byte fields of the messages.
+type client struct {
+	endpoints  []string
+	httpClient *http.Client
+	username   string
+	password   string
+
+	lock  sync.Mutex
+	token string
+}
+
+// keyValue is a key of etcd, along with the revisions guarding its updates
+type keyValue struct {
+	Key            []byte `json:"key"`
+	Value          []byte `json:"value"`
+	CreateRevision int64  `json:"create_revision,omitempty,string"`
+	ModRevision    int64  `json:"mod_revision,omitempty,string"`
+}
+
+type rangeRequest struct {
+	Key []byte `json:"key"`
+}
+
+type rangeResponse struct {
+	Kvs []keyValue `json:"kvs"`
+}
+
+// compare is a condition of a transaction, on the revision of a key: a revision of 0 matches a key missing
+type compare struct {
+	Key            []byte `json:"key"`
+	Target         string `json:"target"`
+	Result         string `json:"result"`
+	CreateRevision int64  `json:"create_revision,omitempty,string"`
+	ModRevision    int64  `json:"mod_revision,omitempty,string"`
+}
+
+type putRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	Lease int64  `json:"lease,omitempty,string"`
+}
+
+type deleteRangeRequest struct {
+	Key []byte `json:"key"`
+}
+
+type requestOp struct {
+	RequestPut         *putRequest         `json:"request_put,omitempty"`
+	RequestDeleteRange *deleteRangeRequest `json:"request_delete_range,omitempty"`
+}
+
+type txnRequest struct {
+	Compare []compare   `json:"compare"`
+	Success []requestOp `json:"success"`
+}
+
+type txnResponse struct {
+	Succeeded bool `json:"succeeded"`
+}
+
+type leaseGrantRequest struct {
+	TTL int64 `json:"TTL,string"`
+}
+
+type leaseGrantResponse struct {
+	ID int64 `json:"ID,string"`
+}
+
+type leaseRevokeRequest struct {
+	ID int64 `json:"ID,string"`
+}
+
+type leaseKeepAliveRequest struct {
+	ID int64 `json:"ID,string"`
+}
+
+// leaseKeepAliveResponse is the message of the keepalive stream the gateway answers with, without TTL once the lease
+// expired
+type leaseKeepAliveResponse struct {
+	Result struct {
+		TTL int64 `json:"TTL,omitempty,string"`
+	} `json:"result"`
+}
+
+type authenticateRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type authenticateResponse struct {
+	Token string `json:"token"`
+}
+
+// gatewayError is the error the gateway answers a failed request with
+type gatewayError struct {
+	Message string `json:"message"`
+	Error   string `json:"error"`
+}
+
+func newClient(conf types.Etcd3Config) (*client, error) {
+	if len(conf.Endpoints) == 0 {
+		return nil, fmt.Errorf("the etcd3 datastore requires the endpoints of the etcd members")
+	}
+	tlsConfig, err := clientTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]string, 0, len(conf.Endpoints))
+	for _, endpoint := range conf.Endpoints {
+		if !strings.Contains(endpoint, "://") {
+			scheme := "http://"
+			if tlsConfig != nil {
+				scheme = "https://"
+			}
+			endpoint = scheme + endpoint
+		}
+		endpoints = append(endpoints, strings.TrimSuffix(endpoint, "/"))
+	}
+	return &client{
+		endpoints:  endpoints,
+		httpClient: &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment}},
+		username:   conf.Username,
+		password:   conf.Password,
+	}, nil
+}
+
+// clientTLSConfig returns the TLS configuration of the client certificate and CA bundle of the configuration, nil when
+// it sets neither
+func clientTLSConfig(conf types.Etcd3Config) (*tls.Config, error) {
+	if conf.CertFile == "" && conf.KeyFile == "" && conf.CACertFile == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if conf.CertFile != "" || conf.KeyFile != "" {
+		if conf.CertFile == "" || conf.KeyFile == "" {
+			return nil, fmt.Errorf("the etcd3 client certificate requires both cert_file and key_file")
+		}
+		certificate, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the etcd3 client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	if conf.CACertFile != "" {
+		caBundle, err := os.ReadFile(conf.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the etcd3 CA bundle: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificate found in the etcd3 CA bundle %s", conf.CACertFile)
+		}
+	}
+	return tlsConfig, nil
+}
+
+func (c *client) get(ctx context.Context, key string) (*keyValue, error) {
+	var response rangeResponse
+	if err := c.call(ctx, "/v3/kv/range", rangeRequest{Key: []byte(key)}, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Kvs) == 0 {
+		return nil, nil
+	}
+	return &response.Kvs[0], nil
+}
+
+// putIf writes the key provided the condition on its revision holds, telling whether it did
+func (c *client) putIf(ctx context.Context, condition compare, key string, value []byte, lease int64) (bool, error) {
+	return c.txn(ctx, condition, requestOp{RequestPut: &putRequest{Key: []byte(key), Value: value, Lease: lease}})
+}
+
+// deleteIf deletes the key provided the condition on its revision holds, telling whether it did
+func (c *client) deleteIf(ctx context.Context, condition compare, key string) (bool, error) {
+	return c.txn(ctx, condition, requestOp{RequestDeleteRange: &deleteRangeRequest{Key: []byte(key)}})
+}
+
+func (c *client) txn(ctx context.Context, condition compare, op requestOp) (bool, error) {
+	var response txnResponse
+	if err := c.call(ctx, "/v3/kv/txn", txnRequest{Compare: []compare{condition}, Success: []requestOp{op}}, &response); err != nil {
+		return false, err
+	}
+	return response.Succeeded, nil
+}
+
+func (c *client) grantLease(ctx context.Context, ttl int64) (int64, error) {
+	var response leaseGrantResponse
+	if err := c.call(ctx, "/v3/lease/grant", leaseGrantRequest{TTL: ttl}, &response); err != nil {
+		return 0, err
+	}
+	return response.ID, nil
+}
+
+func (c *client) revokeLease(ctx context.Context, id int64) error {
+	return c.call(ctx, "/v3/lease/revoke", leaseRevokeRequest{ID: id}, nil)
+}
+
+// keepAliveLease renews the lease, returning its time to live in seconds, 0 when it expired already
+func (c *client) keepAliveLease(ctx context.Context, id int64) (int64, error) {
+	var response leaseKeepAliveResponse
+	if err := c.call(ctx, "/v3/lease/keepalive", leaseKeepAliveRequest{ID: id}, &response); err != nil {
+		return 0, err
+	}
+	return response.Result.TTL, nil
+}
+
+func (c *client) status(ctx context.Context) error {
+	return c.call(ctx, "/v3/maintenance/status", struct{}{}, nil)
+}
+
+// call posts the request to the first endpoint reachable, authenticating first when the configuration sets a user
+func (c *client) call(ctx context.Context, path string, request, response interface{}) error {
+	token, err := c.authToken(ctx)
+	if err != nil {
+		return err
+	}
+	return c.post(ctx, path, token, request, response)
+}
+
+func (c *client) authToken(ctx context.Context) (string, error) {
+	if c.username == "" {
+		return "", nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.token != "" {
+		return c.token, nil
+	}
+	var response authenticateResponse
+	if err := c.post(ctx, "/v3/auth/authenticate", "", authenticateRequest{Name: c.username, Password: c.password}, &response); err != nil {
+		return "", fmt.Errorf("failed to authenticate to etcd as %s: %w", c.username, err)
+	}
+	c.token = response.Token
+	return c.token, nil
+}
+
+func (c *client) post(ctx context.Context, path, token string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, endpoint := range c.endpoints {
+		httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		httpRequest.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			httpRequest.Header.Set("Authorization", token)
+		}
+		httpResponse, err := c.httpClient.Do(httpRequest)
+		if err != nil {
+			// the next member may be reachable
+			if _, ok := err.(net.Error); ok && ctx.Err() == nil {
+				lastErr = err
+				continue
+			}
+			return err
+		}
+		return decodeResponse(httpResponse, response)
+	}
+	return fmt.Errorf("no etcd endpoint reachable: %w", lastErr)
+}
+
+func decodeResponse(httpResponse *http.Response, response interface{}) error {
+	defer httpResponse.Body.Close()
+	data, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return err
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		var gatewayErr gatewayError
+		if json.Unmarshal(data, &gatewayErr) == nil && (gatewayErr.Message != "" || gatewayErr.Error != "") {
+			message := gatewayErr.Message
+			if message == "" {
+				message = gatewayErr.Error
+			}
+			return fmt.Errorf("etcd request failed with status %d: %s", httpResponse.StatusCode, message)
+		}
+		return fmt.Errorf("etcd request failed with status %d", httpResponse.StatusCode)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(data, response)
+}
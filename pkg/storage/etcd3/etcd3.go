@@ -0,0 +1,319 @@
+// Package etcd3 is the etcd3 datastore: it keeps the IP pools and the cluster wide reservations of the IPs in an etcd
+// cluster of its own, rather than in the custom resources of the Kubernetes API, sparing the API server the writes of
+// the allocations. The binaries import it for its registration in the storage registry.
+package etcd3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// Datastore is the name the datastore parameter selects the etcd3 datastore by
+const Datastore = "etcd3"
+
+const (
+	defaultPrefix = "/whereabouts"
+	// lockTTL is the time to live, in seconds, of the lock of an invocation killed before releasing it
+	lockTTL         = 30
+	lockRetryPeriod = 100 * time.Millisecond
+)
+
+// lockKeepAlivePeriod is the period the lease of the lock is renewed at while held, for the lock to outlive lockTTL
+var lockKeepAlivePeriod = lockTTL * time.Second / 3
+
+func init() {
+	storage.Register(Datastore, func(containerID, ifName string, ipamConf types.IPAMConfig) (storage.Store, error) {
+		return NewStore(ipamConf.Etcd3)
+	})
+}
+
+// Store keeps the IP pools and the cluster wide reservations under the prefix of the configuration:
+//
+//   - <prefix>/ippools/<pool>: the allocations of the IP pool, named like the IPPool resources of the kubernetes
+//     datastore, as a JSON list;
+//   - <prefix>/reservations/<IP>: the cluster wide reservation of the IP, named like the OverlappingRangeIPReservation
+//     resources, as JSON;
+//   - <prefix>/lock: the lock serializing the invocations, held under a lease renewed until unlocked, and expiring
+//     lockTTL seconds after the invocation holding it was killed.
+//
+// The pool updates are conditioned on the revision of the pool read, as are the reservations on their absence.
+type Store struct {
+	client *client
+	prefix string
+	// lease is the lease the lock is held under, 0 when unlocked
+	lease int64
+	// stopKeepAlive stops renewing the lease, keptAlive being closed once it no longer is
+	stopKeepAlive context.CancelFunc
+	keptAlive     chan struct{}
+}
+
+var (
+	_ storage.Store  = &Store{}
+	_ storage.Locker = &Store{}
+)
+
+// NewStore returns the store of the etcd cluster of the configuration
+func NewStore(conf types.Etcd3Config) (*Store, error) {
+	client, err := newClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimSuffix(conf.Prefix, "/")
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	return &Store{client: client, prefix: prefix}, nil
+}
+
+// conflictError is returned by the pool updates and the reservations losing the race to another invocation
+type conflictError struct {
+	key string
+}
+
+func (e conflictError) Error() string {
+	return fmt.Sprintf("the etcd key %s was updated concurrently", e.key)
+}
+
+func (conflictError) Temporary() bool {
+	return true
+}
+
+// GetIPPool returns the IP pool of the range, empty when the etcd cluster holds no allocation of it yet
+func (s *Store) GetIPPool(ctx context.Context, poolIdentifier storage.PoolIdentifier) (storage.IPPool, error) {
+	key := s.prefix + "/ippools/" + poolName(poolIdentifier)
+	kv, err := s.client.get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the IP pool %s: %w", key, err)
+	}
+	pool := &ipPool{store: s, key: key}
+	if kv == nil {
+		return pool, nil
+	}
+	if err := json.Unmarshal(kv.Value, &pool.allocations); err != nil {
+		return nil, fmt.Errorf("failed to decode the IP pool %s: %w", key, err)
+	}
+	pool.modRevision = kv.ModRevision
+	return pool, nil
+}
+
+// GetOverlappingRangeStore returns the store of the cluster wide reservations
+func (s *Store) GetOverlappingRangeStore() (storage.OverlappingRangeStore, error) {
+	return s, nil
+}
+
+// Status verifies an etcd member is reachable
+func (s *Store) Status(ctx context.Context) error {
+	if err := s.client.status(ctx); err != nil {
+		return fmt.Errorf("etcd3 datastore unreachable: %w", err)
+	}
+	return nil
+}
+
+// Close releases the idle connections to the etcd members
+func (s *Store) Close() error {
+	s.client.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// Lock blocks until the invocation holds the lock of the prefix, or the context is done
+func (s *Store) Lock(ctx context.Context) error {
+	lease, err := s.client.grantLease(ctx, lockTTL)
+	if err != nil {
+		return err
+	}
+	key := s.prefix + "/lock"
+	for {
+		locked, err := s.client.putIf(ctx, compare{Key: []byte(key), Target: "CREATE", Result: "EQUAL"}, key, nil, lease)
+		if err != nil {
+			s.revoke(lease)
+			return err
+		}
+		if locked {
+			s.lease = lease
+			keepAliveCtx, stopKeepAlive := context.WithCancel(context.Background())
+			s.stopKeepAlive, s.keptAlive = stopKeepAlive, make(chan struct{})
+			go s.keepAlive(keepAliveCtx, lease, s.keptAlive)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.revoke(lease)
+			return ctx.Err()
+		case <-time.After(lockRetryPeriod):
+		}
+	}
+}
+
+// Unlock releases the lock, revoking its lease
+func (s *Store) Unlock(ctx context.Context) error {
+	if s.lease == 0 {
+		return nil
+	}
+	s.stopKeepAlive()
+	<-s.keptAlive
+	err := s.client.revokeLease(ctx, s.lease)
+	s.lease = 0
+	return err
+}
+
+// keepAlive renews the lease of the lock every lockKeepAlivePeriod until the context is done, closing keptAlive then.
+// It gives up once the lease expired, the lock being lost.
+func (s *Store) keepAlive(ctx context.Context, lease int64, keptAlive chan struct{}) {
+	defer close(keptAlive)
+	ticker := time.NewTicker(lockKeepAlivePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		requestCtx, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+		ttl, err := s.client.keepAliveLease(requestCtx, lease)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				_ = logging.Errorf("failed to renew the etcd lease %d of the lock: %v", lease, err)
+			}
+			continue
+		}
+		if ttl <= 0 {
+			_ = logging.Errorf("the etcd lease %d of the lock expired", lease)
+			return
+		}
+	}
+}
+
+func (s *Store) revoke(lease int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), storage.RequestTimeout)
+	defer cancel()
+	if err := s.client.revokeLease(ctx, lease); err != nil {
+		_ = logging.Errorf("failed to revoke the etcd lease %d: %v", lease, err)
+	}
+}
+
+// GetOverlappingRangeIPReservation returns the cluster wide reservation of the IP, nil when it is not reserved
+func (s *Store) GetOverlappingRangeIPReservation(ctx context.Context, ip net.IP, podRef, networkName string) (*whereaboutsv1alpha1.OverlappingRangeIPReservation, error) {
+	name := reservationName(ip, networkName)
+	kv, err := s.client.get(ctx, s.prefix+"/reservations/"+name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the reservation of IP %s: %w", ip, err)
+	}
+	if kv == nil {
+		return nil, nil
+	}
+	reservation := &whereaboutsv1alpha1.OverlappingRangeIPReservation{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if err := json.Unmarshal(kv.Value, &reservation.Spec); err != nil {
+		return nil, fmt.Errorf("failed to decode the reservation of IP %s: %w", ip, err)
+	}
+	return reservation, nil
+}
+
+// UpdateOverlappingRangeAllocation reserves the IP cluster wide, failing when it is reserved already, or releases it
+func (s *Store) UpdateOverlappingRangeAllocation(ctx context.Context, mode int, ip net.IP, containerID, podRef, ifName, networkName, ipRange string) error {
+	key := s.prefix + "/reservations/" + reservationName(ip, networkName)
+	switch mode {
+	case types.Allocate:
+		value, err := json.Marshal(whereaboutsv1alpha1.OverlappingRangeIPReservationSpec{
+			ContainerID: containerID,
+			PodRef:      podRef,
+			IfName:      ifName,
+		})
+		if err != nil {
+			return err
+		}
+		reserved, err := s.client.putIf(ctx, compare{Key: []byte(key), Target: "CREATE", Result: "EQUAL"}, key, value, 0)
+		if err != nil {
+			return fmt.Errorf("failed to reserve IP %s: %w", ip, err)
+		}
+		if !reserved {
+			return conflictError{key: key}
+		}
+	case types.Deallocate:
+		// the reservation is only released by the pod holding it
+		kv, err := s.client.get(ctx, key)
+		if err != nil || kv == nil {
+			return err
+		}
+		var spec whereaboutsv1alpha1.OverlappingRangeIPReservationSpec
+		if err := json.Unmarshal(kv.Value, &spec); err != nil {
+			return fmt.Errorf("failed to decode the reservation of IP %s: %w", ip, err)
+		}
+		if spec.PodRef != podRef {
+			logging.Debugf("the reservation of IP %s is held by pod %s, not releasing it", ip, spec.PodRef)
+			return nil
+		}
+		if _, err := s.client.deleteIf(ctx, compare{Key: []byte(key), Target: "MOD", Result: "EQUAL", ModRevision: kv.ModRevision}, key); err != nil {
+			return fmt.Errorf("failed to release the reservation of IP %s: %w", ip, err)
+		}
+	default:
+		return fmt.Errorf("unknown mode %d", mode)
+	}
+	return nil
+}
+
+// ipPool is an IP pool as read from etcd, at its revision
+type ipPool struct {
+	store       *Store
+	key         string
+	modRevision int64
+	allocations []types.IPReservation
+}
+
+// Allocations returns the IPs of the pool allocated when it was read
+func (p *ipPool) Allocations() []types.IPReservation {
+	return p.allocations
+}
+
+// Update replaces the allocations of the pool, failing with a Temporary error when it was updated since it was read
+func (p *ipPool) Update(ctx context.Context, reservations []types.IPReservation) error {
+	value, err := json.Marshal(reservations)
+	if err != nil {
+		return err
+	}
+	updated, err := p.store.client.putIf(ctx, compare{Key: []byte(p.key), Target: "MOD", Result: "EQUAL", ModRevision: p.modRevision}, p.key, value, 0)
+	if err != nil {
+		return fmt.Errorf("failed to update the IP pool %s: %w", p.key, err)
+	}
+	if !updated {
+		return conflictError{key: p.key}
+	}
+	return nil
+}
+
+// poolName names the pool of the range like the IPPool resources of the kubernetes datastore
+func poolName(poolIdentifier storage.PoolIdentifier) string {
+	name := normalize(poolIdentifier.IpRange)
+	if poolIdentifier.NetworkName != "" {
+		name = poolIdentifier.NetworkName + "-" + name
+	}
+	return name
+}
+
+// reservationName names the reservation of the IP like the OverlappingRangeIPReservation resources of the kubernetes
+// datastore
+func reservationName(ip net.IP, networkName string) string {
+	name := normalize(ip.String())
+	if networkName != "" {
+		name = networkName + "-" + name
+	}
+	return name
+}
+
+func normalize(value string) string {
+	if strings.HasSuffix(value, ":") {
+		value += "0"
+	}
+	return strings.NewReplacer(":", "-", "/", "-").Replace(value)
+}
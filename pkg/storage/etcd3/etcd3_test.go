@@ -0,0 +1,300 @@
+package etcd3
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// fakeGateway serves the subset of the JSON gateway of the etcd v3 API the store uses, from memory
+type fakeGateway struct {
+	lock     sync.Mutex
+	revision int64
+	kvs      map[string]keyValue
+	leases   map[int64][]string
+	// keepAlives counts the renewals of each lease
+	keepAlives map[int64]int
+	token      string
+}
+
+func newFakeGateway() *fakeGateway {
+	return &fakeGateway{kvs: map[string]keyValue{}, leases: map[int64][]string{}, keepAlives: map[int64]int{}, token: "secret-token"}
+}
+
+func (g *fakeGateway) keptAlive(lease int64) int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.keepAlives[lease]
+}
+
+func (g *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if r.URL.Path == "/v3/auth/authenticate" {
+		var request authenticateRequest
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		if request.Name != "whereabouts" || request.Password != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": "authentication failed", "code": 16}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(authenticateResponse{Token: g.token})
+		return
+	}
+	if r.Header.Get("Authorization") != g.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"message": "invalid auth token", "code": 16}`))
+		return
+	}
+
+	var response interface{}
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		var request rangeRequest
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		rangeResponse := rangeResponse{}
+		if kv, found := g.kvs[string(request.Key)]; found {
+			rangeResponse.Kvs = []keyValue{kv}
+		}
+		response = rangeResponse
+	case "/v3/kv/txn":
+		var request txnRequest
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		succeeded := true
+		for _, condition := range request.Compare {
+			kv := g.kvs[string(condition.Key)]
+			switch condition.Target {
+			case "CREATE":
+				succeeded = succeeded && kv.CreateRevision == condition.CreateRevision
+			case "MOD":
+				succeeded = succeeded && kv.ModRevision == condition.ModRevision
+			}
+		}
+		if succeeded {
+			for _, op := range request.Success {
+				g.revision++
+				if put := op.RequestPut; put != nil {
+					kv := g.kvs[string(put.Key)]
+					if kv.CreateRevision == 0 {
+						kv.CreateRevision = g.revision
+					}
+					kv.Key, kv.Value, kv.ModRevision = put.Key, put.Value, g.revision
+					g.kvs[string(put.Key)] = kv
+					if put.Lease != 0 {
+						g.leases[put.Lease] = append(g.leases[put.Lease], string(put.Key))
+					}
+				}
+				if deleteRange := op.RequestDeleteRange; deleteRange != nil {
+					delete(g.kvs, string(deleteRange.Key))
+				}
+			}
+		}
+		response = txnResponse{Succeeded: succeeded}
+	case "/v3/lease/grant":
+		g.revision++
+		g.leases[g.revision] = nil
+		response = leaseGrantResponse{ID: g.revision}
+	case "/v3/lease/keepalive":
+		var request leaseKeepAliveRequest
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		keepAliveResponse := leaseKeepAliveResponse{}
+		if _, found := g.leases[request.ID]; found {
+			g.keepAlives[request.ID]++
+			keepAliveResponse.Result.TTL = lockTTL
+		}
+		response = keepAliveResponse
+	case "/v3/lease/revoke":
+		var request leaseRevokeRequest
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		for _, key := range g.leases[request.ID] {
+			delete(g.kvs, key)
+		}
+		delete(g.leases, request.ID)
+		response = struct{}{}
+	case "/v3/maintenance/status":
+		response = struct{}{}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// newTestStore returns a store of the fake gateway, served over TLS and authenticating its clients
+func newTestStore(t *testing.T, gateway *fakeGateway) *Store {
+	server := httptest.NewTLSServer(gateway)
+	t.Cleanup(server.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caBundle, 0600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	store, err := NewStore(types.Etcd3Config{
+		Endpoints:  []string{"127.0.0.1:1", server.URL},
+		Username:   "whereabouts",
+		Password:   "password",
+		CACertFile: caFile,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return store
+}
+
+func TestIPManagement(t *testing.T) {
+	gateway := newFakeGateway()
+	ipamConf := types.IPAMConfig{
+		IPRanges:          []types.RangeConfiguration{{Range: "10.0.0.0/24"}},
+		OverlappingRanges: true,
+		NetworkName:       "net1",
+		PodNamespace:      "default",
+		PodName:           "pod-a",
+	}
+
+	ips, err := storage.IPManagement(context.TODO(), types.Allocate, ipamConf, newTestStore(t, gateway), "container-a", "net1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ips) != 1 || !ips[0].IP.Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("Expected IP 10.0.0.1, got %v", ips)
+	}
+	if _, found := gateway.kvs["/whereabouts/reservations/net1-10.0.0.1"]; !found {
+		t.Errorf("Expected IP 10.0.0.1 to be reserved cluster wide")
+	}
+	if _, found := gateway.kvs["/whereabouts/lock"]; found {
+		t.Errorf("Expected the lock to be released")
+	}
+
+	ipamConf.PodName = "pod-b"
+	ips, err = storage.IPManagement(context.TODO(), types.Allocate, ipamConf, newTestStore(t, gateway), "container-b", "net1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ips) != 1 || !ips[0].IP.Equal(net.ParseIP("10.0.0.2")) {
+		t.Fatalf("Expected IP 10.0.0.2, got %v", ips)
+	}
+
+	ipamConf.PodName = "pod-a"
+	if _, err := storage.IPManagement(context.TODO(), types.Deallocate, ipamConf, newTestStore(t, gateway), "container-a", "net1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, found := gateway.kvs["/whereabouts/reservations/net1-10.0.0.1"]; found {
+		t.Errorf("Expected the reservation of IP 10.0.0.1 to be released")
+	}
+	pool, err := newTestStore(t, gateway).GetIPPool(context.TODO(), storage.PoolIdentifier{IpRange: "10.0.0.0/24", NetworkName: "net1"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if allocations := pool.Allocations(); len(allocations) != 1 || allocations[0].PodRef != "default/pod-b" {
+		t.Errorf("Expected only the allocation of pod default/pod-b to be left, got %v", allocations)
+	}
+}
+
+func TestConcurrentUpdate(t *testing.T) {
+	store := newTestStore(t, newFakeGateway())
+	poolIdentifier := storage.PoolIdentifier{IpRange: "10.0.0.0/24"}
+	first, err := store.GetIPPool(context.TODO(), poolIdentifier)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	second, err := store.GetIPPool(context.TODO(), poolIdentifier)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	reservation := types.IPReservation{IP: net.ParseIP("10.0.0.1"), ContainerID: "container-a", PodRef: "default/pod-a"}
+	if err := first.Update(context.TODO(), []types.IPReservation{reservation}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	err = second.Update(context.TODO(), []types.IPReservation{reservation})
+	if temporary, ok := err.(storage.Temporary); !ok || !temporary.Temporary() {
+		t.Errorf("Expected a temporary error updating a pool updated since read, got %v", err)
+	}
+
+	if err := store.UpdateOverlappingRangeAllocation(context.TODO(), types.Allocate, reservation.IP, "container-a", "default/pod-a", "net1", "", "10.0.0.0/24"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := store.UpdateOverlappingRangeAllocation(context.TODO(), types.Allocate, reservation.IP, "container-b", "default/pod-b", "net1", "", "10.0.0.0/24"); err == nil {
+		t.Errorf("Expected reserving a reserved IP to fail")
+	}
+}
+
+func TestLock(t *testing.T) {
+	gateway := newFakeGateway()
+	holder := newTestStore(t, gateway)
+	if err := holder.Lock(context.TODO()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 3*lockRetryPeriod)
+	defer cancel()
+	if err := newTestStore(t, gateway).Lock(ctx); err == nil {
+		t.Fatalf("Expected the lock to be held")
+	}
+
+	if err := holder.Unlock(context.TODO()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	next := newTestStore(t, gateway)
+	if err := next.Lock(ctx); err != nil {
+		t.Fatalf("Expected the lock to be released, got %v", err)
+	}
+	if err := next.Unlock(context.TODO()); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestLockKeepAlive(t *testing.T) {
+	defer func(period time.Duration) { lockKeepAlivePeriod = period }(lockKeepAlivePeriod)
+	lockKeepAlivePeriod = 10 * time.Millisecond
+
+	gateway := newFakeGateway()
+	store := newTestStore(t, gateway)
+	if err := store.Lock(context.TODO()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lease := store.lease
+	deadline := time.Now().Add(5 * time.Second)
+	for gateway.keptAlive(lease) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the lease of the lock to be renewed while held")
+		}
+		time.Sleep(lockKeepAlivePeriod)
+	}
+
+	if err := store.Unlock(context.TODO()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	keptAlive := gateway.keptAlive(lease)
+	time.Sleep(5 * lockKeepAlivePeriod)
+	if renewals := gateway.keptAlive(lease); renewals != keptAlive {
+		t.Errorf("Expected the lease to no longer be renewed once unlocked, got %d renewals after %d", renewals, keptAlive)
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	for name, conf := range map[string]types.Etcd3Config{
+		"without endpoints":        {},
+		"without client key":       {Endpoints: []string{"etcd:2379"}, CertFile: "client.crt"},
+		"with a missing CA bundle": {Endpoints: []string{"etcd:2379"}, CACertFile: filepath.Join(t.TempDir(), "ca.crt")},
+	} {
+		if _, err := NewStore(conf); err == nil {
+			t.Errorf("Expected an error %s, got none", name)
+		}
+	}
+}
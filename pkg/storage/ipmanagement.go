@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/allocate"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// IPManagement allocates, or releases, the IPs of the interface of the container in the IP pools of the ranges of the
+// configuration, through any Store: it is the allocation path of the datastores registered besides the
+// DefaultDatastore. It covers the ranges and their excludes, the requested IPs of the CNI args and the cluster wide
+// reservations of the networks with enable_overlapping_ranges set; the kubernetes datastore manages its IPs on its
+// own, along with the features built on the Kubernetes API, e.g. the node slices, the pool shards and the leader
+// election.
+func IPManagement(ctx context.Context, mode int, ipamConf types.IPAMConfig, store Store, containerID, ifName string) ([]net.IPNet, error) {
+	logger := logging.FromContext(ctx)
+	switch mode {
+	case types.Allocate, types.Deallocate:
+	default:
+		return nil, fmt.Errorf("got an unknown mode passed to IPManagement: %v", mode)
+	}
+	if mode == types.Allocate && ipamConf.AllocationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(ipamConf.AllocationTimeout)*time.Millisecond)
+		defer cancel()
+	}
+
+	requestCtx, requestCancel := context.WithTimeout(ctx, RequestTimeout)
+	defer requestCancel()
+	if err := store.Status(requestCtx); err != nil {
+		logger.Errorf("IPAM connectivity error: %v", err)
+		return nil, err
+	}
+	if mode == types.Allocate {
+		if err := allocate.CheckRequestedIPs(ipamConf.IPRanges, ipamConf.RequestedIPs); err != nil {
+			return nil, err
+		}
+	}
+	var overlappingRangeStore OverlappingRangeStore
+	if ipamConf.OverlappingRanges {
+		var err error
+		if overlappingRangeStore, err = store.GetOverlappingRangeStore(); err != nil {
+			return nil, err
+		}
+	}
+
+	if locker, ok := store.(Locker); ok {
+		if err := locker.Lock(ctx); err != nil {
+			return nil, fmt.Errorf("failed to lock the datastore: %w", err)
+		}
+		defer func() {
+			unlockCtx, cancel := context.WithTimeout(context.Background(), RequestTimeout)
+			defer cancel()
+			if err := locker.Unlock(unlockCtx); err != nil {
+				logger.Errorf("failed to unlock the datastore: %v", err)
+			}
+		}()
+	}
+
+	manager := ipManager{
+		store:                 store,
+		overlappingRangeStore: overlappingRangeStore,
+		ipamConf:              ipamConf,
+		containerID:           containerID,
+		ifName:                ifName,
+	}
+	var newips []net.IPNet
+	for _, ipRange := range ipamConf.IPRanges {
+		if mode == types.Deallocate {
+			if err := manager.deallocate(ctx, ipRange); err != nil {
+				logger.Errorf("Error deallocating IP from range %s: %v", ipRange.Range, err)
+				return nil, err
+			}
+			continue
+		}
+		newip, err := manager.allocate(ctx, ipRange)
+		if err != nil {
+			logger.Errorf("Error assigning IP from range %s: %v", ipRange.Range, err)
+			return newips, err
+		}
+		newips = append(newips, newip)
+	}
+	return newips, nil
+}
+
+// CheckAllocations verifies the IP pools of the ranges of the configuration still hold the allocations of the interface
+// of the container, through any Store
+func CheckAllocations(ctx context.Context, ipamConf types.IPAMConfig, store Store, containerID, ifName string) error {
+	for _, ipRange := range ipamConf.IPRanges {
+		requestCtx, cancel := context.WithTimeout(ctx, RequestTimeout)
+		pool, err := store.GetIPPool(requestCtx, poolIdentifier(ipamConf, ipRange))
+		cancel()
+		if err != nil {
+			return err
+		}
+		if !holdsAllocation(pool.Allocations(), containerID, ifName) {
+			return fmt.Errorf("no IP of range %s is allocated to container %s, interface %s", ipRange.Range, containerID, ifName)
+		}
+	}
+	return nil
+}
+
+// ipManager manages the IPs of an interface in the IP pools of a Store
+type ipManager struct {
+	store                 Store
+	overlappingRangeStore OverlappingRangeStore
+	ipamConf              types.IPAMConfig
+	containerID           string
+	ifName                string
+}
+
+// allocate assigns an IP of the range, retrying on the IP pool read anew when it was updated concurrently, and on
+// another IP when the IP is reserved cluster wide by a pod of an overlapping range. The cluster wide reservation is
+// written before the IP pool, and rolled back when the IP pool update fails.
+func (m ipManager) allocate(ctx context.Context, ipRange types.RangeConfiguration) (net.IPNet, error) {
+	logger := logging.FromContext(ctx)
+	podRef := m.ipamConf.GetPodRef()
+	var podUID string
+	if m.ipamConf.RequirePodUIDMatch {
+		podUID = m.ipamConf.PodUID
+	}
+	requestedIP := allocate.RequestedIP(ipRange, m.ipamConf.RequestedIPs)
+	backoff := m.retryBackoff()
+	// the IPs reserved cluster wide for the pods of the overlapping ranges, skipped by the assignment
+	var reservedElsewhere []types.IPReservation
+
+	for attempt := 0; attempt < DatastoreRetries; attempt++ {
+		requestCtx, cancel := context.WithTimeout(ctx, RequestTimeout)
+		pool, err := m.store.GetIPPool(requestCtx, poolIdentifier(m.ipamConf, ipRange))
+		if err != nil {
+			cancel()
+			return net.IPNet{}, err
+		}
+		reservelist := append(append([]types.IPReservation{}, pool.Allocations()...), reservedElsewhere...)
+		// the datastores record no release time: the lru ranges are allocated sequentially
+		newip, reservelist, err := allocate.AssignIP(ipRange, reservelist, nil, m.containerID, podRef, podUID, m.ifName, "", requestedIP, nil)
+		if err != nil {
+			cancel()
+			return net.IPNet{}, err
+		}
+		if err := allocate.CheckForeignRanges(newip.IP, m.ipamConf.ForeignRanges); err != nil {
+			cancel()
+			return net.IPNet{}, err
+		}
+		if holdsIP(reservedElsewhere, newip.IP) {
+			// the IP pool already allocates the IP to the pod
+			cancel()
+			return net.IPNet{}, fmt.Errorf("IP %s allocated to pod %s is reserved cluster wide for another pod", newip.IP, podRef)
+		}
+
+		reserved := false
+		if m.overlappingRangeStore != nil {
+			reservation, err := m.overlappingRangeStore.GetOverlappingRangeIPReservation(requestCtx, newip.IP, podRef, m.ipamConf.NetworkName)
+			if err != nil {
+				cancel()
+				return net.IPNet{}, err
+			}
+			if reservation != nil && reservation.Spec.PodRef != podRef {
+				cancel()
+				logger.Debugf("IP %s is reserved cluster wide for pod %s, trying another one", newip.IP, reservation.Spec.PodRef)
+				reservedElsewhere = append(reservedElsewhere, types.IPReservation{
+					IP: newip.IP, ContainerID: reservation.Spec.ContainerID, PodRef: reservation.Spec.PodRef, IfName: reservation.Spec.IfName,
+				})
+				continue
+			}
+			if reservation == nil {
+				if err := m.overlappingRangeStore.UpdateOverlappingRangeAllocation(requestCtx, types.Allocate, newip.IP,
+					m.containerID, podRef, m.ifName, m.ipamConf.NetworkName, ipRange.Range); err != nil {
+					cancel()
+					logger.Debugf("failed to reserve IP %s cluster wide, retrying: %v", newip.IP, err)
+					if err := waitForRetry(ctx, &backoff); err != nil {
+						return net.IPNet{}, err
+					}
+					continue
+				}
+				reserved = true
+			}
+		}
+
+		err = pool.Update(requestCtx, withoutIPs(reservelist, reservedElsewhere))
+		if err != nil && reserved {
+			if rollbackErr := m.overlappingRangeStore.UpdateOverlappingRangeAllocation(requestCtx, types.Deallocate, newip.IP,
+				m.containerID, podRef, m.ifName, m.ipamConf.NetworkName, ipRange.Range); rollbackErr != nil {
+				logger.Errorf("failed to roll back the cluster wide reservation of IP %s: %v", newip.IP, rollbackErr)
+			}
+		}
+		cancel()
+		if err == nil {
+			return newip, nil
+		}
+		if !isTemporary(err) {
+			return net.IPNet{}, err
+		}
+		logger.Debugf("IP pool of range %s updated concurrently, retrying: %v", ipRange.Range, err)
+		if err := waitForRetry(ctx, &backoff); err != nil {
+			return net.IPNet{}, err
+		}
+	}
+	return net.IPNet{}, fmt.Errorf("failed to allocate an IP of range %s after %d attempts", ipRange.Range, DatastoreRetries)
+}
+
+// deallocate releases the IP of the range, retrying on the IP pool read anew when it was updated concurrently, then
+// its cluster wide reservation
+func (m ipManager) deallocate(ctx context.Context, ipRange types.RangeConfiguration) error {
+	logger := logging.FromContext(ctx)
+	backoff := m.retryBackoff()
+	for attempt := 0; attempt < DatastoreRetries; attempt++ {
+		requestCtx, cancel := context.WithTimeout(ctx, RequestTimeout)
+		pool, err := m.store.GetIPPool(requestCtx, poolIdentifier(m.ipamConf, ipRange))
+		if err != nil {
+			cancel()
+			return err
+		}
+		reservelist, ip := allocate.DeallocateIP(pool.Allocations(), m.containerID, m.ifName)
+		if ip == nil {
+			cancel()
+			logger.Debugf("no IP of range %s allocated to container %s, interface %s", ipRange.Range, m.containerID, m.ifName)
+			return nil
+		}
+		err = pool.Update(requestCtx, reservelist)
+		if err == nil && m.overlappingRangeStore != nil {
+			if err := m.overlappingRangeStore.UpdateOverlappingRangeAllocation(requestCtx, types.Deallocate, ip,
+				m.containerID, m.ipamConf.GetPodRef(), m.ifName, m.ipamConf.NetworkName, ipRange.Range); err != nil {
+				logger.Errorf("failed to release the cluster wide reservation of IP %s: %v", ip, err)
+			}
+		}
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if !isTemporary(err) {
+			return err
+		}
+		logger.Debugf("IP pool of range %s updated concurrently, retrying: %v", ipRange.Range, err)
+		if err := waitForRetry(ctx, &backoff); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to release the IP of range %s after %d attempts", ipRange.Range, DatastoreRetries)
+}
+
+func (m ipManager) retryBackoff() wait.Backoff {
+	return DatastoreRetryBackoff(time.Duration(m.ipamConf.BackoffBaseMs)*time.Millisecond,
+		time.Duration(m.ipamConf.BackoffMaxMs)*time.Millisecond)
+}
+
+func poolIdentifier(ipamConf types.IPAMConfig, ipRange types.RangeConfiguration) PoolIdentifier {
+	return PoolIdentifier{IpRange: ipRange.Range, NetworkName: ipamConf.NetworkName}
+}
+
+func isTemporary(err error) bool {
+	temporary, ok := err.(Temporary)
+	return ok && temporary.Temporary()
+}
+
+func waitForRetry(ctx context.Context, backoff *wait.Backoff) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(DatastoreRetryDelay(backoff)):
+		return nil
+	}
+}
+
+// withoutIPs returns the allocations but those of the IPs of the excluded allocations
+func withoutIPs(reservelist, excluded []types.IPReservation) []types.IPReservation {
+	if len(excluded) == 0 {
+		return reservelist
+	}
+	kept := make([]types.IPReservation, 0, len(reservelist))
+	for _, reservation := range reservelist {
+		if !holdsIP(excluded, reservation.IP) {
+			kept = append(kept, reservation)
+		}
+	}
+	return kept
+}
+
+func holdsIP(reservelist []types.IPReservation, ip net.IP) bool {
+	for _, reservation := range reservelist {
+		if reservation.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func holdsAllocation(reservelist []types.IPReservation, containerID, ifName string) bool {
+	for _, reservation := range reservelist {
+		if reservation.ContainerID == containerID && reservation.IfName == ifName {
+			return true
+		}
+	}
+	return false
+}
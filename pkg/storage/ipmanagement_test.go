@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/allocate"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// memoryStore keeps the IP pools in memory, failing the updates of the pools updated since they were read
+type memoryStore struct {
+	lock         sync.Mutex
+	pools        map[string][]types.IPReservation
+	versions     map[string]int
+	reservations map[string]v1alpha1.OverlappingRangeIPReservationSpec
+	// conflicts is the number of pool updates to fail as if updated concurrently
+	conflicts int
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		pools:        map[string][]types.IPReservation{},
+		versions:     map[string]int{},
+		reservations: map[string]v1alpha1.OverlappingRangeIPReservationSpec{},
+	}
+}
+
+type conflictError struct{}
+
+func (conflictError) Error() string   { return "the IP pool was updated concurrently" }
+func (conflictError) Temporary() bool { return true }
+
+type memoryPool struct {
+	store       *memoryStore
+	name        string
+	version     int
+	allocations []types.IPReservation
+}
+
+func (p *memoryPool) Allocations() []types.IPReservation {
+	return p.allocations
+}
+
+func (p *memoryPool) Update(_ context.Context, reservations []types.IPReservation) error {
+	p.store.lock.Lock()
+	defer p.store.lock.Unlock()
+	if p.store.conflicts > 0 {
+		p.store.conflicts--
+		p.store.versions[p.name]++
+	}
+	if p.store.versions[p.name] != p.version {
+		return conflictError{}
+	}
+	p.store.pools[p.name] = append([]types.IPReservation{}, reservations...)
+	p.store.versions[p.name]++
+	return nil
+}
+
+func (s *memoryStore) GetIPPool(_ context.Context, poolIdentifier PoolIdentifier) (IPPool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	name := poolIdentifier.IpRange
+	return &memoryPool{store: s, name: name, version: s.versions[name], allocations: append([]types.IPReservation{}, s.pools[name]...)}, nil
+}
+
+func (s *memoryStore) GetOverlappingRangeStore() (OverlappingRangeStore, error) {
+	return s, nil
+}
+
+func (s *memoryStore) Status(context.Context) error {
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+func (s *memoryStore) GetOverlappingRangeIPReservation(_ context.Context, ip net.IP, _, _ string) (*v1alpha1.OverlappingRangeIPReservation, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	spec, found := s.reservations[ip.String()]
+	if !found {
+		return nil, nil
+	}
+	return &v1alpha1.OverlappingRangeIPReservation{Spec: spec}, nil
+}
+
+func (s *memoryStore) UpdateOverlappingRangeAllocation(_ context.Context, mode int, ip net.IP, containerID, podRef, ifName, _, _ string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if mode == types.Deallocate {
+		delete(s.reservations, ip.String())
+		return nil
+	}
+	if _, found := s.reservations[ip.String()]; found {
+		return fmt.Errorf("IP %s is already reserved", ip)
+	}
+	s.reservations[ip.String()] = v1alpha1.OverlappingRangeIPReservationSpec{ContainerID: containerID, PodRef: podRef, IfName: ifName}
+	return nil
+}
+
+var _ = Describe("Pluggable datastores", func() {
+	ipamConf := func(podName string) types.IPAMConfig {
+		return types.IPAMConfig{
+			Datastore:         "memory",
+			IPRanges:          []types.RangeConfiguration{{Range: "192.168.1.0/29", OmitRanges: []string{"192.168.1.1/32"}}},
+			OverlappingRanges: true,
+			PodName:           podName,
+			PodNamespace:      "default",
+			BackoffBaseMs:     1,
+			BackoffMaxMs:      1,
+		}
+	}
+
+	It("opens the store of the registered datastore", func() {
+		store := newMemoryStore()
+		Register("memory", func(string, string, types.IPAMConfig) (Store, error) { return store, nil })
+		Expect(Datastores()).To(ContainElement("memory"))
+		Expect(func() { Register("memory", func(string, string, types.IPAMConfig) (Store, error) { return nil, nil }) }).To(Panic())
+
+		opened, err := NewStore("container", "net1", ipamConf("pod"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opened).To(BeIdenticalTo(store))
+
+		_, err = NewStore("container", "net1", types.IPAMConfig{Datastore: "consul"})
+		Expect(err).To(MatchError(HavePrefix(`unknown datastore "consul", expected one of:`)))
+		Expect(DatastoreOf(types.IPAMConfig{})).To(Equal(DefaultDatastore))
+		Expect(DatastoreOf(types.IPAMConfig{Datastore: "etcd"})).To(Equal(DefaultDatastore))
+	})
+
+	It("allocates and releases the IPs through the store", func() {
+		store := newMemoryStore()
+		store.reservations["192.168.1.2"] = v1alpha1.OverlappingRangeIPReservationSpec{PodRef: "other/pod"}
+		store.conflicts = 2
+
+		newips, err := IPManagement(context.TODO(), types.Allocate, ipamConf("pod1"), store, "container1", "net1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(newips).To(HaveLen(1))
+		Expect(newips[0].String()).To(Equal("192.168.1.3/29"))
+		Expect(store.pools["192.168.1.0/29"]).To(HaveLen(1))
+		Expect(store.reservations).To(HaveKeyWithValue("192.168.1.3",
+			v1alpha1.OverlappingRangeIPReservationSpec{ContainerID: "container1", PodRef: "default/pod1", IfName: "net1"}))
+
+		newips, err = IPManagement(context.TODO(), types.Allocate, ipamConf("pod1"), store, "container1", "net1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(newips[0].String()).To(Equal("192.168.1.3/29"))
+		Expect(CheckAllocations(context.TODO(), ipamConf("pod1"), store, "container1", "net1")).To(Succeed())
+
+		_, err = IPManagement(context.TODO(), types.Deallocate, ipamConf("pod1"), store, "container1", "net1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.pools["192.168.1.0/29"]).To(BeEmpty())
+		Expect(store.reservations).NotTo(HaveKey("192.168.1.3"))
+		Expect(CheckAllocations(context.TODO(), ipamConf("pod1"), store, "container1", "net1")).NotTo(Succeed())
+	})
+
+	It("refuses to allocate the IPs of the foreign ranges", func() {
+		store := newMemoryStore()
+		conf := ipamConf("pod1")
+		conf.ForeignRanges = []string{"192.168.1.0/30"}
+
+		_, err := IPManagement(context.TODO(), types.Allocate, conf, store, "container1", "net1")
+		Expect(err).To(BeAssignableToTypeOf(allocate.ForeignRangeError{}))
+		Expect(store.pools["192.168.1.0/29"]).To(BeEmpty())
+		Expect(store.reservations).To(BeEmpty())
+	})
+
+	It("clamps the retry delays to the max delay", func() {
+		const maxDelay = 40 * time.Millisecond
+		backoff := DatastoreRetryBackoff(10*time.Millisecond, maxDelay)
+		for i := 0; i < 20; i++ {
+			Expect(DatastoreRetryDelay(&backoff)).To(BeNumerically("<=", maxDelay))
+		}
+	})
+})
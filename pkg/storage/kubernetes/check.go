@@ -0,0 +1,138 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// CheckAllocations verifies, for a CNI CHECK, that the interface of the container still holds an address of each range
+// of the network: the IP pool of the range - any of its shards, or the slice of the node - holds an allocation of the
+// container which belongs to the pod, and so does its cluster wide reservation when the ranges may overlap. Unlike an
+// ADD or a DEL, it only reads the pools, creating none. It returns the addresses checked.
+func CheckAllocations(ctx context.Context, ipam *KubernetesIPAM, ipamConf whereaboutstypes.IPAMConfig) ([]net.IPNet, error) {
+	logger := logging.FromContext(ctx)
+	podRef := ipamConf.GetPodRef()
+
+	var ips []net.IPNet
+	for _, ipRange := range ipamConf.IPRanges {
+		poolIdentifiers, err := checkedPools(ctx, ipam, ipamConf, ipRange)
+		if err != nil {
+			return nil, err
+		}
+
+		var found *whereaboutstypes.IPReservation
+		var foundPool string
+		for _, poolIdentifier := range poolIdentifiers {
+			name := IPPoolName(poolIdentifier)
+			allocation, err := containerAllocation(ctx, ipam, name)
+			if err != nil {
+				return nil, err
+			}
+			if allocation != nil {
+				found, foundPool = allocation, name
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("no address of range %s is allocated to container %s on interface %s",
+				ipRange.Range, ipam.containerID, ipam.IfName)
+		}
+		if found.PodRef != podRef {
+			return nil, fmt.Errorf("IP %s of IP pool %s is allocated to pod %s, not to pod %s",
+				found.IP, foundPool, found.PodRef, podRef)
+		}
+
+		if ipamConf.OverlappingRanges {
+			store := &KubernetesOverlappingRangeStore{ipam.client, ipam.namespace}
+			reservation, err := store.GetOverlappingRangeIPReservation(ctx, found.IP, podRef, ipamConf.NetworkName)
+			if err != nil {
+				return nil, err
+			}
+			if reservation == nil {
+				return nil, fmt.Errorf("IP %s has no cluster wide reservation", found.IP)
+			}
+			if reservation.Spec.PodRef != podRef {
+				return nil, fmt.Errorf("IP %s is reserved cluster wide for pod %s, not for pod %s",
+					found.IP, reservation.Spec.PodRef, podRef)
+			}
+		}
+
+		ipNet, err := ipRange.Network()
+		if subrange, inSubrange := ipRange.Subrange(found.IP); inSubrange {
+			ipNet, err = subrange.Network()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %s: %w", ipRange.Range, err)
+		}
+		logger.Debugf("IP %s of IP pool %s is allocated to container %s of pod %s", found.IP, foundPool, ipam.containerID, podRef)
+		ips = append(ips, net.IPNet{IP: found.IP, Mask: ipNet.Mask})
+	}
+	return ips, nil
+}
+
+// checkedPools returns the identifiers of the IP pools an allocation of the range may be found in: the slice of the node
+// on node slice networks, every shard on sharded ranges
+func checkedPools(ctx context.Context, ipam *KubernetesIPAM, ipamConf whereaboutstypes.IPAMConfig, ipRange whereaboutstypes.RangeConfiguration) ([]PoolIdentifier, error) {
+	poolIdentifier := PoolIdentifier{IpRange: ipRange.Range, NetworkName: ipamConf.NetworkName}
+	if ipamConf.NodeSliceSize != "" {
+		hostname, err := ipam.nodeName()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get node hostname: %w", err)
+		}
+		nodeSliceRange, err := GetNodeSliceOfRange(ctx, ipam, hostname, ipRange.Range)
+		if err != nil {
+			return nil, err
+		}
+		poolIdentifier.NodeName = hostname
+		poolIdentifier.IpRange = nodeSliceRange
+		return []PoolIdentifier{poolIdentifier}, nil
+	}
+
+	shards, err := rangeShards(ipRange, ipamConf.PoolShards, ipam.containerID)
+	if err != nil {
+		return nil, err
+	}
+	var poolIdentifiers []PoolIdentifier
+	for _, shard := range shards {
+		shardIdentifier := poolIdentifier
+		if shard != nil {
+			shardIdentifier.IpRange = shard.ipRange
+			shardIdentifier.Shard = true
+		}
+		poolIdentifiers = append(poolIdentifiers, shardIdentifier)
+	}
+	return poolIdentifiers, nil
+}
+
+// containerAllocation returns the allocation of the IP pool to the interface of the container, nil when the pool holds
+// none or does not exist
+func containerAllocation(ctx context.Context, ipam *KubernetesIPAM, name string) (*whereaboutstypes.IPReservation, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	pool, err := ipam.client.WhereaboutsV1alpha1().IPPools(ipam.poolNamespace).Get(ctxWithTimeout, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("k8s get error: %s", err)
+	}
+
+	firstIP, _, err := pool.ParseCIDR()
+	if err != nil {
+		return nil, err
+	}
+	for _, allocation := range toIPReservationList(pool.Spec.Allocations, firstIP) {
+		if allocation.ContainerID == ipam.containerID && allocation.IfName == ipam.IfName {
+			return &allocation, nil
+		}
+	}
+	return nil, nil
+}
@@ -2,27 +2,72 @@ package kubernetes
 
 import (
 	"context"
+	"fmt"
+	"os"
 	"time"
 
+	authorizationv1 "k8s.io/api/authorization/v1"
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
+
 	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
 	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
+	wbinformers "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/informers/externalversions"
+	wblisters "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/listers/whereabouts.cni.cncf.io/v1alpha1"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
 )
 
 const listRequestTimeout = 30 * time.Second
 
+// informerSyncTimeout bounds the initial sync of the informers shared among the requests
+const informerSyncTimeout = time.Minute
+
+const (
+	// AllocationUserAgent tags the requests issued by the CNI plugin while allocating and releasing addresses
+	AllocationUserAgent = "whereabouts-ipam"
+	// ReconcilerUserAgent tags the requests issued by the IP reconciler
+	ReconcilerUserAgent = "whereabouts-reconciler"
+	// ControlLoopUserAgent tags the requests issued by the IP control loop
+	ControlLoopUserAgent = "whereabouts-controlloop"
+	// NodeSliceControllerUserAgent tags the requests issued by the node slice controller
+	NodeSliceControllerUserAgent = "whereabouts-node-slice-controller"
+	// WebhookUserAgent tags the requests issued by the admission webhook
+	WebhookUserAgent = "whereabouts-webhook"
+)
+
+// RateLimit throttles, client side, the requests issued to the API server. Zero values keep the client-go defaults.
+type RateLimit struct {
+	QPS   float32
+	Burst int
+}
+
 // Client has info on how to connect to the kubernetes cluster
 type Client struct {
 	client    wbclient.Interface
 	clientSet kubernetes.Interface
+	nadClient nadclient.Interface
 	retries   int
+	// whereaboutsNamespace holds the cluster wide reservations, whichever namespace the IP pools live in
+	whereaboutsNamespace string
+	// leaderElections, podLister and nodeSliceLister are shared among the requests of a long-running process
+	leaderElections *LeaderElections
+	podLister       corev1listers.PodLister
+	nodeSliceLister wblisters.NodeSlicePoolLister
 }
 
 func NewClient() (*Client, error) {
@@ -35,17 +80,70 @@ func NewClient() (*Client, error) {
 }
 
 func NewClientViaKubeconfig(kubeconfigPath string) (*Client, error) {
-	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
-		&clientcmd.ConfigOverrides{}).ClientConfig()
+	config, err := clientConfig(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(config)
+}
 
+// NewClientWithUserAgent returns a client tagging its requests with the provided user agent, and throttling them
+// according to the rate limit. When no kubeconfig path is provided, the in-cluster configuration is used.
+func NewClientWithUserAgent(kubeconfigPath, userAgent string, rateLimit RateLimit) (*Client, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfigPath == "" {
+		config, err = rest.InClusterConfig()
+	} else {
+		config, err = clientConfig(kubeconfigPath)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	ConfigureUserAgent(config, userAgent, rateLimit)
 	return newClient(config)
 }
 
+// ConfigureUserAgent tags the requests issued through the rest config with the user agent - telling whereabouts
+// components apart in the API server audit logs and metrics - and applies the rate limit.
+func ConfigureUserAgent(config *rest.Config, userAgent string, rateLimit RateLimit) {
+	config.UserAgent = fmt.Sprintf("%s %s", userAgent, rest.DefaultKubernetesUserAgent())
+	if rateLimit.QPS > 0 {
+		config.QPS = rateLimit.QPS
+	}
+	if rateLimit.Burst > 0 {
+		config.Burst = rateLimit.Burst
+	}
+}
+
+// PluginClientConfig returns the rest config of the CNI plugin: that of its kubeconfig, authenticating with the token
+// file instead of the credentials of the kubeconfig when one is configured. The token file is read anew as the kubelet
+// refreshes it, so that the short-lived tokens projected for the node are rotated without regenerating the kubeconfig.
+func PluginClientConfig(kubernetesConfig whereaboutstypes.KubernetesConfig) (*rest.Config, error) {
+	config, err := clientConfig(kubernetesConfig.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	if kubernetesConfig.TokenFile == "" {
+		return config, nil
+	}
+
+	if _, err := os.Stat(kubernetesConfig.TokenFile); err != nil {
+		return nil, fmt.Errorf("failed to read the token file: %w", err)
+	}
+	config = rest.AnonymousClientConfig(config)
+	config.BearerTokenFile = kubernetesConfig.TokenFile
+	return config, nil
+}
+
+func clientConfig(kubeconfigPath string) (*rest.Config, error) {
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
+		&clientcmd.ConfigOverrides{}).ClientConfig()
+}
+
 func newClient(config *rest.Config) (*Client, error) {
 	clientSet, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -57,57 +155,127 @@ func newClient(config *rest.Config) (*Client, error) {
 		return nil, err
 	}
 
-	return NewKubernetesClient(c, clientSet), nil
+	nadClientSet, err := nadclient.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKubernetesClientWithNetAttachDefs(c, clientSet, nadClientSet), nil
 }
 
 func NewKubernetesClient(k8sClient wbclient.Interface, k8sClientSet kubernetes.Interface) *Client {
 	return &Client{
-		client:    k8sClient,
-		clientSet: k8sClientSet,
-		retries:   storage.DatastoreRetries,
+		client:               k8sClient,
+		clientSet:            k8sClientSet,
+		retries:              storage.DatastoreRetries,
+		whereaboutsNamespace: WhereaboutsNamespace(),
 	}
 }
 
-func (i *Client) ListIPPools() ([]storage.IPPool, error) {
-	logging.Debugf("listing IP pools")
+// WhereaboutsNamespace returns the namespace of the whereabouts deployment: the WHEREABOUTS_NAMESPACE of the process,
+// or else kube-system
+func WhereaboutsNamespace() string {
+	if namespace, found := os.LookupEnv("WHEREABOUTS_NAMESPACE"); found {
+		return namespace
+	}
+	return "kube-system"
+}
 
-	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), listRequestTimeout)
-	defer cancel()
+// NewKubernetesClientWithNetAttachDefs returns a client which also lists the network-attachment-definitions
+func NewKubernetesClientWithNetAttachDefs(k8sClient wbclient.Interface, k8sClientSet kubernetes.Interface, nadClientSet nadclient.Interface) *Client {
+	client := NewKubernetesClient(k8sClient, k8sClientSet)
+	client.nadClient = nadClientSet
+	return client
+}
 
-	ipPoolList, err := i.client.WhereaboutsV1alpha1().IPPools(metav1.NamespaceAll).List(ctxWithTimeout, metav1.ListOptions{})
-	if err != nil {
-		return nil, err
+// ShareLeaderElections has the requests of the client share their leader elections, campaigning under the identity of
+// the process, see LeaderElections
+func (i *Client) ShareLeaderElections(identity string) {
+	i.leaderElections = NewLeaderElections(identity)
+}
+
+// ShareInformers has the client read the pods of the node, and the node slices of the namespace, from informers started
+// along - and synced before returning - rather than from the API server on each request. The objects missing from the
+// caches, e.g. a pod created moments ago, are still read from the API server. The informers stop with the context;
+// failing to sync them in time, the client keeps reading from the API server.
+func (i *Client) ShareInformers(ctx context.Context, nodeName, namespace string) error {
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(i.clientSet, 0,
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+		}))
+	wbInformerFactory := wbinformers.NewSharedInformerFactoryWithOptions(i.client, 0, wbinformers.WithNamespace(namespace))
+	podInformer := kubeInformerFactory.Core().V1().Pods()
+	nodeSliceInformer := wbInformerFactory.Whereabouts().V1alpha1().NodeSlicePools()
+	podInformer.Informer()
+	nodeSliceInformer.Informer()
+
+	kubeInformerFactory.Start(ctx.Done())
+	wbInformerFactory.Start(ctx.Done())
+	syncCtx, cancel := context.WithTimeout(ctx, informerSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), podInformer.Informer().HasSynced, nodeSliceInformer.Informer().HasSynced) {
+		return fmt.Errorf("failed to sync the informers of the pods of node %s and the node slices", nodeName)
 	}
+	i.podLister = podInformer.Lister()
+	i.nodeSliceLister = nodeSliceInformer.Lister()
+	return nil
+}
+
+func (i *Client) ListIPPools(ctx context.Context) ([]storage.IPPool, error) {
+	logging.Debugf("listing IP pools")
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, listRequestTimeout)
+	defer cancel()
 
 	var whereaboutsApiIPPoolList []storage.IPPool
-	for idx, pool := range ipPoolList.Items {
+	err := eachListItem(ctxWithTimeout, func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		return i.client.WhereaboutsV1alpha1().IPPools(metav1.NamespaceAll).List(ctx, opts)
+	}, metav1.ListOptions{}, func(obj runtime.Object) error {
+		pool := obj.(*whereaboutsv1alpha1.IPPool)
 		firstIP, _, err := pool.ParseCIDR()
 		if err != nil {
-			return nil, err
+			return err
 		}
 		whereaboutsApiIPPoolList = append(
 			whereaboutsApiIPPoolList,
-			&KubernetesIPPool{client: i.client, firstIP: firstIP, pool: &ipPoolList.Items[idx]})
+			&KubernetesIPPool{client: i.client, firstIP: firstIP, pool: pool})
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return whereaboutsApiIPPoolList, nil
 }
 
-func (i *Client) ListPods() ([]v1.Pod, error) {
+func (i *Client) ListPods(ctx context.Context) ([]v1.Pod, error) {
 	logging.Debugf("listing Pods")
 
-	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), listRequestTimeout)
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, listRequestTimeout)
 	defer cancel()
 
-	podList, err := i.clientSet.CoreV1().Pods(metav1.NamespaceAll).List(ctxWithTimeout, metav1.ListOptions{})
+	var pods []v1.Pod
+	err := eachListItem(ctxWithTimeout, func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		return i.clientSet.CoreV1().Pods(metav1.NamespaceAll).List(ctx, opts)
+	}, metav1.ListOptions{}, func(obj runtime.Object) error {
+		pods = append(pods, *obj.(*v1.Pod))
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return podList.Items, nil
+	return pods, nil
 }
 
-func (i *Client) GetPod(namespace, name string) (*v1.Pod, error) {
-	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), storage.RequestTimeout)
+func (i *Client) GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error) {
+	if i.podLister != nil {
+		pod, err := i.podLister.Pods(namespace).Get(name)
+		if !k8serrors.IsNotFound(err) {
+			return pod, err
+		}
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
 	defer cancel()
 
 	pod, err := i.clientSet.CoreV1().Pods(namespace).Get(ctxWithTimeout, name, metav1.GetOptions{})
@@ -118,22 +286,212 @@ func (i *Client) GetPod(namespace, name string) (*v1.Pod, error) {
 	return pod, nil
 }
 
-func (i *Client) ListOverlappingIPs() ([]whereaboutsv1alpha1.OverlappingRangeIPReservation, error) {
-	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), listRequestTimeout)
+// GetNamespace returns the namespace of the given name, read from the API server
+func (i *Client) GetNamespace(ctx context.Context, name string) (*v1.Namespace, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
 	defer cancel()
 
-	overlappingIPsList, err := i.client.WhereaboutsV1alpha1().OverlappingRangeIPReservations(metav1.NamespaceAll).List(ctxWithTimeout, metav1.ListOptions{})
+	return i.clientSet.CoreV1().Namespaces().Get(ctxWithTimeout, name, metav1.GetOptions{})
+}
+
+func (i *Client) ListOverlappingIPs(ctx context.Context) ([]whereaboutsv1alpha1.OverlappingRangeIPReservation, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, listRequestTimeout)
+	defer cancel()
+
+	var overlappingIPs []whereaboutsv1alpha1.OverlappingRangeIPReservation
+	err := eachListItem(ctxWithTimeout, func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		return i.client.WhereaboutsV1alpha1().OverlappingRangeIPReservations(metav1.NamespaceAll).List(ctx, opts)
+	}, metav1.ListOptions{}, func(obj runtime.Object) error {
+		overlappingIPs = append(overlappingIPs, *obj.(*whereaboutsv1alpha1.OverlappingRangeIPReservation))
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return overlappingIPsList.Items, nil
+	return overlappingIPs, nil
 }
 
-func (i *Client) DeleteOverlappingIP(clusterWideIP *whereaboutsv1alpha1.OverlappingRangeIPReservation) error {
-	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), storage.RequestTimeout)
+func (i *Client) DeleteOverlappingIP(ctx context.Context, clusterWideIP *whereaboutsv1alpha1.OverlappingRangeIPReservation) error {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
 	defer cancel()
 
 	return i.client.WhereaboutsV1alpha1().OverlappingRangeIPReservations(clusterWideIP.GetNamespace()).Delete(
 		ctxWithTimeout, clusterWideIP.GetName(), metav1.DeleteOptions{})
 }
+
+// ReleasedAllocations are allocations released from an IP pool
+type ReleasedAllocations struct {
+	Pool        storage.IPPool
+	Allocations []whereaboutstypes.IPReservation
+}
+
+// ReleaseOverlappingIPs deletes the overlapping range reservations of the released allocations: for each, the
+// reservation of its IP in the network of its pool, as long as it is held by the pod of the allocation. The other
+// reservations of the pod - e.g. those of its allocations spared by the caller - are left alone. The reservations of
+// the pools of no known network are left to the reconciler.
+func (i *Client) ReleaseOverlappingIPs(ctx context.Context, released []ReleasedAllocations) error {
+	netAttachDefs, err := i.ListNetAttachDefs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list the network-attachment-definitions: %w", err)
+	}
+	networks := NewWhereaboutsNetworks(netAttachDefs)
+	clusterWideIPs, err := i.ListOverlappingIPs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list the overlapping range reservations: %w", err)
+	}
+	reservationsByName := map[string][]int{}
+	for j, clusterWideIP := range clusterWideIPs {
+		reservationsByName[clusterWideIP.GetName()] = append(reservationsByName[clusterWideIP.GetName()], j)
+	}
+
+	for _, poolReleased := range released {
+		pool, isNamed := poolReleased.Pool.(rangedPool)
+		if !isNamed {
+			continue
+		}
+		network, found := networks.poolWhereaboutsNetwork(pool.Name(), pool.Range())
+		if !found {
+			logging.Debugf("no network known for IP pool %s; leaving the cluster wide reservations of its released allocations to the reconciler",
+				pool.Name())
+			continue
+		}
+		for _, allocation := range poolReleased.Allocations {
+			for _, j := range reservationsByName[NormalizeIP(allocation.IP, network.networkName)] {
+				if clusterWideIPs[j].Spec.PodRef != allocation.PodRef {
+					continue
+				}
+				if err := i.DeleteOverlappingIP(ctx, &clusterWideIPs[j]); err != nil && !k8serrors.IsNotFound(err) {
+					return fmt.Errorf("failed to delete the overlapping range reservation %s: %w", clusterWideIPs[j].GetName(), err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// PoolOverlappingRangeStore returns the store of the cluster wide reservations of the IPs of the IP pool, along with
+// the name of its network. The store is nil when the network of the pool does not reserve its IPs cluster wide, or is
+// not known.
+func (i *Client) PoolOverlappingRangeStore(ctx context.Context, pool *KubernetesIPPool) (*KubernetesOverlappingRangeStore, string, error) {
+	network, found, err := i.poolNetwork(ctx, pool)
+	if err != nil || !found || !network.overlappingRanges {
+		return nil, "", err
+	}
+	return &KubernetesOverlappingRangeStore{i.client, i.whereaboutsNamespace}, network.networkName, nil
+}
+
+// poolNetwork returns the network of the IP pool, told from the network-attachment-definitions, if known
+func (i *Client) poolNetwork(ctx context.Context, pool *KubernetesIPPool) (whereaboutsNetwork, bool, error) {
+	netAttachDefs, err := i.ListNetAttachDefs(ctx)
+	if err != nil {
+		return whereaboutsNetwork{}, false, fmt.Errorf("failed to list the network-attachment-definitions: %w", err)
+	}
+	network, found := NewWhereaboutsNetworks(netAttachDefs).poolWhereaboutsNetwork(pool.Name(), pool.Range())
+	return network, found, nil
+}
+
+// rangedPool is implemented by the IP pools whose network can be told from their name and range
+type rangedPool interface {
+	Name() string
+	Range() string
+}
+
+// GetIPPool returns the IP pool of the namespace with the given name
+func (i *Client) GetIPPool(ctx context.Context, namespace, name string) (storage.IPPool, error) {
+	pool, err := i.namedIPPool(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// ListNetAttachDefs lists the network-attachment-definitions of all namespaces. Clients created without a
+// network-attachment-definition client list none.
+func (i *Client) ListNetAttachDefs(ctx context.Context) ([]nadv1.NetworkAttachmentDefinition, error) {
+	if i.nadClient == nil {
+		return nil, nil
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, listRequestTimeout)
+	defer cancel()
+
+	var netAttachDefs []nadv1.NetworkAttachmentDefinition
+	err := eachListItem(ctxWithTimeout, func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		return i.nadClient.K8sCniCncfIoV1().NetworkAttachmentDefinitions(metav1.NamespaceAll).List(ctx, opts)
+	}, metav1.ListOptions{}, func(obj runtime.Object) error {
+		netAttachDefs = append(netAttachDefs, *obj.(*nadv1.NetworkAttachmentDefinition))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return netAttachDefs, nil
+}
+
+// GetNetAttachDef returns the network-attachment-definition of the namespace with the given name
+func (i *Client) GetNetAttachDef(ctx context.Context, namespace, name string) (*nadv1.NetworkAttachmentDefinition, error) {
+	if i.nadClient == nil {
+		return nil, fmt.Errorf("the client reads no network-attachment-definitions")
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	return i.nadClient.K8sCniCncfIoV1().NetworkAttachmentDefinitions(namespace).Get(ctxWithTimeout, name, metav1.GetOptions{})
+}
+
+func (i *Client) GetConfigMap(ctx context.Context, namespace, name string) (*v1.ConfigMap, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	return i.clientSet.CoreV1().ConfigMaps(namespace).Get(ctxWithTimeout, name, metav1.GetOptions{})
+}
+
+func (i *Client) CreateConfigMap(ctx context.Context, configMap *v1.ConfigMap) error {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	_, err := i.clientSet.CoreV1().ConfigMaps(configMap.GetNamespace()).Create(ctxWithTimeout, configMap, metav1.CreateOptions{})
+	return err
+}
+
+func (i *Client) UpdateConfigMap(ctx context.Context, configMap *v1.ConfigMap) error {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	_, err := i.clientSet.CoreV1().ConfigMaps(configMap.GetNamespace()).Update(ctxWithTimeout, configMap, metav1.UpdateOptions{})
+	return err
+}
+
+// ReviewSubjectAccess asks the API server whether the subject of the review may perform its action, e.g. on behalf of
+// the requests delegated by the aggregation layer
+func (i *Client) ReviewSubjectAccess(ctx context.Context, review *authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	return i.clientSet.AuthorizationV1().SubjectAccessReviews().Create(ctxWithTimeout, review, metav1.CreateOptions{})
+}
+
+// Events returns the client of the events of all namespaces, e.g. to record those of the reconciler
+func (i *Client) Events() typedcorev1.EventInterface {
+	return i.clientSet.CoreV1().Events(metav1.NamespaceAll)
+}
+
+// ListNodeSlicePools lists the NodeSlicePools of all namespaces
+func (i *Client) ListNodeSlicePools(ctx context.Context) ([]whereaboutsv1alpha1.NodeSlicePool, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, listRequestTimeout)
+	defer cancel()
+
+	var nodeSlicePools []whereaboutsv1alpha1.NodeSlicePool
+	err := eachListItem(ctxWithTimeout, func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		return i.client.WhereaboutsV1alpha1().NodeSlicePools(metav1.NamespaceAll).List(ctx, opts)
+	}, metav1.ListOptions{}, func(obj runtime.Object) error {
+		nodeSlicePools = append(nodeSlicePools, *obj.(*whereaboutsv1alpha1.NodeSlicePool))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodeSlicePools, nil
+}
@@ -0,0 +1,156 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+func TestConfigureUserAgent(t *testing.T) {
+	cases := []struct {
+		name          string
+		rateLimit     RateLimit
+		expectedQPS   float32
+		expectedBurst int
+	}{
+		{
+			name:          "Client-go defaults",
+			rateLimit:     RateLimit{},
+			expectedQPS:   5,
+			expectedBurst: 10,
+		},
+		{
+			name:          "Dedicated rate limit",
+			rateLimit:     RateLimit{QPS: 2, Burst: 4},
+			expectedQPS:   2,
+			expectedBurst: 4,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &rest.Config{QPS: 5, Burst: 10}
+			ConfigureUserAgent(config, ReconcilerUserAgent, tc.rateLimit)
+
+			if !strings.HasPrefix(config.UserAgent, ReconcilerUserAgent+" ") {
+				t.Errorf("Expected the user agent to start with %q, got %q", ReconcilerUserAgent, config.UserAgent)
+			}
+			if config.QPS != tc.expectedQPS {
+				t.Errorf("Expected QPS %v, got %v", tc.expectedQPS, config.QPS)
+			}
+			if config.Burst != tc.expectedBurst {
+				t.Errorf("Expected burst %d, got %d", tc.expectedBurst, config.Burst)
+			}
+		})
+	}
+}
+
+func TestPluginClientConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	kubeconfigPath := filepath.Join(tmpDir, "whereabouts.kubeconfig")
+	kubeconfig := `apiVersion: v1
+kind: Config
+clusters:
+- name: local
+  cluster:
+    server: https://10.96.0.1:443
+users:
+- name: whereabouts
+  user:
+    token: shared-token
+contexts:
+- name: whereabouts-context
+  context:
+    cluster: local
+    user: whereabouts
+current-context: whereabouts-context
+`
+	if err := os.WriteFile(kubeconfigPath, []byte(kubeconfig), 0600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tokenPath := filepath.Join(tmpDir, "token")
+	if err := os.WriteFile(tokenPath, []byte("node-token"), 0600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	config, err := PluginClientConfig(whereaboutstypes.KubernetesConfig{KubeConfigPath: kubeconfigPath})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if config.BearerToken != "shared-token" || config.BearerTokenFile != "" {
+		t.Errorf("Expected the token of the kubeconfig, got %q and token file %q", config.BearerToken, config.BearerTokenFile)
+	}
+
+	config, err = PluginClientConfig(whereaboutstypes.KubernetesConfig{KubeConfigPath: kubeconfigPath, TokenFile: tokenPath})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if config.BearerToken != "" || config.BearerTokenFile != tokenPath {
+		t.Errorf("Expected the token file %s only, got token %q and token file %q", tokenPath, config.BearerToken, config.BearerTokenFile)
+	}
+	if config.Host != "https://10.96.0.1:443" {
+		t.Errorf("Expected the server of the kubeconfig, got %s", config.Host)
+	}
+
+	if _, err := PluginClientConfig(whereaboutstypes.KubernetesConfig{KubeConfigPath: kubeconfigPath, TokenFile: filepath.Join(tmpDir, "missing")}); err == nil {
+		t.Errorf("Expected an error with a missing token file")
+	}
+}
+
+func TestListIPPoolsPaginated(t *testing.T) {
+	var pools []whereaboutsv1alpha1.IPPool
+	for i := 0; i < 5; i++ {
+		pools = append(pools, whereaboutsv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("10.0.%d.0-24", i), Namespace: "kube-system"},
+			Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: fmt.Sprintf("10.0.%d.0/24", i)},
+		})
+	}
+	// the API server returns the pools two by two, along with the continue token of the next page
+	var listOptions []metav1.ListOptions
+	wbClient := fakewbclient.NewSimpleClientset()
+	wbClient.PrependReactor("list", "ippools", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		options := action.(k8stesting.ListActionImpl).ListOptions
+		listOptions = append(listOptions, options)
+		start := 0
+		if options.Continue != "" {
+			start, _ = strconv.Atoi(options.Continue)
+		}
+		page := &whereaboutsv1alpha1.IPPoolList{}
+		end := min(start+2, len(pools))
+		page.Items = pools[start:end]
+		if end < len(pools) {
+			page.Continue = strconv.Itoa(end)
+		}
+		return true, page, nil
+	})
+	client := NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset())
+
+	listed, err := client.ListIPPools(context.TODO())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(listed) != len(pools) {
+		t.Errorf("Expected the %d pools to be listed, got %d", len(pools), len(listed))
+	}
+	if len(listOptions) != 3 {
+		t.Fatalf("Expected the pools to be listed in 3 pages, got %d", len(listOptions))
+	}
+	for _, options := range listOptions {
+		if options.Limit != listPageSize {
+			t.Errorf("Expected the pages to be limited to %d pools, got %d", listPageSize, options.Limit)
+		}
+	}
+}
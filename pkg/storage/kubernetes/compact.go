@@ -0,0 +1,215 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+)
+
+// Compaction is what compacting an IP pool changed, or would change in dry-run mode
+type Compaction struct {
+	Pool      string `json:"pool"`
+	Namespace string `json:"namespace"`
+	// Rekeyed counts the allocations whose offset was not written canonically, e.g. "007", and is rewritten
+	Rekeyed int `json:"rekeyed,omitempty"`
+	// Dropped are the keys of the tombstones dropped from the allocations: the keys which are no offsets, and the
+	// offsets outside the range. The allocations to no pod, e.g. those set aside by hand, are kept.
+	Dropped []string `json:"dropped,omitempty"`
+	// PrunedStatus counts the entries dropped from the release times, externally used IPs and sticky IPs of the
+	// status: those of invalid or out of range offsets, and the release times and externally used IPs of allocated
+	// offsets
+	PrunedStatus int `json:"prunedStatus,omitempty"`
+}
+
+// Changed tells whether compacting the pool changed it
+func (c Compaction) Changed() bool {
+	return c.Rekeyed > 0 || len(c.Dropped) > 0 || c.PrunedStatus > 0
+}
+
+// CompactIPPool returns a copy of the pool with its allocations map rewritten: the tombstones dropped and the offsets
+// keyed canonically. The entries of the status maps no allocation can match are pruned. The offsets themselves are
+// never renumbered, being the IPs of the allocations.
+func CompactIPPool(pool *whereaboutsv1alpha1.IPPool) (*whereaboutsv1alpha1.IPPool, Compaction) {
+	compacted := pool.DeepCopy()
+	compaction := Compaction{Pool: pool.GetName(), Namespace: pool.GetNamespace()}
+
+	inRange, err := offsetsInRange(pool)
+	if err != nil {
+		logging.Debugf("not compacting IP pool %s: %v", pool.GetName(), err)
+		return compacted, compaction
+	}
+
+	allocations := make(map[string]whereaboutsv1alpha1.IPAllocation, len(pool.Spec.Allocations))
+	for _, key := range sortedKeys(pool.Spec.Allocations) {
+		allocation := pool.Spec.Allocations[key]
+		offset, ok := inRange(key)
+		if !ok {
+			compaction.Dropped = append(compaction.Dropped, key)
+			continue
+		}
+		canonicalKey := strconv.FormatUint(offset, 10)
+		if _, duplicate := allocations[canonicalKey]; duplicate {
+			compaction.Dropped = append(compaction.Dropped, key)
+			continue
+		}
+		if canonicalKey != key {
+			compaction.Rekeyed++
+		}
+		allocations[canonicalKey] = allocation
+	}
+	compacted.Spec.Allocations = allocations
+
+	spare := func(key string) bool {
+		offset, ok := inRange(key)
+		if !ok {
+			return false
+		}
+		_, allocated := allocations[strconv.FormatUint(offset, 10)]
+		return !allocated
+	}
+	compaction.PrunedStatus += pruneKeys(compacted.Status.Released, spare)
+	compaction.PrunedStatus += pruneKeys(compacted.Status.ExternallyUsed, spare)
+	for podRef, key := range compacted.Status.StickyIPs {
+		if _, ok := inRange(key); !ok {
+			delete(compacted.Status.StickyIPs, podRef)
+			compaction.PrunedStatus++
+		}
+	}
+	return compacted, compaction
+}
+
+// CompactIPPools compacts the IP pools - only the named one when set - and returns the compactions of those which
+// changed. In dry-run mode, the pools are left as they are. The updates are guarded by the resource version of the
+// pools, a pool changed meanwhile being compacted anew.
+func (i *Client) CompactIPPools(ctx context.Context, poolName string, dryRun bool) ([]Compaction, error) {
+	var pools []whereaboutsv1alpha1.IPPool
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, listRequestTimeout)
+	err := eachListItem(ctxWithTimeout, func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		return i.client.WhereaboutsV1alpha1().IPPools(metav1.NamespaceAll).List(ctx, opts)
+	}, metav1.ListOptions{}, func(obj runtime.Object) error {
+		pool := obj.(*whereaboutsv1alpha1.IPPool)
+		if poolName == "" || pool.GetName() == poolName {
+			pools = append(pools, *pool)
+		}
+		return nil
+	})
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the IP pools: %w", err)
+	}
+	if poolName != "" && len(pools) == 0 {
+		return nil, fmt.Errorf("no IP pool named %s", poolName)
+	}
+
+	var compactions []Compaction
+	for idx := range pools {
+		compaction, err := i.compactIPPool(ctx, &pools[idx], dryRun)
+		if err != nil {
+			return compactions, err
+		}
+		if compaction.Changed() {
+			compactions = append(compactions, compaction)
+		}
+	}
+	return compactions, nil
+}
+
+func (i *Client) compactIPPool(ctx context.Context, pool *whereaboutsv1alpha1.IPPool, dryRun bool) (Compaction, error) {
+	var err error
+	for j := 0; j < i.retries; j++ {
+		compacted, compaction := CompactIPPool(pool)
+		if !compaction.Changed() || dryRun {
+			return compaction, nil
+		}
+
+		ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+		_, err = i.client.WhereaboutsV1alpha1().IPPools(pool.GetNamespace()).Update(ctxWithTimeout, compacted, metav1.UpdateOptions{})
+		if err == nil {
+			cancel()
+			logging.Verbosef("compacted IP pool %s: %d allocations rekeyed, %d dropped, %d status entries pruned",
+				pool.GetName(), compaction.Rekeyed, len(compaction.Dropped), compaction.PrunedStatus)
+			return compaction, nil
+		}
+		if !k8serrors.IsConflict(err) {
+			cancel()
+			break
+		}
+		logging.Debugf("IP pool %s changed while compacting it, retrying: %v", pool.GetName(), err)
+		pool, err = i.client.WhereaboutsV1alpha1().IPPools(pool.GetNamespace()).Get(ctxWithTimeout, pool.GetName(), metav1.GetOptions{})
+		cancel()
+		if err != nil {
+			break
+		}
+	}
+	return Compaction{}, fmt.Errorf("failed to compact IP pool %s: %w", pool.GetName(), err)
+}
+
+// offsetsInRange returns a function parsing the key of an offset of the pool, which tells whether the IP of the offset
+// belongs to the range, or to one of the CIDRs of the range set of the pool
+func offsetsInRange(pool *whereaboutsv1alpha1.IPPool) (func(string) (uint64, bool), error) {
+	firstIP, rangeNet, err := pool.ParseCIDR()
+	if err != nil {
+		return nil, err
+	}
+	nets := []*net.IPNet{rangeNet}
+	for _, cidr := range pool.Spec.RangeSet {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return func(key string) (uint64, bool) {
+		offset, err := strconv.ParseUint(key, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		ip := iphelpers.IPAddOffset(firstIP, offset)
+		for _, ipNet := range nets {
+			if ipNet.Contains(ip) {
+				return offset, true
+			}
+		}
+		return 0, false
+	}, nil
+}
+
+// pruneKeys deletes the entries of the map whose key is not spared, and returns how many it deleted
+func pruneKeys(m map[string]metav1.Time, spare func(string) bool) int {
+	pruned := 0
+	for key := range m {
+		if !spare(key) {
+			delete(m, key)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// sortedKeys sorts the keys of the allocations by length first: the canonical key of an offset is the shortest one,
+// hence kept over the others of the same offset
+func sortedKeys(allocations map[string]whereaboutsv1alpha1.IPAllocation) []string {
+	keys := make([]string, 0, len(allocations))
+	for key := range allocations {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,71 @@
+package kubernetes
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+)
+
+func TestCompactIPPool(t *testing.T) {
+	released := metav1.NewTime(time.Now())
+	pool := &whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-30", Namespace: "kube-system"},
+		Spec: whereaboutsv1alpha1.IPPoolSpec{
+			Range:    "10.0.0.0/30",
+			RangeSet: []string{"10.0.0.0/30", "10.0.1.0/30"},
+			Allocations: map[string]whereaboutsv1alpha1.IPAllocation{
+				"1":   {ContainerID: "container-a", PodRef: "default/pod-a"},
+				"01":  {ContainerID: "container-b", PodRef: "default/pod-b"},
+				"002": {ContainerID: "container-c", PodRef: "default/pod-c"},
+				"3":   {ContainerID: "container-d"},
+				"100": {ContainerID: "container-e", PodRef: "default/pod-e"},
+				"257": {ContainerID: "container-f", PodRef: "default/pod-f"},
+				"ip":  {ContainerID: "container-g", PodRef: "default/pod-g"},
+			},
+		},
+		Status: whereaboutsv1alpha1.IPPoolStatus{
+			Released:  map[string]metav1.Time{"1": released, "3": released, "100": released, "258": released},
+			StickyIPs: map[string]string{"default/pod-a": "1", "default/pod-h": "100"},
+		},
+	}
+
+	compacted, compaction := CompactIPPool(pool)
+
+	expectedAllocations := map[string]whereaboutsv1alpha1.IPAllocation{
+		"1":   {ContainerID: "container-a", PodRef: "default/pod-a"},
+		"2":   {ContainerID: "container-c", PodRef: "default/pod-c"},
+		"3":   {ContainerID: "container-d"},
+		"257": {ContainerID: "container-f", PodRef: "default/pod-f"},
+	}
+	if !reflect.DeepEqual(compacted.Spec.Allocations, expectedAllocations) {
+		t.Errorf("Expected the allocations %v, got %v", expectedAllocations, compacted.Spec.Allocations)
+	}
+	if !reflect.DeepEqual(compacted.Status.Released, map[string]metav1.Time{"258": released}) {
+		t.Errorf("Expected only the release time of the free 10.0.1.2 to be kept, got %v", compacted.Status.Released)
+	}
+	if !reflect.DeepEqual(compacted.Status.StickyIPs, map[string]string{"default/pod-a": "1"}) {
+		t.Errorf("Expected only the sticky IP in range to be kept, got %v", compacted.Status.StickyIPs)
+	}
+
+	expectedCompaction := Compaction{
+		Pool:         "10.0.0.0-30",
+		Namespace:    "kube-system",
+		Rekeyed:      1,
+		Dropped:      []string{"01", "ip", "100"},
+		PrunedStatus: 4,
+	}
+	if !reflect.DeepEqual(compaction, expectedCompaction) {
+		t.Errorf("Expected the compaction %+v, got %+v", expectedCompaction, compaction)
+	}
+	if len(pool.Spec.Allocations) != 7 {
+		t.Errorf("Expected the pool to be left as it is, got %v", pool.Spec.Allocations)
+	}
+
+	if _, compaction := CompactIPPool(compacted); compaction.Changed() {
+		t.Errorf("Expected a compacted pool to be left as it is, got %+v", compaction)
+	}
+}
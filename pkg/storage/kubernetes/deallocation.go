@@ -0,0 +1,266 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/allocate"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// ContainerIDLabel indexes the cluster wide reservations by the container they were allocated to, so that a DEL finds
+// the ranges of the container without reading the IP pool of every range. Label values being limited to 63
+// characters, it holds the first 63 characters of the container ID.
+const ContainerIDLabel = "whereabouts.cni.cncf.io/container-id"
+
+const maxLabelValueLength = 63
+
+// containerIDLabelValue returns the value of the ContainerIDLabel of the reservations of the container
+func containerIDLabelValue(containerID string) string {
+	if len(containerID) > maxLabelValueLength {
+		return containerID[:maxLabelValueLength]
+	}
+	return containerID
+}
+
+// ListContainerReservedIPs lists the IPs of the network reserved cluster wide for the interface of the container,
+// through the ContainerIDLabel of their reservations. The reservations predating the label are not listed.
+func (c *KubernetesOverlappingRangeStore) ListContainerReservedIPs(ctx context.Context, containerID, ifName, networkName string) ([]net.IP, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, listRequestTimeout)
+	defer cancel()
+
+	selector := labels.SelectorFromSet(labels.Set{ContainerIDLabel: containerIDLabelValue(containerID)})
+	reservations, err := c.client.WhereaboutsV1alpha1().OverlappingRangeIPReservations(c.namespace).List(ctxWithTimeout,
+		metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("k8s list OverlappingRangeIPReservation error: %s", err)
+	}
+
+	prefix := ""
+	if networkName != UnnamedNetwork {
+		prefix = networkName + "-"
+	}
+	var reservedIPs []net.IP
+	for _, reservation := range reservations.Items {
+		if reservation.Spec.ContainerID != containerID || reservation.Spec.IfName != ifName ||
+			!strings.HasPrefix(reservation.GetName(), prefix) {
+			continue
+		}
+		// the reservations of other networks do not make up valid IPs
+		if ip := net.ParseIP(strings.ReplaceAll(strings.TrimPrefix(reservation.GetName(), prefix), "-", ":")); ip != nil {
+			reservedIPs = append(reservedIPs, ip)
+		}
+	}
+	return reservedIPs, nil
+}
+
+// deallocationRanges narrows the ranges a DEL releases addresses from down to those of the cluster wide reservations of
+// the container, sparing the reads of the IP pools of the other ranges. The reservations being created before the IP
+// pool allocations and deleted after them, no range holding an allocation of the container is left out. All the ranges
+// are returned when fewer reservations than ranges are found, as those predating the ContainerIDLabel, or missing it,
+// are not listed.
+func deallocationRanges(ctx context.Context, ipam *KubernetesIPAM, ipamConf whereaboutstypes.IPAMConfig) []whereaboutstypes.RangeConfiguration {
+	store := &KubernetesOverlappingRangeStore{ipam.client, ipam.namespace}
+	reservedIPs, err := store.ListContainerReservedIPs(ctx, ipam.containerID, ipam.IfName, ipamConf.NetworkName)
+	if err != nil {
+		logging.Debugf("failed to list the cluster wide reservations of container %s, releasing from every range: %v", ipam.containerID, err)
+		return ipamConf.IPRanges
+	}
+	if len(reservedIPs) < len(ipamConf.IPRanges) {
+		logging.Debugf("%d cluster wide reservations of container %s found for %d ranges, releasing from every range",
+			len(reservedIPs), ipam.containerID, len(ipamConf.IPRanges))
+		return ipamConf.IPRanges
+	}
+
+	var ranges []whereaboutstypes.RangeConfiguration
+	for _, ipRange := range ipamConf.IPRanges {
+		for _, ip := range reservedIPs {
+			if ipRange.Contains(ip) {
+				ranges = append(ranges, ipRange)
+				break
+			}
+		}
+	}
+	logging.Debugf("releasing the addresses of container %s from ranges %v", ipam.containerID, ranges)
+	return ranges
+}
+
+// PodDeallocation is the allocation of an interface of a pod, to release from the IP pool holding it
+type PodDeallocation struct {
+	// IPAMConfig is the configuration of the network of the interface, naming the pod
+	IPAMConfig whereaboutstypes.IPAMConfig
+	// Range is the range of the network configuration the IP pool belongs to
+	Range         whereaboutstypes.RangeConfiguration
+	PoolName      string
+	PoolNamespace string
+	ContainerID   string
+	IfName        string
+}
+
+// DeallocateBatch releases the allocations of the interfaces of a pod across all their IP pools in a single leader
+// election, on the lease of the IPAM, rather than one per interface: each IP pool is updated once, dropping all the
+// allocations of the batch it holds, then the cluster wide reservations of the released IPs are deleted. The election
+// is skipped when all the networks of the batch allocate without leader election. It returns the deallocations
+// released, or found released already, along with the first error met; the IP pools are all attempted regardless.
+func DeallocateBatch(ctx context.Context, ipam *KubernetesIPAM, deallocations []PodDeallocation) ([]PodDeallocation, error) {
+	if len(deallocations) == 0 {
+		return nil, nil
+	}
+	var released []PodDeallocation
+	deallocate := func() error {
+		var err error
+		released, err = deallocateBatch(ctx, ipam, deallocations)
+		return err
+	}
+
+	var err error
+	if batchNeedsLeaderElection(deallocations) {
+		err = runAsLeader(ctx, ipam, time.Now(), deallocate)
+	} else {
+		err = deallocate()
+	}
+	if err != nil {
+		metrics.DeallocationFailures.Inc()
+	}
+	return released, err
+}
+
+func batchNeedsLeaderElection(deallocations []PodDeallocation) bool {
+	for _, deallocation := range deallocations {
+		if !optimisticManagement(deallocation.IPAMConfig) {
+			return true
+		}
+	}
+	return false
+}
+
+func deallocateBatch(ctx context.Context, ipam *KubernetesIPAM, deallocations []PodDeallocation) ([]PodDeallocation, error) {
+	logger := logging.FromContext(ctx)
+	var released []PodDeallocation
+	var firstErr error
+	for _, poolDeallocations := range deallocationsByPool(deallocations) {
+		poolReleased, releasedIPs, err := deallocatePool(ctx, ipam, poolDeallocations)
+		released = append(released, poolReleased...)
+		if err != nil {
+			logger.Errorf("failed to release the allocations of IP pool %s/%s: %v", poolDeallocations[0].PoolNamespace, poolDeallocations[0].PoolName, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		// The pool is released before the cluster wide reservations: should we crash in between, the reconciler
+		// removes the reservations left behind.
+		store := &KubernetesOverlappingRangeStore{ipam.client, ipam.namespace}
+		for i, deallocation := range poolDeallocations {
+			if releasedIPs[i] == nil || !deallocation.IPAMConfig.OverlappingRanges {
+				continue
+			}
+			err := store.UpdateOverlappingRangeAllocation(ctx, whereaboutstypes.Deallocate, releasedIPs[i], deallocation.ContainerID,
+				deallocation.IPAMConfig.GetPodRef(), deallocation.IfName, deallocation.IPAMConfig.NetworkName, deallocation.Range.Range)
+			if err != nil && !errors.IsNotFound(err) {
+				logger.Errorf("Error performing UpdateOverlappingRangeAllocation: %v", err)
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+	return released, firstErr
+}
+
+// deallocationsByPool groups the deallocations by IP pool, in the order of the batch
+func deallocationsByPool(deallocations []PodDeallocation) [][]PodDeallocation {
+	var pools [][]PodDeallocation
+	poolIndexes := map[string]int{}
+	for _, deallocation := range deallocations {
+		key := deallocation.PoolNamespace + "/" + deallocation.PoolName
+		index, found := poolIndexes[key]
+		if !found {
+			index = len(pools)
+			poolIndexes[key] = index
+			pools = append(pools, nil)
+		}
+		pools[index] = append(pools[index], deallocation)
+	}
+	return pools
+}
+
+// deallocatePool drops the allocations of the deallocations, all of the same IP pool, in a single update of the pool,
+// retried on the pool read anew when it was updated concurrently. It returns the deallocations released, or found
+// released already, and the IPs released for each of them - nil for those found released already.
+func deallocatePool(ctx context.Context, ipam *KubernetesIPAM, deallocations []PodDeallocation) ([]PodDeallocation, []net.IP, error) {
+	logger := logging.FromContext(ctx)
+	ipamConf := deallocations[0].IPAMConfig
+	retryBackoff := storage.DatastoreRetryBackoff(time.Duration(ipamConf.BackoffBaseMs)*time.Millisecond,
+		time.Duration(ipamConf.BackoffMaxMs)*time.Millisecond)
+	for j := 0; j < storage.DatastoreRetries; j++ {
+		pool, err := ipam.getExistingPool(ctx, deallocations[0].PoolNamespace, deallocations[0].PoolName)
+		if errors.IsNotFound(err) {
+			return deallocations, make([]net.IP, len(deallocations)), nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		reservelist := pool.Allocations()
+		releasedIPs := make([]net.IP, len(deallocations))
+		for i, deallocation := range deallocations {
+			reservelist, releasedIPs[i] = allocate.DeallocateIP(reservelist, deallocation.ContainerID, deallocation.IfName)
+		}
+		pool.SetAllocationStrategy(deallocations[0].Range.AllocationStrategy)
+		if !containsAnyIP(releasedIPs) {
+			logger.Debugf("no allocation of the batch left in IP pool %s", pool.Name())
+			return deallocations, releasedIPs, nil
+		}
+
+		err = pool.Update(ctx, reservelist)
+		if err == nil {
+			return deallocations, releasedIPs, nil
+		}
+		logger.Errorf("IPAM error updating pool (attempt: %d): %v", j, err)
+		if e, ok := err.(storage.Temporary); !ok || !e.Temporary() {
+			return nil, nil, err
+		}
+		if err := waitForRetry(ctx, &retryBackoff); err != nil {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, fmt.Errorf("failed to update IP pool %s/%s after %d attempts", deallocations[0].PoolNamespace,
+		deallocations[0].PoolName, storage.DatastoreRetries)
+}
+
+// getExistingPool reads the IP pool, unlike getPool never creating it
+func (i *KubernetesIPAM) getExistingPool(ctx context.Context, namespace, name string) (*KubernetesIPPool, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	pool, err := i.client.WhereaboutsV1alpha1().IPPools(namespace).Get(ctxWithTimeout, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	firstIP, _, err := pool.ParseCIDR()
+	if err != nil {
+		return nil, err
+	}
+	return &KubernetesIPPool{client: i.client, firstIP: firstIP, pool: pool}, nil
+}
+
+func containsAnyIP(ips []net.IP) bool {
+	for _, ip := range ips {
+		if ip != nil {
+			return true
+		}
+	}
+	return false
+}
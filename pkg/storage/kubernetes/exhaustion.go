@@ -0,0 +1,138 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+const (
+	// NoFreeIPReason is the reason of the Exhausted condition of an IP pool with no free IP left
+	NoFreeIPReason = "NoFreeIP"
+	// AllocationsUpdatedReason is the reason of the Exhausted condition cleared by an update of the allocations
+	AllocationsUpdatedReason = "AllocationsUpdated"
+	// IPPoolExhaustedReason is the reason of the warning event emitted on a pod whose IP pool has no free IP left
+	IPPoolExhaustedReason = "IPPoolExhausted"
+
+	exhaustionEventSource = "whereabouts"
+)
+
+// reportExhaustion sets the Exhausted condition of the pool, and emits a warning event on the pod which got no IP from
+// it: otherwise, the only trace of the failure is the CNI error in the logs of the runtime. The condition is only
+// written when it changes, and the events of the retries of a pod are aggregated into a single one, counting them. Both
+// are best effort, the allocation failing anyway.
+func (i *KubernetesIPAM) reportExhaustion(ctx context.Context, pool *KubernetesIPPool, ipamConf whereaboutstypes.IPAMConfig, cause error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	exhausted := pool.pool.DeepCopy()
+	if meta.SetStatusCondition(&exhausted.Status.Conditions, metav1.Condition{
+		Type:    whereaboutsv1alpha1.IPPoolExhausted,
+		Status:  metav1.ConditionTrue,
+		Reason:  NoFreeIPReason,
+		Message: fmt.Sprintf("IP pool %s has no free IP left: %v", pool.Name(), cause),
+	}) {
+		if _, err := i.client.WhereaboutsV1alpha1().IPPools(pool.Namespace()).Update(ctxWithTimeout, exhausted, metav1.UpdateOptions{}); err != nil {
+			logging.Debugf("failed to set the %s condition of IP pool %s: %v", whereaboutsv1alpha1.IPPoolExhausted, pool.Name(), err)
+		}
+	}
+
+	if i.clientSet == nil || ipamConf.PodName == "" {
+		return
+	}
+	if err := i.emitExhaustionEvent(ctxWithTimeout, pool.Name(), ipamConf,
+		fmt.Sprintf("IP pool %s has no free IP left for pod %s: %v", pool.Name(), ipamConf.GetPodRef(), cause)); err != nil {
+		logging.Debugf("failed to emit the %s event of pod %s: %v", IPPoolExhaustedReason, ipamConf.GetPodRef(), err)
+	}
+}
+
+// emitExhaustionEvent creates the warning event of the pod on the exhausted pool or, once emitted, bumps its count: the
+// event is named after the pod and the pool, for the retries of the runtime not to flood the namespace with events
+func (i *KubernetesIPAM) emitExhaustionEvent(ctx context.Context, poolName string, ipamConf whereaboutstypes.IPAMConfig, message string) error {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(poolName))
+	name := fmt.Sprintf("%s.%x", ipamConf.PodName, hash.Sum32())
+	now := metav1.NewTime(time.Now())
+
+	events := i.clientSet.CoreV1().Events(ipamConf.PodNamespace)
+	event, err := events.Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && event.InvolvedObject.UID == k8stypes.UID(ipamConf.PodUID) {
+		event.Count++
+		event.LastTimestamp = now
+		event.Message = message
+		_, err = events.Update(ctx, event, metav1.UpdateOptions{})
+		return err
+	}
+
+	exhaustionEvent := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ipamConf.PodNamespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Namespace:  ipamConf.PodNamespace,
+			Name:       ipamConf.PodName,
+			UID:        k8stypes.UID(ipamConf.PodUID),
+		},
+		Reason:         IPPoolExhaustedReason,
+		Message:        message,
+		Type:           v1.EventTypeWarning,
+		Source:         v1.EventSource{Component: exhaustionEventSource},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if err == nil {
+		// the event of a previous pod of the same name is taken over
+		exhaustionEvent.ResourceVersion = event.ResourceVersion
+		_, err = events.Update(ctx, exhaustionEvent, metav1.UpdateOptions{})
+		return err
+	}
+	_, err = events.Create(ctx, exhaustionEvent, metav1.CreateOptions{})
+	return err
+}
+
+// clearExhaustion clears the Exhausted condition of the pool once the update of its allocations allocates or releases
+// an IP: the updates leaving the allocations alone do not tell whether an IP is free again
+func clearExhaustion(pool *whereaboutsv1alpha1.IPPool, previous map[string]whereaboutsv1alpha1.IPAllocation) {
+	if !meta.IsStatusConditionTrue(pool.Status.Conditions, whereaboutsv1alpha1.IPPoolExhausted) || !allocationsChanged(previous, pool.Spec.Allocations) {
+		return
+	}
+	meta.SetStatusCondition(&pool.Status.Conditions, metav1.Condition{
+		Type:    whereaboutsv1alpha1.IPPoolExhausted,
+		Status:  metav1.ConditionFalse,
+		Reason:  AllocationsUpdatedReason,
+		Message: fmt.Sprintf("the allocations of IP pool %s were updated since it had no free IP left", pool.GetName()),
+	})
+}
+
+// allocationsChanged tells whether an IP was allocated or released between the previous and the current allocations
+func allocationsChanged(previous, allocations map[string]whereaboutsv1alpha1.IPAllocation) bool {
+	if len(previous) != len(allocations) {
+		return true
+	}
+	for offset, allocation := range allocations {
+		if previousAllocation, found := previous[offset]; !found || previousAllocation.ContainerID != allocation.ContainerID ||
+			previousAllocation.PodRef != allocation.PodRef || previousAllocation.IfName != allocation.IfName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/allocate"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// ClaimFloatingIP allocates an IP of the pool to the floating IP claim of the container ID - the requested IP when
+// set, the first free one otherwise - and assigns it to the pod reference. The IP already allocated to the claim is
+// returned as is. The IPs reserved for network services, and those found used outside of whereabouts, are spared. On
+// networks with overlapping ranges, the IP is reserved cluster wide first, as on allocation, sparing the IPs other
+// ranges of the network allocated.
+func (i *Client) ClaimFloatingIP(ctx context.Context, namespace, poolName, claimID string, requestedIP net.IP, podRef, ifName string) (net.IP, error) {
+	var err error
+	for j := 0; j < i.retries; j++ {
+		var pool *KubernetesIPPool
+		pool, err = i.namedIPPool(ctx, namespace, poolName)
+		if err != nil {
+			return nil, err
+		}
+
+		reservations := pool.Allocations()
+		for _, reservation := range reservations {
+			if reservation.ContainerID == claimID {
+				return reservation.IP, nil
+			}
+		}
+
+		var overlappingRangeStore *KubernetesOverlappingRangeStore
+		var networkName string
+		overlappingRangeStore, networkName, err = i.PoolOverlappingRangeStore(ctx, pool)
+		if err != nil {
+			return nil, err
+		}
+		var reservedElsewhere []whereaboutstypes.IPReservation
+		if overlappingRangeStore != nil {
+			var reservedIPs []net.IP
+			reservedIPs, err = overlappingRangeStore.ListOverlappingRangeReservedIPs(ctx, "", networkName)
+			if err != nil {
+				return nil, err
+			}
+			for _, ip := range reservedIPs {
+				reservedElsewhere = append(reservedElsewhere, whereaboutstypes.IPReservation{IP: ip, IsAllocated: true})
+			}
+		}
+
+		var ip net.IP
+		ip, reservations, err = assignFloatingIP(pool, reservations, reservedElsewhere, requestedIP, claimID, podRef, ifName)
+		if err != nil {
+			return nil, err
+		}
+		if overlappingRangeStore != nil {
+			err = overlappingRangeStore.UpdateOverlappingRangeAllocation(ctx, whereaboutstypes.Allocate, ip, claimID, podRef,
+				ifName, networkName, pool.Range())
+			if k8serrors.IsAlreadyExists(err) {
+				logging.Debugf("IP %s was reserved cluster wide while claiming it for %s, retrying", ip, claimID)
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to reserve IP %s cluster wide: %w", ip, err)
+			}
+		}
+
+		ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+		err = pool.Update(ctxWithTimeout, reservations)
+		cancel()
+		if err == nil {
+			return ip, nil
+		}
+		if overlappingRangeStore != nil {
+			rollbackOverlappingRangeAllocation(ctx, overlappingRangeStore, ip, claimID, podRef, ifName, networkName)
+		}
+		if temporary, ok := err.(storage.Temporary); !ok || !temporary.Temporary() {
+			break
+		}
+		logging.Debugf("IP pool %s changed while claiming a floating IP for %s, retrying: %v", poolName, claimID, err)
+	}
+	return nil, fmt.Errorf("failed to claim a floating IP of IP pool %s: %w", poolName, err)
+}
+
+// ReleaseFloatingIP frees the IP of the pool allocated to the floating IP claim of the container ID, if any, then its
+// cluster wide reservation
+func (i *Client) ReleaseFloatingIP(ctx context.Context, namespace, poolName, claimID string) error {
+	var err error
+	for j := 0; j < i.retries; j++ {
+		var pool *KubernetesIPPool
+		pool, err = i.namedIPPool(ctx, namespace, poolName)
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var kept []whereaboutstypes.IPReservation
+		var released *whereaboutstypes.IPReservation
+		for _, reservation := range pool.Allocations() {
+			if reservation.ContainerID != claimID {
+				kept = append(kept, reservation)
+				continue
+			}
+			reservation := reservation
+			released = &reservation
+		}
+		if released == nil {
+			return nil
+		}
+
+		ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+		err = pool.Update(ctxWithTimeout, kept)
+		cancel()
+		if err == nil {
+			return i.releaseFloatingIPReservation(ctx, pool, *released)
+		}
+		if temporary, ok := err.(storage.Temporary); !ok || !temporary.Temporary() {
+			break
+		}
+		logging.Debugf("IP pool %s changed while releasing the floating IP of %s, retrying: %v", poolName, claimID, err)
+	}
+	return fmt.Errorf("failed to release the floating IP of IP pool %s: %w", poolName, err)
+}
+
+// releaseFloatingIPReservation deletes the cluster wide reservation of the floating IP released, as long as it is held
+// by its claim
+func (i *Client) releaseFloatingIPReservation(ctx context.Context, pool *KubernetesIPPool, released whereaboutstypes.IPReservation) error {
+	overlappingRangeStore, networkName, err := i.PoolOverlappingRangeStore(ctx, pool)
+	if err != nil || overlappingRangeStore == nil {
+		return err
+	}
+	clusterWideIP, err := overlappingRangeStore.GetOverlappingRangeIPReservation(ctx, released.IP, released.PodRef, networkName)
+	if err != nil || clusterWideIP == nil || clusterWideIP.Spec.ContainerID != released.ContainerID {
+		return err
+	}
+	err = overlappingRangeStore.UpdateOverlappingRangeAllocation(ctx, whereaboutstypes.Deallocate, released.IP, released.ContainerID,
+		released.PodRef, released.IfName, networkName, pool.Range())
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to release the cluster wide reservation of floating IP %s: %w", released.IP, err)
+	}
+	return nil
+}
+
+func (i *Client) namedIPPool(ctx context.Context, namespace, name string) (*KubernetesIPPool, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	pool, err := i.client.WhereaboutsV1alpha1().IPPools(namespace).Get(ctxWithTimeout, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	firstIP, _, err := pool.ParseCIDR()
+	if err != nil {
+		return nil, err
+	}
+	return &KubernetesIPPool{client: i.client, firstIP: firstIP, pool: pool}, nil
+}
+
+// assignFloatingIP adds the allocation of the requested IP, or of the first free IP of the usable range of the pool,
+// to the reservations. The IPs reserved elsewhere are spared as well.
+func assignFloatingIP(pool *KubernetesIPPool, reservations, reservedElsewhere []whereaboutstypes.IPReservation, requestedIP net.IP, claimID, podRef, ifName string) (net.IP, []whereaboutstypes.IPReservation, error) {
+	_, ipNet, err := pool.pool.ParseCIDR()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spared := append([]whereaboutstypes.IPReservation{}, reservations...)
+	spared = append(spared, reservedElsewhere...)
+	spared = append(spared, pool.ExternallyUsed(0)...)
+	for _, serviceReservation := range pool.pool.Status.Reservations {
+		spared = append(spared, whereaboutstypes.IPReservation{IP: net.ParseIP(serviceReservation.IP), IsAllocated: true})
+	}
+
+	if requestedIP != nil {
+		if !ipNet.Contains(requestedIP) {
+			return nil, nil, fmt.Errorf("IP %s is not in the range %s of IP pool %s", requestedIP, ipNet, pool.Name())
+		}
+		for _, reservation := range spared {
+			if reservation.IP.Equal(requestedIP) {
+				return nil, nil, fmt.Errorf("IP %s of IP pool %s is already in use", requestedIP, pool.Name())
+			}
+		}
+	}
+
+	ip := requestedIP
+	if ip == nil {
+		ip, _, err = allocate.IterateForAssignment(*ipNet, net.ParseIP(pool.pool.Status.RangeStart), net.ParseIP(pool.pool.Status.RangeEnd),
+			spared, nil, claimID, podRef, "", ifName)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return ip, append(reservations, whereaboutstypes.IPReservation{
+		IP: ip, ContainerID: claimID, PodRef: podRef, IfName: ifName, AllocatedAt: time.Now(),
+	}), nil
+}
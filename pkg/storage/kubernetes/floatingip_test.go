@@ -0,0 +1,130 @@
+package kubernetes
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	fakenadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+func TestFloatingIPClaims(t *testing.T) {
+	const (
+		namespace = "kube-system"
+		poolName  = "10.0.0.0-24"
+	)
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: poolName, Namespace: namespace, ResourceVersion: "1"},
+		Spec: whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{
+			"1": {ContainerID: "container-a", PodRef: "default/pod-a", IfName: "net1"},
+		}},
+		Status: whereaboutsv1alpha1.IPPoolStatus{
+			Reservations: []whereaboutsv1alpha1.ServiceReservation{{Name: "gateway", IP: "10.0.0.2"}},
+			RangeStart:   "10.0.0.1",
+			RangeEnd:     "10.0.0.254",
+		},
+	})
+	client := NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset())
+	claimID := whereaboutstypes.FloatingIPClaimContainerID("default", "vip")
+
+	ip, err := client.ClaimFloatingIP(context.TODO(), namespace, poolName, claimID, nil, "default/active", "net1")
+	if err != nil {
+		t.Fatalf("Expected no error claiming a floating IP, got %v", err)
+	}
+	if !ip.Equal(net.ParseIP("10.0.0.3")) {
+		t.Errorf("Expected the first IP neither allocated nor reserved, 10.0.0.3, got %s", ip)
+	}
+
+	ip, err = client.ClaimFloatingIP(context.TODO(), namespace, poolName, claimID, nil, "default/active", "net1")
+	if err != nil || !ip.Equal(net.ParseIP("10.0.0.3")) {
+		t.Errorf("Expected the IP already claimed, 10.0.0.3, got %s, %v", ip, err)
+	}
+
+	otherClaimID := whereaboutstypes.FloatingIPClaimContainerID("default", "other-vip")
+	if _, err := client.ClaimFloatingIP(context.TODO(), namespace, poolName, otherClaimID, net.ParseIP("10.0.0.2"), "default/active", "net1"); err == nil {
+		t.Errorf("Expected an error claiming the IP reserved for the gateway")
+	}
+	ip, err = client.ClaimFloatingIP(context.TODO(), namespace, poolName, otherClaimID, net.ParseIP("10.0.0.100"), "default/other", "")
+	if err != nil || !ip.Equal(net.ParseIP("10.0.0.100")) {
+		t.Errorf("Expected the requested IP, 10.0.0.100, got %s, %v", ip, err)
+	}
+
+	pool, err := wbClient.WhereaboutsV1alpha1().IPPools(namespace).Get(context.TODO(), poolName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error getting the pool, got %v", err)
+	}
+	claimed := pool.Spec.Allocations["3"]
+	if claimed.ContainerID != claimID || claimed.PodRef != "default/active" || claimed.IfName != "net1" || !whereaboutstypes.IsFloatingIPClaim(claimed.ContainerID) {
+		t.Errorf("Expected 10.0.0.3 to be allocated to the claim, assigned to default/active, got %+v", claimed)
+	}
+
+	if err := client.ReleaseFloatingIP(context.TODO(), namespace, poolName, claimID); err != nil {
+		t.Fatalf("Expected no error releasing the floating IP, got %v", err)
+	}
+	pool, err = wbClient.WhereaboutsV1alpha1().IPPools(namespace).Get(context.TODO(), poolName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error getting the pool, got %v", err)
+	}
+	if _, found := pool.Spec.Allocations["3"]; found || len(pool.Spec.Allocations) != 2 {
+		t.Errorf("Expected only the IP of the claim to be released, got %+v", pool.Spec.Allocations)
+	}
+	if err := client.ReleaseFloatingIP(context.TODO(), namespace, "missing", claimID); err != nil {
+		t.Errorf("Expected no error releasing the floating IP of a missing pool, got %v", err)
+	}
+	if whereaboutstypes.IsFloatingIPClaim("container-a") {
+		t.Errorf("Expected the allocations of the pods not to be floating IP claims")
+	}
+}
+
+func TestFloatingIPClaimsOfOverlappingRanges(t *testing.T) {
+	const (
+		namespace = "kube-system"
+		poolName  = "10.0.0.0-24"
+	)
+	nadClient := fakenadclient.NewSimpleClientset()
+	if _, err := nadClient.K8sCniCncfIoV1().NetworkAttachmentDefinitions("default").Create(context.TODO(), &nadv1.NetworkAttachmentDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "floating", Namespace: "default"},
+		Spec:       nadv1.NetworkAttachmentDefinitionSpec{Config: `{"name": "floating", "ipam": {"type": "whereabouts", "range": "10.0.0.0/24"}}`},
+	}, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	wbClient := fakewbclient.NewSimpleClientset(
+		&whereaboutsv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: poolName, Namespace: namespace, ResourceVersion: "1"},
+			Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+		},
+		// allocated from an overlapping range of the network
+		overlappingRangeIPReservation("10.0.0.1", "default/pod-a"),
+	)
+	client := NewKubernetesClientWithNetAttachDefs(wbClient, fakek8sclient.NewSimpleClientset(), nadClient)
+	claimID := whereaboutstypes.FloatingIPClaimContainerID("default", "vip")
+
+	ip, err := client.ClaimFloatingIP(context.TODO(), namespace, poolName, claimID, nil, "default/active", "net1")
+	if err != nil {
+		t.Fatalf("Expected no error claiming a floating IP, got %v", err)
+	}
+	if !ip.Equal(net.ParseIP("10.0.0.2")) {
+		t.Errorf("Expected the first IP not reserved cluster wide, 10.0.0.2, got %s", ip)
+	}
+	reservation, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).Get(context.TODO(), "10.0.0.2", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the floating IP to be reserved cluster wide, got %v", err)
+	}
+	if reservation.Spec.ContainerID != claimID || reservation.Spec.PodRef != "default/active" {
+		t.Errorf("Expected the cluster wide reservation of the claim, got %+v", reservation.Spec)
+	}
+
+	if err := client.ReleaseFloatingIP(context.TODO(), namespace, poolName, claimID); err != nil {
+		t.Fatalf("Expected no error releasing the floating IP, got %v", err)
+	}
+	if _, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).Get(context.TODO(), "10.0.0.2", metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected the cluster wide reservation of the floating IP to be released")
+	}
+}
@@ -0,0 +1,295 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// The reasons a host-local lease is not migrated
+const (
+	LeaseOutsideRange      = "the IP is outside the range"
+	LeaseWithoutPod        = "no pod lists the IP in its network-status"
+	LeaseAlreadyAllocated  = "the IP is already allocated to the pod"
+	LeaseAllocatedToOther  = "the IP is allocated to another pod"
+	LeaseReservedElsewhere = "the IP is reserved cluster wide for another pod"
+)
+
+// HostLocalLease is an IP leased by the host-local IPAM plugin
+type HostLocalLease struct {
+	IP          net.IP `json:"ip"`
+	ContainerID string `json:"containerID"`
+	// IfName is the interface of the lease, which host-local only records since CNI v0.7
+	IfName string `json:"ifName,omitempty"`
+}
+
+// MigratedLease is a host-local lease, along with the pod it was found allocated to
+type MigratedLease struct {
+	HostLocalLease
+	PodRef string `json:"podRef,omitempty"`
+	// Skipped is the reason the lease was not migrated, if any
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// LeaseMigration is what migrating host-local leases into an IP pool changed, or would change in dry-run mode
+type LeaseMigration struct {
+	Pool      string          `json:"pool"`
+	Namespace string          `json:"namespace"`
+	Leases    []MigratedLease `json:"leases"`
+}
+
+// ReadHostLocalLeases reads the leases of the data directory of a host-local network, e.g. /var/lib/cni/networks/NET:
+// a file per leased IP, named after it, holds the container ID and the interface of the lease on separate lines. The
+// other files, e.g. the lock and the last reserved IPs, are skipped.
+func ReadHostLocalLeases(dir string) ([]HostLocalLease, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the host-local leases: %w", err)
+	}
+
+	var leases []HostLocalLease
+	for _, entry := range entries {
+		ip := net.ParseIP(entry.Name())
+		if entry.IsDir() || ip == nil {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the host-local lease of IP %s: %w", ip, err)
+		}
+		lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+		lease := HostLocalLease{IP: ip, ContainerID: strings.TrimSpace(lines[0])}
+		if len(lines) > 1 {
+			lease.IfName = strings.TrimSpace(lines[1])
+		}
+		leases = append(leases, lease)
+	}
+	sort.Slice(leases, func(i, j int) bool {
+		return string(leases[i].IP.To16()) < string(leases[j].IP.To16())
+	})
+	return leases, nil
+}
+
+// MigrateHostLocalLeases allocates the host-local leases in the IP pool of the range and network, in the given
+// namespace, creating the pool if need be; the leases are reserved cluster wide as well, unless overlapping ranges are
+// disabled. host-local does not record the pods of its leases: each is allocated to the pod listing its IP - on the
+// interface of the lease, if known - in its network-status. The leases no pod lists, those outside the range and
+// those colliding with existing allocations or reservations are skipped. The pool update is guarded by its resource
+// version: on conflict, the migration is attempted anew.
+func (i *Client) MigrateHostLocalLeases(ctx context.Context, namespace string, poolIdentifier PoolIdentifier,
+	leases []HostLocalLease, overlappingRanges, dryRun bool) (*LeaseMigration, error) {
+	_, ipNet, err := net.ParseCIDR(poolIdentifier.IpRange)
+	if err != nil {
+		return nil, fmt.Errorf("invalid range %q: %w", poolIdentifier.IpRange, err)
+	}
+	pods, err := i.ListPods(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the pods: %w", err)
+	}
+	podsByIP := indexPodsByIP(pods)
+
+	for j := 0; j < i.retries; j++ {
+		var pool *KubernetesIPPool
+		pool, err = i.migrationPool(ctx, namespace, poolIdentifier, dryRun)
+		if err != nil {
+			return nil, err
+		}
+
+		migration := &LeaseMigration{Pool: pool.Name(), Namespace: namespace}
+		reservations := pool.Allocations()
+		var migrated []whereaboutstypes.IPReservation
+		for _, lease := range leases {
+			var owner *leaseOwner
+			migratedLease := MigratedLease{HostLocalLease: lease}
+			owner, migratedLease.Skipped, err = i.ownerOfLease(ctx, namespace, poolIdentifier.NetworkName, lease, ipNet, podsByIP,
+				reservations, overlappingRanges)
+			if err != nil {
+				return nil, err
+			}
+			if owner != nil {
+				migratedLease.PodRef = owner.podRef
+			}
+			migration.Leases = append(migration.Leases, migratedLease)
+			if migratedLease.Skipped != "" {
+				continue
+			}
+			migrated = append(migrated, whereaboutstypes.IPReservation{
+				IP:          lease.IP,
+				ContainerID: lease.ContainerID,
+				PodRef:      owner.podRef,
+				PodUID:      owner.podUID,
+				IfName:      lease.IfName,
+				AllocatedAt: time.Now(),
+			})
+		}
+		if dryRun || len(migrated) == 0 {
+			return migration, nil
+		}
+
+		ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+		err = pool.Update(ctxWithTimeout, append(reservations, migrated...))
+		cancel()
+		if err == nil {
+			if overlappingRanges {
+				err = i.reserveMigratedLeases(ctx, namespace, poolIdentifier.NetworkName, migrated)
+			}
+			return migration, err
+		}
+		if temporary, ok := err.(storage.Temporary); !ok || !temporary.Temporary() {
+			break
+		}
+		logging.Debugf("IP pool %s changed while migrating the host-local leases, retrying: %v", pool.Name(), err)
+	}
+	return nil, fmt.Errorf("failed to migrate the host-local leases: %w", err)
+}
+
+// leaseOwner is a pod listing an IP in its network-status
+type leaseOwner struct {
+	podRef string
+	podUID string
+	ifName string
+}
+
+// indexPodsByIP indexes the pods which did not terminate by the IPs their network-status lists
+func indexPodsByIP(pods []v1.Pod) map[string][]leaseOwner {
+	podsByIP := map[string][]leaseOwner{}
+	for _, pod := range pods {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		networkStatus, found := pod.GetAnnotations()[nadv1.NetworkStatusAnnot]
+		if !found {
+			continue
+		}
+		var ifaceStatuses []nadv1.NetworkStatus
+		if err := json.Unmarshal([]byte(networkStatus), &ifaceStatuses); err != nil {
+			logging.Debugf("failed to parse the network-status of pod %s/%s: %v", pod.GetNamespace(), pod.GetName(), err)
+			continue
+		}
+		for _, ifaceStatus := range ifaceStatuses {
+			for _, ipStr := range ifaceStatus.IPs {
+				if ip := net.ParseIP(ipStr); ip != nil {
+					podsByIP[ip.String()] = append(podsByIP[ip.String()], leaseOwner{
+						podRef: fmt.Sprintf("%s/%s", pod.GetNamespace(), pod.GetName()),
+						podUID: string(pod.GetUID()),
+						ifName: ifaceStatus.Interface,
+					})
+				}
+			}
+		}
+	}
+	return podsByIP
+}
+
+// ownerOfLease returns the pod the lease is to be allocated to or, if it is to be skipped, the reason why
+func (i *Client) ownerOfLease(ctx context.Context, namespace, networkName string, lease HostLocalLease, ipNet *net.IPNet,
+	podsByIP map[string][]leaseOwner, reservations []whereaboutstypes.IPReservation, overlappingRanges bool) (*leaseOwner, string, error) {
+	if !ipNet.Contains(lease.IP) {
+		return nil, LeaseOutsideRange, nil
+	}
+
+	var owner *leaseOwner
+	for _, candidate := range podsByIP[lease.IP.String()] {
+		if lease.IfName == "" || candidate.ifName == lease.IfName {
+			candidate := candidate
+			owner = &candidate
+			break
+		}
+	}
+	if owner == nil {
+		return nil, LeaseWithoutPod, nil
+	}
+
+	for _, reservation := range reservations {
+		if !reservation.IP.Equal(lease.IP) {
+			continue
+		}
+		if reservation.PodRef == owner.podRef {
+			return owner, LeaseAlreadyAllocated, nil
+		}
+		return owner, LeaseAllocatedToOther, nil
+	}
+
+	if overlappingRanges {
+		ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+		defer cancel()
+		overlappingStore := &KubernetesOverlappingRangeStore{i.client, namespace}
+		clusterWideIP, err := overlappingStore.GetOverlappingRangeIPReservation(ctxWithTimeout, lease.IP, owner.podRef, networkName)
+		if err != nil {
+			return nil, "", err
+		}
+		if clusterWideIP != nil && clusterWideIP.Spec.PodRef != owner.podRef {
+			return owner, LeaseReservedElsewhere, nil
+		}
+	}
+	return owner, "", nil
+}
+
+// migrationPool returns the IP pool the leases are migrated into, creating it if need be - unless in dry-run mode,
+// an empty pool standing in for it
+func (i *Client) migrationPool(ctx context.Context, namespace string, poolIdentifier PoolIdentifier, dryRun bool) (*KubernetesIPPool, error) {
+	name := IPPoolName(poolIdentifier)
+	pool, err := i.namedIPPool(ctx, namespace, name)
+	if !k8serrors.IsNotFound(err) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve the IP pool %s: %w", name, err)
+		}
+		return pool, nil
+	}
+
+	newPool := &whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: resourceLabels(poolIdentifier.NetworkName, poolIdentifier.IpRange)},
+		Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: poolIdentifier.IpRange, Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+	}
+	if dryRun {
+		firstIP, _, err := newPool.ParseCIDR()
+		if err != nil {
+			return nil, err
+		}
+		return &KubernetesIPPool{client: i.client, firstIP: firstIP, pool: newPool}, nil
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	_, err = i.client.WhereaboutsV1alpha1().IPPools(namespace).Create(ctxWithTimeout, newPool, metav1.CreateOptions{})
+	cancel()
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create the IP pool %s: %w", name, err)
+	}
+	return i.namedIPPool(ctx, namespace, name)
+}
+
+// reserveMigratedLeases reserves the migrated leases cluster wide; the reservations already held by their pods are
+// kept
+func (i *Client) reserveMigratedLeases(ctx context.Context, namespace, networkName string, migrated []whereaboutstypes.IPReservation) error {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	for _, reservation := range migrated {
+		if err := i.CreateOverlappingIP(ctxWithTimeout, namespace, reservation.IP, networkName,
+			whereaboutsv1alpha1.OverlappingRangeIPReservationSpec{
+				ContainerID: reservation.ContainerID,
+				PodRef:      reservation.PodRef,
+				IfName:      reservation.IfName,
+			}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,15 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
-	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
@@ -25,30 +27,98 @@ import (
 	wbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/platform"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/tracing"
 	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
 	"gomodules.xyz/jsonpatch/v2"
 )
 
 const UnnamedNetwork string = ""
 
+// PoolLockedAnnotation, set to "true" on an IP pool by an administrator - e.g. during a maintenance or an incident -
+// makes the allocations from the pool fail right away. Releasing addresses keeps working.
+const PoolLockedAnnotation = "whereabouts.cni.cncf.io/locked"
+
+// GracePeriodAnnotation, set on an IP pool to a duration - e.g. "10m" - overrides how long the IP reconciler spares
+// the allocations of the pool whose pod is not listed among the live pods, from when it first found them orphaned
+const GracePeriodAnnotation = "whereabouts.cni.cncf.io/grace-period"
+
+// VMIdentityLabel is the label of the pods of a KubeVirt virtual machine instance, set to its UID: the allocation of
+// the MAC address of a pod still running is only handed over to another pod of the same virtual machine, e.g. the
+// target of its live migration.
+const VMIdentityLabel = "kubevirt.io/created-by"
+
+// AllocationStrategyAnnotation records on an IP pool the allocation strategy of its range, as of the last ADD or DEL.
+// The pools of the ranges allocated with the lru allocation strategy record the release time of every IP dropped from
+// their allocations, whichever component releases it - a DEL, the reconciler or a garbage collector.
+const AllocationStrategyAnnotation = "whereabouts.cni.cncf.io/allocation-strategy"
+
 // KubernetesIPAM manages ip blocks in an kubernetes CRD backend
 type KubernetesIPAM struct {
 	Client
-	Config      whereaboutstypes.IPAMConfig
-	namespace   string
-	containerID string
-	IfName      string
+	Config whereaboutstypes.IPAMConfig
+	// namespace holds the whereabouts resources but the IP pools: the cluster wide reservations, the leases and the
+	// node slices
+	namespace string
+	// poolNamespace holds the IP pools: the pool_namespace of the network configuration, or else namespace
+	poolNamespace string
+	containerID   string
+	IfName        string
+	// NodeName is the node whose slice the addresses are managed in, when node slices are enabled. Defaults to the
+	// node the process runs on.
+	NodeName string
+	// maxConflicts, when positive, is the number of failed IP pool updates after which the IP management gives up
+	maxConflicts int
+	// ipInUse verifies the IPs are unused before assigning them, when asked to. Defaults to sending them an ICMP echo
+	// request.
+	ipInUse ipInUse
+	// verifiedIPs, set while allocating under the lease, tells whether the IPs verified outside of it are used
+	verifiedIPs map[string]bool
+}
+
+// podRef returns the reference of the pod of the addresses, empty when the network configuration tells none
+func (ipam *KubernetesIPAM) podRef() string {
+	if ipam.Config.PodName == "" {
+		return ""
+	}
+	return ipam.Config.GetPodRef()
 }
 
 func newKubernetesIPAM(containerID, ifName string, ipamConf whereaboutstypes.IPAMConfig, namespace string, kubernetesClient Client) *KubernetesIPAM {
+	kubernetesClient.whereaboutsNamespace = namespace
 	return &KubernetesIPAM{
-		Config:      ipamConf,
-		containerID: containerID,
-		IfName:      ifName,
-		namespace:   namespace,
-		Client:      kubernetesClient,
+		Config:        ipamConf,
+		containerID:   containerID,
+		IfName:        ifName,
+		namespace:     namespace,
+		poolNamespace: poolNamespace(ipamConf, namespace),
+		Client:        kubernetesClient,
+	}
+}
+
+// poolNamespace returns the namespace of the IP pools of the network configuration: its pool_namespace, which takes
+// precedence over the namespace of the whereabouts deployment
+func poolNamespace(ipamConf whereaboutstypes.IPAMConfig, namespace string) string {
+	if ipamConf.PoolNamespace != "" {
+		return ipamConf.PoolNamespace
 	}
+	return namespace
+}
+
+var _ storage.Store = &KubernetesIPAM{}
+
+func init() {
+	storage.Register(storage.DefaultDatastore, func(containerID, ifName string, ipamConf whereaboutstypes.IPAMConfig) (storage.Store, error) {
+		return NewKubernetesIPAM(containerID, ifName, ipamConf)
+	})
+}
+
+// NewKubernetesIPAMWithClient returns a new KubernetesIPAM using the provided client, for the whereabouts resources
+// of the given namespace
+func NewKubernetesIPAMWithClient(containerID, ifName string, ipamConf whereaboutstypes.IPAMConfig, namespace string, kubernetesClient Client) *KubernetesIPAM {
+	return newKubernetesIPAM(containerID, ifName, ipamConf, namespace, kubernetesClient)
 }
 
 // NewKubernetesIPAM returns a new KubernetesIPAM Client configured to a kubernetes CRD backend
@@ -62,7 +132,12 @@ func NewKubernetesIPAM(containerID, ifName string, ipamConf whereaboutstypes.IPA
 		return nil, fmt.Errorf("k8s config: namespace not present in context")
 	}
 
-	kubernetesClient, err := NewClientViaKubeconfig(ipamConf.Kubernetes.KubeConfigPath)
+	config, err := PluginClientConfig(ipamConf.Kubernetes)
+	if err != nil {
+		return nil, fmt.Errorf("failed instantiating kubernetes client: %v", err)
+	}
+	ConfigureUserAgent(config, AllocationUserAgent, RateLimit{})
+	kubernetesClient, err := newClient(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed instantiating kubernetes client: %v", err)
 	}
@@ -77,20 +152,25 @@ func NewKubernetesIPAMWithNamespace(containerID, ifName string, ipamConf whereab
 		return nil, err
 	}
 	k8sIPAM.namespace = namespace
+	k8sIPAM.Client.whereaboutsNamespace = namespace
+	k8sIPAM.poolNamespace = poolNamespace(ipamConf, namespace)
 	return k8sIPAM, nil
 }
 
-type PoolIdentifier struct {
-	IpRange     string
-	NetworkName string
-	NodeName    string
-}
+// PoolIdentifier identifies the IP pool of a range
+type PoolIdentifier = storage.PoolIdentifier
 
 // GetIPPool returns a storage.IPPool for the given range
 func (i *KubernetesIPAM) GetIPPool(ctx context.Context, poolIdentifier PoolIdentifier) (storage.IPPool, error) {
+	return i.getIPPool(ctx, poolIdentifier)
+}
+
+func (i *KubernetesIPAM) getIPPool(ctx context.Context, poolIdentifier PoolIdentifier) (*KubernetesIPPool, error) {
 	name := IPPoolName(poolIdentifier)
 
+	ctx, span := tracing.Start(ctx, "ippool.get", tracing.String("pool", name))
 	pool, err := i.getPool(ctx, name, poolIdentifier.IpRange)
+	span.End(err)
 	if err != nil {
 		return nil, err
 	}
@@ -100,10 +180,14 @@ func (i *KubernetesIPAM) GetIPPool(ctx context.Context, poolIdentifier PoolIdent
 		return nil, err
 	}
 
-	return &KubernetesIPPool{i.client, firstIP, pool}, nil
+	return &KubernetesIPPool{client: i.client, firstIP: firstIP, pool: pool}, nil
 }
 
 func IPPoolName(poolIdentifier PoolIdentifier) string {
+	if poolIdentifier.Shard {
+		// shards are named like node slices, the node name being replaced by the shard tag
+		poolIdentifier.NodeName = shardPoolTag
+	}
 	if poolIdentifier.NodeName != "" {
 		// fast node range naming convention
 		if poolIdentifier.NetworkName == UnnamedNetwork {
@@ -121,6 +205,26 @@ func IPPoolName(poolIdentifier PoolIdentifier) string {
 	}
 }
 
+// checkPoolRange verifies the IP pool records the range it is looked up for: the offsets of its allocations count from
+// the first IP of its recorded range, hence would map to other IPs of a range differing in its first IP or its size
+func checkPoolRange(pool *whereaboutsv1alpha1.IPPool, ipRange string) error {
+	poolIP, poolNet, err := pool.ParseCIDR()
+	if err != nil {
+		return fmt.Errorf("the IP pool %s records the invalid range %q: %v", pool.GetName(), pool.Spec.Range, err)
+	}
+	rangeIP, rangeNet, err := net.ParseCIDR(ipRange)
+	if err != nil {
+		return fmt.Errorf("invalid range %q of the network configuration: %v", ipRange, err)
+	}
+	poolOnes, _ := poolNet.Mask.Size()
+	rangeOnes, _ := rangeNet.Mask.Size()
+	if !poolIP.Equal(rangeIP) || poolOnes != rangeOnes {
+		return fmt.Errorf("the IP pool %s records the range %s, not the range %s of the network configuration: "+
+			"refusing to allocate with strict_range_check", pool.GetName(), pool.Spec.Range, ipRange)
+	}
+	return nil
+}
+
 func normalizeRange(ipRange string) string {
 	// v6 filter
 	if ipRange[len(ipRange)-1] == ':' {
@@ -137,33 +241,48 @@ func (i *KubernetesIPAM) getPool(ctx context.Context, name string, iprange strin
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
 	defer cancel()
 
-	pool, err := i.client.WhereaboutsV1alpha1().IPPools(i.namespace).Get(ctxWithTimeout, name, metav1.GetOptions{})
+	pool, err := i.client.WhereaboutsV1alpha1().IPPools(i.poolNamespace).Get(ctxWithTimeout, name, metav1.GetOptions{})
 	if err != nil && errors.IsNotFound(err) {
 		// pool does not exist, create it
 		newPool := &whereaboutsv1alpha1.IPPool{}
 		newPool.ObjectMeta.Name = name
+		newPool.SetLabels(resourceLabels(i.Config.NetworkName, iprange))
 		newPool.Spec.Range = iprange
 		newPool.Spec.Allocations = make(map[string]whereaboutsv1alpha1.IPAllocation)
-		_, err = i.client.WhereaboutsV1alpha1().IPPools(i.namespace).Create(ctxWithTimeout, newPool, metav1.CreateOptions{})
+		_, err = i.client.WhereaboutsV1alpha1().IPPools(i.poolNamespace).Create(ctxWithTimeout, newPool, metav1.CreateOptions{})
 		if err != nil && errors.IsAlreadyExists(err) {
 			// the pool was just created -- allow retry
 			return nil, &temporaryError{err}
 		} else if err != nil {
-			return nil, fmt.Errorf("k8s create error: %s", err)
+			return nil, fmt.Errorf("k8s create error: %s", i.explainForbidden(err))
 		}
 		// if the pool was created for the first time, trigger another retry of the allocation loop
 		// so all of the metadata / resourceVersions are populated as necessary by the `client.Get` call
 		return nil, &temporaryError{fmt.Errorf("k8s pool initialized")}
 	} else if err != nil {
-		return nil, fmt.Errorf("k8s get error: %s", err)
+		return nil, fmt.Errorf("k8s get error: %s", i.explainForbidden(err))
 	}
 	return pool, nil
 }
 
-// Status tests connectivity to the kubernetes backend
+// explainForbidden points a request denied by the RBAC of the cluster at the namespace of the IP pools: the usual
+// culprit is a pool_namespace whereabouts was not granted access to
+func (i *KubernetesIPAM) explainForbidden(err error) error {
+	if !errors.IsForbidden(err) {
+		return err
+	}
+	return fmt.Errorf("%v: whereabouts is not allowed to manage the IP pools of namespace %q, check the pool_namespace "+
+		"of the network configuration and the RBAC of whereabouts", err, i.poolNamespace)
+}
+
+// Status tests connectivity to the kubernetes backend. A single IP pool is listed: the check runs on every ADD and DEL,
+// and listing all the pools of a large cluster would weigh on the API server.
 func (i *KubernetesIPAM) Status(ctx context.Context) error {
-	_, err := i.client.WhereaboutsV1alpha1().IPPools(i.namespace).List(ctx, metav1.ListOptions{})
-	return err
+	_, err := i.client.WhereaboutsV1alpha1().IPPools(i.poolNamespace).List(ctx, metav1.ListOptions{Limit: 1})
+	if err != nil {
+		return i.explainForbidden(err)
+	}
+	return nil
 }
 
 // Close partially implements the Store interface
@@ -176,6 +295,21 @@ type KubernetesIPPool struct {
 	client  wbclient.Interface
 	firstIP net.IP
 	pool    *whereaboutsv1alpha1.IPPool
+	// serviceReservations, when not nil, replace the pool's status reservations on Update
+	serviceReservations []whereaboutsv1alpha1.ServiceReservation
+	// rangeStart and rangeEnd, when not nil, replace the pool's status usable range on Update
+	rangeStart, rangeEnd net.IP
+	// rangeSet, when not nil, replaces the pool's spec range set on Update
+	rangeSet []string
+	// allocationStrategy, when not nil, replaces the pool's allocation strategy annotation on Update
+	allocationStrategy *string
+	// externallyUsedIPs are added to the pool's status externally used IPs on Update
+	externallyUsedIPs map[string]time.Time
+	// orphanedSince, when not nil, replaces the pool's status orphaned allocations on Update
+	orphanedSince map[string]time.Time
+	// stickyPodRef and stickyIP, when set, are recorded in the pool's status sticky IPs on Update
+	stickyPodRef string
+	stickyIP     net.IP
 }
 
 // Allocations returns the initially retrieved set of allocations for this pool
@@ -183,8 +317,155 @@ func (p *KubernetesIPPool) Allocations() []whereaboutstypes.IPReservation {
 	return toIPReservationList(p.pool.Spec.Allocations, p.firstIP)
 }
 
+// Name returns the name of the pool
+func (p *KubernetesIPPool) Name() string {
+	return p.pool.GetName()
+}
+
+// Namespace returns the namespace of the pool
+func (p *KubernetesIPPool) Namespace() string {
+	return p.pool.GetNamespace()
+}
+
+// Annotations returns the annotations of the pool as retrieved
+func (p *KubernetesIPPool) Annotations() map[string]string {
+	return p.pool.GetAnnotations()
+}
+
+// Range returns the range of the pool
+func (p *KubernetesIPPool) Range() string {
+	return p.pool.Spec.Range
+}
+
+// ResourceVersion returns the resource version of the pool as retrieved
+func (p *KubernetesIPPool) ResourceVersion() string {
+	return p.pool.GetResourceVersion()
+}
+
+// SetServiceReservations sets the network service reservations to be recorded in the pool status on the next Update
+func (p *KubernetesIPPool) SetServiceReservations(reservations []whereaboutsv1alpha1.ServiceReservation) {
+	p.serviceReservations = reservations
+}
+
+// SetUsableRange sets the first and last IPs of the range which can be allocated, to be recorded in the pool status on
+// the next Update
+func (p *KubernetesIPPool) SetUsableRange(rangeStart, rangeEnd net.IP) {
+	p.rangeStart = rangeStart
+	p.rangeEnd = rangeEnd
+}
+
+// SetRangeSet sets the CIDRs of the range set the pool allocates from, none when the range is not a range set, to be
+// recorded in the pool spec on the next Update
+func (p *KubernetesIPPool) SetRangeSet(rangeSet []string) {
+	p.rangeSet = append([]string{}, rangeSet...)
+}
+
+// ReleaseTimes returns the times the free IPs of the pool were last released, keyed by IP
+func (p *KubernetesIPPool) ReleaseTimes() map[string]time.Time {
+	releaseTimes := map[string]time.Time{}
+	for offset, releaseTime := range p.pool.Status.Released {
+		numOffset, err := strconv.ParseUint(offset, 10, 64)
+		if err != nil {
+			logging.Errorf("Error decoding released ip offset (backend: kubernetes): %v", err)
+			continue
+		}
+		releaseTimes[iphelpers.IPAddOffset(p.firstIP, numOffset).String()] = releaseTime.Time
+	}
+	return releaseTimes
+}
+
+// SetAllocationStrategy sets the allocation strategy of the range of the pool, to be recorded in the pool annotations
+// on the next Update. The IPs released by that Update and the following ones have their release time recorded when the
+// strategy is lru.
+func (p *KubernetesIPPool) SetAllocationStrategy(strategy string) {
+	p.allocationStrategy = &strategy
+}
+
+// ExternallyUsed returns the IPs of the pool found used outside of whereabouts, as reservations which are not recorded
+// as allocations on Update. With a quarantine, only the IPs found used within the quarantine are returned: the others
+// are verified anew before being assigned.
+func (p *KubernetesIPPool) ExternallyUsed(quarantine time.Duration) []whereaboutstypes.IPReservation {
+	var externallyUsed []whereaboutstypes.IPReservation
+	for offset, detectionTime := range p.pool.Status.ExternallyUsed {
+		if quarantine > 0 && time.Since(detectionTime.Time) >= quarantine {
+			continue
+		}
+		numOffset, err := strconv.ParseUint(offset, 10, 64)
+		if err != nil {
+			logging.Errorf("Error decoding externally used ip offset (backend: kubernetes): %v", err)
+			continue
+		}
+		externallyUsed = append(externallyUsed, whereaboutstypes.IPReservation{
+			IP:          iphelpers.IPAddOffset(p.firstIP, numOffset),
+			IsAllocated: true,
+		})
+	}
+	return externallyUsed
+}
+
+// SetExternallyUsed sets the IP found used outside of whereabouts at the given time, to be recorded in the pool status
+// on the next Update
+func (p *KubernetesIPPool) SetExternallyUsed(ip net.IP, detectionTime time.Time) {
+	if p.externallyUsedIPs == nil {
+		p.externallyUsedIPs = map[string]time.Time{}
+	}
+	p.externallyUsedIPs[ip.String()] = detectionTime
+}
+
+// OrphanedSince returns the times the IP reconciler first found the allocations of the pool orphaned, keyed by IP
+func (p *KubernetesIPPool) OrphanedSince() map[string]time.Time {
+	orphanedSince := map[string]time.Time{}
+	for offset, since := range p.pool.Status.Orphaned {
+		numOffset, err := strconv.ParseUint(offset, 10, 64)
+		if err != nil {
+			logging.Errorf("Error decoding orphaned ip offset (backend: kubernetes): %v", err)
+			continue
+		}
+		orphanedSince[iphelpers.IPAddOffset(p.firstIP, numOffset).String()] = since.Time
+	}
+	return orphanedSince
+}
+
+// SetOrphanedSince sets the times the allocations of the pool were first found orphaned, keyed by IP, to replace those
+// recorded in the pool status on the next Update. The times of the IPs released, or allocated anew, by an Update are
+// dropped.
+func (p *KubernetesIPPool) SetOrphanedSince(orphanedSince map[string]time.Time) {
+	p.orphanedSince = orphanedSince
+	if p.orphanedSince == nil {
+		p.orphanedSince = map[string]time.Time{}
+	}
+}
+
+// StickyIP returns the IP last allocated to the pod, when recorded
+func (p *KubernetesIPPool) StickyIP(podRef string) net.IP {
+	offset, recorded := p.pool.Status.StickyIPs[podRef]
+	if !recorded {
+		return nil
+	}
+	numOffset, err := strconv.ParseUint(offset, 10, 64)
+	if err != nil {
+		logging.Errorf("Error decoding sticky ip offset (backend: kubernetes): %v", err)
+		return nil
+	}
+	return iphelpers.IPAddOffset(p.firstIP, numOffset)
+}
+
+// SetStickyIP sets the IP allocated to the pod, to be recorded in the pool status on the next Update. The IP is no
+// longer sticky to the pods it was previously allocated to.
+func (p *KubernetesIPPool) SetStickyIP(podRef string, ip net.IP) {
+	p.stickyPodRef = podRef
+	p.stickyIP = ip
+}
+
 // Update sets the pool allocated IP list to the given IP reservations
 func (p *KubernetesIPPool) Update(ctx context.Context, reservations []whereaboutstypes.IPReservation) error {
+	ctx, span := tracing.Start(ctx, "ippool.update", tracing.String("pool", p.Name()), tracing.Int("allocations", len(reservations)))
+	err := p.update(ctx, reservations)
+	span.End(err)
+	return err
+}
+
+func (p *KubernetesIPPool) update(ctx context.Context, reservations []whereaboutstypes.IPReservation) error {
 	// marshal the current pool to serve as the base for the patch creation
 	orig := p.pool.DeepCopy()
 	origBytes, err := json.Marshal(orig)
@@ -198,6 +479,47 @@ func (p *KubernetesIPPool) Update(ctx context.Context, reservations []whereabout
 		return err
 	}
 	p.pool.Spec.Allocations = allocations
+	if p.serviceReservations != nil {
+		p.pool.Status.Reservations = p.serviceReservations
+	}
+	if p.rangeStart != nil && p.rangeEnd != nil {
+		p.pool.Status.RangeStart = p.rangeStart.String()
+		p.pool.Status.RangeEnd = p.rangeEnd.String()
+	}
+	if p.rangeSet != nil {
+		p.pool.Spec.RangeSet = p.rangeSet
+		if len(p.rangeSet) == 0 {
+			p.pool.Spec.RangeSet = nil
+		}
+	}
+	p.updateAllocationStrategy()
+	p.updateReleased(orig.Spec.Allocations, allocations)
+	for ip, detectionTime := range p.externallyUsedIPs {
+		offset, err := iphelpers.IPGetOffset(net.ParseIP(ip), p.firstIP)
+		if err != nil {
+			return err
+		}
+		if p.pool.Status.ExternallyUsed == nil {
+			p.pool.Status.ExternallyUsed = map[string]metav1.Time{}
+		}
+		p.pool.Status.ExternallyUsed[fmt.Sprintf("%d", offset)] = metav1.NewTime(detectionTime)
+	}
+	// the IPs assigned once out of quarantine are no longer used outside of whereabouts
+	for offset := range p.pool.Status.ExternallyUsed {
+		if _, allocated := allocations[offset]; allocated {
+			delete(p.pool.Status.ExternallyUsed, offset)
+		}
+	}
+	if len(p.pool.Status.ExternallyUsed) == 0 {
+		p.pool.Status.ExternallyUsed = nil
+	}
+	if err := p.updateOrphaned(orig.Spec.Allocations, allocations); err != nil {
+		return err
+	}
+	if err := p.updateStickyIPs(); err != nil {
+		return err
+	}
+	clearExhaustion(p.pool, orig.Spec.Allocations)
 	modBytes, err := json.Marshal(p.pool)
 	if err != nil {
 		return err
@@ -240,6 +562,100 @@ func (p *KubernetesIPPool) Update(ctx context.Context, reservations []whereabout
 	return nil
 }
 
+// updateAllocationStrategy records the allocation strategy set in the pool annotations, only the lru one being
+// recorded
+func (p *KubernetesIPPool) updateAllocationStrategy() {
+	if p.allocationStrategy == nil {
+		return
+	}
+	annotations := p.pool.GetAnnotations()
+	if *p.allocationStrategy != whereaboutstypes.LRUAllocation {
+		delete(annotations, AllocationStrategyAnnotation)
+		return
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[AllocationStrategyAnnotation] = *p.allocationStrategy
+	p.pool.SetAnnotations(annotations)
+}
+
+// updateReleased records in the pool status the release of the previous allocations no longer allocated, when the
+// range of the pool is allocated with the lru allocation strategy, and drops the release times of the allocated IPs
+func (p *KubernetesIPPool) updateReleased(previous, allocations map[string]whereaboutsv1alpha1.IPAllocation) {
+	if p.pool.GetAnnotations()[AllocationStrategyAnnotation] == whereaboutstypes.LRUAllocation {
+		releaseTime := metav1.Now()
+		for offset := range previous {
+			if _, allocated := allocations[offset]; allocated {
+				continue
+			}
+			if p.pool.Status.Released == nil {
+				p.pool.Status.Released = map[string]metav1.Time{}
+			}
+			p.pool.Status.Released[offset] = releaseTime
+		}
+	}
+	for offset := range p.pool.Status.Released {
+		if _, allocated := allocations[offset]; allocated {
+			delete(p.pool.Status.Released, offset)
+		}
+	}
+	if len(p.pool.Status.Released) == 0 {
+		p.pool.Status.Released = nil
+	}
+}
+
+// updateOrphaned records in the pool status the times the allocations were first found orphaned, when set, and drops
+// those of the IPs no longer allocated to the same container
+func (p *KubernetesIPPool) updateOrphaned(previous, allocations map[string]whereaboutsv1alpha1.IPAllocation) error {
+	if p.orphanedSince != nil {
+		p.pool.Status.Orphaned = nil
+		for ip, since := range p.orphanedSince {
+			offset, err := iphelpers.IPGetOffset(net.ParseIP(ip), p.firstIP)
+			if err != nil {
+				return err
+			}
+			if p.pool.Status.Orphaned == nil {
+				p.pool.Status.Orphaned = map[string]metav1.Time{}
+			}
+			p.pool.Status.Orphaned[fmt.Sprintf("%d", offset)] = metav1.NewTime(since)
+		}
+	}
+	for offset := range p.pool.Status.Orphaned {
+		allocation, allocated := allocations[offset]
+		if !allocated || allocation.ContainerID != previous[offset].ContainerID || allocation.PodRef != previous[offset].PodRef {
+			delete(p.pool.Status.Orphaned, offset)
+		}
+	}
+	if len(p.pool.Status.Orphaned) == 0 {
+		p.pool.Status.Orphaned = nil
+	}
+	return nil
+}
+
+// updateStickyIPs records the IP allocated to the sticky pod in the pool status, dropping the pods the IP was
+// previously sticky to
+func (p *KubernetesIPPool) updateStickyIPs() error {
+	if p.stickyIP == nil {
+		return nil
+	}
+	offset, err := iphelpers.IPGetOffset(p.stickyIP, p.firstIP)
+	if err != nil {
+		return err
+	}
+	stickyOffset := fmt.Sprintf("%d", offset)
+	for podRef, podOffset := range p.pool.Status.StickyIPs {
+		if podOffset == stickyOffset {
+			delete(p.pool.Status.StickyIPs, podRef)
+		}
+	}
+	if p.pool.Status.StickyIPs == nil {
+		p.pool.Status.StickyIPs = map[string]string{}
+	}
+	p.pool.Status.StickyIPs[p.stickyPodRef] = stickyOffset
+	return nil
+}
+
 func toIPReservationList(allocations map[string]whereaboutsv1alpha1.IPAllocation, firstip net.IP) []whereaboutstypes.IPReservation {
 	reservelist := []whereaboutstypes.IPReservation{}
 	for offset, a := range allocations {
@@ -251,7 +667,11 @@ func toIPReservationList(allocations map[string]whereaboutsv1alpha1.IPAllocation
 			continue
 		}
 		ip := iphelpers.IPAddOffset(firstip, uint64(numOffset))
-		reservelist = append(reservelist, whereaboutstypes.IPReservation{IP: ip, ContainerID: a.ContainerID, PodRef: a.PodRef, IfName: a.IfName})
+		reservation := whereaboutstypes.IPReservation{IP: ip, ContainerID: a.ContainerID, PodRef: a.PodRef, PodUID: a.PodUID, IfName: a.IfName, MAC: a.MAC}
+		if a.AllocatedAt != nil {
+			reservation.AllocatedAt = a.AllocatedAt.Time
+		}
+		reservelist = append(reservelist, reservation)
 	}
 	return reservelist
 }
@@ -263,7 +683,11 @@ func toAllocationMap(reservelist []whereaboutstypes.IPReservation, firstip net.I
 		if err != nil {
 			return nil, err
 		}
-		allocations[fmt.Sprintf("%d", index)] = whereaboutsv1alpha1.IPAllocation{ContainerID: r.ContainerID, PodRef: r.PodRef, IfName: r.IfName}
+		allocation := whereaboutsv1alpha1.IPAllocation{ContainerID: r.ContainerID, PodRef: r.PodRef, PodUID: r.PodUID, IfName: r.IfName, MAC: r.MAC}
+		if !r.AllocatedAt.IsZero() {
+			allocation.AllocatedAt = &metav1.Time{Time: r.AllocatedAt}
+		}
+		allocations[fmt.Sprintf("%d", index)] = allocation
 	}
 	return allocations, nil
 }
@@ -289,11 +713,15 @@ func (c *KubernetesOverlappingRangeStore) GetOverlappingRangeIPReservation(ctx c
 	logging.Debugf("Get overlappingRangewide allocation; normalized IP: %q, IP: %q, networkName: %q",
 		normalizedIP, ip, networkName)
 
+	ctx, span := tracing.Start(ctx, "overlappingrange.get", tracing.String("reservation", normalizedIP))
 	r, err := c.client.WhereaboutsV1alpha1().OverlappingRangeIPReservations(c.namespace).Get(ctx, normalizedIP, metav1.GetOptions{})
 	if err != nil && errors.IsNotFound(err) {
+		span.End(nil)
 		// cluster ip reservation does not exist, this appears to be good news.
 		return nil, nil
-	} else if err != nil {
+	}
+	span.End(err)
+	if err != nil {
 		logging.Errorf("k8s get OverlappingRangeIPReservation error: %s", err)
 		return nil, fmt.Errorf("k8s get OverlappingRangeIPReservation error: %s", err)
 	}
@@ -303,9 +731,40 @@ func (c *KubernetesOverlappingRangeStore) GetOverlappingRangeIPReservation(ctx c
 	return r, nil
 }
 
-// UpdateOverlappingRangeAllocation updates clusterwide allocation for overlapping ranges.
+// ListOverlappingRangeReservedIPs lists in one pass the IPs of the network reserved cluster wide by pods other than
+// podRef, whose reservation names hold the IPs - prefixed by the network name on named networks.
+func (c *KubernetesOverlappingRangeStore) ListOverlappingRangeReservedIPs(ctx context.Context, podRef, networkName string) ([]net.IP, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, listRequestTimeout)
+	defer cancel()
+
+	prefix := ""
+	if networkName != UnnamedNetwork {
+		prefix = networkName + "-"
+	}
+	var reservedIPs []net.IP
+	err := eachListItem(ctxWithTimeout, func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		return c.client.WhereaboutsV1alpha1().OverlappingRangeIPReservations(c.namespace).List(ctx, opts)
+	}, metav1.ListOptions{}, func(obj runtime.Object) error {
+		reservation := obj.(*whereaboutsv1alpha1.OverlappingRangeIPReservation)
+		if reservation.Spec.PodRef == podRef || !strings.HasPrefix(reservation.GetName(), prefix) {
+			return nil
+		}
+		// the reservations of other networks do not make up valid IPs
+		if ip := net.ParseIP(strings.ReplaceAll(strings.TrimPrefix(reservation.GetName(), prefix), "-", ":")); ip != nil {
+			reservedIPs = append(reservedIPs, ip)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("k8s list OverlappingRangeIPReservation error: %s", err)
+	}
+	return reservedIPs, nil
+}
+
+// UpdateOverlappingRangeAllocation updates clusterwide allocation for overlapping ranges. The reservations are labelled
+// with the network name and the range of the IP on allocation.
 func (c *KubernetesOverlappingRangeStore) UpdateOverlappingRangeAllocation(ctx context.Context, mode int, ip net.IP,
-	podRef, ifName, networkName string) error {
+	containerID, podRef, ifName, networkName, ipRange string) error {
 	normalizedIP := NormalizeIP(ip, networkName)
 
 	clusteripres := &whereaboutsv1alpha1.OverlappingRangeIPReservation{
@@ -314,14 +773,20 @@ func (c *KubernetesOverlappingRangeStore) UpdateOverlappingRangeAllocation(ctx c
 
 	var err error
 	var verb string
+	ctx, span := tracing.Start(ctx, "overlappingrange.update", tracing.String("reservation", normalizedIP), tracing.String("mode", whereaboutstypes.ModeName(mode)))
+	defer func() { span.End(err) }()
 	switch mode {
 	case whereaboutstypes.Allocate:
 		// Put together our cluster ip reservation
 		verb = "allocate"
 
+		reservationLabels := resourceLabels(networkName, ipRange)
+		reservationLabels[ContainerIDLabel] = containerIDLabelValue(containerID)
+		clusteripres.SetLabels(reservationLabels)
 		clusteripres.Spec = whereaboutsv1alpha1.OverlappingRangeIPReservationSpec{
-			PodRef: podRef,
-			IfName: ifName,
+			ContainerID: containerID,
+			PodRef:      podRef,
+			IfName:      ifName,
 		}
 
 		_, err = c.client.WhereaboutsV1alpha1().OverlappingRangeIPReservations(c.namespace).Create(
@@ -355,59 +820,51 @@ func NormalizeIP(ip net.IP, networkName string) string {
 	return normalizedIP
 }
 
-// TODO: what's the best way to discover the node name? this should work in both controller pod and whereabouts host process
-func getNodeName() (string, error) {
-	envName := os.Getenv("NODENAME")
-	if envName != "" {
-		return strings.TrimSpace(envName), nil
-	}
-	file, err := os.Open("/etc/hostname")
+// newLeaderElector creates a new leaderelection.LeaderElector and associated
+// channels by which to observe elections and depositions.
+func newLeaderElector(ctx context.Context, clientset kubernetes.Interface, namespace string, ipamConf *KubernetesIPAM) (*leaderelection.LeaderElector, chan struct{}, chan struct{}) {
+	lease, err := leaseName(ctx, ipamConf)
 	if err != nil {
-		logging.Errorf("Error opening file /etc/hostname: %v", err)
-		return "", err
+		logging.Errorf("Failed to create leader elector: %v", err)
+		return nil, make(chan struct{}), make(chan struct{})
 	}
-	defer file.Close()
-
-	// Read the contents of the file
-	data := make([]byte, 1024) // Adjust the buffer size as needed
-	n, err := file.Read(data)
+	identity := fmt.Sprintf("%s/%s", ipamConf.Config.PodNamespace, ipamConf.Config.PodName)
+	le, leaderOK, deposed, err := newLeaseElector(clientset, namespace, lease, identity, ipamConf.Config)
 	if err != nil {
-		logging.Errorf("Error reading file /etc/hostname: %v", err)
+		logging.Errorf("Failed to create leader elector: %v", err)
+		return nil, leaderOK, deposed
 	}
-
-	// Convert bytes to string
-	hostname := string(data[:n])
-	hostname = strings.TrimSpace(hostname)
-	logging.Debugf("discovered current hostname as: %s", hostname)
-	return hostname, nil
+	return le, leaderOK, deposed
 }
 
-// newLeaderElector creates a new leaderelection.LeaderElector and associated
-// channels by which to observe elections and depositions.
-func newLeaderElector(ctx context.Context, clientset kubernetes.Interface, namespace string, ipamConf *KubernetesIPAM) (*leaderelection.LeaderElector, chan struct{}, chan struct{}) {
-	//log.WithField("context", "leaderelection")
-	// leaderOK will block gRPC startup until it's closed.
-	leaderOK := make(chan struct{})
-	// deposed is closed by the leader election callback when
-	// we are deposed as leader so that we can clean up.
-	deposed := make(chan struct{})
-
+// leaseName returns the name of the lease the IPAM elects a leader on: the whereabouts lease, or the one of the node
+// slice of its network
+func leaseName(ctx context.Context, ipamConf *KubernetesIPAM) (string, error) {
 	leaseName := "whereabouts"
 	if ipamConf.Config.NodeSliceSize != "" {
 		// we lock per IP Pool so just use the pool name for the lease name
-		hostname, err := getNodeName()
+		hostname, err := ipamConf.nodeName()
 		if err != nil {
-			logging.Errorf("Failed to create leader elector: %v", err)
-			return nil, leaderOK, deposed
+			return "", err
 		}
 		nodeSliceRange, err := GetNodeSlicePoolRange(ctx, ipamConf, hostname)
 		if err != nil {
-			logging.Errorf("Failed to create leader elector: %v", err)
-			return nil, leaderOK, deposed
+			return "", err
 		}
 		leaseName = IPPoolName(PoolIdentifier{IpRange: nodeSliceRange, NodeName: hostname, NetworkName: ipamConf.Config.NetworkName})
 	}
 	logging.Debugf("using lease with name: %v", leaseName)
+	return leaseName, nil
+}
+
+// newLeaseElector creates the leader elector of the lease, campaigning under the identity with the timings of the
+// configuration, and the channels closed once elected and once deposed
+func newLeaseElector(clientset kubernetes.Interface, namespace, leaseName, identity string, conf whereaboutstypes.IPAMConfig) (*leaderelection.LeaderElector, chan struct{}, chan struct{}, error) {
+	// leaderOK will block gRPC startup until it's closed.
+	leaderOK := make(chan struct{})
+	// deposed is closed by the leader election callback when
+	// we are deposed as leader so that we can clean up.
+	deposed := make(chan struct{})
 
 	var rl = &resourcelock.LeaseLock{
 		LeaseMeta: metav1.ObjectMeta{
@@ -416,7 +873,7 @@ func newLeaderElector(ctx context.Context, clientset kubernetes.Interface, names
 		},
 		Client: clientset.CoordinationV1(),
 		LockConfig: resourcelock.ResourceLockConfig{
-			Identity: fmt.Sprintf("%s/%s", ipamConf.Config.PodNamespace, ipamConf.Config.PodName),
+			Identity: identity,
 		},
 	}
 
@@ -424,9 +881,9 @@ func newLeaderElector(ctx context.Context, clientset kubernetes.Interface, names
 	// !bang
 	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
 		Lock:            rl,
-		LeaseDuration:   time.Duration(ipamConf.Config.LeaderLeaseDuration) * time.Millisecond,
-		RenewDeadline:   time.Duration(ipamConf.Config.LeaderRenewDeadline) * time.Millisecond,
-		RetryPeriod:     time.Duration(ipamConf.Config.LeaderRetryPeriod) * time.Millisecond,
+		LeaseDuration:   time.Duration(conf.LeaderLeaseDuration) * time.Millisecond,
+		RenewDeadline:   time.Duration(conf.LeaderRenewDeadline) * time.Millisecond,
+		RetryPeriod:     time.Duration(conf.LeaderRetryPeriod) * time.Millisecond,
 		ReleaseOnCancel: true,
 		Callbacks: leaderelection.LeaderCallbacks{
 			OnStartedLeading: func(_ context.Context) {
@@ -442,21 +899,74 @@ func newLeaderElector(ctx context.Context, clientset kubernetes.Interface, names
 		},
 	})
 	if err != nil {
-		logging.Errorf("Failed to create leader elector: %v", err)
-		return nil, leaderOK, deposed
+		return nil, leaderOK, deposed, err
 	}
-	return le, leaderOK, deposed
+	return le, leaderOK, deposed, nil
 }
 
 // IPManagement manages ip allocation and deallocation from a storage perspective
 func IPManagement(ctx context.Context, mode int, ipamConf whereaboutstypes.IPAMConfig, client *KubernetesIPAM) ([]net.IPNet, error) {
 	var newips []net.IPNet
+	logger := logging.FromContext(ctx)
 
 	if ipamConf.PodName == "" {
 		return newips, fmt.Errorf("IPAM client initialization error: no pod name")
 	}
 
-	// setup leader election
+	start := time.Now()
+	if mode == whereaboutstypes.Allocate {
+		defer metrics.AllocationDuration.ObserveSince(start)
+	}
+
+	if optimisticManagement(ipamConf) {
+		optimisticCtx, span := tracing.Start(ctx, "ipam.optimistic")
+		newips, err := optimisticIPManagement(optimisticCtx, mode, ipamConf, client)
+		span.End(err)
+		if !isTooManyConflicts(err) {
+			if mode == whereaboutstypes.Deallocate && err != nil {
+				metrics.DeallocationFailures.Inc()
+			}
+			logger.Debugf("IPManagement: %v, %v", newips, err)
+			return newips, err
+		}
+		logger.Debugf("Falling back to leader election: %v", err)
+	}
+
+	err := client.verifyingOutsideLease(ctx, mode, ipamConf, func() error {
+		return runAsLeader(ctx, client, start, func() error {
+			var err error
+			newips, err = IPManagementKubernetesUpdate(ctx, mode, client, ipamConf)
+			return err
+		})
+	})
+	if mode == whereaboutstypes.Deallocate && err != nil {
+		metrics.DeallocationFailures.Inc()
+	}
+	logger.Debugf("IPManagement: %v, %v", newips, err)
+	return newips, err
+}
+
+// runAsLeader runs fn once elected leader of the lease of the IPAM - the whereabouts lease, or the one of the node slice
+// of its network - and steps down right after, unless the client shares its leader elections among its requests. It
+// fails once the context is done while waiting to be elected; when deposed before being elected, fn is not run and no
+// error is returned. The wait is measured since start.
+func runAsLeader(ctx context.Context, client *KubernetesIPAM, start time.Time, fn func() error) error {
+	logger := logging.FromContext(ctx)
+	_, electionSpan := tracing.Start(ctx, "leaderelection.wait")
+	if client.leaderElections != nil {
+		var elected bool
+		err := client.leaderElections.run(ctx, client, func() error {
+			elected = true
+			logger.Debugf("Elected as leader of the shared election, do processing")
+			metrics.LeaderElectionWait.ObserveSince(start)
+			electionSpan.End(nil)
+			return fn()
+		})
+		if !elected {
+			electionSpan.End(err)
+		}
+		return err
+	}
 	le, leader, deposed := newLeaderElector(ctx, client.clientSet, client.namespace, client)
 	var wg sync.WaitGroup
 	wg.Add(2)
@@ -471,15 +981,19 @@ func IPManagement(ctx context.Context, mode int, ipamConf whereaboutstypes.IPAMC
 			select {
 			case <-ctx.Done():
 				err = fmt.Errorf("time limit exceeded while waiting to become leader")
+				electionSpan.End(err)
 				stopM <- struct{}{}
 				return
 			case <-leader:
-				logging.Debugf("Elected as leader, do processing")
-				newips, err = IPManagementKubernetesUpdate(ctx, mode, client, ipamConf)
+				logger.Debugf("Elected as leader, do processing")
+				metrics.LeaderElectionWait.ObserveSince(start)
+				electionSpan.End(nil)
+				err = fn()
 				stopM <- struct{}{}
 				return
 			case <-deposed:
-				logging.Debugf("Deposed as leader, shutting down")
+				logger.Debugf("Deposed as leader, shutting down")
+				electionSpan.End(nil)
 				result <- nil
 				return
 			}
@@ -489,12 +1003,12 @@ func IPManagement(ctx context.Context, mode int, ipamConf whereaboutstypes.IPAMC
 	go func() {
 		defer wg.Done()
 		res := make(chan error)
-		leCtx, leCancel := context.WithCancel(context.Background())
+		leCtx, leCancel := context.WithCancel(ctx)
 
 		go func() {
-			logging.Debugf("Started leader election")
+			logger.Debugf("Started leader election")
 			le.Run(leCtx)
-			logging.Debugf("Finished leader election")
+			logger.Debugf("Finished leader election")
 			res <- nil
 		}()
 
@@ -506,27 +1020,69 @@ func IPManagement(ctx context.Context, mode int, ipamConf whereaboutstypes.IPAMC
 	}()
 	wg.Wait()
 	close(stopM)
-	logging.Debugf("IPManagement: %v, %v", newips, err)
-	return newips, err
+	return err
 }
 
+// GetNodeSlicePoolRange returns the slice of the first range of the network assigned to the node
 func GetNodeSlicePoolRange(ctx context.Context, ipam *KubernetesIPAM, nodeName string) (string, error) {
+	return GetNodeSliceOfRange(ctx, ipam, nodeName, "")
+}
+
+// GetNodeSliceOfRange returns the slice of the given range of the network assigned to the node - the first range of the
+// network when empty. A dual-stack network assigns each node a slice of the range of each IP family.
+func GetNodeSliceOfRange(ctx context.Context, ipam *KubernetesIPAM, nodeName, ipRange string) (string, error) {
 	logging.Debugf("ipam namespace is %v", ipam.namespace)
-	nodeSlice, err := ipam.client.WhereaboutsV1alpha1().NodeSlicePools(ipam.namespace).Get(ctx, getNodeSliceName(ipam), metav1.GetOptions{})
+	nodeSlice, err := ipam.getNodeSlice(ctx, getNodeSliceName(ipam))
 	if err != nil {
 		logging.Errorf("error getting node slice %s/%s %v", ipam.namespace, getNodeSliceName(ipam), err)
 		return "", err
 	}
+	additionalRangeIndex := -1
+	if ipRange != "" && ipRange != nodeSlice.Spec.Range {
+		for i, additionalRange := range nodeSlice.Spec.AdditionalRanges {
+			if additionalRange.Range == ipRange {
+				additionalRangeIndex = i
+			}
+		}
+		if additionalRangeIndex < 0 {
+			logging.Errorf("error finding range %s within node slice %s/%s", ipRange, ipam.namespace, nodeSlice.GetName())
+			return "", fmt.Errorf("no node slices of range %s", ipRange)
+		}
+	}
 	for _, allocation := range nodeSlice.Status.Allocations {
 		if allocation.NodeName == nodeName {
 			logging.Debugf("found matching node slice allocation for hostname %v: %v", nodeName, allocation)
-			return allocation.SliceRange, nil
+			if additionalRangeIndex < 0 {
+				return allocation.SliceRange, nil
+			}
+			if additionalRangeIndex < len(allocation.AdditionalSliceRanges) {
+				return allocation.AdditionalSliceRanges[additionalRangeIndex], nil
+			}
+			break
 		}
 	}
 	logging.Errorf("error finding node within node slice allocations")
 	return "", fmt.Errorf("no allocated node slice for node")
 }
 
+// getNodeSlice returns the node slice pool of the namespace of the IPAM, from the shared informer when there is one
+func (i *KubernetesIPAM) getNodeSlice(ctx context.Context, name string) (*whereaboutsv1alpha1.NodeSlicePool, error) {
+	if i.nodeSliceLister != nil {
+		nodeSlice, err := i.nodeSliceLister.NodeSlicePools(i.namespace).Get(name)
+		if !errors.IsNotFound(err) {
+			return nodeSlice, err
+		}
+	}
+	return i.client.WhereaboutsV1alpha1().NodeSlicePools(i.namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (i *KubernetesIPAM) nodeName() (string, error) {
+	if i.NodeName != "" {
+		return i.NodeName, nil
+	}
+	return platform.NodeName()
+}
+
 func getNodeSliceName(ipam *KubernetesIPAM) string {
 	if ipam.Config.NetworkName == UnnamedNetwork {
 		return ipam.Config.Name
@@ -536,7 +1092,16 @@ func getNodeSliceName(ipam *KubernetesIPAM) string {
 
 // IPManagementKubernetesUpdate manages k8s updates
 func IPManagementKubernetesUpdate(ctx context.Context, mode int, ipam *KubernetesIPAM, ipamConf whereaboutstypes.IPAMConfig) ([]net.IPNet, error) {
-	logging.Debugf("IPManagement -- mode: %d / containerID: %q / podRef: %q / ifName: %q ", mode, ipam.containerID, ipamConf.GetPodRef(), ipam.IfName)
+	ctx, span := tracing.Start(ctx, "ipam.update", tracing.String("mode", whereaboutstypes.ModeName(mode)), tracing.String("pod", ipamConf.GetPodRef()),
+		tracing.String("interface", ipam.IfName))
+	newips, err := ipManagementKubernetesUpdate(ctx, mode, ipam, ipamConf)
+	span.End(err)
+	return newips, err
+}
+
+func ipManagementKubernetesUpdate(ctx context.Context, mode int, ipam *KubernetesIPAM, ipamConf whereaboutstypes.IPAMConfig) ([]net.IPNet, error) {
+	logger := logging.FromContext(ctx)
+	logger.Debugf("IPManagement -- mode: %d / containerID: %q / podRef: %q / ifName: %q ", mode, ipam.containerID, ipamConf.GetPodRef(), ipam.IfName)
 
 	var newips []net.IPNet
 	var newip net.IPNet
@@ -548,156 +1113,385 @@ func IPManagementKubernetesUpdate(ctx context.Context, mode int, ipam *Kubernete
 	}
 
 	var overlappingrangestore storage.OverlappingRangeStore
-	var pool storage.IPPool
+	var pool *KubernetesIPPool
 	var err error
 
+	if mode == whereaboutstypes.Allocate && ipamConf.AllocationTimeout > 0 {
+		var allocationCancel context.CancelFunc
+		ctx, allocationCancel = context.WithTimeout(ctx, time.Duration(ipamConf.AllocationTimeout)*time.Millisecond)
+		defer allocationCancel()
+	}
+
 	requestCtx, requestCancel := context.WithTimeout(ctx, storage.RequestTimeout)
 	defer requestCancel()
 
 	// Check our connectivity first
 	if err := ipam.Status(requestCtx); err != nil {
-		logging.Errorf("IPAM connectivity error: %v", err)
+		logger.Errorf("IPAM connectivity error: %v", err)
 		return newips, err
 	}
 
+	// The UID of the pod is recorded along with its allocations, but only required to match on their reuse when asked to
+	var podUID string
+	if ipamConf.RequirePodUIDMatch {
+		podUID = ipamConf.PodUID
+	}
+
+	// The allocations of the networks keying their reservations by MAC address follow the MAC address of the interface,
+	// and are sticky to it
+	var mac string
+	stickyKey := ipamConf.GetPodRef()
+	if ipamConf.ReservationKey == whereaboutstypes.ReservationKeyMAC {
+		if mode == whereaboutstypes.Allocate && ipamConf.MAC == "" {
+			err = fmt.Errorf("reservation_key %q needs the MAC CNI arg of the interface", ipamConf.ReservationKey)
+			logger.Errorf("Error assigning IP: %v", err)
+			return newips, err
+		}
+		mac = ipamConf.MAC
+		stickyKey = mac
+	}
+	sticky := ipamConf.EnableStickyIPs || mac != ""
+
 	// handle the ip add/del until successful
 	var overlappingrangeallocations []whereaboutstypes.IPReservation
 	var ipforoverlappingrangeupdate net.IP
-	skipOverlappingRangeUpdate := false
-	for _, ipRange := range ipamConf.IPRanges {
-	RETRYLOOP:
-		for j := 0; j < storage.DatastoreRetries; j++ {
-			select {
-			case <-ctx.Done():
-				break RETRYLOOP
-			default:
-				// retry the IPAM loop if the context has not been cancelled
-			}
-			overlappingrangestore, err = ipam.GetOverlappingRangeStore()
+	if mode == whereaboutstypes.Allocate && ipamConf.OverlappingRanges && ipamConf.SeedOverlappingIPs {
+		overlappingrangeallocations, err = seedOverlappingRangeAllocations(requestCtx, ipam, ipamConf)
+		if err != nil {
+			logger.Errorf("Error listing cluster wide IP allocations: %v", err)
+			return newips, err
+		}
+	}
+	var podRequestedIPs []net.IP
+	if mode == whereaboutstypes.Allocate {
+		podRequestedIPs, err = requestedIPs(requestCtx, ipam, ipamConf)
+		if err != nil {
+			logger.Errorf("Error reading the requested IPs: %v", err)
+			return newips, err
+		}
+		if err = allocate.CheckRequestedIPs(ipamConf.IPRanges, podRequestedIPs); err != nil {
+			logger.Errorf("Error assigning IP: %v", err)
+			return newips, err
+		}
+	}
+	var conflicts int
+	ipRanges := ipamConf.IPRanges
+	if mode == whereaboutstypes.Deallocate && ipamConf.OverlappingRanges && len(ipRanges) > 1 {
+		ipRanges = deallocationRanges(requestCtx, ipam, ipamConf)
+	}
+	for _, ipRange := range ipRanges {
+		conflictBackoff := storage.OverlappingRangeConflictBackoff
+		retryBackoff := storage.DatastoreRetryBackoff(time.Duration(ipamConf.BackoffBaseMs)*time.Millisecond,
+			time.Duration(ipamConf.BackoffMaxMs)*time.Millisecond)
+		requestedIP := allocate.RequestedIP(ipRange, podRequestedIPs)
+		var shards []*poolShard
+		shards, err = rangeShards(ipRange, ipamConf.PoolShards, ipam.containerID)
+		if err != nil {
+			logger.Errorf("Error sharding range %s: %v", ipRange.Range, err)
+			return newips, err
+		}
+		if mode == whereaboutstypes.Allocate {
+			var held bool
+			shards, held, err = heldShards(requestCtx, ipam, ipamConf.NetworkName, shards, ipamConf.GetPodRef(), podUID, ipam.IfName,
+				mac, stickyKey, sticky)
 			if err != nil {
-				logging.Errorf("IPAM error getting OverlappingRangeStore: %v", err)
+				logger.Errorf("Error reading the shards of range %s: %v", ipRange.Range, err)
 				return newips, err
 			}
-			poolIdentifier := PoolIdentifier{IpRange: ipRange.Range, NetworkName: ipamConf.NetworkName}
-			if ipamConf.NodeSliceSize != "" {
-				hostname, err := getNodeName()
-				if err != nil {
-					logging.Errorf("Failed to get node hostname: %v", err)
+			if !held {
+				shards = requestedIPShards(shards, requestedIP)
+			}
+		}
+	SHARDLOOP:
+		for shardIndex, shard := range shards {
+			lastShard := shardIndex == len(shards)-1
+		RETRYLOOP:
+			for j := 0; j < storage.DatastoreRetries; j++ {
+				select {
+				case <-ctx.Done():
+					err = fmt.Errorf("IPAM deadline reached (attempt: %d): %w", j, ctx.Err())
+					logger.Errorf("%v", err)
 					return newips, err
+				default:
+					// retry the IPAM loop if the context has not been cancelled
 				}
-				poolIdentifier.NodeName = hostname
-				nodeSliceRange, err := GetNodeSlicePoolRange(ctx, ipam, hostname)
+				overlappingrangestore, err = ipam.GetOverlappingRangeStore()
 				if err != nil {
+					logger.Errorf("IPAM error getting OverlappingRangeStore: %v", err)
 					return newips, err
 				}
-				_, ipNet, err := net.ParseCIDR(nodeSliceRange)
-				if err != nil {
-					logging.Errorf("Error parsing node slice cidr to net.IPNet: %v", err)
-					return newips, err
+				poolIdentifier := PoolIdentifier{IpRange: ipRange.Range, NetworkName: ipamConf.NetworkName}
+				if ipamConf.NodeSliceSize != "" {
+					hostname, err := ipam.nodeName()
+					if err != nil {
+						logger.Errorf("Failed to get node hostname: %v", err)
+						return newips, err
+					}
+					poolIdentifier.NodeName = hostname
+					nodeSliceRange, err := GetNodeSliceOfRange(ctx, ipam, hostname, ipRange.Range)
+					if err != nil {
+						return newips, err
+					}
+					_, ipNet, err := net.ParseCIDR(nodeSliceRange)
+					if err != nil {
+						logger.Errorf("Error parsing node slice cidr to net.IPNet: %v", err)
+						return newips, err
+					}
+					poolIdentifier.IpRange = nodeSliceRange
+					rangeStart, err := iphelpers.FirstUsableIP(*ipNet)
+					if err != nil {
+						logger.Errorf("Error parsing node slice cidr to range start: %v", err)
+						return newips, err
+					}
+					if ipamConf.GatewayStrategy == whereaboutstypes.GatewayStrategyPerNodeSliceFirstIP {
+						// the first IP of the slice is the gateway of its pods
+						rangeStart = iphelpers.IncIP(rangeStart)
+					}
+					rangeEnd, err := iphelpers.LastUsableIP(*ipNet)
+					if err != nil {
+						logger.Errorf("Error parsing node slice cidr to range start: %v", err)
+						return newips, err
+					}
+					ipRange = whereaboutstypes.RangeConfiguration{
+						Range:              ipRange.Range,
+						RangeStart:         rangeStart,
+						RangeEnd:           rangeEnd,
+						AllocationStrategy: ipRange.AllocationStrategy,
+					}
+				}
+				assignRange := ipRange
+				if shard != nil {
+					poolIdentifier.IpRange = shard.ipRange
+					poolIdentifier.Shard = true
+					assignRange = shard.assignRange
 				}
-				poolIdentifier.IpRange = nodeSliceRange
-				rangeStart, err := iphelpers.FirstUsableIP(*ipNet)
+				logger.Debugf("using pool identifier: %v", poolIdentifier)
+				pool, err = ipam.getIPPool(requestCtx, poolIdentifier)
 				if err != nil {
-					logging.Errorf("Error parsing node slice cidr to range start: %v", err)
+					logger.Errorf("IPAM error reading pool allocations (attempt: %d): %v", j, err)
+					if e, ok := err.(storage.Temporary); ok && e.Temporary() {
+						if err := waitForRetry(ctx, &retryBackoff); err != nil {
+							return newips, err
+						}
+						continue
+					}
 					return newips, err
 				}
-				rangeEnd, err := iphelpers.LastUsableIP(*ipNet)
-				if err != nil {
-					logging.Errorf("Error parsing node slice cidr to range start: %v", err)
+				if migratedTo, migrated := pool.pool.GetAnnotations()[MigratedToAnnotation]; migrated && mode == whereaboutstypes.Allocate {
+					// releasing from the emptied pool is harmless, allocating from it would double book the named pool
+					err = fmt.Errorf("the IP pool %s was migrated to %s: set the network_name of the network configuration", pool.Name(), migratedTo)
+					logger.Errorf("IPAM error reading pool allocations: %v", err)
+					return newips, err
+				} else if migrated && len(pool.pool.Spec.Allocations) > 0 {
+					// the pool is frozen while its allocations are copied: a release now would be lost in the named pool
+					err = fmt.Errorf("the IP pool %s is being migrated to %s: retry once the migration is over", pool.Name(), migratedTo)
+					logger.Errorf("IPAM error reading pool allocations: %v", err)
 					return newips, err
 				}
-				ipRange = whereaboutstypes.RangeConfiguration{
-					Range:      ipRange.Range,
-					RangeStart: rangeStart,
-					RangeEnd:   rangeEnd,
+				if pool.pool.GetAnnotations()[PoolLockedAnnotation] == "true" && mode == whereaboutstypes.Allocate {
+					if !lastShard {
+						logger.Debugf("Shard %s is locked by administrator, trying the next one", poolIdentifier.IpRange)
+						continue SHARDLOOP
+					}
+					err = fmt.Errorf("the IP pool %s is locked by administrator: remove its %s annotation to allocate from it",
+						pool.Name(), PoolLockedAnnotation)
+					logger.Errorf("IPAM error reading pool allocations: %v", err)
+					return newips, err
 				}
-			}
-			logging.Debugf("using pool identifier: %v", poolIdentifier)
-			pool, err = ipam.GetIPPool(requestCtx, poolIdentifier)
-			if err != nil {
-				logging.Errorf("IPAM error reading pool allocations (attempt: %d): %v", j, err)
-				if e, ok := err.(storage.Temporary); ok && e.Temporary() {
-					continue
+
+				if ipamConf.StrictRangeCheck && mode == whereaboutstypes.Allocate {
+					if err = checkPoolRange(pool.pool, poolIdentifier.IpRange); err != nil {
+						logger.Errorf("IPAM error reading pool allocations: %v", err)
+						return newips, err
+					}
 				}
-				return newips, err
-			}
 
-			reservelist := pool.Allocations()
-			reservelist = append(reservelist, overlappingrangeallocations...)
-			var updatedreservelist []whereaboutstypes.IPReservation
-			switch mode {
-			case whereaboutstypes.Allocate:
-				newip, updatedreservelist, err = allocate.AssignIP(ipRange, reservelist, ipam.containerID, ipamConf.GetPodRef(), ipam.IfName)
+				var serviceIPs []whereaboutstypes.IPReservation
+				var reservedForServices []whereaboutsv1alpha1.ServiceReservation
+				reservedForServices, serviceIPs, err = serviceReservations(ipRange, ipamConf.ServiceReservations)
 				if err != nil {
-					logging.Errorf("Error assigning IP: %v", err)
+					logger.Errorf("Error reserving network service IPs: %v", err)
 					return newips, err
 				}
-				// Now check if this is allocated overlappingrange wide
-				// When it's allocated overlappingrange wide, we add it to a local reserved list
-				// And we try again.
-				if ipamConf.OverlappingRanges {
-					overlappingRangeIPReservation, err := overlappingrangestore.GetOverlappingRangeIPReservation(requestCtx, newip.IP,
-						ipamConf.GetPodRef(), ipamConf.NetworkName)
+				pool.SetServiceReservations(shardServiceReservations(shard, reservedForServices))
+				if rangeStart, rangeEnd, err := assignRange.UsableRange(); err == nil {
+					pool.SetUsableRange(rangeStart, rangeEnd)
+				}
+				pool.SetRangeSet(assignRange.RangeSet)
+
+				reservelist := pool.Allocations()
+				reservelist = append(reservelist, overlappingrangeallocations...)
+				reservelist = append(reservelist, serviceIPs...)
+				reservelist = append(reservelist, pool.ExternallyUsed(time.Duration(ipamConf.VerifyUnusedQuarantine)*time.Second)...)
+				var updatedreservelist []whereaboutstypes.IPReservation
+				var createdOverlappingRangeIP net.IP
+				switch mode {
+				case whereaboutstypes.Allocate:
+					reservelist = dropLostAllocations(reservelist, overlappingrangeallocations, ipamConf.GetPodRef(), ipam.IfName)
+					var stickyIP net.IP
+					if sticky {
+						stickyIP = pool.StickyIP(stickyKey)
+					}
+					previousHolder, handedOver := macHolder(reservelist, mac, ipamConf.GetPodRef(), ipam.IfName)
+					if handedOver {
+						if err := verifyMACHandover(ctx, ipam, ipamConf, previousHolder); err != nil {
+							logger.Errorf("Error handing over the allocation of MAC %s: %v", mac, err)
+							return newips, err
+						}
+					}
+					newip, updatedreservelist, err = allocate.AssignIP(assignRange, reservelist, pool.ReleaseTimes(), ipam.containerID,
+						ipamConf.GetPodRef(), podUID, ipam.IfName, mac, requestedIP, stickyIP)
+					// An IP newly assigned which answers the verification is used outside of whereabouts: it is
+					// recorded as such in the pool, and the next one is assigned.
+					for err == nil && len(updatedreservelist) > len(reservelist) {
+						used, verifyErr := ipam.externallyUsed(ctx, ipamConf, newip.IP)
+						if verifyErr != nil {
+							logger.Debugf("Releasing the lease to verify IP %v: %v", newip.IP, verifyErr)
+							return newips, verifyErr
+						}
+						if !used {
+							break
+						}
+						logger.Debugf("IP %v is used outside of whereabouts, assigning another one", newip.IP)
+						pool.SetExternallyUsed(newip.IP, time.Now())
+						reservelist = append(reservelist, whereaboutstypes.IPReservation{IP: newip.IP, IsAllocated: true})
+						newip, updatedreservelist, err = allocate.AssignIP(assignRange, reservelist, pool.ReleaseTimes(), ipam.containerID,
+							ipamConf.GetPodRef(), podUID, ipam.IfName, mac, requestedIP, stickyIP)
+					}
+					_, exhausted := err.(allocate.AssignmentError)
+					if exhausted && !lastShard {
+						logger.Debugf("Shard %s is exhausted, trying the next one: %v", poolIdentifier.IpRange, err)
+						continue SHARDLOOP
+					}
 					if err != nil {
-						logging.Errorf("Error getting cluster wide IP allocation: %v", err)
+						if exhausted {
+							metrics.IPPoolExhaustions.Inc()
+							ipam.reportExhaustion(ctx, pool, ipamConf, err)
+						}
+						logger.Errorf("Error assigning IP: %v", err)
+						return newips, err
+					}
+					if err := allocate.CheckForeignRanges(newip.IP, ipamConf.ForeignRanges); err != nil {
+						logger.Errorf("Error assigning IP: %v", err)
 						return newips, err
 					}
+					recordAllocationOwner(updatedreservelist, newip.IP, ipamConf.PodUID, time.Now())
+					if sticky {
+						pool.SetStickyIP(stickyKey, newip.IP)
+					}
+					// Now check if this is allocated overlappingrange wide
+					// When it's allocated overlappingrange wide, we add it to a local reserved list
+					// And we try again.
+					// The cluster wide reservation is written before the pool: should we crash in between, a retried
+					// ADD finds its own reservation and reuses it, while the reconciler removes it otherwise.
+					if ipamConf.OverlappingRanges {
+						overlappingRangeIPReservation, err := overlappingrangestore.GetOverlappingRangeIPReservation(requestCtx, newip.IP,
+							ipamConf.GetPodRef(), ipamConf.NetworkName)
+						if err != nil {
+							logger.Errorf("Error getting cluster wide IP allocation: %v", err)
+							return newips, err
+						}
 
-					if overlappingRangeIPReservation != nil {
-						if overlappingRangeIPReservation.Spec.PodRef != ipamConf.GetPodRef() {
-							logging.Debugf("Continuing loop, IP is already allocated (possibly from another range): %v", newip)
+						if overlappingRangeIPReservation != nil && handedOver && newip.IP.Equal(previousHolder.IP) &&
+							overlappingRangeIPReservation.Spec.PodRef == previousHolder.PodRef {
+							// the allocation of the MAC address was handed over: so is its cluster wide reservation
+							err = overlappingrangestore.UpdateOverlappingRangeAllocation(requestCtx, whereaboutstypes.Deallocate, newip.IP,
+								previousHolder.ContainerID, previousHolder.PodRef, previousHolder.IfName, ipamConf.NetworkName, ipRange.Range)
+							if err != nil && !errors.IsNotFound(err) {
+								logger.Errorf("Error releasing the cluster wide reservation of the previous pod of MAC %s: %v", mac, err)
+								return newips, err
+							}
+							overlappingRangeIPReservation = nil
+						}
+
+						if overlappingRangeIPReservation != nil && overlappingRangeIPReservation.Spec.PodRef != ipamConf.GetPodRef() {
+							logger.Debugf("Continuing loop, IP is already allocated (possibly from another range): %v", newip)
 							// We create "dummy" records here for evaluation, but, we need to filter those out later.
 							overlappingrangeallocations = append(overlappingrangeallocations, whereaboutstypes.IPReservation{IP: newip.IP, IsAllocated: true})
 							continue
 						}
 
-						skipOverlappingRangeUpdate = true
+						if overlappingRangeIPReservation == nil {
+							err = overlappingrangestore.UpdateOverlappingRangeAllocation(requestCtx, mode, newip.IP,
+								ipam.containerID, ipamConf.GetPodRef(), ipam.IfName, ipamConf.NetworkName, ipRange.Range)
+							if errors.IsAlreadyExists(err) && conflictBackoff.Steps > 0 {
+								// Another pod claimed the IP between our check and the creation of its reservation: try
+								// again with the next candidate instead of failing the ADD.
+								logger.Debugf("Lost the race for IP %v to a pod of an overlapping range, retrying", newip)
+								overlappingrangeallocations = append(overlappingrangeallocations, whereaboutstypes.IPReservation{IP: newip.IP, IsAllocated: true})
+								select {
+								case <-ctx.Done():
+									return newips, ctx.Err()
+								case <-time.After(conflictBackoff.Step()):
+								}
+								continue
+							}
+							if err != nil {
+								logger.Errorf("Error performing UpdateOverlappingRangeAllocation: %v", err)
+								return newips, err
+							}
+							createdOverlappingRangeIP = newip.IP
+						}
 					}
 
-					ipforoverlappingrangeupdate = newip.IP
+				case whereaboutstypes.Deallocate:
+					updatedreservelist, ipforoverlappingrangeupdate = allocate.DeallocatePodIP(reservelist, ipam.containerID, ipam.IfName, ipam.podRef())
+					if ipforoverlappingrangeupdate == nil && !lastShard {
+						continue SHARDLOOP
+					}
+					if ipforoverlappingrangeupdate == nil {
+						// Do not fail if allocation was not found.
+						logger.Debugf("Failed to find allocation for container ID: %s", ipam.containerID)
+						return nil, nil
+					}
 				}
+				pool.SetAllocationStrategy(assignRange.AllocationStrategy)
 
-			case whereaboutstypes.Deallocate:
-				updatedreservelist, ipforoverlappingrangeupdate = allocate.DeallocateIP(reservelist, ipam.containerID, ipam.IfName)
-				if ipforoverlappingrangeupdate == nil {
-					// Do not fail if allocation was not found.
-					logging.Debugf("Failed to find allocation for container ID: %s", ipam.containerID)
-					return nil, nil
+				// Clean out any dummy records from the reservelist...
+				var usereservelist []whereaboutstypes.IPReservation
+				for _, rl := range updatedreservelist {
+					if !rl.IsAllocated {
+						usereservelist = append(usereservelist, rl)
+					}
 				}
-			}
 
-			// Clean out any dummy records from the reservelist...
-			var usereservelist []whereaboutstypes.IPReservation
-			for _, rl := range updatedreservelist {
-				if !rl.IsAllocated {
-					usereservelist = append(usereservelist, rl)
+				// Manual race condition testing
+				if ipamConf.SleepForRace > 0 {
+					time.Sleep(time.Duration(ipamConf.SleepForRace) * time.Second)
 				}
-			}
-
-			// Manual race condition testing
-			if ipamConf.SleepForRace > 0 {
-				time.Sleep(time.Duration(ipamConf.SleepForRace) * time.Second)
-			}
 
-			err = pool.Update(requestCtx, usereservelist)
-			if err != nil {
-				logging.Errorf("IPAM error updating pool (attempt: %d): %v", j, err)
-				if e, ok := err.(storage.Temporary); ok && e.Temporary() {
-					continue
+				err = pool.Update(requestCtx, usereservelist)
+				if err != nil {
+					logger.Errorf("IPAM error updating pool (attempt: %d): %v", j, err)
+					if createdOverlappingRangeIP != nil {
+						rollbackOverlappingRangeAllocation(requestCtx, overlappingrangestore, createdOverlappingRangeIP, ipam.containerID,
+							ipamConf.GetPodRef(), ipam.IfName, ipamConf.NetworkName)
+					}
+					if e, ok := err.(storage.Temporary); ok && e.Temporary() {
+						conflicts++
+						if ipam.maxConflicts > 0 && conflicts >= ipam.maxConflicts {
+							return newips, fmt.Errorf("%w (%d): %v", errTooManyConflicts, conflicts, err)
+						}
+						if err := waitForRetry(ctx, &retryBackoff); err != nil {
+							return newips, err
+						}
+						continue
+					}
+					return newips, err
 				}
 				break RETRYLOOP
 			}
-			break RETRYLOOP
+			break SHARDLOOP
 		}
 
-		if ipamConf.OverlappingRanges {
-			if !skipOverlappingRangeUpdate {
-				err = overlappingrangestore.UpdateOverlappingRangeAllocation(requestCtx, mode, ipforoverlappingrangeupdate,
-					ipamConf.GetPodRef(), ipam.IfName, ipamConf.NetworkName)
-				if err != nil {
-					logging.Errorf("Error performing UpdateOverlappingRangeAllocation: %v", err)
-					return newips, err
-				}
+		// The pool is released before the cluster wide reservation: should we crash in between, the reconciler
+		// removes the reservation left behind.
+		if ipamConf.OverlappingRanges && mode == whereaboutstypes.Deallocate {
+			err = overlappingrangestore.UpdateOverlappingRangeAllocation(requestCtx, mode, ipforoverlappingrangeupdate,
+				ipam.containerID, ipamConf.GetPodRef(), ipam.IfName, ipamConf.NetworkName, ipRange.Range)
+			if err != nil {
+				logger.Errorf("Error performing UpdateOverlappingRangeAllocation: %v", err)
+				return newips, err
 			}
 		}
 
@@ -706,6 +1500,164 @@ func IPManagementKubernetesUpdate(ctx context.Context, mode int, ipam *Kubernete
 	return newips, err
 }
 
+// waitForRetry paces the retries of the pool updates, giving up once the allocation deadline is reached
+func waitForRetry(ctx context.Context, backoff *wait.Backoff) error {
+	select {
+	case <-ctx.Done():
+		err := fmt.Errorf("IPAM deadline reached while retrying the IP pool update: %w", ctx.Err())
+		logging.Errorf("%v", err)
+		return err
+	case <-time.After(storage.DatastoreRetryDelay(backoff)):
+		metrics.IPPoolUpdateRetries.Inc()
+		return nil
+	}
+}
+
+// seedOverlappingRangeAllocations returns the IPs of the ranges already reserved cluster wide by other pods, as "dummy"
+// records: rather than discovering them one conflict per retry, the allocation skips them right away.
+func seedOverlappingRangeAllocations(ctx context.Context, ipam *KubernetesIPAM, ipamConf whereaboutstypes.IPAMConfig) ([]whereaboutstypes.IPReservation, error) {
+	overlappingrangestore := &KubernetesOverlappingRangeStore{ipam.client, ipam.namespace}
+	reservedIPs, err := overlappingrangestore.ListOverlappingRangeReservedIPs(ctx, ipamConf.GetPodRef(), ipamConf.NetworkName)
+	if err != nil {
+		return nil, err
+	}
+
+	var seeded []whereaboutstypes.IPReservation
+	for _, ip := range reservedIPs {
+		for _, ipRange := range ipamConf.IPRanges {
+			if ipRange.Contains(ip) {
+				seeded = append(seeded, whereaboutstypes.IPReservation{IP: ip, IsAllocated: true})
+				break
+			}
+		}
+	}
+	logging.Debugf("seeded %d IPs reserved cluster wide by other pods", len(seeded))
+	return seeded, nil
+}
+
+// rollbackOverlappingRangeAllocation deletes the cluster wide reservation created for an IP which could not be
+// recorded in its pool. Failing to do so is not fatal: the reconciler eventually removes the stray reservation.
+func rollbackOverlappingRangeAllocation(ctx context.Context, overlappingrangestore storage.OverlappingRangeStore, ip net.IP,
+	containerID, podRef, ifName, networkName string) {
+	err := overlappingrangestore.UpdateOverlappingRangeAllocation(ctx, whereaboutstypes.Deallocate, ip, containerID, podRef, ifName, networkName, "")
+	if err != nil && !errors.IsNotFound(err) {
+		logging.Errorf("Error rolling back the overlapping range reservation of IP %v: %v", ip, err)
+	}
+}
+
+// recordAllocationOwner stamps the allocation of the IP with the UID of the pod it was assigned to, and the time it was
+// allocated to that pod: an allocation reused by a pod of the same name but another UID is stamped anew. The
+// reconciler spares the recent allocations, and tells the pod from its predecessors, by them.
+func recordAllocationOwner(reservelist []whereaboutstypes.IPReservation, ip net.IP, podUID string, now time.Time) {
+	for i := range reservelist {
+		r := &reservelist[i]
+		if r.IsAllocated || !r.IP.Equal(ip) {
+			continue
+		}
+		if podUID != "" && r.PodUID != podUID {
+			r.PodUID = podUID
+			r.AllocatedAt = now
+		}
+		if r.AllocatedAt.IsZero() {
+			r.AllocatedAt = now
+		}
+		return
+	}
+}
+
+// macHolder returns the allocation recording the MAC address when held by another pod interface, which the MAC
+// address's allocation is handed over from
+func macHolder(reservelist []whereaboutstypes.IPReservation, mac, podRef, ifName string) (whereaboutstypes.IPReservation, bool) {
+	if mac == "" {
+		return whereaboutstypes.IPReservation{}, false
+	}
+	for _, r := range reservelist {
+		if !r.IsAllocated && r.MAC == mac && (r.PodRef != podRef || r.IfName != ifName) {
+			return r, true
+		}
+	}
+	return whereaboutstypes.IPReservation{}, false
+}
+
+// verifyMACHandover fails unless the pod the allocation of the MAC address is handed over from is gone - deleted,
+// recreated under another UID or terminated - or is the pod being allocated, or runs the same virtual machine: that of
+// a live migration, sharing its VMIdentityLabel.
+func verifyMACHandover(ctx context.Context, ipam *KubernetesIPAM, ipamConf whereaboutstypes.IPAMConfig, previousHolder whereaboutstypes.IPReservation) error {
+	namespace, name, found := strings.Cut(previousHolder.PodRef, "/")
+	if !found {
+		return nil
+	}
+	previousPod, err := ipam.GetPod(ctx, namespace, name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get pod %s holding IP %s: %w", previousHolder.PodRef, previousHolder.IP, err)
+	}
+	if previousHolder.PodUID != "" && string(previousPod.UID) != previousHolder.PodUID {
+		return nil
+	}
+	if previousPod.Status.Phase == v1.PodSucceeded || previousPod.Status.Phase == v1.PodFailed {
+		return nil
+	}
+	if ipamConf.PodUID != "" && string(previousPod.UID) == ipamConf.PodUID {
+		return nil
+	}
+	if vm := previousPod.GetLabels()[VMIdentityLabel]; vm != "" && ipamConf.PodName != "" {
+		pod, err := ipam.GetPod(ctx, ipamConf.PodNamespace, ipamConf.PodName)
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get pod %s: %w", ipamConf.GetPodRef(), err)
+		}
+		if err == nil && pod.GetLabels()[VMIdentityLabel] == vm {
+			return nil
+		}
+	}
+	return fmt.Errorf("IP %s of MAC address %s is held by the running pod %s", previousHolder.IP, previousHolder.MAC, previousHolder.PodRef)
+}
+
+// dropLostAllocations removes the allocations of the pod interface whose IP turned out to be reserved cluster wide
+// by another pod, so that a new IP gets assigned in their place.
+func dropLostAllocations(reservelist, lostIPs []whereaboutstypes.IPReservation, podRef, ifName string) []whereaboutstypes.IPReservation {
+	if len(lostIPs) == 0 {
+		return reservelist
+	}
+
+	var keptReservations []whereaboutstypes.IPReservation
+	for _, r := range reservelist {
+		if r.PodRef == podRef && r.IfName == ifName && containsIP(lostIPs, r.IP) {
+			continue
+		}
+		keptReservations = append(keptReservations, r)
+	}
+	return keptReservations
+}
+
+func containsIP(reservelist []whereaboutstypes.IPReservation, ip net.IP) bool {
+	for _, r := range reservelist {
+		if r.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceReservations computes the addresses of the range set aside for the named network services, both as the
+// entries recorded in the pool status and as dummy reservations which keep them from being allocated.
+func serviceReservations(ipRange whereaboutstypes.RangeConfiguration, names []string) ([]whereaboutsv1alpha1.ServiceReservation, []whereaboutstypes.IPReservation, error) {
+	ips, err := allocate.ServiceIPs(ipRange, len(names))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reservations := make([]whereaboutsv1alpha1.ServiceReservation, 0, len(ips))
+	dummyReservations := make([]whereaboutstypes.IPReservation, 0, len(ips))
+	for i, ip := range ips {
+		reservations = append(reservations, whereaboutsv1alpha1.ServiceReservation{Name: names[i], IP: ip.String()})
+		dummyReservations = append(dummyReservations, whereaboutstypes.IPReservation{IP: ip, IsAllocated: true})
+	}
+	return reservations, dummyReservations, nil
+}
+
 func wbNamespaceFromCtx(ctx *clientcmdapi.Context) string {
 	namespace := ctx.Namespace
 	if namespace == "" {
@@ -1,6 +1,35 @@
 package kubernetes
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/metrics"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/tracing"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
 
 func TestIPPoolName(t *testing.T) {
 	cases := []struct {
@@ -42,6 +71,24 @@ func TestIPPoolName(t *testing.T) {
 			},
 			expectedResult: "testnetwork-testnode-10.0.0.0-8",
 		},
+		{
+			name: "Shard, unnamed network",
+			poolIdentifier: PoolIdentifier{
+				NetworkName: UnnamedNetwork,
+				IpRange:     "10.64.0.0/10",
+				Shard:       true,
+			},
+			expectedResult: "shard-10.64.0.0-10",
+		},
+		{
+			name: "Shard, named network",
+			poolIdentifier: PoolIdentifier{
+				NetworkName: "testnetwork",
+				IpRange:     "10.64.0.0/10",
+				Shard:       true,
+			},
+			expectedResult: "testnetwork-shard-10.64.0.0-10",
+		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -52,3 +99,1353 @@ func TestIPPoolName(t *testing.T) {
 		})
 	}
 }
+
+func overlappingRangeIPReservation(name, podRef string) *whereaboutsv1alpha1.OverlappingRangeIPReservation {
+	return &whereaboutsv1alpha1.OverlappingRangeIPReservation{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-system"},
+		Spec:       whereaboutsv1alpha1.OverlappingRangeIPReservationSpec{ContainerID: "c", PodRef: podRef},
+	}
+}
+
+func TestListOverlappingRangeReservedIPs(t *testing.T) {
+	wbClient := fakewbclient.NewSimpleClientset(
+		overlappingRangeIPReservation("10.0.0.1", "default/pod-b"),
+		overlappingRangeIPReservation("10.0.0.2", "default/pod-a"),
+		overlappingRangeIPReservation("fd00--1", "default/pod-b"),
+		overlappingRangeIPReservation("net1-10.0.0.3", "default/pod-b"),
+		overlappingRangeIPReservation("net1-fd00--5", "default/pod-b"),
+	)
+	store := &KubernetesOverlappingRangeStore{client: wbClient, namespace: "kube-system"}
+
+	cases := []struct {
+		name        string
+		networkName string
+		expectedIPs []string
+	}{
+		{name: "Unnamed network", networkName: UnnamedNetwork, expectedIPs: []string{"10.0.0.1", "fd00::1"}},
+		{name: "Named network", networkName: "net1", expectedIPs: []string{"10.0.0.3", "fd00::5"}},
+		{name: "Network without reservations", networkName: "net2"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reservedIPs, err := store.ListOverlappingRangeReservedIPs(context.TODO(), "default/pod-a", tc.networkName)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(reservedIPs) != len(tc.expectedIPs) {
+				t.Fatalf("Expected IPs %v, got %v", tc.expectedIPs, reservedIPs)
+			}
+			for i, ip := range reservedIPs {
+				if ip.String() != tc.expectedIPs[i] {
+					t.Errorf("Expected IPs %v, got %v", tc.expectedIPs, reservedIPs)
+				}
+			}
+		})
+	}
+}
+
+func TestSeedOverlappingIPs(t *testing.T) {
+	objects := []runtime.Object{
+		&whereaboutsv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+			Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+		},
+		overlappingRangeIPReservation("10.0.0.1", "default/pod-b"),
+		overlappingRangeIPReservation("10.0.0.2", "default/pod-b"),
+	}
+
+	cases := []struct {
+		name                           string
+		seed                           bool
+		expectedReservationLookupCount int
+	}{
+		{name: "Conflicts discovered one by one", expectedReservationLookupCount: 3},
+		{name: "Seeded conflicts", seed: true, expectedReservationLookupCount: 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			wbClient := fakewbclient.NewSimpleClientset(objects...)
+			ipamConf := whereaboutstypes.IPAMConfig{
+				IPRanges: []whereaboutstypes.RangeConfiguration{{
+					Range:      "10.0.0.0/24",
+					RangeStart: net.ParseIP("10.0.0.1"),
+					RangeEnd:   net.ParseIP("10.0.0.254"),
+				}},
+				OverlappingRanges:  true,
+				SeedOverlappingIPs: tc.seed,
+				PodNamespace:       "default",
+				PodName:            "pod-a",
+			}
+			ipam := NewKubernetesIPAMWithClient("container", "net1", ipamConf, "kube-system",
+				*NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset()))
+
+			ips, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, ipam, ipamConf)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(ips) != 1 || ips[0].IP.String() != "10.0.0.3" {
+				t.Errorf("Expected IP 10.0.0.3 to be allocated, got %v", ips)
+			}
+
+			lookups := 0
+			for _, action := range wbClient.Actions() {
+				if action.GetVerb() == "get" && action.GetResource().Resource == "overlappingrangeipreservations" {
+					lookups++
+				}
+			}
+			if lookups != tc.expectedReservationLookupCount {
+				t.Errorf("Expected %d cluster wide reservation lookups, got %d", tc.expectedReservationLookupCount, lookups)
+			}
+		})
+	}
+}
+
+func TestAllocationDeadline(t *testing.T) {
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+		Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+	})
+	// every update of the pool loses the race against another pod
+	wbClient.PrependReactor("patch", "ippools", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewInvalid(schema.GroupKind{Kind: "IPPool"}, "10.0.0.0-24", nil)
+	})
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:          []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+		BackoffBaseMs:     10,
+		BackoffMaxMs:      40,
+		AllocationTimeout: 300,
+		PodNamespace:      "default",
+		PodName:           "pod-a",
+	}
+	ipam := NewKubernetesIPAMWithClient("container", "net1", ipamConf, "kube-system",
+		*NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset()))
+
+	retries := metrics.IPPoolUpdateRetries.Value()
+	start := time.Now()
+	_, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, ipam, ipamConf)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the allocation deadline to be reached, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the allocation to give up after its timeout, got %v", elapsed)
+	}
+
+	patches := 0
+	for _, action := range wbClient.Actions() {
+		if action.GetVerb() == "patch" {
+			patches++
+		}
+	}
+	// without backoff, the retries would be exhausted within the deadline
+	if patches < 2 || patches >= storage.DatastoreRetries {
+		t.Errorf("Expected the pool updates to be retried with a backoff, got %d attempts", patches)
+	}
+	if retried := metrics.IPPoolUpdateRetries.Value() - retries; retried != uint64(patches-1) {
+		t.Errorf("Expected %d retries to be counted, got %d", patches-1, retried)
+	}
+}
+
+func TestLockedPool(t *testing.T) {
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "10.0.0.0-24",
+			Namespace:       "kube-system",
+			ResourceVersion: "1",
+			Annotations:     map[string]string{PoolLockedAnnotation: "true"},
+		},
+		Spec: whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{
+			"1": {ContainerID: "container-b", PodRef: "default/pod-b", IfName: "net1"},
+		}},
+	})
+	ipamConf := whereaboutstypes.IPAMConfig{IPRanges: []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}}}
+	newIPAM := func(containerID string) *KubernetesIPAM {
+		return NewKubernetesIPAMWithClient(containerID, "net1", ipamConf, "kube-system",
+			*NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset()))
+	}
+
+	_, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, newIPAM("container-a"), ipamConf)
+	expectedErr := "the IP pool 10.0.0.0-24 is locked by administrator: remove its whereabouts.cni.cncf.io/locked annotation to allocate from it"
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("Expected the allocation to fail with %q, got %v", expectedErr, err)
+	}
+
+	if _, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Deallocate, newIPAM("container-b"), ipamConf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	pool, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(context.TODO(), "10.0.0.0-24", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(pool.Spec.Allocations) != 0 {
+		t.Errorf("Expected the locked pool to release its addresses, got %v", pool.Spec.Allocations)
+	}
+}
+
+func TestStrictRangeCheck(t *testing.T) {
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+		Spec: whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/23", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{
+			"1": {ContainerID: "container-b", PodRef: "default/pod-b", IfName: "net1"},
+		}},
+	})
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:         []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+		StrictRangeCheck: true,
+	}
+	newIPAM := func(containerID string) *KubernetesIPAM {
+		return NewKubernetesIPAMWithClient(containerID, "net1", ipamConf, "kube-system",
+			*NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset()))
+	}
+
+	_, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, newIPAM("container-a"), ipamConf)
+	expectedErr := "the IP pool 10.0.0.0-24 records the range 10.0.0.0/23, not the range 10.0.0.0/24 of the network " +
+		"configuration: refusing to allocate with strict_range_check"
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("Expected the allocation to fail with %q, got %v", expectedErr, err)
+	}
+
+	if _, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Deallocate, newIPAM("container-b"), ipamConf); err != nil {
+		t.Fatalf("Expected the release to ignore the check, got %v", err)
+	}
+
+	ipamConf.StrictRangeCheck = false
+	if _, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, newIPAM("container-a"), ipamConf); err != nil {
+		t.Errorf("Expected the allocation to succeed without the check, got %v", err)
+	}
+}
+
+func TestPoolNamespace(t *testing.T) {
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "tenant-a", ResourceVersion: "1"},
+		Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+	})
+	wbClient.PrependReactor("get", "ippools", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() != "tenant-b" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "whereabouts.cni.cncf.io", Resource: "ippools"},
+			"10.0.0.0-24", errors.New("no RBAC policy matched"))
+	})
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:          []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+		PoolNamespace:     "tenant-a",
+		OverlappingRanges: true,
+	}
+	newIPAM := func() *KubernetesIPAM {
+		return NewKubernetesIPAMWithClient("container-a", "net1", ipamConf, "kube-system",
+			*NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset()))
+	}
+
+	if _, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, newIPAM(), ipamConf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	pool, err := wbClient.WhereaboutsV1alpha1().IPPools("tenant-a").Get(context.TODO(), "10.0.0.0-24", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(pool.Spec.Allocations) != 1 {
+		t.Errorf("Expected the IP to be allocated from the pool of the pool namespace, got %v", pool.Spec.Allocations)
+	}
+	for _, namespace := range []string{"kube-system", "tenant-a"} {
+		reservations, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if expected := map[string]int{"kube-system": 1}[namespace]; len(reservations.Items) != expected {
+			t.Errorf("Expected %d cluster wide reservations in namespace %s, got %v", expected, namespace, reservations.Items)
+		}
+	}
+
+	ipamConf.PoolNamespace = "tenant-b"
+	_, err = IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, newIPAM(), ipamConf)
+	if err == nil || !strings.Contains(err.Error(), `whereabouts is not allowed to manage the IP pools of namespace "tenant-b"`) {
+		t.Errorf("Expected the allocation to fail pointing at the pool namespace, got %v", err)
+	}
+}
+
+func TestPoolExhaustion(t *testing.T) {
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-30", Namespace: "kube-system", ResourceVersion: "1"},
+		Spec: whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/30", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{
+			"1": {ContainerID: "container-b", PodRef: "default/pod-b", IfName: "net1"},
+			"2": {ContainerID: "container-c", PodRef: "default/pod-c", IfName: "net1"},
+		}},
+	})
+	k8sClient := fakek8sclient.NewSimpleClientset()
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:     []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/30"}},
+		PodName:      "pod-a",
+		PodNamespace: "default",
+		PodUID:       "uid-a",
+	}
+	newIPAM := func(containerID string) *KubernetesIPAM {
+		return NewKubernetesIPAMWithClient(containerID, "net1", ipamConf, "kube-system", *NewKubernetesClient(wbClient, k8sClient))
+	}
+	exhaustedCondition := func() *metav1.Condition {
+		pool, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(context.TODO(), "10.0.0.0-30", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return meta.FindStatusCondition(pool.Status.Conditions, whereaboutsv1alpha1.IPPoolExhausted)
+	}
+
+	if _, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, newIPAM("container-a"), ipamConf); err == nil {
+		t.Fatalf("Expected the allocation to fail on the exhausted pool")
+	}
+	if condition := exhaustedCondition(); condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != NoFreeIPReason {
+		t.Errorf("Expected the pool to be marked as exhausted, got %+v", condition)
+	}
+	events, err := k8sClient.CoreV1().Events("default").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(events.Items) != 1 || events.Items[0].Reason != IPPoolExhaustedReason || events.Items[0].InvolvedObject.Name != "pod-a" ||
+		events.Items[0].InvolvedObject.UID != "uid-a" || events.Items[0].Type != v1.EventTypeWarning {
+		t.Errorf("Expected a warning event on pod-a, got %+v", events.Items)
+	}
+
+	wbClient.ClearActions()
+	if _, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, newIPAM("container-a"), ipamConf); err == nil {
+		t.Fatalf("Expected the retry to fail on the exhausted pool")
+	}
+	for _, action := range wbClient.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("Expected the retry to leave the exhausted condition alone, got %v", action)
+		}
+	}
+	events, err = k8sClient.CoreV1().Events("default").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(events.Items) != 1 || events.Items[0].Count != 2 {
+		t.Errorf("Expected the retry to be counted on the event of pod-a, got %+v", events.Items)
+	}
+
+	podBConf := ipamConf
+	podBConf.PodName = "pod-b"
+	releaseIPAM := NewKubernetesIPAMWithClient("container-b", "net1", podBConf, "kube-system", *NewKubernetesClient(wbClient, k8sClient))
+	if _, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Deallocate, releaseIPAM, podBConf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if condition := exhaustedCondition(); condition == nil || condition.Status != metav1.ConditionFalse {
+		t.Errorf("Expected the release to clear the exhausted condition, got %+v", condition)
+	}
+}
+
+func TestDualStackNodeSlices(t *testing.T) {
+	slicePool := func(sliceRange string) *whereaboutsv1alpha1.IPPool {
+		return &whereaboutsv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            IPPoolName(PoolIdentifier{IpRange: sliceRange, NodeName: "node1", NetworkName: "net"}),
+				Namespace:       "kube-system",
+				ResourceVersion: "1",
+			},
+			Spec: whereaboutsv1alpha1.IPPoolSpec{Range: sliceRange, Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+		}
+	}
+	wbClient := fakewbclient.NewSimpleClientset(slicePool("10.0.1.0/24"), slicePool("fd00:0:0:1::/64"), &whereaboutsv1alpha1.NodeSlicePool{
+		ObjectMeta: metav1.ObjectMeta{Name: "net", Namespace: "kube-system"},
+		Spec: whereaboutsv1alpha1.NodeSlicePoolSpec{
+			Range:            "10.0.0.0/16",
+			SliceSize:        "/24",
+			AdditionalRanges: []whereaboutsv1alpha1.NodeSliceRange{{Range: "fd00::/48", SliceSize: "/64"}},
+		},
+		Status: whereaboutsv1alpha1.NodeSlicePoolStatus{Allocations: []whereaboutsv1alpha1.NodeSliceAllocation{
+			{NodeName: "node0", SliceRange: "10.0.0.0/24", AdditionalSliceRanges: []string{"fd00::/64"}},
+			{NodeName: "node1", SliceRange: "10.0.1.0/24", AdditionalSliceRanges: []string{"fd00:0:0:1::/64"}},
+		}},
+	})
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges: []whereaboutstypes.RangeConfiguration{
+			{Range: "10.0.0.0/16"},
+			{Range: "fd00::/48", NodeSliceSize: "/64"},
+		},
+		NodeSliceSize: "/24",
+		NetworkName:   "net",
+		PodNamespace:  "default",
+		PodName:       "pod-a",
+	}
+	ipam := NewKubernetesIPAMWithClient("container", "net1", ipamConf, "kube-system",
+		*NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset()))
+	ipam.NodeName = "node1"
+
+	ips, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, ipam, ipamConf)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ips) != 2 || ips[0].IP.String() != "10.0.1.1" || ips[1].IP.String() != "fd00:0:0:1::1" {
+		t.Errorf("Expected an IP of each of the node slices to be allocated, got %v", ips)
+	}
+
+	if _, err := GetNodeSliceOfRange(context.TODO(), ipam, "node1", "fd01::/48"); err == nil {
+		t.Errorf("Expected no node slice of a range foreign to the network")
+	}
+}
+
+func TestNodeSliceGatewayStrategy(t *testing.T) {
+	wbClient := fakewbclient.NewSimpleClientset(
+		&whereaboutsv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            IPPoolName(PoolIdentifier{IpRange: "10.0.1.0/24", NodeName: "node1", NetworkName: "net"}),
+				Namespace:       "kube-system",
+				ResourceVersion: "1",
+			},
+			Spec: whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.1.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+		},
+		&whereaboutsv1alpha1.NodeSlicePool{
+			ObjectMeta: metav1.ObjectMeta{Name: "net", Namespace: "kube-system"},
+			Spec:       whereaboutsv1alpha1.NodeSlicePoolSpec{Range: "10.0.0.0/16", SliceSize: "/24"},
+			Status: whereaboutsv1alpha1.NodeSlicePoolStatus{Allocations: []whereaboutsv1alpha1.NodeSliceAllocation{
+				{NodeName: "node1", SliceRange: "10.0.1.0/24"},
+			}},
+		})
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:        []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/16"}},
+		NodeSliceSize:   "/24",
+		GatewayStrategy: whereaboutstypes.GatewayStrategyPerNodeSliceFirstIP,
+		NetworkName:     "net",
+		PodNamespace:    "default",
+		PodName:         "pod-a",
+	}
+	ipam := NewKubernetesIPAMWithClient("container", "net1", ipamConf, "kube-system",
+		*NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset()))
+	ipam.NodeName = "node1"
+
+	ips, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, ipam, ipamConf)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ips) != 1 || ips[0].IP.String() != "10.0.1.2" {
+		t.Fatalf("Expected the IP following the gateway of the node slice to be allocated, got %v", ips)
+	}
+	if gateway := ipamConf.GatewayOf(ips[0].IP); gateway.String() != "10.0.1.1" {
+		t.Errorf("Expected the first IP of the node slice to be the gateway, got %v", gateway)
+	}
+}
+
+func TestDeallocationRanges(t *testing.T) {
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:          []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}, {Range: "10.0.1.0/24"}},
+		OverlappingRanges: true,
+		PodNamespace:      "default",
+		PodName:           "pod-a",
+	}
+	pool := func(name, ipRange string) *whereaboutsv1alpha1.IPPool {
+		return &whereaboutsv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-system", ResourceVersion: "1"},
+			Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: ipRange, Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+		}
+	}
+
+	cases := []struct {
+		name              string
+		reservationLabels map[string]string
+	}{
+		// the reservation of the other range is not indexed, e.g. created before the index
+		{name: "Reservations partly indexed by container ID", reservationLabels: map[string]string{ContainerIDLabel: "container"}},
+		{name: "Reservations predating the index"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			allocation := whereaboutsv1alpha1.IPAllocation{ContainerID: "container", PodRef: "default/pod-a", IfName: "net1"}
+			firstPool := pool("10.0.0.0-24", "10.0.0.0/24")
+			firstPool.Spec.Allocations["1"] = allocation
+			secondPool := pool("10.0.1.0-24", "10.0.1.0/24")
+			secondPool.Spec.Allocations["1"] = allocation
+			indexedReservation := overlappingRangeIPReservation("10.0.0.1", "default/pod-a")
+			indexedReservation.Spec.ContainerID = "container"
+			indexedReservation.Spec.IfName = "net1"
+			indexedReservation.SetLabels(tc.reservationLabels)
+			reservation := overlappingRangeIPReservation("10.0.1.1", "default/pod-a")
+			reservation.Spec.ContainerID = "container"
+			reservation.Spec.IfName = "net1"
+			wbClient := fakewbclient.NewSimpleClientset(firstPool, secondPool, indexedReservation, reservation)
+			ipam := NewKubernetesIPAMWithClient("container", "net1", ipamConf, "kube-system",
+				*NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset()))
+
+			if _, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Deallocate, ipam, ipamConf); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			for _, poolName := range []string{"10.0.0.0-24", "10.0.1.0-24"} {
+				releasedPool, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(context.TODO(), poolName, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if len(releasedPool.Spec.Allocations) != 0 {
+					t.Errorf("Expected the allocation of the container to be released from %s, got %v", poolName, releasedPool.Spec.Allocations)
+				}
+			}
+		})
+	}
+}
+
+func TestDeallocateBatch(t *testing.T) {
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:            []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}, {Range: "10.0.1.0/24"}},
+		OverlappingRanges:   true,
+		LeaderLeaseDuration: whereaboutstypes.DefaultLeaderLeaseDuration,
+		LeaderRenewDeadline: whereaboutstypes.DefaultLeaderRenewDeadline,
+		LeaderRetryPeriod:   whereaboutstypes.DefaultLeaderRetryPeriod,
+		PodNamespace:        "default",
+		PodName:             "pod-a",
+	}
+	pool := func(name, ipRange string, allocations map[string]whereaboutsv1alpha1.IPAllocation) *whereaboutsv1alpha1.IPPool {
+		return &whereaboutsv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-system", ResourceVersion: "1"},
+			Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: ipRange, Allocations: allocations},
+		}
+	}
+	wbClient := fakewbclient.NewSimpleClientset(
+		pool("10.0.0.0-24", "10.0.0.0/24", map[string]whereaboutsv1alpha1.IPAllocation{
+			"1": {ContainerID: "container", PodRef: "default/pod-a", IfName: "net1"},
+			"2": {ContainerID: "container", PodRef: "default/pod-a", IfName: "net2"},
+			"3": {ContainerID: "other", PodRef: "default/pod-b", IfName: "net1"},
+		}),
+		pool("10.0.1.0-24", "10.0.1.0/24", map[string]whereaboutsv1alpha1.IPAllocation{
+			"1": {ContainerID: "container", PodRef: "default/pod-a", IfName: "net3"},
+		}),
+		overlappingRangeIPReservation("10.0.0.1", "default/pod-a"),
+		overlappingRangeIPReservation("10.0.0.2", "default/pod-a"),
+		overlappingRangeIPReservation("10.0.0.3", "default/pod-b"),
+		overlappingRangeIPReservation("10.0.1.1", "default/pod-a"),
+	)
+	ipam := NewKubernetesIPAMWithClient("", "", ipamConf, "kube-system", *NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset()))
+	deallocation := func(poolName string, ipRange whereaboutstypes.RangeConfiguration, ifName string) PodDeallocation {
+		return PodDeallocation{IPAMConfig: ipamConf, Range: ipRange, PoolName: poolName, PoolNamespace: "kube-system",
+			ContainerID: "container", IfName: ifName}
+	}
+	deallocations := []PodDeallocation{
+		deallocation("10.0.0.0-24", ipamConf.IPRanges[0], "net1"),
+		deallocation("10.0.1.0-24", ipamConf.IPRanges[1], "net3"),
+		deallocation("10.0.0.0-24", ipamConf.IPRanges[0], "net2"),
+	}
+
+	released, err := DeallocateBatch(context.TODO(), ipam, deallocations)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(released) != len(deallocations) {
+		t.Errorf("Expected all the %d deallocations to be released, got %v", len(deallocations), released)
+	}
+
+	// each pool is updated once, whatever the number of allocations it releases
+	poolsUpdated := 0
+	for _, action := range wbClient.Actions() {
+		if (action.GetVerb() == "update" || action.GetVerb() == "patch") && action.GetResource().Resource == "ippools" {
+			poolsUpdated++
+		}
+	}
+	if poolsUpdated != 2 {
+		t.Errorf("Expected 2 IP pool updates, got %d", poolsUpdated)
+	}
+	releasedPool, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(context.TODO(), "10.0.0.0-24", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, kept := releasedPool.Spec.Allocations["3"]; !kept || len(releasedPool.Spec.Allocations) != 1 {
+		t.Errorf("Expected only the allocation of the other pod to be kept, got %v", releasedPool.Spec.Allocations)
+	}
+	reservations, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations("kube-system").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(reservations.Items) != 1 || reservations.Items[0].Name != "10.0.0.3" {
+		t.Errorf("Expected only the reservation of the other pod to be kept, got %v", reservations.Items)
+	}
+}
+
+func TestContainerIDLabel(t *testing.T) {
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+		Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+	})
+	// container IDs are longer than label values may be
+	containerID := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:          []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+		OverlappingRanges: true,
+		PodNamespace:      "default",
+		PodName:           "pod-a",
+	}
+	ipam := NewKubernetesIPAMWithClient(containerID, "net1", ipamConf, "kube-system",
+		*NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset()))
+
+	if _, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, ipam, ipamConf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	store := &KubernetesOverlappingRangeStore{wbClient, "kube-system"}
+	ips, err := store.ListContainerReservedIPs(context.TODO(), containerID, "net1", UnnamedNetwork)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ips) != 1 || ips[0].String() != "10.0.0.1" {
+		t.Errorf("Expected the reservation of 10.0.0.1 to be indexed by the container ID, got %v", ips)
+	}
+}
+
+func TestResourceLabels(t *testing.T) {
+	wbClient := fakewbclient.NewSimpleClientset()
+	// the pool is created by the allocation: the fake client sets no resource version, which the pool updates test
+	wbClient.PrependReactor("create", "ippools", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*whereaboutsv1alpha1.IPPool).SetResourceVersion("1")
+		return false, nil, nil
+	})
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:          []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+		NetworkName:       "net1",
+		OverlappingRanges: true,
+		PodNamespace:      "default",
+		PodName:           "pod-a",
+	}
+	ipam := NewKubernetesIPAMWithClient("container", "eth1", ipamConf, "kube-system",
+		*NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset()))
+
+	if _, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, ipam, ipamConf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	listOptions := metav1.ListOptions{LabelSelector: ResourceSelector("net1", "10.0.0.0/24").String()}
+	pools, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").List(context.TODO(), listOptions)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(pools.Items) != 1 || pools.Items[0].GetName() != "net1-10.0.0.0-24" {
+		t.Errorf("Expected the pool net1-10.0.0.0-24 to be selected, got %v", pools.Items)
+	}
+	reservations, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations("kube-system").List(context.TODO(), listOptions)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(reservations.Items) != 1 || reservations.Items[0].GetName() != "net1-10.0.0.1" {
+		t.Errorf("Expected the reservation net1-10.0.0.1 to be selected, got %v", reservations.Items)
+	}
+
+	listOptions = metav1.ListOptions{LabelSelector: ResourceSelector("net2", "").String()}
+	pools, err = wbClient.WhereaboutsV1alpha1().IPPools("kube-system").List(context.TODO(), listOptions)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(pools.Items) != 0 {
+		t.Errorf("Expected no pool of network net2, got %v", pools.Items)
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	cases := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{name: "IPv4 range", value: normalizeRange("10.0.0.0/24"), expected: "10.0.0.0-24"},
+		{name: "IPv6 range", value: normalizeRange("fd00::/64"), expected: "fd00---64"},
+		{name: "IPv6 range starting with a colon", value: normalizeRange("::/0"), expected: "0"},
+		{name: "Truncated value", value: strings.Repeat("a", 62) + "-b", expected: strings.Repeat("a", 62)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if value := labelValue(tc.value); value != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, value)
+			}
+		})
+	}
+}
+
+func TestNoLeaderElection(t *testing.T) {
+	cases := []struct {
+		name             string
+		conflicts        int
+		expectedElection bool
+	}{
+		{name: "Allocation without conflicts", conflicts: 0},
+		{name: "Allocation losing fewer races than allowed", conflicts: 2},
+		{name: "Allocation falling back to leader election", conflicts: 3, expectedElection: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+				Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+			})
+			// the first updates of the pool lose the race against other pods
+			conflicts := 0
+			wbClient.PrependReactor("patch", "ippools", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if conflicts < tc.conflicts {
+					conflicts++
+					return true, nil, apierrors.NewInvalid(schema.GroupKind{Kind: "IPPool"}, "10.0.0.0-24", nil)
+				}
+				return false, nil, nil
+			})
+			k8sClient := fakek8sclient.NewSimpleClientset()
+			ipamConf := whereaboutstypes.IPAMConfig{
+				IPRanges:                []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+				NoLeaderElection:        true,
+				LeaderFallbackConflicts: whereaboutstypes.DefaultLeaderFallbackConflicts,
+				LeaderLeaseDuration:     whereaboutstypes.DefaultLeaderLeaseDuration,
+				LeaderRenewDeadline:     whereaboutstypes.DefaultLeaderRenewDeadline,
+				LeaderRetryPeriod:       whereaboutstypes.DefaultLeaderRetryPeriod,
+				BackoffBaseMs:           1,
+				BackoffMaxMs:            1,
+				PodNamespace:            "default",
+				PodName:                 "pod-a",
+			}
+			ipam := NewKubernetesIPAMWithClient("container", "net1", ipamConf, "kube-system", *NewKubernetesClient(wbClient, k8sClient))
+
+			ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+			defer cancel()
+			ips, err := IPManagement(ctx, whereaboutstypes.Allocate, ipamConf, ipam)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(ips) != 1 || ips[0].IP.String() != "10.0.0.1" {
+				t.Errorf("Expected 10.0.0.1 to be allocated, got %v", ips)
+			}
+
+			elected := false
+			for _, action := range k8sClient.Actions() {
+				if action.GetResource().Resource == "leases" {
+					elected = true
+				}
+			}
+			if elected != tc.expectedElection {
+				t.Errorf("Expected the leader election to be used: %v, got %v", tc.expectedElection, elected)
+			}
+		})
+	}
+}
+
+func TestLRUAllocation(t *testing.T) {
+	releasedLongAgo := metav1.NewTime(time.Now().Add(-time.Hour))
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+		Spec: whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{
+			"1": {ContainerID: "container-a", PodRef: "default/pod-a", IfName: "net1"},
+		}},
+		Status: whereaboutsv1alpha1.IPPoolStatus{Released: map[string]metav1.Time{"2": releasedLongAgo}},
+	})
+	k8sClient := fakek8sclient.NewSimpleClientset()
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:      []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24", AllocationStrategy: whereaboutstypes.LRUAllocation}},
+		BackoffBaseMs: 1,
+		BackoffMaxMs:  1,
+		PodNamespace:  "default",
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+
+	ipamConf.PodName = "pod-a"
+	ipam := NewKubernetesIPAMWithClient("container-a", "net1", ipamConf, "kube-system", *NewKubernetesClient(wbClient, k8sClient))
+	if _, err := IPManagementKubernetesUpdate(ctx, whereaboutstypes.Deallocate, ipam, ipamConf); err != nil {
+		t.Fatalf("Expected no error releasing the IP, got %v", err)
+	}
+	pool, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(ctx, "10.0.0.0-24", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error getting the pool, got %v", err)
+	}
+	if _, recorded := pool.Status.Released["1"]; !recorded || len(pool.Status.Released) != 2 {
+		t.Errorf("Expected the release of 10.0.0.1 to be recorded, got %v", pool.Status.Released)
+	}
+
+	// the released IPs are only handed out once the IPs never released are exhausted
+	ipamConf.PodName = "pod-b"
+	ipam = NewKubernetesIPAMWithClient("container-b", "net1", ipamConf, "kube-system", *NewKubernetesClient(wbClient, k8sClient))
+	ips, err := IPManagementKubernetesUpdate(ctx, whereaboutstypes.Allocate, ipam, ipamConf)
+	if err != nil {
+		t.Fatalf("Expected no error allocating the IP, got %v", err)
+	}
+	if len(ips) != 1 || ips[0].IP.String() != "10.0.0.3" {
+		t.Errorf("Expected 10.0.0.3 to be allocated, got %v", ips)
+	}
+
+	// the release of the IPs outside of a DEL, e.g. by the reconciler, is recorded as well
+	client := NewKubernetesClient(wbClient, k8sClient)
+	reconciledPool, err := client.GetIPPool(ctx, "kube-system", "10.0.0.0-24")
+	if err != nil {
+		t.Fatalf("Expected no error getting the pool, got %v", err)
+	}
+	if err := reconciledPool.Update(ctx, nil); err != nil {
+		t.Fatalf("Expected no error releasing the IP, got %v", err)
+	}
+	pool, err = wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(ctx, "10.0.0.0-24", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error getting the pool, got %v", err)
+	}
+	if _, recorded := pool.Status.Released["3"]; !recorded {
+		t.Errorf("Expected the release of 10.0.0.3 to be recorded, got %v", pool.Status.Released)
+	}
+}
+
+func TestVerifyUnused(t *testing.T) {
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+		Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+	})
+	k8sClient := fakek8sclient.NewSimpleClientset()
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:      []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+		VerifyUnused:  whereaboutstypes.VerifyUnusedICMP,
+		BackoffBaseMs: 1,
+		BackoffMaxMs:  1,
+		PodNamespace:  "default",
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+
+	// an unmanaged device of the VLAN answers on 10.0.0.1
+	var verified []string
+	ipInUse := func(ctx context.Context, ip net.IP, timeout time.Duration) (bool, error) {
+		verified = append(verified, ip.String())
+		return ip.Equal(net.ParseIP("10.0.0.1")), nil
+	}
+
+	for _, tc := range []struct {
+		podName          string
+		expectedIP       string
+		expectedVerified []string
+	}{
+		{podName: "pod-a", expectedIP: "10.0.0.2", expectedVerified: []string{"10.0.0.1", "10.0.0.2"}},
+		{podName: "pod-b", expectedIP: "10.0.0.3", expectedVerified: []string{"10.0.0.3"}},
+	} {
+		verified = nil
+		ipamConf.PodName = tc.podName
+		ipam := NewKubernetesIPAMWithClient("container-"+tc.podName, "net1", ipamConf, "kube-system", *NewKubernetesClient(wbClient, k8sClient))
+		ipam.ipInUse = ipInUse
+		ips, err := IPManagementKubernetesUpdate(ctx, whereaboutstypes.Allocate, ipam, ipamConf)
+		if err != nil {
+			t.Fatalf("Expected no error allocating the IP of %s, got %v", tc.podName, err)
+		}
+		if len(ips) != 1 || ips[0].IP.String() != tc.expectedIP {
+			t.Errorf("Expected %s to be allocated to %s, got %v", tc.expectedIP, tc.podName, ips)
+		}
+		if !reflect.DeepEqual(verified, tc.expectedVerified) {
+			t.Errorf("Expected %v to be verified for %s, got %v", tc.expectedVerified, tc.podName, verified)
+		}
+	}
+
+	pool, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(ctx, "10.0.0.0-24", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error getting the pool, got %v", err)
+	}
+	if _, recorded := pool.Status.ExternallyUsed["1"]; !recorded || len(pool.Status.ExternallyUsed) != 1 {
+		t.Errorf("Expected 10.0.0.1 to be recorded as externally used, got %v", pool.Status.ExternallyUsed)
+	}
+	if _, allocated := pool.Spec.Allocations["1"]; allocated {
+		t.Errorf("Expected 10.0.0.1 not to be allocated, got %v", pool.Spec.Allocations)
+	}
+}
+
+func TestAllocationOwner(t *testing.T) {
+	allocatedLongAgo := metav1.NewTime(time.Now().Add(-time.Hour))
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+		Spec: whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{
+			"1": {ContainerID: "container-a", PodRef: "default/pod-a", PodUID: "uid-a1", IfName: "net1", AllocatedAt: &allocatedLongAgo},
+			"3": {ContainerID: "container-c", PodRef: "default/pod-c", IfName: "net1"},
+		}},
+	})
+	k8sClient := fakek8sclient.NewSimpleClientset()
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:      []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+		BackoffBaseMs: 1,
+		BackoffMaxMs:  1,
+		PodNamespace:  "default",
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		podName       string
+		podUID        string
+		expectedIndex string
+		expectedNew   bool
+	}{
+		{podName: "pod-a", podUID: "uid-a1", expectedIndex: "1"},
+		// the next incarnation of the pod takes the allocation over
+		{podName: "pod-a", podUID: "uid-a2", expectedIndex: "1", expectedNew: true},
+		{podName: "pod-b", podUID: "uid-b", expectedIndex: "2", expectedNew: true},
+		// the allocation made before the UIDs were recorded is stamped on its reuse
+		{podName: "pod-c", podUID: "uid-c", expectedIndex: "3", expectedNew: true},
+	} {
+		ipamConf.PodName = tc.podName
+		ipamConf.PodUID = tc.podUID
+		ipam := NewKubernetesIPAMWithClient("container-"+tc.podUID, "net1", ipamConf, "kube-system", *NewKubernetesClient(wbClient, k8sClient))
+		if _, err := IPManagementKubernetesUpdate(ctx, whereaboutstypes.Allocate, ipam, ipamConf); err != nil {
+			t.Fatalf("Expected no error allocating the IP of %s, got %v", tc.podUID, err)
+		}
+		pool, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(ctx, "10.0.0.0-24", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Expected no error getting the pool, got %v", err)
+		}
+		allocation := pool.Spec.Allocations[tc.expectedIndex]
+		if allocation.PodUID != tc.podUID || allocation.AllocatedAt == nil {
+			t.Fatalf("Expected the allocation %s to be stamped with %s and its allocation time, got %+v", tc.expectedIndex, tc.podUID, allocation)
+		}
+		if isNew := time.Since(allocation.AllocatedAt.Time) < time.Minute; isNew != tc.expectedNew {
+			t.Errorf("Expected the allocation of %s to be new: %t, allocated at %s", tc.podUID, tc.expectedNew, allocation.AllocatedAt)
+		}
+	}
+}
+
+func TestStickyIPs(t *testing.T) {
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+		Spec: whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{
+			"5": {ContainerID: "container-x", PodRef: "default/pod-x", IfName: "net1"},
+		}},
+		Status: whereaboutsv1alpha1.IPPoolStatus{StickyIPs: map[string]string{
+			"default/pod-a": "5",
+			"default/pod-b": "7",
+			"default/pod-c": "1",
+		}},
+	})
+	k8sClient := fakek8sclient.NewSimpleClientset()
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:        []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+		EnableStickyIPs: true,
+		BackoffBaseMs:   1,
+		BackoffMaxMs:    1,
+		PodNamespace:    "default",
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		podName    string
+		expectedIP string
+	}{
+		// the IP pod-a held is allocated to another pod meanwhile
+		{podName: "pod-a", expectedIP: "10.0.0.1"},
+		{podName: "pod-b", expectedIP: "10.0.0.7"},
+	} {
+		ipamConf.PodName = tc.podName
+		ipam := NewKubernetesIPAMWithClient("container-"+tc.podName, "net1", ipamConf, "kube-system", *NewKubernetesClient(wbClient, k8sClient))
+		ips, err := IPManagementKubernetesUpdate(ctx, whereaboutstypes.Allocate, ipam, ipamConf)
+		if err != nil {
+			t.Fatalf("Expected no error allocating the IP of %s, got %v", tc.podName, err)
+		}
+		if len(ips) != 1 || ips[0].IP.String() != tc.expectedIP {
+			t.Errorf("Expected %s to be allocated to %s, got %v", tc.expectedIP, tc.podName, ips)
+		}
+	}
+
+	pool, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(ctx, "10.0.0.0-24", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error getting the pool, got %v", err)
+	}
+	// 10.0.0.1 is no longer sticky to pod-c
+	expectedStickyIPs := map[string]string{"default/pod-a": "1", "default/pod-b": "7"}
+	if !reflect.DeepEqual(pool.Status.StickyIPs, expectedStickyIPs) {
+		t.Errorf("Expected the sticky IPs %v, got %v", expectedStickyIPs, pool.Status.StickyIPs)
+	}
+}
+
+func TestMACReservations(t *testing.T) {
+	const (
+		vmMAC    = "0a:58:0a:00:00:05"
+		otherMAC = "0a:58:0a:00:00:09"
+	)
+	wbClient := fakewbclient.NewSimpleClientset(
+		&whereaboutsv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+			Spec: whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{
+				"5": {ContainerID: "container-vm-old", PodRef: "default/vm-old", IfName: "net1", MAC: vmMAC},
+			}},
+			Status: whereaboutsv1alpha1.IPPoolStatus{StickyIPs: map[string]string{otherMAC: "9"}},
+		},
+		overlappingRangeIPReservation("10.0.0.5", "default/vm-old"))
+	k8sClient := fakek8sclient.NewSimpleClientset()
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:          []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+		ReservationKey:    whereaboutstypes.ReservationKeyMAC,
+		OverlappingRanges: true,
+		PodNamespace:      "default",
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		podName    string
+		mac        string
+		expectedIP string
+	}{
+		// the allocation of the MAC address is handed over from the previous pod of the virtual machine
+		{podName: "vm-new", mac: vmMAC, expectedIP: "10.0.0.5"},
+		// the IP last allocated to the MAC address is allocated again
+		{podName: "other", mac: otherMAC, expectedIP: "10.0.0.9"},
+	} {
+		ipamConf.PodName = tc.podName
+		ipamConf.MAC = tc.mac
+		ipam := NewKubernetesIPAMWithClient("container-"+tc.podName, "net1", ipamConf, "kube-system", *NewKubernetesClient(wbClient, k8sClient))
+		ips, err := IPManagementKubernetesUpdate(ctx, whereaboutstypes.Allocate, ipam, ipamConf)
+		if err != nil {
+			t.Fatalf("Expected no error allocating the IP of %s, got %v", tc.podName, err)
+		}
+		if len(ips) != 1 || ips[0].IP.String() != tc.expectedIP {
+			t.Errorf("Expected %s to be allocated to %s, got %v", tc.expectedIP, tc.podName, ips)
+		}
+	}
+
+	// the deletion of the previous pod of the virtual machine leaves the allocation handed over alone
+	ipamConf.PodName = "vm-old"
+	ipamConf.MAC = vmMAC
+	ipam := NewKubernetesIPAMWithClient("container-vm-old", "net1", ipamConf, "kube-system", *NewKubernetesClient(wbClient, k8sClient))
+	if _, err := IPManagementKubernetesUpdate(ctx, whereaboutstypes.Deallocate, ipam, ipamConf); err != nil {
+		t.Fatalf("Expected no error releasing the IP of vm-old, got %v", err)
+	}
+
+	pool, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(ctx, "10.0.0.0-24", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error getting the pool, got %v", err)
+	}
+	if allocation := pool.Spec.Allocations["5"]; allocation.ContainerID != "container-vm-new" || allocation.PodRef != "default/vm-new" ||
+		allocation.MAC != vmMAC {
+		t.Errorf("Expected 10.0.0.5 to be handed over to vm-new, got %+v", allocation)
+	}
+	if allocation := pool.Spec.Allocations["9"]; allocation.PodRef != "default/other" || allocation.MAC != otherMAC {
+		t.Errorf("Expected 10.0.0.9 to record the MAC address of other, got %+v", allocation)
+	}
+	expectedStickyIPs := map[string]string{vmMAC: "5", otherMAC: "9"}
+	if !reflect.DeepEqual(pool.Status.StickyIPs, expectedStickyIPs) {
+		t.Errorf("Expected the sticky IPs %v, got %v", expectedStickyIPs, pool.Status.StickyIPs)
+	}
+	reservation, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations("kube-system").Get(ctx, "10.0.0.5", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error getting the cluster wide reservation, got %v", err)
+	}
+	if reservation.Spec.PodRef != "default/vm-new" || reservation.Spec.ContainerID != "container-vm-new" {
+		t.Errorf("Expected the cluster wide reservation to be handed over to vm-new, got %+v", reservation.Spec)
+	}
+
+	ipamConf.PodName = "no-mac"
+	ipamConf.MAC = ""
+	ipam = NewKubernetesIPAMWithClient("container-no-mac", "net1", ipamConf, "kube-system", *NewKubernetesClient(wbClient, k8sClient))
+	if _, err := IPManagementKubernetesUpdate(ctx, whereaboutstypes.Allocate, ipam, ipamConf); err == nil {
+		t.Errorf("Expected the allocation of an interface without MAC address to fail")
+	}
+}
+
+func TestMACHandover(t *testing.T) {
+	const vmMAC = "0a:58:0a:00:00:05"
+	vmPod := func(name, uid, vmi string, phase v1.PodPhase) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: k8stypes.UID(uid), Labels: map[string]string{VMIdentityLabel: vmi}},
+			Status:     v1.PodStatus{Phase: phase},
+		}
+	}
+
+	for _, tc := range []struct {
+		name           string
+		previousPod    *v1.Pod
+		expectHandover bool
+	}{
+		{name: "previous pod deleted", expectHandover: true},
+		{name: "previous pod recreated", previousPod: vmPod("vm-old", "uid-old-2", "vmi-1", v1.PodRunning), expectHandover: true},
+		{name: "previous pod terminated", previousPod: vmPod("vm-old", "uid-old", "vmi-1", v1.PodSucceeded), expectHandover: true},
+		{name: "previous pod of the same virtual machine", previousPod: vmPod("vm-old", "uid-old", "vmi-1", v1.PodRunning), expectHandover: true},
+		{name: "previous pod of another virtual machine", previousPod: vmPod("vm-old", "uid-old", "vmi-2", v1.PodRunning)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+				Spec: whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{
+					"5": {ContainerID: "container-vm-old", PodRef: "default/vm-old", PodUID: "uid-old", IfName: "net1", MAC: vmMAC},
+				}},
+			})
+			pods := []runtime.Object{vmPod("vm-new", "uid-new", "vmi-1", v1.PodPending)}
+			if tc.previousPod != nil {
+				pods = append(pods, tc.previousPod)
+			}
+			k8sClient := fakek8sclient.NewSimpleClientset(pods...)
+			ipamConf := whereaboutstypes.IPAMConfig{
+				IPRanges:       []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+				ReservationKey: whereaboutstypes.ReservationKeyMAC,
+				PodNamespace:   "default",
+				PodName:        "vm-new",
+				PodUID:         "uid-new",
+				MAC:            vmMAC,
+			}
+			ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+			defer cancel()
+
+			ipam := NewKubernetesIPAMWithClient("container-vm-new", "net1", ipamConf, "kube-system", *NewKubernetesClient(wbClient, k8sClient))
+			ips, err := IPManagementKubernetesUpdate(ctx, whereaboutstypes.Allocate, ipam, ipamConf)
+			if !tc.expectHandover {
+				if err == nil {
+					t.Fatalf("Expected the hand-over of the IP of a running pod to fail, got %v", ips)
+				}
+				pool, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(ctx, "10.0.0.0-24", metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("Expected no error getting the pool, got %v", err)
+				}
+				if allocation := pool.Spec.Allocations["5"]; allocation.PodRef != "default/vm-old" {
+					t.Errorf("Expected 10.0.0.5 to stay allocated to vm-old, got %+v", allocation)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error handing the IP over, got %v", err)
+			}
+			if len(ips) != 1 || ips[0].IP.String() != "10.0.0.5" {
+				t.Errorf("Expected 10.0.0.5 to be handed over to vm-new, got %v", ips)
+			}
+		})
+	}
+}
+
+func TestVerifyUnusedQuarantine(t *testing.T) {
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+		Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+		Status: whereaboutsv1alpha1.IPPoolStatus{ExternallyUsed: map[string]metav1.Time{
+			"1": metav1.NewTime(time.Now().Add(-2 * time.Hour)),
+			"2": metav1.NewTime(time.Now().Add(-time.Minute)),
+		}},
+	})
+	k8sClient := fakek8sclient.NewSimpleClientset()
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:               []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+		VerifyUnused:           whereaboutstypes.VerifyUnusedICMP,
+		VerifyUnusedQuarantine: 3600,
+		BackoffBaseMs:          1,
+		BackoffMaxMs:           1,
+		PodNamespace:           "default",
+		PodName:                "pod-a",
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+
+	// the device which answered on 10.0.0.1 left the VLAN since
+	ipam := NewKubernetesIPAMWithClient("container-a", "net1", ipamConf, "kube-system", *NewKubernetesClient(wbClient, k8sClient))
+	ipam.ipInUse = func(ctx context.Context, ip net.IP, timeout time.Duration) (bool, error) {
+		return false, nil
+	}
+	ips, err := IPManagementKubernetesUpdate(ctx, whereaboutstypes.Allocate, ipam, ipamConf)
+	if err != nil {
+		t.Fatalf("Expected no error allocating the IP, got %v", err)
+	}
+	if len(ips) != 1 || ips[0].IP.String() != "10.0.0.1" {
+		t.Errorf("Expected 10.0.0.1 to be allocated once out of quarantine, got %v", ips)
+	}
+
+	pool, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(ctx, "10.0.0.0-24", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error getting the pool, got %v", err)
+	}
+	if _, quarantined := pool.Status.ExternallyUsed["2"]; !quarantined || len(pool.Status.ExternallyUsed) != 1 {
+		t.Errorf("Expected only 10.0.0.2 to remain externally used, got %v", pool.Status.ExternallyUsed)
+	}
+}
+
+func TestRangeSet(t *testing.T) {
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-30", Namespace: "kube-system", ResourceVersion: "1"},
+		Spec: whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/30", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{
+			"1": {ContainerID: "container-x", PodRef: "default/pod-x", IfName: "net1"},
+			"2": {ContainerID: "container-y", PodRef: "default/pod-y", IfName: "net1"},
+		}},
+	})
+	k8sClient := fakek8sclient.NewSimpleClientset()
+	ipRange := whereaboutstypes.RangeConfiguration{RangeSet: []string{"10.0.0.0/30", "10.0.9.0/24"}}
+	if err := ipRange.Normalize(); err != nil {
+		t.Fatalf("Expected no error normalizing the range set, got %v", err)
+	}
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:      []whereaboutstypes.RangeConfiguration{ipRange},
+		BackoffBaseMs: 1,
+		BackoffMaxMs:  1,
+		PodNamespace:  "default",
+		PodName:       "pod-a",
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+
+	ipam := NewKubernetesIPAMWithClient("container-a", "net1", ipamConf, "kube-system", *NewKubernetesClient(wbClient, k8sClient))
+	ips, err := IPManagementKubernetesUpdate(ctx, whereaboutstypes.Allocate, ipam, ipamConf)
+	if err != nil {
+		t.Fatalf("Expected no error allocating the IP, got %v", err)
+	}
+	if len(ips) != 1 || ips[0].String() != "10.0.9.1/24" {
+		t.Errorf("Expected 10.0.9.1/24 to be allocated once 10.0.0.0/30 is exhausted, got %v", ips)
+	}
+
+	pools, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error listing the pools, got %v", err)
+	}
+	if len(pools.Items) != 1 {
+		t.Fatalf("Expected the range set to be a single pool, got %d pools", len(pools.Items))
+	}
+	pool := pools.Items[0]
+	if !reflect.DeepEqual(pool.Spec.RangeSet, ipRange.RangeSet) {
+		t.Errorf("Expected the range set %v in the pool spec, got %v", ipRange.RangeSet, pool.Spec.RangeSet)
+	}
+	if len(pool.Spec.Allocations) != 3 {
+		t.Errorf("Expected 3 allocations in the pool, got %v", pool.Spec.Allocations)
+	}
+}
+
+func TestIPManagementTracing(t *testing.T) {
+	spanNames := make(chan []string, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						Name string `json:"name"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("Expected an export request, got %v", err)
+		}
+		var names []string
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				for _, span := range scopeSpans.Spans {
+					names = append(names, span.Name)
+				}
+			}
+		}
+		spanNames <- names
+	}))
+	defer collector.Close()
+
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+		Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+	})
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:          []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+		OverlappingRanges: true,
+		PodName:           "pod",
+		PodNamespace:      "default",
+		TracingEndpoint:   collector.URL,
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+
+	ctx, finish := tracing.Trace(ctx, ipamConf.TracingEndpoint, "whereabouts.add")
+	ipam := NewKubernetesIPAMWithClient("container", "net1", ipamConf, "kube-system", *NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset()))
+	if _, err := IPManagementKubernetesUpdate(ctx, whereaboutstypes.Allocate, ipam, ipamConf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := finish(nil); err != nil {
+		t.Fatalf("Expected the spans to be exported, got %v", err)
+	}
+
+	names := <-spanNames
+	sort.Strings(names)
+	expectedNames := []string{"ipam.update", "ippool.get", "ippool.update", "overlappingrange.get", "overlappingrange.update", "whereabouts.add"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("Expected the spans %v, got %v", expectedNames, names)
+	}
+}
+
+func TestVerifyUnusedOutsideLease(t *testing.T) {
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+		Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+	})
+	k8sClient := fakek8sclient.NewSimpleClientset()
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:            []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+		VerifyUnused:        whereaboutstypes.VerifyUnusedICMP,
+		VerifyUnusedTimeout: 100,
+		BackoffBaseMs:       1,
+		BackoffMaxMs:        1,
+		PodNamespace:        "default",
+		PodName:             "pod-a",
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+
+	// unmanaged devices of the VLAN answer on 10.0.0.1 and 10.0.0.2
+	var leaseHeld bool
+	var verified []string
+	ipam := NewKubernetesIPAMWithClient("container-a", "net1", ipamConf, "kube-system", *NewKubernetesClient(wbClient, k8sClient))
+	ipam.ipInUse = func(ctx context.Context, ip net.IP, timeout time.Duration) (bool, error) {
+		if leaseHeld {
+			t.Errorf("Expected IP %s not to be verified while holding the lease", ip)
+		}
+		if timeout != 100*time.Millisecond {
+			t.Errorf("Expected the verify unused timeout of the configuration, got %s", timeout)
+		}
+		verified = append(verified, ip.String())
+		return ip.Equal(net.ParseIP("10.0.0.1")) || ip.Equal(net.ParseIP("10.0.0.2")), nil
+	}
+
+	var ips []net.IPNet
+	var elections int
+	err := ipam.verifyingOutsideLease(ctx, whereaboutstypes.Allocate, ipamConf, func() error {
+		elections++
+		leaseHeld = true
+		defer func() { leaseHeld = false }()
+		var err error
+		ips, err = IPManagementKubernetesUpdate(ctx, whereaboutstypes.Allocate, ipam, ipamConf)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Expected no error allocating the IP, got %v", err)
+	}
+	if len(ips) != 1 || ips[0].IP.String() != "10.0.0.3" {
+		t.Errorf("Expected 10.0.0.3 to be allocated, got %v", ips)
+	}
+	if expected := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}; !reflect.DeepEqual(verified, expected) {
+		t.Errorf("Expected %v to be verified, got %v", expected, verified)
+	}
+	if elections != 4 {
+		t.Errorf("Expected the lease to be taken once per verification and once to allocate, got %d elections", elections)
+	}
+
+	pool, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(ctx, "10.0.0.0-24", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error getting the pool, got %v", err)
+	}
+	if len(pool.Status.ExternallyUsed) != 2 {
+		t.Errorf("Expected 10.0.0.1 and 10.0.0.2 to be recorded as externally used, got %v", pool.Status.ExternallyUsed)
+	}
+}
+
+func TestLockedShard(t *testing.T) {
+	shardPool := func(poolRange string, locked bool) *whereaboutsv1alpha1.IPPool {
+		pool := &whereaboutsv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            IPPoolName(PoolIdentifier{IpRange: poolRange, NetworkName: UnnamedNetwork, Shard: true}),
+				Namespace:       "kube-system",
+				ResourceVersion: "1",
+			},
+			Spec: whereaboutsv1alpha1.IPPoolSpec{Range: poolRange, Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+		}
+		if locked {
+			pool.SetAnnotations(map[string]string{PoolLockedAnnotation: "true"})
+		}
+		return pool
+	}
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:     []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+		PoolShards:   2,
+		PodNamespace: "default",
+		PodName:      "pod-a",
+	}
+
+	wbClient := fakewbclient.NewSimpleClientset(shardPool("10.0.0.0/25", true), shardPool("10.0.0.128/25", false))
+	ipam := NewKubernetesIPAMWithClient("container-a", "net1", ipamConf, "kube-system",
+		*NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset()))
+	ips, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, ipam, ipamConf)
+	if err != nil {
+		t.Fatalf("Expected the allocation to move on to the unlocked shard, got %v", err)
+	}
+	if len(ips) != 1 || ips[0].IP.String() != "10.0.0.128" {
+		t.Errorf("Expected the first IP of the unlocked shard, got %v", ips)
+	}
+
+	wbClient = fakewbclient.NewSimpleClientset(shardPool("10.0.0.0/25", true), shardPool("10.0.0.128/25", true))
+	ipam = NewKubernetesIPAMWithClient("container-a", "net1", ipamConf, "kube-system",
+		*NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset()))
+	if _, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, ipam, ipamConf); err == nil ||
+		!strings.Contains(err.Error(), "is locked by administrator") {
+		t.Errorf("Expected the allocation to fail once every shard is locked, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package kubernetes
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// The labels the IP pools and the cluster wide reservations are created with, so that they can be listed by network
+// or range through a label selector rather than filtered client side. The values are normalized like the names of the
+// pools, e.g. 10.0.0.0-24 or fd00---64, and truncated to the 63 characters of label values.
+const (
+	// NetworkNameLabel holds the network name of named networks; the resources of unnamed networks do not carry it
+	NetworkNameLabel = "whereabouts.cni.cncf.io/network-name"
+	// RangeLabel holds the range of the IP pool - the slice or the shard of the network range, for those pools - or the
+	// network range the reserved IP was allocated from
+	RangeLabel = "whereabouts.cni.cncf.io/range"
+)
+
+// resourceLabels returns the labels of the resources of the range of the network
+func resourceLabels(networkName, ipRange string) map[string]string {
+	resourceLabels := map[string]string{}
+	if networkName != UnnamedNetwork {
+		resourceLabels[NetworkNameLabel] = labelValue(networkName)
+	}
+	if ipRange != "" {
+		resourceLabels[RangeLabel] = labelValue(normalizeRange(ipRange))
+	}
+	return resourceLabels
+}
+
+// ResourceSelector selects the IP pools and the cluster wide reservations of the network and range created with the
+// labels. Either may be empty: the selector of an unnamed network only matches the range.
+func ResourceSelector(networkName, ipRange string) labels.Selector {
+	return labels.SelectorFromSet(resourceLabels(networkName, ipRange))
+}
+
+// labelValue truncates the value to the length allowed for label values, which must start and end with an
+// alphanumeric character
+func labelValue(value string) string {
+	if len(value) > maxLabelValueLength {
+		value = value[:maxLabelValueLength]
+	}
+	return strings.TrimFunc(value, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	})
+}
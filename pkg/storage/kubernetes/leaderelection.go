@@ -0,0 +1,201 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+)
+
+// LeaderElections shares the leader elections among the requests of a long-running process, e.g. the daemon: rather
+// than each request campaigning for the lease on its own, the requests waiting on the same lease are served one after
+// the other by a single election. The election holds the lease until none is left waiting, or its term is over: it
+// then releases the lease, and lets the other processes campaign for it before campaigning anew.
+type LeaderElections struct {
+	identity  string
+	lock      sync.Mutex
+	elections map[string]*sharedElection
+	// yieldUntil tells when the process campaigns again for the leases whose terms are over, by key
+	yieldUntil map[string]time.Time
+}
+
+// maxTermRequests is the number of requests an election serves at most before releasing the lease, for the processes
+// of the other nodes not to starve under a steady stream of requests. The term is over after a lease duration as well.
+const maxTermRequests = 16
+
+// NewLeaderElections returns the leader elections of the process, holding the leases under the identity
+func NewLeaderElections(identity string) *LeaderElections {
+	return &LeaderElections{
+		identity:   identity,
+		elections:  map[string]*sharedElection{},
+		yieldUntil: map[string]time.Time{},
+	}
+}
+
+// sharedElection is the election of a lease, run as long as requests wait on it
+type sharedElection struct {
+	waiters int
+	leading chan struct{}
+	deposed chan struct{}
+	stop    context.CancelFunc
+	// serving is held by the request served under the lease
+	serving chan struct{}
+	// ended is closed once the term is over, see account; the process then yields the lease for the yield duration
+	ended        chan struct{}
+	started      time.Time
+	served       int
+	termDuration time.Duration
+	yield        time.Duration
+}
+
+// run runs fn once elected leader of the lease of the IPAM, after the requests waiting on the same lease before it.
+// Deposed, or the term over, before being served, the request campaigns anew. It fails once the context is done while
+// waiting.
+func (l *LeaderElections) run(ctx context.Context, ipam *KubernetesIPAM, fn func() error) error {
+	lease, err := leaseName(ctx, ipam)
+	if err != nil {
+		return fmt.Errorf("failed to create leader elector: %w", err)
+	}
+	key := fmt.Sprintf("%s/%s", ipam.namespace, lease)
+	for {
+		if wait := l.yielding(key); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("time limit exceeded while waiting to become leader")
+			case <-time.After(wait):
+			}
+		}
+		election, err := l.join(key, ipam, lease)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			l.leave(key, election)
+			return fmt.Errorf("time limit exceeded while waiting to become leader")
+		case <-election.deposed:
+			l.leave(key, election)
+			continue
+		case <-election.ended:
+			l.leave(key, election)
+			continue
+		case <-election.leading:
+		}
+
+		select {
+		case <-ctx.Done():
+			l.leave(key, election)
+			return fmt.Errorf("time limit exceeded while waiting to become leader")
+		case election.serving <- struct{}{}:
+		}
+		select {
+		case <-election.deposed:
+			<-election.serving
+			l.leave(key, election)
+			continue
+		case <-election.ended:
+			<-election.serving
+			l.leave(key, election)
+			continue
+		default:
+		}
+		servedAt := time.Now()
+		err = fn()
+		l.account(key, election, servedAt)
+		<-election.serving
+		l.leave(key, election)
+		return err
+	}
+}
+
+// yielding returns how long the process lets the other processes campaign for the lease, its last term being over
+func (l *LeaderElections) yielding(key string) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	wait := time.Until(l.yieldUntil[key])
+	if wait <= 0 {
+		delete(l.yieldUntil, key)
+	}
+	return wait
+}
+
+// account counts the request served at servedAt, ending the term of the election once it served maxTermRequests
+// requests or lasted a lease duration since serving the first: the lease is released, and the requests still waiting
+// campaign anew once the other processes had the time to campaign for it - twice their retry period.
+func (l *LeaderElections) account(key string, election *sharedElection, servedAt time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if election.served == 0 {
+		election.started = servedAt
+	}
+	election.served++
+	if election.served < maxTermRequests && time.Since(election.started) < election.termDuration {
+		return
+	}
+	close(election.ended)
+	election.stop()
+	if l.elections[key] == election {
+		delete(l.elections, key)
+	}
+	l.yieldUntil[key] = time.Now().Add(election.yield)
+	logging.Debugf("Ended the term of the shared leader election of lease %s after %d requests", key, election.served)
+}
+
+// join returns the election of the lease, starting it when no request waits on it yet, or when deposed
+func (l *LeaderElections) join(key string, ipam *KubernetesIPAM, lease string) (*sharedElection, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	election, found := l.elections[key]
+	if found {
+		select {
+		case <-election.deposed:
+			found = false
+		case <-election.ended:
+			found = false
+		default:
+		}
+	}
+	if !found {
+		le, leading, deposed, err := newLeaseElector(ipam.clientSet, ipam.namespace, lease, l.identity, ipam.Config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create leader elector: %w", err)
+		}
+		electionCtx, stop := context.WithCancel(context.Background())
+		election = &sharedElection{
+			leading:      leading,
+			deposed:      deposed,
+			stop:         stop,
+			serving:      make(chan struct{}, 1),
+			ended:        make(chan struct{}),
+			termDuration: time.Duration(ipam.Config.LeaderLeaseDuration) * time.Millisecond,
+			yield:        2 * time.Duration(ipam.Config.LeaderRetryPeriod) * time.Millisecond,
+		}
+		l.elections[key] = election
+		logging.Debugf("Started the shared leader election of lease %s", key)
+		go le.Run(electionCtx)
+	}
+	election.waiters++
+	return election, nil
+}
+
+// leave stops the election, releasing the lease, once no request waits on it anymore
+func (l *LeaderElections) leave(key string, election *sharedElection) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	election.waiters--
+	if election.waiters > 0 {
+		return
+	}
+	election.stop()
+	if l.elections[key] == election {
+		delete(l.elections, key)
+	}
+	logging.Debugf("Stopped the shared leader election of lease %s", key)
+}
@@ -0,0 +1,141 @@
+package kubernetes
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+func TestSharedLeaderElections(t *testing.T) {
+	k8sClient := fakek8sclient.NewSimpleClientset()
+	client := NewKubernetesClient(fakewbclient.NewSimpleClientset(), k8sClient)
+	client.ShareLeaderElections("node1")
+	ipamConf := whereaboutstypes.IPAMConfig{
+		LeaderLeaseDuration: whereaboutstypes.DefaultLeaderLeaseDuration,
+		LeaderRenewDeadline: whereaboutstypes.DefaultLeaderRenewDeadline,
+		LeaderRetryPeriod:   whereaboutstypes.DefaultLeaderRetryPeriod,
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+
+	const requests = 8
+	var served, concurrent, maxConcurrent int32
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ipam := NewKubernetesIPAMWithClient("container", "net1", ipamConf, "kube-system", *client)
+			err := client.leaderElections.run(ctx, ipam, func() error {
+				current := atomic.AddInt32(&concurrent, 1)
+				defer atomic.AddInt32(&concurrent, -1)
+				for {
+					previousMax := atomic.LoadInt32(&maxConcurrent)
+					if current <= previousMax || atomic.CompareAndSwapInt32(&maxConcurrent, previousMax, current) {
+						break
+					}
+				}
+				lease, err := k8sClient.CoordinationV1().Leases("kube-system").Get(ctx, "whereabouts", metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != "node1" {
+					t.Errorf("Expected the lease to be held under the identity of the process, got %v", lease.Spec.HolderIdentity)
+				}
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&served, 1)
+				return nil
+			})
+			if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if served != requests {
+		t.Errorf("Expected the %d requests to be served, got %d", requests, served)
+	}
+	if maxConcurrent != 1 {
+		t.Errorf("Expected the requests to be served one after the other, got %d at once", maxConcurrent)
+	}
+
+	client.leaderElections.lock.Lock()
+	defer client.leaderElections.lock.Unlock()
+	if len(client.leaderElections.elections) != 0 {
+		t.Errorf("Expected the election to be stopped once no request waits on it, got %v", client.leaderElections.elections)
+	}
+}
+
+func TestSharedLeaderElectionsYield(t *testing.T) {
+	k8sClient := fakek8sclient.NewSimpleClientset()
+	ipamConf := whereaboutstypes.IPAMConfig{
+		LeaderLeaseDuration: 1000,
+		LeaderRenewDeadline: 500,
+		LeaderRetryPeriod:   50,
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Second)
+	defer cancel()
+
+	// the daemon of node1 keeps requests waiting on the lease all along
+	busyClient := NewKubernetesClient(fakewbclient.NewSimpleClientset(), k8sClient)
+	busyClient.ShareLeaderElections("node1")
+	busyCtx, stopBusy := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for busyCtx.Err() == nil {
+				ipam := NewKubernetesIPAMWithClient("container", "net1", ipamConf, "kube-system", *busyClient)
+				_ = busyClient.leaderElections.run(busyCtx, ipam, func() error {
+					time.Sleep(5 * time.Millisecond)
+					return nil
+				})
+			}
+		}()
+	}
+	defer func() {
+		stopBusy()
+		wg.Wait()
+	}()
+
+	for {
+		lease, err := k8sClient.CoordinationV1().Leases("kube-system").Get(ctx, "whereabouts", metav1.GetOptions{})
+		if err == nil && lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity == "node1" {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("Expected node1 to hold the lease")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the daemon of node2 contends for the same lease
+	client := NewKubernetesClient(fakewbclient.NewSimpleClientset(), k8sClient)
+	client.ShareLeaderElections("node2")
+	requestCtx, cancelRequest := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelRequest()
+	ipam := NewKubernetesIPAMWithClient("container", "net1", ipamConf, "kube-system", *client)
+	err := client.leaderElections.run(requestCtx, ipam, func() error {
+		lease, err := k8sClient.CoordinationV1().Leases("kube-system").Get(ctx, "whereabouts", metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != "node2" {
+			t.Errorf("Expected the lease to be held by node2, got %v", lease.Spec.HolderIdentity)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Expected node2 to be served while node1 keeps requests waiting, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+)
+
+// MigratedToAnnotation, set on the IP pool of an unnamed network migrated by MigratePoolToNetwork, names the pool its
+// allocations were moved to. The pool is left as a tombstone: allocating from it fails, so that nodes still using the
+// unnamed network configuration cannot hand out the addresses of the named network's pool. While the pool still holds
+// allocations, i.e. the migration is under way, releasing from it fails too.
+const MigratedToAnnotation = "whereabouts.cni.cncf.io/migrated-to"
+
+// MigratePoolToNetwork moves the allocations and network service reservations of the IP pool of an unnamed network
+// into the pool of the network named networkName - the pool the network switches to once network_name is set - and
+// copies the overlapping range reservations of the moved allocations to the named network.
+//
+// The unnamed pool is first frozen, annotated with MigratedToAnnotation in an update conditioned on the version of the
+// pool read: from then on, neither allocations nor releases change it, and the copy cannot miss any. The allocations
+// are then merged into the named network's pool, when it exists already; an address it allocated to a different
+// container fails the migration. Last, the frozen pool is emptied, in an update conditioned on the frozen version.
+//
+// A failed migration leaves the unnamed pool frozen, and can be run again: migrating a frozen pool resumes the copy,
+// and migrating a pool already emptied by a migration to the same network is a no-op.
+func (i *Client) MigratePoolToNetwork(ctx context.Context, namespace string, poolIdentifier PoolIdentifier, networkName string) (*whereaboutsv1alpha1.IPPool, error) {
+	if networkName == UnnamedNetwork {
+		return nil, fmt.Errorf("cannot migrate an IP pool to the unnamed network")
+	}
+	poolIdentifier.NetworkName = UnnamedNetwork
+	unnamedPoolName := IPPoolName(poolIdentifier)
+	poolIdentifier.NetworkName = networkName
+	namedPoolName := IPPoolName(poolIdentifier)
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+	pools := i.client.WhereaboutsV1alpha1().IPPools(namespace)
+
+	unnamedPool, err := pools.Get(ctxWithTimeout, unnamedPoolName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve the IP pool %s: %w", unnamedPoolName, err)
+	}
+	if migratedTo, migrated := unnamedPool.GetAnnotations()[MigratedToAnnotation]; !migrated {
+		frozen := unnamedPool.DeepCopy()
+		if frozen.Annotations == nil {
+			frozen.Annotations = map[string]string{}
+		}
+		frozen.Annotations[MigratedToAnnotation] = namedPoolName
+		unnamedPool, err = pools.Update(ctxWithTimeout, frozen, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to freeze the IP pool %s: %w", unnamedPoolName, err)
+		}
+	} else if migratedTo != namedPoolName {
+		return nil, fmt.Errorf("the IP pool %s was already migrated to %s", unnamedPoolName, migratedTo)
+	} else if len(unnamedPool.Spec.Allocations) == 0 && len(unnamedPool.Status.Reservations) == 0 {
+		return pools.Get(ctxWithTimeout, namedPoolName, metav1.GetOptions{})
+	}
+
+	namedPool, err := pools.Get(ctxWithTimeout, namedPoolName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		namedPool = &whereaboutsv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: namedPoolName, Namespace: namespace},
+			Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: unnamedPool.Spec.Range},
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to retrieve the IP pool %s: %w", namedPoolName, err)
+	} else if namedPool.Spec.Range != unnamedPool.Spec.Range {
+		return nil, fmt.Errorf("the range %s of the IP pool %s differs from the range %s of the IP pool %s",
+			namedPool.Spec.Range, namedPoolName, unnamedPool.Spec.Range, unnamedPoolName)
+	}
+
+	allocations, err := mergeAllocations(namedPool.Spec.Allocations, unnamedPool.Spec.Allocations)
+	if err != nil {
+		return nil, fmt.Errorf("cannot migrate the IP pool %s to %s: %w", unnamedPoolName, namedPoolName, err)
+	}
+	namedPool.Spec.Allocations = allocations
+	namedPool.Status.Reservations = mergeServiceReservations(namedPool.Status.Reservations, unnamedPool.Status.Reservations)
+
+	if namedPool.GetResourceVersion() == "" {
+		namedPool, err = pools.Create(ctxWithTimeout, namedPool, metav1.CreateOptions{})
+	} else {
+		namedPool, err = pools.Update(ctxWithTimeout, namedPool, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the IP pool %s: %w", namedPoolName, err)
+	}
+
+	if err := i.copyOverlappingReservations(ctxWithTimeout, namespace, unnamedPool, networkName); err != nil {
+		return nil, err
+	}
+
+	tombstone := unnamedPool.DeepCopy()
+	tombstone.Spec.Allocations = map[string]whereaboutsv1alpha1.IPAllocation{}
+	tombstone.Status.Reservations = nil
+	if _, err := pools.Update(ctxWithTimeout, tombstone, metav1.UpdateOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to empty the frozen IP pool %s: %w", unnamedPoolName, err)
+	}
+
+	logging.Verbosef("migrated %d allocations of the IP pool %s to %s", len(unnamedPool.Spec.Allocations), unnamedPoolName, namedPoolName)
+	return namedPool, nil
+}
+
+// copyOverlappingReservations creates, for the named network, the overlapping range reservations of the allocations
+// of the unnamed pool. Reservations of the named network already held by the same pod are kept.
+func (i *Client) copyOverlappingReservations(ctx context.Context, namespace string, unnamedPool *whereaboutsv1alpha1.IPPool, networkName string) error {
+	firstIP, _, err := unnamedPool.ParseCIDR()
+	if err != nil {
+		return err
+	}
+
+	reservations := i.client.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace)
+	for _, allocation := range toIPReservationList(unnamedPool.Spec.Allocations, firstIP) {
+		reservation, err := reservations.Get(ctx, NormalizeIP(allocation.IP, UnnamedNetwork), metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to retrieve the overlapping range reservation of %s: %w", allocation.IP, err)
+		}
+		if reservation.Spec.PodRef != allocation.PodRef {
+			continue
+		}
+
+		if err := i.CreateOverlappingIP(ctx, namespace, allocation.IP, networkName, reservation.Spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateOverlappingIP reserves the IP of the network cluster wide for the pod of the spec, failing when another pod
+// holds the reservation
+func (i *Client) CreateOverlappingIP(ctx context.Context, namespace string, ip net.IP, networkName string, spec whereaboutsv1alpha1.OverlappingRangeIPReservationSpec) error {
+	name := NormalizeIP(ip, networkName)
+	reservations := i.client.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace)
+	_, err := reservations.Create(ctx, &whereaboutsv1alpha1.OverlappingRangeIPReservation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{ContainerIDLabel: containerIDLabelValue(spec.ContainerID)},
+		},
+		Spec: spec,
+	}, metav1.CreateOptions{})
+	if !errors.IsAlreadyExists(err) {
+		if err != nil {
+			return fmt.Errorf("failed to create the overlapping range reservation %s: %w", name, err)
+		}
+		return nil
+	}
+
+	existing, err := reservations.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to retrieve the overlapping range reservation %s: %w", name, err)
+	}
+	if existing.Spec.PodRef != spec.PodRef {
+		return fmt.Errorf("the overlapping range reservation %s is held by pod %s", name, existing.Spec.PodRef)
+	}
+	return nil
+}
+
+// mergeAllocations adds the allocations to the ones of the pool, failing on offsets the pool allocated to a
+// different container
+func mergeAllocations(poolAllocations, allocations map[string]whereaboutsv1alpha1.IPAllocation) (map[string]whereaboutsv1alpha1.IPAllocation, error) {
+	merged := make(map[string]whereaboutsv1alpha1.IPAllocation, len(poolAllocations)+len(allocations))
+	for offset, allocation := range poolAllocations {
+		merged[offset] = allocation
+	}
+	for offset, allocation := range allocations {
+		if existing, found := merged[offset]; found && existing != allocation {
+			return nil, fmt.Errorf("offset %s is allocated to both pod %s (container %s) and pod %s (container %s)",
+				offset, existing.PodRef, existing.ContainerID, allocation.PodRef, allocation.ContainerID)
+		}
+		merged[offset] = allocation
+	}
+	return merged, nil
+}
+
+// mergeServiceReservations adds the network service reservations missing from the pool's
+func mergeServiceReservations(poolReservations, reservations []whereaboutsv1alpha1.ServiceReservation) []whereaboutsv1alpha1.ServiceReservation {
+	merged := append([]whereaboutsv1alpha1.ServiceReservation{}, poolReservations...)
+	for _, reservation := range reservations {
+		found := false
+		for _, poolReservation := range poolReservations {
+			if iphelpers.CompareIPs(net.ParseIP(poolReservation.IP), net.ParseIP(reservation.IP)) == 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, reservation)
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
@@ -0,0 +1,182 @@
+package kubernetes
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+func TestMigratePoolToNetwork(t *testing.T) {
+	const (
+		namespace   = "kube-system"
+		ipRange     = "10.0.0.0/24"
+		networkName = "net1"
+	)
+	pool := func(name string, allocations map[string]whereaboutsv1alpha1.IPAllocation) *whereaboutsv1alpha1.IPPool {
+		return &whereaboutsv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, ResourceVersion: "1"},
+			Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: ipRange, Allocations: allocations},
+		}
+	}
+	pod1 := whereaboutsv1alpha1.IPAllocation{ContainerID: "c1", PodRef: "default/pod1", IfName: "net1"}
+	pod2 := whereaboutsv1alpha1.IPAllocation{ContainerID: "c2", PodRef: "default/pod2", IfName: "net1"}
+
+	cases := []struct {
+		name                string
+		objects             []runtime.Object
+		expectedAllocations map[string]whereaboutsv1alpha1.IPAllocation
+		expectedError       bool
+	}{
+		{
+			name: "New named pool",
+			objects: []runtime.Object{
+				pool("10.0.0.0-24", map[string]whereaboutsv1alpha1.IPAllocation{"1": pod1}),
+				&whereaboutsv1alpha1.OverlappingRangeIPReservation{
+					ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.1", Namespace: namespace},
+					Spec:       whereaboutsv1alpha1.OverlappingRangeIPReservationSpec{ContainerID: "c1", PodRef: "default/pod1", IfName: "net1"},
+				},
+			},
+			expectedAllocations: map[string]whereaboutsv1alpha1.IPAllocation{"1": pod1},
+		},
+		{
+			name: "Existing named pool",
+			objects: []runtime.Object{
+				pool("10.0.0.0-24", map[string]whereaboutsv1alpha1.IPAllocation{"1": pod1}),
+				pool("net1-10.0.0.0-24", map[string]whereaboutsv1alpha1.IPAllocation{"1": pod1, "2": pod2}),
+			},
+			expectedAllocations: map[string]whereaboutsv1alpha1.IPAllocation{"1": pod1, "2": pod2},
+		},
+		{
+			name: "Conflicting allocations",
+			objects: []runtime.Object{
+				pool("10.0.0.0-24", map[string]whereaboutsv1alpha1.IPAllocation{"1": pod1}),
+				pool("net1-10.0.0.0-24", map[string]whereaboutsv1alpha1.IPAllocation{"1": pod2}),
+			},
+			expectedError: true,
+		},
+		{
+			name:          "Missing unnamed pool",
+			expectedError: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			wbClient := fakewbclient.NewSimpleClientset(tc.objects...)
+			client := NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset())
+
+			namedPool, err := client.MigratePoolToNetwork(context.TODO(), namespace, PoolIdentifier{IpRange: ipRange}, networkName)
+			if tc.expectedError {
+				if err == nil {
+					t.Errorf("Expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !reflect.DeepEqual(namedPool.Spec.Allocations, tc.expectedAllocations) {
+				t.Errorf("Expected allocations %v, got %v", tc.expectedAllocations, namedPool.Spec.Allocations)
+			}
+
+			tombstone, err := wbClient.WhereaboutsV1alpha1().IPPools(namespace).Get(context.TODO(), "10.0.0.0-24", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if migratedTo := tombstone.Annotations[MigratedToAnnotation]; migratedTo != "net1-10.0.0.0-24" {
+				t.Errorf("Expected a tombstone pointing to net1-10.0.0.0-24, got %q", migratedTo)
+			}
+			if len(tombstone.Spec.Allocations) != 0 {
+				t.Errorf("Expected no allocations left in the tombstone, got %v", tombstone.Spec.Allocations)
+			}
+
+			// migrating again is a no-op
+			if _, err := client.MigratePoolToNetwork(context.TODO(), namespace, PoolIdentifier{IpRange: ipRange}, networkName); err != nil {
+				t.Errorf("Expected no error migrating again, got %v", err)
+			}
+		})
+	}
+
+	t.Run("Frozen pool", func(t *testing.T) {
+		frozen := pool("10.0.0.0-24", map[string]whereaboutsv1alpha1.IPAllocation{"1": pod1})
+		frozen.Annotations = map[string]string{MigratedToAnnotation: "net1-10.0.0.0-24"}
+		wbClient := fakewbclient.NewSimpleClientset(frozen)
+		client := NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset())
+
+		if _, err := client.MigratePoolToNetwork(context.TODO(), namespace, PoolIdentifier{IpRange: ipRange}, "net2"); err == nil {
+			t.Errorf("Expected an error migrating to another network, got none")
+		}
+		namedPool, err := client.MigratePoolToNetwork(context.TODO(), namespace, PoolIdentifier{IpRange: ipRange}, networkName)
+		if err != nil {
+			t.Fatalf("Expected the migration to resume, got %v", err)
+		}
+		if !reflect.DeepEqual(namedPool.Spec.Allocations, frozen.Spec.Allocations) {
+			t.Errorf("Expected allocations %v, got %v", frozen.Spec.Allocations, namedPool.Spec.Allocations)
+		}
+	})
+
+	t.Run("Conflicting freeze", func(t *testing.T) {
+		wbClient := fakewbclient.NewSimpleClientset(pool("10.0.0.0-24", map[string]whereaboutsv1alpha1.IPAllocation{"1": pod1}))
+		wbClient.PrependReactor("update", "ippools", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.NewConflict(whereaboutsv1alpha1.Resource("ippools"), "10.0.0.0-24", nil)
+		})
+		client := NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset())
+		if _, err := client.MigratePoolToNetwork(context.TODO(), namespace, PoolIdentifier{IpRange: ipRange}, networkName); err == nil {
+			t.Fatalf("Expected an error, got none")
+		}
+		if _, err := wbClient.WhereaboutsV1alpha1().IPPools(namespace).Get(context.TODO(), "net1-10.0.0.0-24", metav1.GetOptions{}); !errors.IsNotFound(err) {
+			t.Errorf("Expected no named pool to be written before the unnamed one is frozen, got %v", err)
+		}
+	})
+
+	t.Run("Overlapping range reservations", func(t *testing.T) {
+		wbClient := fakewbclient.NewSimpleClientset(cases[0].objects...)
+		client := NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset())
+		if _, err := client.MigratePoolToNetwork(context.TODO(), namespace, PoolIdentifier{IpRange: ipRange}, networkName); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		reservation, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).Get(context.TODO(), "net1-10.0.0.1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Expected the reservation to be copied, got %v", err)
+		}
+		if reservation.Spec.PodRef != "default/pod1" {
+			t.Errorf("Expected the reservation of pod default/pod1, got %s", reservation.Spec.PodRef)
+		}
+		if containerID := reservation.GetLabels()[ContainerIDLabel]; containerID != reservation.Spec.ContainerID {
+			t.Errorf("Expected the reservation to be indexed by container %s, got %s", reservation.Spec.ContainerID, containerID)
+		}
+	})
+}
+
+func TestFrozenPool(t *testing.T) {
+	wbClient := fakewbclient.NewSimpleClientset(&whereaboutsv1alpha1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "10.0.0.0-24",
+			Namespace:       "kube-system",
+			ResourceVersion: "1",
+			Annotations:     map[string]string{MigratedToAnnotation: "net1-10.0.0.0-24"},
+		},
+		Spec: whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{
+			"1": {ContainerID: "container-b", PodRef: "default/pod-b", IfName: "net1"},
+		}},
+	})
+	ipamConf := whereaboutstypes.IPAMConfig{IPRanges: []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}}}
+	ipam := NewKubernetesIPAMWithClient("container-b", "net1", ipamConf, "kube-system",
+		*NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset()))
+
+	_, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Deallocate, ipam, ipamConf)
+	expectedErr := "the IP pool 10.0.0.0-24 is being migrated to net1-10.0.0.0-24: retry once the migration is over"
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("Expected the release to fail with %q, got %v", expectedErr, err)
+	}
+}
@@ -0,0 +1,172 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// IPMove reassigns the allocation of an IP from a pod to another
+type IPMove struct {
+	IP net.IP
+	// FromPodRef is the pod the IP must be allocated to, as namespace/name
+	FromPodRef string
+	// FromContainerID, when set, is the container the IP must be allocated to
+	FromContainerID string
+	ToPodRef        string
+	ToContainerID   string
+	// ToPodUID is the UID of the pod the IP is moved to, if known
+	ToPodUID string
+	// ToIfName is the interface of the pod the IP is moved to; the interface of the allocation is kept when empty
+	ToIfName string
+}
+
+// MoveIP reassigns the allocation of an IP from a pod to another, e.g. the floating IP of an active/passive pair on
+// failover, in a single update of the IP pool: the given pool or, if empty, the one holding the allocation of the IP
+// to the pod. As on allocation, the cluster wide reservation of the IP, if any, is reassigned first, and restored should
+// the pool update fail. The update is guarded by the resource version of the pool, hence never overwrites a concurrent
+// change: the move is attempted anew, and fails once the IP is no longer allocated to the pod.
+func (i *Client) MoveIP(ctx context.Context, poolName string, move IPMove) (*KubernetesIPPool, error) {
+	var err error
+	for j := 0; j < i.retries; j++ {
+		var pool *KubernetesIPPool
+		pool, err = i.allocationPool(ctx, poolName, move)
+		if err != nil {
+			return nil, err
+		}
+		var restore func()
+		restore, err = i.moveOverlappingIP(ctx, pool, move)
+		if err != nil {
+			return nil, err
+		}
+
+		reservations := pool.Allocations()
+		for k := range reservations {
+			if !reservations[k].IP.Equal(move.IP) {
+				continue
+			}
+			reservations[k].PodRef = move.ToPodRef
+			reservations[k].ContainerID = move.ToContainerID
+			reservations[k].PodUID = move.ToPodUID
+			reservations[k].AllocatedAt = time.Now()
+			if move.ToIfName != "" {
+				reservations[k].IfName = move.ToIfName
+			}
+		}
+
+		ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+		err = pool.Update(ctxWithTimeout, reservations)
+		cancel()
+		if err == nil {
+			return pool, nil
+		}
+		restore()
+		if temporary, ok := err.(storage.Temporary); !ok || !temporary.Temporary() {
+			break
+		}
+		logging.Debugf("IP pool %s changed while moving IP %s, retrying: %v", pool.Name(), move.IP, err)
+	}
+	return nil, fmt.Errorf("failed to move IP %s: %w", move.IP, err)
+}
+
+// allocationPool returns the IP pool holding the allocation of the IP to the pod - and container - the IP is moved
+// from
+func (i *Client) allocationPool(ctx context.Context, poolName string, move IPMove) (*KubernetesIPPool, error) {
+	pools, err := i.ListIPPools(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the IP pools: %w", err)
+	}
+
+	var matching []*KubernetesIPPool
+	for _, pool := range pools {
+		k8sPool, ok := pool.(*KubernetesIPPool)
+		if !ok || (poolName != "" && k8sPool.Name() != poolName) {
+			continue
+		}
+		for _, reservation := range k8sPool.Allocations() {
+			if reservation.IP.Equal(move.IP) && movedFrom(reservation, move) {
+				matching = append(matching, k8sPool)
+				break
+			}
+		}
+	}
+	switch len(matching) {
+	case 0:
+		return nil, fmt.Errorf("IP %s is not allocated to pod %s", move.IP, move.FromPodRef)
+	case 1:
+		return matching[0], nil
+	default:
+		var names []string
+		for _, pool := range matching {
+			names = append(names, pool.Name())
+		}
+		return nil, fmt.Errorf("IP %s is allocated to pod %s in several IP pools %v: pick one", move.IP, move.FromPodRef, names)
+	}
+}
+
+// moveOverlappingIP reassigns the cluster wide reservation of the IP on the network of the pool, unless the network
+// does not reserve its IPs cluster wide. It returns the function restoring the reservation as it was.
+func (i *Client) moveOverlappingIP(ctx context.Context, pool *KubernetesIPPool, move IPMove) (func(), error) {
+	network, found, err := i.poolNetwork(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	if !found || !network.overlappingRanges {
+		return func() {}, nil
+	}
+	reservationName := NormalizeIP(move.IP, network.networkName)
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	reservations := i.client.WhereaboutsV1alpha1().OverlappingRangeIPReservations(i.whereaboutsNamespace)
+	clusterWideIP, err := reservations.Get(ctxWithTimeout, reservationName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the cluster wide reservation of IP %s: %w", move.IP, err)
+	}
+	if clusterWideIP.Spec.PodRef != move.FromPodRef {
+		return nil, fmt.Errorf("the cluster wide reservation of IP %s belongs to pod %s, not to pod %s", move.IP, clusterWideIP.Spec.PodRef, move.FromPodRef)
+	}
+
+	moved := clusterWideIP.DeepCopy()
+	moved.Spec.PodRef = move.ToPodRef
+	moved.Spec.ContainerID = move.ToContainerID
+	if move.ToIfName != "" {
+		moved.Spec.IfName = move.ToIfName
+	}
+	if moved.Labels == nil {
+		moved.Labels = map[string]string{}
+	}
+	moved.Labels[ContainerIDLabel] = containerIDLabelValue(move.ToContainerID)
+	moved, err = reservations.Update(ctxWithTimeout, moved, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to move the cluster wide reservation of IP %s: %w", move.IP, err)
+	}
+
+	return func() {
+		ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+		defer cancel()
+
+		restored := moved.DeepCopy()
+		restored.Spec = clusterWideIP.Spec
+		restored.Labels = clusterWideIP.Labels
+		if _, err := reservations.Update(ctxWithTimeout, restored, metav1.UpdateOptions{}); err != nil {
+			logging.Errorf("Error restoring the cluster wide reservation of IP %v to pod %s: %v", move.IP, clusterWideIP.Spec.PodRef, err)
+		}
+	}, nil
+}
+
+func movedFrom(reservation whereaboutstypes.IPReservation, move IPMove) bool {
+	return reservation.PodRef == move.FromPodRef && (move.FromContainerID == "" || reservation.ContainerID == move.FromContainerID)
+}
@@ -0,0 +1,113 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	fakenadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+)
+
+// newMoveClient returns a client of the pool of network net1 allocating IP 10.0.0.10 to default/active, reserved
+// cluster wide
+func newMoveClient(t *testing.T, namespace string) (*fakewbclient.Clientset, *Client) {
+	nadClient := fakenadclient.NewSimpleClientset()
+	if _, err := nadClient.K8sCniCncfIoV1().NetworkAttachmentDefinitions("default").Create(context.TODO(), &nadv1.NetworkAttachmentDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "floating", Namespace: "default"},
+		Spec: nadv1.NetworkAttachmentDefinitionSpec{
+			Config: `{"name": "floating", "ipam": {"type": "whereabouts", "range": "10.0.0.0/24", "network_name": "net1"}}`,
+		},
+	}, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	wbClient := fakewbclient.NewSimpleClientset(
+		&whereaboutsv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "net1-10.0.0.0-24", Namespace: namespace, ResourceVersion: "1"},
+			Spec: whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{
+				"10": {ContainerID: "container-active", PodRef: "default/active", PodUID: "uid-active", IfName: "net1"},
+				"11": {ContainerID: "container-other", PodRef: "default/other", IfName: "net1"},
+			}},
+		},
+		&whereaboutsv1alpha1.OverlappingRangeIPReservation{
+			ObjectMeta: metav1.ObjectMeta{Name: "net1-10.0.0.10", Namespace: namespace, ResourceVersion: "1",
+				Labels: map[string]string{ContainerIDLabel: "container-active"}},
+			Spec: whereaboutsv1alpha1.OverlappingRangeIPReservationSpec{ContainerID: "container-active", PodRef: "default/active", IfName: "net1"},
+		},
+	)
+	return wbClient, NewKubernetesClientWithNetAttachDefs(wbClient, fakek8sclient.NewSimpleClientset(), nadClient)
+}
+
+func TestMoveIP(t *testing.T) {
+	const namespace = "kube-system"
+	wbClient, client := newMoveClient(t, namespace)
+	move := IPMove{
+		IP:            net.ParseIP("10.0.0.10"),
+		FromPodRef:    "default/active",
+		ToPodRef:      "default/passive",
+		ToContainerID: "container-passive",
+	}
+
+	if _, err := client.MoveIP(context.TODO(), "", move); err != nil {
+		t.Fatalf("Expected no error moving the IP, got %v", err)
+	}
+
+	pool, err := wbClient.WhereaboutsV1alpha1().IPPools(namespace).Get(context.TODO(), "net1-10.0.0.0-24", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error getting the pool, got %v", err)
+	}
+	moved := pool.Spec.Allocations["10"]
+	if moved.PodRef != "default/passive" || moved.ContainerID != "container-passive" || moved.IfName != "net1" || moved.PodUID != "" {
+		t.Errorf("Expected the IP to be allocated to the interface net1 of default/passive, got %+v", moved)
+	}
+	if other := pool.Spec.Allocations["11"]; other.PodRef != "default/other" {
+		t.Errorf("Expected the other allocations to be kept, got %+v", other)
+	}
+
+	clusterWideIP, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).Get(context.TODO(), "net1-10.0.0.10", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error getting the cluster wide reservation, got %v", err)
+	}
+	if clusterWideIP.Spec.PodRef != "default/passive" || clusterWideIP.Spec.ContainerID != "container-passive" ||
+		clusterWideIP.GetLabels()[ContainerIDLabel] != "container-passive" {
+		t.Errorf("Expected the cluster wide reservation to be moved to default/passive, got %+v", clusterWideIP)
+	}
+
+	// the IP is no longer allocated to the pod it is moved from
+	if _, err := client.MoveIP(context.TODO(), "", move); err == nil {
+		t.Error("Expected moving the IP again to fail")
+	}
+	if _, err := client.MoveIP(context.TODO(), "", IPMove{IP: net.ParseIP("10.0.0.11"), FromPodRef: "default/other",
+		FromContainerID: "container-stale", ToPodRef: "default/passive"}); err == nil {
+		t.Error("Expected moving the IP of another container to fail")
+	}
+}
+
+func TestMoveIPRestoresTheReservation(t *testing.T) {
+	const namespace = "kube-system"
+	wbClient, client := newMoveClient(t, namespace)
+	wbClient.PrependReactor("patch", "ippools", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("injected error")
+	})
+
+	if _, err := client.MoveIP(context.TODO(), "", IPMove{IP: net.ParseIP("10.0.0.10"), FromPodRef: "default/active",
+		ToPodRef: "default/passive", ToContainerID: "container-passive"}); err == nil {
+		t.Fatal("Expected moving the IP to fail")
+	}
+
+	clusterWideIP, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).Get(context.TODO(), "net1-10.0.0.10", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error getting the cluster wide reservation, got %v", err)
+	}
+	if clusterWideIP.Spec.PodRef != "default/active" || clusterWideIP.GetLabels()[ContainerIDLabel] != "container-active" {
+		t.Errorf("Expected the cluster wide reservation to be restored to default/active, got %+v", clusterWideIP)
+	}
+}
@@ -0,0 +1,55 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+)
+
+// PodNetworkSelections parses the networks annotation of the pod, as multus does: either a JSON list of network
+// selection elements, or a comma separated list of [namespace/]name[@interface]
+func PodNetworkSelections(pod *v1.Pod) ([]nadv1.NetworkSelectionElement, error) {
+	networks := strings.TrimSpace(pod.GetAnnotations()[nadv1.NetworkAttachmentAnnot])
+	if networks == "" {
+		return nil, nil
+	}
+
+	var selections []nadv1.NetworkSelectionElement
+	if strings.HasPrefix(networks, "[") {
+		if err := json.Unmarshal([]byte(networks), &selections); err != nil {
+			return nil, err
+		}
+		return selections, nil
+	}
+	for _, item := range strings.Split(networks, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		selection := nadv1.NetworkSelectionElement{}
+		item, selection.InterfaceRequest, _ = strings.Cut(item, "@")
+		if namespace, name, found := strings.Cut(item, "/"); found {
+			selection.Namespace, selection.Name = namespace, name
+		} else {
+			selection.Name = item
+		}
+		if selection.Name == "" {
+			return nil, fmt.Errorf("invalid network %q", item)
+		}
+		selections = append(selections, selection)
+	}
+	return selections, nil
+}
+
+// SelectionInterface returns the name of the interface of the network selection of the given index: multus names the
+// interfaces after the index of their network, unless the network selection names them
+func SelectionInterface(index int, selection nadv1.NetworkSelectionElement) string {
+	if selection.InterfaceRequest != "" {
+		return selection.InterfaceRequest
+	}
+	return fmt.Sprintf("net%d", index+1)
+}
@@ -0,0 +1,82 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+)
+
+// NodeSlices tell the IP pools of the node slices apart, along with the network they belong to. Each node of a node
+// slice network allocates from the pools of the slices its NodeSlicePool assigns it, named after both the node and the
+// slice rather than after the range of the network.
+type NodeSlices map[string]nodeSlice
+
+type nodeSlice struct {
+	nodeSlicePool string
+	nodeName      string
+	network       string
+}
+
+// NewNodeSlices enumerates the IP pools of the slices the NodeSlicePools assign to nodes
+func NewNodeSlices(nodeSlicePools []whereaboutsv1alpha1.NodeSlicePool) NodeSlices {
+	slices := NodeSlices{}
+	for _, nodeSlicePool := range nodeSlicePools {
+		ranges := []string{nodeSlicePool.Spec.Range}
+		for _, additionalRange := range nodeSlicePool.Spec.AdditionalRanges {
+			ranges = append(ranges, additionalRange.Range)
+		}
+
+		for _, allocation := range nodeSlicePool.Status.Allocations {
+			if allocation.NodeName == "" {
+				continue
+			}
+			sliceRanges := append([]string{allocation.SliceRange}, allocation.AdditionalSliceRanges...)
+			for i, sliceRange := range sliceRanges {
+				if i >= len(ranges) || sliceRange == "" {
+					break
+				}
+				// the NodeSlicePool is named after the network name of named networks, and after the
+				// network-attachment-definition otherwise: the pools of the latter are not prefixed
+				namedPool := IPPoolName(PoolIdentifier{IpRange: sliceRange, NetworkName: nodeSlicePool.GetName(), NodeName: allocation.NodeName})
+				slices[nodeSlicesKey(nodeSlicePool.GetNamespace(), namedPool)] = nodeSlice{
+					nodeSlicePool: nodeSlicePool.GetName(),
+					nodeName:      allocation.NodeName,
+					network:       nodeSlicePool.GetName(),
+				}
+				unnamedPool := IPPoolName(PoolIdentifier{IpRange: sliceRange, NetworkName: UnnamedNetwork, NodeName: allocation.NodeName})
+				slices[nodeSlicesKey(nodeSlicePool.GetNamespace(), unnamedPool)] = nodeSlice{
+					nodeSlicePool: nodeSlicePool.GetName(),
+					nodeName:      allocation.NodeName,
+					network:       rangeLabel(ranges[i]),
+				}
+			}
+		}
+	}
+	return slices
+}
+
+// rangeLabel labels the networks by their range, like WhereaboutsNetworks
+func rangeLabel(ipRange string) string {
+	if _, ipNet, err := net.ParseCIDR(ipRange); err == nil {
+		return ipNet.String()
+	}
+	return ipRange
+}
+
+func nodeSlicesKey(namespace, poolName string) string {
+	return fmt.Sprintf("%s/%s", namespace, poolName)
+}
+
+// SlicePool returns the NodeSlicePool and the node of the slice the IP pool serves, if any
+func (n NodeSlices) SlicePool(namespace, poolName string) (nodeSlicePool, nodeName string, found bool) {
+	slice, found := n[nodeSlicesKey(namespace, poolName)]
+	return slice.nodeSlicePool, slice.nodeName, found
+}
+
+// PoolNetwork returns the label of the network of the slice the IP pool serves: its network name on named networks,
+// its range otherwise - as labelled by WhereaboutsNetworks. It reports whether the pool serves a slice at all.
+func (n NodeSlices) PoolNetwork(namespace, poolName string) (string, bool) {
+	slice, found := n[nodeSlicesKey(namespace, poolName)]
+	return slice.network, found
+}
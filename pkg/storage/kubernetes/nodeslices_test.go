@@ -0,0 +1,63 @@
+package kubernetes
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+)
+
+func TestNodeSlices(t *testing.T) {
+	nodeSlices := NewNodeSlices([]whereaboutsv1alpha1.NodeSlicePool{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "sliced", Namespace: "kube-system"},
+			Spec: whereaboutsv1alpha1.NodeSlicePoolSpec{
+				Range:            "10.1.0.0/16",
+				SliceSize:        "/24",
+				AdditionalRanges: []whereaboutsv1alpha1.NodeSliceRange{{Range: "fd00::/64", SliceSize: "/120"}},
+			},
+			Status: whereaboutsv1alpha1.NodeSlicePoolStatus{
+				Allocations: []whereaboutsv1alpha1.NodeSliceAllocation{
+					{NodeName: "node1", SliceRange: "10.1.0.0/24", AdditionalSliceRanges: []string{"fd00::/120"}},
+					{NodeName: "", SliceRange: "10.1.1.0/24", AdditionalSliceRanges: []string{"fd00::100/120"}},
+				},
+			},
+		},
+	})
+
+	cases := []struct {
+		name            string
+		namespace       string
+		poolName        string
+		expectedSlice   bool
+		expectedNode    string
+		expectedNetwork string
+	}{
+		{name: "Slice pool of a named network", namespace: "kube-system", poolName: "sliced-node1-10.1.0.0-24",
+			expectedSlice: true, expectedNode: "node1", expectedNetwork: "sliced"},
+		{name: "Slice pool of an additional range", namespace: "kube-system", poolName: "sliced-node1-fd00---120",
+			expectedSlice: true, expectedNode: "node1", expectedNetwork: "sliced"},
+		{name: "Slice pool of an unnamed network", namespace: "kube-system", poolName: "node1-10.1.0.0-24",
+			expectedSlice: true, expectedNode: "node1", expectedNetwork: "10.1.0.0/16"},
+		{name: "Slice pool of an additional range of an unnamed network", namespace: "kube-system", poolName: "node1-fd00---120",
+			expectedSlice: true, expectedNode: "node1", expectedNetwork: "fd00::/64"},
+		{name: "Pool of another namespace", namespace: "default", poolName: "sliced-node1-10.1.0.0-24"},
+		{name: "Pool of an unassigned slice", namespace: "kube-system", poolName: "sliced-node2-10.1.1.0-24"},
+		{name: "Pool of the whole range", namespace: "kube-system", poolName: "sliced-10.1.0.0-16"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, nodeName, isSlice := nodeSlices.SlicePool(tc.namespace, tc.poolName)
+			if isSlice != tc.expectedSlice {
+				t.Fatalf("Expected the pool to serve a slice: %v, got %v", tc.expectedSlice, isSlice)
+			}
+			if nodeName != tc.expectedNode {
+				t.Errorf("Expected node %q, got %q", tc.expectedNode, nodeName)
+			}
+			if network, _ := nodeSlices.PoolNetwork(tc.namespace, tc.poolName); network != tc.expectedNetwork {
+				t.Errorf("Expected network %q, got %q", tc.expectedNetwork, network)
+			}
+		})
+	}
+}
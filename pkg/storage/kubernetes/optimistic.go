@@ -0,0 +1,33 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// errTooManyConflicts stops the IP management without leader election once it lost the race for the IP pool update
+// to concurrent requests as many times as allowed
+var errTooManyConflicts = errors.New("too many conflicts updating the IP pool")
+
+// optimisticManagement reports whether the IPs of the network are managed without taking the leader election lease:
+// when asked to, on single range networks - a request managing the IPs of several ranges would hold on to those of the
+// first ranges while falling back to the leader election.
+func optimisticManagement(ipamConf whereaboutstypes.IPAMConfig) bool {
+	return ipamConf.NoLeaderElection && len(ipamConf.IPRanges) == 1
+}
+
+// optimisticIPManagement manages the IPs without leader election. The IP pool updates being conditioned on the
+// resource version of the pool read, concurrent requests cannot double book an IP: the losers of the race retry with
+// the updated pool, up to leader_fallback_conflicts conflicts.
+func optimisticIPManagement(ctx context.Context, mode int, ipamConf whereaboutstypes.IPAMConfig, client *KubernetesIPAM) ([]net.IPNet, error) {
+	optimisticClient := *client
+	optimisticClient.maxConflicts = ipamConf.LeaderFallbackConflicts
+	return IPManagementKubernetesUpdate(ctx, mode, &optimisticClient, ipamConf)
+}
+
+func isTooManyConflicts(err error) bool {
+	return errors.Is(err, errTooManyConflicts)
+}
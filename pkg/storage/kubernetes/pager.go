@@ -0,0 +1,21 @@
+package kubernetes
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/pager"
+)
+
+// listPageSize bounds the number of resources of each page of the lists, sparing the API server from serializing the
+// thousands of IP pools or reservations of a large cluster in a single response
+const listPageSize = 500
+
+// eachListItem lists the resources page by page, following the continue token of each page, and calls fn on each of
+// them. Should the continue token expire in between, the remaining resources are listed in a single request.
+func eachListItem(ctx context.Context, list pager.ListPageFunc, options metav1.ListOptions, fn func(obj runtime.Object) error) error {
+	listPager := pager.New(list)
+	listPager.PageSize = listPageSize
+	return listPager.EachListItem(ctx, options, fn)
+}
@@ -0,0 +1,235 @@
+package kubernetes
+
+import (
+	"net"
+	"strings"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/config"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// ReconcileAnnotation, set to "false" on a network-attachment-definition, excludes the IP pools of the network from
+// the garbage collection of both the IP reconciler and the IP control loop, e.g. while an external process manages
+// them during a migration
+const ReconcileAnnotation = "whereabouts.cni.cncf.io/reconcile"
+
+// ReconcileDisabled reports whether the annotations of a network-attachment-definition opt it out of reconciliation
+func ReconcileDisabled(annotations map[string]string) bool {
+	return annotations[ReconcileAnnotation] == "false"
+}
+
+// UnreconciledNetworks tells apart the IP pools and cluster wide reservations of the networks opted out of
+// reconciliation
+type UnreconciledNetworks []whereaboutsNetwork
+
+// WhereaboutsNetworks tell the whereabouts networks the IP pools and cluster wide reservations belong to
+type WhereaboutsNetworks []whereaboutsNetwork
+
+// UnknownNetwork labels the IP pools and cluster wide reservations of no known whereabouts network
+const UnknownNetwork = "unknown"
+
+type whereaboutsNetwork struct {
+	networkName string
+	ranges      []*net.IPNet
+	// overlappingRanges tells the IPs of the network are reserved cluster wide, as enable_overlapping_ranges does
+	overlappingRanges bool
+}
+
+type whereaboutsNetConf struct {
+	IPAM    *whereaboutsIPAMConf `json:"ipam,omitempty"`
+	Plugins []whereaboutsNetConf `json:"plugins,omitempty"`
+}
+
+type whereaboutsIPAMConf struct {
+	Type        string   `json:"type"`
+	Range       string   `json:"range"`
+	RangeSet    []string `json:"range_set"`
+	NetworkName string   `json:"network_name"`
+	IPRanges    []struct {
+		Range    string   `json:"range"`
+		RangeSet []string `json:"range_set"`
+	} `json:"ipRanges"`
+	// OverlappingRanges is nil when the network configuration does not set it, the cluster wide reservations being on
+	OverlappingRanges *bool `json:"enable_overlapping_ranges"`
+}
+
+// NewUnreconciledNetworks collects the whereabouts networks of the network-attachment-definitions opted out of
+// reconciliation
+func NewUnreconciledNetworks(netAttachDefs []nadv1.NetworkAttachmentDefinition) UnreconciledNetworks {
+	var networks UnreconciledNetworks
+	for _, netAttachDef := range netAttachDefs {
+		if !ReconcileDisabled(netAttachDef.GetAnnotations()) {
+			continue
+		}
+		if parsed := parseWhereaboutsNetworks(netAttachDef); len(parsed) > 0 {
+			logging.Debugf("network-attachment-definition %s/%s opted out of reconciliation",
+				netAttachDef.GetNamespace(), netAttachDef.GetName())
+			networks = append(networks, parsed...)
+		}
+	}
+	return networks
+}
+
+// NewWhereaboutsNetworks collects the whereabouts networks of the network-attachment-definitions
+func NewWhereaboutsNetworks(netAttachDefs []nadv1.NetworkAttachmentDefinition) WhereaboutsNetworks {
+	var networks WhereaboutsNetworks
+	for _, netAttachDef := range netAttachDefs {
+		networks = append(networks, parseWhereaboutsNetworks(netAttachDef)...)
+	}
+	return networks
+}
+
+func parseWhereaboutsNetworks(netAttachDef nadv1.NetworkAttachmentDefinition) []whereaboutsNetwork {
+	var netConf whereaboutsNetConf
+	if err := config.UnmarshalNetConf([]byte(netAttachDef.Spec.Config), &netConf); err != nil {
+		logging.Debugf("failed to parse the configuration of network-attachment-definition %s/%s: %v",
+			netAttachDef.GetNamespace(), netAttachDef.GetName(), err)
+		return nil
+	}
+
+	var networks []whereaboutsNetwork
+	for _, ipamConf := range append([]whereaboutsNetConf{netConf}, netConf.Plugins...) {
+		if ipamConf.IPAM == nil || ipamConf.IPAM.Type != "whereabouts" {
+			continue
+		}
+		overlappingRanges := whereaboutstypes.DefaultOverlappingIPsFeatures
+		if ipamConf.IPAM.OverlappingRanges != nil {
+			overlappingRanges = *ipamConf.IPAM.OverlappingRanges
+		}
+		networks = append(networks, whereaboutsNetwork{
+			networkName:       ipamConf.IPAM.NetworkName,
+			ranges:            ipamConf.IPAM.ranges(),
+			overlappingRanges: overlappingRanges,
+		})
+	}
+	return networks
+}
+
+func (c *whereaboutsIPAMConf) ranges() []*net.IPNet {
+	rangeStrs := append([]string{c.Range}, c.RangeSet...)
+	for _, ipRange := range c.IPRanges {
+		rangeStrs = append(rangeStrs, ipRange.Range)
+		rangeStrs = append(rangeStrs, ipRange.RangeSet...)
+	}
+
+	var ranges []*net.IPNet
+	for _, rangeStr := range rangeStrs {
+		// ranges may be written as <range start>-<CIDR>
+		if r := strings.SplitN(rangeStr, "-", 2); len(r) == 2 {
+			rangeStr = r[1]
+		}
+		if _, ipNet, err := net.ParseCIDR(rangeStr); err == nil {
+			ranges = append(ranges, ipNet)
+		}
+	}
+	return ranges
+}
+
+// ContainsPool reports whether the IP pool belongs to a network opted out of reconciliation: either the pool of one
+// of its ranges, or - on node slice networks - the pool of a slice of one of them.
+func (u UnreconciledNetworks) ContainsPool(poolName, poolRange string) bool {
+	_, found := WhereaboutsNetworks(u).poolNetwork(poolName, poolRange)
+	return found
+}
+
+// ContainsClusterWideIP reports whether the cluster wide reservation of the IP belongs to a network opted out of
+// reconciliation
+func (u UnreconciledNetworks) ContainsClusterWideIP(reservationName string, ip net.IP) bool {
+	_, found := WhereaboutsNetworks(u).clusterWideIPNetwork(reservationName, ip)
+	return found
+}
+
+// PoolNetwork returns the label of the network the IP pool belongs to: its network name on named networks, its range
+// otherwise. UnknownNetwork is returned for the pools of no known network.
+func (w WhereaboutsNetworks) PoolNetwork(poolName, poolRange string) string {
+	if label, found := w.poolNetwork(poolName, poolRange); found {
+		return label
+	}
+	return UnknownNetwork
+}
+
+// ClusterWideIPNetwork returns the label of the network the cluster wide reservation of the IP belongs to: its
+// network name on named networks, its range otherwise. UnknownNetwork is returned for the reservations of no known
+// network.
+func (w WhereaboutsNetworks) ClusterWideIPNetwork(reservationName string, ip net.IP) string {
+	if label, found := w.clusterWideIPNetwork(reservationName, ip); found {
+		return label
+	}
+	return UnknownNetwork
+}
+
+func (w WhereaboutsNetworks) poolNetwork(poolName, poolRange string) (string, bool) {
+	network, ipRange, found := w.networkOfPool(poolName, poolRange)
+	if !found {
+		return "", false
+	}
+	return network.label(ipRange), true
+}
+
+// networkOfPool returns the network the IP pool belongs to, along with the network range holding the range of the pool
+func (w WhereaboutsNetworks) networkOfPool(poolName, poolRange string) (whereaboutsNetwork, *net.IPNet, bool) {
+	_, poolNet, err := net.ParseCIDR(poolRange)
+	if err != nil {
+		return whereaboutsNetwork{}, nil, false
+	}
+
+	for _, network := range w {
+		for _, ipRange := range network.ranges {
+			if !ipRange.Contains(poolNet.IP) {
+				continue
+			}
+			if poolNet.String() == ipRange.String() &&
+				poolName == IPPoolName(PoolIdentifier{IpRange: poolNet.String(), NetworkName: network.networkName}) {
+				return network, ipRange, true
+			}
+			poolOnes, _ := poolNet.Mask.Size()
+			rangeOnes, _ := ipRange.Mask.Size()
+			isSlicePool := poolOnes > rangeOnes && strings.HasSuffix(poolName, "-"+normalizeRange(poolNet.String())) &&
+				(network.networkName == UnnamedNetwork || strings.HasPrefix(poolName, network.networkName+"-"))
+			if isSlicePool {
+				return network, ipRange, true
+			}
+		}
+	}
+	return whereaboutsNetwork{}, nil, false
+}
+
+// poolWhereaboutsNetwork returns the network of the IP pool: that of the network-attachment-definition it is found in,
+// or else the one its name is made of, for the pools named after their range
+func (w WhereaboutsNetworks) poolWhereaboutsNetwork(poolName, poolRange string) (whereaboutsNetwork, bool) {
+	if network, _, found := w.networkOfPool(poolName, poolRange); found {
+		return network, true
+	}
+	rangeName := normalizeRange(poolRange)
+	if poolName == rangeName {
+		return whereaboutsNetwork{networkName: UnnamedNetwork, overlappingRanges: whereaboutstypes.DefaultOverlappingIPsFeatures}, true
+	}
+	if networkName, isNamed := strings.CutSuffix(poolName, "-"+rangeName); isNamed && networkName != "" {
+		return whereaboutsNetwork{networkName: networkName, overlappingRanges: whereaboutstypes.DefaultOverlappingIPsFeatures}, true
+	}
+	return whereaboutsNetwork{}, false
+}
+
+func (w WhereaboutsNetworks) clusterWideIPNetwork(reservationName string, ip net.IP) (string, bool) {
+	for _, network := range w {
+		if reservationName != NormalizeIP(ip, network.networkName) {
+			continue
+		}
+		for _, ipRange := range network.ranges {
+			if ipRange.Contains(ip) {
+				return network.label(ipRange), true
+			}
+		}
+	}
+	return "", false
+}
+
+func (n whereaboutsNetwork) label(ipRange *net.IPNet) string {
+	if n.networkName != UnnamedNetwork {
+		return n.networkName
+	}
+	return ipRange.String()
+}
@@ -0,0 +1,102 @@
+package kubernetes
+
+import (
+	"net"
+	"testing"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUnreconciledNetworks(t *testing.T) {
+	netAttachDef := func(name, reconcile, config string) nadv1.NetworkAttachmentDefinition {
+		return nadv1.NetworkAttachmentDefinition{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Annotations: map[string]string{ReconcileAnnotation: reconcile},
+			},
+			Spec: nadv1.NetworkAttachmentDefinitionSpec{Config: config},
+		}
+	}
+	networks := NewUnreconciledNetworks([]nadv1.NetworkAttachmentDefinition{
+		netAttachDef("unnamed", "false", `{"name": "unnamed", "ipam": {"type": "whereabouts", "range": "10.0.0.0/24"}}`),
+		netAttachDef("sliced", "false", `{"name": "sliced", "plugins": [{"type": "macvlan", "ipam": {"type": "whereabouts", "range": "10.1.0.0/16", "node_slice_size": "/24", "network_name": "sliced"}}]}`),
+		netAttachDef("ranged", "false", `{"name": "ranged", "ipam": {"type": "whereabouts", "ipRanges": [{"range": "10.2.0.10-10.2.0.0/24"}], "network_name": "ranged"}}`),
+		netAttachDef("reconciled", "true", `{"name": "reconciled", "ipam": {"type": "whereabouts", "range": "10.3.0.0/24"}}`),
+		netAttachDef("static", "false", `{"name": "static", "ipam": {"type": "static"}}`),
+	})
+
+	poolCases := []struct {
+		name      string
+		poolName  string
+		poolRange string
+		expected  bool
+	}{
+		{name: "Pool of an unnamed network", poolName: "10.0.0.0-24", poolRange: "10.0.0.0/24", expected: true},
+		{name: "Pool of a named network sharing the range", poolName: "other-10.0.0.0-24", poolRange: "10.0.0.0/24", expected: false},
+		{name: "Slice pool of a named network", poolName: "sliced-node1-10.1.3.0-24", poolRange: "10.1.3.0/24", expected: true},
+		{name: "Slice pool of another network", poolName: "other-node1-10.1.3.0-24", poolRange: "10.1.3.0/24", expected: false},
+		{name: "Pool of a range with a start", poolName: "ranged-10.2.0.0-24", poolRange: "10.2.0.0/24", expected: true},
+		{name: "Pool of a reconciled network", poolName: "10.3.0.0-24", poolRange: "10.3.0.0/24", expected: false},
+	}
+	for _, tc := range poolCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if contained := networks.ContainsPool(tc.poolName, tc.poolRange); contained != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, contained)
+			}
+		})
+	}
+
+	clusterWideIPCases := []struct {
+		name            string
+		reservationName string
+		ip              string
+		expected        bool
+	}{
+		{name: "Reservation of an unnamed network", reservationName: "10.0.0.5", ip: "10.0.0.5", expected: true},
+		{name: "Reservation of a named network", reservationName: "sliced-10.1.3.5", ip: "10.1.3.5", expected: true},
+		{name: "Reservation of another network", reservationName: "other-10.1.3.5", ip: "10.1.3.5", expected: false},
+		{name: "Reservation of a reconciled network", reservationName: "10.3.0.5", ip: "10.3.0.5", expected: false},
+	}
+	for _, tc := range clusterWideIPCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if contained := networks.ContainsClusterWideIP(tc.reservationName, net.ParseIP(tc.ip)); contained != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, contained)
+			}
+		})
+	}
+}
+
+func TestWhereaboutsNetworks(t *testing.T) {
+	netAttachDef := func(name, config string) nadv1.NetworkAttachmentDefinition {
+		return nadv1.NetworkAttachmentDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       nadv1.NetworkAttachmentDefinitionSpec{Config: config},
+		}
+	}
+	networks := NewWhereaboutsNetworks([]nadv1.NetworkAttachmentDefinition{
+		netAttachDef("unnamed", `{"name": "unnamed", "ipam": {"type": "whereabouts", "range": "10.0.0.0/24"}}`),
+		netAttachDef("sliced", `{"name": "sliced", "plugins": [{"type": "macvlan", "ipam": {"type": "whereabouts", "range": "10.1.0.0/16", "node_slice_size": "/24", "network_name": "sliced"}}]}`),
+	})
+
+	cases := []struct {
+		name     string
+		actual   string
+		expected string
+	}{
+		{name: "Pool of an unnamed network", actual: networks.PoolNetwork("10.0.0.0-24", "10.0.0.0/24"), expected: "10.0.0.0/24"},
+		{name: "Slice pool of a named network", actual: networks.PoolNetwork("sliced-node1-10.1.3.0-24", "10.1.3.0/24"), expected: "sliced"},
+		{name: "Pool of no known network", actual: networks.PoolNetwork("10.9.0.0-24", "10.9.0.0/24"), expected: UnknownNetwork},
+		{name: "Reservation of an unnamed network", actual: networks.ClusterWideIPNetwork("10.0.0.5", net.ParseIP("10.0.0.5")), expected: "10.0.0.0/24"},
+		{name: "Reservation of a named network", actual: networks.ClusterWideIPNetwork("sliced-10.1.3.5", net.ParseIP("10.1.3.5")), expected: "sliced"},
+		{name: "Reservation of no known network", actual: networks.ClusterWideIPNetwork("other-10.1.3.5", net.ParseIP("10.1.3.5")), expected: UnknownNetwork},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.actual != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, tc.actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/allocate"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// RequestedIPAnnotation pins the IPs of a pod, e.g. whereabouts.cni.cncf.io/ip: 10.10.0.53 - or a comma separated
+// list, one IP per range. The annotation covers all the whereabouts networks of the pod: each network assigns the
+// requested IPs belonging to its ranges, ignoring the others.
+const RequestedIPAnnotation = "whereabouts.cni.cncf.io/ip"
+
+// requestedIPs returns the IPs requested for the pod: those of the network config args or, when there are none,
+// those of the pod annotation belonging to the ranges of the network
+func requestedIPs(ctx context.Context, ipam *KubernetesIPAM, ipamConf whereaboutstypes.IPAMConfig) ([]net.IP, error) {
+	if len(ipamConf.RequestedIPs) > 0 {
+		return ipamConf.RequestedIPs, nil
+	}
+	if ipamConf.PodName == "" {
+		return nil, nil
+	}
+
+	pod, err := ipam.GetPod(ctx, ipamConf.PodNamespace, ipamConf.PodName)
+	if errors.IsNotFound(err) {
+		logging.Debugf("pod %s not found, not looking for requested IPs", ipamConf.GetPodRef())
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %s: %w", ipamConf.GetPodRef(), err)
+	}
+	annotation, found := pod.GetAnnotations()[RequestedIPAnnotation]
+	if !found {
+		return nil, nil
+	}
+
+	var podRequestedIPs []net.IP
+	for _, item := range strings.Split(annotation, ",") {
+		ip := net.ParseIP(strings.TrimSpace(item))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q in the %s annotation of pod %s", item, RequestedIPAnnotation, ipamConf.GetPodRef())
+		}
+		if err := allocate.CheckRequestedIPs(ipamConf.IPRanges, []net.IP{ip}); err != nil {
+			logging.Debugf("ignoring the requested IP %s of pod %s: %v", ip, ipamConf.GetPodRef(), err)
+			continue
+		}
+		podRequestedIPs = append(podRequestedIPs, ip)
+	}
+	return podRequestedIPs, nil
+}
+
+// requestedIPShards returns the shard holding the requested IP, when the range is sharded
+func requestedIPShards(shards []*poolShard, requestedIP net.IP) []*poolShard {
+	if requestedIP == nil {
+		return shards
+	}
+	for _, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		if _, shardNet, err := net.ParseCIDR(shard.ipRange); err == nil && shardNet.Contains(requestedIP) {
+			return []*poolShard{shard}
+		}
+	}
+	return shards
+}
@@ -0,0 +1,94 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/allocate"
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+func TestRequestedIPs(t *testing.T) {
+	cases := []struct {
+		name           string
+		annotation     string
+		argsIPs        []net.IP
+		expectedIP     string
+		expectedReason string
+	}{
+		{
+			name:       "Annotation, ignoring the IPs of other networks",
+			annotation: "192.168.0.1, 10.0.0.53",
+			expectedIP: "10.0.0.53",
+		},
+		{
+			name:       "Args taking precedence over the annotation",
+			annotation: "10.0.0.53",
+			argsIPs:    []net.IP{net.ParseIP("10.0.0.54")},
+			expectedIP: "10.0.0.54",
+		},
+		{
+			name:           "IP allocated in the pool",
+			annotation:     "10.0.0.2",
+			expectedReason: allocate.RequestedIPReserved,
+		},
+		{
+			name:           "IP reserved cluster wide",
+			annotation:     "10.0.0.3",
+			expectedReason: allocate.RequestedIPReserved,
+		},
+		{
+			name:           "Args IP outside of the ranges",
+			argsIPs:        []net.IP{net.ParseIP("192.168.0.1")},
+			expectedReason: allocate.RequestedIPOutsideRange,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pool := &whereaboutsv1alpha1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "10.0.0.0-24", Namespace: "kube-system", ResourceVersion: "1"},
+				Spec: whereaboutsv1alpha1.IPPoolSpec{Range: "10.0.0.0/24", Allocations: map[string]whereaboutsv1alpha1.IPAllocation{
+					"2": {ContainerID: "container-b", PodRef: "default/pod-b", IfName: "net1"},
+				}},
+			}
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:        "pod-a",
+				Namespace:   "default",
+				Annotations: map[string]string{RequestedIPAnnotation: tc.annotation},
+			}}
+			wbClient := fakewbclient.NewSimpleClientset(pool, overlappingRangeIPReservation("10.0.0.3", "default/pod-c"))
+			ipamConf := whereaboutstypes.IPAMConfig{
+				IPRanges:          []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/24"}},
+				OverlappingRanges: true,
+				PodNamespace:      "default",
+				PodName:           "pod-a",
+				RequestedIPs:      tc.argsIPs,
+			}
+			ipam := NewKubernetesIPAMWithClient("container-a", "net1", ipamConf, "kube-system",
+				*NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset(pod)))
+
+			ips, err := IPManagementKubernetesUpdate(context.TODO(), whereaboutstypes.Allocate, ipam, ipamConf)
+			if tc.expectedReason != "" {
+				var requestedIPErr allocate.RequestedIPError
+				if !errors.As(err, &requestedIPErr) || requestedIPErr.Reason() != tc.expectedReason {
+					t.Errorf("Expected the requested IP to be refused as %q, got %v", tc.expectedReason, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(ips) != 1 || ips[0].IP.String() != tc.expectedIP {
+				t.Errorf("Expected IP %s to be allocated, got %v", tc.expectedIP, ips)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// shardPoolTag stands in for the node name in the names of the shard pools, e.g. mynet-shard-10.0.64.0-18
+const shardPoolTag = "shard"
+
+// poolShard is one of the pools a range is split across, when the pool_shards parameter is set: each pool holds a
+// contiguous part of the range, hence the pool of an IP is deterministic.
+type poolShard struct {
+	// ipRange is the part of the range held by the shard pool
+	ipRange string
+	// assignRange is the range of the network, its start and end bounded to the part held by the shard: the network
+	// and broadcast addresses of the part are usable, unlike those of the range
+	assignRange whereaboutstypes.RangeConfiguration
+}
+
+// rangeShards returns the shards of the range, in the order the allocations of the container go through them:
+// starting at a shard picked by hashing the container ID, spreading the concurrent allocations across the pools. A
+// range which is not sharded has a single nil shard.
+func rangeShards(ipRange whereaboutstypes.RangeConfiguration, shards int, containerID string) ([]*poolShard, error) {
+	if shards <= 1 {
+		return []*poolShard{nil}, nil
+	}
+
+	ipNet, err := ipRange.Network()
+	if err != nil {
+		return nil, fmt.Errorf("invalid range %s: %w", ipRange.Range, err)
+	}
+	firstIP, lastIP, err := ipRange.UsableRange()
+	if err != nil {
+		return nil, err
+	}
+	subnets, err := iphelpers.SplitSubnet(*ipNet, shards)
+	if err != nil {
+		return nil, err
+	}
+
+	var rangeShards []*poolShard
+	for _, subnet := range subnets {
+		shardFirstIP := iphelpers.NetworkIP(subnet)
+		if iphelpers.CompareIPs(shardFirstIP, firstIP) < 0 {
+			shardFirstIP = firstIP
+		}
+		shardLastIP := iphelpers.SubnetBroadcastIP(subnet)
+		if iphelpers.CompareIPs(shardLastIP, lastIP) > 0 {
+			shardLastIP = lastIP
+		}
+		if iphelpers.CompareIPs(shardFirstIP, shardLastIP) > 0 {
+			// the shard lies outside range_start - range_end
+			continue
+		}
+
+		assignRange := ipRange
+		assignRange.RangeStart = shardFirstIP
+		assignRange.RangeEnd = shardLastIP
+		rangeShards = append(rangeShards, &poolShard{ipRange: subnet.String(), assignRange: assignRange})
+	}
+	if len(rangeShards) == 0 {
+		return nil, fmt.Errorf("no shard of range %s holds usable IPs", ipRange.Range)
+	}
+
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(containerID))
+	first := int(hash.Sum32() % uint32(len(rangeShards)))
+	orderedShards := make([]*poolShard, 0, len(rangeShards))
+	orderedShards = append(orderedShards, rangeShards[first:]...)
+	return append(orderedShards, rangeShards[:first]...), nil
+}
+
+// shardHolding is what a shard pool holds of the interface an allocation is requested for, from the most to the least
+// binding: AssignIP reuses the allocation of the interface, then that of its MAC address, then its sticky IP
+type shardHolding int
+
+const (
+	shardHoldsNothing shardHolding = iota
+	shardHoldsStickyIP
+	shardHoldsMACAllocation
+	shardHoldsAllocation
+)
+
+// heldShards moves first the shard holding the most binding allocation of the interface: its own - e.g. pre-reserved
+// for its pod under types.PreReservationContainerID - or else that of its MAC address, or else its sticky IP. The
+// hash of the container ID only spreads the new allocations across the shards, while a retried ADD, a recreated
+// sandbox or the claim of a pre-reservation comes with another container ID: each shard pool only tells what it
+// holds. It returns whether the first shard holds the allocation of the interface or of its MAC address, which take
+// precedence over a requested IP. The shard pools are only read, creating none.
+func heldShards(ctx context.Context, ipam *KubernetesIPAM, networkName string, shards []*poolShard, podRef, podUID, ifName, mac, stickyKey string, sticky bool) ([]*poolShard, bool, error) {
+	if len(shards) <= 1 {
+		return shards, false, nil
+	}
+
+	held, heldIndex := shardHoldsNothing, 0
+	for i, shard := range shards {
+		holding, err := shardHoldingOf(ctx, ipam, IPPoolName(PoolIdentifier{IpRange: shard.ipRange, NetworkName: networkName, Shard: true}),
+			podRef, podUID, ifName, mac, stickyKey, sticky)
+		if err != nil {
+			return nil, false, err
+		}
+		if holding > held {
+			held, heldIndex = holding, i
+		}
+		if held == shardHoldsAllocation {
+			break
+		}
+	}
+	if held == shardHoldsNothing {
+		return shards, false, nil
+	}
+
+	orderedShards := make([]*poolShard, 0, len(shards))
+	orderedShards = append(orderedShards, shards[heldIndex])
+	orderedShards = append(orderedShards, shards[:heldIndex]...)
+	orderedShards = append(orderedShards, shards[heldIndex+1:]...)
+	return orderedShards, held >= shardHoldsMACAllocation, nil
+}
+
+// shardHoldingOf returns what the shard pool of the name holds of the interface, nothing when it does not exist
+func shardHoldingOf(ctx context.Context, ipam *KubernetesIPAM, name, podRef, podUID, ifName, mac, stickyKey string, sticky bool) (shardHolding, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+
+	pool, err := ipam.client.WhereaboutsV1alpha1().IPPools(ipam.poolNamespace).Get(ctxWithTimeout, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return shardHoldsNothing, nil
+	} else if err != nil {
+		return shardHoldsNothing, fmt.Errorf("k8s get error: %s", ipam.explainForbidden(err))
+	}
+	firstIP, _, err := pool.ParseCIDR()
+	if err != nil {
+		return shardHoldsNothing, err
+	}
+
+	holding := shardHoldsNothing
+	for _, allocation := range toIPReservationList(pool.Spec.Allocations, firstIP) {
+		if allocation.PodRef == podRef && allocation.IfName == ifName &&
+			(podUID == "" || allocation.PodUID == "" || allocation.PodUID == podUID) {
+			return shardHoldsAllocation, nil
+		}
+		if mac != "" && allocation.MAC == mac {
+			holding = shardHoldsMACAllocation
+		}
+	}
+	if holding == shardHoldsNothing && sticky && (&KubernetesIPPool{firstIP: firstIP, pool: pool}).StickyIP(stickyKey) != nil {
+		holding = shardHoldsStickyIP
+	}
+	return holding, nil
+}
+
+// shardServiceReservations returns the network service reservations of the range held by the shard
+func shardServiceReservations(shard *poolShard, reservations []whereaboutsv1alpha1.ServiceReservation) []whereaboutsv1alpha1.ServiceReservation {
+	if shard == nil || reservations == nil {
+		return reservations
+	}
+	_, shardNet, err := net.ParseCIDR(shard.ipRange)
+	if err != nil {
+		return reservations
+	}
+	shardReservations := []whereaboutsv1alpha1.ServiceReservation{}
+	for _, reservation := range reservations {
+		if shardNet.Contains(net.ParseIP(reservation.IP)) {
+			shardReservations = append(shardReservations, reservation)
+		}
+	}
+	return shardReservations
+}
+
+// ShardPoolIdentifiers returns the identifiers of the pools the range is split across, see the pool_shards parameter.
+// A shard pool is only created on its first allocation.
+func ShardPoolIdentifiers(ipRange, networkName string, shards int) ([]PoolIdentifier, error) {
+	_, ipNet, err := net.ParseCIDR(ipRange)
+	if err != nil {
+		return nil, fmt.Errorf("invalid range %s: %w", ipRange, err)
+	}
+	subnets, err := iphelpers.SplitSubnet(*ipNet, shards)
+	if err != nil {
+		return nil, err
+	}
+
+	poolIdentifiers := make([]PoolIdentifier, 0, len(subnets))
+	for _, subnet := range subnets {
+		poolIdentifiers = append(poolIdentifiers, PoolIdentifier{IpRange: subnet.String(), NetworkName: networkName, Shard: true})
+	}
+	return poolIdentifiers, nil
+}
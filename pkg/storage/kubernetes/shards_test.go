@@ -0,0 +1,283 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/allocate"
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+func TestRangeShards(t *testing.T) {
+	cases := []struct {
+		name           string
+		ipRange        whereaboutstypes.RangeConfiguration
+		shards         int
+		expectedShards map[string][2]string
+	}{
+		{
+			name:           "Unsharded range",
+			ipRange:        whereaboutstypes.RangeConfiguration{Range: "10.0.0.0/24"},
+			shards:         0,
+			expectedShards: map[string][2]string{},
+		},
+		{
+			name:    "Sharded range",
+			ipRange: whereaboutstypes.RangeConfiguration{Range: "10.0.0.0/24"},
+			shards:  4,
+			expectedShards: map[string][2]string{
+				"10.0.0.0/26":   {"10.0.0.1", "10.0.0.63"},
+				"10.0.0.64/26":  {"10.0.0.64", "10.0.0.127"},
+				"10.0.0.128/26": {"10.0.0.128", "10.0.0.191"},
+				"10.0.0.192/26": {"10.0.0.192", "10.0.0.254"},
+			},
+		},
+		{
+			name: "Sharded range bounded by its start and end",
+			ipRange: whereaboutstypes.RangeConfiguration{
+				Range:      "10.0.0.0/24",
+				RangeStart: net.ParseIP("10.0.0.100"),
+				RangeEnd:   net.ParseIP("10.0.0.150"),
+			},
+			shards: 4,
+			expectedShards: map[string][2]string{
+				"10.0.0.64/26":  {"10.0.0.100", "10.0.0.127"},
+				"10.0.0.128/26": {"10.0.0.128", "10.0.0.150"},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			shards, err := rangeShards(tc.ipRange, tc.shards, "container")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(tc.expectedShards) == 0 {
+				if len(shards) != 1 || shards[0] != nil {
+					t.Errorf("Expected a single nil shard, got %v", shards)
+				}
+				return
+			}
+			if len(shards) != len(tc.expectedShards) {
+				t.Fatalf("Expected %d shards, got %d", len(tc.expectedShards), len(shards))
+			}
+			for _, shard := range shards {
+				bounds, found := tc.expectedShards[shard.ipRange]
+				if !found {
+					t.Fatalf("Unexpected shard %s", shard.ipRange)
+				}
+				if shard.assignRange.Range != tc.ipRange.Range ||
+					shard.assignRange.RangeStart.String() != bounds[0] || shard.assignRange.RangeEnd.String() != bounds[1] {
+					t.Errorf("Expected shard %s to assign %s - %s of range %s, got %s - %s of range %s", shard.ipRange, bounds[0], bounds[1],
+						tc.ipRange.Range, shard.assignRange.RangeStart, shard.assignRange.RangeEnd, shard.assignRange.Range)
+				}
+			}
+		})
+	}
+}
+
+func TestShardedIPManagement(t *testing.T) {
+	// the fake clientset does not set the resource version of the pools it creates, which the updates check
+	var shardPools []runtime.Object
+	for _, poolRange := range []string{"10.0.0.0/31", "10.0.0.2/31"} {
+		shardPools = append(shardPools, &whereaboutsv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            IPPoolName(PoolIdentifier{IpRange: poolRange, NetworkName: UnnamedNetwork, Shard: true}),
+				Namespace:       "kube-system",
+				ResourceVersion: "1",
+			},
+			Spec: whereaboutsv1alpha1.IPPoolSpec{Range: poolRange, Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+		})
+	}
+	wbClient := fakewbclient.NewSimpleClientset(shardPools...)
+	client := *NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset())
+	ipamConf := whereaboutstypes.IPAMConfig{
+		IPRanges:     []whereaboutstypes.RangeConfiguration{{Range: "10.0.0.0/30"}},
+		PoolShards:   2,
+		PodNamespace: "default",
+	}
+	manage := func(mode int, containerID string) ([]net.IPNet, error) {
+		conf := ipamConf
+		conf.PodName = containerID
+		ipam := NewKubernetesIPAMWithClient(containerID, "net1", conf, "kube-system", client)
+		return IPManagementKubernetesUpdate(context.TODO(), mode, ipam, conf)
+	}
+
+	// each shard holds a single usable IP: the second allocation moves on to the other shard
+	allocated := map[string]bool{}
+	for _, containerID := range []string{"pod-a", "pod-b"} {
+		ips, err := manage(whereaboutstypes.Allocate, containerID)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(ips) != 1 || ips[0].Mask.String() != net.CIDRMask(30, 32).String() {
+			t.Fatalf("Expected an IP of the range, got %v", ips)
+		}
+		allocated[ips[0].IP.String()] = true
+	}
+	if !allocated["10.0.0.1"] || !allocated["10.0.0.2"] {
+		t.Errorf("Expected 10.0.0.1 and 10.0.0.2 to be allocated, got %v", allocated)
+	}
+	if _, err := manage(whereaboutstypes.Allocate, "pod-c"); err == nil {
+		t.Errorf("Expected the range to be exhausted, got no error")
+	} else if _, exhausted := err.(allocate.AssignmentError); !exhausted {
+		t.Errorf("Expected an assignment error, got %v", err)
+	}
+
+	for poolName, usableIP := range map[string]string{"shard-10.0.0.0-31": "10.0.0.1", "shard-10.0.0.2-31": "10.0.0.2"} {
+		pool, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").Get(context.TODO(), poolName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Expected shard pool %s to exist, got %v", poolName, err)
+		}
+		if len(pool.Spec.Allocations) != 1 {
+			t.Errorf("Expected a single allocation in shard pool %s, got %v", poolName, pool.Spec.Allocations)
+		}
+		if pool.Status.RangeStart != usableIP || pool.Status.RangeEnd != usableIP {
+			t.Errorf("Expected shard pool %s to publish the usable range %s-%s, got %s-%s", poolName, usableIP, usableIP,
+				pool.Status.RangeStart, pool.Status.RangeEnd)
+		}
+	}
+
+	for _, containerID := range []string{"pod-a", "pod-b"} {
+		if _, err := manage(whereaboutstypes.Deallocate, containerID); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	pools, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, pool := range pools.Items {
+		if len(pool.Spec.Allocations) != 0 {
+			t.Errorf("Expected shard pool %s to be emptied, got %v", pool.GetName(), pool.Spec.Allocations)
+		}
+	}
+}
+
+func TestShardedAllocationFollowsTheInterface(t *testing.T) {
+	ipRange := whereaboutstypes.RangeConfiguration{Range: "10.0.0.0/29"}
+	// containerIDs on another shard than the first allocation of the interface, as picked by hashing them
+	shardOf := func(containerID string) string {
+		shards, err := rangeShards(ipRange, 2, containerID)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return shards[0].ipRange
+	}
+	otherShardContainerID := func(containerID string) string {
+		for i := 0; ; i++ {
+			if other := fmt.Sprintf("%s-%d", containerID, i); shardOf(other) != shardOf(containerID) {
+				return other
+			}
+		}
+	}
+
+	cases := []struct {
+		name string
+		// configure sets up the network configuration of the interface
+		configure func(conf *whereaboutstypes.IPAMConfig)
+		// first is the container ID of the first allocation, deallocated before the second when released is set
+		first    string
+		released bool
+		// secondPodName is the pod of the second allocation, the pod of the first when empty
+		secondPodName string
+	}{
+		{
+			name:  "Retried ADD of another container ID",
+			first: "sandbox-a",
+		},
+		{
+			name:      "Claim of a pre-reservation",
+			configure: func(conf *whereaboutstypes.IPAMConfig) { conf.PodUID = "uid-1" },
+			first:     whereaboutstypes.PreReservationContainerID("uid-1"),
+		},
+		{
+			name:      "Sticky IP",
+			configure: func(conf *whereaboutstypes.IPAMConfig) { conf.EnableStickyIPs = true },
+			first:     "sandbox-a",
+			released:  true,
+		},
+		{
+			name: "Allocation of the MAC address",
+			configure: func(conf *whereaboutstypes.IPAMConfig) {
+				conf.ReservationKey = whereaboutstypes.ReservationKeyMAC
+				conf.MAC = "02:00:00:00:00:01"
+			},
+			first:         "sandbox-a",
+			secondPodName: "vm-recreated",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// the fake clientset does not set the resource version of the pools it creates, which the updates check
+			var shardPools []runtime.Object
+			for _, poolRange := range []string{"10.0.0.0/30", "10.0.0.4/30"} {
+				shardPools = append(shardPools, &whereaboutsv1alpha1.IPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:            IPPoolName(PoolIdentifier{IpRange: poolRange, NetworkName: UnnamedNetwork, Shard: true}),
+						Namespace:       "kube-system",
+						ResourceVersion: "1",
+					},
+					Spec: whereaboutsv1alpha1.IPPoolSpec{Range: poolRange, Allocations: map[string]whereaboutsv1alpha1.IPAllocation{}},
+				})
+			}
+			wbClient := fakewbclient.NewSimpleClientset(shardPools...)
+			client := *NewKubernetesClient(wbClient, fakek8sclient.NewSimpleClientset())
+			ipamConf := whereaboutstypes.IPAMConfig{
+				IPRanges:     []whereaboutstypes.RangeConfiguration{ipRange},
+				PoolShards:   2,
+				PodNamespace: "default",
+				PodName:      "pod-a",
+			}
+			if tc.configure != nil {
+				tc.configure(&ipamConf)
+			}
+			manage := func(mode int, containerID string, conf whereaboutstypes.IPAMConfig) ([]net.IPNet, error) {
+				ipam := NewKubernetesIPAMWithClient(containerID, "net1", conf, "kube-system", client)
+				return IPManagementKubernetesUpdate(context.TODO(), mode, ipam, conf)
+			}
+
+			firstIPs, err := manage(whereaboutstypes.Allocate, tc.first, ipamConf)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if tc.released {
+				if _, err := manage(whereaboutstypes.Deallocate, tc.first, ipamConf); err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+			}
+			secondConf := ipamConf
+			if tc.secondPodName != "" {
+				secondConf.PodName = tc.secondPodName
+			}
+			secondIPs, err := manage(whereaboutstypes.Allocate, otherShardContainerID(tc.first), secondConf)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(firstIPs) != 1 || len(secondIPs) != 1 || !firstIPs[0].IP.Equal(secondIPs[0].IP) {
+				t.Errorf("Expected the interface to be allocated %v again, got %v", firstIPs, secondIPs)
+			}
+
+			pools, err := wbClient.WhereaboutsV1alpha1().IPPools("kube-system").List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			allocations := 0
+			for _, pool := range pools.Items {
+				allocations += len(pool.Spec.Allocations)
+			}
+			if allocations != 1 {
+				t.Errorf("Expected a single allocation across the shards, got %d", allocations)
+			}
+		})
+	}
+}
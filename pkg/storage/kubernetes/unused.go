@@ -0,0 +1,151 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// The IANA protocol numbers of ICMP and ICMPv6, to parse the messages received
+const (
+	protocolICMP   = 1
+	protocolICMPv6 = 58
+)
+
+// ipInUse reports whether the IP is used outside of whereabouts, e.g. by an unmanaged device of a shared VLAN, awaiting
+// the answer for the timeout at most
+type ipInUse func(ctx context.Context, ip net.IP, timeout time.Duration) (bool, error)
+
+// maxUnusedVerifications bounds the IPs verified between the leader elections of an allocation
+const maxUnusedVerifications = 16
+
+// unverifiedIPError interrupts the IP management holding the lease on a candidate IP not verified yet: awaiting the
+// answer of the IP would hold up the allocations of the whole cluster
+type unverifiedIPError struct {
+	ip net.IP
+}
+
+func (e *unverifiedIPError) Error() string {
+	return fmt.Sprintf("IP %s is not verified unused yet", e.ip)
+}
+
+// externallyUsed reports whether the IP answers the verification of the configuration. Failing to verify it, e.g.
+// lacking the privileges to send ICMP messages, does not hold the allocation back: the IP is deemed unused. While the
+// lease is held, only the IPs verified beforehand are reported, the others failing with an unverifiedIPError.
+func (i *KubernetesIPAM) externallyUsed(ctx context.Context, ipamConf whereaboutstypes.IPAMConfig, ip net.IP) (bool, error) {
+	if ipamConf.VerifyUnused != whereaboutstypes.VerifyUnusedICMP {
+		return false, nil
+	}
+	if i.verifiedIPs != nil {
+		used, verified := i.verifiedIPs[ip.String()]
+		if !verified {
+			return false, &unverifiedIPError{ip: ip}
+		}
+		return used, nil
+	}
+	return i.verifyUnused(ctx, ipamConf, ip), nil
+}
+
+func (i *KubernetesIPAM) verifyUnused(ctx context.Context, ipamConf whereaboutstypes.IPAMConfig, ip net.IP) bool {
+	inUse := i.ipInUse
+	if inUse == nil {
+		inUse = answersICMPEcho
+	}
+	timeout := time.Duration(ipamConf.VerifyUnusedTimeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = whereaboutstypes.DefaultVerifyUnusedTimeout * time.Millisecond
+	}
+	used, err := inUse(ctx, ip, timeout)
+	if err != nil {
+		logging.Errorf("Error verifying IP %s is unused: %v", ip, err)
+		return false
+	}
+	return used
+}
+
+// verifyingOutsideLease runs the IP management under the lease, verifying its candidate IPs once the lease is released:
+// each candidate not verified yet interrupts the IP management, which is run again with the verified IPs.
+func (i *KubernetesIPAM) verifyingOutsideLease(ctx context.Context, mode int, ipamConf whereaboutstypes.IPAMConfig, runAsLeader func() error) error {
+	if mode != whereaboutstypes.Allocate || ipamConf.VerifyUnused != whereaboutstypes.VerifyUnusedICMP {
+		return runAsLeader()
+	}
+	i.verifiedIPs = map[string]bool{}
+	defer func() { i.verifiedIPs = nil }()
+	for verifications := 0; ; verifications++ {
+		err := runAsLeader()
+		var unverified *unverifiedIPError
+		if !errors.As(err, &unverified) || verifications == maxUnusedVerifications {
+			return err
+		}
+		logging.FromContext(ctx).Debugf("verifying IP %s is unused outside of the lease", unverified.ip)
+		i.verifiedIPs[unverified.ip.String()] = i.verifyUnused(ctx, ipamConf, unverified.ip)
+	}
+}
+
+// answersICMPEcho sends an ICMP echo request to the IP from the host, and reports whether it is answered within the
+// timeout
+func answersICMPEcho(ctx context.Context, ip net.IP, timeout time.Duration) (bool, error) {
+	network, address, protocol := "ip4:icmp", "0.0.0.0", protocolICMP
+	var echoType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		network, address, protocol = "ip6:ipv6-icmp", "::", protocolICMPv6
+		echoType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	conn, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return false, err
+	}
+
+	echo := &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: rand.IntN(1 << 16), Data: []byte("whereabouts")}
+	request, err := (&icmp.Message{Type: echoType, Body: echo}).Marshal(nil)
+	if err != nil {
+		return false, err
+	}
+	if _, err := conn.WriteTo(request, &net.IPAddr{IP: ip}); err != nil {
+		return false, err
+	}
+
+	// the socket receives the ICMP messages of the whole host: wait for the reply to our request
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if peerAddr, ok := peer.(*net.IPAddr); !ok || !peerAddr.IP.Equal(ip) {
+			continue
+		}
+		reply, err := icmp.ParseMessage(protocol, buf[:n])
+		if err != nil || reply.Type != replyType {
+			continue
+		}
+		if replyEcho, ok := reply.Body.(*icmp.Echo); ok && replyEcho.ID == echo.ID && replyEcho.Seq == echo.Seq {
+			return true, nil
+		}
+	}
+}
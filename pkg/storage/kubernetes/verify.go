@@ -0,0 +1,226 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/storage"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// The drifts between the IP pool allocations and their cluster wide reservations
+const (
+	// ReservationMissing is an allocation whose IP is not reserved cluster wide
+	ReservationMissing = "missing"
+	// ReservationMismatched is an allocation whose IP is reserved cluster wide for a pod no IP pool allocates it to
+	ReservationMismatched = "mismatched"
+	// ReservationConflicting is an IP several IP pools of the network allocate to different pods: telling which pod
+	// actually uses it takes a human, hence its reservation is left alone
+	ReservationConflicting = "conflicting"
+)
+
+// verifiedReservationAge spares the reservations created since: the IP pool allocation of an IP is written after its
+// cluster wide reservation, hence may still be in flight
+const verifiedReservationAge = 5 * time.Minute
+
+// ReservationDrift is an IP pool allocation its cluster wide reservation disagrees with
+type ReservationDrift struct {
+	Drift       string `json:"drift"`
+	Reservation string `json:"reservation"`
+	Namespace   string `json:"namespace"`
+	Pool        string `json:"pool"`
+	IP          string `json:"ip"`
+	PodRef      string `json:"podRef"`
+	// ReservedPodRef is the pod the IP is reserved for, empty when the reservation is missing
+	ReservedPodRef string `json:"reservedPodRef,omitempty"`
+	// Repaired tells the reservation was created, or rewritten, after the allocation
+	Repaired bool `json:"repaired,omitempty"`
+}
+
+// reservationOwner is an IP pool allocation of a network reserving its IPs cluster wide
+type reservationOwner struct {
+	pool       *KubernetesIPPool
+	allocation whereaboutstypes.IPReservation
+	network    whereaboutsNetwork
+	ipRange    *net.IPNet
+}
+
+// VerifyOverlappingReservations cross-references the allocations of the IP pools - of the node slices and shards too -
+// of the networks with enable_overlapping_ranges set with their cluster wide reservations, which a crash between the
+// writes of both, or an edit by hand, leaves behind. The missing reservations are created, and the reservations of
+// other pods rewritten after the allocations, unless in dry-run mode; the reservations of IPs allocated to different
+// pods are only reported. The pools of networks opted out of reconciliation, or of no known network, are left alone,
+// as are the floating IP claims, which are not reserved cluster wide. The reservations no allocation backs are the
+// reconciler's business. It returns the drifts found, and the first repair failure.
+func (i *Client) VerifyOverlappingReservations(ctx context.Context, dryRun bool) ([]ReservationDrift, error) {
+	if i.nadClient == nil {
+		return nil, fmt.Errorf("verifying the cluster wide reservations needs the network-attachment-definitions")
+	}
+	netAttachDefs, err := i.ListNetAttachDefs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the network-attachment-definitions: %w", err)
+	}
+	networks := NewWhereaboutsNetworks(netAttachDefs)
+	unreconciledNetworks := NewUnreconciledNetworks(netAttachDefs)
+
+	// the pools are listed before the reservations: a reservation is written before, and deleted after, its allocation
+	pools, err := i.ListIPPools(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the IP pools: %w", err)
+	}
+	reservations, err := i.ListOverlappingIPs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the overlapping range reservations: %w", err)
+	}
+	reserved := map[string]*whereaboutsv1alpha1.OverlappingRangeIPReservation{}
+	for j := range reservations {
+		reserved[reservations[j].GetNamespace()+"/"+reservations[j].GetName()] = &reservations[j]
+	}
+
+	owners := map[string][]reservationOwner{}
+	for _, pool := range pools {
+		k8sPool, ok := pool.(*KubernetesIPPool)
+		if !ok || unreconciledNetworks.ContainsPool(k8sPool.Name(), k8sPool.Range()) {
+			continue
+		}
+		network, ipRange, found := networks.networkOfPool(k8sPool.Name(), k8sPool.Range())
+		if !found || !network.overlappingRanges {
+			continue
+		}
+		for _, allocation := range k8sPool.Allocations() {
+			if allocation.PodRef == "" {
+				continue
+			}
+			key := k8sPool.Namespace() + "/" + NormalizeIP(allocation.IP, network.networkName)
+			owners[key] = append(owners[key], reservationOwner{pool: k8sPool, allocation: allocation, network: network, ipRange: ipRange})
+		}
+	}
+	keys := make([]string, 0, len(owners))
+	for key := range owners {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	now := time.Now()
+	var drifts []ReservationDrift
+	var repairErr error
+	for _, key := range keys {
+		keyOwners := owners[key]
+		reservation := reserved[key]
+		if conflicting(keyOwners) {
+			for _, owner := range keyOwners {
+				drifts = append(drifts, newReservationDrift(ReservationConflicting, owner, reservation))
+			}
+			_ = logging.Errorf("IP %s is allocated to several pods by the IP pools of its network", keyOwners[0].allocation.IP)
+			continue
+		}
+
+		owner := keyOwners[0]
+		drift := ReservationMissing
+		if reservation != nil {
+			if reservation.Spec.PodRef == owner.allocation.PodRef || now.Sub(reservation.GetCreationTimestamp().Time) < verifiedReservationAge {
+				continue
+			}
+			drift = ReservationMismatched
+		}
+		reservationDrift := newReservationDrift(drift, owner, reservation)
+		logging.Verbosef("the cluster wide reservation %s/%s of IP %s, allocated to pod %s in IP pool %s, is %s",
+			reservationDrift.Namespace, reservationDrift.Reservation, reservationDrift.IP, reservationDrift.PodRef, reservationDrift.Pool, drift)
+		if !dryRun {
+			reservationDrift.Repaired, err = i.repairReservation(ctx, owner, reservation)
+			if err != nil && repairErr == nil {
+				repairErr = err
+			}
+		}
+		drifts = append(drifts, reservationDrift)
+	}
+	return drifts, repairErr
+}
+
+// repairReservation creates, or rewrites, the cluster wide reservation of the allocation once the IP pool - read anew -
+// still holds it: the allocation may be released meanwhile, along with its reservation. A reservation written
+// meanwhile is left as it is. It returns whether the reservation was repaired.
+func (i *Client) repairReservation(ctx context.Context, owner reservationOwner, reservation *whereaboutsv1alpha1.OverlappingRangeIPReservation) (bool, error) {
+	allocation := owner.allocation
+	reservationName := NormalizeIP(allocation.IP, owner.network.networkName)
+	pool, err := i.namedIPPool(ctx, owner.pool.Namespace(), owner.pool.Name())
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to get the IP pool %s: %w", owner.pool.Name(), err)
+	}
+	if err != nil || !holdsAllocation(pool, allocation) {
+		logging.Debugf("IP %s of IP pool %s was released meanwhile, not repairing its reservation", allocation.IP, owner.pool.Name())
+		return false, nil
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, storage.RequestTimeout)
+	defer cancel()
+	if reservation == nil {
+		store := &KubernetesOverlappingRangeStore{i.client, i.whereaboutsNamespace}
+		err = store.UpdateOverlappingRangeAllocation(ctxWithTimeout, whereaboutstypes.Allocate, allocation.IP,
+			allocation.ContainerID, allocation.PodRef, allocation.IfName, owner.network.networkName, owner.ipRange.String())
+	} else {
+		repaired := reservation.DeepCopy()
+		if repaired.Labels == nil {
+			repaired.Labels = map[string]string{}
+		}
+		repaired.Labels[ContainerIDLabel] = containerIDLabelValue(allocation.ContainerID)
+		repaired.Spec = whereaboutsv1alpha1.OverlappingRangeIPReservationSpec{
+			ContainerID: allocation.ContainerID,
+			PodRef:      allocation.PodRef,
+			IfName:      allocation.IfName,
+		}
+		_, err = i.client.WhereaboutsV1alpha1().OverlappingRangeIPReservations(repaired.GetNamespace()).Update(
+			ctxWithTimeout, repaired, metav1.UpdateOptions{})
+	}
+	if k8serrors.IsAlreadyExists(err) || k8serrors.IsConflict(err) {
+		logging.Debugf("the cluster wide reservation %s was written meanwhile, not repairing it: %v", reservationName, err)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to repair the cluster wide reservation %s: %w", reservationName, err)
+	}
+	logging.Verbosef("repaired the cluster wide reservation %s of IP %s to pod %s", reservationName, allocation.IP, allocation.PodRef)
+	return true, nil
+}
+
+func newReservationDrift(drift string, owner reservationOwner, reservation *whereaboutsv1alpha1.OverlappingRangeIPReservation) ReservationDrift {
+	reservationDrift := ReservationDrift{
+		Drift:       drift,
+		Reservation: NormalizeIP(owner.allocation.IP, owner.network.networkName),
+		Namespace:   owner.pool.Namespace(),
+		Pool:        owner.pool.Name(),
+		IP:          owner.allocation.IP.String(),
+		PodRef:      owner.allocation.PodRef,
+	}
+	if reservation != nil {
+		reservationDrift.ReservedPodRef = reservation.Spec.PodRef
+	}
+	return reservationDrift
+}
+
+// conflicting tells whether the allocations of an IP belong to different pods
+func conflicting(owners []reservationOwner) bool {
+	for _, owner := range owners[1:] {
+		if owner.allocation.PodRef != owners[0].allocation.PodRef {
+			return true
+		}
+	}
+	return false
+}
+
+func holdsAllocation(pool *KubernetesIPPool, allocation whereaboutstypes.IPReservation) bool {
+	for _, candidate := range pool.Allocations() {
+		if candidate.IP.Equal(allocation.IP) && candidate.PodRef == allocation.PodRef {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package kubernetes
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	fakenadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
+
+	whereaboutsv1alpha1 "github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
+	fakewbclient "github.com/k8snetworkplumbingwg/whereabouts/pkg/generated/clientset/versioned/fake"
+	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+func TestVerifyOverlappingReservations(t *testing.T) {
+	const namespace = "kube-system"
+	netAttachDef := func(name, config string) *nadv1.NetworkAttachmentDefinition {
+		return &nadv1.NetworkAttachmentDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       nadv1.NetworkAttachmentDefinitionSpec{Config: config},
+		}
+	}
+	pool := func(name, ipRange string, allocations map[string]whereaboutsv1alpha1.IPAllocation) *whereaboutsv1alpha1.IPPool {
+		return &whereaboutsv1alpha1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, ResourceVersion: "1"},
+			Spec:       whereaboutsv1alpha1.IPPoolSpec{Range: ipRange, Allocations: allocations},
+		}
+	}
+	reservation := func(name, podRef string, creation metav1.Time) *whereaboutsv1alpha1.OverlappingRangeIPReservation {
+		return &whereaboutsv1alpha1.OverlappingRangeIPReservation{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, ResourceVersion: "1", CreationTimestamp: creation},
+			Spec:       whereaboutsv1alpha1.OverlappingRangeIPReservationSpec{ContainerID: "container", PodRef: podRef, IfName: "net1"},
+		}
+	}
+	allocation := func(podRef string) whereaboutsv1alpha1.IPAllocation {
+		return whereaboutsv1alpha1.IPAllocation{ContainerID: podRef + "-container", PodRef: podRef, IfName: "net1"}
+	}
+
+	nadClient := fakenadclient.NewSimpleClientset()
+	for _, netAttachDef := range []*nadv1.NetworkAttachmentDefinition{
+		netAttachDef("overlapping", `{"name": "overlapping", "ipam": {"type": "whereabouts", "range": "10.0.0.0/24"}}`),
+		netAttachDef("sliced", `{"name": "sliced", "ipam": {"type": "whereabouts", "range": "10.1.0.0/16", "node_slice_size": "/24", "network_name": "blue"}}`),
+		netAttachDef("sharded", `{"name": "sharded", "ipam": {"type": "whereabouts", "range": "10.4.0.0/24", "pool_shards": 4}}`),
+		netAttachDef("reserved-locally", `{"name": "reserved-locally", "ipam": {"type": "whereabouts", "range": "10.2.0.0/24", "enable_overlapping_ranges": false}}`),
+		netAttachDef("narrow", `{"name": "narrow", "ipam": {"type": "whereabouts", "range": "10.3.0.0/24"}}`),
+		netAttachDef("wide", `{"name": "wide", "ipam": {"type": "whereabouts", "range": "10.3.0.0/23"}}`),
+	} {
+		if _, err := nadClient.K8sCniCncfIoV1().NetworkAttachmentDefinitions("default").Create(context.TODO(), netAttachDef, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	wbClient := fakewbclient.NewSimpleClientset(
+		pool("10.0.0.0-24", "10.0.0.0/24", map[string]whereaboutsv1alpha1.IPAllocation{
+			"1": allocation("default/reserved"),
+			"2": allocation("default/unreserved"),
+			"3": allocation("default/misreserved"),
+			"4": allocation("default/in-flight"),
+			"5": {ContainerID: whereaboutstypes.FloatingIPClaimContainerID("default", "claim"), PodRef: "default/holder"},
+		}),
+		pool("blue-node1-10.1.3.0-24", "10.1.3.0/24", map[string]whereaboutsv1alpha1.IPAllocation{"1": allocation("default/sliced")}),
+		pool("shard-10.4.0.64-26", "10.4.0.64/26", map[string]whereaboutsv1alpha1.IPAllocation{"2": allocation("default/sharded")}),
+		pool("10.2.0.0-24", "10.2.0.0/24", map[string]whereaboutsv1alpha1.IPAllocation{"1": allocation("default/local")}),
+		pool("10.3.0.0-24", "10.3.0.0/24", map[string]whereaboutsv1alpha1.IPAllocation{"5": allocation("default/narrow")}),
+		pool("10.3.0.0-23", "10.3.0.0/23", map[string]whereaboutsv1alpha1.IPAllocation{"5": allocation("default/wide")}),
+		reservation("10.0.0.1", "default/reserved", metav1.Time{}),
+		reservation("10.0.0.3", "default/gone", metav1.Time{}),
+		reservation("10.0.0.4", "default/racing", metav1.Now()))
+	client := NewKubernetesClientWithNetAttachDefs(wbClient, fakek8sclient.NewSimpleClientset(), nadClient)
+
+	expectedDrifts := map[string]string{
+		"10.0.0.0-24/10.0.0.2":            ReservationMissing,
+		"10.0.0.0-24/10.0.0.3":            ReservationMismatched,
+		"10.0.0.0-24/10.0.0.5":            ReservationMissing,
+		"blue-node1-10.1.3.0-24/10.1.3.1": ReservationMissing,
+		"shard-10.4.0.64-26/10.4.0.66":    ReservationMissing,
+		"10.3.0.0-24/10.3.0.5":            ReservationConflicting,
+		"10.3.0.0-23/10.3.0.5":            ReservationConflicting,
+	}
+	driftsOf := func(drifts []ReservationDrift, repaired bool) map[string]string {
+		found := map[string]string{}
+		for _, drift := range drifts {
+			found[drift.Pool+"/"+drift.IP] = drift.Drift
+			if drift.Repaired != (repaired && drift.Drift != ReservationConflicting) {
+				t.Errorf("Expected the drift %+v to be repaired: %v", drift, repaired)
+			}
+		}
+		return found
+	}
+
+	drifts, err := client.VerifyOverlappingReservations(context.TODO(), true)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if found := driftsOf(drifts, false); !reflect.DeepEqual(found, expectedDrifts) {
+		t.Errorf("Expected the drifts %v, got %v", expectedDrifts, found)
+	}
+	if _, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).Get(context.TODO(), "10.0.0.2", metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected the dry run to leave the reservations as they are")
+	}
+
+	drifts, err = client.VerifyOverlappingReservations(context.TODO(), false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if found := driftsOf(drifts, true); !reflect.DeepEqual(found, expectedDrifts) {
+		t.Errorf("Expected the drifts %v, got %v", expectedDrifts, found)
+	}
+	for name, podRef := range map[string]string{
+		"10.0.0.2":      "default/unreserved",
+		"10.0.0.3":      "default/misreserved",
+		"10.0.0.4":      "default/racing",
+		"10.0.0.5":      "default/holder",
+		"blue-10.1.3.1": "default/sliced",
+		"10.4.0.66":     "default/sharded",
+	} {
+		reservation, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil || reservation.Spec.PodRef != podRef {
+			t.Errorf("Expected %s to be reserved for pod %s, got %v, %v", name, podRef, reservation, err)
+			continue
+		}
+		if name == "10.0.0.3" && (reservation.Spec.ContainerID != podRef+"-container" || reservation.GetLabels()[ContainerIDLabel] != podRef+"-container") {
+			t.Errorf("Expected the reservation to be rewritten after the allocation, got %+v", reservation)
+		}
+	}
+	for _, name := range []string{"10.2.0.1", "10.3.0.5"} {
+		if _, err := wbClient.WhereaboutsV1alpha1().OverlappingRangeIPReservations(namespace).Get(context.TODO(), name, metav1.GetOptions{}); err == nil {
+			t.Errorf("Expected %s to be left unreserved", name)
+		}
+	}
+
+	drifts, err = client.VerifyOverlappingReservations(context.TODO(), false)
+	if err != nil || len(drifts) != 2 {
+		t.Errorf("Expected only the conflicting allocations to be left, got %+v, %v", drifts, err)
+	}
+}
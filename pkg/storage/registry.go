@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// DefaultDatastore is the datastore of the network configurations not setting the datastore parameter
+const DefaultDatastore = "kubernetes"
+
+const removedEtcdDatastore = "etcd"
+
+// Factory opens the Store of an invocation, managing the IPs of the interface of the container
+type Factory func(containerID, ifName string, ipamConf types.IPAMConfig) (Store, error)
+
+var (
+	factoriesLock sync.RWMutex
+	factories     = map[string]Factory{}
+)
+
+// Register makes the backend of the factory selectable by the datastore parameter of the network configurations under
+// the given name. It is meant to be called from the init function of the package of the backend, which the binaries
+// then import, e.g. for a Consul backend:
+//
+//	func init() {
+//		storage.Register("consul", NewConsulStore)
+//	}
+//
+// Registering a name twice panics.
+func Register(name string, factory Factory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	if factory == nil {
+		panic("storage: registering a nil factory for datastore " + name)
+	}
+	if _, registered := factories[name]; registered {
+		panic("storage: datastore " + name + " registered twice")
+	}
+	factories[name] = factory
+}
+
+// Datastores returns the names of the registered backends, sorted
+func Datastores() []string {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewStore opens the Store of the datastore of the configuration, the DefaultDatastore when unset
+func NewStore(containerID, ifName string, ipamConf types.IPAMConfig) (Store, error) {
+	name := DatastoreOf(ipamConf)
+	factoriesLock.RLock()
+	factory, registered := factories[name]
+	factoriesLock.RUnlock()
+	if !registered {
+		return nil, fmt.Errorf("unknown datastore %q, expected one of: %s", name, strings.Join(Datastores(), ", "))
+	}
+	store, err := factory(containerID, ifName, ipamConf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the %s datastore: %w", name, err)
+	}
+	return store, nil
+}
+
+// DatastoreOf returns the datastore of the configuration, the DefaultDatastore when unset. The configurations still
+// selecting the removed etcd datastore, which was ignored since, keep using the DefaultDatastore.
+func DatastoreOf(ipamConf types.IPAMConfig) string {
+	switch ipamConf.Datastore {
+	case "", removedEtcdDatastore:
+		return DefaultDatastore
+	}
+	return ipamConf.Datastore
+}
@@ -1,11 +1,15 @@
+// Package storage defines the interface of the backends storing the IP pools, and the registry they are selected from
+// through the datastore parameter
 package storage
 
 import (
 	"context"
-	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
 	"net"
 	"time"
 
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/api/whereabouts.cni.cncf.io/v1alpha1"
 	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
 )
 
@@ -16,26 +20,85 @@ var (
 	// DatastoreRetries defines how many retries are attempted when updating the Pool
 	DatastoreRetries  = 100
 	PodRefreshRetries = 3
+
+	// OverlappingRangeConflictBackoff paces the allocation retries after losing the race for an IP to a pod
+	// of an overlapping range
+	OverlappingRangeConflictBackoff = wait.Backoff{
+		Duration: 100 * time.Millisecond,
+		Factor:   2.0,
+		Jitter:   0.1,
+		Steps:    5,
+	}
 )
 
+// DatastoreRetryBackoff paces the retries of the pool updates after a conflict: the delay doubles from the base delay
+// up to the max delay, with a jitter spreading the pods which conflicted at once
+func DatastoreRetryBackoff(baseDelay, maxDelay time.Duration) wait.Backoff {
+	return wait.Backoff{
+		Duration: baseDelay,
+		Factor:   2.0,
+		Jitter:   0.5,
+		Steps:    DatastoreRetries,
+		Cap:      maxDelay,
+	}
+}
+
+// DatastoreRetryDelay steps the backoff, returning its next delay clamped to its max delay: the jitter is added past it
+func DatastoreRetryDelay(backoff *wait.Backoff) time.Duration {
+	delay := backoff.Step()
+	if backoff.Cap > 0 && delay > backoff.Cap {
+		return backoff.Cap
+	}
+	return delay
+}
+
 // IPPool is the interface that represents an manageable pool of allocated IPs
 type IPPool interface {
+	// Allocations returns the IPs of the pool allocated when it was read
 	Allocations() []types.IPReservation
+	// Update replaces the allocations of the pool. It fails with a Temporary error when the pool was updated since it
+	// was read, for the allocation to be retried on the pool read anew.
 	Update(ctx context.Context, reservations []types.IPReservation) error
 }
 
-// Store is the interface that wraps the basic IP Allocation methods on the underlying storage backend
+// PoolIdentifier identifies the IP pool of a range
+type PoolIdentifier struct {
+	IpRange     string
+	NetworkName string
+	NodeName    string
+	// Shard tells IpRange is the shard of a range split across several pools, see the pool_shards parameter
+	Shard bool
+}
+
+// Store is the interface that wraps the basic IP Allocation methods on the underlying storage backend, see Register
 type Store interface {
-	GetIPPool(ctx context.Context, ipRange string) (IPPool, error)
+	// GetIPPool returns the IP pool of the range, created empty when it does not exist yet
+	GetIPPool(ctx context.Context, poolIdentifier PoolIdentifier) (IPPool, error)
+	// GetOverlappingRangeStore returns the store of the cluster wide reservations of the IPs, which keep the networks
+	// with enable_overlapping_ranges set from allocating the same IP out of different ranges
 	GetOverlappingRangeStore() (OverlappingRangeStore, error)
+	// Status verifies the backend is reachable, on every ADD and DEL
 	Status(ctx context.Context) error
+	// Close releases the connections to the backend
 	Close() error
 }
 
 // OverlappingRangeStore is an interface for wrapping overlappingrange storage options
 type OverlappingRangeStore interface {
+	// GetOverlappingRangeIPReservation returns the cluster wide reservation of the IP, nil when it is not reserved
 	GetOverlappingRangeIPReservation(ctx context.Context, ip net.IP, podRef, networkName string) (*v1alpha1.OverlappingRangeIPReservation, error)
-	UpdateOverlappingRangeAllocation(ctx context.Context, mode int, ip net.IP, podRef, ifName, networkName string) error
+	// UpdateOverlappingRangeAllocation reserves the IP cluster wide, or releases it, depending on the mode. Reserving
+	// an IP already reserved fails.
+	UpdateOverlappingRangeAllocation(ctx context.Context, mode int, ip net.IP, containerID, podRef, ifName, networkName, ipRange string) error
+}
+
+// Locker is implemented by the stores serializing the IP management of the invocations, e.g. through a lock of the
+// backend. The invocations using a store which does not are serialized by the Temporary errors of IPPool.Update only.
+type Locker interface {
+	// Lock blocks until the invocation holds the lock, or the context is done
+	Lock(ctx context.Context) error
+	// Unlock releases the lock
+	Unlock(ctx context.Context) error
 }
 
 type Temporary interface {
@@ -0,0 +1,268 @@
+// Package tracing records the spans of the stages of the allocation path - the leader election, and the reads and
+// updates of the IP pools and of the overlapping range reservations - and exports them to an OpenTelemetry collector
+// through OTLP over HTTP, in its JSON encoding. The spans are only recorded when the context carries a tracer, see
+// Trace: the functions of the package are no-ops otherwise.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ServiceName is the service the spans are reported under
+	ServiceName = "whereabouts"
+
+	// ExportTimeout bounds the export of the spans of an invocation, sparing it a collector which does not answer
+	ExportTimeout = time.Second
+
+	tracesPath      = "/v1/traces"
+	jsonContentType = "application/json"
+
+	spanKindInternal = 1
+	statusCodeOK     = 1
+	statusCodeError  = 2
+)
+
+// Attribute is a key and string value describing a span
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// String returns the attribute of the key and value
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int returns the attribute of the key and integer value
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: strconv.Itoa(value)}
+}
+
+// Tracer collects the ended spans of a trace until they are exported
+type Tracer struct {
+	endpoint string
+	client   *http.Client
+	traceID  string
+
+	lock  sync.Mutex
+	spans []*Span
+}
+
+// NewTracer returns a tracer of a new trace, exporting its spans to the OTLP over HTTP endpoint of the collector, e.g.
+// http://otel-collector.monitoring:4318
+func NewTracer(endpoint string) *Tracer {
+	return &Tracer{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: ExportTimeout},
+		traceID:  randomID(16),
+	}
+}
+
+// Span is a timed stage of a trace. The methods of a nil span, the one started without tracer, are no-ops.
+type Span struct {
+	tracer     *Tracer
+	name       string
+	spanID     string
+	parentID   string
+	start      time.Time
+	end        time.Time
+	attributes []Attribute
+	err        error
+}
+
+type tracerKey struct{}
+
+type spanKey struct{}
+
+// WithTracer returns a copy of the context carrying the tracer: the spans started from it are recorded
+func WithTracer(ctx context.Context, tracer *Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, tracer)
+}
+
+// Start starts a span, child of the span of the context if any, and returns a copy of the context carrying it. The
+// span is nil when the context carries no tracer.
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	tracer, _ := ctx.Value(tracerKey{}).(*Tracer)
+	if tracer == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		tracer:     tracer,
+		name:       name,
+		spanID:     randomID(8),
+		start:      time.Now(),
+		attributes: attributes,
+	}
+	if parent, _ := ctx.Value(spanKey{}).(*Span); parent != nil {
+		span.parentID = parent.spanID
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SetAttributes adds the attributes to the span
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.attributes = append(s.attributes, attributes...)
+}
+
+// End ends the span, failed when err is set, and records it for export
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	s.tracer.lock.Lock()
+	s.tracer.spans = append(s.tracer.spans, s)
+	s.tracer.lock.Unlock()
+}
+
+// Trace roots the spans of an invocation in a span of the given name, when the endpoint of a collector is set. The
+// returned function ends the root span, and exports the spans of the invocation; it never fails the invocation, the
+// export errors being returned for logging only.
+func Trace(ctx context.Context, endpoint, name string, attributes ...Attribute) (context.Context, func(err error) error) {
+	if endpoint == "" {
+		return ctx, func(error) error { return nil }
+	}
+	tracer := NewTracer(endpoint)
+	ctx, span := Start(WithTracer(ctx, tracer), name, attributes...)
+	return ctx, func(err error) error {
+		span.End(err)
+		exportCtx, cancel := context.WithTimeout(context.Background(), ExportTimeout)
+		defer cancel()
+		return tracer.Export(exportCtx)
+	}
+}
+
+// Export sends the ended spans to the collector, and forgets them
+func (t *Tracer) Export(ctx context.Context) error {
+	t.lock.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.lock.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(t.request(spans))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint+tracesPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", jsonContentType)
+	response, err := t.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to export the spans to %s: %w", t.endpoint, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to export the spans to %s: %s", t.endpoint, response.Status)
+	}
+	return nil
+}
+
+// the OTLP JSON encoding of an export request, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanData `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type spanData struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            status     `json:"status"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func (t *Tracer) request(spans []*Span) exportRequest {
+	data := make([]spanData, 0, len(spans))
+	for _, span := range spans {
+		spanStatus := status{Code: statusCodeOK}
+		if span.err != nil {
+			spanStatus = status{Code: statusCodeError, Message: span.err.Error()}
+		}
+		data = append(data, spanData{
+			TraceID:           t.traceID,
+			SpanID:            span.spanID,
+			ParentSpanID:      span.parentID,
+			Name:              span.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+			Attributes:        keyValues(span.attributes),
+			Status:            spanStatus,
+		})
+	}
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: keyValues([]Attribute{String("service.name", ServiceName)})},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: ServiceName}, Spans: data}},
+	}}}
+}
+
+func keyValues(attributes []Attribute) []keyValue {
+	var values []keyValue
+	for _, attribute := range attributes {
+		values = append(values, keyValue{Key: attribute.Key, Value: anyValue{StringValue: attribute.Value}})
+	}
+	return values
+}
+
+// randomID returns the hex encoding of size random bytes, as the trace and span IDs are encoded in OTLP JSON
+func randomID(size int) string {
+	id := make([]byte, size)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
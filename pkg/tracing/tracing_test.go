@@ -0,0 +1,88 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func collector(t *testing.T, requests chan<- exportRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != tracesPath || r.Header.Get("Content-Type") != jsonContentType {
+			t.Errorf("Expected a JSON export to %s, got %s %s", tracesPath, r.Header.Get("Content-Type"), r.URL.Path)
+		}
+		request := exportRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("Expected an export request, got %v", err)
+		}
+		requests <- request
+	}))
+}
+
+func TestTrace(t *testing.T) {
+	requests := make(chan exportRequest, 1)
+	server := collector(t, requests)
+	defer server.Close()
+
+	ctx, finish := Trace(context.TODO(), server.URL+"/", "ipam.allocate", String("pod", "default/pod"))
+	stageCtx, stage := Start(ctx, "ippool.update", Int("attempt", 1))
+	_, call := Start(stageCtx, "ippool.get")
+	call.End(nil)
+	stage.End(errors.New("conflict"))
+	if err := finish(nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	request := <-requests
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Expected the spans of a single resource and scope, got %+v", request)
+	}
+	if attributes := request.ResourceSpans[0].Resource.Attributes; len(attributes) != 1 || attributes[0].Value.StringValue != ServiceName {
+		t.Errorf("Expected the spans of service %s, got %+v", ServiceName, attributes)
+	}
+	spans := map[string]spanData{}
+	for _, span := range request.ResourceSpans[0].ScopeSpans[0].Spans {
+		spans[span.Name] = span
+	}
+	root, update, get := spans["ipam.allocate"], spans["ippool.update"], spans["ippool.get"]
+	if len(spans) != 3 || root.ParentSpanID != "" || update.ParentSpanID != root.SpanID || get.ParentSpanID != update.SpanID {
+		t.Fatalf("Expected the nested spans of the trace, got %+v", spans)
+	}
+	if update.TraceID != root.TraceID || len(root.TraceID) != 32 || len(root.SpanID) != 16 {
+		t.Errorf("Expected the spans of a single trace, got %+v", spans)
+	}
+	if update.Status != (status{Code: statusCodeError, Message: "conflict"}) || root.Status.Code != statusCodeOK {
+		t.Errorf("Expected the failed stage, got %+v", spans)
+	}
+	if len(update.Attributes) != 1 || update.Attributes[0] != (keyValue{Key: "attempt", Value: anyValue{StringValue: "1"}}) {
+		t.Errorf("Expected the attributes of the stage, got %+v", update.Attributes)
+	}
+}
+
+func TestTraceWithoutEndpoint(t *testing.T) {
+	ctx, finish := Trace(context.TODO(), "", "ipam.allocate")
+	_, span := Start(ctx, "ippool.get")
+	if span != nil {
+		t.Errorf("Expected no span without tracer, got %+v", span)
+	}
+	span.SetAttributes(String("pool", "10.0.0.0-24"))
+	span.End(nil)
+	if err := finish(nil); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestTraceCollectorFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, finish := Trace(context.TODO(), server.URL, "ipam.deallocate")
+	if err := finish(nil); err == nil {
+		t.Error("Expected the export to fail")
+	}
+}
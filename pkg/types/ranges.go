@@ -0,0 +1,188 @@
+package types
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	netutils "k8s.io/utils/net"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
+)
+
+// Normalize turns the range into its canonical form: the network in CIDR notation, along with the first and last
+// usable IPs of the range, honoring range_start and range_end. The range may be set as "<range start>-<CIDR>" too,
+// e.g. "192.168.2.225-192.168.2.230/28", the IP of the CIDR being the range end. This is the single place ranges are
+// validated: the IPs of a normalized range are never re-parsed. The exclusions of the range, CIDRs or single IPs, must
+// be of its IP family.
+func (r *RangeConfiguration) Normalize() error {
+	if len(r.RangeSet) > 0 {
+		return r.normalizeRangeSet()
+	}
+
+	if parts := strings.SplitN(r.Range, "-", 2); len(parts) == 2 {
+		firstIP := netutils.ParseIPSloppy(parts[0])
+		if firstIP == nil {
+			return fmt.Errorf("invalid range start IP: %s", parts[0])
+		}
+		lastIP, ipNet, err := netutils.ParseCIDRSloppy(parts[1])
+		if err != nil {
+			return fmt.Errorf("invalid CIDR (do you have the 'range' parameter set for Whereabouts?) '%s': %s", parts[1], err)
+		}
+		if !ipNet.Contains(firstIP) {
+			return fmt.Errorf("invalid range start for CIDR %s: %s", ipNet.String(), firstIP)
+		}
+		r.Range = ipNet.String()
+		r.RangeStart = firstIP
+		r.RangeEnd = lastIP
+	}
+
+	_, ipNet, err := netutils.ParseCIDRSloppy(r.Range)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR %s: %s", r.Range, err)
+	}
+	if r.RangeStart != nil && !ipNet.Contains(r.RangeStart) {
+		return fmt.Errorf("invalid range start for CIDR %s: %s", ipNet.String(), r.RangeStart)
+	}
+	if r.RangeEnd != nil && !ipNet.Contains(r.RangeEnd) {
+		return fmt.Errorf("invalid range end for CIDR %s: %s", ipNet.String(), r.RangeEnd)
+	}
+	if r.RangeStart != nil && r.RangeEnd != nil && iphelpers.CompareIPs(r.RangeStart, r.RangeEnd) > 0 {
+		return fmt.Errorf("invalid range %s: range start %s is after range end %s", ipNet.String(), r.RangeStart, r.RangeEnd)
+	}
+	firstIP, lastIP, err := iphelpers.GetIPRange(*ipNet, r.RangeStart, r.RangeEnd)
+	if err != nil {
+		return fmt.Errorf("invalid range %s: %s", ipNet.String(), err)
+	}
+	for _, omitRange := range r.OmitRanges {
+		excluded, err := iphelpers.ParseExcludedRange(omitRange)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR in exclude list %s: %s", omitRange, err)
+		}
+		if iphelpers.IsIPv4(excluded.IP) != iphelpers.IsIPv4(ipNet.IP) {
+			return fmt.Errorf("invalid exclude %s for range %s: the IP families differ", omitRange, ipNet.String())
+		}
+	}
+
+	r.Range = ipNet.String()
+	r.RangeStart = firstIP.To16()
+	r.RangeEnd = lastIP.To16()
+	r.network = ipNet
+	return nil
+}
+
+// normalizeRangeSet turns the CIDRs of the range set into their canonical form, making sure they are of the same IP
+// family and listed in ascending order without overlapping. The range start, when set, must belong to the first CIDR
+// and the range end to the last one. The range is that of the first CIDR, which names the IP pool of the range set.
+func (r *RangeConfiguration) normalizeRangeSet() error {
+	var firstNet, lastNet *net.IPNet
+	for idx, cidr := range r.RangeSet {
+		_, ipNet, err := netutils.ParseCIDRSloppy(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR in range set %s: %s", cidr, err)
+		}
+		if lastNet != nil {
+			if iphelpers.IsIPv4(ipNet.IP) != iphelpers.IsIPv4(lastNet.IP) {
+				return fmt.Errorf("invalid range set %v: the IP families of %s and %s differ", r.RangeSet, lastNet, ipNet)
+			}
+			if iphelpers.CompareIPs(ipNet.IP, iphelpers.SubnetBroadcastIP(*lastNet)) <= 0 {
+				return fmt.Errorf("invalid range set %v: %s must follow %s without overlapping it", r.RangeSet, ipNet, lastNet)
+			}
+		} else {
+			firstNet = ipNet
+		}
+		r.RangeSet[idx] = ipNet.String()
+		lastNet = ipNet
+	}
+	if r.Range != "" && r.Range != firstNet.String() {
+		return fmt.Errorf("invalid range set %v: range %s cannot be set along with it", r.RangeSet, r.Range)
+	}
+	// the allocations of the IP pool are keyed by their offset from the first IP of the range set
+	lastIP := iphelpers.SubnetBroadcastIP(*lastNet)
+	if offset, err := iphelpers.IPGetOffset(lastIP, firstNet.IP); err != nil || !iphelpers.IPAddOffset(firstNet.IP, offset).Equal(lastIP) {
+		return fmt.Errorf("invalid range set %v: it spans too many addresses", r.RangeSet)
+	}
+
+	subranges := r.Subranges()
+	for idx := range subranges {
+		if err := subranges[idx].Normalize(); err != nil {
+			return err
+		}
+	}
+	r.Range = firstNet.String()
+	r.RangeStart = subranges[0].RangeStart
+	r.RangeEnd = subranges[len(subranges)-1].RangeEnd
+	r.network = firstNet
+	return nil
+}
+
+// Subranges returns a range per CIDR of the range set, in the order they are allocated from, sharing the exclusions
+// and allocation strategy of the range set: the range start applies to the first of them, and the range end to the
+// last one. A range which is not a range set is its own single subrange.
+func (r RangeConfiguration) Subranges() []RangeConfiguration {
+	if len(r.RangeSet) == 0 {
+		return []RangeConfiguration{r}
+	}
+	subranges := make([]RangeConfiguration, 0, len(r.RangeSet))
+	for idx, cidr := range r.RangeSet {
+		subrange := RangeConfiguration{Range: cidr, OmitRanges: r.OmitRanges, AllocationStrategy: r.AllocationStrategy}
+		if idx == 0 {
+			subrange.RangeStart = r.RangeStart
+		}
+		if idx == len(r.RangeSet)-1 {
+			subrange.RangeEnd = r.RangeEnd
+		}
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			subrange.network = ipNet
+		}
+		subranges = append(subranges, subrange)
+	}
+	return subranges
+}
+
+// Subrange returns the subrange the IP belongs to, if any
+func (r RangeConfiguration) Subrange(ip net.IP) (RangeConfiguration, bool) {
+	for _, subrange := range r.Subranges() {
+		if ipNet, err := subrange.Network(); err == nil && ipNet.Contains(ip) {
+			return subrange, true
+		}
+	}
+	return RangeConfiguration{}, false
+}
+
+// Contains tells whether the IP belongs to the network of the range, or to any of those of the range set
+func (r RangeConfiguration) Contains(ip net.IP) bool {
+	_, contained := r.Subrange(ip)
+	return contained
+}
+
+// Network returns the network of the range. Ranges built rather than loaded from a configuration, e.g. the node
+// slices, are parsed on the fly.
+func (r RangeConfiguration) Network() (*net.IPNet, error) {
+	if r.network != nil {
+		return r.network, nil
+	}
+	_, ipNet, err := net.ParseCIDR(r.Range)
+	return ipNet, err
+}
+
+// UsableRange returns the first and last IPs of the range which can be assigned
+func (r RangeConfiguration) UsableRange() (net.IP, net.IP, error) {
+	if len(r.RangeSet) > 0 {
+		subranges := r.Subranges()
+		firstIP, _, err := subranges[0].UsableRange()
+		if err != nil {
+			return nil, nil, err
+		}
+		_, lastIP, err := subranges[len(subranges)-1].UsableRange()
+		if err != nil {
+			return nil, nil, err
+		}
+		return firstIP, lastIP, nil
+	}
+	ipNet, err := r.Network()
+	if err != nil {
+		return nil, nil, err
+	}
+	return iphelpers.GetIPRange(*ipNet, r.RangeStart, r.RangeEnd)
+}
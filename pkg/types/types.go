@@ -4,9 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	cnitypes "github.com/containernetworking/cni/pkg/types"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
 )
 
 // Datastore types
@@ -16,8 +20,92 @@ const (
 	DefaultLeaderRetryPeriod      = 500
 	AddTimeLimit                  = 2 * time.Minute
 	DelTimeLimit                  = 1 * time.Minute
+	CheckTimeLimit                = 1 * time.Minute
 	DefaultOverlappingIPsFeatures = true
 	DefaultSleepForRace           = 0
+	DefaultBackoffBaseMs          = 50
+	DefaultBackoffMaxMs           = 2000
+)
+
+// DefaultLeaderFallbackConflicts is the number of conflicting IP pool updates after which the IP management without
+// leader election falls back to it
+const DefaultLeaderFallbackConflicts = 3
+
+// Orders of the IPs in the CNI result
+const (
+	ResultOrderV4First = "v4-first"
+	ResultOrderV6First = "v6-first"
+)
+
+// Allocation strategies, picking which free IP of a range is assigned
+const (
+	SequentialAllocation = "sequential"
+	RandomAllocation     = "random"
+	LRUAllocation        = "lru"
+	// RandomIIDAllocation assigns the IPs of cryptographically random interface identifiers. It is the strategy of the
+	// IPv6 ranges of the configurations in the random-iid IPv6 address mode, not an allocation_strategy of its own.
+	RandomIIDAllocation = "random-iid"
+)
+
+// IPv6AddressModeRandomIID assigns the IPs of the IPv6 ranges random interface identifiers, rather than the low,
+// sequential ones the scans of anti-scanning middleboxes probe first
+const IPv6AddressModeRandomIID = "random-iid"
+
+// Reservation keys, telling which pod interface an allocation is reused by
+const (
+	// ReservationKeyPod reuses the allocation of a pod interface for the pod of the same reference
+	ReservationKeyPod = "pod"
+	// ReservationKeyMAC reuses the allocation of a pod interface for the interface of the same MAC address, e.g. that
+	// of a virtual machine whose pod was recreated
+	ReservationKeyMAC = "mac"
+)
+
+// GatewayStrategyPerNodeSliceFirstIP sets the gateway of the IPs allocated from node slices to the first usable IP of
+// their node's slice, rather than to the gateway of the configuration
+const GatewayStrategyPerNodeSliceFirstIP = "per-node-slice-first-ip"
+
+// preReservationPrefix prefixes the container ID of the allocations pre-reserved for pods bound to a node, before
+// their sandbox exists
+const preReservationPrefix = "prereservation/"
+
+// PreReservationContainerID is the container ID of the allocations pre-reserved for the pod of the UID. The CNI ADD of
+// the pod interface claims its allocation, recording its own container ID in place of this one.
+func PreReservationContainerID(podUID string) string {
+	return preReservationPrefix + podUID
+}
+
+// IsPreReservation tells whether the container ID is the one of an allocation pre-reserved for a pod not started yet
+func IsPreReservation(containerID string) bool {
+	return strings.HasPrefix(containerID, preReservationPrefix)
+}
+
+// floatingIPClaimPrefix prefixes the container ID of the IP pool allocations of the floating IP claims
+const floatingIPClaimPrefix = "floatingipclaim/"
+
+// FloatingIPClaimContainerID is the container ID of the IP pool allocation of the floating IP claim
+func FloatingIPClaimContainerID(namespace, name string) string {
+	return floatingIPClaimPrefix + namespace + "/" + name
+}
+
+// IsFloatingIPClaim tells whether the container ID is the one of the allocation of a floating IP claim. These
+// allocations outlive the pods holding the claims: they are only released along with the claims, never garbage
+// collected along with the pods, nor reused by the CNI ADD of the pod holding the claim.
+func IsFloatingIPClaim(containerID string) bool {
+	return strings.HasPrefix(containerID, floatingIPClaimPrefix)
+}
+
+// VerifyUnusedICMP verifies that nothing answers the ICMP echo requests sent to an IP before assigning it
+const VerifyUnusedICMP = "icmp"
+
+// DefaultVerifyUnusedTimeout is how long the reply to the ICMP echo request sent to an IP is awaited, before deeming it
+// unused, unless the verify_unused_timeout of the network configuration tells otherwise
+const DefaultVerifyUnusedTimeout = 500
+
+// Address family policies, asserting the families of the ipRanges at configuration load time
+const (
+	AddressFamilyPolicyRequireDualStack = "require_dual_stack"
+	AddressFamilyPolicyV4Only           = "v4_only"
+	AddressFamilyPolicyV6Only           = "v6_only"
 )
 
 // Net is The top-level network config - IPAM plugins are passed the full configuration
@@ -26,6 +114,18 @@ type Net struct {
 	Name       string      `json:"name"`
 	CNIVersion string      `json:"cniVersion"`
 	IPAM       *IPAMConfig `json:"ipam"`
+	Args       *NetArgs    `json:"args,omitempty"`
+}
+
+// NetArgs are the arguments passed along with the network config, see the CNI conventions
+type NetArgs struct {
+	CNI *CNIArgs `json:"cni,omitempty"`
+}
+
+// CNIArgs are the well-known arguments of the network config
+type CNIArgs struct {
+	// IPs are the IPs requested for the interface, with or without their prefix length
+	IPs []string `json:"ips,omitempty"`
 }
 
 // NetConfList describes an ordered list of networks.
@@ -42,6 +142,16 @@ type RangeConfiguration struct {
 	Range      string   `json:"range"`
 	RangeStart net.IP   `json:"range_start,omitempty"`
 	RangeEnd   net.IP   `json:"range_end,omitempty"`
+	// NodeSliceSize overrides the node_slice_size of the configuration for this range, e.g. for the IPv6 range of a
+	// dual-stack network
+	NodeSliceSize string `json:"node_slice_size,omitempty"`
+	// AllocationStrategy overrides the allocation_strategy of the configuration for this range
+	AllocationStrategy string `json:"allocation_strategy,omitempty"`
+	// RangeSet are the CIDRs of a range spanning non-contiguous networks, in ascending order, allocated from in order
+	// as a single pool. Range is set to the first of them by Normalize.
+	RangeSet []string `json:"range_set,omitempty"`
+	// network is the parsed Range, set by Normalize
+	network *net.IPNet
 }
 
 // IPAMConfig describes the expected json configuration for this plugin
@@ -49,29 +159,62 @@ type IPAMConfig struct {
 	Name                     string
 	Type                     string               `json:"type"`
 	Routes                   []*cnitypes.Route    `json:"routes"`
+	Datastore                string               `json:"datastore,omitempty"`
 	Addresses                []Address            `json:"addresses,omitempty"`
 	IPRanges                 []RangeConfiguration `json:"ipRanges"`
 	OmitRanges               []string             `json:"exclude,omitempty"`
 	DNS                      cnitypes.DNS         `json:"dns"`
 	Range                    string               `json:"range"`
+	RangeSet                 []string             `json:"range_set,omitempty"`
 	NodeSliceSize            string               `json:"node_slice_size"`
+	PoolShards               int                  `json:"pool_shards,omitempty"`
+	AllocationStrategy       string               `json:"allocation_strategy,omitempty"`
+	VerifyUnused             string               `json:"verify_unused,omitempty"`
+	VerifyUnusedQuarantine   int                  `json:"verify_unused_quarantine,omitempty"`
+	VerifyUnusedTimeout      int                  `json:"verify_unused_timeout,omitempty"`
 	RangeStart               net.IP               `json:"range_start,omitempty"`
 	RangeEnd                 net.IP               `json:"range_end,omitempty"`
 	GatewayStr               string               `json:"gateway"`
 	LeaderLeaseDuration      int                  `json:"leader_lease_duration,omitempty"`
 	LeaderRenewDeadline      int                  `json:"leader_renew_deadline,omitempty"`
 	LeaderRetryPeriod        int                  `json:"leader_retry_period,omitempty"`
+	NoLeaderElection         bool                 `json:"no_leader_election,omitempty"`
+	LeaderFallbackConflicts  int                  `json:"leader_fallback_conflicts,omitempty"`
 	LogFile                  string               `json:"log_file"`
 	LogLevel                 string               `json:"log_level"`
+	LogFormat                string               `json:"log_format,omitempty"`
 	ReconcilerCronExpression string               `json:"reconciler_cron_expression,omitempty"`
 	OverlappingRanges        bool                 `json:"enable_overlapping_ranges,omitempty"`
 	SleepForRace             int                  `json:"sleep_for_race,omitempty"`
+	BackoffBaseMs            int                  `json:"backoff_base_ms,omitempty"`
+	BackoffMaxMs             int                  `json:"backoff_max_ms,omitempty"`
+	AllocationTimeout        int                  `json:"allocation_timeout,omitempty"`
+	SeedOverlappingIPs       bool                 `json:"seed_overlapping_ips,omitempty"`
+	ServiceReservations      []string             `json:"service_reservations,omitempty"`
+	ResultOrder              string               `json:"result_order,omitempty"`
+	ForeignRanges            []string             `json:"foreign_ranges,omitempty"`
+	DaemonSocket             string               `json:"daemon_socket,omitempty"`
+	AllocationAPI            bool                 `json:"allocation_api,omitempty"`
+	AddressFamilyPolicy      string               `json:"address_family_policy,omitempty"`
+	RequirePodUIDMatch       bool                 `json:"require_pod_uid_match,omitempty"`
+	EnableStickyIPs          bool                 `json:"enable_sticky_ips,omitempty"`
+	StrictRangeCheck         bool                 `json:"strict_range_check,omitempty"`
+	PoolNamespace            string               `json:"pool_namespace,omitempty"`
+	IPv6AddressMode          string               `json:"ipv6_address_mode,omitempty"`
+	GatewayStrategy          string               `json:"gateway_strategy,omitempty"`
+	ReservationKey           string               `json:"reservation_key,omitempty"`
+	TracingEndpoint          string               `json:"tracing_endpoint,omitempty"`
+	NodeUnreadyGracePeriod   string               `json:"node_unready_grace_period,omitempty"`
 	Gateway                  net.IP
 	Kubernetes               KubernetesConfig `json:"kubernetes,omitempty"`
+	Etcd3                    Etcd3Config      `json:"etcd3,omitempty"`
 	ConfigurationPath        string           `json:"configuration_path"`
 	PodName                  string
 	PodNamespace             string
-	NetworkName              string `json:"network_name,omitempty"`
+	PodUID                   string
+	RequestedIPs             []net.IP `json:"-"`
+	MAC                      string   `json:"-"`
+	NetworkName              string   `json:"network_name,omitempty"`
 }
 
 func (ic *IPAMConfig) UnmarshalJSON(data []byte) error {
@@ -83,9 +226,15 @@ func (ic *IPAMConfig) UnmarshalJSON(data []byte) error {
 		Addresses                []Address            `json:"addresses,omitempty"`
 		IPRanges                 []RangeConfiguration `json:"ipRanges"`
 		NodeSliceSize            string               `json:"node_slice_size"`
+		PoolShards               int                  `json:"pool_shards,omitempty"`
+		AllocationStrategy       string               `json:"allocation_strategy,omitempty"`
+		VerifyUnused             string               `json:"verify_unused,omitempty"`
+		VerifyUnusedQuarantine   int                  `json:"verify_unused_quarantine,omitempty"`
+		VerifyUnusedTimeout      int                  `json:"verify_unused_timeout,omitempty"`
 		OmitRanges               []string             `json:"exclude,omitempty"`
 		DNS                      cnitypes.DNS         `json:"dns"`
 		Range                    string               `json:"range"`
+		RangeSet                 []string             `json:"range_set,omitempty"`
 		RangeStart               string               `json:"range_start,omitempty"`
 		RangeEnd                 string               `json:"range_end,omitempty"`
 		GatewayStr               string               `json:"gateway"`
@@ -98,13 +247,36 @@ func (ic *IPAMConfig) UnmarshalJSON(data []byte) error {
 		LeaderLeaseDuration      int                  `json:"leader_lease_duration,omitempty"`
 		LeaderRenewDeadline      int                  `json:"leader_renew_deadline,omitempty"`
 		LeaderRetryPeriod        int                  `json:"leader_retry_period,omitempty"`
+		NoLeaderElection         bool                 `json:"no_leader_election,omitempty"`
+		LeaderFallbackConflicts  int                  `json:"leader_fallback_conflicts,omitempty"`
 		LogFile                  string               `json:"log_file"`
 		LogLevel                 string               `json:"log_level"`
+		LogFormat                string               `json:"log_format,omitempty"`
 		ReconcilerCronExpression string               `json:"reconciler_cron_expression,omitempty"`
 		OverlappingRanges        bool                 `json:"enable_overlapping_ranges,omitempty"`
 		SleepForRace             int                  `json:"sleep_for_race,omitempty"`
+		BackoffBaseMs            int                  `json:"backoff_base_ms,omitempty"`
+		BackoffMaxMs             int                  `json:"backoff_max_ms,omitempty"`
+		AllocationTimeout        int                  `json:"allocation_timeout,omitempty"`
+		SeedOverlappingIPs       bool                 `json:"seed_overlapping_ips,omitempty"`
+		ServiceReservations      []string             `json:"service_reservations,omitempty"`
+		ResultOrder              string               `json:"result_order,omitempty"`
+		ForeignRanges            []string             `json:"foreign_ranges,omitempty"`
+		DaemonSocket             string               `json:"daemon_socket,omitempty"`
+		AllocationAPI            bool                 `json:"allocation_api,omitempty"`
+		AddressFamilyPolicy      string               `json:"address_family_policy,omitempty"`
+		RequirePodUIDMatch       bool                 `json:"require_pod_uid_match,omitempty"`
+		EnableStickyIPs          bool                 `json:"enable_sticky_ips,omitempty"`
+		StrictRangeCheck         bool                 `json:"strict_range_check,omitempty"`
+		PoolNamespace            string               `json:"pool_namespace,omitempty"`
+		IPv6AddressMode          string               `json:"ipv6_address_mode,omitempty"`
+		GatewayStrategy          string               `json:"gateway_strategy,omitempty"`
+		ReservationKey           string               `json:"reservation_key,omitempty"`
+		TracingEndpoint          string               `json:"tracing_endpoint,omitempty"`
+		NodeUnreadyGracePeriod   string               `json:"node_unready_grace_period,omitempty"`
 		Gateway                  string
 		Kubernetes               KubernetesConfig `json:"kubernetes,omitempty"`
+		Etcd3                    Etcd3Config      `json:"etcd3,omitempty"`
 		ConfigurationPath        string           `json:"configuration_path"`
 		PodName                  string
 		PodNamespace             string
@@ -123,25 +295,55 @@ func (ic *IPAMConfig) UnmarshalJSON(data []byte) error {
 		Name:                     ipamConfigAlias.Name,
 		Type:                     ipamConfigAlias.Type,
 		Routes:                   ipamConfigAlias.Routes,
+		Datastore:                ipamConfigAlias.Datastore,
 		Addresses:                ipamConfigAlias.Addresses,
 		IPRanges:                 ipamConfigAlias.IPRanges,
 		OmitRanges:               ipamConfigAlias.OmitRanges,
 		DNS:                      ipamConfigAlias.DNS,
 		Range:                    ipamConfigAlias.Range,
+		RangeSet:                 ipamConfigAlias.RangeSet,
 		RangeStart:               backwardsCompatibleIPAddress(ipamConfigAlias.RangeStart),
 		RangeEnd:                 backwardsCompatibleIPAddress(ipamConfigAlias.RangeEnd),
 		NodeSliceSize:            ipamConfigAlias.NodeSliceSize,
+		PoolShards:               ipamConfigAlias.PoolShards,
+		AllocationStrategy:       ipamConfigAlias.AllocationStrategy,
+		VerifyUnused:             ipamConfigAlias.VerifyUnused,
+		VerifyUnusedQuarantine:   ipamConfigAlias.VerifyUnusedQuarantine,
+		VerifyUnusedTimeout:      ipamConfigAlias.VerifyUnusedTimeout,
 		GatewayStr:               ipamConfigAlias.GatewayStr,
 		LeaderLeaseDuration:      ipamConfigAlias.LeaderLeaseDuration,
 		LeaderRenewDeadline:      ipamConfigAlias.LeaderRenewDeadline,
 		LeaderRetryPeriod:        ipamConfigAlias.LeaderRetryPeriod,
+		NoLeaderElection:         ipamConfigAlias.NoLeaderElection,
+		LeaderFallbackConflicts:  ipamConfigAlias.LeaderFallbackConflicts,
 		LogFile:                  ipamConfigAlias.LogFile,
 		LogLevel:                 ipamConfigAlias.LogLevel,
+		LogFormat:                ipamConfigAlias.LogFormat,
 		OverlappingRanges:        ipamConfigAlias.OverlappingRanges,
 		ReconcilerCronExpression: ipamConfigAlias.ReconcilerCronExpression,
 		SleepForRace:             ipamConfigAlias.SleepForRace,
+		BackoffBaseMs:            ipamConfigAlias.BackoffBaseMs,
+		BackoffMaxMs:             ipamConfigAlias.BackoffMaxMs,
+		AllocationTimeout:        ipamConfigAlias.AllocationTimeout,
+		SeedOverlappingIPs:       ipamConfigAlias.SeedOverlappingIPs,
+		ServiceReservations:      ipamConfigAlias.ServiceReservations,
+		ResultOrder:              ipamConfigAlias.ResultOrder,
+		ForeignRanges:            ipamConfigAlias.ForeignRanges,
+		DaemonSocket:             ipamConfigAlias.DaemonSocket,
+		AllocationAPI:            ipamConfigAlias.AllocationAPI,
+		AddressFamilyPolicy:      ipamConfigAlias.AddressFamilyPolicy,
+		RequirePodUIDMatch:       ipamConfigAlias.RequirePodUIDMatch,
+		EnableStickyIPs:          ipamConfigAlias.EnableStickyIPs,
+		StrictRangeCheck:         ipamConfigAlias.StrictRangeCheck,
+		PoolNamespace:            ipamConfigAlias.PoolNamespace,
+		IPv6AddressMode:          ipamConfigAlias.IPv6AddressMode,
+		GatewayStrategy:          ipamConfigAlias.GatewayStrategy,
+		ReservationKey:           ipamConfigAlias.ReservationKey,
+		TracingEndpoint:          ipamConfigAlias.TracingEndpoint,
+		NodeUnreadyGracePeriod:   ipamConfigAlias.NodeUnreadyGracePeriod,
 		Gateway:                  backwardsCompatibleIPAddress(ipamConfigAlias.Gateway),
 		Kubernetes:               ipamConfigAlias.Kubernetes,
+		Etcd3:                    ipamConfigAlias.Etcd3,
 		ConfigurationPath:        ipamConfigAlias.ConfigurationPath,
 		PodName:                  ipamConfigAlias.PodName,
 		PodNamespace:             ipamConfigAlias.PodNamespace,
@@ -154,6 +356,62 @@ func (ic *IPAMConfig) GetPodRef() string {
 	return fmt.Sprintf("%s/%s", ic.PodNamespace, ic.PodName)
 }
 
+// RangeNodeSliceSize returns the size of the node slices of the range: its own node_slice_size, or else that of the
+// configuration
+func (ic *IPAMConfig) RangeNodeSliceSize(ipRange RangeConfiguration) string {
+	if ipRange.NodeSliceSize != "" {
+		return ipRange.NodeSliceSize
+	}
+	return ic.NodeSliceSize
+}
+
+// NodeSliceGateway returns the gateway of an IP allocated from a node slice of the ranges, i.e. the first usable IP of
+// the slice, or nil for the IPs outside the node slices
+func (ic *IPAMConfig) NodeSliceGateway(ip net.IP) net.IP {
+	for _, ipRange := range ic.IPRanges {
+		sliceSize := ic.RangeNodeSliceSize(ipRange)
+		if sliceSize == "" {
+			continue
+		}
+		ipNet, err := ipRange.Network()
+		if err != nil || !ipNet.Contains(ip) {
+			continue
+		}
+		ones, err := strconv.Atoi(strings.TrimPrefix(sliceSize, "/"))
+		if err != nil {
+			return nil
+		}
+		_, bits := ipNet.Mask.Size()
+		sliceMask := net.CIDRMask(ones, bits)
+		gateway, err := iphelpers.FirstUsableIP(net.IPNet{IP: ip.Mask(sliceMask), Mask: sliceMask})
+		if err != nil {
+			return nil
+		}
+		return gateway
+	}
+	return nil
+}
+
+// GatewayOf returns the gateway of an IP allocated from the ranges: with the per-node-slice-first-ip gateway strategy,
+// that of its node slice, or else the gateway of the configuration
+func (ic *IPAMConfig) GatewayOf(ip net.IP) net.IP {
+	if ic.GatewayStrategy == GatewayStrategyPerNodeSliceFirstIP {
+		if gateway := ic.NodeSliceGateway(ip); gateway != nil {
+			return gateway
+		}
+	}
+	return ic.Gateway
+}
+
+// RangeAllocationStrategy returns the allocation strategy of the range: its own allocation_strategy, or else that of
+// the configuration
+func (ic *IPAMConfig) RangeAllocationStrategy(ipRange RangeConfiguration) string {
+	if ipRange.AllocationStrategy != "" {
+		return ipRange.AllocationStrategy
+	}
+	return ic.AllocationStrategy
+}
+
 func backwardsCompatibleIPAddress(ip string) net.IP {
 	var ipAddr net.IP
 	if sanitizedIP, err := sanitizeIP(ip); err == nil {
@@ -170,12 +428,32 @@ type IPAMEnvArgs struct {
 	K8S_POD_NAME               cnitypes.UnmarshallableString //revive:disable-line
 	K8S_POD_NAMESPACE          cnitypes.UnmarshallableString //revive:disable-line
 	K8S_POD_INFRA_CONTAINER_ID cnitypes.UnmarshallableString //revive:disable-line
+	K8S_POD_UID                cnitypes.UnmarshallableString //revive:disable-line
+	MAC                        cnitypes.UnmarshallableString
 }
 
 // KubernetesConfig describes the kubernetes-specific configuration details
 type KubernetesConfig struct {
 	KubeConfigPath string `json:"kubeconfig,omitempty"`
 	K8sAPIRoot     string `json:"k8s_api_root,omitempty"`
+	// TokenFile is a service account token the plugin authenticates with instead of the credentials of the
+	// kubeconfig, read anew as it is refreshed
+	TokenFile string `json:"token_file,omitempty"`
+}
+
+// Etcd3Config describes the connection to the etcd cluster of the etcd3 datastore
+type Etcd3Config struct {
+	// Endpoints are the client URLs of the etcd members, e.g. https://etcd-0:2379, tried in order
+	Endpoints []string `json:"endpoints,omitempty"`
+	// Prefix is the key the IP pools and the reservations are stored under, /whereabouts when empty
+	Prefix   string `json:"prefix,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// CertFile and KeyFile are the client certificate the plugin authenticates with, and its private key
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// CACertFile is the CA bundle verifying the certificates of the etcd members
+	CACertFile string `json:"ca_cert_file,omitempty"`
 }
 
 // Address is our standard address.
@@ -191,8 +469,12 @@ type IPReservation struct {
 	IP          net.IP `json:"ip"`
 	ContainerID string `json:"id"`
 	PodRef      string `json:"podref"`
+	PodUID      string `json:"podUID,omitempty"`
 	IfName      string `json:"ifName"`
+	MAC         string `json:"mac,omitempty"`
 	IsAllocated bool
+	// AllocatedAt is the time the IP was allocated to the pod of PodUID, zero when unknown
+	AllocatedAt time.Time
 }
 
 func (ir IPReservation) String() string {
@@ -204,4 +486,19 @@ const (
 	Allocate = 0
 	// Deallocate operation identifier
 	Deallocate = 1
+	// Check operation identifier, verifying the allocations without updating them
+	Check = 2
 )
+
+// ModeName names the operation of the identifier, e.g. in the traces of the allocation path
+func ModeName(mode int) string {
+	switch mode {
+	case Allocate:
+		return "allocate"
+	case Deallocate:
+		return "deallocate"
+	case Check:
+		return "check"
+	}
+	return strconv.Itoa(mode)
+}
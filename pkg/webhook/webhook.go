@@ -0,0 +1,280 @@
+// Package webhook validates the whereabouts IPAM configuration of the network-attachment-definitions at admission,
+// so that a misconfigured network is rejected when applied rather than failing the creation of its pods.
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	nadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
+
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/config"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/iphelpers"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
+	"github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
+)
+
+// ValidatePath is the path the admission reviews of the network-attachment-definitions are served on
+const ValidatePath = "/validate"
+
+const (
+	whereaboutsIPAMType = "whereabouts"
+	maxReviewBytes      = 3 * 1024 * 1024
+	readHeaderTimeout   = 5 * time.Second
+	listRequestTimeout  = 10 * time.Second
+)
+
+// Validator validates the whereabouts IPAM configurations of network-attachment-definitions, merging them with the
+// flat configuration file as the control loop does
+type Validator struct {
+	flatConfigPaths []string
+	// nadClient, when set, lists the network-attachment-definitions the node slices are checked against
+	nadClient nadclient.Interface
+}
+
+// NewValidator returns a validator looking for the flat configuration file at the given paths, on top of the default
+// ones
+func NewValidator(flatConfigPaths ...string) *Validator {
+	return &Validator{flatConfigPaths: flatConfigPaths}
+}
+
+// NewValidatorWithNetAttachDefs returns a validator which also checks the node slices of a network against those of
+// the other network-attachment-definitions
+func NewValidatorWithNetAttachDefs(nadClient nadclient.Interface, flatConfigPaths ...string) *Validator {
+	validator := NewValidator(flatConfigPaths...)
+	validator.nadClient = nadClient
+	return validator
+}
+
+// Validate returns why the network-attachment-definition cannot be used with whereabouts, if it cannot. Those not
+// using whereabouts are always valid.
+func (v *Validator) Validate(ctx context.Context, nad *nadv1.NetworkAttachmentDefinition) error {
+	if nad.Spec.Config == "" || !usesWhereabouts([]byte(nad.Spec.Config)) {
+		return nil
+	}
+
+	ipamConf, err := config.LoadIPAMConfiguration([]byte(nad.Spec.Config), "", v.flatConfigPaths...)
+	if err != nil {
+		var invalidPluginErr *config.InvalidPluginError
+		if errors.As(err, &invalidPluginErr) {
+			// like the control loop, only the first plugin of a configuration list is considered
+			return nil
+		}
+		return err
+	}
+	if err := validateNodeSlices(ipamConf); err != nil {
+		return err
+	}
+	return v.validateNodeSliceOverlaps(ctx, nad, ipamConf)
+}
+
+// validateNodeSlices makes sure each range of a node slice network, a single one or one per IP family, can be divided
+// in slices of the requested size
+func validateNodeSlices(ipamConf *types.IPAMConfig) error {
+	if ipamConf.NodeSliceSize == "" {
+		return nil
+	}
+	if len(ipamConf.IPRanges) > 2 {
+		return fmt.Errorf("node_slice_size requires a single range, or one per IP family, got %d", len(ipamConf.IPRanges))
+	}
+
+	families := map[bool]struct{}{}
+	for _, ipRange := range ipamConf.IPRanges {
+		ipNet, err := ipRange.Network()
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %s: %s", ipRange.Range, err)
+		}
+		isIPv4 := iphelpers.IsIPv4(ipNet.IP)
+		if _, ok := families[isIPv4]; ok {
+			return fmt.Errorf("node_slice_size requires a single range per IP family, got several for range %s", ipRange.Range)
+		}
+		families[isIPv4] = struct{}{}
+
+		sliceSize := ipamConf.RangeNodeSliceSize(ipRange)
+		slices, err := iphelpers.DivideRangeBySize(ipNet.String(), sliceSize)
+		if err != nil {
+			return fmt.Errorf("invalid node_slice_size %s for range %s: %v", sliceSize, ipRange.Range, err)
+		}
+		if len(slices) == 0 {
+			return fmt.Errorf("invalid node_slice_size %s: expected a prefix length, e.g. /22", sliceSize)
+		}
+	}
+	return nil
+}
+
+// validateNodeSliceOverlaps makes sure the node slices of the network match those of the other network-attachment-
+// definitions of the network, which share its node slice pool, and overlap none of the node slices of the networks of
+// other names, whose addresses are not reserved against each other
+func (v *Validator) validateNodeSliceOverlaps(ctx context.Context, nad *nadv1.NetworkAttachmentDefinition, ipamConf *types.IPAMConfig) error {
+	if v.nadClient == nil || ipamConf.NodeSliceSize == "" {
+		return nil
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, listRequestTimeout)
+	defer cancel()
+	nads, err := v.nadClient.K8sCniCncfIoV1().NetworkAttachmentDefinitions(metav1.NamespaceAll).List(ctxWithTimeout, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list the network-attachment-definitions: %w", err)
+	}
+
+	for _, other := range nads.Items {
+		if other.GetNamespace() == nad.GetNamespace() && other.GetName() == nad.GetName() {
+			continue
+		}
+		if other.Spec.Config == "" || !usesWhereabouts([]byte(other.Spec.Config)) {
+			continue
+		}
+		otherConf, err := config.LoadIPAMConfiguration([]byte(other.Spec.Config), "", v.flatConfigPaths...)
+		if err != nil || otherConf.NodeSliceSize == "" {
+			// the invalid networks have no node slices
+			continue
+		}
+
+		if sliceName(otherConf) == sliceName(ipamConf) {
+			if !sameNodeSlices(ipamConf, otherConf) {
+				return fmt.Errorf("the node slices differ from those of network-attachment-definition %s/%s of the same network %s",
+					other.GetNamespace(), other.GetName(), sliceName(ipamConf))
+			}
+			continue
+		}
+		for _, ipRange := range ipamConf.IPRanges {
+			for _, otherRange := range otherConf.IPRanges {
+				if rangesOverlap(ipRange, otherRange) {
+					return fmt.Errorf("the node slices of range %s overlap those of range %s of network %s, of network-attachment-definition %s/%s",
+						ipRange.Range, otherRange.Range, sliceName(otherConf), other.GetNamespace(), other.GetName())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sliceName returns the name of the node slice pool of the network, as the node slice controller names it
+func sliceName(ipamConf *types.IPAMConfig) string {
+	if ipamConf.NetworkName != "" {
+		return ipamConf.NetworkName
+	}
+	return ipamConf.Name
+}
+
+// sameNodeSlices tells whether both networks divide the same ranges in slices of the same sizes
+func sameNodeSlices(ipamConf, otherConf *types.IPAMConfig) bool {
+	if len(ipamConf.IPRanges) != len(otherConf.IPRanges) {
+		return false
+	}
+	for i, ipRange := range ipamConf.IPRanges {
+		otherRange := otherConf.IPRanges[i]
+		if ipRange.Range != otherRange.Range || ipamConf.RangeNodeSliceSize(ipRange) != otherConf.RangeNodeSliceSize(otherRange) {
+			return false
+		}
+	}
+	return true
+}
+
+func rangesOverlap(ipRange, otherRange types.RangeConfiguration) bool {
+	ipNet, err := ipRange.Network()
+	if err != nil {
+		return false
+	}
+	otherNet, err := otherRange.Network()
+	if err != nil {
+		return false
+	}
+	return ipNet.Contains(otherNet.IP) || otherNet.Contains(ipNet.IP)
+}
+
+// usesWhereabouts tells whether a plugin of the network configuration, or configuration list, delegates to whereabouts
+func usesWhereabouts(netConfig []byte) bool {
+	type ipamType struct {
+		IPAM *struct {
+			Type string `json:"type"`
+		} `json:"ipam,omitempty"`
+	}
+	var netConf struct {
+		ipamType
+		Plugins []ipamType `json:"plugins,omitempty"`
+	}
+	if err := config.UnmarshalNetConf(netConfig, &netConf); err != nil {
+		// not a network configuration whereabouts could be delegated to
+		return false
+	}
+	for _, plugin := range append([]ipamType{netConf.ipamType}, netConf.Plugins...) {
+		if plugin.IPAM != nil && plugin.IPAM.Type == whereaboutsIPAMType {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP answers the admission reviews of the network-attachment-definitions, denying those Validate rejects
+func (v *Validator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "admission reviews must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxReviewBytes))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read the admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, "invalid admission review", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = v.review(r.Context(), review.Request)
+	review.Request = nil
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		_ = logging.Errorf("failed to write the admission response: %v", err)
+	}
+}
+
+func (v *Validator) review(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	response := &admissionv1.AdmissionResponse{UID: request.UID, Allowed: true}
+	if len(request.Object.Raw) == 0 {
+		return response
+	}
+
+	var nad nadv1.NetworkAttachmentDefinition
+	err := json.Unmarshal(request.Object.Raw, &nad)
+	if err == nil {
+		err = v.Validate(ctx, &nad)
+	}
+	if err != nil {
+		logging.Verbosef("denying network-attachment-definition %s/%s: %v", request.Namespace, request.Name, err)
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: fmt.Sprintf("invalid whereabouts IPAM configuration: %v", err),
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+		}
+	}
+	return response
+}
+
+// NewServer returns a server answering the admission reviews under ValidatePath. It must be served over TLS, the API
+// server only calling webhooks over HTTPS.
+func (v *Validator) NewServer(address string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(ValidatePath, v)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+}
@@ -0,0 +1,247 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	fakenadclient "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
+)
+
+const flatConfig = `{
+	"kubernetes": {"kubeconfig": "/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"}
+}`
+
+func newTestValidator(t *testing.T) *Validator {
+	path := filepath.Join(t.TempDir(), "whereabouts.conf")
+	if err := os.WriteFile(path, []byte(flatConfig), 0600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return NewValidator(path)
+}
+
+func newNAD(config string) *nadv1.NetworkAttachmentDefinition {
+	return &nadv1.NetworkAttachmentDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "mynet", Namespace: "default"},
+		Spec:       nadv1.NetworkAttachmentDefinitionSpec{Config: config},
+	}
+}
+
+func whereaboutsConfig(ipam string) string {
+	return `{"cniVersion": "0.3.1", "name": "mynet", "type": "macvlan", "ipam": {"type": "whereabouts", ` + ipam + `}}`
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		expectedErr string
+	}{
+		{
+			name:   "valid range",
+			config: whereaboutsConfig(`"range": "192.168.1.0/24", "exclude": ["192.168.1.0/28"]`),
+		},
+		{
+			name:   "another IPAM",
+			config: `{"cniVersion": "0.3.1", "name": "mynet", "type": "macvlan", "ipam": {"type": "host-local"}}`,
+		},
+		{
+			name:   "valid configuration list",
+			config: `{"cniVersion": "0.3.1", "name": "mynet", "plugins": [{"type": "macvlan", "ipam": {"type": "whereabouts", "range": "10.0.0.0/16"}}]}`,
+		},
+		{
+			name:        "invalid range",
+			config:      whereaboutsConfig(`"range": "192.168.1.0/33"`),
+			expectedErr: "invalid CIDR 192.168.1.0/33",
+		},
+		{
+			name:        "range start outside the CIDR",
+			config:      whereaboutsConfig(`"range": "192.168.1.0/24", "range_start": "192.168.2.10"`),
+			expectedErr: "invalid range start for CIDR 192.168.1.0/24",
+		},
+		{
+			name:        "exclude of the other IP family",
+			config:      whereaboutsConfig(`"ipRanges": [{"range": "192.168.1.0/24", "exclude": ["abcd::/120"]}]`),
+			expectedErr: "invalid exclude abcd::/120",
+		},
+		{
+			name:   "valid node slices",
+			config: whereaboutsConfig(`"range": "10.0.0.0/16", "node_slice_size": "/22"`),
+		},
+		{
+			name:   "valid IPv6 node slices",
+			config: whereaboutsConfig(`"range": "fd00:10::/56", "node_slice_size": "/64"`),
+		},
+		{
+			name:        "node slices larger than the range",
+			config:      whereaboutsConfig(`"range": "10.0.0.0/24", "node_slice_size": "/22"`),
+			expectedErr: "invalid node_slice_size /22 for range 10.0.0.0/24",
+		},
+		{
+			name: "valid dual-stack node slices",
+			config: whereaboutsConfig(`"ipRanges": [{"range": "10.0.0.0/16"}, {"range": "fd00:10::/56", "node_slice_size": "/64"}],
+				"node_slice_size": "/22"`),
+		},
+		{
+			name:        "dual-stack node slices larger than the range",
+			config:      whereaboutsConfig(`"ipRanges": [{"range": "10.0.0.0/16"}, {"range": "fd00:10::/56"}], "node_slice_size": "/22"`),
+			expectedErr: "invalid node_slice_size /22 for range fd00:10::/56",
+		},
+		{
+			name:        "node slices of several ranges of an IP family",
+			config:      whereaboutsConfig(`"ipRanges": [{"range": "10.0.0.0/16"}, {"range": "10.1.0.0/16"}], "node_slice_size": "/22"`),
+			expectedErr: "node_slice_size requires a single range per IP family, got several for range 10.1.0.0/16",
+		},
+	}
+
+	validator := newTestValidator(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validator.Validate(context.TODO(), newNAD(test.config))
+			if test.expectedErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("Expected an error containing %q, got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateNodeSliceOverlaps(t *testing.T) {
+	existing := newNAD(whereaboutsConfig(`"range": "10.0.0.0/16", "node_slice_size": "/22", "network_name": "sliced"`))
+	existing.Namespace = "tenant-a"
+	tests := []struct {
+		name        string
+		config      string
+		expectedErr string
+	}{
+		{
+			name:   "disjoint node slices",
+			config: whereaboutsConfig(`"range": "10.1.0.0/16", "node_slice_size": "/22"`),
+		},
+		{
+			name:   "same node slices of the same network",
+			config: whereaboutsConfig(`"range": "10.0.0.0/16", "node_slice_size": "/22", "network_name": "sliced"`),
+		},
+		{
+			name:   "overlapping range without node slices",
+			config: whereaboutsConfig(`"range": "10.0.0.0/24"`),
+		},
+		{
+			name:        "node slices of another size on the same network",
+			config:      whereaboutsConfig(`"range": "10.0.0.0/16", "node_slice_size": "/24", "network_name": "sliced"`),
+			expectedErr: "the node slices differ from those of network-attachment-definition tenant-a/mynet of the same network sliced",
+		},
+		{
+			name:        "overlapping node slices of another network",
+			config:      whereaboutsConfig(`"range": "10.0.128.0/17", "node_slice_size": "/22"`),
+			expectedErr: "the node slices of range 10.0.128.0/17 overlap those of range 10.0.0.0/16 of network sliced, of network-attachment-definition tenant-a/mynet",
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "whereabouts.conf")
+	if err := os.WriteFile(path, []byte(flatConfig), 0600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	nadClient := fakenadclient.NewSimpleClientset()
+	if _, err := nadClient.K8sCniCncfIoV1().NetworkAttachmentDefinitions(existing.Namespace).Create(context.TODO(), existing, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	validator := NewValidatorWithNetAttachDefs(nadClient, path)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validator.Validate(context.TODO(), newNAD(test.config))
+			if test.expectedErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.expectedErr {
+				t.Errorf("Expected the error %q, got %v", test.expectedErr, err)
+			}
+		})
+	}
+
+	// updating the network-attachment-definition itself
+	if err := validator.Validate(context.TODO(), existing); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	tests := []struct {
+		name            string
+		config          string
+		expectedAllowed bool
+	}{
+		{name: "allowed", config: whereaboutsConfig(`"range": "192.168.1.0/24"`), expectedAllowed: true},
+		{name: "denied", config: whereaboutsConfig(`"range": "192.168.1.0/24", "range_end": "192.168.2.10"`)},
+	}
+
+	validator := newTestValidator(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			object, err := json.Marshal(newNAD(test.config))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			review, err := json.Marshal(admissionv1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+				Request: &admissionv1.AdmissionRequest{
+					UID:       "1234",
+					Name:      "mynet",
+					Namespace: "default",
+					Operation: admissionv1.Create,
+					Object:    runtime.RawExtension{Raw: object},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			recorder := httptest.NewRecorder()
+			validator.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, ValidatePath, bytes.NewReader(review)))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+			}
+
+			var response admissionv1.AdmissionReview
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if response.Response == nil || response.Response.UID != "1234" {
+				t.Fatalf("Expected the response to the request 1234, got %+v", response.Response)
+			}
+			if response.Response.Allowed != test.expectedAllowed {
+				t.Errorf("Expected allowed %t, got %t: %+v", test.expectedAllowed, response.Response.Allowed, response.Response.Result)
+			}
+			if response.Kind != "AdmissionReview" {
+				t.Errorf("Expected kind AdmissionReview, got %q", response.Kind)
+			}
+		})
+	}
+}
+
+func TestServeHTTPRejectsInvalidReviews(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	newTestValidator(t).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, ValidatePath, strings.NewReader("{}")))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
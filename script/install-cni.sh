@@ -14,6 +14,9 @@ CNI_BIN_DIR=${CNI_BIN_DIR:-"/host/opt/cni/bin/"}
 WHEREABOUTS_KUBECONFIG_FILE_HOST=${WHEREABOUTS_KUBECONFIG_FILE_HOST:-"/etc/cni/net.d/whereabouts.d/whereabouts.kubeconfig"}
 CNI_CONF_DIR=${CNI_CONF_DIR:-"/host/etc/cni/net.d"}
 WHEREABOUTS_RECONCILER_CRON=${WHEREABOUTS_RECONCILER_CRON:-30 4 * * *}
+# The service account token projected for the pod of the node, used instead of the token of the shared service account
+# when present
+WHEREABOUTS_PROJECTED_TOKEN=${WHEREABOUTS_PROJECTED_TOKEN:-"/var/run/secrets/whereabouts/token"}
 
 # Make a whereabouts.d directory (for our kubeconfig)
 
@@ -21,6 +24,8 @@ mkdir -p $CNI_CONF_DIR/whereabouts.d
 WHEREABOUTS_KUBECONFIG=$CNI_CONF_DIR/whereabouts.d/whereabouts.kubeconfig
 WHEREABOUTS_CONF_FILE=$CNI_CONF_DIR/whereabouts.d/whereabouts.conf 
 WHEREABOUTS_KUBECONFIG_LITERAL=$(echo "$WHEREABOUTS_KUBECONFIG" | sed -e s'|/host||')
+WHEREABOUTS_TOKEN_FILE=$CNI_CONF_DIR/whereabouts.d/token
+WHEREABOUTS_TOKEN_FILE_LITERAL=$(echo "$WHEREABOUTS_TOKEN_FILE" | sed -e s'|/host||')
 
 # ------------------------------- Generate a "kube-config"
 SERVICE_ACCOUNT_PATH=/var/run/secrets/kubernetes.io/serviceaccount
@@ -31,6 +36,7 @@ SKIP_TLS_VERIFY=${SKIP_TLS_VERIFY:-false}
 
 LAST_SERVICEACCOUNT_MD5SUM=""
 LAST_KUBE_CA_FILE_MD5SUM=""
+LAST_PROJECTED_TOKEN_MD5SUM=""
 
 # Setup our logging routines
 
@@ -73,6 +79,15 @@ if [ -f "$SERVICE_ACCOUNT_PATH/token" ]; then
     KUBERNETES_SERVICE_HOST_WRAP=\[$KUBERNETES_SERVICE_HOST_WRAP\]
   fi
 
+  # The plugin authenticates with the projected token the whereabouts configuration points to, rather than with a
+  # token written in the kubeconfig
+  if [ -f "$WHEREABOUTS_PROJECTED_TOKEN" ]; then
+    USER_CFG="{}"
+  else
+    USER_CFG="
+    token: \"${SERVICE_ACCOUNT_TOKEN}\""
+  fi
+
   # Write a kubeconfig file for the CNI plugin.  Do this
   # to skip TLS verification for now.  We should eventually support
   # writing more complete kubeconfig files. This is only used
@@ -90,8 +105,7 @@ clusters:
     $TLS_CFG
 users:
 - name: whereabouts
-  user:
-    token: "${SERVICE_ACCOUNT_TOKEN}"
+  user: $USER_CFG
 contexts:
 - name: whereabouts-context
   context:
@@ -111,6 +125,27 @@ generateKubeConfig
 
 # ------------------ end Generate a "kube-config"
 
+# ----------------- Copy the projected service account token
+
+function copyProjectedToken {
+  # Written aside then renamed, so that the plugin never reads a partial token
+  cp -f $WHEREABOUTS_PROJECTED_TOKEN $WHEREABOUTS_TOKEN_FILE.tmp
+  chmod ${KUBECONFIG_MODE:-600} $WHEREABOUTS_TOKEN_FILE.tmp
+  mv -f $WHEREABOUTS_TOKEN_FILE.tmp $WHEREABOUTS_TOKEN_FILE
+  LAST_PROJECTED_TOKEN_MD5SUM=$(md5sum $WHEREABOUTS_PROJECTED_TOKEN | awk '{print $1}')
+}
+
+TOKEN_FILE_CFG=""
+if [ -f "$WHEREABOUTS_PROJECTED_TOKEN" ]; then
+  copyProjectedToken
+  TOKEN_FILE_CFG=",
+    \"token_file\": \"${WHEREABOUTS_TOKEN_FILE_LITERAL}\""
+else
+  rm -f $WHEREABOUTS_TOKEN_FILE
+fi
+
+# ---------------- End copy the projected service account token
+
 # ----------------- Generate a whereabouts conf
 
 function generateWhereaboutsConf {
@@ -121,7 +156,7 @@ function generateWhereaboutsConf {
 {
   "datastore": "kubernetes",
   "kubernetes": {
-    "kubeconfig": "${WHEREABOUTS_KUBECONFIG_LITERAL}"
+    "kubeconfig": "${WHEREABOUTS_KUBECONFIG_LITERAL}"${TOKEN_FILE_CFG}
   },
   "reconciler_cron_expression": "${WHEREABOUTS_RECONCILER_CRON}"
 }
@@ -150,6 +185,13 @@ while true; do
       # log "Detected service account or CA file change, regenerating kubeconfig..."
       generateKubeConfig
     fi
+    # The kubelet refreshes the projected token before it expires; the plugin reads the copy anew
+    if [ -f "$WHEREABOUTS_PROJECTED_TOKEN" ]; then
+      tokensum=$(md5sum $WHEREABOUTS_PROJECTED_TOKEN | awk '{print $1}')
+      if [ "$tokensum" != "$LAST_PROJECTED_TOKEN_MD5SUM" ]; then
+        copyProjectedToken
+      fi
+    fi
 
     sleep 1
   done